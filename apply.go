@@ -0,0 +1,231 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// filePatch is the change a unified diff makes to one file.
+type filePatch struct {
+	OldFile string // Path of the file before the change, or empty if the file is created.
+	NewFile string // Path of the file after the change, or empty if the file is deleted.
+	Hunks   []diffHunk
+}
+
+var hunkHeaderPattern = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// parsePatchPath returns the path named on a ---/+++ line of a unified diff, without its
+// a/ or b/ prefix, or empty for /dev/null.
+func parsePatchPath(line string) string {
+	path, _, _ := strings.Cut(line[len("--- "):], "\t")
+	if path == "/dev/null" {
+		return ""
+	}
+	if strings.HasPrefix(path, "a/") || strings.HasPrefix(path, "b/") {
+		return path[2:]
+	}
+	return path
+}
+
+// parsePatch parses the file changes in a unified diff. Lines outside of file changes,
+// such as commit metadata and diff summaries, are ignored.
+func parsePatch(text string) ([]filePatch, error) {
+	lines := splitLines([]byte(text))
+	var patches []filePatch
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		if strings.HasPrefix(line, "Binary files ") {
+			return nil, fmt.Errorf("parsePatch: binary changes cannot be applied")
+		}
+		if !strings.HasPrefix(line, "--- ") || i+1 >= len(lines) || !strings.HasPrefix(lines[i+1], "+++ ") {
+			continue
+		}
+		p := filePatch{OldFile: parsePatchPath(line), NewFile: parsePatchPath(lines[i+1])}
+		i += 2
+		for i < len(lines) {
+			m := hunkHeaderPattern.FindStringSubmatch(lines[i])
+			if m == nil {
+				break
+			}
+			hunk := diffHunk{OldCount: 1, NewCount: 1}
+			hunk.OldStart, _ = strconv.Atoi(m[1])
+			if m[2] != "" {
+				hunk.OldCount, _ = strconv.Atoi(m[2])
+			}
+			hunk.NewStart, _ = strconv.Atoi(m[3])
+			if m[4] != "" {
+				hunk.NewCount, _ = strconv.Atoi(m[4])
+			}
+			i++
+			oldSeen, newSeen := 0, 0
+			for ; i < len(lines) && (oldSeen < hunk.OldCount || newSeen < hunk.NewCount); i++ {
+				line := lines[i]
+				if strings.HasPrefix(line, `\`) {
+					continue // "\ No newline at end of file"
+				}
+				if line == "" {
+					line = " " // some tools strip the space of empty context lines
+				}
+				switch op := line[0]; op {
+				case ' ', '-', '+':
+					hunk.Lines = append(hunk.Lines, diffLine{Op: op, Text: line[1:]})
+					if op != '+' {
+						oldSeen++
+					}
+					if op != '-' {
+						newSeen++
+					}
+				default:
+					return nil, fmt.Errorf("parsePatch: unexpected line in hunk: %q", line)
+				}
+			}
+			if oldSeen != hunk.OldCount || newSeen != hunk.NewCount {
+				return nil, fmt.Errorf("parsePatch: truncated hunk for %v", p.NewFile)
+			}
+			p.Hunks = append(p.Hunks, hunk)
+		}
+		i--
+		if p.OldFile == "" && p.NewFile == "" {
+			return nil, fmt.Errorf("parsePatch: change without a file")
+		}
+		patches = append(patches, p)
+	}
+	return patches, nil
+}
+
+// hunkMatches reports whether the old side of a hunk matches lines starting at index start.
+func hunkMatches(lines []string, oldLines []string, start int) bool {
+	return start >= 0 && start+len(oldLines) <= len(lines) && slices.Equal(lines[start:start+len(oldLines)], oldLines)
+}
+
+// applyHunks applies hunks to the lines of a file. Each hunk is applied where its context and
+// deleted lines match, at its recorded position or, if the file has shifted, at the nearest
+// position after the previous hunk. Returns the line number of the first hunk that does not
+// match, with ok false.
+func applyHunks(lines []string, hunks []diffHunk) (result []string, failedLine int, ok bool) {
+	offset, next := 0, 0
+	for _, hunk := range hunks {
+		var oldLines, newLines []string
+		for _, line := range hunk.Lines {
+			if line.Op != '+' {
+				oldLines = append(oldLines, line.Text)
+			}
+			if line.Op != '-' {
+				newLines = append(newLines, line.Text)
+			}
+		}
+		// an empty old side starts after the line it names
+		base := hunk.OldStart - 1
+		if hunk.OldCount == 0 {
+			base++
+		}
+		expected, start := base+offset, -1
+		for distance := 0; start < 0 && distance <= len(lines); distance++ {
+			if pos := expected - distance; pos >= next && hunkMatches(lines, oldLines, pos) {
+				start = pos
+			} else if pos := expected + distance; pos >= next && hunkMatches(lines, oldLines, pos) {
+				start = pos
+			}
+		}
+		if start < 0 {
+			return nil, hunk.OldStart, false
+		}
+		lines = slices.Concat(lines[:start], newLines, lines[start+len(oldLines):])
+		offset = start - base + len(newLines) - len(oldLines)
+		next = start + len(newLines)
+	}
+	return lines, 0, true
+}
+
+// applyPatch applies the changes in a patch file to the working directory. With stage, the
+// changed files are also staged. Every change is checked before any file is written, so a
+// patch that does not apply leaves the working directory unchanged.
+func applyPatch(patchFile string, stage bool) error {
+	unlock, err := lockRepo("apply")
+	if err != nil {
+		return fmt.Errorf("applyPatch: %w", err)
+	}
+	defer unlock()
+	text, err := readContentsAsString(patchFile)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			exit("Patch file does not exist.")
+		}
+		return fmt.Errorf("applyPatch: %w", err)
+	}
+	patches, err := parsePatch(text)
+	if err != nil {
+		exitf("Corrupt patch: %v.", err)
+	}
+	if len(patches) == 0 {
+		exit("No changes found in the patch.")
+	}
+
+	for i := range patches {
+		for _, file := range []*string{&patches[i].OldFile, &patches[i].NewFile} {
+			if *file == "" {
+				continue
+			}
+			if *file, err = normalizePath(*file); err != nil {
+				return fmt.Errorf("applyPatch: %w", err)
+			}
+		}
+	}
+	results := make([][]string, len(patches))
+	for i, p := range patches {
+		var lines []string
+		if p.OldFile != "" {
+			contents, err := readContents(p.OldFile)
+			if errors.Is(err, fs.ErrNotExist) {
+				exitf("Cannot apply patch: %v does not exist.", p.OldFile)
+			} else if err != nil {
+				return fmt.Errorf("applyPatch: %w", err)
+			}
+			lines = splitLines(contents)
+		} else if _, err := readContents(p.NewFile); err == nil {
+			exitf("Cannot apply patch: %v already exists.", p.NewFile)
+		}
+		result, failedLine, ok := applyHunks(lines, p.Hunks)
+		if !ok {
+			exitf("Patch does not apply to %v at line %v.", p.OldFile, failedLine)
+		}
+		if p.NewFile == "" && len(result) > 0 {
+			exitf("Cannot apply patch: %v does not match the deleted version.", p.OldFile)
+		}
+		results[i] = result
+	}
+
+	for i, p := range patches {
+		if p.OldFile != "" && p.OldFile != p.NewFile {
+			if err := restrictedDelete(p.OldFile); err != nil {
+				return fmt.Errorf("applyPatch: %w", err)
+			}
+		}
+		if p.NewFile != "" {
+			contents := strings.Join(results[i], "\n")
+			if len(results[i]) > 0 {
+				contents += "\n"
+			}
+			if err := writeContents(p.NewFile, []string{contents}); err != nil {
+				return fmt.Errorf("applyPatch: %w", err)
+			}
+		}
+		if !stage {
+			continue
+		}
+		for _, file := range []string{p.OldFile, p.NewFile} {
+			if file == "" {
+				continue
+			}
+			if err := stageFile(file); err != nil {
+				return fmt.Errorf("applyPatch: %w", err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"maps"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestApplyHunks(t *testing.T) {
+	hunks := []diffHunk{{
+		OldStart: 2, OldCount: 3, NewStart: 2, NewCount: 3,
+		Lines: []diffLine{{Op: ' ', Text: "b"}, {Op: '-', Text: "c"}, {Op: '+', Text: "C"}, {Op: ' ', Text: "d"}},
+	}}
+	// the hunk is found even though a line was inserted above it
+	result, _, ok := applyHunks([]string{"new", "a", "b", "c", "d"}, hunks)
+	if expected := []string{"new", "a", "b", "C", "d"}; !ok || !slices.Equal(result, expected) {
+		t.Errorf("Expected %v, got %v, %v", expected, result, ok)
+	}
+	// the deleted line no longer matches
+	if _, failedLine, ok := applyHunks([]string{"a", "b", "x", "d"}, hunks); ok || failedLine != 2 {
+		t.Errorf("Expected the hunk at line 2 to fail, got %v, %v", failedLine, ok)
+	}
+}
+
+func TestApplyPatch(t *testing.T) {
+	setupTestRepo(t)
+	for _, file := range []string{"changed.txt", "deleted.txt"} {
+		if err := writeContents(file, []string{"one\n", "two\n", "three\n"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := stageFile(file); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := newCommit("add files"); err != nil {
+		t.Fatal(err)
+	}
+	if err := addBranch("old"); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeContents("changed.txt", []string{"one\n", "2\n", "three\n", "four\n"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeContents("added.txt", []string{"wug\n"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repoFS.Remove("deleted.txt"); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{"changed.txt", "added.txt", "deleted.txt"} {
+		if err := stageFile(file); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := newCommit("change files"); err != nil {
+		t.Fatal(err)
+	}
+	headCommit, err := getHeadCommit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	patchDir := testTempDir(t)
+	if err := writePatches("old", patchDir); err != nil {
+		t.Fatal(err)
+	}
+	patchFile := filepath.Join(patchDir, patchFileName(1, "change files"))
+
+	if err := checkoutBranch("old"); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyPatch(patchFile, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repoFS.Stat("deleted.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("deleted.txt should be removed: %v", err)
+	}
+	if err := newCommit("apply patch"); err != nil {
+		t.Fatal(err)
+	}
+	appliedCommit, err := getHeadCommit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !maps.Equal(appliedCommit.FileToBlob, headCommit.FileToBlob) {
+		t.Errorf("Applying the patch should reproduce %v, got %v", headCommit.FileToBlob, appliedCommit.FileToBlob)
+	}
+}
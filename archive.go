@@ -0,0 +1,144 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+)
+
+// Formats of archives written by archive.
+const (
+	archiveTar   string = "tar"
+	archiveTarGz string = "tar.gz"
+	archiveZip   string = "zip"
+)
+
+// archiveFormatFromName returns the archive format matching the extension of a file name,
+// defaulting to tar.
+func archiveFormatFromName(name string) string {
+	switch {
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return archiveTarGz
+	case strings.HasSuffix(name, ".zip"):
+		return archiveZip
+	default:
+		return archiveTar
+	}
+}
+
+// writeArchive writes every file tracked by a commit to w as an archive of the given format.
+// Files are read from their blobs one at a time, and are given the commit's time.
+func writeArchive(w io.Writer, c commit, format string) error {
+	modTime := time.Unix(c.Timestamp, 0)
+	var addFile func(name string, contents []byte) error
+	var closeArchive func() error
+	switch format {
+	case archiveTar, archiveTarGz:
+		var gw *gzip.Writer
+		if format == archiveTarGz {
+			gw = gzip.NewWriter(w)
+			w = gw
+		}
+		tw := tar.NewWriter(w)
+		addFile = func(name string, contents []byte) error {
+			header := &tar.Header{
+				Typeflag: tar.TypeReg,
+				Name:     name,
+				Mode:     0644,
+				Size:     int64(len(contents)),
+				ModTime:  modTime,
+			}
+			if err := tw.WriteHeader(header); err != nil {
+				return err
+			}
+			_, err := tw.Write(contents)
+			return err
+		}
+		closeArchive = func() error {
+			if err := tw.Close(); err != nil {
+				return err
+			}
+			if gw != nil {
+				return gw.Close()
+			}
+			return nil
+		}
+	case archiveZip:
+		zw := zip.NewWriter(w)
+		addFile = func(name string, contents []byte) error {
+			header := &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modTime}
+			header.SetMode(0644)
+			fw, err := zw.CreateHeader(header)
+			if err != nil {
+				return err
+			}
+			_, err = fw.Write(contents)
+			return err
+		}
+		closeArchive = zw.Close
+	default:
+		return fmt.Errorf("writeArchive: unknown archive format '%v'", format)
+	}
+
+	for _, file := range sortedKeys(c.FileToBlob) {
+		_, contents, err := readBlob(c.FileToBlob[file])
+		if err != nil {
+			return fmt.Errorf("writeArchive: %w", err)
+		}
+		if err := addFile(file, contents); err != nil {
+			return fmt.Errorf("writeArchive: %w", err)
+		}
+	}
+	if err := closeArchive(); err != nil {
+		return fmt.Errorf("writeArchive: %w", err)
+	}
+	return nil
+}
+
+// archiveCommit exports the files of the commit named by a revision as an archive, without
+// touching the working directory. The archive is written to the output file, or to standard
+// output if it is empty. An empty format is chosen from the output file's extension.
+func archiveCommit(rev string, output string, format string) error {
+	commitHash, err := resolveRevision(rev)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			exit("No commit with that id exists.")
+		}
+		return fmt.Errorf("archiveCommit: %w", err)
+	}
+	c, err := getCommit(commitHash)
+	if err != nil {
+		return fmt.Errorf("archiveCommit: %w", err)
+	}
+	if format == "" {
+		format = archiveFormatFromName(output)
+	}
+	if format != archiveTar && format != archiveTarGz && format != archiveZip {
+		exit("Unknown archive format; use tar, tar.gz, or zip.")
+	}
+	if output == "" {
+		if err := writeArchive(os.Stdout, c, format); err != nil {
+			return fmt.Errorf("archiveCommit: %w", err)
+		}
+		return nil
+	}
+	f, err := repoFS.OpenFile(output, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return fmt.Errorf("archiveCommit: %w", err)
+	}
+	if err := writeArchive(f, c, format); err != nil {
+		f.Close()
+		return fmt.Errorf("archiveCommit: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("archiveCommit: %w", err)
+	}
+	return nil
+}
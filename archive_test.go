@@ -0,0 +1,96 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"testing"
+)
+
+func TestArchiveFormatFromName(t *testing.T) {
+	for name, want := range map[string]string{
+		"out.tar":    archiveTar,
+		"out.tar.gz": archiveTarGz,
+		"out.tgz":    archiveTarGz,
+		"out.zip":    archiveZip,
+		"":           archiveTar,
+	} {
+		if got := archiveFormatFromName(name); got != want {
+			t.Errorf("archiveFormatFromName(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestWriteArchive(t *testing.T) {
+	setupTestRepo(t)
+	for _, file := range []string{"a.txt", "b.txt"} {
+		if err := writeContents(file, []string{"This is " + file}); err != nil {
+			t.Fatal(err)
+		}
+		if err := stageFile(file); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := newCommit("add files"); err != nil {
+		t.Fatal(err)
+	}
+	c, err := getHeadCommit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"a.txt": "This is a.txt", "b.txt": "This is b.txt"}
+
+	var tgz bytes.Buffer
+	if err := writeArchive(&tgz, c, archiveTarGz); err != nil {
+		t.Fatal(err)
+	}
+	gr, err := gzip.NewReader(&tgz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]string)
+	tr := tar.NewReader(gr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got[header.Name] = string(b)
+	}
+	if len(got) != len(want) || got["a.txt"] != want["a.txt"] || got["b.txt"] != want["b.txt"] {
+		t.Errorf("Incorrect tar.gz archive: want %v, got %v", want, got)
+	}
+
+	var z bytes.Buffer
+	if err := writeArchive(&z, c, archiveZip); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(z.Bytes()), int64(z.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got[f.Name] = string(b)
+	}
+	if len(got) != len(want) || got["a.txt"] != want["a.txt"] || got["b.txt"] != want["b.txt"] {
+		t.Errorf("Incorrect zip archive: want %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
+)
+
+var (
+	autosaveRefFile = filepath.Join(refsDir, "autosave")
+	autosavePIDFile = filepath.Join(gitletDir, "autosave.pid")
+	autosaveLogFile = filepath.Join(gitletDir, "autosave.log")
+)
+
+// autosave snapshots the working directory to the hidden autosave ref, unless it is
+// unchanged since the last snapshot. Snapshots are chained through their second parent.
+// Returns the hash of the new snapshot, or an empty hash if nothing changed.
+func autosave() (string, error) {
+	unlock, err := lockRepo("autosave")
+	if err != nil {
+		return "", fmt.Errorf("autosave: %w", err)
+	}
+	defer unlock()
+
+	lastHash, err := readRef(autosaveRefFile)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return "", fmt.Errorf("autosave: %w", err)
+	}
+	snapshot, err := snapshotWorkingTree("autosave", lastHash)
+	if err != nil {
+		return "", fmt.Errorf("autosave: %w", err)
+	}
+	if lastHash != "" {
+		last, err := getCommit(lastHash)
+		if err != nil {
+			return "", fmt.Errorf("autosave: %w", err)
+		}
+		if last.ParentUIDs[0] == snapshot.ParentUIDs[0] && sameSnapshotFiles(last, snapshot) {
+			return "", nil
+		}
+	}
+	hash, err := writeCommitBlob(snapshot)
+	if err != nil {
+		return "", fmt.Errorf("autosave: %w", err)
+	}
+	if err := writeRef(autosaveRefFile, hash, "autosave"); err != nil {
+		return "", fmt.Errorf("autosave: %w", err)
+	}
+	return hash, nil
+}
+
+// getAutosaves returns the hashes of every autosave snapshot, newest first.
+func getAutosaves() ([]string, error) {
+	hash, err := readRef(autosaveRefFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("getAutosaves: %w", err)
+	}
+	var hashes []string
+	for hash != "" {
+		hashes = append(hashes, hash)
+		c, err := getCommit(hash)
+		if err != nil {
+			return nil, fmt.Errorf("getAutosaves: %w", err)
+		}
+		hash = c.ParentUIDs[1]
+	}
+	return hashes, nil
+}
+
+// printAutosaves prints every autosave snapshot, newest first.
+func printAutosaves() error {
+	hashes, err := getAutosaves()
+	if err != nil {
+		return fmt.Errorf("printAutosaves: %w", err)
+	}
+	if len(hashes) == 0 {
+		log.Println("No autosaves found.")
+		return nil
+	}
+	for _, hash := range hashes {
+		c, err := getCommit(hash)
+		if err != nil {
+			return fmt.Errorf("printAutosaves: %w", err)
+		}
+		log.Printf(
+			"%v %v %v files (on %v)\n",
+			hash[:6], time.Unix(c.Timestamp, 0).Local().Format(time.DateTime),
+			len(c.FileToBlob), c.ParentUIDs[0][:6],
+		)
+	}
+	return nil
+}
+
+// restoreAutosave restores the files of an autosave snapshot into the working directory.
+func restoreAutosave(rev string) error {
+	hashes, err := getAutosaves()
+	if err != nil {
+		return fmt.Errorf("restoreAutosave: %w", err)
+	}
+	hash := rev
+	if len(hash) < hashLength {
+		if resolved, err := resolveHash(hash); err == nil {
+			hash = resolved
+		}
+	}
+	if !slices.Contains(hashes, hash) {
+		exit("No autosave with that id exists.")
+	}
+	if err := restoreSnapshot(hash); err != nil {
+		return fmt.Errorf("restoreAutosave: %w", err)
+	}
+	log.Printf("Restored autosave (%v).\n", hash[:6])
+	return nil
+}
+
+// runAutosave snapshots the working directory every interval until the process is stopped.
+// A snapshot is skipped, not retried early, if another command holds the repository lock.
+func runAutosave(interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		hash, err := autosave()
+		var lockedErr *repoLockedError
+		if errors.As(err, &lockedErr) {
+			log.Printf("Skipped autosave: %v\n", lockedErr)
+			continue
+		} else if err != nil {
+			return fmt.Errorf("runAutosave: %w", err)
+		}
+		if hash != "" {
+			log.Printf("Autosaved (%v) at %v.\n", hash[:6], time.Now().Format(time.DateTime))
+		}
+	}
+	return nil
+}
+
+// readAutosavePID returns the PID of the running autosave daemon, or 0 if none is running.
+func readAutosavePID() (int, error) {
+	contents, err := readContentsAsString(autosavePIDFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("readAutosavePID: %w", err)
+	}
+	pid, err := strconv.Atoi(contents)
+	if err != nil || !processExists(pid) {
+		return 0, nil
+	}
+	return pid, nil
+}
+
+// startAutosave starts a background process that autosaves every interval,
+// logging to the autosave log file.
+func startAutosave(interval time.Duration) error {
+	if pid, err := readAutosavePID(); err != nil {
+		return fmt.Errorf("startAutosave: %w", err)
+	} else if pid != 0 {
+		exitf("Autosave is already running (pid %v).", pid)
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("startAutosave: %w", err)
+	}
+	f, err := repoFS.OpenFile(autosaveLogFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("startAutosave: %w", err)
+	}
+	defer f.Close()
+	// the background process opens the repository itself, so it must be on disk
+	logFile, ok := f.(*os.File)
+	if !ok {
+		return errors.New("startAutosave: the repository is not kept on disk")
+	}
+	dir, err := repoFS.Abs(".")
+	if err != nil {
+		return fmt.Errorf("startAutosave: %w", err)
+	}
+
+	cmd := exec.Command(executable, "autosave", "run", "-interval", interval.String())
+	cmd.Dir = dir
+	cmd.Stdout, cmd.Stderr = logFile, logFile
+	detachProcess(cmd)
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("startAutosave: %w", err)
+	}
+	pid := cmd.Process.Pid
+	if err := cmd.Process.Release(); err != nil {
+		return fmt.Errorf("startAutosave: %w", err)
+	}
+	if err := writeFileAtomic(autosavePIDFile, []byte(strconv.Itoa(pid))); err != nil {
+		return fmt.Errorf("startAutosave: %w", err)
+	}
+	log.Printf("Autosave started (pid %v), saving every %v.\n", pid, interval)
+	return nil
+}
+
+// stopAutosave stops the background autosave process.
+func stopAutosave() error {
+	pid, err := readAutosavePID()
+	if err != nil {
+		return fmt.Errorf("stopAutosave: %w", err)
+	}
+	if pid == 0 {
+		exit("Autosave is not running.")
+	}
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return fmt.Errorf("stopAutosave: %w", err)
+	}
+	if err := process.Kill(); err != nil {
+		return fmt.Errorf("stopAutosave: %w", err)
+	}
+	if err := repoFS.Remove(autosavePIDFile); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("stopAutosave: %w", err)
+	}
+	log.Printf("Autosave stopped (pid %v).\n", pid)
+	return nil
+}
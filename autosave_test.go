@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestAutosave(t *testing.T) {
+	setupTestRepo(t)
+	if err := writeContents("wug.txt", []string{"This is a wug"}); err != nil {
+		t.Fatal(err)
+	}
+	first, err := autosave()
+	if err != nil || first == "" {
+		t.Fatalf("Could not autosave: %v, %v", first, err)
+	}
+	if hash, err := autosave(); err != nil || hash != "" {
+		t.Fatalf("Unchanged working directory was autosaved: %v, %v", hash, err)
+	}
+	if err := writeContents("wug.txt", []string{"This is a changed wug"}); err != nil {
+		t.Fatal(err)
+	}
+	second, err := autosave()
+	if err != nil || second == "" {
+		t.Fatalf("Could not autosave: %v, %v", second, err)
+	}
+	if hashes, err := getAutosaves(); err != nil || len(hashes) != 2 || hashes[0] != second || hashes[1] != first {
+		t.Fatalf("Incorrect autosaves: want [%v %v], got %v, %v", second, first, hashes, err)
+	}
+
+	// autosaves do not change the index or history, and are not unreachable
+	if index, err := readIndex(); err != nil || len(index) != 0 {
+		t.Fatalf("Autosave changed the index: %v, %v", index, err)
+	}
+	if hash, err := getHeadCommitHash(); err != nil || hash != initialCommitHash {
+		t.Fatalf("Autosave changed the head commit: %v, %v", hash, err)
+	}
+	if tips, err := findUnreachableTips(); err != nil || len(tips) != 0 {
+		t.Fatalf("Autosaves are unreachable: %v, %v", tips, err)
+	}
+
+	if err := restrictedDelete("wug.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := restoreAutosave(first[:8]); err != nil {
+		t.Fatal(err)
+	}
+	if contents, err := readContentsAsString("wug.txt"); err != nil || contents != "This is a wug" {
+		t.Fatalf("Incorrect restored file: %v, %v", contents, err)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"text/tabwriter"
+	"time"
+)
+
+// benchOptions configures the synthetic repository generated by runBenchmark.
+type benchOptions struct {
+	Files    int   // Number of files in the repository.
+	FileSize int   // Size of each file in bytes.
+	Depth    int   // Number of commits in the history before merging.
+	Seed     int64 // Seed for the generated file contents.
+}
+
+// benchResult is the timing of one benchmarked operation.
+type benchResult struct {
+	Operation string
+	Count     int // Number of times the operation ran.
+	Elapsed   time.Duration
+}
+
+// runBenchmark generates a synthetic repository in a temporary directory and times
+// add, commit, status, log, and merge against it. The working directory is restored
+// and the temporary repository removed before returning.
+func runBenchmark(opts benchOptions) ([]benchResult, error) {
+	if opts.Files < 2 || opts.FileSize < 1 || opts.Depth < 1 {
+		return nil, errors.New("runBenchmark: need at least 2 files, 1 byte per file, and 1 commit")
+	}
+	dir, err := repoFS.MkdirTemp("", "gitlet-bench-")
+	if err != nil {
+		return nil, fmt.Errorf("runBenchmark: %w", err)
+	}
+	defer repoFS.RemoveAll(dir)
+	benchFS, err := repoFS.Sub(dir)
+	if err != nil {
+		return nil, fmt.Errorf("runBenchmark: %w", err)
+	}
+	wdFS := repoFS
+	repoFS = benchFS
+	defer func() { repoFS = wdFS }()
+
+	// commands print progress messages that would drown out the report
+	logWriter := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(logWriter)
+
+	rng := rand.New(rand.NewSource(opts.Seed))
+	files := make([]string, opts.Files)
+	for i := range files {
+		files[i] = fmt.Sprintf("file%06d.txt", i)
+	}
+	writeFiles := func(files []string) error {
+		contents := make([]byte, opts.FileSize)
+		for _, file := range files {
+			rng.Read(contents)
+			if err := writeContents(file, [][]byte{contents}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var results []benchResult
+	// measure times an operation as if it were a fresh command, without warm caches
+	measure := func(operation string, count int, f func() error) error {
+		resetObjectCache()
+		if err := closePacks(); err != nil {
+			return err
+		}
+		start := time.Now()
+		if err := f(); err != nil {
+			return fmt.Errorf("%v: %w", operation, err)
+		}
+		results = append(results, benchResult{operation, count, time.Since(start)})
+		return nil
+	}
+
+	if err := newRepository(); err != nil {
+		return nil, fmt.Errorf("runBenchmark: %w", err)
+	}
+	if err := writeFiles(files); err != nil {
+		return nil, fmt.Errorf("runBenchmark: %w", err)
+	}
+	if err := measure("add", len(files), func() error { return stageFiles(files) }); err != nil {
+		return nil, fmt.Errorf("runBenchmark: %w", err)
+	}
+	if err := measure("commit", 1, func() error { return newCommit("add files") }); err != nil {
+		return nil, fmt.Errorf("runBenchmark: %w", err)
+	}
+
+	// each later commit modifies a tenth of the files
+	changed := max(len(files)/10, 1)
+	for i := 1; i < opts.Depth; i++ {
+		start := rng.Intn(len(files) - changed + 1)
+		if err := writeFiles(files[start : start+changed]); err != nil {
+			return nil, fmt.Errorf("runBenchmark: %w", err)
+		}
+		if err := stageFiles(files[start : start+changed]); err != nil {
+			return nil, fmt.Errorf("runBenchmark: %w", err)
+		}
+		if err := newCommit(fmt.Sprintf("modify files %d", i)); err != nil {
+			return nil, fmt.Errorf("runBenchmark: %w", err)
+		}
+	}
+
+	if err := writeFiles(files[:changed]); err != nil {
+		return nil, fmt.Errorf("runBenchmark: %w", err)
+	}
+	if err := measure("status", 1, printStatus); err != nil {
+		return nil, fmt.Errorf("runBenchmark: %w", err)
+	}
+	if err := measure("log", opts.Depth+1, func() error { return printBranchLog(logOptions{}) }); err != nil {
+		return nil, fmt.Errorf("runBenchmark: %w", err)
+	}
+
+	// diverge two branches on disjoint halves of the files and merge them
+	half := len(files) / 2
+	if err := checkoutHeadFiles(files[:changed]); err != nil {
+		return nil, fmt.Errorf("runBenchmark: %w", err)
+	}
+	if err := addBranch("bench"); err != nil {
+		return nil, fmt.Errorf("runBenchmark: %w", err)
+	}
+	for _, branch := range []string{"bench", "main"} {
+		if err := checkoutBranch(branch); err != nil {
+			return nil, fmt.Errorf("runBenchmark: %w", err)
+		}
+		side := files[:half]
+		if branch == "main" {
+			side = files[half:]
+		}
+		if err := writeFiles(side); err != nil {
+			return nil, fmt.Errorf("runBenchmark: %w", err)
+		}
+		if err := stageFiles(side); err != nil {
+			return nil, fmt.Errorf("runBenchmark: %w", err)
+		}
+		if err := newCommit("modify " + branch); err != nil {
+			return nil, fmt.Errorf("runBenchmark: %w", err)
+		}
+	}
+	if err := measure("merge", 1, func() error { return mergeBranch("bench", mergeOptions{}) }); err != nil {
+		return nil, fmt.Errorf("runBenchmark: %w", err)
+	}
+	return results, nil
+}
+
+// checkoutHeadFiles restores files in the working directory to their head commit versions.
+func checkoutHeadFiles(files []string) error {
+	for _, file := range files {
+		if err := checkoutHeadCommit(file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// printBenchmark prints a benchmark report with the total and per-operation time of each operation.
+func printBenchmark(opts benchOptions, results []benchResult) error {
+	log.Printf(
+		"Benchmarked %v files of %v bytes with %v commits of history.\n",
+		opts.Files, opts.FileSize, opts.Depth,
+	)
+	w := tabwriter.NewWriter(log.Writer(), 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "operation\tcount\ttotal\tper op\t")
+	for _, r := range results {
+		fmt.Fprintf(
+			w, "%v\t%v\t%v\t%v\t\n",
+			r.Operation, r.Count, r.Elapsed.Round(time.Microsecond),
+			(r.Elapsed / time.Duration(r.Count)).Round(time.Microsecond),
+		)
+	}
+	return w.Flush()
+}
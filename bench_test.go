@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestRunBenchmark(t *testing.T) {
+	setupTestFS(t)
+	cwd, err := repoFS.Abs(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := runBenchmark(benchOptions{Files: 10, FileSize: 64, Depth: 3, Seed: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var operations []string
+	for _, r := range results {
+		operations = append(operations, r.Operation)
+	}
+	expected := []string{"add", "commit", "status", "log", "merge"}
+	if len(operations) != len(expected) {
+		t.Fatalf("Incorrect benchmarked operations: want %v, got %v", expected, operations)
+	}
+	for i := range expected {
+		if operations[i] != expected[i] {
+			t.Fatalf("Incorrect benchmarked operations: want %v, got %v", expected, operations)
+		}
+	}
+	if after, err := repoFS.Abs("."); err != nil || after != cwd {
+		t.Fatalf("Working directory not restored: want %v, got %v", cwd, after)
+	}
+}
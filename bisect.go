@@ -0,0 +1,273 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"path/filepath"
+	"slices"
+)
+
+var bisectFile = filepath.Join(gitletDir, "BISECT")
+
+// bisectState records the progress of a bisect session.
+type bisectState struct {
+	Head string   // Contents of the HEAD file when the bisect started, restored by reset.
+	Bad  string   // Latest commit marked bad, or empty if none was marked yet.
+	Good []string // Commits marked good.
+}
+
+// readBisectState returns the state of the current bisect session, or nil if there is none.
+func readBisectState() (*bisectState, error) {
+	b, err := readFile(bisectFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("readBisectState: %w", err)
+	}
+	state, err := deserialize[bisectState](b)
+	if err != nil {
+		return nil, fmt.Errorf("readBisectState: %w", err)
+	}
+	return &state, nil
+}
+
+// writeBisectState records the state of the current bisect session.
+func writeBisectState(state bisectState) error {
+	b, err := serialize(state)
+	if err != nil {
+		return fmt.Errorf("writeBisectState: %w", err)
+	}
+	if err := writeFileAtomic(bisectFile, b); err != nil {
+		return fmt.Errorf("writeBisectState: %w", err)
+	}
+	return nil
+}
+
+// findBisectMidpoint returns the commit to test next when searching for the first bad
+// commit: the candidate that splits the commits reachable from the bad commit but not from
+// any good commit most evenly. Also returns how many candidates remain. When only the bad
+// commit remains, it is the first bad commit.
+func findBisectMidpoint(bad string, good []string) (string, int, error) {
+	candidates, err := getAncestors(bad)
+	if err != nil {
+		return "", 0, fmt.Errorf("findBisectMidpoint: %w", err)
+	}
+	for _, hash := range good {
+		ancestors, err := getAncestors(hash)
+		if err != nil {
+			return "", 0, fmt.Errorf("findBisectMidpoint: %w", err)
+		}
+		for ancestor := range ancestors {
+			delete(candidates, ancestor)
+		}
+	}
+	if len(candidates) == 0 {
+		return "", 0, errors.New("findBisectMidpoint: the bad commit is an ancestor of a good commit")
+	}
+
+	midpoint, bestScore := bad, -1
+	for _, hash := range sortedKeys(candidates) {
+		ancestors, err := getAncestors(hash)
+		if err != nil {
+			return "", 0, fmt.Errorf("findBisectMidpoint: %w", err)
+		}
+		// candidates left if hash is bad, and if it is good
+		ifBad := 0
+		for ancestor := range ancestors {
+			if candidates[ancestor] {
+				ifBad++
+			}
+		}
+		if score := min(ifBad, len(candidates)-ifBad); score > bestScore {
+			midpoint, bestScore = hash, score
+		}
+	}
+	if len(candidates) == 1 {
+		midpoint = bad
+	}
+	return midpoint, len(candidates), nil
+}
+
+// bisectNext checks out the next commit to test, or reports the first bad commit once it is
+// found. Does nothing until a bad and a good commit have been marked.
+func bisectNext(state bisectState) error {
+	if state.Bad == "" || len(state.Good) == 0 {
+		return nil
+	}
+	midpoint, remaining, err := findBisectMidpoint(state.Bad, state.Good)
+	if err != nil {
+		return fmt.Errorf("bisectNext: %w", err)
+	}
+	c, err := getCommit(midpoint)
+	if err != nil {
+		return fmt.Errorf("bisectNext: %w", err)
+	}
+	if remaining == 1 {
+		log.Printf("%v is the first bad commit\n", midpoint)
+		log.Println(c.String(midpoint))
+		return nil
+	}
+	headCommitHash, err := getHeadCommitHash()
+	if err != nil {
+		return fmt.Errorf("bisectNext: %w", err)
+	}
+	if midpoint != headCommitHash {
+		if err := checkoutDetached(midpoint); err != nil {
+			return fmt.Errorf("bisectNext: %w", err)
+		}
+	}
+	log.Printf("Bisecting: %v commits left to test after this.\n", remaining/2)
+	log.Printf("[%v]\n", c.Oneline(midpoint))
+	return nil
+}
+
+// startBisect starts a bisect session, optionally marking a bad commit and good commits.
+func startBisect(bad string, good []string) error {
+	unlock, err := lockRepo("bisect")
+	if err != nil {
+		return fmt.Errorf("startBisect: %w", err)
+	}
+	defer unlock()
+	if state, err := readBisectState(); err != nil {
+		return fmt.Errorf("startBisect: %w", err)
+	} else if state != nil {
+		exit("A bisect is already in progress; run 'bisect reset' first.")
+	}
+	index, err := readIndex()
+	if err != nil {
+		return fmt.Errorf("startBisect: %w", err)
+	}
+	if len(index) != 0 {
+		exit("You have uncommitted changes.")
+	}
+	head, err := readRef(headFile)
+	if err != nil {
+		return fmt.Errorf("startBisect: %w", err)
+	}
+	state := bisectState{Head: head}
+	if bad != "" {
+		if state.Bad, err = resolveBisectRevision(bad); err != nil {
+			return fmt.Errorf("startBisect: %w", err)
+		}
+	}
+	for _, rev := range good {
+		hash, err := resolveBisectRevision(rev)
+		if err != nil {
+			return fmt.Errorf("startBisect: %w", err)
+		}
+		state.Good = append(state.Good, hash)
+	}
+	if err := checkBisectState(state); err != nil {
+		return fmt.Errorf("startBisect: %w", err)
+	}
+	if err := writeBisectState(state); err != nil {
+		return fmt.Errorf("startBisect: %w", err)
+	}
+	if err := bisectNext(state); err != nil {
+		return fmt.Errorf("startBisect: %w", err)
+	}
+	return nil
+}
+
+// markBisect marks the commit named by a revision as good or bad, then checks out the next
+// commit to test.
+func markBisect(rev string, good bool) error {
+	unlock, err := lockRepo("bisect")
+	if err != nil {
+		return fmt.Errorf("markBisect: %w", err)
+	}
+	defer unlock()
+	state, err := readBisectState()
+	if err != nil {
+		return fmt.Errorf("markBisect: %w", err)
+	}
+	if state == nil {
+		exit("No bisect in progress; start one with 'bisect start'.")
+	}
+	hash, err := resolveBisectRevision(rev)
+	if err != nil {
+		return fmt.Errorf("markBisect: %w", err)
+	}
+	if good {
+		if !slices.Contains(state.Good, hash) {
+			state.Good = append(state.Good, hash)
+		}
+	} else {
+		state.Bad = hash
+	}
+	if err := checkBisectState(*state); err != nil {
+		return fmt.Errorf("markBisect: %w", err)
+	}
+	if err := writeBisectState(*state); err != nil {
+		return fmt.Errorf("markBisect: %w", err)
+	}
+	if err := bisectNext(*state); err != nil {
+		return fmt.Errorf("markBisect: %w", err)
+	}
+	return nil
+}
+
+// resetBisect ends the bisect session and checks out the branch or commit that was checked
+// out when it started.
+func resetBisect() error {
+	unlock, err := lockRepo("bisect")
+	if err != nil {
+		return fmt.Errorf("resetBisect: %w", err)
+	}
+	defer unlock()
+	state, err := readBisectState()
+	if err != nil {
+		return fmt.Errorf("resetBisect: %w", err)
+	}
+	if state == nil {
+		exit("No bisect in progress.")
+	}
+	head, err := readRef(headFile)
+	if err != nil {
+		return fmt.Errorf("resetBisect: %w", err)
+	}
+	if head != state.Head {
+		if isHash(state.Head) {
+			err = checkoutDetached(state.Head)
+		} else {
+			err = checkoutBranch(filepath.Base(state.Head))
+		}
+		if err != nil {
+			return fmt.Errorf("resetBisect: %w", err)
+		}
+	}
+	if err := repoFS.Remove(bisectFile); err != nil {
+		return fmt.Errorf("resetBisect: %w", err)
+	}
+	return nil
+}
+
+// checkBisectState exits if the bad commit is a good commit or an ancestor of one, as no
+// commit could then be the first bad commit.
+func checkBisectState(state bisectState) error {
+	if state.Bad == "" {
+		return nil
+	}
+	for _, hash := range state.Good {
+		if ok, err := isAncestor(state.Bad, hash); err != nil {
+			return fmt.Errorf("checkBisectState: %w", err)
+		} else if ok {
+			exit("The bad commit cannot be a good commit or an ancestor of one.")
+		}
+	}
+	return nil
+}
+
+// resolveBisectRevision resolves a revision marked during a bisect.
+func resolveBisectRevision(rev string) (string, error) {
+	hash, err := resolveRevision(rev)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			exit("No commit with that id exists.")
+		}
+		return "", fmt.Errorf("resolveBisectRevision: %w", err)
+	}
+	return hash, nil
+}
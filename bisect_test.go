@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestBisect(t *testing.T) {
+	setupTestRepo(t)
+	var hashes []string
+	for i := range 8 {
+		if err := writeContents("wug.txt", []string{fmt.Sprint(i)}); err != nil {
+			t.Fatal(err)
+		}
+		if err := stageFile("wug.txt"); err != nil {
+			t.Fatal(err)
+		}
+		if err := newCommit(fmt.Sprintf("commit %v", i)); err != nil {
+			t.Fatal(err)
+		}
+		hash, err := getHeadCommitHash()
+		if err != nil {
+			t.Fatal(err)
+		}
+		hashes = append(hashes, hash)
+	}
+	firstBad := hashes[5]
+	isBad, err := getAncestors(hashes[7])
+	if err != nil {
+		t.Fatal(err)
+	}
+	good, err := getAncestors(hashes[4])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for hash := range good {
+		delete(isBad, hash)
+	}
+
+	if err := startBisect(hashes[7], []string{initialCommitHash}); err != nil {
+		t.Fatal(err)
+	}
+	for steps := 0; ; steps++ {
+		if steps > 4 {
+			t.Fatal("Bisect did not finish in log2(9) steps")
+		}
+		state, err := readBisectState()
+		if err != nil {
+			t.Fatal(err)
+		}
+		midpoint, remaining, err := findBisectMidpoint(state.Bad, state.Good)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if remaining == 1 {
+			if midpoint != firstBad {
+				t.Fatalf("First bad commit = %v, want %v", midpoint, firstBad)
+			}
+			break
+		}
+		head, err := getHeadCommitHash()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if head != midpoint {
+			t.Fatalf("Bisect checked out %v, want %v", head, midpoint)
+		}
+		if err := markBisect("HEAD", !isBad[head]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := resetBisect(); err != nil {
+		t.Fatal(err)
+	}
+	if branch, err := getCurrentBranch(); err != nil || branch != "main" {
+		t.Errorf("Reset should return to the main branch, got %q, %v", branch, err)
+	}
+	if state, err := readBisectState(); err != nil || state != nil {
+		t.Errorf("Reset should end the bisect: %v, %v", state, err)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// blameLine is a line of a file and the commit that introduced it.
+type blameLine struct {
+	Hash      string // Commit that introduced the line.
+	Author    string // Author of that commit, as "name <email>"; empty in older commits.
+	Timestamp int64  // When that commit was created in UNIX time in UTC.
+	Text      string
+}
+
+// blameFile attributes every line of a file as of a commit to the commit that introduced it.
+// History is followed through first parents: each line is traced back through the diffs of
+// successive versions of the file until the commit that inserted it, or the commit that added
+// the file, is found.
+func blameFile(commitHash string, file string) ([]blameLine, error) {
+	c, err := getCommit(commitHash)
+	if err != nil {
+		return nil, fmt.Errorf("blameFile: %w", err)
+	}
+	lines, ok, err := getCommitFileLines(c, file)
+	if err != nil {
+		return nil, fmt.Errorf("blameFile: %w", err)
+	}
+	if !ok {
+		exit("File does not exist in that commit.")
+	}
+	blame := make([]blameLine, len(lines))
+	// lines of the current version not yet attributed, by line number, to their index in the blame
+	pending := make(map[int]int, len(lines))
+	for i := range lines {
+		pending[i+1] = i
+	}
+	attribute := func(lineNumbers map[int]int, hash string, c commit) {
+		for _, i := range lineNumbers {
+			blame[i] = blameLine{hash, c.Author, c.Timestamp, lines[i]}
+		}
+	}
+
+	current := lines
+	for len(pending) > 0 {
+		parentHash := c.ParentUIDs[0]
+		if parentHash == "" {
+			attribute(pending, commitHash, c)
+			break
+		}
+		parent, err := getCommit(parentHash)
+		if err != nil {
+			return nil, fmt.Errorf("blameFile: %w", err)
+		}
+		// the file is unchanged, so every line predates this commit
+		if blobHash, ok := parent.FileToBlob[file]; ok && blobHash == c.FileToBlob[file] {
+			commitHash, c = parentHash, parent
+			continue
+		}
+		parentLines, inParent, err := getCommitFileLines(parent, file)
+		if err != nil {
+			return nil, fmt.Errorf("blameFile: %w", err)
+		}
+		if !inParent {
+			attribute(pending, commitHash, c)
+			break
+		}
+		inherited := make(map[int]int)
+		inserted := make(map[int]int)
+		for _, e := range diffLines(parentLines, current) {
+			i, ok := pending[e.NewLine]
+			if !ok || e.Op == '-' {
+				continue
+			}
+			if e.Op == '+' {
+				inserted[e.NewLine] = i
+			} else {
+				inherited[e.OldLine] = i
+			}
+		}
+		attribute(inserted, commitHash, c)
+		commitHash, c, current, pending = parentHash, parent, parentLines, inherited
+	}
+	return blame, nil
+}
+
+// printBlame prints every line of a file as of a revision, annotated with the commit that
+// introduced it, that commit's author and date, and the line number.
+func printBlame(rev string, file string) error {
+	file, err := normalizePath(file)
+	if err != nil {
+		return fmt.Errorf("printBlame: %w", err)
+	}
+	commitHash, err := resolveRevision(rev)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			exit("No commit with that id exists.")
+		}
+		return fmt.Errorf("printBlame: %w", err)
+	}
+	blame, err := blameFile(commitHash, file)
+	if err != nil {
+		return fmt.Errorf("printBlame: %w", err)
+	}
+	width := len(strconv.Itoa(len(blame)))
+	authorWidth := 0
+	for _, line := range blame {
+		authorWidth = max(authorWidth, utf8.RuneCountInString(authorName(line.Author)))
+	}
+	for i, line := range blame {
+		log.Printf(
+			"%v (%-*v %v %*d) %v\n",
+			line.Hash[:6], authorWidth, authorName(line.Author),
+			time.Unix(line.Timestamp, 0).Local().Format(time.DateTime), width, i+1, line.Text,
+		)
+	}
+	return nil
+}
+
+// authorName returns the name in an identity recorded as "name <email>", or unknownAuthor if
+// the identity is empty.
+func authorName(author string) string {
+	if author == "" {
+		return unknownAuthor
+	}
+	name, _, _ := strings.Cut(author, " <")
+	return name
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestBlameFile(t *testing.T) {
+	setupTestRepo(t)
+	var hashes []string
+	for _, version := range [][]string{
+		{"one\n", "two\n", "three\n"},
+		{"one\n", "2\n", "three\n"},
+		{"zero\n", "one\n", "2\n", "three\n"},
+	} {
+		if err := writeContents("wug.txt", version); err != nil {
+			t.Fatal(err)
+		}
+		if err := stageFile("wug.txt"); err != nil {
+			t.Fatal(err)
+		}
+		if err := newCommit("update wug"); err != nil {
+			t.Fatal(err)
+		}
+		hash, err := getHeadCommitHash()
+		if err != nil {
+			t.Fatal(err)
+		}
+		hashes = append(hashes, hash)
+	}
+	// a commit that does not touch the file is skipped
+	if err := writeContents("other.txt", []string{"other"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stageFile("other.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := newCommit("add other"); err != nil {
+		t.Fatal(err)
+	}
+	headHash, err := getHeadCommitHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blame, err := blameFile(headHash, "wug.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct {
+		hash string
+		text string
+	}{
+		{hashes[2], "zero"},
+		{hashes[0], "one"},
+		{hashes[1], "2"},
+		{hashes[0], "three"},
+	}
+	if len(blame) != len(expected) {
+		t.Fatalf("Incorrect number of blamed lines: want %v, got %v", len(expected), len(blame))
+	}
+	for i, e := range expected {
+		if blame[i].Hash != e.hash || blame[i].Text != e.text {
+			t.Errorf("Line %v blamed on %v %q, want %v %q", i+1, blame[i].Hash[:6], blame[i].Text, e.hash[:6], e.text)
+		}
+		if c, err := getCommit(e.hash); err != nil || blame[i].Author != c.Author {
+			t.Errorf("Line %v blamed on author %q, want %q, %v", i+1, blame[i].Author, c.Author, err)
+		}
+	}
+}
+
+func TestAuthorName(t *testing.T) {
+	for author, expected := range map[string]string{
+		"Ada Lovelace <ada@example.com>": "Ada Lovelace",
+		"ada":                            "ada",
+		"":                               unknownAuthor,
+	} {
+		if name := authorName(author); name != expected {
+			t.Errorf("authorName(%q) = %q, want %q", author, name, expected)
+		}
+	}
+}
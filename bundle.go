@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// First line of a bundle file.
+const bundleSignature string = "# gitlet bundle v1"
+
+// bundleRef is a branch or tag recorded in a bundle.
+type bundleRef struct {
+	Hash string
+	Name string // Full name of the ref, such as refs/heads/main or refs/tags/v1.
+}
+
+// getBundleRefs returns the refs named by branch or tag names, or every branch and tag if
+// no names are given.
+func getBundleRefs(names []string) ([]bundleRef, error) {
+	if len(names) == 0 {
+		branches, err := getRefNames(branchesDir)
+		if err != nil {
+			return nil, fmt.Errorf("getBundleRefs: %w", err)
+		}
+		tags, err := getTags()
+		if err != nil {
+			return nil, fmt.Errorf("getBundleRefs: %w", err)
+		}
+		names = append(branches, sortedKeys(tags)...)
+	}
+	var refs []bundleRef
+	for _, name := range names {
+		found := false
+		for _, refDir := range []string{branchesDir, tagsDir} {
+			hash, err := readRef(filepath.Join(refDir, name))
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			} else if err != nil {
+				return nil, fmt.Errorf("getBundleRefs: %w", err)
+			}
+			refName, err := filepath.Rel(gitletDir, filepath.Join(refDir, name))
+			if err != nil {
+				return nil, fmt.Errorf("getBundleRefs: %w", err)
+			}
+			refs = append(refs, bundleRef{hash, filepath.ToSlash(refName)})
+			found = true
+			break
+		}
+		if !found {
+			exitf("No branch or tag named '%v' exists.", name)
+		}
+	}
+	return refs, nil
+}
+
+// createBundle writes the given branches and tags, or every branch and tag, and all objects
+// reachable from them into a single bundle file that can be unbundled into another repository.
+func createBundle(file string, names []string) error {
+	refs, err := getBundleRefs(names)
+	if err != nil {
+		return fmt.Errorf("createBundle: %w", err)
+	}
+	var commitHashes, tagHashes []string
+	for _, ref := range refs {
+		commitHash, err := peelTag(ref.Hash)
+		if err != nil {
+			return fmt.Errorf("createBundle: %w", err)
+		}
+		if commitHash != ref.Hash {
+			tagHashes = append(tagHashes, ref.Hash)
+		}
+		commitHashes = append(commitHashes, commitHash)
+	}
+
+	f, err := repoFS.OpenFile(file, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return fmt.Errorf("createBundle: %w", err)
+	}
+	w := bufio.NewWriter(f)
+	fmt.Fprintln(w, bundleSignature)
+	for _, ref := range refs {
+		fmt.Fprintf(w, "%v %v\n", ref.Hash, ref.Name)
+	}
+	fmt.Fprintln(w)
+	count, err := writeBundleObjects(w, commitHashes, tagHashes)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		f.Close()
+		repoFS.Remove(file)
+		return fmt.Errorf("createBundle: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("createBundle: %w", err)
+	}
+	log.Printf("Bundled %v and %v.\n", pluralize(len(refs), "ref"), pluralize(count, "object"))
+	return nil
+}
+
+// writeBundleObjects writes the tags with the given hashes and all objects reachable from the
+// given commits to a bundle, each as it is read, so the bundle is never held in memory.
+// Returns the number of objects written.
+func writeBundleObjects(w io.Writer, commitHashes []string, tagHashes []string) (int, error) {
+	count := 0
+	writeObject := func(hash string, payload []byte) error {
+		count++
+		if _, err := fmt.Fprintf(w, "%v %v\n", hash, len(payload)); err != nil {
+			return err
+		}
+		_, err := w.Write(payload)
+		return err
+	}
+	if err := collectObjects(commitHashes, nil, writeObject); err != nil {
+		return 0, fmt.Errorf("writeBundleObjects: %w", err)
+	}
+	for _, hash := range tagHashes {
+		payload, err := readObjectPayload(hash)
+		if err != nil {
+			return 0, fmt.Errorf("writeBundleObjects: %w", err)
+		}
+		if err := writeObject(hash, payload); err != nil {
+			return 0, fmt.Errorf("writeBundleObjects: %w", err)
+		}
+	}
+	return count, nil
+}
+
+// readBundle reads the refs and the object payloads by hash in a bundle file. Returns an
+// error if the file is not a bundle, or an object does not match its hash.
+func readBundle(file string) ([]bundleRef, map[string][]byte, error) {
+	f, err := repoFS.Open(file)
+	if err != nil {
+		return nil, nil, fmt.Errorf("readBundle: %w", err)
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	if line, err := r.ReadString('\n'); err != nil || strings.TrimSuffix(line, "\n") != bundleSignature {
+		return nil, nil, fmt.Errorf("readBundle: %v is not a gitlet bundle", file)
+	}
+	var refs []bundleRef
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, nil, fmt.Errorf("readBundle: truncated ref list: %w", err)
+		}
+		if line = strings.TrimSuffix(line, "\n"); line == "" {
+			break
+		}
+		hash, name, ok := strings.Cut(line, " ")
+		rest, isRef := strings.CutPrefix(name, "refs/heads/")
+		if !isRef {
+			rest, isRef = strings.CutPrefix(name, "refs/tags/")
+		}
+		if !ok || !isHash(hash) || !isRef || rest == "" || path.Clean(rest) != rest || strings.HasPrefix(rest, "../") {
+			return nil, nil, fmt.Errorf("readBundle: invalid ref %q", line)
+		}
+		refs = append(refs, bundleRef{hash, name})
+	}
+	payloads := make(map[string][]byte)
+	for {
+		line, err := r.ReadString('\n')
+		if err == io.EOF && line == "" {
+			break
+		} else if err != nil {
+			return nil, nil, fmt.Errorf("readBundle: truncated object: %w", err)
+		}
+		hash, size, _ := strings.Cut(strings.TrimSuffix(line, "\n"), " ")
+		n, err := strconv.Atoi(size)
+		if err != nil || n < 0 || !isHash(hash) {
+			return nil, nil, fmt.Errorf("readBundle: invalid object header %q", line)
+		}
+		payload := make([]byte, n)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return nil, nil, fmt.Errorf("readBundle: truncated object %v: %w", hash, err)
+		}
+		if actual, err := getHash([][]byte{payload}); err != nil {
+			return nil, nil, fmt.Errorf("readBundle: %w", err)
+		} else if actual != hash {
+			return nil, nil, &corruptObjectError{hash, "bundled contents do not match the hash"}
+		}
+		payloads[hash] = payload
+	}
+	return refs, payloads, nil
+}
+
+// unbundle copies the objects in a bundle file into the current repository, then creates
+// the bundled branches and tags that do not exist yet. Existing branches are fast-forwarded,
+// except the current branch; other refs that differ are reported and left unchanged.
+func unbundle(file string) error {
+	unlock, err := lockRepo("unbundle")
+	if err != nil {
+		return fmt.Errorf("unbundle: %w", err)
+	}
+	defer unlock()
+	refs, payloads, err := readBundle(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		exit("Bundle file does not exist.")
+	} else if err != nil {
+		return fmt.Errorf("unbundle: %w", err)
+	}
+	for _, hash := range sortedKeys(payloads) {
+		if ok, err := hasObject(hash); err != nil {
+			return fmt.Errorf("unbundle: %w", err)
+		} else if ok {
+			continue
+		}
+		if err := writeLooseObject(hash, [][]byte{payloads[hash]}); err != nil {
+			return fmt.Errorf("unbundle: %w", err)
+		}
+	}
+
+	currentBranch, err := getCurrentBranch()
+	if err != nil {
+		return fmt.Errorf("unbundle: %w", err)
+	}
+	for _, ref := range refs {
+		if ok, err := hasObject(ref.Hash); err != nil {
+			return fmt.Errorf("unbundle: %w", err)
+		} else if !ok {
+			exitf("Bundle is missing the object for %v.", ref.Name)
+		}
+		refFile := filepath.Join(gitletDir, filepath.FromSlash(ref.Name))
+		branch, isBranch := strings.CutPrefix(ref.Name, "refs/heads/")
+		oldHash, err := readRef(refFile)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("unbundle: %w", err)
+		}
+		message := "bundle: created"
+		switch {
+		case oldHash == ref.Hash:
+			continue
+		case oldHash == "":
+		case !isBranch:
+			log.Printf("Skipped %v: it already exists.\n", ref.Name)
+			continue
+		case branch == currentBranch:
+			log.Printf("Skipped %v: it is checked out.\n", ref.Name)
+			continue
+		default:
+			ancestors, err := getAncestors(ref.Hash)
+			if err != nil {
+				return fmt.Errorf("unbundle: %w", err)
+			}
+			if !ancestors[oldHash] {
+				log.Printf("Skipped %v: not a fast-forward.\n", ref.Name)
+				continue
+			}
+			message = "bundle: fast-forward"
+		}
+		if err := repoFS.MkdirAll(filepath.Dir(refFile), 0755); err != nil {
+			return fmt.Errorf("unbundle: %w", err)
+		}
+		if err := writeRef(refFile, ref.Hash, "unbundle"); err != nil {
+			return fmt.Errorf("unbundle: %w", err)
+		}
+		if isBranch {
+			if err := logRefUpdate(branch, oldHash, ref.Hash, message); err != nil {
+				return fmt.Errorf("unbundle: %w", err)
+			}
+		}
+		log.Printf("%v -> %v\n", ref.Name, ref.Hash[:6])
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestBundle(t *testing.T) {
+	setupTestFS(t)
+	remoteDir := setupRemoteRepo(t)
+	bundleFile := filepath.Join(testTempDir(t), "repo.bundle")
+	var headCommitHash string
+	if err := inRepository(remoteDir, func() error {
+		if err := createTag("v1", "HEAD", "first release", true); err != nil {
+			return err
+		}
+		if err := addBranch("feature"); err != nil {
+			return err
+		}
+		var err error
+		if headCommitHash, err = getHeadCommitHash(); err != nil {
+			return err
+		}
+		return createBundle(bundleFile, nil)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := newRepository(); err != nil {
+		t.Fatal(err)
+	}
+	if err := addBranch("feature"); err != nil {
+		t.Fatal(err)
+	}
+	if err := unbundle(bundleFile); err != nil {
+		t.Fatal(err)
+	}
+	// the current branch is left alone, and other branches are fast-forwarded
+	if hash, err := resolveRevision("main"); err != nil || hash != initialCommitHash {
+		t.Errorf("The current branch should not change, got %v, %v", hash, err)
+	}
+	if hash, err := resolveRevision("feature"); err != nil || hash != headCommitHash {
+		t.Errorf("Expected feature to be fast-forwarded to %v, got %v, %v", headCommitHash, hash, err)
+	}
+	if hash, err := resolveRevision("v1"); err != nil || hash != headCommitHash {
+		t.Errorf("Expected tag v1 at %v, got %v, %v", headCommitHash, hash, err)
+	}
+	c, err := getCommit(headCommitHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := hasObject(c.FileToBlob["wug.txt"]); err != nil || !ok {
+		t.Errorf("Bundled blob was not unbundled: %v", err)
+	}
+}
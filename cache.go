@@ -0,0 +1,129 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+)
+
+// Maximum number of bytes of object contents held in the per-command object cache. Objects
+// larger than this are read again whenever they are needed.
+const objectCacheBytes int = 64 << 20
+
+// Maximum number of decoded commits held in the per-command commit cache. Commits are small,
+// so the cache holds the history traversed by log, merge, and split point searches, which
+// would otherwise be evicted from the object cache by the blobs of a checkout.
+const commitCacheSize int = 1 << 16
+
+// lruCache is a fixed-capacity cache that evicts the least recently used entries.
+// It is safe for concurrent use.
+type lruCache[K comparable, V any] struct {
+	mu       sync.Mutex
+	capacity int         // Most total weight of the cached entries.
+	weight   func(V) int // Weight of an entry.
+	used     int         // Total weight of the cached entries.
+	entries  map[K]*list.Element
+	order    *list.List // Front is the most recently used entry.
+}
+
+type lruEntry[K comparable, V any] struct {
+	key    K
+	value  V
+	weight int
+}
+
+// newLRUCache creates an empty cache holding at most capacity entries.
+func newLRUCache[K comparable, V any](capacity int) *lruCache[K, V] {
+	return newWeightedLRUCache[K, V](capacity, func(V) int { return 1 })
+}
+
+// newWeightedLRUCache creates an empty cache holding entries of at most capacity total
+// weight. Entries weighing more than capacity are never cached.
+func newWeightedLRUCache[K comparable, V any](capacity int, weight func(V) int) *lruCache[K, V] {
+	return &lruCache[K, V]{
+		capacity: capacity,
+		weight:   weight,
+		entries:  make(map[K]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// get returns the value cached for a key and marks it as recently used.
+func (c *lruCache[K, V]) get(key K) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		c.order.MoveToFront(e)
+		return e.Value.(*lruEntry[K, V]).value, true
+	}
+	var zero V
+	return zero, false
+}
+
+// add caches a value for a key, evicting the least recently used entries until the cache
+// is within its capacity. A value weighing more than the capacity is not cached.
+func (c *lruCache[K, V]) add(key K, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		c.removeElement(e)
+	}
+	weight := c.weight(value)
+	if weight > c.capacity {
+		return
+	}
+	c.entries[key] = c.order.PushFront(&lruEntry[K, V]{key, value, weight})
+	c.used += weight
+	for c.used > c.capacity {
+		c.removeElement(c.order.Back())
+	}
+}
+
+// remove drops a key from the cache if present.
+func (c *lruCache[K, V]) remove(key K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		c.removeElement(e)
+	}
+}
+
+// removeElement drops an entry from the cache. Callers hold c.mu.
+func (c *lruCache[K, V]) removeElement(e *list.Element) {
+	entry := e.Value.(*lruEntry[K, V])
+	c.order.Remove(e)
+	delete(c.entries, entry.key)
+	c.used -= entry.weight
+}
+
+// len returns the number of cached entries.
+func (c *lruCache[K, V]) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// cachedObject holds an object read from the objects directory.
+// Callers must treat the contents as read-only.
+type cachedObject struct {
+	header   string
+	contents []byte
+}
+
+// size returns the number of bytes an object holds in memory.
+func (obj *cachedObject) size() int {
+	return len(obj.header) + len(obj.contents)
+}
+
+// objectCache caches objects by hash so each object that fits is read at most once per
+// command.
+var objectCache = newWeightedLRUCache[string, *cachedObject](objectCacheBytes, (*cachedObject).size)
+
+// commitCache caches decoded commits by hash so each commit is decoded at most once per
+// command. Callers must treat the maps of a cached commit as read-only.
+var commitCache = newLRUCache[string, commit](commitCacheSize)
+
+// resetObjectCache drops every cached object and commit.
+func resetObjectCache() {
+	objectCache = newWeightedLRUCache[string, *cachedObject](objectCacheBytes, (*cachedObject).size)
+	commitCache = newLRUCache[string, commit](commitCacheSize)
+}
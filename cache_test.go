@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestLRUCacheEviction(t *testing.T) {
+	c := newLRUCache[string, int](2)
+	c.add("a", 1)
+	c.add("b", 2)
+	if _, ok := c.get("a"); !ok {
+		t.Fatal("Cached entry 'a' not found.")
+	}
+	// 'b' is now the least recently used entry
+	c.add("c", 3)
+	if _, ok := c.get("b"); ok {
+		t.Fatal("Least recently used entry 'b' was not evicted.")
+	}
+	if v, ok := c.get("a"); !ok || v != 1 {
+		t.Fatalf("Incorrect cached entry 'a': want 1, got %v", v)
+	}
+	if c.len() != 2 {
+		t.Fatalf("Incorrect cache length: want 2, got %v", c.len())
+	}
+	c.remove("a")
+	if _, ok := c.get("a"); ok {
+		t.Fatal("Removed entry 'a' still cached.")
+	}
+}
+
+func TestWeightedLRUCache(t *testing.T) {
+	c := newWeightedLRUCache[string, string](10, func(v string) int { return len(v) })
+	c.add("a", "aaaa")
+	c.add("b", "bbbb")
+	c.add("c", "cc")
+	if _, ok := c.get("b"); !ok {
+		t.Fatal("Entry 'b' was evicted while the cache had room.")
+	}
+	// 'a' is now the least recently used entry, and evicting it makes room
+	c.add("d", "ddd")
+	if _, ok := c.get("a"); ok {
+		t.Fatal("Least recently used entry 'a' was not evicted.")
+	}
+	if c.len() != 3 {
+		t.Fatalf("Incorrect cache length: want 3, got %v", c.len())
+	}
+	// replacing an entry counts only its new weight
+	c.add("a", "a")
+	c.add("e", "ee")
+	if c.len() != 4 {
+		t.Fatalf("Incorrect cache length after replacing an entry: want 4, got %v", c.len())
+	}
+	// entries heavier than the capacity are not cached, and evict nothing
+	c.add("f", "ffffffffffff")
+	if _, ok := c.get("f"); ok {
+		t.Fatal("Entry heavier than the capacity was cached.")
+	}
+	if c.len() != 4 {
+		t.Fatalf("Caching a heavy entry should evict nothing: got %v entries", c.len())
+	}
+}
+
+func TestObjectCache(t *testing.T) {
+	setupTestRepo(t)
+	if _, err := getCommit(initialCommitHash); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := commitCache.get(initialCommitHash); !ok {
+		t.Fatal("Initial commit was not cached after getCommit.")
+	}
+	// cached commit is served without reading the objects directory
+	if err := restrictedDelete(objectPath(initialCommitHash)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := getCommit(initialCommitHash); err != nil {
+		t.Fatalf("Cached commit not served: %v", err)
+	}
+	// removed objects are evicted from the cache
+	if err := removeObject(initialCommitHash); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := getCommit(initialCommitHash); err == nil {
+		t.Fatal("Removed commit still served from cache.")
+	}
+}
+
+func TestCommitCacheOutlivesObjects(t *testing.T) {
+	setupTestRepo(t)
+	if _, err := getCommit(initialCommitHash); err != nil {
+		t.Fatal(err)
+	}
+	// reading large blobs evicts the commit object but not the decoded commit
+	contents := make([]byte, objectCacheBytes/2)
+	for i := range 2 {
+		objectCache.add(fmt.Sprint(i), &cachedObject{header: "file", contents: contents})
+	}
+	if _, ok := objectCache.get(initialCommitHash); ok {
+		t.Fatal("Initial commit object should be evicted from the object cache.")
+	}
+	if err := restrictedDelete(objectPath(initialCommitHash)); err != nil {
+		t.Fatal(err)
+	}
+	if c, err := getCommit(initialCommitHash); err != nil || c.Message != "initial commit" {
+		t.Fatalf("Cached commit not served: %+v, %v", c, err)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"strings"
+)
+
+// cherryCommit is a commit of one branch that is not an ancestor of another.
+type cherryCommit struct {
+	Hash       string
+	Equivalent bool // The other branch has a commit making the same changes.
+}
+
+// getPatchID returns a hash of the changes a commit made to its first parent that does not
+// depend on the line numbers of the changes, so the same change applied to different
+// commits, as by cherry-pick or rebase, has the same patch ID.
+func getPatchID(commitHash string) (string, error) {
+	c, err := getCommit(commitHash)
+	if err != nil {
+		return "", fmt.Errorf("getPatchID: %w", err)
+	}
+	diffs, err := diffCommits(c.ParentUIDs[0], commitHash)
+	if err != nil {
+		return "", fmt.Errorf("getPatchID: %w", err)
+	}
+	var b strings.Builder
+	for _, d := range diffs {
+		fmt.Fprintf(&b, "%v %v %v\n", d.File, d.OldExists, d.NewExists)
+		if d.Binary {
+			fmt.Fprintf(&b, "binary %v\n", c.FileToBlob[d.File])
+		}
+		for _, hunk := range d.Hunks {
+			for _, line := range hunk.Lines {
+				if line.Op != ' ' {
+					fmt.Fprintf(&b, "%c%v\n", line.Op, line.Text)
+				}
+			}
+		}
+	}
+	patchID, err := getHash([][]byte{[]byte(b.String())})
+	if err != nil {
+		return "", fmt.Errorf("getPatchID: %w", err)
+	}
+	return patchID, nil
+}
+
+// getCherryCommits returns the non-merge commits on the first-parent history of the head
+// commit that are not ancestors of the upstream commit, oldest first, noting which have an
+// equivalent commit on the upstream side.
+func getCherryCommits(upstreamHash string, headHash string) ([]cherryCommit, error) {
+	upstreamCommits, err := getPatchCommits(headHash, upstreamHash)
+	if err != nil {
+		return nil, fmt.Errorf("getCherryCommits: %w", err)
+	}
+	upstreamPatchIDs := make(map[string]bool)
+	for _, hash := range upstreamCommits {
+		patchID, err := getPatchID(hash)
+		if err != nil {
+			return nil, fmt.Errorf("getCherryCommits: %w", err)
+		}
+		upstreamPatchIDs[patchID] = true
+	}
+	headCommits, err := getPatchCommits(upstreamHash, headHash)
+	if err != nil {
+		return nil, fmt.Errorf("getCherryCommits: %w", err)
+	}
+	var cherries []cherryCommit
+	for _, hash := range headCommits {
+		patchID, err := getPatchID(hash)
+		if err != nil {
+			return nil, fmt.Errorf("getCherryCommits: %w", err)
+		}
+		cherries = append(cherries, cherryCommit{hash, upstreamPatchIDs[patchID]})
+	}
+	return cherries, nil
+}
+
+// printCherry prints the commits of a head revision that are not in an upstream revision,
+// oldest first: "+" marks a commit a merge would carry over, and "-" a commit whose changes
+// upstream already has. With verbose, each commit's subject is printed too.
+func printCherry(upstreamRev string, headRev string, verbose bool) error {
+	hashes := make([]string, 2)
+	for i, rev := range []string{upstreamRev, headRev} {
+		hash, err := resolveRevision(rev)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				exitf("No commit or branch named '%v' exists.", rev)
+			}
+			return fmt.Errorf("printCherry: %w", err)
+		}
+		hashes[i] = hash
+	}
+	cherries, err := getCherryCommits(hashes[0], hashes[1])
+	if err != nil {
+		return fmt.Errorf("printCherry: %w", err)
+	}
+	for _, cherry := range cherries {
+		sign := "+"
+		if cherry.Equivalent {
+			sign = "-"
+		}
+		if !verbose {
+			log.Printf("%v %v\n", sign, cherry.Hash)
+			continue
+		}
+		c, err := getCommit(cherry.Hash)
+		if err != nil {
+			return fmt.Errorf("printCherry: %w", err)
+		}
+		log.Printf("%v %v %v\n", sign, cherry.Hash, c.Subject())
+	}
+	return nil
+}
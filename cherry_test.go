@@ -0,0 +1,36 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestGetCherryCommits(t *testing.T) {
+	setupTestRepo(t)
+	commitInRepo(t, ".", "base.txt", "1\n2\n3\n4\n5\n6\n7\n8")
+	if err := addBranch("upstream"); err != nil {
+		t.Fatal(err)
+	}
+	picked := commitInRepo(t, ".", "base.txt", "1\n2\n3\n4\n5\n6\n7\neight")
+	unpicked := commitInRepo(t, ".", "wug.txt", "wug")
+	headCommitHash, err := getHeadCommitHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// upstream makes the same change to a file it also changed elsewhere
+	if err := checkoutBranch("upstream"); err != nil {
+		t.Fatal(err)
+	}
+	commitInRepo(t, ".", "base.txt", "one\n2\n3\n4\n5\n6\n7\n8")
+	upstreamHash := commitInRepo(t, ".", "base.txt", "one\n2\n3\n4\n5\n6\n7\neight")
+
+	cherries, err := getCherryCommits(upstreamHash, headCommitHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []cherryCommit{{picked, true}, {unpicked, false}}
+	if !slices.Equal(cherries, expected) {
+		t.Fatalf("Cherry commits do not match, want %v, got %v", expected, cherries)
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+)
+
+// cherryPick creates a new commit on the head commit applying the changes a commit made to
+// its first parent, with the same message.
+func cherryPick(rev string) error {
+	unlock, err := lockRepo("cherry-pick")
+	if err != nil {
+		return fmt.Errorf("cherryPick: %w", err)
+	}
+	defer unlock()
+	commitHash, err := resolveRevision(rev)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			exit("No commit with that id exists.")
+		}
+		return fmt.Errorf("cherryPick: %w", err)
+	}
+	c, err := getCommit(commitHash)
+	if err != nil {
+		return fmt.Errorf("cherryPick: %w", err)
+	}
+	if err := applyCommitDiff(c.ParentUIDs[0], commitHash, c.Message, "cherry-pick"); err != nil {
+		return fmt.Errorf("cherryPick: %w", err)
+	}
+	return nil
+}
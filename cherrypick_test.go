@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestCherryPick(t *testing.T) {
+	setupTestRepo(t)
+	if err := writeContents("wug.txt", []string{"wug"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stageFile("wug.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := newCommit("add wug"); err != nil {
+		t.Fatal(err)
+	}
+	if err := addBranch("other"); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeContents("notwug.txt", []string{"notwug"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stageFile("notwug.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := newCommit("add notwug"); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeContents("wug.txt", []string{"picked"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stageFile("wug.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := newCommit("change wug"); err != nil {
+		t.Fatal(err)
+	}
+	pickedHash, err := getHeadCommitHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := checkoutBranch("other"); err != nil {
+		t.Fatal(err)
+	}
+	otherHash, err := getHeadCommitHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cherryPick(pickedHash); err != nil {
+		t.Fatal(err)
+	}
+	headCommit, err := getHeadCommit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if headCommit.ParentUIDs[0] != otherHash || headCommit.Message != "change wug" {
+		t.Errorf("Incorrect picked commit: %+v", headCommit)
+	}
+	entries, err := readReflog("HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message := entries[len(entries)-1].Message; message != "cherry-pick: change wug" {
+		t.Errorf("Reflog message = %q, want %q", message, "cherry-pick: change wug")
+	}
+	if contents, err := readContentsAsString("wug.txt"); err != nil || contents != "picked" {
+		t.Errorf("Picked change was not applied: %q, %v", contents, err)
+	}
+	// only the picked commit's changes are applied, not its ancestors'
+	if _, err := repoFS.Stat("notwug.txt"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("File from an earlier commit should not be applied: %v", err)
+	}
+}
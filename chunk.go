@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Files of at least chunk.threshold bytes are split into content-defined chunks, each stored as
+// its own chunk object, and committed as a chunked blob listing the chunks in order. Chunk
+// boundaries depend only on the bytes near them, so an edit to a large file changes only the
+// chunks around it and the other chunks are shared with earlier versions. Chunked blobs read as
+// one "<hash> <size>\n" line per chunk.
+//
+// Boundaries are found with a gear rolling hash: a boundary follows the first byte, at least
+// minChunkSize bytes into a chunk, where the low bits of the hash selected by chunkBoundaryMask
+// are zero, or the byte maxChunkSize bytes into the chunk.
+const (
+	minChunkSize      int    = 16 << 10
+	maxChunkSize      int    = 256 << 10
+	chunkBoundaryMask uint64 = 1<<16 - 1 // Chunks average minChunkSize plus 64 KiB.
+)
+
+// gearTable maps each byte to the random value it adds to the rolling hash. It is generated
+// from a fixed seed so that every repository finds the same boundaries.
+var gearTable = func() [256]uint64 {
+	var table [256]uint64
+	state := uint64(0x676974_6c6574) // splitmix64, seeded with "gitlet"
+	for i := range table {
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}()
+
+// fileChunk is one chunk of a chunked blob.
+type fileChunk struct {
+	Hash string // Hash of the chunk object.
+	Size int64  // Size of the chunk contents in bytes.
+}
+
+// splitChunks returns the lengths of the content-defined chunks of contents, in order.
+func splitChunks(contents []byte) []int {
+	var lengths []int
+	for len(contents) > 0 {
+		n := nextChunkLength(contents)
+		lengths = append(lengths, n)
+		contents = contents[n:]
+	}
+	return lengths
+}
+
+// nextChunkLength returns the length of the chunk at the start of contents.
+func nextChunkLength(contents []byte) int {
+	if len(contents) <= minChunkSize {
+		return len(contents)
+	}
+	limit := min(len(contents), maxChunkSize)
+	var hash uint64
+	for i := minChunkSize; i < limit; i++ {
+		hash = hash<<1 + gearTable[contents[i]]
+		if hash&chunkBoundaryMask == 0 {
+			return i + 1
+		}
+	}
+	return limit
+}
+
+// getChunkThreshold returns the size from which files are split into chunks, or 0 if
+// chunk.threshold is not set and no file is chunked.
+func getChunkThreshold() (int64, error) {
+	value, ok, err := getConfig("chunk.threshold")
+	if err != nil {
+		return 0, fmt.Errorf("getChunkThreshold: %w", err)
+	}
+	if !ok || value == "" {
+		return 0, nil
+	}
+	threshold, err := parseSize(value)
+	if err != nil {
+		return 0, fmt.Errorf("getChunkThreshold: chunk.threshold: %w", err)
+	}
+	return threshold, nil
+}
+
+// streamChunks returns the contents of the chunked blob that stores the file contents read
+// from r, and how many bytes were read. Only one chunk is held in memory at a time. If write is
+// true, the chunk objects are also written unless they already exist.
+func streamChunks(r io.Reader, write bool) ([]byte, int64, error) {
+	br := bufio.NewReaderSize(r, maxChunkSize)
+	var b strings.Builder
+	var total int64
+	for {
+		// chunk boundaries only depend on the next maxChunkSize bytes
+		peeked, err := br.Peek(maxChunkSize)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, 0, fmt.Errorf("streamChunks: %w", err)
+		}
+		if len(peeked) == 0 {
+			break
+		}
+		n := nextChunkLength(peeked)
+		var hash string
+		if write {
+			hash, _, err = createBlob("chunk", peeked[:n])
+		} else {
+			hash, err = getHash(blobPayload("chunk", peeked[:n]))
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("streamChunks: %w", err)
+		}
+		fmt.Fprintf(&b, "%v %d\n", hash, n)
+		total += int64(n)
+		if _, err := br.Discard(n); err != nil {
+			return nil, 0, fmt.Errorf("streamChunks: %w", err)
+		}
+	}
+	return []byte(b.String()), total, nil
+}
+
+// parseChunkedBlob parses the contents of a chunked blob.
+func parseChunkedBlob(b []byte) ([]fileChunk, error) {
+	var chunks []fileChunk
+	for _, line := range strings.SplitAfter(string(b), "\n") {
+		if line == "" {
+			continue
+		}
+		hash, sizeField, ok := strings.Cut(strings.TrimSuffix(line, "\n"), " ")
+		size, err := strconv.ParseInt(sizeField, 10, 64)
+		if !ok || !strings.HasSuffix(line, "\n") || !isHash(hash) || err != nil || size <= 0 {
+			return nil, fmt.Errorf("parseChunkedBlob: malformed chunk %q", line)
+		}
+		chunks = append(chunks, fileChunk{hash, size})
+	}
+	return chunks, nil
+}
+
+// readChunks returns the contents of the chunks listed by a chunked blob, in order.
+func readChunks(blobContents []byte) ([][]byte, error) {
+	chunks, err := parseChunkedBlob(blobContents)
+	if err != nil {
+		return nil, fmt.Errorf("readChunks: %w", err)
+	}
+	parts := make([][]byte, 0, len(chunks))
+	for _, chunk := range chunks {
+		contents, err := readChunk(chunk)
+		if err != nil {
+			return nil, fmt.Errorf("readChunks: %w", err)
+		}
+		parts = append(parts, contents)
+	}
+	return parts, nil
+}
+
+// readChunk returns the contents of a chunk. Chunks are read past the object cache, since
+// the chunks of a large file would evict every other object.
+func readChunk(chunk fileChunk) ([]byte, error) {
+	payload, err := readObjectPayload(chunk.Hash)
+	if err != nil {
+		return nil, fmt.Errorf("readChunk: %w", err)
+	}
+	header, contents, err := parsePayload(chunk.Hash, payload)
+	if err != nil {
+		return nil, fmt.Errorf("readChunk: %w", err)
+	}
+	if header != "chunk" || int64(len(contents)) != chunk.Size {
+		return nil, &corruptObjectError{chunk.Hash, fmt.Sprintf("want 'chunk' object of %d bytes", chunk.Size)}
+	}
+	return contents, nil
+}
+
+// chunkReader reads the contents of the chunks listed by a chunked blob in order, holding
+// only one chunk in memory at a time.
+type chunkReader struct {
+	chunks  []fileChunk // Chunks not read yet.
+	current []byte      // Unread contents of the chunk being read.
+}
+
+// newChunkReader returns a reader of the contents of a chunked blob.
+func newChunkReader(blobContents []byte) (*chunkReader, error) {
+	chunks, err := parseChunkedBlob(blobContents)
+	if err != nil {
+		return nil, fmt.Errorf("newChunkReader: %w", err)
+	}
+	return &chunkReader{chunks: chunks}, nil
+}
+
+// Read reads the contents of the chunks, reading each chunk once the previous one is used up.
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for len(r.current) == 0 {
+		if len(r.chunks) == 0 {
+			return 0, io.EOF
+		}
+		contents, err := readChunk(r.chunks[0])
+		if err != nil {
+			return 0, fmt.Errorf("Read: %w", err)
+		}
+		r.chunks, r.current = r.chunks[1:], contents
+	}
+	n := copy(p, r.current)
+	r.current = r.current[n:]
+	return n, nil
+}
+
+// getBlobChunks returns the hashes of the chunk objects a blob lists, or nil if it is not a
+// chunked blob.
+func getBlobChunks(hash string) ([]string, error) {
+	header, err := parseBlobHeader(hash)
+	if err != nil {
+		return nil, fmt.Errorf("getBlobChunks: %w", err)
+	}
+	if header != "chunked" {
+		return nil, nil
+	}
+	obj, err := loadObject(hash)
+	if err != nil {
+		return nil, fmt.Errorf("getBlobChunks: %w", err)
+	}
+	chunks, err := parseChunkedBlob(obj.contents)
+	if err != nil {
+		return nil, fmt.Errorf("getBlobChunks: %w", err)
+	}
+	hashes := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		hashes[i] = chunk.Hash
+	}
+	return hashes, nil
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+// randomContents returns n pseudo-random bytes that are the same for every run.
+func randomContents(n int) []byte {
+	b := make([]byte, n)
+	rand.New(rand.NewSource(1)).Read(b)
+	return b
+}
+
+func TestSplitChunks(t *testing.T) {
+	contents := randomContents(4 << 20)
+	lengths := splitChunks(contents)
+	total := 0
+	for i, n := range lengths {
+		if n > maxChunkSize || (n < minChunkSize && i != len(lengths)-1) {
+			t.Fatalf("Chunk %v has %v bytes, outside the chunk size limits", i, n)
+		}
+		total += n
+	}
+	if total != len(contents) {
+		t.Fatalf("Chunks cover %v bytes, want %v", total, len(contents))
+	}
+
+	// inserting bytes only changes the chunks around them
+	edited := bytes.Clone(contents[:1<<20])
+	edited = append(edited, []byte("This is a wug")...)
+	edited = append(edited, contents[1<<20:]...)
+	before, _, err := streamChunks(bytes.NewReader(contents), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, _, err := streamChunks(bytes.NewReader(edited), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	beforeChunks, _ := parseChunkedBlob(before)
+	afterChunks, err := parseChunkedBlob(after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shared := make(map[string]bool)
+	for _, chunk := range beforeChunks {
+		shared[chunk.Hash] = true
+	}
+	changed := 0
+	for _, chunk := range afterChunks {
+		if !shared[chunk.Hash] {
+			changed++
+		}
+	}
+	if changed > 2 {
+		t.Fatalf("Small edit changed %v of %v chunks", changed, len(afterChunks))
+	}
+}
+
+func TestParseChunkedBlob(t *testing.T) {
+	for _, s := range []string{
+		"wug 10\n",
+		initialCommitHash + " 0\n",
+		initialCommitHash + " 10",
+		initialCommitHash + "\n",
+	} {
+		if _, err := parseChunkedBlob([]byte(s)); err == nil {
+			t.Errorf("Parsing %q should fail", s)
+		}
+	}
+}
+
+func TestChunkedCommit(t *testing.T) {
+	setupTestRepo(t)
+	if err := setConfig("chunk.threshold", "1m"); err != nil {
+		t.Fatal(err)
+	}
+	contents := randomContents(2 << 20)
+	if err := writeContents("wug.bin", [][]byte{contents}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stageFile("wug.bin"); err != nil {
+		t.Fatal(err)
+	}
+	if err := newCommit("add large wug"); err != nil {
+		t.Fatal(err)
+	}
+	headCommit, err := getHeadCommit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	blobHash := headCommit.FileToBlob["wug.bin"]
+	if header, err := parseBlobHeader(blobHash); err != nil || header != "chunked" {
+		t.Fatalf("Large file should be committed as a chunked blob: %q, %v", header, err)
+	}
+	if header, blobContents, err := readBlob(blobHash); err != nil || header != "file" || !bytes.Equal(blobContents, contents) {
+		t.Fatalf("Chunked blob does not read as the file contents: %q, %v", header, err)
+	}
+	if err := verifyBlob(blobHash); err != nil {
+		t.Fatalf("Chunked blob should verify: %v", err)
+	}
+	reachable, err := getReachableObjects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunks, err := getBlobChunks(blobHash)
+	if err != nil || len(chunks) < 2 {
+		t.Fatalf("Large file should be split into chunks: %v, %v", chunks, err)
+	}
+	for _, chunkHash := range chunks {
+		if !reachable[chunkHash] {
+			t.Fatalf("Chunk %v of a committed file is unreachable", chunkHash)
+		}
+	}
+
+	if err := repoFS.Remove("wug.bin"); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkoutHeadCommit("wug.bin"); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := readFile("wug.bin"); err != nil || !bytes.Equal(b, contents) {
+		t.Fatalf("Chunked file was not checked out: %v", err)
+	}
+	if entries, err := getStatusEntries(); err != nil || len(entries) != 0 {
+		t.Fatalf("Checked out chunked file should be unmodified: %+v, %v", entries, err)
+	}
+
+	// a small edit stores only the changed chunks and the new chunk list
+	objectsBefore, err := getObjectHashes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents[1<<20] ^= 0xff
+	if err := writeContents("wug.bin", [][]byte{contents}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stageFile("wug.bin"); err != nil {
+		t.Fatal(err)
+	}
+	objectsAfter, err := getObjectHashes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added := len(objectsAfter) - len(objectsBefore); added > 3 {
+		t.Fatalf("Small edit added %v objects", added)
+	}
+}
+
+func TestChunkedClone(t *testing.T) {
+	setupTestFS(t)
+	remoteDir := testTempDir(t)
+	contents := randomContents(1 << 20)
+	if err := inRepository(remoteDir, func() error {
+		if err := newRepository(); err != nil {
+			return err
+		}
+		if err := setConfig("chunk.threshold", "64k"); err != nil {
+			return err
+		}
+		if err := writeContents("wug.bin", [][]byte{contents}); err != nil {
+			return err
+		}
+		if err := stageFile("wug.bin"); err != nil {
+			return err
+		}
+		return newCommit("add large wug")
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cloneRemote(remoteDir, "clone"); err != nil {
+		t.Fatal(err)
+	}
+	enterTestDir(t, "clone")
+	if b, err := readFile("wug.bin"); err != nil || !bytes.Equal(b, contents) {
+		t.Fatalf("Clone did not check out the chunked file: %v", err)
+	}
+	if entries, err := getStatusEntries(); err != nil || len(entries) != 0 {
+		t.Fatalf("Cloned chunked file should be unmodified: %+v, %v", entries, err)
+	}
+}
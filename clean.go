@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// cleanUntracked removes the untracked files listed by status. With dryRun, the files are
+// only listed. Refuses to remove anything unless force is set.
+func cleanUntracked(dryRun bool, force bool) error {
+	if !dryRun && !force {
+		exit("Refusing to clean without -f or -n.")
+	}
+	unlock, err := lockRepo("clean")
+	if err != nil {
+		return fmt.Errorf("cleanUntracked: %w", err)
+	}
+	defer unlock()
+	_, headFiles, err := getHeadScopeFiles()
+	if err != nil {
+		return fmt.Errorf("cleanUntracked: %w", err)
+	}
+	index, err := readIndex()
+	if err != nil {
+		return fmt.Errorf("cleanUntracked: %w", err)
+	}
+	untracked, err := getUntrackedFiles(headFiles, index)
+	if err != nil {
+		return fmt.Errorf("cleanUntracked: %w", err)
+	}
+	for _, file := range untracked {
+		if dryRun {
+			log.Printf("Would remove %v\n", file)
+			continue
+		}
+		if err := restrictedDelete(file); err != nil {
+			return fmt.Errorf("cleanUntracked: %w", err)
+		}
+		log.Printf("Removing %v\n", file)
+	}
+	return nil
+}
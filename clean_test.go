@@ -0,0 +1,43 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestCleanUntracked(t *testing.T) {
+	setupTestRepo(t)
+	for _, file := range []string{"tracked.txt", "staged.txt", "untracked.txt"} {
+		if err := writeContents(file, []string{file}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := stageFile("tracked.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := newCommit("add tracked"); err != nil {
+		t.Fatal(err)
+	}
+	if err := stageFile("staged.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cleanUntracked(true, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repoFS.Stat("untracked.txt"); err != nil {
+		t.Fatalf("Dry run should not remove files: %v", err)
+	}
+	if err := cleanUntracked(false, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repoFS.Stat("untracked.txt"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Untracked file was not removed: %v", err)
+	}
+	for _, file := range []string{"tracked.txt", "staged.txt"} {
+		if _, err := repoFS.Stat(file); err != nil {
+			t.Errorf("%v should not be removed: %v", file, err)
+		}
+	}
+}
@@ -3,53 +3,80 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 )
 
 const blobHeaderDelim byte = 0
-const bufferSize int = 4096
+
+// Objects at least this many bytes are memory-mapped instead of read into the heap.
+const largeObjectThreshold int64 = 1 << 20
+
+// Blobs with contents of at least largeObjectThreshold bytes have their header padded to this
+// size so their contents start on a filesystem block boundary and can be cloned by reflink.
+const largeBlobHeaderSize int = 4096
 
 type commit struct {
 	Message    string            // User supplied commit message.
 	Timestamp  int64             // When the commit was created in UNIX time in UTC.
 	FileToBlob map[string]string // Map of file names to file blob UIDs tracked in the commit.
 	ParentUIDs [2]string         // SHA1 hash of the parent commit. Merge commits have two parents.
+	Submodules map[string]string `json:",omitempty"` // Map of submodule paths to the commits they are pinned to.
+	Author     string            `json:",omitempty"` // Who made the changes, as "name <email>"; empty in older commits.
+	Committer  string            `json:",omitempty"` // Who recorded the commit, as "name <email>"; empty in older commits.
+	Tree       string            `json:",omitempty"` // Root tree of the tracked files, from which FileToBlob is read; empty in older commits.
 }
 
 func (c *commit) String(hash string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "commit %v\n", hash)
 	if isMergeCommit := c.ParentUIDs[1] != ""; isMergeCommit {
-		return fmt.Sprintf(
-			"commit %v\n"+
-				"Merge: %v %v\n"+
-				"Date: %v\n"+
-				"%v\n",
-			hash,
-			c.ParentUIDs[0][:6], c.ParentUIDs[1][:6],
-			time.Unix(c.Timestamp, 0).Local().Format("Mon Jan 02 15:04:05 2006 -0700"),
-			c.Message,
-		)
-	}
-	return fmt.Sprintf(
-		"commit %v\n"+
-			"Date: %v\n"+
-			"%v\n",
-		hash,
-		time.Unix(c.Timestamp, 0).Local().Format("Mon Jan 02 15:04:05 2006 -0700"),
-		c.Message,
-	)
+		fmt.Fprintf(&b, "Merge: %v %v\n", c.ParentUIDs[0][:6], c.ParentUIDs[1][:6])
+	}
+	if c.Author != "" {
+		fmt.Fprintf(&b, "Author: %v\n", c.Author)
+	}
+	// the committer is only shown if someone else recorded the author's changes
+	if c.Committer != "" && c.Committer != c.Author {
+		fmt.Fprintf(&b, "Commit: %v\n", c.Committer)
+	}
+	fmt.Fprintf(&b, "Date: %v\n", time.Unix(c.Timestamp, 0).Local().Format("Mon Jan 02 15:04:05 2006 -0700"))
+	fmt.Fprintf(&b, "%v\n", c.Message)
+	return b.String()
+}
+
+// Subject returns the first line of the commit message.
+func (c *commit) Subject() string {
+	subject, _, _ := strings.Cut(c.Message, "\n")
+	return subject
+}
+
+// Oneline formats a commit as its abbreviated hash and the first line of its message.
+func (c *commit) Oneline(hash string) string {
+	return fmt.Sprintf("%v %v", hash[:6], c.Subject())
 }
 
+// getHeadCommitHash returns the hash of the head commit: the head of the current branch,
+// or the commit HEAD points to directly if it is detached.
 func getHeadCommitHash() (string, error) {
-	currentBranchFile, err := readContentsAsString(headFile)
+	head, err := readRef(headFile)
 	if err != nil {
 		return "", fmt.Errorf("getHeadCommitHash: %w", err)
 	}
-	headCommitHash, err := readContentsAsString(currentBranchFile)
+	if isHash(head) {
+		return head, nil
+	}
+	headCommitHash, err := readRef(head)
 	if err != nil {
 		return "", fmt.Errorf("getHeadCommitHash: %w", err)
 	}
@@ -58,11 +85,7 @@ func getHeadCommitHash() (string, error) {
 
 func getHeadCommit() (commit, error) {
 	var c commit
-	currentBranchFile, err := readContentsAsString(headFile)
-	if err != nil {
-		return c, fmt.Errorf("getHeadCommit: %w", err)
-	}
-	headCommitHash, err := readContentsAsString(currentBranchFile)
+	headCommitHash, err := getHeadCommitHash()
 	if err != nil {
 		return c, fmt.Errorf("getHeadCommit: %w", err)
 	}
@@ -73,26 +96,176 @@ func getHeadCommit() (commit, error) {
 	return c, nil
 }
 
-func writeCommitBlob(c commit) error {
+// getCurrentBranch returns the name of the current branch, or an empty name if HEAD is detached.
+func getCurrentBranch() (string, error) {
+	head, err := readRef(headFile)
+	if err != nil {
+		return "", fmt.Errorf("getCurrentBranch: %w", err)
+	}
+	if isHash(head) {
+		return "", nil
+	}
+	return filepath.Base(head), nil
+}
+
+// setHeadCommit moves the current branch to a commit, or HEAD itself if it is detached,
+// and records the move with the given message in the reflogs of HEAD and the branch.
+func setHeadCommit(commitHash string, message string) error {
+	unlockHead, err := lockFile(headFile, "update HEAD")
+	if err != nil {
+		return fmt.Errorf("setHeadCommit: %w", err)
+	}
+	defer unlockHead()
+	head, err := readRef(headFile)
+	if err != nil {
+		return fmt.Errorf("setHeadCommit: %w", err)
+	}
+	oldHash, branch := head, ""
+	if isHash(head) {
+		head = headFile
+	} else {
+		branch = filepath.Base(head)
+		unlockBranch, err := lockFile(head, "update "+branch)
+		if err != nil {
+			return fmt.Errorf("setHeadCommit: %w", err)
+		}
+		defer unlockBranch()
+		if oldHash, err = readRef(head); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("setHeadCommit: %w", err)
+		}
+	}
+	if err := writeRef(head, commitHash, message); err != nil {
+		return fmt.Errorf("setHeadCommit: %w", err)
+	}
+	if err := logRefUpdate("HEAD", oldHash, commitHash, message); err != nil {
+		return fmt.Errorf("setHeadCommit: %w", err)
+	}
+	if branch != "" {
+		if err := logRefUpdate(branch, oldHash, commitHash, message); err != nil {
+			return fmt.Errorf("setHeadCommit: %w", err)
+		}
+	}
+	return nil
+}
+
+// writeCommitBlob writes a commit, storing its files as tree objects rather than in the commit
+// itself, adds it to the commit graph, and returns its hash. A commit without files lists them
+// as before, so every repository's initial commit keeps the same hash.
+func writeCommitBlob(c commit) (string, error) {
+	c.Tree = ""
+	if len(c.FileToBlob) > 0 {
+		tree, err := writeTree(c.FileToBlob)
+		if err != nil {
+			return "", fmt.Errorf("writeCommitBlob: %w", err)
+		}
+		c.Tree, c.FileToBlob = tree, nil
+	}
 	b, err := serialize(c)
 	if err != nil {
-		return err
+		return "", fmt.Errorf("writeCommitBlob: %w", err)
+	}
+	hash, err := writeBlob("commit", b)
+	if err != nil {
+		return "", fmt.Errorf("writeCommitBlob: %w", err)
+	}
+	if err := addToCommitGraph(hash, c); err != nil {
+		return "", fmt.Errorf("writeCommitBlob: %w", err)
+	}
+	return hash, nil
+}
+
+func writeFileBlob(file string) (string, error) {
+	f, err := repoFS.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	fileInfo, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	hash, _, err := storeFileContents(f, fileInfo.Size(), true)
+	return hash, err
+}
+
+// corruptObjectError reports an object whose stored bytes do not match its hash or header.
+type corruptObjectError struct {
+	Hash   string // Hash of the corrupt object.
+	Reason string // What failed validation.
+}
+
+func (e *corruptObjectError) Error() string {
+	return fmt.Sprintf("object %v is corrupt: %v", e.Hash, e.Reason)
+}
+
+// blobPayload returns the bytes stored and hashed for a blob: its header of object type and
+// contents size, the header delimiter, and its contents. Headers of large blobs are padded
+// with spaces so the contents are block-aligned in the object file.
+func blobPayload(objType string, contents []byte) []any {
+	return []any{blobHeader(objType, int64(len(contents))), []byte{blobHeaderDelim}, contents}
+}
+
+// blobHeader returns the header of a blob with the given object type and contents size.
+func blobHeader(objType string, size int64) string {
+	header := fmt.Sprintf("%v %d", objType, size)
+	if size >= largeObjectThreshold && len(header) < largeBlobHeaderSize-1 {
+		header += strings.Repeat(" ", largeBlobHeaderSize-1-len(header))
 	}
-	return writeBlob("commit", b)
+	return header
 }
 
-func writeFileBlob(file string) error {
-	b, err := readContents(file)
+// parseHeader returns the object type and contents size recorded in a blob header.
+// The size is -1 for objects written before headers recorded sizes.
+func parseHeader(header string) (string, int, error) {
+	fields := strings.Fields(header)
+	switch len(fields) {
+	case 1:
+		return fields[0], -1, nil
+	case 2:
+		size, err := strconv.Atoi(fields[1])
+		if err != nil || size < 0 {
+			return "", 0, fmt.Errorf("invalid size '%v' in header", fields[1])
+		}
+		return fields[0], size, nil
+	default:
+		return "", 0, fmt.Errorf("malformed header '%v'", header)
+	}
+}
+
+// parsePayload validates the stored bytes of an object against its hash and the size
+// recorded in its header, and returns the object type and contents.
+func parsePayload(hash string, payload []byte) (string, []byte, error) {
+	headerLen := bytes.IndexByte(payload, blobHeaderDelim)
+	if headerLen < 0 {
+		return "", nil, &corruptObjectError{hash, "missing header delimiter"}
+	}
+	objType, size, err := parseHeader(string(payload[:headerLen]))
 	if err != nil {
-		return err
+		return "", nil, &corruptObjectError{hash, err.Error()}
+	}
+	contents := payload[headerLen+1:]
+	if size >= 0 && size != len(contents) {
+		return "", nil, &corruptObjectError{hash, fmt.Sprintf("header records %d bytes, found %d", size, len(contents))}
 	}
-	return writeBlob("file", b)
+	if sum := sha1.Sum(payload); hex.EncodeToString(sum[:]) != hash {
+		return "", nil, &corruptObjectError{hash, "contents do not match hash"}
+	}
+	return objType, contents, nil
 }
 
 // parseBlobHeader returns a blob's header given the hash of the blob.
 func parseBlobHeader(hash string) (string, error) {
-	f, err := os.Open(filepath.Join(objectsDir, hash))
-	if err != nil {
+	if obj, ok := objectCache.get(hash); ok {
+		return obj.header, nil
+	}
+	f, err := openLooseObject(hash)
+	if errors.Is(err, fs.ErrNotExist) {
+		obj, err := loadObject(hash)
+		if err != nil {
+			return "", fmt.Errorf("parseBlobHeader: %w", err)
+		}
+		return obj.header, nil
+	} else if err != nil {
 		return "", fmt.Errorf("parseBlobHeader: %w", err)
 	}
 	defer f.Close()
@@ -101,86 +274,400 @@ func parseBlobHeader(hash string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	header = bytes.TrimSuffix(header, []byte{blobHeaderDelim})
-	return string(header), f.Close()
+	objType, _, err := parseHeader(string(bytes.TrimSuffix(header, []byte{blobHeaderDelim})))
+	if err != nil {
+		return "", &corruptObjectError{hash, err.Error()}
+	}
+	return objType, f.Close()
 }
 
 // readBlob returns the header and contents of a blob given the hash of the blob.
+// An lfs pointer blob or a chunked blob reads as a file blob of the contents it stores.
 func readBlob(hash string) (string, []byte, error) {
-	var header string
-	var contents []byte
-	f, err := os.Open(filepath.Join(objectsDir, hash))
+	obj, err := loadObject(hash)
 	if err != nil {
-		return header, contents, fmt.Errorf("readBlob: %w", err)
+		return "", nil, fmt.Errorf("readBlob: %w", err)
+	}
+	switch obj.header {
+	case "lfs":
+		contents, err := readMedia(obj.contents)
+		if err != nil {
+			return "", nil, fmt.Errorf("readBlob: %w", err)
+		}
+		return "file", contents, nil
+	case "chunked":
+		parts, err := readChunks(obj.contents)
+		if err != nil {
+			return "", nil, fmt.Errorf("readBlob: %w", err)
+		}
+		return "file", bytes.Join(parts, nil), nil
+	}
+	return obj.header, obj.contents, nil
+}
+
+// loadObject returns the object with the given hash, reading it from the objects directory
+// or a pack only if it is not already in the object cache.
+func loadObject(hash string) (*cachedObject, error) {
+	if obj, ok := objectCache.get(hash); ok {
+		return obj, nil
+	}
+	payload, err := readObjectPayload(hash)
+	if err != nil {
+		return nil, fmt.Errorf("loadObject: %w", err)
+	}
+	header, contents, err := parsePayload(hash, payload)
+	if err != nil {
+		return nil, fmt.Errorf("loadObject: %w", err)
+	}
+	obj := &cachedObject{header: header, contents: contents}
+	objectCache.add(hash, obj)
+	return obj, nil
+}
+
+// mappedObject provides read access to an object's contents.
+// Large objects are memory-mapped rather than copied into heap buffers.
+type mappedObject struct {
+	header   string
+	contents []byte
+	mapping  []byte // Memory-mapped object file, nil if the object was read from the cache or a pack.
+}
+
+// openObject returns the object with the given hash, memory-mapping it if it is large.
+// The returned object must be closed once its contents are no longer used.
+func openObject(hash string) (*mappedObject, error) {
+	r, err := openLooseObject(hash)
+	if errors.Is(err, fs.ErrNotExist) {
+		// packed objects are read directly from the memory-mapped pack file
+		payload, packErr := readPackedObject(hash)
+		if packErr != nil {
+			return nil, fmt.Errorf("openObject: %w", packErr)
+		}
+		if payload == nil {
+			return nil, fmt.Errorf("openObject: %w", err)
+		}
+		header, contents, err := parsePayload(hash, payload)
+		if err != nil {
+			return nil, fmt.Errorf("openObject: %w", err)
+		}
+		return &mappedObject{header: header, contents: contents}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("openObject: %w", err)
+	}
+	defer r.Close()
+	f, ok := r.(*os.File)
+	if !ok {
+		// only objects kept in files can be memory-mapped
+		obj, err := loadObject(hash)
+		if err != nil {
+			return nil, fmt.Errorf("openObject: %w", err)
+		}
+		return &mappedObject{header: obj.header, contents: obj.contents}, nil
+	}
+	fileInfo, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("openObject: %w", err)
+	}
+	if fileInfo.Size() < largeObjectThreshold {
+		obj, err := loadObject(hash)
+		if err != nil {
+			return nil, fmt.Errorf("openObject: %w", err)
+		}
+		return &mappedObject{header: obj.header, contents: obj.contents}, nil
 	}
-	defer f.Close()
-	reader := bufio.NewReader(f)
 
-	headerBytes, err := reader.ReadBytes(blobHeaderDelim)
+	mapping, err := mmapFile(f, int(fileInfo.Size()))
 	if err != nil {
-		return header, contents, fmt.Errorf("readBlob: %w", err)
+		return nil, fmt.Errorf("openObject: %w", err)
 	}
-	header = string(bytes.TrimSuffix(headerBytes, []byte{blobHeaderDelim}))
+	header, contents, err := parsePayload(hash, mapping)
+	if err != nil {
+		munmapFile(mapping)
+		return nil, fmt.Errorf("openObject: %w", err)
+	}
+	return &mappedObject{header: header, contents: contents, mapping: mapping}, f.Close()
+}
+
+// Close releases the memory mapping of the object, if any.
+func (o *mappedObject) Close() error {
+	if o.mapping == nil {
+		return nil
+	}
+	mapping := o.mapping
+	o.mapping, o.contents = nil, nil
+	return munmapFile(mapping)
+}
 
-	contents = make([]byte, bufferSize)
-	bytesRead, err := reader.Read(contents)
+// objectReader reads the contents of an opened object, releasing the object once closed.
+type objectReader struct {
+	*bytes.Reader
+	obj *mappedObject
+}
+
+// Close releases the object.
+func (r *objectReader) Close() error {
+	return r.obj.Close()
+}
+
+// openBlob returns a reader of the file contents stored by a file blob. Large loose blobs are
+// memory-mapped, lfs media are streamed from the media store, and chunks are read one at a
+// time, so the contents are never read into memory at once. The reader must be closed.
+func openBlob(hash string) (io.ReadCloser, error) {
+	obj, err := openObject(hash)
 	if err != nil {
-		return header, contents, fmt.Errorf("readBlob: %w", err)
+		return nil, fmt.Errorf("openBlob: %w", err)
+	}
+	switch obj.header {
+	case "lfs":
+		defer obj.Close()
+		r, err := openMedia(obj.contents)
+		if err != nil {
+			return nil, fmt.Errorf("openBlob: %w", err)
+		}
+		return r, nil
+	case "chunked":
+		defer obj.Close()
+		r, err := newChunkReader(obj.contents)
+		if err != nil {
+			return nil, fmt.Errorf("openBlob: %w", err)
+		}
+		return io.NopCloser(r), nil
+	}
+	return &objectReader{bytes.NewReader(obj.contents), obj}, nil
+}
+
+// materializeBlob writes the contents of a file blob to a file in the working directory.
+// Large loose blobs are cloned by reflink when the filesystem supports it.
+func materializeBlob(hash string, file string) error {
+	if cloned, err := cloneBlob(hash, file); err != nil {
+		return fmt.Errorf("materializeBlob: %w", err)
+	} else if cloned {
+		return nil
+	}
+	r, err := openBlob(hash)
+	if err != nil {
+		return fmt.Errorf("materializeBlob: %w", err)
+	}
+	defer r.Close()
+	if err := writeStream(file, r); err != nil {
+		return fmt.Errorf("materializeBlob: %w", err)
+	}
+	return r.Close()
+}
+
+// cloneBlob materializes a large loose file blob by sharing the block-aligned contents of
+// its object file with the working directory file instead of copying them.
+// Returns false without error if the blob cannot be cloned and must be copied instead.
+func cloneBlob(hash string, file string) (bool, error) {
+	r, err := openLooseObject(hash)
+	if err != nil {
+		// packed blobs are not block-aligned and are always copied
+		return false, nil
+	}
+	defer r.Close()
+	src, ok := r.(*os.File)
+	if !ok {
+		// only blobs kept in files can be cloned
+		return false, nil
+	}
+	fileInfo, err := src.Stat()
+	if err != nil || fileInfo.Size()-int64(largeBlobHeaderSize) < largeObjectThreshold {
+		return false, nil
+	}
+	header := make([]byte, largeBlobHeaderSize)
+	if _, err := src.ReadAt(header, 0); err != nil || header[largeBlobHeaderSize-1] != blobHeaderDelim {
+		return false, nil
+	}
+	// cloning never reads the contents, so only the recorded size can be validated
+	contentsSize := fileInfo.Size() - int64(largeBlobHeaderSize)
+	if _, size, err := parseHeader(string(header[:largeBlobHeaderSize-1])); err != nil {
+		return false, &corruptObjectError{hash, err.Error()}
+	} else if int64(size) != contentsSize {
+		return false, &corruptObjectError{hash, fmt.Sprintf("header records %d bytes, found %d", size, contentsSize)}
+	}
+
+	if fileInfo, err := repoFS.Stat(file); err == nil && fileInfo.IsDir() {
+		return false, fmt.Errorf("cloneBlob: cannot overwrite directory '%v'", file)
+	}
+	dst, err := repoFS.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return false, fmt.Errorf("cloneBlob: %w", err)
+	}
+	defer dst.Close()
+	dstFile, ok := dst.(*os.File)
+	if !ok {
+		return false, nil
 	}
-	return header, contents[:bytesRead], f.Close()
+	if err := cloneFileRange(dstFile, src, int64(largeBlobHeaderSize), contentsSize); err != nil {
+		return false, nil
+	}
+	if err := dst.Close(); err != nil {
+		return false, fmt.Errorf("cloneBlob: %w", err)
+	}
+	return true, nil
+}
+
+// removeObject deletes a loose object and removes it from the object and commit
+// caches. Does nothing if the object does not exist.
+func removeObject(hash string) error {
+	objectCache.remove(hash)
+	commitCache.remove(hash)
+	s := getStorage()
+	if err := s.removeObject(hash); err != nil {
+		return fmt.Errorf("removeObject: %w", err)
+	}
+	return nil
 }
 
-// Get commit object given the hash of the commit blob.
+// Get commit object given the hash of the commit blob, with the files it tracks.
 // Returns an error if the blob is not a commit blob.
 func getCommit(hash string) (commit, error) {
+	hash, c, err := loadCommit(hash)
+	if err != nil {
+		return c, fmt.Errorf("getCommit: %w", err)
+	}
+	if c.Tree == "" || c.FileToBlob != nil {
+		return c, nil
+	}
+	if c.FileToBlob, err = readTree(c.Tree); err != nil {
+		return c, fmt.Errorf("getCommit: %w", err)
+	}
+	commitCache.add(hash, c)
+	return c, nil
+}
+
+// getCommitMetadata returns a commit without reading its tree, for walks of history that
+// only need the messages, timestamps, authors, and parents of commits. FileToBlob of a commit
+// that records its files as a tree is nil unless its files were already read.
+// Returns an error if the blob is not a commit blob.
+func getCommitMetadata(hash string) (commit, error) {
+	_, c, err := loadCommit(hash)
+	if err != nil {
+		return c, fmt.Errorf("getCommitMetadata: %w", err)
+	}
+	return c, nil
+}
+
+// loadCommit resolves the hash of a commit and decodes the commit, reading it only if it is
+// not already in the commit cache.
+func loadCommit(hash string) (string, commit, error) {
 	var c commit
 	var err error
-	if len(hash) < 40 {
+	if len(hash) < hashLength {
 		hash, err = resolveHash(hash)
 		if err != nil {
-			return c, fmt.Errorf("getCommit: could not resolve hash %v: %w", hash, err)
+			return hash, c, fmt.Errorf("loadCommit: could not resolve hash %v: %w", hash, err)
 		}
 	}
 
-	header, contents, err := readBlob(hash)
+	if c, ok := commitCache.get(hash); ok {
+		return hash, c, nil
+	}
+	obj, err := loadObject(hash)
 	if err != nil {
-		return c, fmt.Errorf("getCommit: %w", err)
+		return hash, c, fmt.Errorf("loadCommit: %w", err)
 	}
-	if header != "commit" {
-		return c, fmt.Errorf("getCommit: incorrect blob header, want 'commit', got '%v'", header)
+	if obj.header != "commit" {
+		return hash, c, fmt.Errorf("loadCommit: incorrect blob header, want 'commit', got '%v'", obj.header)
 	}
-	c, err = deserialize[commit](contents)
+	c, err = deserialize[commit](obj.contents)
 	if err != nil {
-		return c, fmt.Errorf("getCommit: %w", err)
+		return hash, c, fmt.Errorf("loadCommit: %w", err)
 	}
-	return c, nil
+	commitCache.add(hash, c)
+	return hash, c, nil
+}
+
+// writeBlob writes an object with the given header and contents and returns its hash.
+// Objects that already exist are not rewritten.
+func writeBlob(header string, b []byte) (string, error) {
+	hash, _, err := createBlob(header, b)
+	return hash, err
 }
 
-func writeBlob(header string, b []byte) error {
-	payload := []any{header, []byte{blobHeaderDelim}, b}
+// createBlob writes a blob unless it already exists, and returns its hash and whether it
+// was written.
+func createBlob(header string, b []byte) (string, bool, error) {
+	payload := blobPayload(header, b)
 	hash, err := getHash(payload)
 	if err != nil {
-		return err
+		return "", false, err
+	}
+	if ok, err := hasObject(hash); err != nil {
+		return "", false, err
+	} else if ok {
+		return hash, false, nil
+	}
+	return hash, true, writeLooseObject(hash, payload)
+}
+
+// errFileChanged reports a file whose size changed while its contents were streamed into a blob.
+var errFileChanged = errors.New("file changed while it was read")
+
+// streamBlob returns the hash of the blob with the given object type whose size bytes of
+// contents are read from r, without holding the contents in memory. If write is true, the blob
+// is also written as it is read unless it already exists. Returns whether it was written.
+func streamBlob(objType string, r io.Reader, size int64, write bool) (string, bool, error) {
+	h := sha1.New()
+	w := io.Writer(h)
+	var obj *fileObjectWriter
+	if write {
+		var err error
+		if obj, err = getStorage().createObject(); err != nil {
+			return "", false, fmt.Errorf("streamBlob: %w", err)
+		}
+		defer obj.discard()
+		w = io.MultiWriter(obj, h)
+	}
+	if _, err := io.WriteString(w, blobHeader(objType, size)); err != nil {
+		return "", false, fmt.Errorf("streamBlob: %w", err)
+	}
+	if _, err := w.Write([]byte{blobHeaderDelim}); err != nil {
+		return "", false, fmt.Errorf("streamBlob: %w", err)
+	}
+	n, err := io.Copy(w, r)
+	if err != nil {
+		return "", false, fmt.Errorf("streamBlob: %w", err)
+	}
+	if n != size {
+		return "", false, fmt.Errorf("streamBlob: read %d bytes, want %d: %w", n, size, errFileChanged)
+	}
+	hash := hex.EncodeToString(h.Sum(nil))
+	if !write {
+		return hash, false, nil
+	}
+	if ok, err := hasObject(hash); err != nil {
+		return "", false, fmt.Errorf("streamBlob: %w", err)
+	} else if ok {
+		return hash, false, nil
+	}
+	if err := obj.commit(hash); err != nil {
+		return "", false, fmt.Errorf("streamBlob: %w", err)
 	}
-	blobFile := filepath.Join(objectsDir, hash)
-	return writeContents(blobFile, payload)
+	return hash, true, nil
 }
 
 // resolveHash matches the given hash abbreviation and returns the corresponding a full
 // hash in the objects directory.
 func resolveHash(hash string) (string, error) {
-	blobFiles, err := getFilenames(objectsDir)
+	if !isHexPrefix(hash) {
+		return "", fmt.Errorf("resolveHash: no matching blobs found: %w", fs.ErrNotExist)
+	}
+	s := getStorage()
+	matched, err := s.findObjects(hash, 2)
 	if err != nil {
 		return "", fmt.Errorf("resolveHash: %w", err)
 	}
-	var matched []string
-	for _, file := range blobFiles {
-		if strings.HasPrefix(file, hash) {
-			matched = append(matched, file)
-		}
+	midx, err := openMultiPackIndex()
+	if err != nil {
+		return "", fmt.Errorf("resolveHash: %w", err)
+	}
+	if midx != nil {
+		matched = append(matched, midx.findPrefix(hash, 2)...)
+		slices.Sort(matched)
+		matched = slices.Compact(matched)
 	}
 	if len(matched) < 1 {
-		return "", errors.New("resolveHash: no matching blobs found")
+		return "", fmt.Errorf("resolveHash: no matching blobs found: %w", fs.ErrNotExist)
 	} else if len(matched) > 1 {
 		return "", errors.New("resolveHash: ambiguous hash prefix")
 	} else {
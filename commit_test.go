@@ -1,7 +1,10 @@
 package main
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"path/filepath"
 	"testing"
 	"time"
 )
@@ -21,6 +24,58 @@ func TestCommitString(t *testing.T) {
 	if expected != actual {
 		t.Fatalf("Commit hash does not match:\nwant %v\ngot %v", actual, expected)
 	}
+
+	c.Author, c.Committer = "Wug <wug@example.com>", "Notwug"
+	expected = fmt.Sprintf(
+		"commit %v\nAuthor: Wug <wug@example.com>\nCommit: Notwug\nDate: %v\ntest commit\n",
+		testCommitHash, localTestTime,
+	)
+	if actual := c.String(testCommitHash); expected != actual {
+		t.Fatalf("Commit identities do not match:\nwant %v\ngot %v", expected, actual)
+	}
+}
+
+func TestGetIdentity(t *testing.T) {
+	setupTestRepo(t)
+	if err := setConfig("user.name", "Wug"); err != nil {
+		t.Fatal(err)
+	}
+	if err := setConfig("user.email", "wug@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GITLET_AUTHOR_NAME", "")
+	t.Setenv("GITLET_AUTHOR_EMAIL", "")
+	t.Setenv("GITLET_COMMITTER_NAME", "Notwug")
+	t.Setenv("GITLET_COMMITTER_EMAIL", "")
+	for _, test := range []struct {
+		role     string
+		expected string
+	}{
+		{authorRole, "Wug <wug@example.com>"},
+		{committerRole, "Notwug <wug@example.com>"},
+	} {
+		if identity, err := getIdentity(test.role); err != nil || identity != test.expected {
+			t.Errorf("getIdentity(%v) = %q, %v, want %q", test.role, identity, err, test.expected)
+		}
+	}
+}
+
+func TestGetIdentityUnknown(t *testing.T) {
+	setupTestRepo(t)
+	t.Setenv("USER", "")
+	t.Setenv("GITLET_AUTHOR_NAME", "")
+	if identity, err := getIdentity(authorRole); !errors.Is(err, errUnknownIdentity) {
+		t.Fatalf("getIdentity without a name = %q, %v, want errUnknownIdentity", identity, err)
+	}
+	if err := writeContents("wug.txt", []string{"This is a wug"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stageFile("wug.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := newCommit("add wug"); !errors.Is(err, errUnknownIdentity) {
+		t.Errorf("Commit without a name should fail with errUnknownIdentity, got %v", err)
+	}
 }
 
 func TestParseBlobHeader(t *testing.T) {
@@ -44,3 +99,248 @@ func TestGetCommit(t *testing.T) {
 		t.Fatalf("incorrect commit message: want 'initial commit', got %v", initialCommit.Message)
 	}
 }
+
+func TestOpenLargeObject(t *testing.T) {
+	setupTestRepo(t)
+	contents := bytes.Repeat([]byte("wug\n"), int(largeObjectThreshold))
+	if _, err := writeBlob("file", contents); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := getHash(blobPayload("file", contents))
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, err := openObject(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj.header != "file" {
+		t.Fatalf("want 'file', got '%v'", obj.header)
+	}
+	if !bytes.Equal(obj.contents, contents) {
+		t.Fatal("Memory-mapped object contents do not match the written blob.")
+	}
+	if err := obj.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := materializeBlob(hash, "wug.txt"); err != nil {
+		t.Fatal(err)
+	}
+	written, err := readFile("wug.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(written, contents) {
+		t.Fatal("Materialized file contents do not match the written blob.")
+	}
+}
+
+func TestLargeBlobAlignment(t *testing.T) {
+	setupTestRepo(t)
+	contents := bytes.Repeat([]byte{'w'}, int(largeObjectThreshold))
+	if _, err := writeBlob("file", contents); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := getHash(blobPayload("file", contents))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := readFile(objectPath(hash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored[largeBlobHeaderSize-1] != blobHeaderDelim || !bytes.Equal(stored[largeBlobHeaderSize:], contents) {
+		t.Fatal("Large blob contents are not block-aligned.")
+	}
+	header, err := parseBlobHeader(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header != "file" {
+		t.Fatalf("want 'file', got '%v'", header)
+	}
+	// cloned or copied, the materialized file matches the blob
+	if err := materializeBlob(hash, "wug.bin"); err != nil {
+		t.Fatal(err)
+	}
+	written, err := readFile("wug.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(written, contents) {
+		t.Fatal("Materialized large file contents do not match the written blob.")
+	}
+}
+
+func TestCorruptObject(t *testing.T) {
+	setupTestRepo(t)
+	objectFile := objectPath(initialCommitHash)
+	payload, err := readFile(objectFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, corrupted := range map[string][]byte{
+		"truncated":      payload[:len(payload)-1],
+		"modified":       bytes.Replace(payload, []byte("initial"), []byte("INITIAL"), 1),
+		"missing header": bytes.ReplaceAll(payload, []byte{blobHeaderDelim}, []byte{' '}),
+	} {
+		resetObjectCache()
+		if err := writeFile(objectFile, corrupted, 0644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := getCommit(initialCommitHash)
+		var corruptErr *corruptObjectError
+		if !errors.As(err, &corruptErr) {
+			t.Fatalf("%v object: want corruptObjectError, got %v", name, err)
+		}
+		if corruptErr.Hash != initialCommitHash {
+			t.Fatalf("%v object: incorrect corrupt hash: want %v, got %v", name, initialCommitHash, corruptErr.Hash)
+		}
+	}
+}
+
+// writeLegacyObject writes an object with a header recording only its type, as objects were
+// written before headers recorded sizes, and returns its hash.
+func writeLegacyObject(t *testing.T, objType string, contents []byte) string {
+	t.Helper()
+	payload := []any{objType, []byte{blobHeaderDelim}, contents}
+	hash, err := getHash(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := makeObjectDir(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeContents(file, payload); err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
+
+func TestLegacyObjectHeader(t *testing.T) {
+	setupTestRepo(t)
+	contents := []byte("This is a wug")
+	hash := writeLegacyObject(t, "file", contents)
+	header, actual, err := readBlob(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header != "file" || !bytes.Equal(actual, contents) {
+		t.Fatalf("Incorrect legacy blob: want 'file' '%s', got '%v' '%s'", contents, header, actual)
+	}
+}
+
+func TestLegacyRepository(t *testing.T) {
+	setupTestRepo(t)
+	// the head commit and its blob are written as they were before headers recorded sizes
+	blobHash := writeLegacyObject(t, "file", []byte("This is a wug"))
+	b, err := serialize(commit{
+		Message:    "add wug.txt",
+		Timestamp:  time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC).Unix(),
+		FileToBlob: map[string]string{"wug.txt": blobHash},
+		ParentUIDs: [2]string{initialCommitHash, ""},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	commitHash := writeLegacyObject(t, "commit", b)
+	if err := writeRef(filepath.Join(branchesDir, "main"), commitHash, "commit"); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFile("wug.txt", []byte("This is a wug"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// unchanged files are neither modified nor staged again
+	if entries, err := getStatusEntries(); err != nil || len(entries) != 0 {
+		t.Fatalf("Unchanged file of a legacy commit should not be modified: %v, %v", entries, err)
+	}
+	if err := stageFile("wug.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if index, err := readIndex(); err != nil || len(index) != 0 {
+		t.Fatalf("Unchanged file of a legacy commit should not be staged: %v, %v", index, err)
+	}
+	newHash, err := hashWorkingFile("wug.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := hasObject(newHash); err != nil || ok {
+		t.Fatalf("Blob written for an unchanged file should be removed: %v, %v", ok, err)
+	}
+
+	// changed files still are
+	if err := writeFile("wug.txt", []byte("This is not a wug"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if entries, err := getStatusEntries(); err != nil || len(entries) != 1 || entries[0].String() != " M wug.txt" {
+		t.Fatalf("Changed file of a legacy commit should be modified: %v, %v", entries, err)
+	}
+	if err := stageFile("wug.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if index, err := readIndex(); err != nil || len(index) != 1 {
+		t.Fatalf("Changed file of a legacy commit should be staged: %v, %v", index, err)
+	}
+}
+
+func TestLargeFileRoundTrip(t *testing.T) {
+	setupTestRepo(t)
+	// contents past any fixed read buffer, ending without a newline so truncation shows
+	files := map[string][]byte{
+		"page.bin":  bytes.Repeat([]byte("wug\x00\xff"), 4096/5+1),
+		"large.bin": bytes.Repeat([]byte("This is a large wug.\n"), int(largeObjectThreshold)/21+1)[1:],
+	}
+	for file, contents := range files {
+		if err := writeFile(file, contents, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := stageFile(file); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := newCommit("add large files"); err != nil {
+		t.Fatal(err)
+	}
+	if err := addBranch("other"); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkoutBranch("other"); err != nil {
+		t.Fatal(err)
+	}
+	files["theirs.bin"] = bytes.Repeat([]byte("This is their wug.\n"), int(largeObjectThreshold)/19+1)
+	if err := writeFile("theirs.bin", files["theirs.bin"], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := stageFile("theirs.bin"); err != nil {
+		t.Fatal(err)
+	}
+	if err := newCommit("add their large file"); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkoutBranch("main"); err != nil {
+		t.Fatal(err)
+	}
+	if err := repoFS.Remove("large.bin"); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkoutHeadCommit("large.bin"); err != nil {
+		t.Fatal(err)
+	}
+	commitInRepo(t, ".", "wug.txt", "This is a wug")
+	if err := mergeBranch("other", mergeOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	for file, contents := range files {
+		written, err := readFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(written, contents) {
+			t.Errorf("%v: want %v bytes, got %v bytes", file, len(contents), len(written))
+		}
+	}
+}
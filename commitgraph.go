@@ -0,0 +1,506 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The commit graph file records the parents, timestamp, and generation number of commits, so
+// ancestry queries and log traversal can walk history without reading every commit object.
+// A commit's generation is one more than the largest generation of its parents, starting
+// from 1 for a commit without parents, so a commit can only be an ancestor of commits with a
+// larger generation. The graph holds a commit only if it holds its parents. It is rewritten
+// with every reachable commit by gc, and commits written later are added as they are made.
+// Commits missing from the graph, such as fetched ones, are read from their objects instead.
+//
+//	commit graph: magic | version | count | (hash, generation, timestamp, parent positions)... |
+//	              checksum
+//
+// Commits added after gc are written to a chain of smaller layers stacked on the graph file,
+// so adding a commit does not rewrite the whole graph. The chain file lists the checksums of
+// the layer files, lowest first. A new commit is written as a layer of its own, merged with
+// the layers above any layer more than twice its size, so the chain stays logarithmically
+// short and each commit is rewritten only a logarithmic number of times. Parent positions
+// count the commits of the graph file and of every layer below, in the order they are stored.
+//
+//	commit graph layer: magic | version | count | lower count |
+//	                    (hash, generation, timestamp, parent positions)... | checksum
+const (
+	commitGraphMagic           string = "GCGR"
+	commitGraphLayerMagic      string = "GCGL"
+	commitGraphVersion         uint32 = 1
+	commitGraphHeaderSize      int    = 12
+	commitGraphLayerHeaderSize int    = 16
+	commitGraphRecordSize      int    = hashSize + 4 + 8 + 4 + 4
+	commitGraphNoParent        uint32 = math.MaxUint32
+	// generationInfinity is the generation of commits missing from the graph, which may be
+	// descendants of any commit.
+	generationInfinity uint32 = math.MaxUint32
+)
+
+var (
+	commitGraphFile      string = filepath.Join(gitletDir, "commit-graph")
+	commitGraphChainFile string = filepath.Join(gitletDir, "commit-graph-chain")
+	commitGraphsDir      string = filepath.Join(gitletDir, "commit-graphs")
+)
+
+// commitGraphEntry is what the commit graph records about a commit.
+type commitGraphEntry struct {
+	Parents    []string // Distinct parents of the commit.
+	Timestamp  int64
+	Generation uint32
+}
+
+// commitGraph maps commit hashes to their commit graph entries.
+type commitGraph map[string]commitGraphEntry
+
+// commitGraphFiles is the commit graph file and its chain of layers as read from disk.
+type commitGraphFiles struct {
+	Graph     commitGraph       // Commits of the graph file and every layer.
+	Hashes    []string          // Commits by their position.
+	Positions map[string]uint32 // Positions by commit.
+	Layers    []string          // Checksums of the layers, lowest first.
+	Counts    []int             // Number of commits in each layer.
+}
+
+var (
+	// loadedCommitGraph is the commit graph read by this process, if any.
+	loadedCommitGraph *commitGraphFiles
+	// loadedCommitGraphModTime and loadedCommitGraphSize identify the graph file it was read
+	// from, and loadedCommitGraphChain the contents of the chain file.
+	loadedCommitGraphModTime time.Time
+	loadedCommitGraphSize    int64
+	loadedCommitGraphChain   string
+	// commitGraphMu guards loadedCommitGraph.
+	commitGraphMu sync.Mutex
+)
+
+// readCommitGraph returns the commit graph, which is empty if the repository has none. The
+// graph is read once per process and read again if it is rewritten, so it must not be
+// modified.
+func readCommitGraph() (commitGraph, error) {
+	files, err := readCommitGraphFiles()
+	if err != nil {
+		return nil, fmt.Errorf("readCommitGraph: %w", err)
+	}
+	return files.Graph, nil
+}
+
+// readCommitGraphFiles returns the commit graph file and its layers, read once per process
+// and read again if either is rewritten.
+func readCommitGraphFiles() (*commitGraphFiles, error) {
+	commitGraphMu.Lock()
+	defer commitGraphMu.Unlock()
+	for {
+		var modTime time.Time
+		var size int64
+		fileInfo, err := repoFS.Stat(commitGraphFile)
+		if err == nil {
+			modTime, size = fileInfo.ModTime(), fileInfo.Size()
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("readCommitGraphFiles: %w", err)
+		}
+		chain, err := readContentsAsString(commitGraphChainFile)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("readCommitGraphFiles: %w", err)
+		}
+		if files := loadedCommitGraph; files != nil && loadedCommitGraphModTime.Equal(modTime) &&
+			loadedCommitGraphSize == size && loadedCommitGraphChain == chain {
+			return files, nil
+		}
+		files, err := decodeCommitGraphFiles(fileInfo != nil, chain)
+		if errors.Is(err, fs.ErrNotExist) {
+			// a layer was merged or collected after the chain was read, so it is read again
+			newChain, chainErr := readContentsAsString(commitGraphChainFile)
+			if chainErr != nil && !errors.Is(chainErr, fs.ErrNotExist) {
+				return nil, fmt.Errorf("readCommitGraphFiles: %w", chainErr)
+			}
+			if newChain != chain {
+				continue
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("readCommitGraphFiles: %w", err)
+		}
+		loadedCommitGraph, loadedCommitGraphModTime, loadedCommitGraphSize, loadedCommitGraphChain =
+			files, modTime, size, chain
+		return files, nil
+	}
+}
+
+// decodeCommitGraphFiles reads the commit graph file, if the repository has one, and the
+// layers listed by the contents of the chain file.
+func decodeCommitGraphFiles(hasGraphFile bool, chain string) (*commitGraphFiles, error) {
+	files := &commitGraphFiles{Graph: make(commitGraph)}
+	if hasGraphFile {
+		b, err := readFile(commitGraphFile)
+		if err != nil {
+			return nil, fmt.Errorf("decodeCommitGraphFiles: %w", err)
+		}
+		if files.Hashes, err = decodeCommitGraphFile(b, files.Graph); err != nil {
+			return nil, fmt.Errorf("decodeCommitGraphFiles: %w", err)
+		}
+	}
+	for _, layer := range strings.Fields(chain) {
+		b, err := readFile(commitGraphLayerFile(layer))
+		if err != nil {
+			return nil, fmt.Errorf("decodeCommitGraphFiles: %w", err)
+		}
+		lowerCount := len(files.Hashes)
+		if files.Hashes, err = decodeCommitGraphLayer(b, files.Hashes, files.Graph); err != nil {
+			return nil, fmt.Errorf("decodeCommitGraphFiles: %w", err)
+		}
+		files.Layers = append(files.Layers, layer)
+		files.Counts = append(files.Counts, len(files.Hashes)-lowerCount)
+	}
+	files.Positions = make(map[string]uint32, len(files.Hashes))
+	for i, hash := range files.Hashes {
+		files.Positions[hash] = uint32(i)
+	}
+	return files, nil
+}
+
+// commitGraphLayerFile returns the path of the commit graph layer with the given checksum.
+func commitGraphLayerFile(layer string) string {
+	return filepath.Join(commitGraphsDir, layer+".graph")
+}
+
+// writeCommitGraph replaces the commit graph file and removes its layers.
+func writeCommitGraph(g commitGraph) error {
+	unlock, err := lockFile(commitGraphFile, "write commit graph")
+	if err != nil {
+		return fmt.Errorf("writeCommitGraph: %w", err)
+	}
+	defer unlock()
+	b, err := encodeCommitGraph(g)
+	if err != nil {
+		return fmt.Errorf("writeCommitGraph: %w", err)
+	}
+	if err := writeFileAtomic(commitGraphFile, b); err != nil {
+		return fmt.Errorf("writeCommitGraph: %w", err)
+	}
+	if err := repoFS.Remove(commitGraphChainFile); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("writeCommitGraph: %w", err)
+	}
+	if err := repoFS.RemoveAll(commitGraphsDir); err != nil {
+		return fmt.Errorf("writeCommitGraph: %w", err)
+	}
+	commitGraphMu.Lock()
+	loadedCommitGraph = nil
+	commitGraphMu.Unlock()
+	return nil
+}
+
+// encodeCommitGraph returns the commit graph file contents of a commit graph, with commits
+// sorted by hash and parents recorded by their position.
+func encodeCommitGraph(g commitGraph) ([]byte, error) {
+	b := make([]byte, commitGraphHeaderSize, commitGraphHeaderSize+len(g)*commitGraphRecordSize+hashSize)
+	copy(b, commitGraphMagic)
+	binary.BigEndian.PutUint32(b[4:], commitGraphVersion)
+	binary.BigEndian.PutUint32(b[8:], uint32(len(g)))
+	b, err := appendCommitGraphRecords(b, g, 0, nil)
+	if err != nil {
+		return nil, fmt.Errorf("encodeCommitGraph: %w", err)
+	}
+	sum := sha1.Sum(b)
+	return append(b, sum[:]...), nil
+}
+
+// encodeCommitGraphLayer returns the layer file contents of the commits of a layer stacked
+// on lowerCount commits, with commits sorted by hash and parents recorded by their position.
+// Parents outside the layer are looked up in lowerPositions.
+func encodeCommitGraphLayer(g commitGraph, lowerCount int, lowerPositions map[string]uint32) ([]byte, error) {
+	b := make([]byte, commitGraphLayerHeaderSize, commitGraphLayerHeaderSize+len(g)*commitGraphRecordSize+hashSize)
+	copy(b, commitGraphLayerMagic)
+	binary.BigEndian.PutUint32(b[4:], commitGraphVersion)
+	binary.BigEndian.PutUint32(b[8:], uint32(len(g)))
+	binary.BigEndian.PutUint32(b[12:], uint32(lowerCount))
+	lower := func(hash string) (uint32, bool) {
+		position, ok := lowerPositions[hash]
+		return position, ok && int(position) < lowerCount
+	}
+	b, err := appendCommitGraphRecords(b, g, lowerCount, lower)
+	if err != nil {
+		return nil, fmt.Errorf("encodeCommitGraphLayer: %w", err)
+	}
+	sum := sha1.Sum(b)
+	return append(b, sum[:]...), nil
+}
+
+// appendCommitGraphRecords appends the records of the commits of a graph stacked on
+// lowerCount commits to b, sorted by hash. Parents in the graph are recorded by their
+// position after the commits below it, whose positions are found by lower, which is nil if
+// there are none.
+func appendCommitGraphRecords(
+	b []byte, g commitGraph, lowerCount int, lower func(string) (uint32, bool),
+) ([]byte, error) {
+	hashes := sortedKeys(g)
+	positions := make(map[string]uint32, len(hashes))
+	for i, hash := range hashes {
+		positions[hash] = uint32(lowerCount + i)
+	}
+	for _, hash := range hashes {
+		entry := g[hash]
+		raw, err := hex.DecodeString(hash)
+		if err != nil || len(raw) != hashSize || len(entry.Parents) > 2 {
+			return nil, fmt.Errorf("appendCommitGraphRecords: invalid entry for commit '%v'", hash)
+		}
+		b = append(b, raw...)
+		b = binary.BigEndian.AppendUint32(b, entry.Generation)
+		b = binary.BigEndian.AppendUint64(b, uint64(entry.Timestamp))
+		for i := range 2 {
+			position := commitGraphNoParent
+			if i < len(entry.Parents) {
+				var ok bool
+				if position, ok = positions[entry.Parents[i]]; !ok && lower != nil {
+					position, ok = lower(entry.Parents[i])
+				}
+				if !ok {
+					return nil, fmt.Errorf("appendCommitGraphRecords: parent of commit '%v' is not in the graph", hash)
+				}
+			}
+			b = binary.BigEndian.AppendUint32(b, position)
+		}
+	}
+	return b, nil
+}
+
+// decodeCommitGraph returns the commit graph stored in commit graph file contents.
+// Returns an error if the contents are truncated or do not match their checksum.
+func decodeCommitGraph(b []byte) (commitGraph, error) {
+	g := make(commitGraph)
+	if _, err := decodeCommitGraphFile(b, g); err != nil {
+		return nil, fmt.Errorf("decodeCommitGraph: %w", err)
+	}
+	return g, nil
+}
+
+// decodeCommitGraphFile adds the commits stored in commit graph file contents to a graph,
+// and returns their hashes by position.
+// Returns an error if the contents are truncated or do not match their checksum.
+func decodeCommitGraphFile(b []byte, g commitGraph) ([]string, error) {
+	records, err := checkCommitGraphFile(b, commitGraphMagic, commitGraphHeaderSize)
+	if err != nil {
+		return nil, fmt.Errorf("decodeCommitGraphFile: %w", err)
+	}
+	hashes, err := decodeCommitGraphRecords(records, int(binary.BigEndian.Uint32(b[8:])), nil, g)
+	if err != nil {
+		return nil, fmt.Errorf("decodeCommitGraphFile: %w", err)
+	}
+	return hashes, nil
+}
+
+// decodeCommitGraphLayer adds the commits stored in layer file contents to a graph of the
+// commits below the layer, and returns the hashes of those commits and the layer's commits
+// by position.
+// Returns an error if the contents are truncated, do not match their checksum, or are
+// stacked on a different number of commits.
+func decodeCommitGraphLayer(b []byte, lowerHashes []string, g commitGraph) ([]string, error) {
+	records, err := checkCommitGraphFile(b, commitGraphLayerMagic, commitGraphLayerHeaderSize)
+	if err != nil {
+		return nil, fmt.Errorf("decodeCommitGraphLayer: %w", err)
+	}
+	if lowerCount := int(binary.BigEndian.Uint32(b[12:])); lowerCount != len(lowerHashes) {
+		return nil, errors.New("decodeCommitGraphLayer: commit graph layer does not match the layers below it")
+	}
+	hashes, err := decodeCommitGraphRecords(records, int(binary.BigEndian.Uint32(b[8:])), lowerHashes, g)
+	if err != nil {
+		return nil, fmt.Errorf("decodeCommitGraphLayer: %w", err)
+	}
+	return hashes, nil
+}
+
+// checkCommitGraphFile checks the magic, checksum, and version of commit graph or layer file
+// contents, and returns their records.
+func checkCommitGraphFile(b []byte, magic string, headerSize int) ([]byte, error) {
+	if len(b) < headerSize+hashSize || string(b[:4]) != magic {
+		return nil, errors.New("checkCommitGraphFile: commit graph is corrupt")
+	}
+	body, checksum := b[:len(b)-hashSize], b[len(b)-hashSize:]
+	if sum := sha1.Sum(body); !bytes.Equal(sum[:], checksum) {
+		return nil, errors.New("checkCommitGraphFile: commit graph does not match its checksum")
+	}
+	if version := binary.BigEndian.Uint32(body[4:]); version != commitGraphVersion {
+		return nil, fmt.Errorf("checkCommitGraphFile: unsupported commit graph version %v", version)
+	}
+	return body[headerSize:], nil
+}
+
+// decodeCommitGraphRecords adds count commit graph records to a graph of the commits
+// below them, and returns the hashes of those commits and the decoded ones by position.
+func decodeCommitGraphRecords(records []byte, count int, lowerHashes []string, g commitGraph) ([]string, error) {
+	if len(records) != count*commitGraphRecordSize {
+		return nil, errors.New("decodeCommitGraphRecords: commit graph is truncated")
+	}
+	hashes := slices.Grow(lowerHashes, count)
+	for i := range count {
+		hashes = append(hashes, hex.EncodeToString(records[i*commitGraphRecordSize:i*commitGraphRecordSize+hashSize]))
+	}
+	for i, hash := range hashes[len(lowerHashes):] {
+		r := records[i*commitGraphRecordSize+hashSize : (i+1)*commitGraphRecordSize]
+		entry := commitGraphEntry{
+			Generation: binary.BigEndian.Uint32(r),
+			Timestamp:  int64(binary.BigEndian.Uint64(r[4:])),
+		}
+		for _, position := range []uint32{binary.BigEndian.Uint32(r[12:]), binary.BigEndian.Uint32(r[16:])} {
+			if position == commitGraphNoParent {
+				continue
+			}
+			if int(position) >= len(hashes) {
+				return nil, fmt.Errorf("decodeCommitGraphRecords: parent of commit '%v' is out of range", hash)
+			}
+			entry.Parents = append(entry.Parents, hashes[position])
+		}
+		g[hash] = entry
+	}
+	return hashes, nil
+}
+
+// lookup returns the commit graph entry of a commit. A commit missing from the graph is read
+// from its object and has an infinite generation.
+func (g commitGraph) lookup(hash string) (commitGraphEntry, error) {
+	if entry, ok := g[hash]; ok {
+		return entry, nil
+	}
+	c, err := getCommitMetadata(hash)
+	if err != nil {
+		return commitGraphEntry{}, fmt.Errorf("lookup: %w", err)
+	}
+	return commitGraphEntry{Parents: getParents(c), Timestamp: c.Timestamp, Generation: generationInfinity}, nil
+}
+
+// add adds a commit to a commit graph if every parent of the commit is in
+// it, computing the commit's generation. Reports whether the commit was added.
+func (g commitGraph) add(hash string, c commit) bool {
+	entry, ok := g.newEntry(c)
+	if ok {
+		g[hash] = entry
+	}
+	return ok
+}
+
+// newEntry returns the commit graph entry of a commit whose parents are all in a commit
+// graph, computing its generation. Reports whether every parent is in the graph.
+func (g commitGraph) newEntry(c commit) (commitGraphEntry, bool) {
+	entry := commitGraphEntry{Parents: getParents(c), Timestamp: c.Timestamp, Generation: 1}
+	for _, parent := range entry.Parents {
+		parentEntry, ok := g[parent]
+		if !ok {
+			return commitGraphEntry{}, false
+		}
+		entry.Generation = max(entry.Generation, parentEntry.Generation+1)
+	}
+	return entry, true
+}
+
+// addToCommitGraph adds a newly written commit to the commit graph, unless it is already in
+// the graph or one of its parents is not. The commit is written to a new top layer, merged
+// with the layers it outgrows.
+func addToCommitGraph(hash string, c commit) error {
+	unlock, err := lockFile(commitGraphFile, "write commit graph")
+	if err != nil {
+		return fmt.Errorf("addToCommitGraph: %w", err)
+	}
+	defer unlock()
+	files, err := readCommitGraphFiles()
+	if err != nil {
+		return fmt.Errorf("addToCommitGraph: %w", err)
+	}
+	if _, ok := files.Graph[hash]; ok {
+		return nil
+	}
+	entry, ok := files.Graph.newEntry(c)
+	if !ok {
+		return nil
+	}
+	layer := commitGraph{hash: entry}
+	layers, lowerCount := files.Layers, len(files.Hashes)
+	for len(layers) > 0 && files.Counts[len(layers)-1] <= 2*len(layer) {
+		count := files.Counts[len(layers)-1]
+		for _, merged := range files.Hashes[lowerCount-count : lowerCount] {
+			layer[merged] = files.Graph[merged]
+		}
+		layers, lowerCount = layers[:len(layers)-1], lowerCount-count
+	}
+	b, err := encodeCommitGraphLayer(layer, lowerCount, files.Positions)
+	if err != nil {
+		return fmt.Errorf("addToCommitGraph: %w", err)
+	}
+	sum := sha1.Sum(b)
+	name := hex.EncodeToString(sum[:])
+	if err := repoFS.MkdirAll(commitGraphsDir, 0755); err != nil {
+		return fmt.Errorf("addToCommitGraph: %w", err)
+	}
+	if err := writeFileAtomic(commitGraphLayerFile(name), b); err != nil {
+		return fmt.Errorf("addToCommitGraph: %w", err)
+	}
+	chain := append(slices.Clone(layers), name)
+	if err := writeFileAtomic(commitGraphChainFile, []byte(strings.Join(chain, "\n")+"\n")); err != nil {
+		return fmt.Errorf("addToCommitGraph: %w", err)
+	}
+	// readers that find a merged layer missing read the new chain instead
+	for _, merged := range files.Layers[len(layers):] {
+		if err := repoFS.Remove(commitGraphLayerFile(merged)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("addToCommitGraph: %w", err)
+		}
+	}
+	return nil
+}
+
+// generateCommitGraph writes a commit graph of the given commits, which must include the
+// ancestors of every one of them.
+func generateCommitGraph(commitHashes []string) error {
+	commits := make(map[string]commit, len(commitHashes))
+	for _, hash := range commitHashes {
+		c, err := getCommitMetadata(hash)
+		if err != nil {
+			return fmt.Errorf("generateCommitGraph: %w", err)
+		}
+		commits[hash] = c
+	}
+	// add commits once their parents are added, oldest first
+	g := make(commitGraph, len(commits))
+	children := make(map[string][]string)
+	waiting := make(map[string]int)
+	var ready []string
+	for _, hash := range sortedKeys(commits) {
+		parents := getParents(commits[hash])
+		for _, parent := range parents {
+			if _, ok := commits[parent]; !ok {
+				return fmt.Errorf("generateCommitGraph: parent of commit '%v' is missing", hash)
+			}
+			children[parent] = append(children[parent], hash)
+		}
+		if waiting[hash] = len(parents); len(parents) == 0 {
+			ready = append(ready, hash)
+		}
+	}
+	for len(ready) > 0 {
+		hash := ready[0]
+		ready = ready[1:]
+		g.add(hash, commits[hash])
+		for _, child := range children[hash] {
+			if waiting[child]--; waiting[child] == 0 {
+				ready = append(ready, child)
+			}
+		}
+	}
+	if len(g) != len(commits) {
+		return errors.New("generateCommitGraph: commit history has a cycle")
+	}
+	if err := writeCommitGraph(g); err != nil {
+		return fmt.Errorf("generateCommitGraph: %w", err)
+	}
+	return nil
+}
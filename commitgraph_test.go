@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// setupMergedHistory commits on two branches and merges them, returning the commit before
+// the branches split, the commit on the other branch, and the merge commit.
+func setupMergedHistory(t *testing.T) (string, string, string) {
+	t.Helper()
+	base := commitInRepo(t, ".", "wug.txt", "This is a wug")
+	if err := addBranch("other"); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkoutBranch("other"); err != nil {
+		t.Fatal(err)
+	}
+	other := commitInRepo(t, ".", "theirs.txt", "This is their wug")
+	if err := checkoutBranch("main"); err != nil {
+		t.Fatal(err)
+	}
+	commitInRepo(t, ".", "ours.txt", "This is our wug")
+	if err := mergeBranch("other", mergeOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	merge, err := getHeadCommitHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base, other, merge
+}
+
+func TestCommitGraphEncoding(t *testing.T) {
+	g := commitGraph{
+		initialCommitHash:                          {Generation: 1},
+		"1111111111111111111111111111111111111111": {Parents: []string{initialCommitHash}, Timestamp: 1, Generation: 2},
+		"2222222222222222222222222222222222222222": {Parents: []string{initialCommitHash}, Timestamp: 2, Generation: 2},
+		"3333333333333333333333333333333333333333": {
+			Parents:    []string{"2222222222222222222222222222222222222222", "1111111111111111111111111111111111111111"},
+			Timestamp:  3,
+			Generation: 3,
+		},
+	}
+	b, err := encodeCommitGraph(g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded, err := decodeCommitGraph(b); err != nil || !reflect.DeepEqual(decoded, g) {
+		t.Fatalf("Incorrect commit graph decoded: want %v, got %v, %v", g, decoded, err)
+	}
+	b[commitGraphHeaderSize] ^= 0xff
+	if _, err := decodeCommitGraph(b); err == nil {
+		t.Fatal("Decoding a commit graph that does not match its checksum should fail")
+	}
+	delete(g, initialCommitHash)
+	if _, err := encodeCommitGraph(g); err == nil {
+		t.Fatal("Encoding a commit graph without a parent of one of its commits should fail")
+	}
+}
+
+func TestCommitGraphAddsCommits(t *testing.T) {
+	setupTestRepo(t)
+	base, other, merge := setupMergedHistory(t)
+	g, err := readCommitGraph()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for hash, generation := range map[string]uint32{initialCommitHash: 1, base: 2, other: 3, merge: 4} {
+		if g[hash].Generation != generation {
+			t.Errorf("Incorrect generation of %v: want %v, got %+v", hash, generation, g[hash])
+		}
+	}
+	if parents := g[merge].Parents; len(parents) != 2 || parents[1] != other {
+		t.Fatalf("Incorrect parents of the merge commit: %v", parents)
+	}
+}
+
+func TestCommitGraphGarbageCollection(t *testing.T) {
+	setupTestRepo(t)
+	base, other, merge := setupMergedHistory(t)
+	// history made without a commit graph is left out of it until gc
+	if err := repoFS.Remove(commitGraphChainFile); err != nil {
+		t.Fatal(err)
+	}
+	head := commitInRepo(t, ".", "wug.txt", "This is a changed wug")
+	if _, err := repoFS.Stat(commitGraphChainFile); err == nil {
+		t.Fatal("Commit with parents missing from the graph should not be added to it")
+	}
+	for _, test := range []struct {
+		ancestor, commit string
+		expected         bool
+	}{
+		{base, head, true},
+		{other, head, true},
+		{head, merge, false},
+		{other, base, false},
+	} {
+		if ok, err := isAncestor(test.ancestor, test.commit); err != nil || ok != test.expected {
+			t.Errorf("isAncestor(%v, %v) without graph = %v, %v, want %v", test.ancestor, test.commit, ok, err, test.expected)
+		}
+	}
+
+	if _, _, err := collectGarbage(time.Hour, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	g, err := readCommitGraph()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g[head].Generation != 5 || len(g) != 6 {
+		t.Fatalf("gc should write a graph of every reachable commit: %v", g)
+	}
+	ancestors, err := getAncestors(head)
+	if err != nil || len(ancestors) != 6 {
+		t.Fatalf("Incorrect ancestors from the commit graph: %v, %v", ancestors, err)
+	}
+	if ok, err := isAncestor(other, base); err != nil || ok {
+		t.Fatalf("isAncestor(%v, %v) with graph = %v, %v, want false", other, base, ok, err)
+	}
+	if splitPoint, err := findSplitPoint(head, other); err != nil || splitPoint != other {
+		t.Fatalf("Incorrect split point: want %v, got %v, %v", other, splitPoint, err)
+	}
+}
+
+func TestCommitGraphLayerEncoding(t *testing.T) {
+	lower := commitGraph{initialCommitHash: {Generation: 1}}
+	lowerPositions := map[string]uint32{initialCommitHash: 0}
+	layer := commitGraph{
+		"1111111111111111111111111111111111111111": {Parents: []string{initialCommitHash}, Timestamp: 1, Generation: 2},
+		"2222222222222222222222222222222222222222": {
+			Parents:    []string{"1111111111111111111111111111111111111111"},
+			Timestamp:  2,
+			Generation: 3,
+		},
+	}
+	b, err := encodeCommitGraphLayer(layer, 1, lowerPositions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := maps.Clone(lower)
+	hashes, err := decodeCommitGraphLayer(b, []string{initialCommitHash}, g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := maps.Clone(lower)
+	maps.Copy(expected, layer)
+	if len(hashes) != 3 || !reflect.DeepEqual(g, expected) {
+		t.Fatalf("Incorrect commit graph layer decoded: %v, %v", hashes, g)
+	}
+	if _, err := decodeCommitGraphLayer(b, nil, make(commitGraph)); err == nil {
+		t.Fatal("Decoding a layer stacked on other commits should fail")
+	}
+	if _, err := encodeCommitGraphLayer(layer, 0, nil); err == nil {
+		t.Fatal("Encoding a layer without a parent of one of its commits should fail")
+	}
+}
+
+func TestCommitGraphChain(t *testing.T) {
+	setupTestRepo(t)
+	hashes := []string{initialCommitHash}
+	for i := range 20 {
+		hashes = append(hashes, commitInRepo(t, ".", "wug.txt", fmt.Sprintf("This is wug %v", i)))
+		files, err := readCommitGraphFiles()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// every layer is more than twice the size of the layer above it
+		for j := 1; j < len(files.Counts); j++ {
+			if files.Counts[j-1] <= 2*files.Counts[j] {
+				t.Fatalf("Layers were not merged after %v commits: %v", len(hashes), files.Counts)
+			}
+		}
+		entries, err := repoFS.ReadDir(commitGraphsDir)
+		if err != nil || len(entries) != len(files.Layers) {
+			t.Fatalf("Merged layers should be removed: %v layers in the chain, %v files, %v", len(files.Layers), len(entries), err)
+		}
+	}
+	g, err := readCommitGraph()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g) != len(hashes) {
+		t.Fatalf("Commit graph should hold every commit: want %v, got %v", len(hashes), len(g))
+	}
+	for i, hash := range hashes {
+		if g[hash].Generation != uint32(i+1) {
+			t.Errorf("Incorrect generation of commit %v: want %v, got %+v", i, i+1, g[hash])
+		}
+	}
+
+	if _, _, err := collectGarbage(time.Hour, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repoFS.Stat(commitGraphChainFile); !os.IsNotExist(err) {
+		t.Fatalf("gc should replace the layers with a single graph file: %v", err)
+	}
+	if after, err := readCommitGraph(); err != nil || !reflect.DeepEqual(after, g) {
+		t.Fatalf("gc should keep every commit in the graph: %v, %v", after, err)
+	}
+}
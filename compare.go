@@ -0,0 +1,151 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"slices"
+)
+
+// comparison describes how two commits differ.
+type comparison struct {
+	Ahead   []string // Commits reachable only from the first commit, newest first.
+	Behind  []string // Commits reachable only from the second commit, newest first.
+	Changes []fileChange
+}
+
+// fileChange is a file that differs between two commits: added ('A'), deleted ('D'),
+// or modified ('M') going from the first commit to the second.
+type fileChange struct {
+	Status byte
+	File   string
+}
+
+// compareCommits finds the commits each of two commits has that the other lacks,
+// and the files that differ between them.
+func compareCommits(commitHash1 string, commitHash2 string) (comparison, error) {
+	var result comparison
+	ancestors1, err := getAncestors(commitHash1)
+	if err != nil {
+		return result, fmt.Errorf("compareCommits: %w", err)
+	}
+	ancestors2, err := getAncestors(commitHash2)
+	if err != nil {
+		return result, fmt.Errorf("compareCommits: %w", err)
+	}
+	onlyIn := func(ancestors, other map[string]bool) ([]string, error) {
+		var hashes []string
+		timestamps := make(map[string]int64)
+		for hash := range ancestors {
+			if other[hash] {
+				continue
+			}
+			c, err := getCommitMetadata(hash)
+			if err != nil {
+				return nil, err
+			}
+			hashes = append(hashes, hash)
+			timestamps[hash] = c.Timestamp
+		}
+		slices.Sort(hashes)
+		slices.SortStableFunc(hashes, func(a, b string) int {
+			return cmp.Compare(timestamps[b], timestamps[a])
+		})
+		return hashes, nil
+	}
+	if result.Ahead, err = onlyIn(ancestors1, ancestors2); err != nil {
+		return result, fmt.Errorf("compareCommits: %w", err)
+	}
+	if result.Behind, err = onlyIn(ancestors2, ancestors1); err != nil {
+		return result, fmt.Errorf("compareCommits: %w", err)
+	}
+
+	commit1, err := getCommitMetadata(commitHash1)
+	if err != nil {
+		return result, fmt.Errorf("compareCommits: %w", err)
+	}
+	commit2, err := getCommitMetadata(commitHash2)
+	if err != nil {
+		return result, fmt.Errorf("compareCommits: %w", err)
+	}
+	if result.Changes, err = diffCommitFiles(commit1, commit2); err != nil {
+		return result, fmt.Errorf("compareCommits: %w", err)
+	}
+	return result, nil
+}
+
+// diffFileToBlob returns the files added, deleted, or modified going from one file to blob
+// mapping to another, sorted by file.
+func diffFileToBlob(from map[string]string, to map[string]string) []fileChange {
+	var changes []fileChange
+	for _, file := range sortedKeys(from) {
+		toHash, ok := to[file]
+		if !ok {
+			changes = append(changes, fileChange{'D', file})
+		} else if toHash != from[file] {
+			changes = append(changes, fileChange{'M', file})
+		}
+	}
+	for _, file := range sortedKeys(to) {
+		if _, ok := from[file]; !ok {
+			changes = append(changes, fileChange{'A', file})
+		}
+	}
+	slices.SortFunc(changes, func(a, b fileChange) int { return cmp.Compare(a.File, b.File) })
+	return changes
+}
+
+// printComparison prints how many commits each of two revisions has that the other lacks,
+// lists those commits, and summarizes the files that differ between them.
+func printComparison(rev1 string, rev2 string) error {
+	commitHashes := make([]string, 2)
+	for i, rev := range []string{rev1, rev2} {
+		hash, err := resolveRevision(rev)
+		if errors.Is(err, fs.ErrNotExist) {
+			exitf("No commit or branch named '%v' exists.", rev)
+		} else if err != nil {
+			return fmt.Errorf("printComparison: %w", err)
+		}
+		commitHashes[i] = hash
+	}
+	result, err := compareCommits(commitHashes[0], commitHashes[1])
+	if err != nil {
+		return fmt.Errorf("printComparison: %w", err)
+	}
+
+	log.Printf(
+		"%v is %v ahead and %v behind %v.\n",
+		rev1, pluralize(len(result.Ahead), "commit"), pluralize(len(result.Behind), "commit"), rev2,
+	)
+	for _, side := range []struct {
+		rev    string
+		hashes []string
+	}{{rev1, result.Ahead}, {rev2, result.Behind}} {
+		if len(side.hashes) == 0 {
+			continue
+		}
+		log.Printf("\n=== Only in %v ===\n", side.rev)
+		for _, hash := range side.hashes {
+			c, err := getCommitMetadata(hash)
+			if err != nil {
+				return fmt.Errorf("printComparison: %w", err)
+			}
+			log.Println(c.Oneline(hash))
+		}
+	}
+	log.Printf("\n=== Files changed from %v to %v ===\n", rev1, rev2)
+	for _, change := range result.Changes {
+		log.Printf("%c %v\n", change.Status, change.File)
+	}
+	return nil
+}
+
+// pluralize formats a count of things, adding an "s" to the thing unless there is exactly one.
+func pluralize(n int, thing string) string {
+	if n == 1 {
+		return fmt.Sprintf("%v %v", n, thing)
+	}
+	return fmt.Sprintf("%v %vs", n, thing)
+}
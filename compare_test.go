@@ -0,0 +1,35 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCompareCommits(t *testing.T) {
+	setupTestRepo(t)
+	commitInRepo(t, ".", "a.txt", "A")
+	if err := addBranch("other"); err != nil {
+		t.Fatal(err)
+	}
+	mainHash := commitInRepo(t, ".", "b.txt", "B")
+	if err := checkoutBranch("other"); err != nil {
+		t.Fatal(err)
+	}
+	first := commitInRepo(t, ".", "a.txt", "!A")
+	otherHash := commitInRepo(t, ".", "c.txt", "C")
+
+	result, err := compareCommits(mainHash, otherHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result.Ahead, []string{mainHash}) {
+		t.Fatalf("Incorrect commits ahead: want [%v], got %v", mainHash, result.Ahead)
+	}
+	if len(result.Behind) != 2 || !reflect.DeepEqual(map[string]bool{result.Behind[0]: true, result.Behind[1]: true}, map[string]bool{first: true, otherHash: true}) {
+		t.Fatalf("Incorrect commits behind: want [%v %v], got %v", otherHash, first, result.Behind)
+	}
+	expectedChanges := []fileChange{{'M', "a.txt"}, {'D', "b.txt"}, {'A', "c.txt"}}
+	if !reflect.DeepEqual(result.Changes, expectedChanges) {
+		t.Fatalf("Incorrect file changes: want %v, got %v", expectedChanges, result.Changes)
+	}
+}
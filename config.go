@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var configFile = filepath.Join(gitletDir, "config")
+
+// Map between dotted configuration keys, such as "gc.reflogExpire", and their values.
+type configMap map[string]string
+
+// Default values of configuration keys that have one.
+var configDefaults = configMap{
+	"gc.reflogExpire":            "90d",
+	"gc.reflogExpireUnreachable": "30d",
+	"gc.pruneExpire":             "2w",
+	"lfs.remote":                 "origin",
+}
+
+// readConfig reads the repository configuration.
+// Returns an empty configuration if none has been written.
+func readConfig() (configMap, error) {
+	b, err := readFile(configFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return make(configMap), nil
+	} else if err != nil {
+		return nil, fmt.Errorf("readConfig: %w", err)
+	}
+	config, err := deserialize[configMap](b)
+	if err != nil {
+		return nil, fmt.Errorf("readConfig: %w", err)
+	}
+	return config, nil
+}
+
+// writeConfig writes the repository configuration.
+func writeConfig(config configMap) error {
+	b, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return fmt.Errorf("writeConfig: %w", err)
+	}
+	if err := writeFileAtomic(configFile, append(b, '\n')); err != nil {
+		return fmt.Errorf("writeConfig: %w", err)
+	}
+	return nil
+}
+
+// validateConfigKey checks that a key has the form "section.name".
+func validateConfigKey(key string) error {
+	section, name, ok := strings.Cut(key, ".")
+	if !ok || section == "" || name == "" || strings.ContainsAny(key, " \t\n") {
+		return fmt.Errorf("validateConfigKey: invalid key '%v', want 'section.name'", key)
+	}
+	return nil
+}
+
+// getConfig returns the configured value of a key, or its default if it is not set.
+// Reports whether the key has a value.
+func getConfig(key string) (string, bool, error) {
+	config, err := readConfig()
+	if err != nil {
+		return "", false, fmt.Errorf("getConfig: %w", err)
+	}
+	if value, ok := config[key]; ok {
+		return value, true, nil
+	}
+	value, ok := configDefaults[key]
+	return value, ok, nil
+}
+
+// setConfig sets the value of a key.
+func setConfig(key string, value string) error {
+	if err := validateConfigKey(key); err != nil {
+		return fmt.Errorf("setConfig: %w", err)
+	}
+	config, err := readConfig()
+	if err != nil {
+		return fmt.Errorf("setConfig: %w", err)
+	}
+	config[key] = value
+	if err := writeConfig(config); err != nil {
+		return fmt.Errorf("setConfig: %w", err)
+	}
+	return nil
+}
+
+// unsetConfig removes the value of a key, reverting it to its default.
+// Reports whether the key was set.
+func unsetConfig(key string) (bool, error) {
+	config, err := readConfig()
+	if err != nil {
+		return false, fmt.Errorf("unsetConfig: %w", err)
+	}
+	if _, ok := config[key]; !ok {
+		return false, nil
+	}
+	delete(config, key)
+	if err := writeConfig(config); err != nil {
+		return false, fmt.Errorf("unsetConfig: %w", err)
+	}
+	return true, nil
+}
+
+// Expiry age of entries that never expire.
+const expireNever time.Duration = math.MaxInt64
+
+// parseExpiry parses an expiry age such as "90d", "2w", "12h", "now", or "never".
+// Ages without a day or week suffix are parsed as Go durations.
+func parseExpiry(s string) (time.Duration, error) {
+	switch s {
+	case "never", "false":
+		return expireNever, nil
+	case "now", "all":
+		return 0, nil
+	}
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if count, ok := strings.CutSuffix(s, suffix); ok {
+			n, err := strconv.Atoi(count)
+			if err != nil || n < 0 {
+				return 0, fmt.Errorf("parseExpiry: invalid expiry '%v'", s)
+			}
+			return time.Duration(n) * unit, nil
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("parseExpiry: invalid expiry '%v'", s)
+	}
+	return d, nil
+}
+
+// getExpiryConfig returns the expiry age configured for a key, or its default.
+func getExpiryConfig(key string) (time.Duration, error) {
+	value, _, err := getConfig(key)
+	if err != nil {
+		return 0, fmt.Errorf("getExpiryConfig: %w", err)
+	}
+	d, err := parseExpiry(value)
+	if err != nil {
+		return 0, fmt.Errorf("getExpiryConfig: %v: %w", key, err)
+	}
+	return d, nil
+}
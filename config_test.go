@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConfig(t *testing.T) {
+	setupTestRepo(t)
+	if value, ok, err := getConfig("gc.reflogExpire"); err != nil || !ok || value != "90d" {
+		t.Fatalf("Incorrect default value: %v, %v, %v", value, ok, err)
+	}
+	if err := setConfig("gc.reflogExpire", "2w"); err != nil {
+		t.Fatal(err)
+	}
+	if d, err := getExpiryConfig("gc.reflogExpire"); err != nil || d != 14*24*time.Hour {
+		t.Fatalf("Incorrect configured expiry: %v, %v", d, err)
+	}
+	if ok, err := unsetConfig("gc.reflogExpire"); err != nil || !ok {
+		t.Fatalf("Could not unset value: %v, %v", ok, err)
+	}
+	if value, _, err := getConfig("gc.reflogExpire"); err != nil || value != "90d" {
+		t.Fatalf("Incorrect value after unsetting: %v, %v", value, err)
+	}
+	if _, ok, err := getConfig("user.name"); err != nil || ok {
+		t.Fatalf("Unset key without default has a value: %v, %v", ok, err)
+	}
+	if err := setConfig("reflogExpire", "2w"); err == nil {
+		t.Fatal("Key without a section was accepted.")
+	}
+}
+
+func TestParseExpiry(t *testing.T) {
+	for s, expected := range map[string]time.Duration{
+		"90d":   90 * 24 * time.Hour,
+		"2w":    14 * 24 * time.Hour,
+		"12h":   12 * time.Hour,
+		"now":   0,
+		"never": expireNever,
+	} {
+		if d, err := parseExpiry(s); err != nil || d != expected {
+			t.Fatalf("Incorrect expiry for %v: want %v, got %v, %v", s, expected, d, err)
+		}
+	}
+	for _, s := range []string{"", "-1d", "soon", "xd"} {
+		if _, err := parseExpiry(s); err == nil {
+			t.Fatalf("Invalid expiry %v was accepted.", s)
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"path/filepath"
+	"strings"
+)
+
+var mergeConflictsFile = filepath.Join(gitletDir, "MERGE_CONFLICTS")
+
+// Markers written around the two sides of a conflicted file by mergeBranch.
+const (
+	conflictStartMarker     = "<<<<<<< HEAD\n"
+	conflictSeparatorMarker = "======="
+	conflictEndMarker       = ">>>>>>>"
+)
+
+// writeConflictFile writes a conflicted file holding our and their versions between conflict
+// markers, streaming each version from its blob so that large files are never read into
+// memory. A version whose blob hash is empty, because that side deleted the file, is empty.
+func writeConflictFile(file string, oursHash string, theirsHash string) error {
+	readers := []io.Reader{strings.NewReader(conflictStartMarker)}
+	for i, hash := range []string{oursHash, theirsHash} {
+		if i == 1 {
+			readers = append(readers, strings.NewReader(conflictSeparatorMarker))
+		}
+		if hash == "" {
+			continue
+		}
+		r, err := openBlob(hash)
+		if err != nil {
+			return fmt.Errorf("writeConflictFile: %w", err)
+		}
+		defer r.Close()
+		readers = append(readers, r)
+	}
+	readers = append(readers, strings.NewReader(conflictEndMarker))
+	if err := writeStream(file, io.MultiReader(readers...)); err != nil {
+		return fmt.Errorf("writeConflictFile: %w", err)
+	}
+	return nil
+}
+
+// mergeConflict records the three versions of a file that conflicted in a merge.
+// Blob hashes are empty for a side where the file does not exist.
+type mergeConflict struct {
+	File   string
+	Base   string // Blob of the file at the split point.
+	Ours   string // Blob of the file in the current branch.
+	Theirs string // Blob of the file in the merged branch.
+}
+
+// mergeState records the conflicts of the last merge until they are resolved by a commit,
+// or abandoned by checking out or resetting to another commit, or by merge --abort.
+type mergeState struct {
+	Base      string // Split point commit.
+	Ours      string // Head commit of the current branch before the merge.
+	Theirs    string // Head commit of the merged branch.
+	Conflicts []mergeConflict
+}
+
+// readMergeState returns the recorded merge state, or nil if there are no unresolved conflicts.
+func readMergeState() (*mergeState, error) {
+	b, err := readFile(mergeConflictsFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("readMergeState: %w", err)
+	}
+	state, err := deserialize[mergeState](b)
+	if err != nil {
+		return nil, fmt.Errorf("readMergeState: %w", err)
+	}
+	return &state, nil
+}
+
+// writeMergeState records the conflicts of a merge.
+func writeMergeState(state mergeState) error {
+	b, err := serialize(state)
+	if err != nil {
+		return fmt.Errorf("writeMergeState: %w", err)
+	}
+	if err := writeFileAtomic(mergeConflictsFile, b); err != nil {
+		return fmt.Errorf("writeMergeState: %w", err)
+	}
+	return nil
+}
+
+// clearMergeState forgets the conflicts of the last merge, if any.
+func clearMergeState() error {
+	if err := repoFS.Remove(mergeConflictsFile); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("clearMergeState: %w", err)
+	}
+	return nil
+}
+
+// abortMerge abandons the last merge while its conflicts are unresolved, restoring the
+// commit, working directory, and empty staging area from before the merge.
+func abortMerge() error {
+	unlock, err := lockRepo("merge")
+	if err != nil {
+		return fmt.Errorf("abortMerge: %w", err)
+	}
+	defer unlock()
+	state, err := readMergeState()
+	if err != nil {
+		return fmt.Errorf("abortMerge: %w", err)
+	}
+	if state == nil {
+		exit("There is no merge to abort.")
+	}
+	if err := moveHead(state.Ours, "merge: abort"); err != nil {
+		return fmt.Errorf("abortMerge: %w", err)
+	}
+	return nil
+}
+
+// conflictSide is one version of a conflicted file in the conflicts export.
+type conflictSide struct {
+	Blob     string `json:"blob,omitempty"`
+	Contents string `json:"contents"`
+}
+
+// conflictHunk is a region of a working file between conflict markers. Offsets are byte
+// offsets into the working file, with end offsets exclusive.
+type conflictHunk struct {
+	Start       int    `json:"start"` // Offset of the start marker.
+	End         int    `json:"end"`   // Offset just past the end marker.
+	OursStart   int    `json:"oursStart"`
+	OursEnd     int    `json:"oursEnd"`
+	TheirsStart int    `json:"theirsStart"`
+	TheirsEnd   int    `json:"theirsEnd"`
+	Base        string `json:"base"`
+	Ours        string `json:"ours"`
+	Theirs      string `json:"theirs"`
+}
+
+// conflictExport describes a conflicted file for editor integrations.
+type conflictExport struct {
+	Path     string         `json:"path"`
+	Resolved bool           `json:"resolved"` // Whether the working file has no conflict markers left.
+	Base     conflictSide   `json:"base"`
+	Ours     conflictSide   `json:"ours"`
+	Theirs   conflictSide   `json:"theirs"`
+	Hunks    []conflictHunk `json:"hunks"`
+}
+
+// findConflictHunks returns the regions of a file between conflict markers.
+func findConflictHunks(contents []byte) []conflictHunk {
+	var hunks []conflictHunk
+	offset := 0
+	for {
+		start := bytes.Index(contents[offset:], []byte(conflictStartMarker))
+		if start < 0 {
+			return hunks
+		}
+		start += offset
+		oursStart := start + len(conflictStartMarker)
+		separator := bytes.Index(contents[oursStart:], []byte(conflictSeparatorMarker))
+		if separator < 0 {
+			return hunks
+		}
+		oursEnd := oursStart + separator
+		theirsStart := oursEnd + len(conflictSeparatorMarker)
+		end := bytes.Index(contents[theirsStart:], []byte(conflictEndMarker))
+		if end < 0 {
+			return hunks
+		}
+		theirsEnd := theirsStart + end
+		hunks = append(hunks, conflictHunk{
+			Start:       start,
+			End:         theirsEnd + len(conflictEndMarker),
+			OursStart:   oursStart,
+			OursEnd:     oursEnd,
+			TheirsStart: theirsStart,
+			TheirsEnd:   theirsEnd,
+			Ours:        string(contents[oursStart:oursEnd]),
+			Theirs:      string(contents[theirsStart:theirsEnd]),
+		})
+		offset = theirsEnd + len(conflictEndMarker)
+	}
+}
+
+// exportConflicts describes every file that conflicted in the last merge, with the three
+// versions of the file and the conflict hunks left in the working file.
+func exportConflicts() ([]conflictExport, error) {
+	state, err := readMergeState()
+	if err != nil {
+		return nil, fmt.Errorf("exportConflicts: %w", err)
+	}
+	if state == nil {
+		return nil, nil
+	}
+	readSide := func(hash string) (conflictSide, error) {
+		if hash == "" {
+			return conflictSide{}, nil
+		}
+		_, contents, err := readBlob(hash)
+		if err != nil {
+			return conflictSide{}, err
+		}
+		return conflictSide{hash, string(contents)}, nil
+	}
+
+	exports := make([]conflictExport, 0, len(state.Conflicts))
+	for _, conflict := range state.Conflicts {
+		export := conflictExport{Path: conflict.File, Hunks: []conflictHunk{}}
+		for _, side := range []struct {
+			hash string
+			dst  *conflictSide
+		}{
+			{conflict.Base, &export.Base},
+			{conflict.Ours, &export.Ours},
+			{conflict.Theirs, &export.Theirs},
+		} {
+			if *side.dst, err = readSide(side.hash); err != nil {
+				return nil, fmt.Errorf("exportConflicts: %w", err)
+			}
+		}
+		contents, err := readFile(conflict.File)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("exportConflicts: %w", err)
+		}
+		for _, hunk := range findConflictHunks(contents) {
+			// conflicts are whole-file, so the base of every hunk is the whole base version
+			hunk.Base = export.Base.Contents
+			export.Hunks = append(export.Hunks, hunk)
+		}
+		export.Resolved = len(export.Hunks) == 0
+		exports = append(exports, export)
+	}
+	return exports, nil
+}
+
+// printConflicts prints the files that conflicted in the last merge, or a JSON export of
+// their versions and hunks if asJSON is set.
+func printConflicts(asJSON bool) error {
+	exports, err := exportConflicts()
+	if err != nil {
+		return fmt.Errorf("printConflicts: %w", err)
+	}
+	if asJSON {
+		if exports == nil {
+			exports = []conflictExport{}
+		}
+		b, err := json.MarshalIndent(exports, "", "  ")
+		if err != nil {
+			return fmt.Errorf("printConflicts: %w", err)
+		}
+		log.Println(string(b))
+		return nil
+	}
+	if len(exports) == 0 {
+		log.Println("No merge conflicts.")
+		return nil
+	}
+	for _, export := range exports {
+		if export.Resolved {
+			log.Printf("%v (resolved)\n", export.Path)
+		} else {
+			log.Printf("%v (%v conflicts)\n", export.Path, len(export.Hunks))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestExportConflicts(t *testing.T) {
+	setupTestRepo(t)
+	commitInRepo(t, ".", "a.txt", "A")
+	if err := addBranch("target"); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkoutBranch("target"); err != nil {
+		t.Fatal(err)
+	}
+	commitInRepo(t, ".", "a.txt", "T")
+	if err := checkoutBranch("main"); err != nil {
+		t.Fatal(err)
+	}
+	commitInRepo(t, ".", "a.txt", "M")
+
+	if exports, err := exportConflicts(); err != nil || len(exports) != 0 {
+		t.Fatalf("Conflicts exported before merging: %v, %v", exports, err)
+	}
+	if err := mergeBranch("target", mergeOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	exports, err := exportConflicts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exports) != 1 {
+		t.Fatalf("Incorrect number of conflicted files: want 1, got %v", exports)
+	}
+	export := exports[0]
+	if export.Path != "a.txt" || export.Resolved ||
+		export.Base.Contents != "A" || export.Ours.Contents != "M" || export.Theirs.Contents != "T" {
+		t.Fatalf("Incorrect conflict export: %+v", export)
+	}
+	if len(export.Hunks) != 1 {
+		t.Fatalf("Incorrect number of hunks: want 1, got %v", export.Hunks)
+	}
+	contents, err := readContentsAsString("a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hunk := export.Hunks[0]
+	if hunk.Start != 0 || hunk.End != len(contents) ||
+		contents[hunk.OursStart:hunk.OursEnd] != "M" || contents[hunk.TheirsStart:hunk.TheirsEnd] != "T" {
+		t.Fatalf("Incorrect hunk ranges in '%v': %+v", contents, hunk)
+	}
+
+	// resolving the conflict and committing clears the merge state
+	commitInRepo(t, ".", "a.txt", "MT")
+	if exports, err := exportConflicts(); err != nil || len(exports) != 0 {
+		t.Fatalf("Conflicts exported after resolving: %v, %v", exports, err)
+	}
+}
+
+func TestAbortMerge(t *testing.T) {
+	setupTestRepo(t)
+	commitInRepo(t, ".", "a.txt", "A")
+	if err := addBranch("target"); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkoutBranch("target"); err != nil {
+		t.Fatal(err)
+	}
+	commitInRepo(t, ".", "a.txt", "T")
+	if err := checkoutBranch("main"); err != nil {
+		t.Fatal(err)
+	}
+	commitInRepo(t, ".", "a.txt", "M")
+	headCommitHash, err := getHeadCommitHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mergeBranch("target", mergeOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := abortMerge(); err != nil {
+		t.Fatal(err)
+	}
+	if hash, err := getHeadCommitHash(); err != nil || hash != headCommitHash {
+		t.Errorf("Expected the head commit before the merge %v, got %v, %v", headCommitHash, hash, err)
+	}
+	if contents, err := readContentsAsString("a.txt"); err != nil || contents != "M" {
+		t.Errorf("Expected the file before the merge, got %q, %v", contents, err)
+	}
+	if state, err := readMergeState(); err != nil || state != nil {
+		t.Errorf("Aborting should clear the merge state, got %v, %v", state, err)
+	}
+}
+
+func TestWriteConflictFile(t *testing.T) {
+	setupTestRepo(t)
+	chunked := randomContents(2 << 20)
+	media := bytes.Repeat([]byte("This is a large wug.\n"), 4<<10)
+	blobs := make(map[string]string)
+	// lfs takes precedence over chunking, so each threshold is set before its blob is written
+	for _, test := range []struct {
+		name, key, threshold string
+		contents             []byte
+	}{
+		{"small", "", "", []byte("This is a wug\n")},
+		{"chunked", "chunk.threshold", "1m", chunked},
+		{"media", "lfs.threshold", "64k", media},
+	} {
+		if test.key != "" {
+			if err := setConfig(test.key, test.threshold); err != nil {
+				t.Fatal(err)
+			}
+		}
+		hash, err := writeFileContentsBlob(test.contents)
+		if err != nil {
+			t.Fatal(err)
+		}
+		blobs[test.name] = hash
+	}
+	for name, header := range map[string]string{"chunked": "chunked", "media": "lfs"} {
+		if got, err := parseBlobHeader(blobs[name]); err != nil || got != header {
+			t.Fatalf("Test blob should be a %v blob: %q, %v", header, got, err)
+		}
+	}
+
+	tests := []struct {
+		ours, theirs string
+		expected     [][]byte
+	}{
+		{blobs["small"], blobs["chunked"], [][]byte{[]byte("This is a wug\n"), chunked}},
+		{blobs["media"], "", [][]byte{media, nil}},
+		{"", blobs["small"], [][]byte{nil, []byte("This is a wug\n")}},
+	}
+	for _, test := range tests {
+		if err := writeConflictFile("wug.txt", test.ours, test.theirs); err != nil {
+			t.Fatal(err)
+		}
+		expected := bytes.Join([][]byte{
+			[]byte(conflictStartMarker), test.expected[0],
+			[]byte(conflictSeparatorMarker), test.expected[1],
+			[]byte(conflictEndMarker),
+		}, nil)
+		if b, err := readFile("wug.txt"); err != nil || !bytes.Equal(b, expected) {
+			t.Fatalf("Incorrect conflict file for %v and %v: got %v bytes, want %v, %v", test.ours, test.theirs, len(b), len(expected), err)
+		}
+	}
+}
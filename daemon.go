@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"time"
+)
+
+// Remote URLs of the form gitlet://host:port/path name a repository served by gitlet daemon,
+// where path is relative to the directory the daemon serves.
+//
+// Each request and response is a JSON message preceded by its length as a 4-byte big-endian
+// integer. A connection may carry any number of requests, each answered in order.
+const (
+	daemonURLScheme   string        = "gitlet://"
+	defaultDaemonAddr string        = ":9418"
+	daemonDialTimeout time.Duration = 10 * time.Second
+)
+
+// writeFrame writes a message preceded by its length.
+func writeFrame(w io.Writer, b []byte) error {
+	if uint64(len(b)) > uint64(maxRemoteMessageSize) {
+		return fmt.Errorf("writeFrame: message of %v bytes is too large", len(b))
+	}
+	frame := binary.BigEndian.AppendUint32(make([]byte, 0, 4+len(b)), uint32(len(b)))
+	if _, err := w.Write(append(frame, b...)); err != nil {
+		return fmt.Errorf("writeFrame: %w", err)
+	}
+	return nil
+}
+
+// readFrame reads a message preceded by its length.
+// Returns io.EOF if the connection was closed before a new message.
+func readFrame(r io.Reader) ([]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err == io.EOF {
+		return nil, io.EOF
+	} else if err != nil {
+		return nil, fmt.Errorf("readFrame: %w", err)
+	}
+	size := binary.BigEndian.Uint32(header[:])
+	if size > maxRemoteMessageSize {
+		return nil, fmt.Errorf("readFrame: message of %v bytes is too large", size)
+	}
+	b := make([]byte, size)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, fmt.Errorf("readFrame: %w", err)
+	}
+	return b, nil
+}
+
+// isDaemonURL reports whether a remote URL names a repository served by gitlet daemon.
+func isDaemonURL(url string) bool {
+	return strings.HasPrefix(url, daemonURLScheme)
+}
+
+// requestDaemon sends a request to the repository named by a daemon URL and returns the
+// response. Exits if the daemon cannot be reached.
+func requestDaemon(url string, req remoteRequest) (remoteResponse, error) {
+	addr, repo, _ := strings.Cut(strings.TrimPrefix(url, daemonURLScheme), "/")
+	if addr == "" {
+		exitf("Invalid remote URL '%v'.", url)
+	}
+	req.Repo = repo
+	conn, err := net.DialTimeout("tcp", addr, daemonDialTimeout)
+	if err != nil {
+		exitf("Could not connect to remote at %v.", addr)
+	}
+	defer conn.Close()
+	b, err := serialize(req)
+	if err != nil {
+		return remoteResponse{}, fmt.Errorf("requestDaemon: %w", err)
+	}
+	if err := writeFrame(conn, b); err != nil {
+		return remoteResponse{}, fmt.Errorf("requestDaemon: %w", err)
+	}
+	b, err = readFrame(conn)
+	if err != nil {
+		return remoteResponse{}, fmt.Errorf("requestDaemon: %w", err)
+	}
+	resp, err := deserialize[remoteResponse](b)
+	if err != nil {
+		return remoteResponse{}, fmt.Errorf("requestDaemon: %w", err)
+	}
+	return resp, nil
+}
+
+// serveDaemon serves the repositories in baseDir and its subdirectories to the connections
+// accepted by a listener, until the listener is closed. Pushes are refused unless allowPush.
+func serveDaemon(l net.Listener, baseDir string, allowPush bool) error {
+	baseDir, err := repoFS.Abs(baseDir)
+	if err != nil {
+		return fmt.Errorf("serveDaemon: %w", err)
+	}
+	if err := acceptDaemonConns(l, baseDir, allowPush); err != nil {
+		return fmt.Errorf("serveDaemon: %w", err)
+	}
+	return nil
+}
+
+// acceptDaemonConns serves the repositories in baseDir, which must be absolute, to the
+// connections accepted by a listener, until the listener is closed.
+func acceptDaemonConns(l net.Listener, baseDir string, allowPush bool) error {
+	for {
+		conn, err := l.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("acceptDaemonConns: %w", err)
+		}
+		go handleDaemonConn(conn, baseDir, allowPush)
+	}
+}
+
+// handleDaemonConn answers the requests sent over a connection until it is closed.
+func handleDaemonConn(conn net.Conn, baseDir string, allowPush bool) {
+	defer conn.Close()
+	for {
+		b, err := readFrame(conn)
+		if err == io.EOF {
+			return
+		} else if err != nil {
+			log.Printf("%v: %v\n", conn.RemoteAddr(), err)
+			return
+		}
+		resp := remoteResponse{Error: "malformed request"}
+		if req, err := deserialize[remoteRequest](b); err == nil {
+			resp = handleRemoteRequest(baseDir, allowPush, req)
+		}
+		if b, err = serialize(resp); err == nil {
+			err = writeFrame(conn, b)
+		}
+		if err != nil {
+			log.Printf("%v: %v\n", conn.RemoteAddr(), err)
+			return
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+func TestFrame(t *testing.T) {
+	var b bytes.Buffer
+	for _, message := range []string{"wug", ""} {
+		if err := writeFrame(&b, []byte(message)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, expected := range []string{"wug", ""} {
+		if message, err := readFrame(&b); err != nil || string(message) != expected {
+			t.Fatalf("Incorrect frame: want %q, got %q, %v", expected, message, err)
+		}
+	}
+	if _, err := readFrame(&b); err != io.EOF {
+		t.Fatalf("Reading past the last frame should return EOF, got %v", err)
+	}
+	if _, err := readFrame(bytes.NewReader([]byte{0, 0, 0, 4, 'w'})); err == nil {
+		t.Fatal("Reading a truncated frame should fail")
+	}
+}
+
+// startDaemon serves the repositories in dir on a local port until the test ends.
+// Returns the URL of the daemon.
+func startDaemon(t *testing.T, dir string, allowPush bool) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	// the directory is resolved before the test moves on to another one
+	dir, err = repoFS.Abs(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go acceptDaemonConns(l, dir, allowPush)
+	return daemonURLScheme + l.Addr().String()
+}
+
+func TestDaemonPushFetch(t *testing.T) {
+	setupTestFS(t)
+	remoteDir := setupRemoteRepo(t)
+	url := startDaemon(t, filepath.Dir(remoteDir), true)
+	if err := repoFS.Mkdir("local", 0755); err != nil {
+		t.Fatal(err)
+	}
+	enterTestDir(t, "local")
+	if err := newRepository(); err != nil {
+		t.Fatal(err)
+	}
+	if err := addRemote("origin", url+"/"+filepath.Base(remoteDir)); err != nil {
+		t.Fatal(err)
+	}
+
+	remoteHeadHash, err := fetchRemoteBranch("origin", "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	remoteHead, err := getCommit(remoteHeadHash)
+	if err != nil {
+		t.Fatalf("Fetched commit is missing: %v", err)
+	}
+	if _, contents, err := readBlob(remoteHead.FileToBlob["wug.txt"]); err != nil || string(contents) != "This is a wug" {
+		t.Fatalf("Incorrect fetched blob: %q, %v", contents, err)
+	}
+	if hash, err := readContentsAsString(filepath.Join(remotesDir, "origin", "main")); err != nil || hash != remoteHeadHash {
+		t.Fatalf("Incorrect remote-tracking branch: %v, %v", hash, err)
+	}
+
+	if err := pull("origin", "main"); err != nil {
+		t.Fatal(err)
+	}
+	headHash := commitInRepo(t, ".", "notwug.txt", "This is not a wug")
+	if err := push("origin", "main"); err != nil {
+		t.Fatal(err)
+	}
+	if hash, err := readContentsAsString(filepath.Join(remoteDir, branchesDir, "main")); err != nil || hash != headHash {
+		t.Fatalf("Remote branch was not pushed: want %v, got %v, %v", headHash, hash, err)
+	}
+	if err := inRepository(remoteDir, func() error {
+		_, err := getCommit(headHash)
+		return err
+	}); err != nil {
+		t.Fatalf("Pushed commit is missing from the remote: %v", err)
+	}
+}
+
+func TestDaemonClone(t *testing.T) {
+	setupTestFS(t)
+	remoteDir := setupRemoteRepo(t)
+	url := startDaemon(t, filepath.Dir(remoteDir), false)
+
+	if err := cloneRemote(url+"/"+filepath.Base(remoteDir), ""); err != nil {
+		t.Fatal(err)
+	}
+	cloneDir := filepath.Base(remoteDir)
+	if contents, err := readContentsAsString(filepath.Join(cloneDir, "wug.txt")); err != nil || contents != "This is a wug" {
+		t.Fatalf("Clone did not check out the remote head: %q, %v", contents, err)
+	}
+	enterTestDir(t, cloneDir)
+	if branch, err := getCurrentBranch(); err != nil || branch != "main" {
+		t.Errorf("Clone should check out the remote's branch, got %q, %v", branch, err)
+	}
+	if err := pull("origin", "main"); err != nil {
+		t.Errorf("Clone should pull from its origin: %v", err)
+	}
+}
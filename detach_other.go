@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+import "os/exec"
+
+// detachProcess starts a command in its own session so it outlives the terminal.
+// Background processes are already detached on other platforms.
+func detachProcess(cmd *exec.Cmd) {}
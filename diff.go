@@ -0,0 +1,361 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"slices"
+	"strings"
+)
+
+// diffLine is a line of an edit script turning an old sequence of lines into a new one.
+// Line numbers are 1-based; OldLine is 0 for inserted lines and NewLine is 0 for deleted lines.
+type diffLine struct {
+	Op      byte // ' ' for an unchanged line, '-' for a deleted line, '+' for an inserted line.
+	Text    string
+	OldLine int
+	NewLine int
+}
+
+// diffHunk is a run of changed lines with surrounding unchanged context lines.
+type diffHunk struct {
+	OldStart, OldCount int
+	NewStart, NewCount int
+	Lines              []diffLine
+}
+
+// String formats a hunk in unified diff format.
+func (h diffHunk) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "@@ -%v +%v @@\n", hunkRange(h.OldStart, h.OldCount), hunkRange(h.NewStart, h.NewCount))
+	for _, line := range h.Lines {
+		b.WriteByte(line.Op)
+		b.WriteString(line.Text)
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// hunkRange formats the start and length of one side of a hunk header.
+// An empty side starts at the line before it, as in unified diffs.
+func hunkRange(start, count int) string {
+	if count == 0 {
+		start--
+	}
+	if count == 1 {
+		return fmt.Sprint(start)
+	}
+	return fmt.Sprintf("%v,%v", start, count)
+}
+
+// splitLines splits contents into lines without their line endings.
+// A final line ending does not start another line.
+func splitLines(contents []byte) []string {
+	if len(contents) == 0 {
+		return nil
+	}
+	lines := strings.Split(string(bytes.TrimSuffix(contents, []byte{'\n'})), "\n")
+	return lines
+}
+
+// diffLines returns the shortest edit script turning lines a into lines b, found with
+// Myers' O(ND) algorithm. Deletions come before insertions within each change.
+func diffLines(a []string, b []string) []diffLine {
+	n, m := len(a), len(b)
+	maxD := n + m
+	offset := maxD + 1
+	// v[k+offset] is the furthest x reached on diagonal k; trace holds v after each step
+	v := make([]int, 2*maxD+3)
+	var trace [][]int
+	found := false
+	for d := 0; d <= maxD && !found; d++ {
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[k-1+offset] < v[k+1+offset]) {
+				x = v[k+1+offset] // step down: insertion
+			} else {
+				x = v[k-1+offset] + 1 // step right: deletion
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+			v[k+offset] = x
+			if x >= n && y >= m {
+				found = true
+				break
+			}
+		}
+		trace = append(trace, slices.Clone(v))
+	}
+
+	// walk the trace backwards to recover the edits
+	var reversed []diffLine
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		k := x - y
+		var prevK int
+		if d == 0 {
+			prevK = 0
+		} else if k == -d || (k != d && trace[d-1][k-1+offset] < trace[d-1][k+1+offset]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := 0
+		if d > 0 {
+			prevX = trace[d-1][prevK+offset]
+		}
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x, y = x-1, y-1
+			reversed = append(reversed, diffLine{' ', a[x], x + 1, y + 1})
+		}
+		if d == 0 {
+			break
+		}
+		if x == prevX {
+			y--
+			reversed = append(reversed, diffLine{'+', b[y], 0, y + 1})
+		} else {
+			x--
+			reversed = append(reversed, diffLine{'-', a[x], x + 1, 0})
+		}
+	}
+	edits := make([]diffLine, len(reversed))
+	for i, line := range reversed {
+		edits[len(reversed)-1-i] = line
+	}
+	return edits
+}
+
+// Number of unchanged lines shown around each change in a hunk.
+const diffContextLines int = 3
+
+// makeHunks groups the changes of an edit script into hunks with up to context unchanged
+// lines around each change. Changes separated by at most twice that many unchanged lines
+// share a hunk.
+func makeHunks(edits []diffLine, context int) []diffHunk {
+	// oldSeen[i] and newSeen[i] count the old and new lines before edits[i]
+	oldSeen := make([]int, len(edits)+1)
+	newSeen := make([]int, len(edits)+1)
+	for i, e := range edits {
+		oldSeen[i+1], newSeen[i+1] = oldSeen[i], newSeen[i]
+		if e.Op != '+' {
+			oldSeen[i+1]++
+		}
+		if e.Op != '-' {
+			newSeen[i+1]++
+		}
+	}
+	var hunks []diffHunk
+	for i := 0; i < len(edits); {
+		if edits[i].Op == ' ' {
+			i++
+			continue
+		}
+		start, end := max(0, i-context), i
+		for j := i; j < len(edits); j++ {
+			if edits[j].Op != ' ' {
+				end = j + 1
+			} else if j-end >= 2*context {
+				break
+			}
+		}
+		stop := min(len(edits), end+context)
+		hunks = append(hunks, diffHunk{
+			OldStart: oldSeen[start] + 1,
+			OldCount: oldSeen[stop] - oldSeen[start],
+			NewStart: newSeen[start] + 1,
+			NewCount: newSeen[stop] - newSeen[start],
+			Lines:    edits[start:stop],
+		})
+		i = stop
+	}
+	return hunks
+}
+
+// fileDiff is the difference between two versions of a file.
+// A version that does not exist is compared as empty.
+type fileDiff struct {
+	File      string
+	OldExists bool
+	NewExists bool
+	Binary    bool // Either version is binary, so no hunks are computed.
+	Hunks     []diffHunk
+}
+
+// newFileDiff compares two versions of a file.
+func newFileDiff(file string, oldContents []byte, oldExists bool, newContents []byte, newExists bool) fileDiff {
+	d := fileDiff{File: file, OldExists: oldExists, NewExists: newExists}
+	if bytes.IndexByte(oldContents, 0) >= 0 || bytes.IndexByte(newContents, 0) >= 0 {
+		d.Binary = !bytes.Equal(oldContents, newContents)
+		return d
+	}
+	d.Hunks = makeHunks(diffLines(splitLines(oldContents), splitLines(newContents)), diffContextLines)
+	return d
+}
+
+// Changed reports whether the two versions of the file differ.
+func (d fileDiff) Changed() bool {
+	return d.Binary || len(d.Hunks) > 0 || d.OldExists != d.NewExists
+}
+
+// Stat returns the number of lines inserted and deleted going from the old version to the new.
+func (d fileDiff) Stat() (int, int) {
+	insertions, deletions := 0, 0
+	for _, hunk := range d.Hunks {
+		for _, line := range hunk.Lines {
+			switch line.Op {
+			case '+':
+				insertions++
+			case '-':
+				deletions++
+			}
+		}
+	}
+	return insertions, deletions
+}
+
+// String formats the difference in unified diff format.
+func (d fileDiff) String() string {
+	from, to := "a/"+d.File, "b/"+d.File
+	if !d.OldExists {
+		from = "/dev/null"
+	}
+	if !d.NewExists {
+		to = "/dev/null"
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "diff --gitlet a/%v b/%v\n", d.File, d.File)
+	if d.Binary {
+		fmt.Fprintf(&b, "Binary files %v and %v differ\n", from, to)
+		return b.String()
+	}
+	fmt.Fprintf(&b, "--- %v\n+++ %v\n", from, to)
+	for _, hunk := range d.Hunks {
+		b.WriteString(hunk.String())
+	}
+	return b.String()
+}
+
+// diffWorkingTree compares the files tracked by the head commit within the current scope
+// against their versions in the working directory, returning the changed files sorted by path.
+func diffWorkingTree() ([]fileDiff, error) {
+	_, headFiles, err := getHeadScopeFiles()
+	if err != nil {
+		return nil, fmt.Errorf("diffWorkingTree: %w", err)
+	}
+	var diffs []fileDiff
+	for _, file := range sortedKeys(headFiles) {
+		_, oldContents, err := readBlob(headFiles[file])
+		if err != nil {
+			return nil, fmt.Errorf("diffWorkingTree: %w", err)
+		}
+		newContents, err := readFile(file)
+		newExists := err == nil
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("diffWorkingTree: %w", err)
+		}
+		if d := newFileDiff(file, oldContents, true, newContents, newExists); d.Changed() {
+			diffs = append(diffs, d)
+		}
+	}
+	return diffs, nil
+}
+
+// diffCommits compares the files of two commits within the current scope, returning the
+// changed files sorted by path.
+func diffCommits(commitHash1 string, commitHash2 string) ([]fileDiff, error) {
+	commit1, err := getCommit(commitHash1)
+	if err != nil {
+		return nil, fmt.Errorf("diffCommits: %w", err)
+	}
+	commit2, err := getCommit(commitHash2)
+	if err != nil {
+		return nil, fmt.Errorf("diffCommits: %w", err)
+	}
+	readFile := func(c commit, file string) ([]byte, bool, error) {
+		blobHash, ok := c.FileToBlob[file]
+		if !ok {
+			return nil, false, nil
+		}
+		_, contents, err := readBlob(blobHash)
+		return contents, true, err
+	}
+	changes, err := diffCommitFiles(commit1, commit2)
+	if err != nil {
+		return nil, fmt.Errorf("diffCommits: %w", err)
+	}
+	var diffs []fileDiff
+	for _, change := range changes {
+		if !inScope(change.File) {
+			continue
+		}
+		oldContents, oldExists, err := readFile(commit1, change.File)
+		if err != nil {
+			return nil, fmt.Errorf("diffCommits: %w", err)
+		}
+		newContents, newExists, err := readFile(commit2, change.File)
+		if err != nil {
+			return nil, fmt.Errorf("diffCommits: %w", err)
+		}
+		if d := newFileDiff(change.File, oldContents, oldExists, newContents, newExists); d.Changed() {
+			diffs = append(diffs, d)
+		}
+	}
+	return diffs, nil
+}
+
+// printDiffs prints file differences in unified diff format.
+func printDiffs(diffs []fileDiff) {
+	for _, d := range diffs {
+		log.Print(d)
+	}
+}
+
+// Widest bar of '+' and '-' characters drawn for a file by formatDiffStat.
+const diffStatBarWidth int = 40
+
+// formatDiffStat summarizes file differences with a line per file counting its changed lines,
+// drawn as a bar of insertions and deletions, followed by a line of totals.
+func formatDiffStat(diffs []fileDiff) string {
+	nameWidth, countWidth, maxChanges := 0, 0, 0
+	for _, d := range diffs {
+		insertions, deletions := d.Stat()
+		nameWidth = max(nameWidth, len(d.File))
+		if d.Binary {
+			countWidth = max(countWidth, len("Bin"))
+		}
+		countWidth = max(countWidth, len(fmt.Sprint(insertions+deletions)))
+		maxChanges = max(maxChanges, insertions+deletions)
+	}
+	var b strings.Builder
+	totalInsertions, totalDeletions := 0, 0
+	for _, d := range diffs {
+		if d.Binary {
+			fmt.Fprintf(&b, " %-*v | %*v\n", nameWidth, d.File, countWidth, "Bin")
+			continue
+		}
+		insertions, deletions := d.Stat()
+		totalInsertions += insertions
+		totalDeletions += deletions
+		// scale the bar down if the largest change would not fit
+		plus, minus := insertions, deletions
+		if maxChanges > diffStatBarWidth {
+			plus = insertions * diffStatBarWidth / maxChanges
+			minus = deletions * diffStatBarWidth / maxChanges
+		}
+		fmt.Fprintf(
+			&b, " %-*v | %*v %v%v\n", nameWidth, d.File, countWidth, insertions+deletions,
+			strings.Repeat("+", plus), strings.Repeat("-", minus),
+		)
+	}
+	fmt.Fprintf(
+		&b, " %v changed, %v(+), %v(-)\n",
+		pluralize(len(diffs), "file"), pluralize(totalInsertions, "insertion"), pluralize(totalDeletions, "deletion"),
+	)
+	return b.String()
+}
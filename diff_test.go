@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		a, b []string
+	}{
+		{nil, nil},
+		{nil, []string{"a", "b"}},
+		{[]string{"a", "b"}, nil},
+		{[]string{"a", "b", "c"}, []string{"a", "b", "c"}},
+		{[]string{"a", "b", "c", "a", "b", "b", "a"}, []string{"c", "b", "a", "b", "a", "c"}},
+		{[]string{"x", "y"}, []string{"y", "z", "x"}},
+	}
+	for _, test := range tests {
+		edits := diffLines(test.a, test.b)
+		var oldLines, newLines []string
+		for _, e := range edits {
+			if e.Op != '+' {
+				oldLines = append(oldLines, e.Text)
+			}
+			if e.Op != '-' {
+				newLines = append(newLines, e.Text)
+			}
+		}
+		if !slices.Equal(oldLines, test.a) || !slices.Equal(newLines, test.b) {
+			t.Errorf("Edit script %v does not turn %v into %v", edits, test.a, test.b)
+		}
+	}
+	// the classic example from Myers' paper has an edit distance of 5
+	edits := diffLines(splitLines([]byte("a\nb\nc\na\nb\nb\na\n")), splitLines([]byte("c\nb\na\nb\na\nc\n")))
+	changes := 0
+	for _, e := range edits {
+		if e.Op != ' ' {
+			changes++
+		}
+	}
+	if changes != 5 {
+		t.Errorf("Incorrect edit distance: want 5, got %v", changes)
+	}
+}
+
+func TestDiffHunkString(t *testing.T) {
+	edits := diffLines([]string{"a", "b", "c"}, []string{"a", "B", "c", "d"})
+	hunk, changed := rangeHunk(edits, 1, 4)
+	if !changed {
+		t.Fatal("Range should be changed")
+	}
+	expected := "@@ -1,3 +1,4 @@\n a\n-b\n+B\n c\n+d\n"
+	if hunk.String() != expected {
+		t.Errorf("Incorrect hunk: want %q, got %q", expected, hunk.String())
+	}
+}
+
+func TestMakeHunks(t *testing.T) {
+	var a []string
+	for i := 1; i <= 20; i++ {
+		a = append(a, fmt.Sprint(i))
+	}
+	b := slices.Clone(a)
+	b[1] = "two"
+	b[17] = "eighteen"
+	hunks := makeHunks(diffLines(a, b), 3)
+	if len(hunks) != 2 {
+		t.Fatalf("Incorrect number of hunks: want 2, got %v", len(hunks))
+	}
+	expected := "@@ -1,5 +1,5 @@\n 1\n-2\n+two\n 3\n 4\n 5\n"
+	if hunks[0].String() != expected {
+		t.Errorf("Incorrect first hunk: want %q, got %q", expected, hunks[0].String())
+	}
+	expected = "@@ -15,6 +15,6 @@\n 15\n 16\n 17\n-18\n+eighteen\n 19\n 20\n"
+	if hunks[1].String() != expected {
+		t.Errorf("Incorrect second hunk: want %q, got %q", expected, hunks[1].String())
+	}
+
+	// changes separated by at most twice the context share a hunk
+	b = slices.Clone(a)
+	b[1], b[8] = "two", "nine"
+	if hunks := makeHunks(diffLines(a, b), 3); len(hunks) != 1 || hunks[0].OldStart != 1 || hunks[0].OldCount != 12 {
+		t.Errorf("Incorrect merged hunks: %v", hunks)
+	}
+}
+
+func TestDiffWorkingTree(t *testing.T) {
+	setupTestRepo(t)
+	for _, file := range []string{"wug.txt", "gone.txt", "same.txt"} {
+		if err := writeContents(file, []string{"hello\nworld"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := stageFile(file); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := newCommit("add files"); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeContents("wug.txt", []string{"hello\nwug"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repoFS.Remove("gone.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeContents("untracked.txt", []string{"new"}); err != nil {
+		t.Fatal(err)
+	}
+
+	diffs, err := diffWorkingTree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []string
+	for _, d := range diffs {
+		out = append(out, d.String())
+	}
+	expected := []string{
+		"diff --gitlet a/gone.txt b/gone.txt\n--- a/gone.txt\n+++ /dev/null\n@@ -1,2 +0,0 @@\n-hello\n-world\n",
+		"diff --gitlet a/wug.txt b/wug.txt\n--- a/wug.txt\n+++ b/wug.txt\n@@ -1,2 +1,2 @@\n hello\n-world\n+wug\n",
+	}
+	if !slices.Equal(out, expected) {
+		t.Errorf("Incorrect diff: want %q, got %q", expected, out)
+	}
+}
+
+func TestDiffCommits(t *testing.T) {
+	setupTestRepo(t)
+	commitFiles := func(files map[string]string, removed ...string) string {
+		t.Helper()
+		for file, contents := range files {
+			if err := writeContents(file, []string{contents}); err != nil {
+				t.Fatal(err)
+			}
+			if err := stageFile(file); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, file := range removed {
+			if err := unstageFile(file); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := newCommit("change files"); err != nil {
+			t.Fatal(err)
+		}
+		hash, err := getHeadCommitHash()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hash
+	}
+	first := commitFiles(map[string]string{"a.txt": "a", "b.txt": "b", "same.txt": "same"})
+	second := commitFiles(map[string]string{"a.txt": "A", "c.txt": "c"}, "b.txt")
+
+	diffs, err := diffCommits(first, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []string
+	for _, d := range diffs {
+		out = append(out, d.String())
+	}
+	expected := []string{
+		"diff --gitlet a/a.txt b/a.txt\n--- a/a.txt\n+++ b/a.txt\n@@ -1 +1 @@\n-a\n+A\n",
+		"diff --gitlet a/b.txt b/b.txt\n--- a/b.txt\n+++ /dev/null\n@@ -1 +0,0 @@\n-b\n",
+		"diff --gitlet a/c.txt b/c.txt\n--- /dev/null\n+++ b/c.txt\n@@ -0,0 +1 @@\n+c\n",
+	}
+	if !slices.Equal(out, expected) {
+		t.Errorf("Incorrect diff: want %q, got %q", expected, out)
+	}
+}
+
+func TestFormatDiffStat(t *testing.T) {
+	diffs := []fileDiff{
+		newFileDiff("a.txt", []byte("a\nb\nc"), true, []byte("a\nB\nc\nd"), true),
+		newFileDiff("long_name.txt", []byte("x"), true, nil, false),
+		newFileDiff("image.png", []byte("\x00"), true, []byte("\x00\x01"), true),
+	}
+	expected := "" +
+		" a.txt         |   3 ++-\n" +
+		" long_name.txt |   1 -\n" +
+		" image.png     | Bin\n" +
+		" 3 files changed, 2 insertions(+), 2 deletions(-)\n"
+	if got := formatDiffStat(diffs); got != expected {
+		t.Errorf("Incorrect diff stat: want %q, got %q", expected, got)
+	}
+}
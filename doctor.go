@@ -0,0 +1,296 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"path/filepath"
+)
+
+// doctorProblem is a problem found by diagnoseRepository.
+type doctorProblem struct {
+	Description string
+	Fix         func() error // Safe automatic repair, or nil if the problem must be fixed by hand.
+}
+
+// diagnoseRepository checks the repository for stale locks, a dangling HEAD,
+// index entries inconsistent with the head commit, orphaned staged blobs,
+// and missing remote refs directories.
+func diagnoseRepository() ([]doctorProblem, error) {
+	var problems []doctorProblem
+	for _, check := range []func() ([]doctorProblem, error){
+		checkRepoLock,
+		checkHead,
+		checkIndex,
+		checkOrphanedBlobs,
+		checkRemoteDirs,
+	} {
+		found, err := check()
+		if err != nil {
+			return nil, fmt.Errorf("diagnoseRepository: %w", err)
+		}
+		problems = append(problems, found...)
+	}
+	return problems, nil
+}
+
+// checkRepoLock reports a repository lock left behind by a process that is no longer running.
+func checkRepoLock() ([]doctorProblem, error) {
+	l, err := readRepoLock()
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("checkRepoLock: %w", err)
+	}
+	if l.PID <= 0 && !isStaleRepoLock(l) {
+		return []doctorProblem{{
+			fmt.Sprintf("repository lock cannot be read; remove %v if no command is running", repoLockFile),
+			nil,
+		}}, nil
+	}
+	if isStaleRepoLock(l) {
+		return []doctorProblem{{
+			fmt.Sprintf("stale repository lock left by %v (pid %v)", l.Operation, l.PID),
+			breakStaleRepoLock,
+		}}, nil
+	}
+	return []doctorProblem{{
+		fmt.Sprintf("repository is locked by %v (pid %v), which is still running", l.Operation, l.PID),
+		nil,
+	}}, nil
+}
+
+// breakStaleRepoLock breaks the repository lock file if it was abandoned, by taking the lock
+// and releasing it. Does nothing if this process holds the lock.
+func breakStaleRepoLock() error {
+	unlock, err := lockRepo("doctor")
+	if err != nil {
+		return fmt.Errorf("breakStaleRepoLock: %w", err)
+	}
+	unlock()
+	return nil
+}
+
+// checkHead reports a HEAD that does not name an existing branch,
+// and a current branch whose head commit is missing.
+func checkHead() ([]doctorProblem, error) {
+	branchFile, err := readRef(headFile)
+	if err != nil {
+		return nil, fmt.Errorf("checkHead: %w", err)
+	}
+	commitHash := branchFile
+	if !isHash(branchFile) {
+		commitHash, err = readRef(branchFile)
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		problem := doctorProblem{Description: fmt.Sprintf("HEAD points to missing branch '%v'", filepath.Base(branchFile))}
+		// only repair HEAD if there is an obvious branch to point it at
+		mainBranchFile := filepath.Join(branchesDir, "main")
+		if _, err := readRef(mainBranchFile); err == nil {
+			problem.Description += "; HEAD will be pointed at 'main'"
+			problem.Fix = func() error {
+				return writeRef(headFile, mainBranchFile, "doctor")
+			}
+		}
+		return []doctorProblem{problem}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("checkHead: %w", err)
+	}
+	if ok, err := hasObject(commitHash); err != nil {
+		return nil, fmt.Errorf("checkHead: %w", err)
+	} else if !ok {
+		description := fmt.Sprintf("head commit %v of branch '%v' is missing", commitHash, filepath.Base(branchFile))
+		if isHash(branchFile) {
+			description = fmt.Sprintf("detached head commit %v is missing", commitHash)
+		}
+		return []doctorProblem{{description, nil}}, nil
+	}
+	return nil, nil
+}
+
+// checkIndex reports staged files whose blobs are missing, files staged for removal that
+// the head commit does not track, and files staged with the contents of the head commit.
+// Each is repaired by unstaging the file.
+func checkIndex() ([]doctorProblem, error) {
+	index, err := readIndex()
+	if err != nil {
+		return nil, fmt.Errorf("checkIndex: %w", err)
+	}
+	headCommit, err := getHeadCommit()
+	if err != nil {
+		// a dangling HEAD is reported by checkHead
+		return nil, nil
+	}
+	var problems []doctorProblem
+	for _, file := range sortedKeys(index) {
+		metadata := index[file]
+		trackedHash, isTracked := headCommit.FileToBlob[file]
+		var description string
+		if metadata.Op == indexSubmodule {
+			// submodule commits live in the submodule's repository
+			continue
+		} else if metadata.Op == indexRemove {
+			if !isTracked {
+				description = fmt.Sprintf("file '%v' is staged for removal but is not tracked", file)
+			}
+		} else if ok, err := hasObject(metadata.Hash); err != nil {
+			return nil, fmt.Errorf("checkIndex: %w", err)
+		} else if !ok {
+			description = fmt.Sprintf("staged blob %v of file '%v' is missing", metadata.Hash, file)
+		} else if isTracked && metadata.Hash == trackedHash {
+			description = fmt.Sprintf("file '%v' is staged without changes from the head commit", file)
+		}
+		if description == "" {
+			continue
+		}
+		problems = append(problems, doctorProblem{description, func() error {
+			unlock, err := lockFile(indexFile, "doctor")
+			if err != nil {
+				return err
+			}
+			defer unlock()
+			index, err := readIndex()
+			if err != nil {
+				return err
+			}
+			delete(index, file)
+			return writeIndex(index)
+		}})
+	}
+	return problems, nil
+}
+
+// checkOrphanedBlobs reports loose file blobs that are neither staged nor referenced by any
+// commit, such as those left behind by interrupted staging. They are repaired by deleting them.
+func checkOrphanedBlobs() ([]doctorProblem, error) {
+	hashes, err := getObjectHashes()
+	if err != nil {
+		return nil, fmt.Errorf("checkOrphanedBlobs: %w", err)
+	}
+	referenced := make(map[string]bool)
+	index, err := readIndex()
+	if err != nil {
+		return nil, fmt.Errorf("checkOrphanedBlobs: %w", err)
+	}
+	for _, metadata := range index {
+		if metadata.Op != indexRemove && metadata.Op != indexSubmodule {
+			referenced[metadata.Hash] = true
+		}
+	}
+	var fileHashes []string
+	for _, hash := range hashes {
+		header, err := parseBlobHeader(hash)
+		if err != nil {
+			return nil, fmt.Errorf("checkOrphanedBlobs: %w", err)
+		}
+		if header == "file" || header == "lfs" || header == "chunked" {
+			fileHashes = append(fileHashes, hash)
+			continue
+		} else if header != "commit" {
+			continue
+		}
+		c, err := getCommit(hash)
+		if err != nil {
+			return nil, fmt.Errorf("checkOrphanedBlobs: %w", err)
+		}
+		for _, blobHash := range c.FileToBlob {
+			referenced[blobHash] = true
+		}
+	}
+
+	loose, err := getLooseObjectHashes()
+	if err != nil {
+		return nil, fmt.Errorf("checkOrphanedBlobs: %w", err)
+	}
+	isLoose := make(map[string]bool, len(loose))
+	for _, hash := range loose {
+		isLoose[hash] = true
+	}
+	var problems []doctorProblem
+	for _, hash := range fileHashes {
+		if referenced[hash] {
+			continue
+		}
+		problem := doctorProblem{Description: fmt.Sprintf("file blob %v is not staged or committed", hash)}
+		if isLoose[hash] {
+			problem.Fix = func() error { return removeObject(hash) }
+		}
+		problems = append(problems, problem)
+	}
+	return problems, nil
+}
+
+// checkRemoteDirs reports a missing remote refs directory, or a missing refs directory
+// for a configured remote. They are repaired by creating the directories.
+func checkRemoteDirs() ([]doctorProblem, error) {
+	remotes, err := readRemoteIndex()
+	if err != nil {
+		return nil, fmt.Errorf("checkRemoteDirs: %w", err)
+	}
+	dirs := []string{remotesDir}
+	for _, name := range sortedKeys(remotes) {
+		dirs = append(dirs, filepath.Join(remotesDir, name))
+	}
+	var problems []doctorProblem
+	for _, dir := range dirs {
+		if _, err := repoFS.Stat(dir); err == nil {
+			continue
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("checkRemoteDirs: %w", err)
+		}
+		problems = append(problems, doctorProblem{
+			fmt.Sprintf("remote refs directory '%v' is missing", dir),
+			func() error { return repoFS.MkdirAll(dir, 0755) },
+		})
+	}
+	return problems, nil
+}
+
+// runDoctor prints the problems found in the repository, and repairs those that have a
+// safe automatic repair if fix is set.
+func runDoctor(fix bool) error {
+	problems, err := diagnoseRepository()
+	if err != nil {
+		return fmt.Errorf("runDoctor: %w", err)
+	}
+	if len(problems) == 0 {
+		log.Println("No problems found.")
+		return nil
+	}
+	if !fix {
+		fixable := 0
+		for _, p := range problems {
+			if p.Fix != nil {
+				fixable++
+				log.Printf("%v (fixable)\n", p.Description)
+			} else {
+				log.Println(p.Description)
+			}
+		}
+		log.Printf("Found %v problems, %v fixable with 'doctor --fix'.\n", len(problems), fixable)
+		return nil
+	}
+
+	// take the lock so repairs do not interleave with other commands;
+	// this also breaks a stale lock if one was found
+	unlock, err := lockRepo("doctor")
+	if err != nil {
+		return fmt.Errorf("runDoctor: %w", err)
+	}
+	defer unlock()
+	fixed := 0
+	for _, p := range problems {
+		if p.Fix == nil {
+			log.Printf("Cannot fix: %v\n", p.Description)
+			continue
+		}
+		if err := p.Fix(); err != nil {
+			return fmt.Errorf("runDoctor: cannot fix %v: %w", p.Description, err)
+		}
+		fixed++
+		log.Printf("Fixed: %v\n", p.Description)
+	}
+	log.Printf("Fixed %v of %v problems.\n", fixed, len(problems))
+	return nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDoctor(t *testing.T) {
+	setupTestRepo(t)
+	if problems, err := diagnoseRepository(); err != nil || len(problems) != 0 {
+		t.Fatalf("Problems found in new repository: %v, %v", problems, err)
+	}
+
+	// stale lock
+	b, err := serialize(repoLock{1 << 30, time.Now().Add(-time.Minute).Unix(), "merge"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFile(repoLockFile, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	// orphaned blob
+	if _, err := writeBlob("file", []byte("This is an orphaned wug")); err != nil {
+		t.Fatal(err)
+	}
+	// staged blob that is missing
+	index, err := readIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	index["wug.txt"] = indexMetadata{indexAdd, "0123456789012345678901234567890123456789", time.Now().Unix(), 0, false}
+	if err := writeIndex(index); err != nil {
+		t.Fatal(err)
+	}
+	// missing remote refs directory
+	if err := repoFS.RemoveAll(remotesDir); err != nil {
+		t.Fatal(err)
+	}
+
+	problems, err := diagnoseRepository()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 4 {
+		t.Fatalf("Incorrect number of problems: want 4, got %v", problems)
+	}
+	for _, p := range problems {
+		if p.Fix == nil {
+			t.Fatalf("Problem is not fixable: %v", p.Description)
+		}
+	}
+	if err := runDoctor(true); err != nil {
+		t.Fatal(err)
+	}
+	if problems, err := diagnoseRepository(); err != nil || len(problems) != 0 {
+		t.Fatalf("Problems remain after fixing: %v, %v", problems, err)
+	}
+	if _, err := repoFS.Stat(repoLockFile); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Repository lock remains after fixing: %v", err)
+	}
+}
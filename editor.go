@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// getEditor returns the command line of the user's editor, from $VISUAL or $EDITOR,
+// falling back to vi.
+func getEditor() []string {
+	for _, name := range []string{"VISUAL", "EDITOR"} {
+		if editor := strings.Fields(os.Getenv(name)); len(editor) > 0 {
+			return editor
+		}
+	}
+	return []string{"vi"}
+}
+
+// editFile opens a file of repoFS in the user's editor and waits for it to exit. A file that
+// is not kept on disk is edited through a temporary copy on disk.
+func editFile(file string) error {
+	if _, ok := repoFS.(osFileSystem); !ok {
+		return editFileCopy(file)
+	}
+	path, err := repoFS.Abs(file)
+	if err != nil {
+		return fmt.Errorf("editFile: %w", err)
+	}
+	editor := getEditor()
+	cmd := exec.Command(editor[0], append(editor[1:], path)...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("editFile: editor %v failed: %w", editor[0], err)
+	}
+	return nil
+}
+
+// editFileCopy edits a file of repoFS that is not kept on disk by copying it to a temporary
+// file on disk, editing the copy, and writing the edited copy back.
+func editFileCopy(file string) error {
+	b, err := readFile(file)
+	if err != nil {
+		return fmt.Errorf("editFile: %w", err)
+	}
+	f, err := os.CreateTemp("", "*-"+filepath.Base(file))
+	if err != nil {
+		return fmt.Errorf("editFile: %w", err)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(b)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("editFile: %w", err)
+	}
+	wdFS := repoFS
+	repoFS = osFileSystem{}
+	err = editFile(f.Name())
+	repoFS = wdFS
+	if err != nil {
+		return err
+	}
+	if b, err = os.ReadFile(f.Name()); err != nil {
+		return fmt.Errorf("editFile: %w", err)
+	}
+	if err := writeFile(file, b, 0644); err != nil {
+		return fmt.Errorf("editFile: %w", err)
+	}
+	return nil
+}
+
+// editText opens the given text in the user's editor using a temporary file, and returns
+// the edited text with lines starting with # removed.
+func editText(pattern string, text string) (string, error) {
+	f, err := repoFS.CreateTemp("", pattern)
+	if err != nil {
+		return "", fmt.Errorf("editText: %w", err)
+	}
+	defer repoFS.Remove(f.Name())
+	_, err = f.WriteString(text)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", fmt.Errorf("editText: %w", err)
+	}
+	if err := editFile(f.Name()); err != nil {
+		return "", fmt.Errorf("editText: %w", err)
+	}
+	b, err := readFile(f.Name())
+	if err != nil {
+		return "", fmt.Errorf("editText: %w", err)
+	}
+	return stripComments(string(b)), nil
+}
+
+// stripComments removes the lines starting with # from text.
+func stripComments(text string) string {
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		if !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// The working directory and .gitlet directory of the current repository are read and written
+// through repoFS, so a repository can be kept on disk or entirely in memory. Paths are relative
+// to the working directory of the repository, like those of the os package are relative to
+// the working directory of the process. The command line keeps repositories on disk, in the
+// working directory of the process; tests and programs embedding gitlet can set repoFS to a
+// memoryFileSystem instead. Operating on another repository, such as a remote, moves repoFS
+// to its directory with inRepository.
+var repoFS fileSystem = osFileSystem{}
+
+// fileSystem is a filesystem with a working directory. Its methods behave like the functions
+// of the os package with the same names, and return errors wrapping the same fs errors.
+type fileSystem interface {
+	Open(name string) (file, error)
+	OpenFile(name string, flag int, perm fs.FileMode) (file, error)
+	CreateTemp(dir string, pattern string) (file, error)
+	MkdirTemp(dir string, pattern string) (string, error)
+	Stat(name string) (fs.FileInfo, error)
+	Lstat(name string) (fs.FileInfo, error)
+	ReadDir(name string) ([]fs.DirEntry, error)
+	Mkdir(name string, perm fs.FileMode) error
+	MkdirAll(name string, perm fs.FileMode) error
+	Remove(name string) error
+	RemoveAll(name string) error
+	Rename(oldpath string, newpath string) error
+	Link(oldname string, newname string) error
+	Chmod(name string, mode fs.FileMode) error
+	Chtimes(name string, atime time.Time, mtime time.Time) error
+	// SameFile reports whether two results of Stat or Lstat describe the same file.
+	SameFile(fi1 fs.FileInfo, fi2 fs.FileInfo) bool
+	// Abs returns the absolute path of a path relative to the working directory.
+	Abs(name string) (string, error)
+	// Sub returns the same filesystem with dir as its working directory.
+	Sub(dir string) (fileSystem, error)
+}
+
+// file is an open file of a fileSystem, like an *os.File.
+type file interface {
+	io.Reader
+	io.ReaderAt
+	io.Writer
+	io.StringWriter
+	io.Seeker
+	io.Closer
+	Name() string
+	Stat() (fs.FileInfo, error)
+	Sync() error
+}
+
+// readFile returns the contents of a file of repoFS, like os.ReadFile.
+func readFile(name string) ([]byte, error) {
+	f, err := repoFS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// writeFile writes a file of repoFS, creating or truncating it, like os.WriteFile.
+func writeFile(name string, b []byte, perm fs.FileMode) error {
+	f, err := repoFS.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// walkDir walks the file tree of repoFS rooted at root, like filepath.WalkDir.
+func walkDir(root string, fn fs.WalkDirFunc) error {
+	info, err := repoFS.Lstat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = walkDirEntry(root, fs.FileInfoToDirEntry(info), fn)
+	}
+	if errors.Is(err, fs.SkipDir) || errors.Is(err, fs.SkipAll) {
+		return nil
+	}
+	return err
+}
+
+// walkDirEntry walks the file tree of repoFS below path, whose directory entry is d.
+func walkDirEntry(path string, d fs.DirEntry, fn fs.WalkDirFunc) error {
+	if err := fn(path, d, nil); err != nil || !d.IsDir() {
+		if errors.Is(err, fs.SkipDir) && d.IsDir() {
+			err = nil
+		}
+		return err
+	}
+	entries, err := repoFS.ReadDir(path)
+	if err != nil {
+		// the directory is reported a second time with the error, as filepath.WalkDir does
+		if err = fn(path, d, err); err != nil {
+			if errors.Is(err, fs.SkipDir) {
+				err = nil
+			}
+			return err
+		}
+	}
+	for _, entry := range entries {
+		if err := walkDirEntry(filepath.Join(path, entry.Name()), entry, fn); err != nil {
+			if errors.Is(err, fs.SkipDir) {
+				break
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// glob returns the names of the files of repoFS matching pattern, like filepath.Glob.
+func glob(pattern string) ([]string, error) {
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	if !hasMeta(pattern) {
+		if _, err := repoFS.Lstat(pattern); err != nil {
+			return nil, nil
+		}
+		return []string{pattern}, nil
+	}
+	dir, file := filepath.Split(pattern)
+	dir = filepath.Clean(dir)
+	if !hasMeta(dir) {
+		return globDir(dir, file, nil), nil
+	}
+	dirs, err := glob(dir)
+	if err != nil {
+		return nil, err
+	}
+	var matches []string
+	for _, d := range dirs {
+		matches = globDir(d, file, matches)
+	}
+	return matches, nil
+}
+
+// globDir appends the names of the files in dir of repoFS matching pattern to matches.
+func globDir(dir string, pattern string, matches []string) []string {
+	entries, err := repoFS.ReadDir(dir)
+	if err != nil {
+		return matches
+	}
+	for _, entry := range entries {
+		if ok, _ := filepath.Match(pattern, entry.Name()); ok {
+			matches = append(matches, filepath.Join(dir, entry.Name()))
+		}
+	}
+	return matches
+}
+
+// hasMeta reports whether path contains any of the magic characters of filepath.Match.
+func hasMeta(path string) bool {
+	return strings.ContainsAny(path, `*?[\`)
+}
+
+// osFileSystem is the filesystem of the operating system, with dir as its working directory,
+// or the working directory of the process if dir is empty.
+type osFileSystem struct {
+	dir string // Absolute path of the working directory, or empty.
+}
+
+// path returns the path the os package knows a path relative to the working directory by.
+func (s osFileSystem) path(name string) string {
+	if s.dir == "" || filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(s.dir, name)
+}
+
+func (s osFileSystem) Open(name string) (file, error) {
+	f, err := os.Open(s.path(name))
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (s osFileSystem) OpenFile(name string, flag int, perm fs.FileMode) (file, error) {
+	f, err := os.OpenFile(s.path(name), flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (s osFileSystem) CreateTemp(dir string, pattern string) (file, error) {
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	f, err := os.CreateTemp(s.path(dir), pattern)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (s osFileSystem) MkdirTemp(dir string, pattern string) (string, error) {
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	return os.MkdirTemp(s.path(dir), pattern)
+}
+
+func (s osFileSystem) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(s.path(name))
+}
+
+func (s osFileSystem) Lstat(name string) (fs.FileInfo, error) {
+	return os.Lstat(s.path(name))
+}
+
+func (s osFileSystem) ReadDir(name string) ([]fs.DirEntry, error) {
+	return os.ReadDir(s.path(name))
+}
+
+func (s osFileSystem) Mkdir(name string, perm fs.FileMode) error {
+	return os.Mkdir(s.path(name), perm)
+}
+
+func (s osFileSystem) MkdirAll(name string, perm fs.FileMode) error {
+	return os.MkdirAll(s.path(name), perm)
+}
+
+func (s osFileSystem) Remove(name string) error {
+	return os.Remove(s.path(name))
+}
+
+func (s osFileSystem) RemoveAll(name string) error {
+	return os.RemoveAll(s.path(name))
+}
+
+func (s osFileSystem) Rename(oldpath string, newpath string) error {
+	return os.Rename(s.path(oldpath), s.path(newpath))
+}
+
+func (s osFileSystem) Link(oldname string, newname string) error {
+	return os.Link(s.path(oldname), s.path(newname))
+}
+
+func (s osFileSystem) Chmod(name string, mode fs.FileMode) error {
+	return os.Chmod(s.path(name), mode)
+}
+
+func (s osFileSystem) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return os.Chtimes(s.path(name), atime, mtime)
+}
+
+func (s osFileSystem) SameFile(fi1 fs.FileInfo, fi2 fs.FileInfo) bool {
+	return os.SameFile(fi1, fi2)
+}
+
+func (s osFileSystem) Abs(name string) (string, error) {
+	return filepath.Abs(s.path(name))
+}
+
+func (s osFileSystem) Sub(dir string) (fileSystem, error) {
+	dir, err := s.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("Sub: %w", err)
+	}
+	if info, err := os.Stat(dir); err != nil {
+		return nil, fmt.Errorf("Sub: %w", err)
+	} else if !info.IsDir() {
+		return nil, fmt.Errorf("Sub: %v is not a directory", dir)
+	}
+	return osFileSystem{dir}, nil
+}
@@ -0,0 +1,549 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// memoryFileSystem is a filesystem kept in the memory of the process, with dir as its working
+// directory. Repositories kept in it, their working directories included, are gone once the
+// filesystem is no longer referenced. Its paths are absolute from its own root, so repositories
+// in it never clash with those on disk.
+type memoryFileSystem struct {
+	tree *memoryTree
+	dir  string // Absolute slash-separated path of the working directory.
+}
+
+// memoryTree is the tree of files shared by the working directories of a memoryFileSystem.
+type memoryTree struct {
+	mu   sync.Mutex
+	root *memoryNode
+	temp int // Number of temporary files and directories created, for naming the next.
+}
+
+// memoryNode is a file or directory of a memoryTree. Hard links to a file share its node.
+type memoryNode struct {
+	mode     fs.FileMode
+	modTime  time.Time
+	data     []byte                 // Contents of a file.
+	children map[string]*memoryNode // Entries of a directory by name.
+}
+
+// newMemoryFileSystem returns an empty memoryFileSystem whose working directory is its root.
+func newMemoryFileSystem() memoryFileSystem {
+	root := &memoryNode{mode: fs.ModeDir | 0755, modTime: time.Now(), children: make(map[string]*memoryNode)}
+	return memoryFileSystem{&memoryTree{root: root}, "/"}
+}
+
+// path returns the absolute slash-separated path of a path relative to the working directory.
+func (s memoryFileSystem) path(name string) string {
+	name = filepath.ToSlash(strings.TrimPrefix(name, filepath.VolumeName(name)))
+	if !path.IsAbs(name) {
+		name = path.Join(s.dir, name)
+	}
+	return path.Clean(name)
+}
+
+// lookup returns the node at an absolute path. Callers hold the lock of the tree.
+func (s memoryFileSystem) lookup(p string) (*memoryNode, error) {
+	node := s.tree.root
+	for _, name := range strings.Split(strings.TrimPrefix(p, "/"), "/") {
+		if name == "" {
+			continue
+		}
+		if !node.mode.IsDir() {
+			return nil, syscall.ENOTDIR
+		}
+		child, ok := node.children[name]
+		if !ok {
+			return nil, fs.ErrNotExist
+		}
+		node = child
+	}
+	return node, nil
+}
+
+// lookupParent returns the directory containing an absolute path and the name of the path in
+// it. Callers hold the lock of the tree.
+func (s memoryFileSystem) lookupParent(p string) (*memoryNode, string, error) {
+	if p == "/" {
+		return nil, "", fs.ErrInvalid
+	}
+	parent, err := s.lookup(path.Dir(p))
+	if err != nil {
+		return nil, "", err
+	}
+	if !parent.mode.IsDir() {
+		return nil, "", syscall.ENOTDIR
+	}
+	return parent, path.Base(p), nil
+}
+
+func (s memoryFileSystem) Open(name string) (file, error) {
+	return s.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (s memoryFileSystem) OpenFile(name string, flag int, perm fs.FileMode) (file, error) {
+	s.tree.mu.Lock()
+	defer s.tree.mu.Unlock()
+	parent, base, err := s.lookupParent(s.path(name))
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	writable := flag&(os.O_WRONLY|os.O_RDWR) != 0
+	node, ok := parent.children[base]
+	switch {
+	case ok && flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+	case !ok && flag&os.O_CREATE == 0:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	case !ok:
+		node = &memoryNode{mode: perm.Perm(), modTime: time.Now()}
+		parent.children[base] = node
+		parent.modTime = node.modTime
+	case node.mode.IsDir() && writable:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: syscall.EISDIR}
+	case writable && flag&os.O_TRUNC != 0:
+		node.data, node.modTime = nil, time.Now()
+	}
+	return &memoryFile{tree: s.tree, node: node, name: name, flag: flag}, nil
+}
+
+// tempName returns the name of a new temporary file or directory, replacing the last "*" in
+// pattern by a number, or appending the number if there is none. Callers hold the lock of
+// the tree.
+func (s memoryFileSystem) tempName(pattern string) string {
+	s.tree.temp++
+	n := fmt.Sprint(s.tree.temp)
+	if i := strings.LastIndex(pattern, "*"); i >= 0 {
+		return pattern[:i] + n + pattern[i+1:]
+	}
+	return pattern + n
+}
+
+// tempDir returns the directory temporary files are created in when none is given.
+func (s memoryFileSystem) tempDir(dir string) (string, error) {
+	if dir != "" {
+		return dir, nil
+	}
+	if err := s.MkdirAll("/tmp", 0755); err != nil {
+		return "", err
+	}
+	return "/tmp", nil
+}
+
+func (s memoryFileSystem) CreateTemp(dir string, pattern string) (file, error) {
+	dir, err := s.tempDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		s.tree.mu.Lock()
+		name := filepath.Join(dir, s.tempName(pattern))
+		s.tree.mu.Unlock()
+		f, err := s.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+		if !errors.Is(err, fs.ErrExist) {
+			return f, err
+		}
+	}
+}
+
+func (s memoryFileSystem) MkdirTemp(dir string, pattern string) (string, error) {
+	dir, err := s.tempDir(dir)
+	if err != nil {
+		return "", err
+	}
+	for {
+		s.tree.mu.Lock()
+		name := filepath.Join(dir, s.tempName(pattern))
+		s.tree.mu.Unlock()
+		if err := s.Mkdir(name, 0700); !errors.Is(err, fs.ErrExist) {
+			return name, err
+		}
+	}
+}
+
+func (s memoryFileSystem) Stat(name string) (fs.FileInfo, error) {
+	s.tree.mu.Lock()
+	defer s.tree.mu.Unlock()
+	p := s.path(name)
+	node, err := s.lookup(p)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+	}
+	return node.info(path.Base(p)), nil
+}
+
+func (s memoryFileSystem) Lstat(name string) (fs.FileInfo, error) {
+	// there are no symbolic links
+	return s.Stat(name)
+}
+
+func (s memoryFileSystem) ReadDir(name string) ([]fs.DirEntry, error) {
+	s.tree.mu.Lock()
+	defer s.tree.mu.Unlock()
+	node, err := s.lookup(s.path(name))
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	if !node.mode.IsDir() {
+		return nil, &fs.PathError{Op: "readdirent", Path: name, Err: syscall.ENOTDIR}
+	}
+	entries := make([]fs.DirEntry, 0, len(node.children))
+	for childName, child := range node.children {
+		entries = append(entries, fs.FileInfoToDirEntry(child.info(childName)))
+	}
+	slices.SortFunc(entries, func(a, b fs.DirEntry) int {
+		return cmp.Compare(a.Name(), b.Name())
+	})
+	return entries, nil
+}
+
+func (s memoryFileSystem) Mkdir(name string, perm fs.FileMode) error {
+	s.tree.mu.Lock()
+	defer s.tree.mu.Unlock()
+	parent, base, err := s.lookupParent(s.path(name))
+	if err != nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+	if _, ok := parent.children[base]; ok {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrExist}
+	}
+	now := time.Now()
+	parent.children[base] = &memoryNode{mode: fs.ModeDir | perm.Perm(), modTime: now, children: make(map[string]*memoryNode)}
+	parent.modTime = now
+	return nil
+}
+
+func (s memoryFileSystem) MkdirAll(name string, perm fs.FileMode) error {
+	s.tree.mu.Lock()
+	defer s.tree.mu.Unlock()
+	node := s.tree.root
+	for _, part := range strings.Split(strings.TrimPrefix(s.path(name), "/"), "/") {
+		if part == "" {
+			continue
+		}
+		child, ok := node.children[part]
+		if !ok {
+			now := time.Now()
+			child = &memoryNode{mode: fs.ModeDir | perm.Perm(), modTime: now, children: make(map[string]*memoryNode)}
+			node.children[part] = child
+			node.modTime = now
+		} else if !child.mode.IsDir() {
+			return &fs.PathError{Op: "mkdir", Path: name, Err: syscall.ENOTDIR}
+		}
+		node = child
+	}
+	return nil
+}
+
+func (s memoryFileSystem) Remove(name string) error {
+	s.tree.mu.Lock()
+	defer s.tree.mu.Unlock()
+	parent, base, err := s.lookupParent(s.path(name))
+	if err != nil {
+		return &fs.PathError{Op: "remove", Path: name, Err: err}
+	}
+	node, ok := parent.children[base]
+	if !ok {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	if node.mode.IsDir() && len(node.children) > 0 {
+		return &fs.PathError{Op: "remove", Path: name, Err: syscall.ENOTEMPTY}
+	}
+	delete(parent.children, base)
+	parent.modTime = time.Now()
+	return nil
+}
+
+func (s memoryFileSystem) RemoveAll(name string) error {
+	s.tree.mu.Lock()
+	defer s.tree.mu.Unlock()
+	parent, base, err := s.lookupParent(s.path(name))
+	if err == fs.ErrNotExist || err == syscall.ENOTDIR {
+		return nil
+	} else if err != nil {
+		return &fs.PathError{Op: "unlinkat", Path: name, Err: err}
+	}
+	if _, ok := parent.children[base]; ok {
+		delete(parent.children, base)
+		parent.modTime = time.Now()
+	}
+	return nil
+}
+
+func (s memoryFileSystem) Rename(oldpath string, newpath string) error {
+	s.tree.mu.Lock()
+	defer s.tree.mu.Unlock()
+	linkErr := func(err error) error {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: err}
+	}
+	oldP, newP := s.path(oldpath), s.path(newpath)
+	oldParent, oldBase, err := s.lookupParent(oldP)
+	if err != nil {
+		return linkErr(err)
+	}
+	node, ok := oldParent.children[oldBase]
+	if !ok {
+		return linkErr(fs.ErrNotExist)
+	}
+	newParent, newBase, err := s.lookupParent(newP)
+	if err != nil {
+		return linkErr(err)
+	}
+	if node.mode.IsDir() && strings.HasPrefix(newP, oldP+"/") {
+		return linkErr(fs.ErrInvalid)
+	}
+	if existing, ok := newParent.children[newBase]; ok && existing != node {
+		switch {
+		case existing.mode.IsDir() && !node.mode.IsDir():
+			return linkErr(syscall.EISDIR)
+		case !existing.mode.IsDir() && node.mode.IsDir():
+			return linkErr(syscall.ENOTDIR)
+		case existing.mode.IsDir() && len(existing.children) > 0:
+			return linkErr(syscall.ENOTEMPTY)
+		}
+	}
+	now := time.Now()
+	delete(oldParent.children, oldBase)
+	newParent.children[newBase] = node
+	oldParent.modTime, newParent.modTime = now, now
+	return nil
+}
+
+func (s memoryFileSystem) Link(oldname string, newname string) error {
+	s.tree.mu.Lock()
+	defer s.tree.mu.Unlock()
+	linkErr := func(err error) error {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: err}
+	}
+	node, err := s.lookup(s.path(oldname))
+	if err != nil {
+		return linkErr(err)
+	}
+	if node.mode.IsDir() {
+		return linkErr(fs.ErrPermission)
+	}
+	parent, base, err := s.lookupParent(s.path(newname))
+	if err != nil {
+		return linkErr(err)
+	}
+	if _, ok := parent.children[base]; ok {
+		return linkErr(fs.ErrExist)
+	}
+	parent.children[base] = node
+	parent.modTime = time.Now()
+	return nil
+}
+
+func (s memoryFileSystem) Chmod(name string, mode fs.FileMode) error {
+	s.tree.mu.Lock()
+	defer s.tree.mu.Unlock()
+	node, err := s.lookup(s.path(name))
+	if err != nil {
+		return &fs.PathError{Op: "chmod", Path: name, Err: err}
+	}
+	node.mode = node.mode.Type() | mode.Perm()
+	return nil
+}
+
+func (s memoryFileSystem) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	s.tree.mu.Lock()
+	defer s.tree.mu.Unlock()
+	node, err := s.lookup(s.path(name))
+	if err != nil {
+		return &fs.PathError{Op: "chtimes", Path: name, Err: err}
+	}
+	node.modTime = mtime
+	return nil
+}
+
+func (s memoryFileSystem) SameFile(fi1 fs.FileInfo, fi2 fs.FileInfo) bool {
+	node1, ok1 := fi1.Sys().(*memoryNode)
+	node2, ok2 := fi2.Sys().(*memoryNode)
+	return ok1 && ok2 && node1 == node2
+}
+
+func (s memoryFileSystem) Abs(name string) (string, error) {
+	return filepath.FromSlash(s.path(name)), nil
+}
+
+func (s memoryFileSystem) Sub(dir string) (fileSystem, error) {
+	s.tree.mu.Lock()
+	defer s.tree.mu.Unlock()
+	p := s.path(dir)
+	node, err := s.lookup(p)
+	if err != nil {
+		return nil, fmt.Errorf("Sub: %w", &fs.PathError{Op: "chdir", Path: dir, Err: err})
+	}
+	if !node.mode.IsDir() {
+		return nil, fmt.Errorf("Sub: %w", &fs.PathError{Op: "chdir", Path: dir, Err: syscall.ENOTDIR})
+	}
+	return memoryFileSystem{s.tree, p}, nil
+}
+
+// info describes a node named name. Callers hold the lock of the tree.
+func (n *memoryNode) info(name string) fs.FileInfo {
+	return memoryFileInfo{name, int64(len(n.data)), n.mode, n.modTime, n}
+}
+
+// memoryFileInfo describes a file or directory of a memoryFileSystem as it was when it was
+// described.
+type memoryFileInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+	node    *memoryNode
+}
+
+func (fi memoryFileInfo) Name() string       { return fi.name }
+func (fi memoryFileInfo) Size() int64        { return fi.size }
+func (fi memoryFileInfo) Mode() fs.FileMode  { return fi.mode }
+func (fi memoryFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi memoryFileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi memoryFileInfo) Sys() any           { return fi.node }
+
+// memoryFile is an open file of a memoryFileSystem.
+type memoryFile struct {
+	tree   *memoryTree
+	node   *memoryNode
+	name   string // Path the file was opened by.
+	flag   int
+	offset int64
+	closed bool
+}
+
+// check returns an error if the file is closed, or a directory, or if writing it was not
+// requested when opening it and write is true. Callers hold the lock of the tree.
+func (f *memoryFile) check(op string, write bool) error {
+	switch {
+	case f.closed:
+		return &fs.PathError{Op: op, Path: f.name, Err: fs.ErrClosed}
+	case f.node.mode.IsDir():
+		return &fs.PathError{Op: op, Path: f.name, Err: syscall.EISDIR}
+	case write && f.flag&(os.O_WRONLY|os.O_RDWR) == 0:
+		return &fs.PathError{Op: op, Path: f.name, Err: syscall.EBADF}
+	case !write && f.flag&os.O_WRONLY != 0:
+		return &fs.PathError{Op: op, Path: f.name, Err: syscall.EBADF}
+	}
+	return nil
+}
+
+func (f *memoryFile) Read(b []byte) (int, error) {
+	f.tree.mu.Lock()
+	defer f.tree.mu.Unlock()
+	if err := f.check("read", false); err != nil {
+		return 0, err
+	}
+	if f.offset >= int64(len(f.node.data)) {
+		return 0, io.EOF
+	}
+	n := copy(b, f.node.data[f.offset:])
+	f.offset += int64(n)
+	return n, nil
+}
+
+func (f *memoryFile) ReadAt(b []byte, off int64) (int, error) {
+	f.tree.mu.Lock()
+	defer f.tree.mu.Unlock()
+	if err := f.check("read", false); err != nil {
+		return 0, err
+	}
+	if off < 0 {
+		return 0, &fs.PathError{Op: "readat", Path: f.name, Err: fs.ErrInvalid}
+	}
+	if off >= int64(len(f.node.data)) {
+		return 0, io.EOF
+	}
+	n := copy(b, f.node.data[off:])
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *memoryFile) Write(b []byte) (int, error) {
+	f.tree.mu.Lock()
+	defer f.tree.mu.Unlock()
+	if err := f.check("write", true); err != nil {
+		return 0, err
+	}
+	if f.flag&os.O_APPEND != 0 {
+		f.offset = int64(len(f.node.data))
+	}
+	if end := f.offset + int64(len(b)); end > int64(len(f.node.data)) {
+		size := len(f.node.data)
+		f.node.data = slices.Grow(f.node.data, int(end)-size)[:end]
+		if f.offset > int64(size) {
+			// a write past the end leaves a hole of zeros
+			clear(f.node.data[size:f.offset])
+		}
+	}
+	n := copy(f.node.data[f.offset:], b)
+	f.offset += int64(n)
+	f.node.modTime = time.Now()
+	return n, nil
+}
+
+func (f *memoryFile) WriteString(s string) (int, error) {
+	return f.Write([]byte(s))
+}
+
+func (f *memoryFile) Seek(offset int64, whence int) (int64, error) {
+	f.tree.mu.Lock()
+	defer f.tree.mu.Unlock()
+	if f.closed {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrClosed}
+	}
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += int64(len(f.node.data))
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrInvalid}
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *memoryFile) Close() error {
+	f.tree.mu.Lock()
+	defer f.tree.mu.Unlock()
+	if f.closed {
+		return &fs.PathError{Op: "close", Path: f.name, Err: fs.ErrClosed}
+	}
+	f.closed = true
+	return nil
+}
+
+func (f *memoryFile) Name() string {
+	return f.name
+}
+
+func (f *memoryFile) Stat() (fs.FileInfo, error) {
+	f.tree.mu.Lock()
+	defer f.tree.mu.Unlock()
+	if f.closed {
+		return nil, &fs.PathError{Op: "stat", Path: f.name, Err: fs.ErrClosed}
+	}
+	return f.node.info(filepath.Base(f.name)), nil
+}
+
+func (f *memoryFile) Sync() error {
+	// there is no disk to flush to
+	return nil
+}
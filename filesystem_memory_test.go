@@ -0,0 +1,208 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"slices"
+	"syscall"
+	"testing"
+)
+
+func TestMemoryFileSystemFiles(t *testing.T) {
+	setupTestFS(t)
+	if err := writeFile("wug.txt", []byte("This is a wug"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := readFile("wug.txt"); err != nil || string(b) != "This is a wug" {
+		t.Fatalf("Incorrect contents: %q, %v", b, err)
+	}
+
+	// writes append, overwrite, and leave a hole of zeros past the end
+	f, err := repoFS.OpenFile("wug.txt", os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString("!"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("?")); !errors.Is(err, fs.ErrClosed) {
+		t.Fatalf("Writing a closed file should fail: %v", err)
+	}
+	f, err = repoFS.OpenFile("wug.txt", os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(16, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("end")); err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, 4)
+	if n, err := f.ReadAt(b, 10); err != nil || string(b[:n]) != "wug!" {
+		t.Fatalf("Incorrect contents read at an offset: %q, %v", b[:n], err)
+	}
+	if info, err := f.Stat(); err != nil || info.Size() != 19 {
+		t.Fatalf("Incorrect size after writing past the end: %v, %v", info, err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := readFile("wug.txt"); err != nil || string(b) != "This is a wug!\x00\x00end" {
+		t.Fatalf("Incorrect contents after writes: %q, %v", b, err)
+	}
+
+	// files open for reading cannot be written, and exclusive creation fails for existing files
+	f, err = repoFS.Open("wug.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("wug")); !errors.Is(err, syscall.EBADF) {
+		t.Fatalf("Writing a file open for reading should fail: %v", err)
+	}
+	f.Close()
+	if _, err := repoFS.OpenFile("wug.txt", os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644); !errors.Is(err, fs.ErrExist) {
+		t.Fatalf("Exclusive creation of an existing file should fail: %v", err)
+	}
+	if _, err := repoFS.Open("notwug.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Opening a missing file should fail: %v", err)
+	}
+	if _, err := repoFS.Stat("wug.txt/notwug.txt"); !errors.Is(err, syscall.ENOTDIR) {
+		t.Fatalf("A file should not have entries: %v", err)
+	}
+}
+
+func TestMemoryFileSystemDirectories(t *testing.T) {
+	setupTestFS(t)
+	if err := repoFS.MkdirAll("a/b", 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{"a/b/wug.txt", "a/notwug.txt"} {
+		if err := writeFile(file, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err := repoFS.ReadDir("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if !slices.Equal(names, []string{"b", "notwug.txt"}) || !entries[0].IsDir() || entries[1].IsDir() {
+		t.Fatalf("Incorrect directory entries: %v", names)
+	}
+	if err := repoFS.Mkdir("a", 0755); !errors.Is(err, fs.ErrExist) {
+		t.Fatalf("Making an existing directory should fail: %v", err)
+	}
+	if err := repoFS.MkdirAll("a/notwug.txt/c", 0755); !errors.Is(err, syscall.ENOTDIR) {
+		t.Fatalf("Making a directory below a file should fail: %v", err)
+	}
+	if _, err := repoFS.OpenFile("a", os.O_WRONLY, 0644); !errors.Is(err, syscall.EISDIR) {
+		t.Fatalf("Writing a directory should fail: %v", err)
+	}
+
+	// working directories share the tree and resolve relative paths from their directory
+	sub, err := repoFS.Sub("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sub.Stat("b/wug.txt"); err != nil {
+		t.Fatalf("Relative path should resolve from the working directory: %v", err)
+	}
+	if abs, err := repoFS.Abs("a/b"); err != nil {
+		t.Fatal(err)
+	} else if _, err := sub.Stat(abs); err != nil {
+		t.Fatalf("Absolute path should resolve from the root: %v", err)
+	}
+	if _, err := repoFS.Sub("a/notwug.txt"); !errors.Is(err, syscall.ENOTDIR) {
+		t.Fatalf("A file should not be a working directory: %v", err)
+	}
+
+	// only empty directories are removed, unless all of it is
+	if err := repoFS.Remove("a"); !errors.Is(err, syscall.ENOTEMPTY) {
+		t.Fatalf("Removing a directory with entries should fail: %v", err)
+	}
+	if err := repoFS.RemoveAll("a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sub.Stat("b/wug.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Removed files should not exist: %v", err)
+	}
+	if err := repoFS.RemoveAll("a"); err != nil {
+		t.Fatalf("Removing a missing directory should do nothing: %v", err)
+	}
+}
+
+func TestMemoryFileSystemRenameLink(t *testing.T) {
+	setupTestFS(t)
+	if err := repoFS.MkdirAll("a/b", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFile("wug.txt", []byte("This is a wug"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// hard links share their contents
+	if err := repoFS.Link("wug.txt", "a/wug.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := repoFS.Link("wug.txt", "a/wug.txt"); !errors.Is(err, fs.ErrExist) {
+		t.Fatalf("Linking over an existing file should fail: %v", err)
+	}
+	info1, err := repoFS.Stat("wug.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info2, err := repoFS.Stat("a/wug.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !repoFS.SameFile(info1, info2) {
+		t.Fatal("Hard links should be the same file")
+	}
+	if err := writeFile("wug.txt", []byte("This is not a wug"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := readFile("a/wug.txt"); err != nil || string(b) != "This is not a wug" {
+		t.Fatalf("Hard links should share their contents: %q, %v", b, err)
+	}
+
+	// renames replace files and empty directories of the same kind
+	if err := writeFile("notwug.txt", []byte("This is a wug"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := repoFS.Rename("notwug.txt", "wug.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := readFile("wug.txt"); err != nil || string(b) != "This is a wug" {
+		t.Fatalf("Rename should replace the file: %q, %v", b, err)
+	}
+	if _, err := repoFS.Stat("notwug.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Renamed file should not exist: %v", err)
+	}
+	if err := repoFS.Rename("wug.txt", "a/b"); !errors.Is(err, syscall.EISDIR) {
+		t.Fatalf("Renaming a file over a directory should fail: %v", err)
+	}
+	if err := repoFS.Rename("a/b", "wug.txt"); !errors.Is(err, syscall.ENOTDIR) {
+		t.Fatalf("Renaming a directory over a file should fail: %v", err)
+	}
+	if err := repoFS.Rename("a", "a/b/c"); err == nil {
+		t.Fatal("Renaming a directory into itself should fail")
+	}
+	if err := repoFS.Mkdir("c", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := repoFS.Rename("a", "c"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repoFS.Stat("c/b"); err != nil {
+		t.Fatalf("Renamed directory should keep its entries: %v", err)
+	}
+}
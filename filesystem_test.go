@@ -0,0 +1,37 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestGlob(t *testing.T) {
+	setupTestFS(t)
+	if err := repoFS.MkdirAll("src/x", 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{"a.go", "b.txt", "src/c.go", "src/x/d.go"} {
+		if err := writeFile(file, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		pattern  string
+		expected []string
+	}{
+		{"*.go", []string{"a.go"}},
+		{"src/*.go", []string{"src/c.go"}},
+		{"*/*", []string{"src/c.go", "src/x"}},
+		{"src/*/*.go", []string{"src/x/d.go"}},
+		{"b.txt", []string{"b.txt"}},
+		{"nosuch.txt", nil},
+	}
+	for _, test := range tests {
+		if matches, err := glob(test.pattern); err != nil || !slices.Equal(matches, test.expected) {
+			t.Errorf("glob(%q) = %v, %v, want %v", test.pattern, matches, err, test.expected)
+		}
+	}
+	if _, err := glob("["); err == nil {
+		t.Error("Malformed pattern should fail")
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"time"
+)
+
+// getReachableRoots returns the objects that are kept alive directly by the repository: the
+// commits and tag objects named by refs, reflogs, the stash, and in-progress merges and
+// bisects, the blobs staged in the index or recorded for merge conflicts, and note blobs.
+func getReachableRoots() ([]string, error) {
+	var roots []string
+	heads, err := getBranchHeads()
+	if err != nil {
+		return nil, fmt.Errorf("getReachableRoots: %w", err)
+	}
+	for _, branch := range sortedKeys(heads) {
+		roots = append(roots, heads[branch])
+	}
+	headCommitHash, err := getHeadCommitHash()
+	if err != nil {
+		return nil, fmt.Errorf("getReachableRoots: %w", err)
+	}
+	roots = append(roots, headCommitHash)
+	remoteBranches, err := listRefs(remotesDir)
+	if err != nil {
+		return nil, fmt.Errorf("getReachableRoots: %w", err)
+	}
+	for _, file := range remoteBranches {
+		hash, err := readRef(file)
+		if err != nil {
+			return nil, fmt.Errorf("getReachableRoots: %w", err)
+		}
+		roots = append(roots, hash)
+	}
+	tags, err := getTags()
+	if err != nil {
+		return nil, fmt.Errorf("getReachableRoots: %w", err)
+	}
+	for _, name := range sortedKeys(tags) {
+		roots = append(roots, tags[name])
+	}
+	if hash, err := readRef(autosaveRefFile); err == nil {
+		roots = append(roots, hash)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("getReachableRoots: %w", err)
+	}
+	stash, err := readStash()
+	if err != nil {
+		return nil, fmt.Errorf("getReachableRoots: %w", err)
+	}
+	for _, entry := range stash {
+		roots = append(roots, entry.Hash)
+	}
+	// reflog entries keep dropped history recoverable until they expire
+	refs, err := getReflogRefs()
+	if err != nil {
+		return nil, fmt.Errorf("getReachableRoots: %w", err)
+	}
+	for _, ref := range refs {
+		entries, err := readReflog(ref)
+		if err != nil {
+			return nil, fmt.Errorf("getReachableRoots: %w", err)
+		}
+		for _, entry := range entries {
+			roots = append(roots, entry.Old, entry.New)
+		}
+	}
+	index, err := readIndex()
+	if err != nil {
+		return nil, fmt.Errorf("getReachableRoots: %w", err)
+	}
+	for _, file := range sortedKeys(index) {
+		if metadata := index[file]; metadata.Op != indexRemove && metadata.Op != indexSubmodule {
+			roots = append(roots, metadata.Hash)
+		}
+	}
+	state, err := readMergeState()
+	if err != nil {
+		return nil, fmt.Errorf("getReachableRoots: %w", err)
+	}
+	if state != nil {
+		roots = append(roots, state.Base, state.Ours, state.Theirs)
+		for _, conflict := range state.Conflicts {
+			roots = append(roots, conflict.Base, conflict.Ours, conflict.Theirs)
+		}
+	}
+	bisect, err := readBisectState()
+	if err != nil {
+		return nil, fmt.Errorf("getReachableRoots: %w", err)
+	}
+	if bisect != nil {
+		roots = append(roots, bisect.Bad)
+		roots = append(roots, bisect.Good...)
+	}
+	notes, err := readNotes()
+	if err != nil {
+		return nil, fmt.Errorf("getReachableRoots: %w", err)
+	}
+	for _, commitHash := range sortedKeys(notes) {
+		roots = append(roots, notes[commitHash])
+	}
+	return roots, nil
+}
+
+// getReachableObjects returns the set of objects reachable from the roots of the repository:
+// the roots themselves, the commits tagged by tag objects, the ancestors of every reachable
+// commit, the trees and file blobs they track, and the chunks of chunked blobs.
+func getReachableObjects() (map[string]bool, error) {
+	queue, err := getReachableRoots()
+	if err != nil {
+		return nil, fmt.Errorf("getReachableObjects: %w", err)
+	}
+	reachable := make(map[string]bool)
+	for len(queue) > 0 {
+		hash := queue[0]
+		queue = queue[1:]
+		if hash == "" || hash == nullHash || reachable[hash] {
+			continue
+		}
+		reachable[hash] = true
+		header, err := parseBlobHeader(hash)
+		if err != nil {
+			return nil, fmt.Errorf("getReachableObjects: %w", err)
+		}
+		switch header {
+		case "chunked":
+			// file blobs staged or recorded by a merge are roots of their own
+			chunks, err := getBlobChunks(hash)
+			if err != nil {
+				return nil, fmt.Errorf("getReachableObjects: %w", err)
+			}
+			for _, chunkHash := range chunks {
+				reachable[chunkHash] = true
+			}
+		case "tag":
+			commitHash, err := peelTag(hash)
+			if err != nil {
+				return nil, fmt.Errorf("getReachableObjects: %w", err)
+			}
+			queue = append(queue, commitHash)
+		case "commit":
+			c, err := getCommit(hash)
+			if err != nil {
+				return nil, fmt.Errorf("getReachableObjects: %w", err)
+			}
+			if c.Tree != "" {
+				trees, err := getTreeObjects(c.Tree, func(treeHash string) bool { return reachable[treeHash] })
+				if err != nil {
+					return nil, fmt.Errorf("getReachableObjects: %w", err)
+				}
+				for _, treeHash := range trees {
+					reachable[treeHash] = true
+				}
+			}
+			for _, blobHash := range c.FileToBlob {
+				if reachable[blobHash] {
+					continue
+				}
+				reachable[blobHash] = true
+				chunks, err := getBlobChunks(blobHash)
+				if err != nil {
+					return nil, fmt.Errorf("getReachableObjects: %w", err)
+				}
+				for _, chunkHash := range chunks {
+					reachable[chunkHash] = true
+				}
+			}
+			for _, parentHash := range c.ParentUIDs {
+				queue = append(queue, parentHash)
+			}
+		}
+	}
+	return reachable, nil
+}
+
+// collectGarbage deletes the loose objects that are unreachable from the roots of the
+// repository and were last modified longer than gracePeriod before now, so that objects
+// written by a command still in progress are not deleted before they are referenced, and
+// rewrites the commit graph with every reachable commit. Returns the number of objects
+// deleted and the bytes they took up.
+func collectGarbage(gracePeriod time.Duration, now time.Time) (int, int64, error) {
+	unlock, err := lockRepo("gc")
+	if err != nil {
+		return 0, 0, fmt.Errorf("collectGarbage: %w", err)
+	}
+	defer unlock()
+	reachable, err := getReachableObjects()
+	if err != nil {
+		return 0, 0, fmt.Errorf("collectGarbage: %w", err)
+	}
+	var commitHashes []string
+	for hash := range reachable {
+		header, err := parseBlobHeader(hash)
+		if err != nil {
+			return 0, 0, fmt.Errorf("collectGarbage: %w", err)
+		}
+		if header == "commit" {
+			commitHashes = append(commitHashes, hash)
+		}
+	}
+	if err := generateCommitGraph(commitHashes); err != nil {
+		return 0, 0, fmt.Errorf("collectGarbage: %w", err)
+	}
+	s := getStorage()
+	hashes, err := getLooseObjectHashes()
+	if err != nil {
+		return 0, 0, fmt.Errorf("collectGarbage: %w", err)
+	}
+	removed, reclaimed := 0, int64(0)
+	for _, hash := range hashes {
+		if reachable[hash] {
+			continue
+		}
+		info, err := s.statObject(hash)
+		if err != nil {
+			return 0, 0, fmt.Errorf("collectGarbage: %w", err)
+		}
+		if now.Sub(info.ModTime) < gracePeriod {
+			continue
+		}
+		if err := removeObject(hash); err != nil {
+			return 0, 0, fmt.Errorf("collectGarbage: %w", err)
+		}
+		removed++
+		reclaimed += info.Size
+	}
+	return removed, reclaimed, nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestCollectGarbage(t *testing.T) {
+	setupTestRepo(t)
+	commitInRepo(t, ".", "wug.txt", "This is a wug")
+	if err := addNote("HEAD", "a noted wug", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeContents("staged.txt", []string{"This is a staged wug"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stageFile("staged.txt"); err != nil {
+		t.Fatal(err)
+	}
+	index, err := readIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stagedHash := index["staged.txt"].Hash
+	// blob left behind by an aborted staging
+	orphanHash, err := writeBlob("file", []byte("This is an orphaned wug"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, err := getObjectHashes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	removed, _, err := collectGarbage(time.Hour, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 0 {
+		t.Fatalf("Objects within the grace period were removed: %v", removed)
+	}
+	removed, reclaimed, err := collectGarbage(0, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 1 || reclaimed <= 0 {
+		t.Fatalf("Incorrect garbage collected: want 1 object, got %v objects of %v bytes", removed, reclaimed)
+	}
+	if ok, err := hasObject(orphanHash); err != nil || ok {
+		t.Fatalf("Orphaned blob was not removed: %v, %v", ok, err)
+	}
+	for _, hash := range before {
+		if hash == orphanHash {
+			continue
+		}
+		if ok, err := hasObject(hash); err != nil || !ok {
+			t.Fatalf("Reachable object %v was removed: %v, %v", hash, ok, err)
+		}
+	}
+	if ok, err := hasObject(stagedHash); err != nil || !ok {
+		t.Fatalf("Staged blob was removed: %v, %v", ok, err)
+	}
+	headCommitHash, err := getHeadCommitHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if note, ok, err := getNote(headCommitHash); err != nil || !ok || note != "a noted wug" {
+		t.Fatalf("Note was not kept: %q, %v, %v", note, ok, err)
+	}
+}
+
+func TestCollectGarbageStagedChunks(t *testing.T) {
+	setupTestRepo(t)
+	if err := setConfig("chunk.threshold", "1000"); err != nil {
+		t.Fatal(err)
+	}
+	contents := randomContents(100 << 10)
+	if err := writeContents("wug.bin", [][]byte{contents}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stageFile("wug.bin"); err != nil {
+		t.Fatal(err)
+	}
+	index, err := readIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stagedHash := index["wug.bin"].Hash
+	if header, err := parseBlobHeader(stagedHash); err != nil || header != "chunked" {
+		t.Fatalf("Staged file should be stored as a chunked blob: %q, %v", header, err)
+	}
+
+	if _, _, err := collectGarbage(0, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if _, blobContents, err := readBlob(stagedHash); err != nil || !bytes.Equal(blobContents, contents) {
+		t.Fatalf("Chunks of a staged file were removed: %v", err)
+	}
+	if err := newCommit("add large wug"); err != nil {
+		t.Fatal(err)
+	}
+}
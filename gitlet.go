@@ -1,21 +1,20 @@
 package main
 
 import (
+	"cmp"
 	"errors"
 	"fmt"
 	"io/fs"
 	"log"
-	"os"
 	"path/filepath"
+	"regexp"
 	"slices"
+	"strconv"
 	"strings"
 	"time"
 )
 
-const (
-	gitletDir              string = ".gitlet"
-	stagedForRemovalMarker string = "DELETED"
-)
+const gitletDir string = ".gitlet"
 
 var (
 	objectsDir  string = filepath.Join(gitletDir, "objects")
@@ -30,23 +29,27 @@ var (
 // newRepository creates a new Gitlet repository with an initial commit and a main branch.
 // The repository stored in .gitlet contains the necessary directories and files for Gitlet.
 func newRepository() error {
-	if dirInfo, err := os.Stat(gitletDir); err == nil {
+	if dirInfo, err := repoFS.Stat(gitletDir); err == nil {
 		if dirInfo.IsDir() {
-			log.Fatal("A Gitlet version-control system already exists in the current directory.")
+			exit("A Gitlet version-control system already exists in the current directory.")
 		}
 	} else if !errors.Is(err, fs.ErrNotExist) {
 		return fmt.Errorf("newRepository: %w", err)
 	}
 
 	if err := errors.Join(
-		os.Mkdir(gitletDir, 0755),
-		os.Mkdir(objectsDir, 0755),
-		os.Mkdir(refsDir, 0755),
-		os.Mkdir(branchesDir, 0755),
-		os.Mkdir(remotesDir, 0755),
+		repoFS.Mkdir(gitletDir, 0755),
+		repoFS.Mkdir(objectsDir, 0755),
+		repoFS.Mkdir(refsDir, 0755),
+		repoFS.Mkdir(branchesDir, 0755),
+		repoFS.Mkdir(tagsDir, 0755),
+		repoFS.Mkdir(remotesDir, 0755),
 	); err != nil {
 		return fmt.Errorf("newRepository: cannot create dirs: %w", err)
 	}
+	if err := setConfig("core.repositoryFormatVersion", strconv.Itoa(repositoryFormatVersion)); err != nil {
+		return fmt.Errorf("newRepository: %w", err)
+	}
 
 	initialCommit := commit{
 		Message:    "initial commit",
@@ -59,24 +62,32 @@ func newRepository() error {
 	if err != nil {
 		return fmt.Errorf("initRepository: cannot serialize initial commit: %w", err)
 	}
-	payload := []any{"commit", []byte{blobHeaderDelim}, contents}
+	payload := blobPayload("commit", contents)
 	initialCommitHash, err := getHash(payload)
 	if err != nil {
 		return fmt.Errorf("initRepository: cannot get initial commit hash: %w", err)
 	}
-	err = writeContents(filepath.Join(objectsDir, initialCommitHash), payload)
+	err = writeLooseObject(initialCommitHash, payload)
 	if err != nil {
 		return fmt.Errorf("initRepository: cannot write initial commit blob: %w", err)
 	}
+	if err := addToCommitGraph(initialCommitHash, initialCommit); err != nil {
+		return fmt.Errorf("initRepository: %w", err)
+	}
 
 	// create main branch
 	mainBranchFile := filepath.Join(branchesDir, "main")
-	if err := writeContents(mainBranchFile, []string{initialCommitHash}); err != nil {
+	if err := writeRef(mainBranchFile, initialCommitHash, "init"); err != nil {
 		return fmt.Errorf("initRepository: cannot create main branch: %w", err)
 	}
+	for _, ref := range []string{"HEAD", "main"} {
+		if err := logRefUpdate(ref, "", initialCommitHash, "commit (initial): initial commit"); err != nil {
+			return fmt.Errorf("initRepository: %w", err)
+		}
+	}
 
 	// set current branch to main branch
-	if err := writeContents(headFile, []string{mainBranchFile}); err != nil {
+	if err := writeRef(headFile, mainBranchFile, "init"); err != nil {
 		return fmt.Errorf("initRepository: cannot set HEAD file: %w", err)
 	}
 
@@ -92,143 +103,200 @@ func newRepository() error {
 	return nil
 }
 
-// stageFile stages a file to be committed.
+// stageFile stages a file to be committed, as stageFiles does for a single file.
+func stageFile(file string) error {
+	if err := stageFiles([]string{file}); err != nil {
+		return fmt.Errorf("stageFile: %w", err)
+	}
+	return nil
+}
+
+// stageFiles stages files to be committed. The blobs of files in the working directory are
+// hashed and written concurrently, then the index is updated once for every file.
 //
 // If the file is already staged and identical to the file in the working directory, the staging operation is skipped.
 // If the file is already staged but modified in the working directory, the file is re-staged, overwriting the previously staged version.
 // If the file is already staged, not in the working directory, and tracked in the head commit, the file is already staged for deletion and staging is skipped.
 // If the file is not staged, not in the working directory, and tracked in the head commit, then it is staged for deletion.
 // If the file is not yet staged and modified, the file will be staged.
-func stageFile(file string) error {
+//
+// Exits without staging any file if a file is neither in the working directory, staged, nor tracked.
+func stageFiles(files []string) error {
+	submodules, err := readSubmodules()
+	if err != nil {
+		return fmt.Errorf("stageFiles: %w", err)
+	}
+	normalized := make([]string, 0, len(files))
+	for _, file := range files {
+		file, err := normalizePath(file)
+		if err != nil {
+			return fmt.Errorf("stageFiles: %w", err)
+		}
+		if _, ok := submodules[file]; ok {
+			if err := stageSubmodule(file); err != nil {
+				return fmt.Errorf("stageFiles: %w", err)
+			}
+			continue
+		}
+		normalized = append(normalized, file)
+	}
+	files = normalized
 	headCommit, err := getHeadCommit()
 	if err != nil {
-		return fmt.Errorf("stageFile: cannot get head commit: %w", err)
+		return fmt.Errorf("stageFiles: cannot get head commit: %w", err)
 	}
-	trackedHash, isTracked := headCommit.FileToBlob[file]
 
+	unlock, err := lockFile(indexFile, "add")
+	if err != nil {
+		return fmt.Errorf("stageFiles: %w", err)
+	}
+	defer unlock()
 	index, err := readIndex()
 	if err != nil {
-		return fmt.Errorf("stageFile: cannot read index file: %w", err)
+		return fmt.Errorf("stageFiles: cannot read index file: %w", err)
 	}
-	stagedMetadata, isStaged := index[file]
 
-	wdInfo, err := os.Stat(file)
-	if err != nil {
+	// files whose metadata matches the index are not read again
+	wdInfos := make(map[string]fs.FileInfo, len(files))
+	var changedFiles []string
+	for _, file := range files {
+		wdInfo, err := repoFS.Stat(file)
 		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("stageFiles: cannot stat file '%v': %w", file, err)
+		}
+		wdInfos[file] = wdInfo
+		stagedMetadata, isStaged := index[file]
+		if isStaged && stagedMetadata.Op != indexRemove &&
+			(wdInfo.Size() == stagedMetadata.FileSize) &&
+			(wdInfo.ModTime().Unix() == stagedMetadata.ModTime) {
+			continue
+		}
+		changedFiles = append(changedFiles, file)
+	}
+	wdBlobs, err := writeWorkingBlobs(changedFiles)
+	if err != nil {
+		return fmt.Errorf("stageFiles: %w", err)
+	}
+
+	var staleObjects []indexMetadata
+	for _, file := range files {
+		trackedHash, isTracked := headCommit.FileToBlob[file]
+		stagedMetadata, isStaged := index[file]
+		if _, ok := wdInfos[file]; !ok {
 			if isTracked {
 				// path: not in WD (modified), is staged (for deletion), is tracked
-				if isStaged && stagedMetadata.Hash == stagedForRemovalMarker {
+				if isStaged && stagedMetadata.Op == indexRemove {
 					log.Printf("File '%v' is already staged.\n", file)
-					return nil
+					continue
 				}
 				// path: not in WD (modified), not staged (for deletion), is tracked
 				// stage file for deletion
-				index[file] = indexMetadata{stagedForRemovalMarker, time.Now().Unix(), 0}
-				if err := writeIndex(index); err != nil {
-					return fmt.Errorf("stageFile: could not stage file for deletion: %w", err)
-				}
-				return nil
-			} else {
 				if isStaged {
-					// path: not in WD
-					// remove staged blob
-					if err := restrictedDelete(filepath.Join(objectsDir, stagedMetadata.Hash)); err != nil {
-						return fmt.Errorf("stageFile: cannot delete old file blob: %w", err)
-					}
-					// delete from index
-					delete(index, file)
-					if err := writeIndex(index); err != nil {
-						return fmt.Errorf("stageFile: could not remove file from index: %w", err)
-					}
-					return nil
-				} else {
-					log.Fatal("File does not exist.")
+					staleObjects = append(staleObjects, stagedMetadata)
 				}
+				index[file] = indexMetadata{indexRemove, "", time.Now().Unix(), 0, false}
+			} else if isStaged {
+				// path: not in WD
+				// remove staged blob and delete from index
+				staleObjects = append(staleObjects, stagedMetadata)
+				delete(index, file)
+			} else {
+				exit("File does not exist.")
 			}
-		} else {
-			return fmt.Errorf("stageFile: cannot stat file '%v': %w", file, err)
+			continue
 		}
-	}
 
-	// compare metadata of WD and index
-	if isStaged &&
-		(wdInfo.Size() == stagedMetadata.FileSize) &&
-		(wdInfo.ModTime().Unix() == stagedMetadata.ModTime) {
-		log.Printf("File '%v' is already staged.\n", file)
-		return nil
-	}
+		// compare metadata of WD and index
+		wdBlob, ok := wdBlobs[file]
+		if !ok {
+			log.Printf("File '%v' is already staged.\n", file)
+			continue
+		}
+		// compare hashes of WD and index, or WD and head commit, whose blobs may be legacy
+		// ones with another hash for the same contents
+		expectedHash, expected := stagedMetadata.Hash, isStaged
+		if !isStaged {
+			expectedHash, expected = trackedHash, isTracked
+		}
+		if expected {
+			matches, err := workingFileMatches(file, wdBlob.Hash, expectedHash)
+			if err != nil {
+				return fmt.Errorf("stageFiles: %w", err)
+			}
+			if matches && wdBlob.Hash != expectedHash {
+				// the blob just written for the contents of a legacy blob is not needed
+				staleObjects = append(staleObjects, indexMetadata{indexAdd, wdBlob.Hash, 0, wdBlob.Size, wdBlob.Created})
+			}
+			if matches && isStaged {
+				log.Printf("File '%v' is already staged.\n", file)
+				continue
+			} else if matches {
+				log.Printf("No changes detected. Skipping staging...\n")
+				continue
+			}
+		}
 
-	// compare hashes of WD and index
-	wdContents, err := readContents(file)
-	if err != nil {
-		return fmt.Errorf("stageFile: cannot read file '%v': %w", file, err)
+		// path: file exists in WD and is modified
+		// previously staged file blob is now outdated
+		if isStaged {
+			staleObjects = append(staleObjects, stagedMetadata)
+		}
+		index[file] = indexMetadata{indexAdd, wdBlob.Hash, time.Now().Unix(), wdBlob.Size, wdBlob.Created}
 	}
-	wdBlobPayload := []any{"file", []byte{blobHeaderDelim}, wdContents}
-	wdHash, err := getHash(wdBlobPayload)
-	if err != nil {
-		return fmt.Errorf("stageFile: cannot get file hash: %w", err)
+	if err = writeIndex(index); err != nil {
+		return fmt.Errorf("stageFiles: could not update file index: %w", err)
 	}
-	if isStaged && (wdHash == stagedMetadata.Hash) {
-		log.Printf("File '%v' is already staged.\n", file)
+
+	// outdated blobs are kept while another staged or tracked file has the same contents
+	if len(staleObjects) == 0 {
 		return nil
 	}
-	// compare hashes of WD and head commit
-	if !isStaged && isTracked && (wdHash == trackedHash) {
-		log.Printf("No changes detected. Skipping staging...\n")
-		return nil
+	referenced := make(map[string]bool)
+	for _, hash := range headCommit.FileToBlob {
+		referenced[hash] = true
 	}
-
-	// path: file exists in WD and is modified
-
-	// remove previously staged file blob that is now outdated
-	if isStaged {
-		if err := restrictedDelete(filepath.Join(objectsDir, stagedMetadata.Hash)); err != nil {
-			return fmt.Errorf("stageFile: cannot delete old file blob: %w", err)
+	for _, metadata := range staleObjects {
+		if referenced[metadata.Hash] {
+			continue
+		}
+		if err := removeStagedObject(metadata, index); err != nil {
+			return fmt.Errorf("stageFiles: cannot delete old file blob: %w", err)
 		}
-	}
-
-	// file is not already staged or should be re-staged
-	wdBlobFile := filepath.Join(objectsDir, wdHash)
-	if err = writeContents(wdBlobFile, wdBlobPayload); err != nil {
-		return fmt.Errorf("stageFile: could not write staged file blob: %w", err)
-	}
-
-	// update file index
-	index[file] = indexMetadata{wdHash, time.Now().Unix(), int64(len(wdContents))}
-	if err = writeIndex(index); err != nil {
-		return fmt.Errorf("stageFile: could not update file index: %w", err)
 	}
 	return nil
 }
 
-func writeCommit(c commit) (string, error) {
+// writeCommit writes a commit of the staged files, moves the current branch to it, and clears
+// the index. The reflog entries name the action that made the commit, such as "commit".
+func writeCommit(c commit, action string) (string, error) {
+	unlock, err := lockFile(indexFile, "commit")
+	if err != nil {
+		return "", fmt.Errorf("writeCommit: %w", err)
+	}
+	defer unlock()
 	index, err := readIndex()
 	if err != nil {
 		return "", fmt.Errorf("writeCommit: %w", err)
 	}
-	if len(index) == 0 {
-		log.Fatal("No changes added to commit.")
+	// a merge commit records the merged history even if it keeps every file as it was
+	if len(index) == 0 && c.ParentUIDs[1] == "" {
+		exit("No changes added to commit.")
 	}
 
-	contents, err := serialize(c)
+	commitHash, err := writeCommitBlob(c)
 	if err != nil {
-		return "", fmt.Errorf("writeCommit: could not serialize commit: %w", err)
-	}
-	payload := []any{"commit", []byte{blobHeaderDelim}, contents}
-	commitHash, err := getHash(payload)
-	if err != nil {
-		return "", fmt.Errorf("writeCommit: could not create commit hash: %w", err)
-	}
-	if err := writeContents(filepath.Join(objectsDir, commitHash), payload); err != nil {
 		return "", fmt.Errorf("writeCommit: cannot write commit blob: %w", err)
 	}
 
 	// set current branch head commit to new commit
-	currentBranchFile, err := readContentsAsString(headFile)
-	if err != nil {
-		return "", fmt.Errorf("writeCommit: %w", err)
+	subject, _, _ := strings.Cut(c.Message, "\n")
+	reflogMessage := action + ": " + subject
+	if c.ParentUIDs[1] != "" {
+		reflogMessage = action + " (merge): " + subject
 	}
-	if err := writeContents(currentBranchFile, []string{commitHash}); err != nil {
+	if err := setHeadCommit(commitHash, reflogMessage); err != nil {
 		return "", fmt.Errorf("writeCommit: cannot update current branch file: %w", err)
 	}
 
@@ -236,61 +304,86 @@ func writeCommit(c commit) (string, error) {
 	if err := newIndex(); err != nil {
 		return "", fmt.Errorf("newCommit: cannot clear index: %w", err)
 	}
+	// committing resolves the conflicts of the last merge
+	if err := clearMergeState(); err != nil {
+		return "", fmt.Errorf("writeCommit: %w", err)
+	}
 	return commitHash, nil
 }
 
 // newCommit creates a new commit.
 // Returns an error if commit message is empty or if no files are staged.
 func newCommit(message string) error {
+	if err := newCommitAs(message, "commit"); err != nil {
+		return fmt.Errorf("newCommit: %w", err)
+	}
+	return nil
+}
+
+// newCommitAs creates a new commit like newCommit, recording the action that made it, such
+// as "cherry-pick", in the reflog entries.
+func newCommitAs(message string, action string) error {
 	if message == "" {
-		log.Fatal("Please enter a commit message.")
+		exit("Please enter a commit message.")
 	}
 	index, err := readIndex()
 	if err != nil {
-		return fmt.Errorf("newCommit: %w", err)
+		return fmt.Errorf("newCommitAs: %w", err)
 	}
 	if len(index) == 0 {
-		log.Fatal("No changes added to commit.")
+		exit("No changes added to commit.")
 	}
 
+	author, err := getIdentity(authorRole)
+	if err != nil {
+		return fmt.Errorf("newCommitAs: %w", err)
+	}
+	committer, err := getIdentity(committerRole)
+	if err != nil {
+		return fmt.Errorf("newCommitAs: %w", err)
+	}
 	c := commit{
 		Message:    message,
 		Timestamp:  time.Now().UTC().Unix(),
 		FileToBlob: make(map[string]string),
 		ParentUIDs: [2]string{},
+		Author:     author,
+		Committer:  committer,
 	}
 
 	// set current head commit as parent
-	currentBranchFile, err := readContentsAsString(headFile)
-	if err != nil {
-		return fmt.Errorf("newCommit: %w", err)
-	}
-	headCommitHash, err := readContentsAsString(currentBranchFile)
+	headCommitHash, err := getHeadCommitHash()
 	if err != nil {
-		return fmt.Errorf("newCommit: %w", err)
+		return fmt.Errorf("newCommitAs: %w", err)
 	}
 	c.ParentUIDs[0] = headCommitHash
 
 	headCommit, err := getCommit(headCommitHash)
 	if err != nil {
-		return fmt.Errorf("newCommit: %w", err)
+		return fmt.Errorf("newCommitAs: %w", err)
 	}
 	// create file to blob mapping from the previous commit
 	for file, blobUID := range headCommit.FileToBlob {
 		c.FileToBlob[file] = blobUID
 	}
+	for path, commitHash := range headCommit.Submodules {
+		pinSubmodule(&c, path, commitHash)
+	}
 	// overwrite mapping with staged files
 	for file, metadata := range index {
-		if metadata.Hash == stagedForRemovalMarker {
+		switch metadata.Op {
+		case indexRemove:
 			// remove file from commit if it is staged for deletion
 			delete(c.FileToBlob, file)
-		} else {
+		case indexSubmodule:
+			pinSubmodule(&c, file, metadata.Hash)
+		default:
 			c.FileToBlob[file] = metadata.Hash
 		}
 	}
 
-	if _, err := writeCommit(c); err != nil {
-		return fmt.Errorf("newCommit: %w", err)
+	if _, err := writeCommit(c, action); err != nil {
+		return fmt.Errorf("newCommitAs: %w", err)
 	}
 	return nil
 }
@@ -300,6 +393,15 @@ func newCommit(message string) error {
 // deletion and removed from the working directory if not already removed.
 // Returns an error if the file is not staged or tracked by head commit.
 func unstageFile(file string) error {
+	file, err := normalizePath(file)
+	if err != nil {
+		return fmt.Errorf("unstageFile: %w", err)
+	}
+	unlock, err := lockFile(indexFile, "rm")
+	if err != nil {
+		return fmt.Errorf("unstageFile: %w", err)
+	}
+	defer unlock()
 	index, err := readIndex()
 	if err != nil {
 		return fmt.Errorf("unstageFile: %w", err)
@@ -308,10 +410,10 @@ func unstageFile(file string) error {
 
 	// Unstage the file if it is currently staged for addition.
 	if isStaged {
-		if err := restrictedDelete(filepath.Join(objectsDir, stagedMetadata.Hash)); err != nil {
+		delete(index, file)
+		if err := removeStagedObject(stagedMetadata, index); err != nil {
 			return fmt.Errorf("unstageFile: %w", err)
 		}
-		delete(index, file)
 		if err := writeIndex(index); err != nil {
 			return fmt.Errorf("unstageFile: %w", err)
 		}
@@ -323,7 +425,7 @@ func unstageFile(file string) error {
 	}
 	_, isTracked := headCommit.FileToBlob[file]
 	if !isStaged && !isTracked {
-		log.Fatal("No reason to remove the file.")
+		exit("No reason to remove the file.")
 	}
 
 	// Stage for deletion if the file is tracked in the head commit.
@@ -340,76 +442,236 @@ func unstageFile(file string) error {
 	return nil
 }
 
+// logOptions configures which commits log prints.
+type logOptions struct {
+	MaxCount int  // Most commits to print, or 0 for no limit.
+	Oneline  bool // Print each commit as its abbreviated hash and subject on one line.
+	Graph    bool // Follow both parents of merges and draw the history as a graph.
+
+	Author string         // Only print commits whose author contains this, if not empty.
+	Since  time.Time      // Only print commits made at or after this time, if not zero.
+	Until  time.Time      // Only print commits made at or before this time, if not zero.
+	Grep   *regexp.Regexp // Only print commits whose message matches this, if not nil.
+	Paths  []string       // Only print commits that changed one of these files, if not empty.
+	Follow bool           // Follow the single file in Paths across renames.
+
+	Pickaxe   string          // Only print commits that added or removed this, if not empty.
+	LineRange *lineRangeTrace // Only print commits that changed the traced range, if not nil.
+}
+
+// filtered reports whether the options skip some commits by author, date, message, path, or
+// changes to their contents.
+func (opts logOptions) filtered() bool {
+	return opts.Author != "" || !opts.Since.IsZero() || !opts.Until.IsZero() || opts.Grep != nil ||
+		len(opts.Paths) > 0 || opts.Pickaxe != "" || opts.LineRange != nil
+}
+
+// matches reports whether a commit passes the author, date, and message filters.
+func (opts logOptions) matches(c commit) bool {
+	switch {
+	case opts.Author != "" && !strings.Contains(c.Author, opts.Author):
+		return false
+	case !opts.Since.IsZero() && c.Timestamp < opts.Since.Unix():
+		return false
+	case !opts.Until.IsZero() && c.Timestamp > opts.Until.Unix():
+		return false
+	case opts.Grep != nil && !opts.Grep.MatchString(c.Message):
+		return false
+	}
+	return true
+}
+
+// commitChangesFiles reports whether a commit added, removed, or modified any of the given
+// files compared to its first parent.
+func commitChangesFiles(c commit, files []string) (bool, error) {
+	var parentFiles map[string]string
+	if c.ParentUIDs[0] != "" {
+		parent, err := getCommit(c.ParentUIDs[0])
+		if err != nil {
+			return false, fmt.Errorf("commitChangesFiles: %w", err)
+		}
+		parentFiles = parent.FileToBlob
+	}
+	for _, file := range files {
+		if c.FileToBlob[file] != parentFiles[file] {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// getLogCommits returns the commits log prints, newest first: the first-parent history of
+// a commit, without commits that do not touch the current scope or the given paths, or
+// do not match the filters, limited by the options. Following a file, the commits that
+// changed it under its earlier names are included too. Tracing a line range, which must
+// start from the commit, the history ends with the commit that introduced the range.
+func getLogCommits(commitHash string, opts logOptions) ([]string, error) {
+	var commitHashes []string
+	paths := slices.Clone(opts.Paths)
+	// the files of commits are only read to check the given paths or their contents
+	load := getCommitMetadata
+	if len(paths) > 0 || opts.Pickaxe != "" || opts.LineRange != nil {
+		load = getCommit
+	}
+	for commitHash != "" && (opts.MaxCount <= 0 || len(commitHashes) < opts.MaxCount) {
+		if opts.LineRange != nil && opts.LineRange.Done {
+			break
+		}
+		c, err := load(commitHash)
+		if err != nil {
+			return nil, fmt.Errorf("getLogCommits: %w", err)
+		}
+		touchesScope := true
+		// the range is traced through every commit, so it is mapped onto each parent
+		if opts.LineRange != nil {
+			if touchesScope, err = opts.LineRange.step(commitHash, c); err != nil {
+				return nil, fmt.Errorf("getLogCommits: %w", err)
+			}
+		}
+		if touchesScope && scope != "" {
+			if touchesScope, err = commitTouchesScope(c); err != nil {
+				return nil, fmt.Errorf("getLogCommits: %w", err)
+			}
+		}
+		if touchesScope && len(paths) > 0 {
+			if touchesScope, err = commitChangesFiles(c, paths); err != nil {
+				return nil, fmt.Errorf("getLogCommits: %w", err)
+			}
+		}
+		touchesScope = touchesScope && opts.matches(c)
+		if touchesScope && opts.Pickaxe != "" {
+			if touchesScope, err = changesOccurrences(c, opts.Pickaxe); err != nil {
+				return nil, fmt.Errorf("getLogCommits: %w", err)
+			}
+		}
+		if touchesScope {
+			commitHashes = append(commitHashes, commitHash)
+		}
+		if opts.Follow {
+			if paths[0], err = followRename(c, paths[0]); err != nil {
+				return nil, fmt.Errorf("getLogCommits: %w", err)
+			}
+		}
+		commitHash = c.ParentUIDs[0] // traverse up first parent
+	}
+	return commitHashes, nil
+}
+
 // printBranchLog prints the commit log from head of current branch to initial commit.
-func printBranchLog() error {
+// With a scope, only commits that changed files in the scope are printed. A graph shows
+// every commit reachable from the head, so it is not restricted to a scope or filtered.
+// Tracing a line range from the head, each commit is followed by its diff of the range.
+func printBranchLog(opts logOptions) error {
 	headCommitHash, err := getHeadCommitHash()
 	if err != nil {
 		return fmt.Errorf("printBranchLog: %w", err)
 	}
-	headCommit, err := getCommit(headCommitHash)
+	if opts.Graph {
+		commitHashes, commits, err := getGraphCommits(headCommitHash, opts.MaxCount)
+		if err != nil {
+			return fmt.Errorf("printBranchLog: %w", err)
+		}
+		for _, line := range drawGraph(commitHashes, commits, opts.Oneline) {
+			log.Println(line)
+		}
+		return nil
+	}
+	commitHashes, err := getLogCommits(headCommitHash, opts)
 	if err != nil {
 		return fmt.Errorf("printBranchLog: %w", err)
 	}
-	var curr = headCommit
-	var currHash = headCommitHash
-	for {
-		log.Printf("===\n%v\n", curr.String(currHash))
-		if curr.ParentUIDs[0] == "" {
-			break
+	for _, commitHash := range commitHashes {
+		c, err := getCommitMetadata(commitHash)
+		if err != nil {
+			return fmt.Errorf("printBranchLog: %w", err)
+		}
+		var change string
+		if opts.LineRange != nil {
+			change = opts.LineRange.Changes[commitHash].String()
 		}
-		currHash = curr.ParentUIDs[0] // traverse up first parent
-		if curr, err = getCommit(currHash); err != nil {
+		if opts.Oneline {
+			log.Printf("%v\n%v", c.Oneline(commitHash), change)
+			continue
+		}
+		note, err := formatNote(commitHash)
+		if err != nil {
 			return fmt.Errorf("printBranchLog: %w", err)
 		}
+		log.Printf("===\n%v%v\n%v", c.String(commitHash), note, change)
 	}
 	return nil
 }
 
-// printAllCommits prints the log of all commits in any order.
-func printAllCommits() error {
-	if err := filepath.WalkDir(
-		objectsDir,
-		func(path string, d fs.DirEntry, err error) error {
-			if d.IsDir() {
-				return nil
-			}
-			c, c_err := getCommit(d.Name())
-			if c_err != nil {
-				return c_err
-			}
-			log.Printf("===\n%v\n", c.String(d.Name()))
-			return err
-		},
-	); err != nil {
+// getAllCommits returns the hashes of every commit in the repository, newest first, with
+// commits made at the same time ordered by hash, and the commits by hash. Only the headers
+// of other objects are read.
+func getAllCommits() ([]string, map[string]commit, error) {
+	hashes, err := getObjectHashes()
+	if err != nil {
+		return nil, nil, fmt.Errorf("getAllCommits: %w", err)
+	}
+	var commitHashes []string
+	commits := make(map[string]commit)
+	for _, hash := range hashes {
+		header, err := parseBlobHeader(hash)
+		if err != nil {
+			return nil, nil, fmt.Errorf("getAllCommits: %w", err)
+		}
+		if header != "commit" {
+			continue
+		}
+		if commits[hash], err = getCommitMetadata(hash); err != nil {
+			return nil, nil, fmt.Errorf("getAllCommits: %w", err)
+		}
+		commitHashes = append(commitHashes, hash)
+	}
+	slices.SortFunc(commitHashes, func(a, b string) int {
+		return cmp.Or(cmp.Compare(commits[b].Timestamp, commits[a].Timestamp), strings.Compare(a, b))
+	})
+	return commitHashes, commits, nil
+}
+
+// printAllCommits prints the log of all commits, newest first, or oldest first if reversed.
+func printAllCommits(reverse bool) error {
+	commitHashes, commits, err := getAllCommits()
+	if err != nil {
 		return fmt.Errorf("printAllCommits: %w", err)
 	}
+	if reverse {
+		slices.Reverse(commitHashes)
+	}
+	for _, hash := range commitHashes {
+		c := commits[hash]
+		log.Printf("===\n%v\n", c.String(hash))
+	}
 	return nil
 }
 
-// printMatchingCommits prints all UIDs of commits with messages that contain a given substring query.
-func printMatchingCommits(query string) error {
-	hasMatch := false
-	if err := filepath.WalkDir(
-		objectsDir,
-		func(path string, d fs.DirEntry, err error) error {
-			if d.IsDir() {
-				return nil
-			}
-			c, c_err := getCommit(d.Name())
-			if c_err != nil {
-				return c_err
-			}
-			if strings.Contains(c.Message, query) {
-				hasMatch = true
-				log.Printf("commit %v\n", d.Name())
-			}
-			return err
-		},
-	); err != nil {
+// printMatchingCommits prints the hashes of all commits, newest first, that pass the author,
+// date, and message filters of the options, or their full log entries if long. Exits if no
+// commit passes them.
+func printMatchingCommits(opts logOptions, long bool) error {
+	commitHashes, commits, err := getAllCommits()
+	if err != nil {
 		return fmt.Errorf("printMatchingCommits: %w", err)
 	}
-	if !hasMatch {
-		log.Fatal("Found no commit with that message.")
+	hasMatch := false
+	for _, hash := range commitHashes {
+		c := commits[hash]
+		if !opts.matches(c) {
+			continue
+		}
+		hasMatch = true
+		if long {
+			log.Printf("===\n%v\n", c.String(hash))
+		} else {
+			log.Printf("commit %v\n", hash)
+		}
+	}
+	if !hasMatch && opts.Grep == nil {
+		exit("Found no matching commit.")
+	} else if !hasMatch {
+		exit("Found no commit with that message.")
 	}
 	return nil
 }
@@ -417,16 +679,22 @@ func printMatchingCommits(query string) error {
 // printStatus prints the current state of the repository.
 func printStatus() error {
 	log.Println("=== Branches ===")
-	currentBranchFile, err := readContentsAsString(headFile)
+	currentBranch, err := getCurrentBranch()
 	if err != nil {
 		return fmt.Errorf("printStatus: %w", err)
 	}
-	currentBranch := filepath.Base(currentBranchFile)
-	branches, err := getFilenames(branchesDir)
+	branches, err := getRefNames(branchesDir)
 	if err != nil {
 		return fmt.Errorf("printStatus: %w", err)
 	}
 	slices.Sort(branches)
+	if currentBranch == "" {
+		headCommitHash, err := getHeadCommitHash()
+		if err != nil {
+			return fmt.Errorf("printStatus: %w", err)
+		}
+		log.Printf("*(HEAD detached at %v)\n", headCommitHash[:6])
+	}
 	for _, branch := range branches {
 		if branch == currentBranch {
 			log.Printf("*%v\n", branch)
@@ -441,7 +709,10 @@ func printStatus() error {
 	}
 	var staged, removed []string
 	for file, stagedMetadata := range index {
-		if stagedMetadata.Hash == stagedForRemovalMarker {
+		if !inScope(file) {
+			continue
+		}
+		if stagedMetadata.Op == indexRemove {
 			removed = append(removed, file)
 		} else {
 			staged = append(staged, file)
@@ -461,33 +732,36 @@ func printStatus() error {
 	}
 
 	log.Println("\n=== Modifications Not Staged For Commit ===")
-	headCommit, err := getHeadCommit()
+	_, headFiles, err := getHeadScopeFiles()
+	if err != nil {
+		return fmt.Errorf("printStatus: %w", err)
+	}
+	// only files whose stat info changed since the last status are hashed
+	sc, err := readStatCache()
 	if err != nil {
 		return fmt.Errorf("printStatus: %w", err)
 	}
 	var unstagedChanges []string
 	// check tracked files (deleted in WD, modified and unstaged in WD)
-	for trackedFile, trackedHash := range headCommit.FileToBlob {
+	for trackedFile, trackedHash := range headFiles {
 		_, isStaged := index[trackedFile]
-		if isStaged {
+		if isStaged || !inScope(trackedFile) {
 			continue
 		}
-		contents, err := readContents(trackedFile)
+		wdHash, err := sc.hash(trackedFile)
 
 		// check if deleted
 		if errors.Is(err, fs.ErrNotExist) {
 			unstagedChanges = append(unstagedChanges, fmt.Sprintf("%v (deleted)", trackedFile))
+			continue
 		} else if err != nil {
 			return fmt.Errorf("printStatus: %w", err)
 		}
 
 		// check if modified
-		payload := []any{"file", []byte{blobHeaderDelim}, contents}
-		wdHash, err := getHash(payload)
-		if err != nil {
+		if matches, err := workingFileMatches(trackedFile, wdHash, trackedHash); err != nil {
 			return fmt.Errorf("printStatus: %w", err)
-		}
-		if wdHash != trackedHash {
+		} else if !matches {
 			unstagedChanges = append(unstagedChanges, fmt.Sprintf("%v (modified)", trackedFile))
 		}
 	}
@@ -495,57 +769,87 @@ func printStatus() error {
 	// check staged files (deleted in WD, modified in WD)
 	// TODO: combine iteration with Staged and Removed sections
 	for stagedFile, stagedMetadata := range index {
-		// skip files staged for removal
-		if stagedMetadata.Hash == stagedForRemovalMarker {
+		// skip files staged for removal, submodules, and files outside the scope
+		if stagedMetadata.Op == indexRemove || stagedMetadata.Op == indexSubmodule || !inScope(stagedFile) {
 			continue
 		}
 
-		contents, err := readContents(stagedFile)
+		wdHash, err := sc.hash(stagedFile)
 		// check if deleted
 		if errors.Is(err, fs.ErrNotExist) {
 			unstagedChanges = append(unstagedChanges, fmt.Sprintf("%v (deleted)", stagedFile))
 		} else if err != nil {
 			return fmt.Errorf("printStatus: %w", err)
-		} else {
+		} else if matches, err := workingFileMatches(stagedFile, wdHash, stagedMetadata.Hash); err != nil {
+			return fmt.Errorf("printStatus: %w", err)
+		} else if !matches {
 			// check if modified
-			payload := []any{"file", []byte{blobHeaderDelim}, contents}
-			wdHash, err := getHash(payload)
-			if err != nil {
-				return fmt.Errorf("printStatus: %w", err)
-			}
-			if wdHash != stagedMetadata.Hash {
-				unstagedChanges = append(unstagedChanges, fmt.Sprintf("%v (modified)", stagedFile))
-			}
+			unstagedChanges = append(unstagedChanges, fmt.Sprintf("%v (modified)", stagedFile))
 		}
 	}
+	if err := sc.save(); err != nil {
+		return fmt.Errorf("printStatus: %w", err)
+	}
 	slices.Sort(unstagedChanges)
 	for _, file := range unstagedChanges {
 		log.Println(file)
 	}
 
 	log.Println("\n=== Untracked Files ===")
-	var untracked []string
-	// files in wd that are not tracked or staged
-	cwd, err := os.Getwd()
+	untracked, err := getUntrackedFiles(headFiles, index)
+	if err != nil {
+		return fmt.Errorf("printStatus: %w", err)
+	}
+	for _, file := range untracked {
+		log.Println(file)
+	}
+
+	conflicts, err := exportConflicts()
 	if err != nil {
 		return fmt.Errorf("printStatus: %w", err)
 	}
-	wdFiles, err := getFilenames(cwd)
+	if len(conflicts) > 0 {
+		log.Println("\n=== Merge In Progress ===")
+		log.Println("You are merging; fix the conflicts and commit, or run 'merge --abort'.")
+		for _, conflict := range conflicts {
+			if conflict.Resolved {
+				log.Printf("%v (resolved)\n", conflict.Path)
+			} else {
+				log.Printf("%v (conflict)\n", conflict.Path)
+			}
+		}
+	}
+
+	submodules, err := getSubmoduleStatuses()
 	if err != nil {
 		return fmt.Errorf("printStatus: %w", err)
 	}
+	if len(submodules) > 0 {
+		log.Println("\n=== Submodules ===")
+		for _, submodule := range submodules {
+			log.Println(submodule)
+		}
+	}
+	return nil
+}
+
+// getUntrackedFiles returns the files in the working directory that are neither among the
+// given files of the head commit nor staged, sorted.
+func getUntrackedFiles(headFiles map[string]string, index indexMap) ([]string, error) {
+	var untracked []string
+	wdFiles, err := getWorkingFiles()
+	if err != nil {
+		return nil, fmt.Errorf("getUntrackedFiles: %w", err)
+	}
 	for _, file := range wdFiles {
 		_, isStaged := index[file]
-		_, isTracked := headCommit.FileToBlob[file]
+		_, isTracked := headFiles[file]
 		if !isStaged && !isTracked {
 			untracked = append(untracked, file)
 		}
 	}
 	slices.Sort(untracked)
-	for _, file := range untracked {
-		log.Println(file)
-	}
-	return nil
+	return untracked, nil
 }
 
 /*
@@ -570,24 +874,23 @@ This command will create the file if it does not exist and overwrites the existi
 The new version of the file is not staged.
 */
 func checkoutCommit(file string, targetCommitUID string) error {
+	file, err := normalizePath(file)
+	if err != nil {
+		return fmt.Errorf("checkoutCommit: %w", err)
+	}
 	targetCommit, err := getCommit(targetCommitUID)
 	if err != nil {
 		if errors.Is(err, fs.ErrNotExist) {
-			log.Fatal("No commit with that id exists.")
+			exit("No commit with that id exists.")
 		}
 		return fmt.Errorf("checkoutCommit: %w", err)
 	}
 	targetBlobHash, ok := targetCommit.FileToBlob[file]
 	if !ok {
-		log.Fatal("File does not exist in that commit.")
-	}
-	// read file contents from target commit
-	_, contents, err := readBlob(targetBlobHash)
-	if err != nil {
-		return fmt.Errorf("checkoutCommit: %w", err)
+		exit("File does not exist in that commit.")
 	}
-	// write file contents into working directory
-	if err := writeContents(file, [][]byte{contents}); err != nil {
+	// write file contents from target commit into working directory
+	if err := materializeBlob(targetBlobHash, file); err != nil {
 		return fmt.Errorf("checkoutCommit: %w", err)
 	}
 	return nil
@@ -605,19 +908,31 @@ Returns an error if the current branch is the target branch, the target branch d
 exist, or there is an untracked file that would be overwritten by the checkout.
 */
 func checkoutBranch(targetBranch string) error {
-	currentBranchFile, err := readContentsAsString(headFile)
+	unlock, err := lockRepo("checkout")
+	if err != nil {
+		return fmt.Errorf("checkoutBranch: %w", err)
+	}
+	defer unlock()
+	currentBranch, err := getCurrentBranch()
 	if err != nil {
 		return fmt.Errorf("checkoutBranch: %w", err)
 	}
-	currentBranch := filepath.Base(currentBranchFile)
 	if targetBranch == currentBranch {
-		log.Fatal("No need to checkout the current branch.")
+		exit("No need to checkout the current branch.")
 	}
 	targetBranchFile := filepath.Join(branchesDir, targetBranch)
-	targetBranchHeadCommitHash, err := readContentsAsString(targetBranchFile)
+	targetBranchHeadCommitHash, err := readRef(targetBranchFile)
 	if err != nil {
 		if errors.Is(err, fs.ErrNotExist) {
-			log.Fatal("No such branch exists.")
+			// any other revision is checked out without a branch
+			commitHash, revErr := resolveRevision(targetBranch)
+			if revErr != nil {
+				exit("No such branch exists.")
+			}
+			if err := checkoutDetached(commitHash); err != nil {
+				return fmt.Errorf("checkoutBranch: %w", err)
+			}
+			return nil
 		}
 		return fmt.Errorf("checkoutBranch: %w", err)
 	}
@@ -625,70 +940,157 @@ func checkoutBranch(targetBranch string) error {
 	if err != nil {
 		return fmt.Errorf("checkoutBranch: %w", err)
 	}
-
-	// check working directory for untracked files
-	currentBranchHeadCommit, err := getHeadCommit()
+	oldHeadCommitHash, err := getHeadCommitHash()
 	if err != nil {
 		return fmt.Errorf("checkoutBranch: %w", err)
 	}
-	cwd, err := os.Getwd()
-	if err != nil {
+
+	if _, err := checkUntrackedFiles(targetBranchHeadCommit); err != nil {
 		return fmt.Errorf("checkoutBranch: %w", err)
 	}
-	wdFiles, err := getFilenames(cwd)
-	if err != nil {
+	if err := beginJournal("checkout", targetBranchFile, "", targetBranchHeadCommitHash); err != nil {
 		return fmt.Errorf("checkoutBranch: %w", err)
 	}
-	for _, file := range wdFiles {
-		_, isTracked := currentBranchHeadCommit.FileToBlob[file]
-		_, wouldBeOverwritten := targetBranchHeadCommit.FileToBlob[file]
-		if !isTracked && wouldBeOverwritten {
-			log.Fatal("There is an untracked file in the way; delete it, or add and commit it first.")
-		}
+	if err := checkoutTree(targetBranchHeadCommit); err != nil {
+		return fmt.Errorf("checkoutBranch: %w", err)
 	}
 
-	// pull all files from target branch head commit into the working directory,
-	// creating or overwriting as needed
-	for file, targetBlobHash := range targetBranchHeadCommit.FileToBlob {
-		_, contents, err := readBlob(targetBlobHash)
-		if err != nil {
-			return fmt.Errorf("checkoutBranch: %w", err)
-		}
-		if err := writeContents(file, [][]byte{contents}); err != nil {
-			return fmt.Errorf("checkoutBranch: %w", err)
-		}
+	// set current branch to target branch
+	if err = writeRef(headFile, targetBranchFile, "checkout"); err != nil {
+		return fmt.Errorf("checkoutBranch: cannot set HEAD file: %w", err)
+	}
+	if err := logCheckout(oldHeadCommitHash, currentBranch, targetBranchHeadCommitHash, targetBranch); err != nil {
+		return fmt.Errorf("checkoutBranch: %w", err)
+	}
+	if err := endJournal(); err != nil {
+		return fmt.Errorf("checkoutBranch: %w", err)
 	}
 
-	// delete files in WD that are not target branch head commit
-	for _, file := range wdFiles {
-		_, ok := targetBranchHeadCommit.FileToBlob[file]
-		if !ok {
-			if err := restrictedDelete(file); err != nil {
-				return fmt.Errorf("checkoutBranch: %w", err)
-			}
-		}
+	log.Printf("Branch '%v' is now checked out.\n", targetBranch)
+	return nil
+}
+
+// checkoutDetached checks out a commit without a branch, pointing HEAD directly at the commit.
+// New commits move HEAD but no branch, until a branch is created or checked out.
+func checkoutDetached(commitHash string) error {
+	unlock, err := lockRepo("checkout")
+	if err != nil {
+		return fmt.Errorf("checkoutDetached: %w", err)
+	}
+	defer unlock()
+	targetCommit, err := getCommit(commitHash)
+	if err != nil {
+		return fmt.Errorf("checkoutDetached: %w", err)
+	}
+	oldHeadCommitHash, err := getHeadCommitHash()
+	if err != nil {
+		return fmt.Errorf("checkoutDetached: %w", err)
+	}
+	currentBranch, err := getCurrentBranch()
+	if err != nil {
+		return fmt.Errorf("checkoutDetached: %w", err)
 	}
+	if _, err := checkUntrackedFiles(targetCommit); err != nil {
+		return fmt.Errorf("checkoutDetached: %w", err)
+	}
+	if err := beginJournal("checkout", commitHash, "", commitHash); err != nil {
+		return fmt.Errorf("checkoutDetached: %w", err)
+	}
+	if err := checkoutTree(targetCommit); err != nil {
+		return fmt.Errorf("checkoutDetached: %w", err)
+	}
+	if err := writeRef(headFile, commitHash, "checkout"); err != nil {
+		return fmt.Errorf("checkoutDetached: cannot set HEAD file: %w", err)
+	}
+	if err := logCheckout(oldHeadCommitHash, currentBranch, commitHash, ""); err != nil {
+		return fmt.Errorf("checkoutDetached: %w", err)
+	}
+	if err := endJournal(); err != nil {
+		return fmt.Errorf("checkoutDetached: %w", err)
+	}
+	log.Printf("HEAD is now detached at commit (%v).\n", commitHash[:6])
+	return nil
+}
 
-	// set current branch to target branch
-	if err = writeContents(headFile, []string{targetBranchFile}); err != nil {
-		return fmt.Errorf("checkoutBranch: cannot set HEAD file: %w", err)
+// checkoutTree replaces the files tracked by the head commit with those of the target commit
+// and discards the staging area. HEAD is not changed.
+// Returns an error if an untracked file would be overwritten by the checkout.
+func checkoutTree(targetCommit commit) error {
+	// check working directory for untracked files
+	wdFiles, err := checkUntrackedFiles(targetCommit)
+	if err != nil {
+		return fmt.Errorf("checkoutTree: %w", err)
+	}
+	headCommit, err := getHeadCommit()
+	if err != nil {
+		return fmt.Errorf("checkoutTree: %w", err)
 	}
 
-	// clear staging area
-	if err := newIndex(); err != nil {
-		return fmt.Errorf("checkoutBranch: %w", err)
+	// delete files in WD and tracked files that are not in target commit, first so that a
+	// file can replace a directory and a directory a file
+	removed := getRemovedFiles(wdFiles, headCommit, targetCommit)
+	if err := removeFiles(removed); err != nil {
+		return fmt.Errorf("checkoutTree: %w", err)
 	}
 
-	log.Printf("Branch '%v' is now checked out.\n", targetBranch)
+	// pull all files from target commit into the working directory,
+	// creating or overwriting as needed
+	if err := materializeFiles(targetCommit.FileToBlob); err != nil {
+		return fmt.Errorf("checkoutTree: %w", err)
+	}
+
+	// clone or update the submodules pinned by the target commit
+	if err := updateSubmodules(targetCommit.Submodules); err != nil {
+		return fmt.Errorf("checkoutTree: %w", err)
+	}
+
+	// discard staging area and abandon the conflicts of the last merge
+	if err := discardIndex(headCommit, targetCommit); err != nil {
+		return fmt.Errorf("checkoutTree: %w", err)
+	}
+	if err := clearMergeState(); err != nil {
+		return fmt.Errorf("checkoutTree: %w", err)
+	}
 	return nil
 }
 
+// checkUntrackedFiles exits if checking out the target commit would overwrite a file in
+// the working directory that the head commit does not track. Returns the files in the
+// working directory.
+func checkUntrackedFiles(targetCommit commit) ([]string, error) {
+	headCommit, err := getHeadCommit()
+	if err != nil {
+		return nil, fmt.Errorf("checkUntrackedFiles: %w", err)
+	}
+	wdFiles, err := walkWorkingFiles(".")
+	if err != nil {
+		return nil, fmt.Errorf("checkUntrackedFiles: %w", err)
+	}
+	for _, file := range wdFiles {
+		_, isTracked := headCommit.FileToBlob[file]
+		_, wouldBeOverwritten := targetCommit.FileToBlob[file]
+		if !isTracked && wouldBeOverwritten {
+			exit("There is an untracked file in the way; delete it, or add and commit it first.")
+		}
+	}
+	return wdFiles, nil
+}
+
 // addBranch creates a new branch pointing to the head commit of the current branch.
 // This function does not checkout the new branch.
 func addBranch(branchName string) error {
+	// names of lock files and temporary files are reserved
+	if strings.HasPrefix(branchName, ".") || strings.HasSuffix(branchName, lockFileSuffix) {
+		exit("Invalid branch name.")
+	}
 	branchFile := filepath.Join(branchesDir, branchName)
-	if _, err := os.Stat(branchFile); err == nil {
-		log.Fatal("A branch with that name already exists.")
+	unlock, err := lockFile(branchFile, "branch")
+	if err != nil {
+		return fmt.Errorf("addBranch: %w", err)
+	}
+	defer unlock()
+	if _, err := readRef(branchFile); err == nil {
+		exit("A branch with that name already exists.")
 	} else if !errors.Is(err, fs.ErrNotExist) {
 		return fmt.Errorf("addBranch: %w", err)
 	}
@@ -696,167 +1098,275 @@ func addBranch(branchName string) error {
 	if err != nil {
 		return fmt.Errorf("addBranch: %w", err)
 	}
-	if err := writeContents(branchFile, []string{headCommitHash}); err != nil {
+	if err := writeRef(branchFile, headCommitHash, "branch"); err != nil {
+		return fmt.Errorf("addBranch: %w", err)
+	}
+	if err := logRefUpdate(branchName, "", headCommitHash, "branch: Created from HEAD"); err != nil {
 		return fmt.Errorf("addBranch: %w", err)
 	}
 	log.Printf("Branch '%v' was created on commit (%v).\n", branchName, string(headCommitHash[:6]))
 	return nil
 }
 
+// getMergedBranches returns the branches, sorted, whose heads are ancestors of a commit
+// if merged, or are not if not merged.
+func getMergedBranches(commitHash string, merged bool) ([]string, error) {
+	ancestors, err := getAncestors(commitHash)
+	if err != nil {
+		return nil, fmt.Errorf("getMergedBranches: %w", err)
+	}
+	branches, err := getRefNames(branchesDir)
+	if err != nil {
+		return nil, fmt.Errorf("getMergedBranches: %w", err)
+	}
+	slices.Sort(branches)
+	var matches []string
+	for _, branch := range branches {
+		branchHeadCommitHash, err := readRef(filepath.Join(branchesDir, branch))
+		if err != nil {
+			return nil, fmt.Errorf("getMergedBranches: %w", err)
+		}
+		if ancestors[branchHeadCommitHash] == merged {
+			matches = append(matches, branch)
+		}
+	}
+	return matches, nil
+}
+
+// printMergedBranches prints the branches whose heads are ancestors of the commit named by
+// a revision if merged, or are not if not merged, marking the current branch with "*".
+func printMergedBranches(rev string, merged bool) error {
+	commitHash, err := resolveRevision(rev)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			exitf("No commit or branch named '%v' exists.", rev)
+		}
+		return fmt.Errorf("printMergedBranches: %w", err)
+	}
+	currentBranch, err := getCurrentBranch()
+	if err != nil {
+		return fmt.Errorf("printMergedBranches: %w", err)
+	}
+	branches, err := getMergedBranches(commitHash, merged)
+	if err != nil {
+		return fmt.Errorf("printMergedBranches: %w", err)
+	}
+	for _, branch := range branches {
+		if branch == currentBranch {
+			log.Printf("*%v\n", branch)
+		} else {
+			log.Println(branch)
+		}
+	}
+	return nil
+}
+
 // rm-branch
 func removeBranch(branchName string) error {
-	headBranchFile, err := readContentsAsString(headFile)
+	currentBranch, err := getCurrentBranch()
 	if err != nil {
 		return fmt.Errorf("removeBranch: %w", err)
 	}
-	if filepath.Base(headBranchFile) == branchName {
-		log.Fatal("Cannot remove the current branch.")
+	if currentBranch == branchName {
+		exit("Cannot remove the current branch.")
 	}
 
-	if err := restrictedDelete(filepath.Join(branchesDir, branchName)); err != nil {
+	branchFile := filepath.Join(branchesDir, branchName)
+	unlock, err := lockFile(branchFile, "rm-branch")
+	if err != nil {
+		return fmt.Errorf("removeBranch: %w", err)
+	}
+	defer unlock()
+	branchHeadCommitHash, err := readRef(branchFile)
+	if err != nil {
 		if errors.Is(err, fs.ErrNotExist) {
-			log.Fatal("A branch with that name does not exist.")
+			exit("A branch with that name does not exist.")
 		}
 		return fmt.Errorf("removeBranch: %w", err)
 	}
-	log.Printf("Branch '%v' has been deleted.\n", branchName)
+	// the reflog of the branch is kept so the branch can be recovered
+	if err := removeRef(branchFile); err != nil {
+		return fmt.Errorf("removeBranch: %w", err)
+	}
+	log.Printf("Branch '%v' has been deleted (was %v).\n", branchName, branchHeadCommitHash[:6])
 	return nil
 }
 
-// resetFile checks out all files tracked by the given commit
+// resetFile checks out all files tracked by the commit named by a revision
 // and removes tracked files not present in that commit.
-func resetFile(targetCommitUID string) error {
-	targetCommit, err := getCommit(targetCommitUID)
+func resetFile(rev string) error {
+	unlock, err := lockRepo("reset")
+	if err != nil {
+		return fmt.Errorf("resetFile: %w", err)
+	}
+	defer unlock()
+	// branches and HEAD must hold full hashes
+	targetCommitUID, err := resolveRevision(rev)
 	if err != nil {
 		if errors.Is(err, fs.ErrNotExist) {
-			log.Fatal("No commit with that id exists.")
+			exit("No commit with that id exists.")
 		}
 		return fmt.Errorf("resetFile: %w", err)
 	}
+	targetCommit, err := getCommit(targetCommitUID)
+	if err != nil {
+		return fmt.Errorf("resetFile: %w", err)
+	}
 	headCommit, err := getHeadCommit()
 	if err != nil {
 		return fmt.Errorf("resetFile: %w", err)
 	}
 	// check working directory for untracked files that would be overwritten
-	cwd, err := os.Getwd()
+	wdFiles, err := checkUntrackedFiles(targetCommit)
 	if err != nil {
 		return fmt.Errorf("resetFile: %w", err)
 	}
-	wdFiles, err := getFilenames(cwd)
-	if err != nil {
+	if err := beginHeadJournal("reset", targetCommitUID); err != nil {
 		return fmt.Errorf("resetFile: %w", err)
 	}
-	for _, file := range wdFiles {
-		_, isTracked := headCommit.FileToBlob[file]
-		_, wouldBeOverwritten := targetCommit.FileToBlob[file]
-		if !isTracked && wouldBeOverwritten {
-			log.Fatal("There is an untracked file in the way; delete it, or add and commit it first.")
-		}
+
+	// delete files in WD and tracked files that are not in target commit, before writing
+	// those that are
+	removed := getRemovedFiles(wdFiles, headCommit, targetCommit)
+	if err := removeFiles(removed); err != nil {
+		return fmt.Errorf("resetFile: %w", err)
 	}
 
 	// checkout every file from the target commit
-	for file, targetBlobHash := range targetCommit.FileToBlob {
-		_, contents, err := readBlob(targetBlobHash)
-		if err != nil {
-			return fmt.Errorf("resetFile: %w", err)
-		}
-		if err := writeContents(file, contents); err != nil {
-			return fmt.Errorf("resetFile: %w", err)
-		}
+	if err := materializeFiles(targetCommit.FileToBlob); err != nil {
+		return fmt.Errorf("resetFile: %w", err)
 	}
 
-	// delete files in WD that are not target commit
-	for _, file := range wdFiles {
-		_, ok := targetCommit.FileToBlob[file]
-		if !ok {
-			if err := restrictedDelete(file); err != nil {
-				return fmt.Errorf("resetFile: %w", err)
-			}
-		}
+	// clone or update the submodules pinned by the target commit
+	if err := updateSubmodules(targetCommit.Submodules); err != nil {
+		return fmt.Errorf("resetFile: %w", err)
 	}
 
 	// set current branch head commit to target commit
-	currentBranchFile, err := readContentsAsString(headFile)
-	if err != nil {
-		return fmt.Errorf("resetFile: %w", err)
-	}
-	if err = writeContents(currentBranchFile, []string{targetCommitUID}); err != nil {
+	if err = setHeadCommit(targetCommitUID, "reset: moving to "+rev); err != nil {
 		return fmt.Errorf("resetFile: cannot set HEAD commit: %w", err)
 	}
 
-	// clear staging area
-	if err := newIndex(); err != nil {
+	// discard staging area and abandon the conflicts of the last merge
+	if err := discardIndex(headCommit, targetCommit); err != nil {
+		return fmt.Errorf("resetFile: %w", err)
+	}
+	if err := clearMergeState(); err != nil {
+		return fmt.Errorf("resetFile: %w", err)
+	}
+	if err := endJournal(); err != nil {
 		return fmt.Errorf("resetFile: %w", err)
 	}
 	return nil
 }
 
+// Strategies for resolving files changed differently on both sides of a merge.
+const (
+	mergeConflictMarkers string = ""       // Write both versions between conflict markers.
+	mergeOurs            string = "ours"   // Keep the current branch's version.
+	mergeTheirs          string = "theirs" // Take the merged branch's version.
+)
+
+// mergeOptions configures how mergeBranch merges a branch.
+type mergeOptions struct {
+	Strategy      string // How files changed differently on both sides are resolved.
+	NoFastForward bool   // Create a merge commit even if the current branch could be fast-forwarded.
+	Squash        bool   // Only stage the merged changes, to be committed as a regular commit.
+}
+
 // mergeBranch merges files from the given branch into the current branch.
-func mergeBranch(branchName string) error {
-	// check for uncommitted changes in staging area
-	idx, err := readIndex()
+func mergeBranch(branchName string, opts mergeOptions) error {
+	unlock, err := lockRepo("merge")
 	if err != nil {
 		return fmt.Errorf("mergeBranch: %w", err)
 	}
-	if len(idx) != 0 {
-		log.Fatal("You have uncommitted changes.")
-	}
+	defer unlock()
 
 	// check target branch exists
 	targetBranchFile := filepath.Join(branchesDir, branchName)
-	targetBranchHeadCommitHash, err := readContentsAsString(targetBranchFile)
+	targetBranchHeadCommitHash, err := readRef(targetBranchFile)
 	if err != nil {
 		if errors.Is(err, fs.ErrNotExist) {
-			log.Fatal("A branch with that name does not exist.")
+			exit("A branch with that name does not exist.")
 		}
 		return fmt.Errorf("mergeBranch: %w", err)
 	}
 
 	// check current branch is not target branch
-	currentBranchFile, err := readContentsAsString(headFile)
+	currentBranch, err := getCurrentBranch()
 	if err != nil {
 		return fmt.Errorf("mergeBranch: %w", err)
 	}
-	currentBranch := filepath.Base(currentBranchFile)
+	if currentBranch == "" {
+		currentBranch = "HEAD"
+	}
 	if branchName == currentBranch {
-		log.Fatal("Cannot merge a branch with itself.")
+		exit("Cannot merge a branch with itself.")
+	}
+
+	if err := mergeCommit(branchName, targetBranchHeadCommitHash, opts); err != nil {
+		return fmt.Errorf("mergeBranch: %w", err)
+	}
+	return nil
+}
+
+// mergeCommit merges a commit, named branchName in messages, into the current branch.
+// The current branch is fast-forwarded if it is an ancestor of the commit, unless the options
+// ask for a merge commit. Otherwise a merge commit is created, with conflicting files resolved
+// by the options' strategy. A squash merge only stages the merged files.
+func mergeCommit(branchName string, targetBranchHeadCommitHash string, opts mergeOptions) error {
+	unlock, err := lockRepo("merge")
+	if err != nil {
+		return fmt.Errorf("mergeCommit: %w", err)
+	}
+	defer unlock()
+	// check for uncommitted changes in staging area
+	idx, err := readIndex()
+	if err != nil {
+		return fmt.Errorf("mergeCommit: %w", err)
+	}
+	if len(idx) != 0 {
+		exit("You have uncommitted changes.")
+	}
+	currentBranch, err := getCurrentBranch()
+	if err != nil {
+		return fmt.Errorf("mergeCommit: %w", err)
+	}
+	if currentBranch == "" {
+		currentBranch = "HEAD"
 	}
 
 	targetBranchHeadCommit, err := getCommit(targetBranchHeadCommitHash)
 	if err != nil {
-		return fmt.Errorf("mergeBranch: %w", err)
+		return fmt.Errorf("mergeCommit: %w", err)
 	}
 	currentBranchHeadCommit, err := getHeadCommit()
 	if err != nil {
-		return fmt.Errorf("mergeBranch: %w", err)
+		return fmt.Errorf("mergeCommit: %w", err)
 	}
 
 	// check working directory for untracked files
-	cwd, err := os.Getwd()
-	if err != nil {
-		return fmt.Errorf("mergeBranch: %w", err)
-	}
-	wdFiles, err := getFilenames(cwd)
+	wdFiles, err := walkWorkingFiles(".")
 	if err != nil {
-		return fmt.Errorf("mergeBranch: %w", err)
+		return fmt.Errorf("mergeCommit: %w", err)
 	}
 	// TODO: check tracked but modified WD files with not yet staged changes
 	for _, file := range wdFiles {
 		_, isTracked := currentBranchHeadCommit.FileToBlob[file]
 		_, wouldBeOverwritten := targetBranchHeadCommit.FileToBlob[file]
 		if !isTracked && wouldBeOverwritten {
-			log.Fatal("There is an untracked file in the way; delete it, or add and commit it first.")
+			exit("There is an untracked file in the way; delete it, or add and commit it first.")
 		}
 	}
 	currentBranchHeadCommitHash, err := getHeadCommitHash()
 	if err != nil {
-		return fmt.Errorf("mergeBranch: %w", err)
+		return fmt.Errorf("mergeCommit: %w", err)
 	}
 
 	// find split point (latest common ancestor)
 	splitPointCommitHash, err := findSplitPoint(currentBranchHeadCommitHash, targetBranchHeadCommitHash)
 	if err != nil {
-		return fmt.Errorf("mergeBranch: %w", err)
+		return fmt.Errorf("mergeCommit: %w", err)
 	}
 
 	// check if split point same commit as given branch
@@ -867,9 +1377,9 @@ func mergeBranch(branchName string) error {
 	}
 	// check if split point is the current branch
 	// checkout the target branch
-	if splitPointCommitHash == currentBranchHeadCommitHash {
-		if err := checkoutBranch(branchName); err != nil {
-			return fmt.Errorf("mergeBranch: %w", err)
+	if splitPointCommitHash == currentBranchHeadCommitHash && !opts.NoFastForward && !opts.Squash {
+		if err := moveHead(targetBranchHeadCommitHash, fmt.Sprintf("merge %v: Fast-forward", branchName)); err != nil {
+			return fmt.Errorf("mergeCommit: %w", err)
 		}
 		log.Println("Current branch fast-forwarded.")
 		return nil
@@ -877,23 +1387,83 @@ func mergeBranch(branchName string) error {
 
 	splitPointCommit, err := getCommit(splitPointCommitHash)
 	if err != nil {
-		return fmt.Errorf("mergeBranch: %w", err)
+		return fmt.Errorf("mergeCommit: %w", err)
+	}
+
+	if err := beginMergeJournal(targetBranchHeadCommitHash); err != nil {
+		return fmt.Errorf("mergeCommit: %w", err)
+	}
+	conflicts, err := mergeCommitFiles(splitPointCommit, currentBranchHeadCommit, targetBranchHeadCommit, opts.Strategy)
+	if err != nil {
+		return fmt.Errorf("mergeCommit: %w", err)
+	}
+
+	if opts.Squash {
+		if len(conflicts) > 0 {
+			if err := writeMergeState(mergeState{
+				splitPointCommitHash, currentBranchHeadCommitHash, targetBranchHeadCommitHash, conflicts,
+			}); err != nil {
+				return fmt.Errorf("mergeCommit: %w", err)
+			}
+		}
+		if err := endJournal(); err != nil {
+			return fmt.Errorf("mergeCommit: %w", err)
+		}
+		if len(conflicts) > 0 {
+			log.Print("Encountered a merge conflict.")
+		}
+		log.Printf("Squashed changes from %v are staged; commit them to finish the merge.\n", branchName)
+		return nil
+	}
+
+	if err := newMergeCommit(
+		branchName, targetBranchHeadCommitHash,
+		currentBranch, currentBranchHeadCommitHash,
+	); err != nil {
+		return fmt.Errorf("mergeCommit: %w", err)
+	}
+	if len(conflicts) > 0 {
+		// record the conflicts after the merge commit, which clears any earlier ones
+		if err := writeMergeState(mergeState{
+			splitPointCommitHash, currentBranchHeadCommitHash, targetBranchHeadCommitHash, conflicts,
+		}); err != nil {
+			return fmt.Errorf("mergeCommit: %w", err)
+		}
 	}
+	if err := endJournal(); err != nil {
+		return fmt.Errorf("mergeCommit: %w", err)
+	}
+	if len(conflicts) > 0 {
+		log.Print("Encountered a merge conflict.")
+	} else {
+		log.Printf("Merged %v into %v.\n", branchName, currentBranch)
+	}
+	return nil
+}
 
+// mergeCommitFiles merges the changes the target commit made since the split point into the
+// working directory and index, given the current commit also changed files since the split
+// point. Files changed on only one side take that side's version. Files changed differently
+// on both sides are resolved by the strategy: by default they are written with conflict
+// markers, staged, and marked conflicted. Returns the conflicts sorted by file.
+func mergeCommitFiles(
+	splitPointCommit commit, currentCommit commit, targetCommit commit, strategy string,
+) ([]mergeConflict, error) {
 	// all files: splitPoint, current, target, WD??
 	allFiles := make(map[string]bool)
 	for file := range splitPointCommit.FileToBlob {
 		allFiles[file] = true
 	}
-	for file := range currentBranchHeadCommit.FileToBlob {
+	for file := range currentCommit.FileToBlob {
 		allFiles[file] = true
 	}
-	for file := range targetBranchHeadCommit.FileToBlob {
+	for file := range targetCommit.FileToBlob {
 		allFiles[file] = true
 	}
-	for file := range allFiles {
-		targetHeadFileBlob, inTargetBranchHeadCommit := targetBranchHeadCommit.FileToBlob[file]
-		currentHeadFileBlob, inCurrentBranchHeadCommit := currentBranchHeadCommit.FileToBlob[file]
+	var conflicts []mergeConflict
+	for _, file := range sortedKeys(allFiles) {
+		targetHeadFileBlob, inTargetBranchHeadCommit := targetCommit.FileToBlob[file]
+		currentHeadFileBlob, inCurrentBranchHeadCommit := currentCommit.FileToBlob[file]
 		splitPointFileBlob, inSplitPointCommit := splitPointCommit.FileToBlob[file]
 
 		// modified: file has been removed, changed, added since split point
@@ -909,12 +1479,19 @@ func mergeBranch(branchName string) error {
 
 		// 1) modified in target branch, unmodified in current branch
 		if modifiedInTargetBranch && !modifiedInCurrentBranch {
+			// removed in target branch, handled by 6)
+			if removedInTargetBranch {
+				if err := unstageFile(file); err != nil {
+					return nil, fmt.Errorf("mergeCommitFiles: %w", err)
+				}
+				continue
+			}
 			// checkout target branch version and stage
-			if err := checkoutCommit(file, targetBranchHeadCommitHash); err != nil {
-				return err
+			if err := materializeBlob(targetHeadFileBlob, file); err != nil {
+				return nil, fmt.Errorf("mergeCommitFiles: %w", err)
 			}
 			if err := stageFile(file); err != nil {
-				return err
+				return nil, fmt.Errorf("mergeCommitFiles: %w", err)
 			}
 			continue
 
@@ -929,23 +1506,10 @@ func mergeBranch(branchName string) error {
 				// the removed file can exist in WD, untracked and unstaged
 				continue
 			}
-			if !removedInCurrentBranch && !removedInTargetBranch {
-				// same hash
-				if currentHeadFileBlob == targetHeadFileBlob {
-					continue
-				}
-				// same contents
-				_, currentBranchFileContents, err := readBlob(currentHeadFileBlob)
-				if err != nil {
-					return fmt.Errorf("mergeBranch: cannot read current file blob: %w", err)
-				}
-				_, targetBranchFileContents, err := readBlob(targetHeadFileBlob)
-				if err != nil {
-					return fmt.Errorf("mergeBranch: cannot read target file blob: %w", err)
-				}
-				if slices.Compare(currentBranchFileContents, targetBranchFileContents) == 0 {
-					continue
-				}
+			// both changed the same way; blobs are content-addressed, so equal hashes
+			// mean equal contents and differing hashes mean differing contents
+			if !removedInCurrentBranch && !removedInTargetBranch && currentHeadFileBlob == targetHeadFileBlob {
+				continue
 			}
 		}
 
@@ -957,11 +1521,11 @@ func mergeBranch(branchName string) error {
 		// 5) not in split point, in target branch, not in current branch
 		if !inSplitPointCommit && inTargetBranchHeadCommit && !inCurrentBranchHeadCommit {
 			// checkout from target branch and stage
-			if err := checkoutCommit(file, targetBranchHeadCommitHash); err != nil {
-				return err
+			if err := materializeBlob(targetHeadFileBlob, file); err != nil {
+				return nil, fmt.Errorf("mergeCommitFiles: %w", err)
 			}
 			if err := stageFile(file); err != nil {
-				return err
+				return nil, fmt.Errorf("mergeCommitFiles: %w", err)
 			}
 			continue
 		}
@@ -970,7 +1534,7 @@ func mergeBranch(branchName string) error {
 		if inSplitPointCommit && !modifiedInCurrentBranch && !inTargetBranchHeadCommit {
 			// remove and untrack
 			if err := unstageFile(file); err != nil {
-				return fmt.Errorf("mergeBranch: %w", err)
+				return nil, fmt.Errorf("mergeCommitFiles: %w", err)
 			}
 			continue
 		}
@@ -981,57 +1545,57 @@ func mergeBranch(branchName string) error {
 		}
 
 		// 8) files are in conflict, both modified
+		if modifiedInCurrentBranch && modifiedInTargetBranch && strategy == mergeOurs {
+			// keep current branch version
+			continue
+		}
+		if modifiedInCurrentBranch && modifiedInTargetBranch && strategy == mergeTheirs {
+			if removedInTargetBranch {
+				if err := unstageFile(file); err != nil {
+					return nil, fmt.Errorf("mergeCommitFiles: %w", err)
+				}
+				continue
+			}
+			if err := materializeBlob(targetHeadFileBlob, file); err != nil {
+				return nil, fmt.Errorf("mergeCommitFiles: %w", err)
+			}
+			if err := stageFile(file); err != nil {
+				return nil, fmt.Errorf("mergeCommitFiles: %w", err)
+			}
+			continue
+		}
 		if modifiedInCurrentBranch && modifiedInTargetBranch {
-			var currentBranchFileContents, targetBranchFileContents []byte
 			// contents are changed and different
 			// contents of one are changed and other is deleted
 			// file absent at split point and has different contents in target and current branches
-			if !removedInCurrentBranch {
-				_, currentBranchFileContents, err = readBlob(currentHeadFileBlob)
-				if err != nil {
-					return err
-				}
-			}
-			if !removedInTargetBranch {
-				_, targetBranchFileContents, err = readBlob(targetHeadFileBlob)
-				if err != nil {
-					return err
-				}
-			}
-			if err := writeContents(file,
-				[]any{
-					"<<<<<<< HEAD\n",
-					currentBranchFileContents,
-					"=======",
-					targetBranchFileContents,
-					">>>>>>>",
-				},
-			); err != nil {
-				return err
+			// blob contents are only read here, once the hashes have shown a conflict
+			if err := writeConflictFile(file, currentHeadFileBlob, targetHeadFileBlob); err != nil {
+				return nil, fmt.Errorf("mergeCommitFiles: %w", err)
 			}
 			if err := stageFile(file); err != nil {
-				return err
+				return nil, fmt.Errorf("mergeCommitFiles: %w", err)
+			}
+			if err := markConflicted(file); err != nil {
+				return nil, fmt.Errorf("mergeCommitFiles: %w", err)
 			}
+			conflicts = append(conflicts, mergeConflict{file, splitPointFileBlob, currentHeadFileBlob, targetHeadFileBlob})
 			continue
 		}
 	}
-
-	if err := newMergeCommit(
-		branchName, targetBranchHeadCommitHash,
-		currentBranch, currentBranchHeadCommitHash,
-	); err != nil {
-		return fmt.Errorf("mergeBranch: %w", err)
-	}
-	log.Print("Encountered a merge conflict.")
-	return nil
+	return conflicts, nil
 }
 
 // findSplitPoint finds the latest common ancestor given two commit UIDs.
 //
 // Uses BFS with map to record visited ancestors, breaking upon finding the earliest common one.
+// Parents are looked up in the commit graph, so commits in it are not read.
 // Time: O(H), where H is the height of the DAG
 // Space: O(H), recording every parent node upon visiting
 func findSplitPoint(commitUID1 string, commitUID2 string) (string, error) {
+	graph, err := readCommitGraph()
+	if err != nil {
+		return "", fmt.Errorf("findSplitPoint: %w", err)
+	}
 	visited := make(map[string]bool)
 	queue := []string{commitUID1, commitUID2}
 	for len(queue) > 0 {
@@ -1041,15 +1605,11 @@ func findSplitPoint(commitUID1 string, commitUID2 string) (string, error) {
 		}
 		visited[commitUID] = true
 		queue = queue[1:]
-		c, err := getCommit(commitUID)
+		entry, err := graph.lookup(commitUID)
 		if err != nil {
 			return "", fmt.Errorf("findSplitPoint: %w", err)
 		}
-		for _, parentUID := range c.ParentUIDs {
-			if parentUID != "" {
-				queue = append(queue, parentUID)
-			}
-		}
+		queue = append(queue, entry.Parents...)
 	}
 	return "", errors.New("findSplitPoint: no valid commit")
 }
@@ -1060,11 +1620,21 @@ func newMergeCommit(
 	currentBranch string,
 	currentBranchHeadCommitHash string,
 ) error {
+	author, err := getIdentity(authorRole)
+	if err != nil {
+		return fmt.Errorf("newMergeCommit: %w", err)
+	}
+	committer, err := getIdentity(committerRole)
+	if err != nil {
+		return fmt.Errorf("newMergeCommit: %w", err)
+	}
 	c := commit{
 		Message:    fmt.Sprintf("Merged %v into %v.", targetBranch, currentBranch),
 		Timestamp:  time.Now().Unix(),
 		FileToBlob: make(map[string]string),
 		ParentUIDs: [2]string{currentBranchHeadCommitHash, targetBranchHeadCommitHash},
+		Author:     author,
+		Committer:  committer,
 	}
 
 	headCommit, err := getHeadCommit()
@@ -1075,39 +1645,30 @@ func newMergeCommit(
 	for file, blobUID := range headCommit.FileToBlob {
 		c.FileToBlob[file] = blobUID
 	}
+	for path, commitHash := range headCommit.Submodules {
+		pinSubmodule(&c, path, commitHash)
+	}
 	// overwrite mapping with staged files
 	index, err := readIndex()
 	if err != nil {
 		return err
 	}
 	for file, metadata := range index {
-		if metadata.Hash == stagedForRemovalMarker {
+		switch metadata.Op {
+		case indexRemove:
 			// remove file from commit if it is staged for deletion
 			delete(c.FileToBlob, file)
-		} else {
+		case indexSubmodule:
+			pinSubmodule(&c, file, metadata.Hash)
+		default:
 			c.FileToBlob[file] = metadata.Hash
 		}
 	}
 
-	// write commit blob
-	commitHash, err := writeCommit(c)
-	if err != nil {
+	// write commit blob, moving the current branch and clearing the index
+	if _, err := writeCommit(c, "commit"); err != nil {
 		return err
 	}
-
-	// set current branch head commit to new commit
-	currentBranchFile, err := readContentsAsString(headFile)
-	if err != nil {
-		return err
-	}
-	if err := writeContents(currentBranchFile, []string{commitHash}); err != nil {
-		return fmt.Errorf("newCommit: cannot update current branch file: %w", err)
-	}
-
-	// clear index
-	if err := newIndex(); err != nil {
-		return fmt.Errorf("newCommit: cannot clear index: %w", err)
-	}
 	return nil
 }
 
@@ -1116,305 +1677,166 @@ func newMergeCommit(
 // Example:
 //
 //	$ gitlet add-remote other ../testing/otherdir/.gitlet
+//	$ gitlet add-remote other gitlet://example.com/otherdir
 func addRemote(remoteName string, remoteGitletDir string) error {
+	if remoteName == "" || strings.ContainsAny(remoteName, "/\\ \t\n") {
+		exit("Invalid remote name.")
+	}
 	remotes, err := readRemoteIndex()
 	if err != nil {
 		return fmt.Errorf("addRemote: %w", err)
 	}
 	if _, ok := remotes[remoteName]; ok {
-		log.Fatal("A remote with that name already exists.")
+		exit("A remote with that name already exists.")
+	}
+	url := remoteGitletDir
+	if !isServerURL(url) {
+		url = filepath.FromSlash(url)
 	}
-	remotes[remoteName] = remoteMetadata{URL: filepath.FromSlash(remoteGitletDir)}
+	remotes[remoteName] = remoteMetadata{Name: remoteName, URL: url}
 	if err = writeRemoteIndex(remotes); err != nil {
 		return fmt.Errorf("addRemote: could not update file index: %w", err)
 	}
-
-	remoteDir := filepath.Join(remotesDir, remoteName)
-	if err := os.Mkdir(remoteDir, 0755); err != nil {
-		return fmt.Errorf("addRemote: %w", err)
-	}
-
-	// copy remote branches
-	remoteBranchDir := filepath.Join(remoteGitletDir, "refs", "heads")
-	if err := filepath.WalkDir(
-		remoteBranchDir,
-		func(path string, d fs.DirEntry, err error) error {
-			if d.IsDir() {
-				return nil
-			}
-			if err != nil {
-				return err
-			}
-			contents, err := readContents(path)
-			if err != nil {
-				return err
-			}
-			if err := writeContents(filepath.Join(remoteGitletDir, filepath.Base(path)), contents); err != nil {
-				return err
-			}
-			return err
-		},
-	); err != nil {
+	if err := repoFS.MkdirAll(filepath.Join(remotesDir, remoteName), 0755); err != nil {
 		return fmt.Errorf("addRemote: %w", err)
 	}
-
-	// copy HEAD
-	remoteHead, err := readContentsAsString(filepath.Join(remoteGitletDir, "HEAD"))
-	if err != nil {
-		return fmt.Errorf("addRemote: %w", err)
-	}
-	filepath.Join(remoteDir, filepath.Base(remoteHead))
 	return nil
 }
 
-// removeRemote removes a remote Gitlet repository and its information.
+// removeRemote removes a remote Gitlet repository from the remote index, along with its
+// remote-tracking branches.
 func removeRemote(remoteName string) error {
+	unlock, err := lockRepo("rm-remote")
+	if err != nil {
+		return fmt.Errorf("removeRemote: %w", err)
+	}
+	defer unlock()
 	remotes, err := readRemoteIndex()
 	if err != nil {
 		return fmt.Errorf("removeRemote: %w", err)
 	}
 	_, ok := remotes[remoteName]
 	if !ok {
-		log.Fatal("A remote with that name does not exist.")
+		exit("A remote with that name does not exist.")
 	}
 	delete(remotes, remoteName)
+	if err := writeRemoteIndex(remotes); err != nil {
+		return fmt.Errorf("removeRemote: could not update file index: %w", err)
+	}
 	remoteDir := filepath.Join(remotesDir, remoteName)
-	if err := os.RemoveAll(remoteDir); err != nil {
+	branches, err := listRefs(remoteDir)
+	if err != nil {
+		return fmt.Errorf("removeRemote: %w", err)
+	}
+	for _, file := range branches {
+		if err := removeRef(file); err != nil {
+			return fmt.Errorf("removeRemote: %w", err)
+		}
+	}
+	if err := repoFS.RemoveAll(remoteDir); err != nil {
 		return fmt.Errorf("removeRemote: %w", err)
 	}
 	return nil
 }
 
-// push appends the current branch's commits to the end of the given branch at the given remote.
+// push copies the commits of the current branch, and the blobs they track, that the remote
+// does not have yet into the remote repository, and fast-forwards the given branch of the
+// remote to the head commit. The branch is created if the remote does not have it.
+// Fails if the remote branch has commits that are not in the history of the head commit.
 //
 // Example:
 //
 //	$ gitlet push origin main
 func push(remoteName string, remoteBranchName string) error {
-	// get remote directory path
-	remoteIndex, err := readRemoteIndex()
+	unlock, err := lockRepo("push")
 	if err != nil {
 		return fmt.Errorf("push: %w", err)
 	}
-	remoteMetadata, ok := remoteIndex[remoteName]
-	if !ok {
-		log.Fatal("A remote with that name does not exist.")
-	}
-	if dirInfo, err := os.Stat(remoteMetadata.URL); errors.Is(err, fs.ErrNotExist) || (err == nil && !dirInfo.IsDir()) {
-		log.Fatal("Remote directory not found.")
-	} else if err != nil {
-		return fmt.Errorf("push: %w", err)
-	}
-
-	// get remote branch head commit: ../remoteRepo/.gitlet/heads/{branch}
-	remoteBranchFile := filepath.Join(remoteMetadata.URL, "heads", remoteBranchName)
-	remoteHeadCommitHash, err := readContentsAsString(remoteBranchFile)
-	if errors.Is(err, fs.ErrNotExist) {
-		// create branch on remote repo using current remote HEAD
-		remoteHeadBranchFile, err := readContentsAsString(filepath.Join(remoteMetadata.URL, "HEAD"))
-		if err != nil {
-			return fmt.Errorf("push: %w", err)
-		}
-		remoteHeadCommitHash, err = readContentsAsString(filepath.Join(filepath.Dir(remoteMetadata.URL), remoteHeadBranchFile))
-		if err != nil {
-			return fmt.Errorf("push: %w", err)
-		}
-		// create branch
-		if err := writeContents(filepath.Join(remoteMetadata.URL, "refs", "heads", remoteBranchName), []string{remoteHeadCommitHash}); err != nil {
-			return fmt.Errorf("push: %w", err)
-		}
-	} else if err != nil {
+	defer unlock()
+	headCommitHash, err := getHeadCommitHash()
+	if err != nil {
 		return fmt.Errorf("push: %w", err)
 	}
-	currentHeadCommitHash, err := getHeadCommitHash()
-	if err != nil {
+	remoteHeadCommitHash, err := readRemoteBranchHead(remoteName, remoteBranchName)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
 		return fmt.Errorf("push: %w", err)
 	}
-	if currentHeadCommitHash == remoteHeadCommitHash {
-		// no local commits to push to remote
+	if remoteHeadCommitHash == headCommitHash {
+		log.Println("Everything up-to-date.")
 		return nil
 	}
 
-	// check if remote branch head is in history of current head
-	inHistory := false
-	currentCommit, err := getCommit(currentHeadCommitHash)
-	if err != nil {
-		return fmt.Errorf("push: %w", err)
-	}
-	for {
-		if currentCommit.ParentUIDs[0] == remoteHeadCommitHash {
-			inHistory = true
-			break
-		} else if currentCommit.ParentUIDs[0] == "" {
-			break
-		}
-
-		currentCommit, err = getCommit(currentCommit.ParentUIDs[0])
+	// only fast-forward the remote branch
+	if remoteHeadCommitHash != "" {
+		ancestors, err := getAncestors(headCommitHash)
 		if err != nil {
 			return fmt.Errorf("push: %w", err)
 		}
-	}
-	if !inHistory {
-		log.Fatal("Please pull down remote changes before pushing.")
+		if !ancestors[remoteHeadCommitHash] {
+			exit("Please pull down remote changes before pushing.")
+		}
 	}
 
-	remoteBlobs := make(map[string]bool)
-	files, err := getFilenames(filepath.Join(remoteMetadata.URL, "objects"))
-	if err != nil {
-		return err
-	}
-	for _, blob := range files {
-		remoteBlobs[blob] = true
+	if err := uploadBranch(remoteName, remoteBranchName, remoteHeadCommitHash, headCommitHash); err != nil {
+		return fmt.Errorf("push: %w", err)
 	}
-
-	// BFS from local head to remote head commit
-	// copy all local commit and file blobs to remote objects dir
-	queue := []string{currentHeadCommitHash}
-	for len(queue) > 0 {
-
-		// write current blob
-		currentHash := queue[0]
-		queue = queue[1:]
-
-		// break if we reach matching hash
-		if currentHash == remoteHeadCommitHash {
-			break
-		}
-
-		// write commit
-		contents, err := readContents(filepath.Join(objectsDir, currentHash))
-		if err != nil {
-			return err
-		}
-		if err := writeContents(filepath.Join(remoteMetadata.URL, "objects", currentHash), contents); err != nil {
-			return err
-		}
-
-		// write local commit's file blobs to remote
-		currentCommit, err := getCommit(currentHeadCommitHash)
-		if err != nil {
-			return fmt.Errorf("push: %w", err)
-		}
-		// write file blobs
-		for _, blob := range currentCommit.FileToBlob {
-			// skip blobs already in remote repo
-			if _, ok := remoteBlobs[blob]; ok {
-				continue
-			}
-			// copy local blob to remote
-			contents, err := readContents(filepath.Join(objectsDir, blob))
-			if err != nil {
-				return err
-			}
-			if err := writeContents(filepath.Join(remoteMetadata.URL, "objects", blob), contents); err != nil {
-				return err
-			}
-			remoteBlobs[blob] = true
-		}
-
-		// add parent commits to traverse
-		for _, p := range currentCommit.ParentUIDs {
-			if p != "" {
-				queue = append(queue, p)
-			}
-		}
+	if err := writeRemoteTrackingBranch(remoteName, remoteBranchName, headCommitHash); err != nil {
+		return fmt.Errorf("push: %w", err)
 	}
-
-	// set remote head to same as local head
-	// write current branch head commit UID to remote branch head file
-	if err := writeContents(filepath.Join(remoteMetadata.URL, "refs", "heads", remoteBranchName), []string{currentHeadCommitHash}); err != nil {
-		return err
+	if remoteHeadCommitHash == "" {
+		log.Printf("Pushed new branch %v to %v.\n", remoteBranchName, remoteName)
+	} else {
+		log.Printf("Pushed %v..%v to %v/%v.\n", remoteHeadCommitHash[:6], headCommitHash[:6], remoteName, remoteBranchName)
 	}
 	return nil
 }
 
-// fetch copies all commits and blobs from the given branch in the remote repository
-// (that are not already in the current repository)
+// fetch copies the commits and blobs of the given branch in the remote repository that are
+// not already in the current repository, and records the branch's head commit as the
+// remote-tracking branch <remote>/<branch>. Local branches are not changed.
 func fetch(remoteName string, remoteBranchName string) error {
-	rIndex, err := readRemoteIndex()
+	unlock, err := lockRepo("fetch")
 	if err != nil {
 		return fmt.Errorf("fetch: %w", err)
 	}
-	remoteMetadata, ok := rIndex[remoteName]
-	if !ok {
-		log.Fatal("A remote with that name does not exist.")
-	}
-
-	if dirInfo, err := os.Stat(remoteMetadata.URL); errors.Is(err, fs.ErrNotExist) || (err != nil && !dirInfo.IsDir()) {
-		log.Fatal("Remote directory not found.")
-	} else if err != nil {
+	defer unlock()
+	if _, err := fetchRemoteBranch(remoteName, remoteBranchName); err != nil {
 		return fmt.Errorf("fetch: %w", err)
 	}
+	return nil
+}
 
-	remoteBranchHeadCommitUID, err := readContentsAsString(filepath.Join(remoteMetadata.URL, "refs", "heads", remoteBranchName))
+// fetchRemoteBranch copies the objects reachable from the given branch in the remote
+// repository into the current repository, and returns the remote branch's head commit.
+func fetchRemoteBranch(remoteName string, remoteBranchName string) (string, error) {
+	remoteHeadCommitHash, err := readRemoteBranchHead(remoteName, remoteBranchName)
 	if errors.Is(err, fs.ErrNotExist) {
-		log.Fatal("That remote does not have that branch.")
+		exit("That remote does not have that branch.")
 	} else if err != nil {
-		return err
+		return "", fmt.Errorf("fetchRemoteBranch: %w", err)
 	}
-
-	// get list of local blobs
-	localBlobs := make(map[string]bool)
-	files, err := getFilenames(objectsDir)
-	if err != nil {
-		return err
+	if err := downloadObjects(remoteName, remoteHeadCommitHash); err != nil {
+		return "", fmt.Errorf("fetchRemoteBranch: %w", err)
 	}
-	for _, blob := range files {
-		localBlobs[blob] = true
-	}
-
-	// BFS from head commit to initial commit?
-	queue := []string{remoteBranchHeadCommitUID}
-	for len(queue) > 0 {
-		commitHash := queue[0]
-		queue = queue[1:]
-
-		// write remote commit to local
-		commitContents, err := readContents(filepath.Join(remoteMetadata.URL, "objects", commitHash))
-		if err != nil {
-			return err
-		}
-		if err := writeContents(filepath.Join(objectsDir, commitHash), [][]byte{commitContents}); err != nil {
-			return err
-		}
-
-		// write remote commit's file blobs
-		curr, err := deserialize[commit](commitContents)
-		if err != nil {
-			return err
-		}
-		for _, blob := range curr.FileToBlob {
-			// skip blobs already in local repo
-			if _, ok := localBlobs[blob]; ok {
-				continue
-			}
-			// copy remote blob to local objects dir
-			contents, err := readContents(filepath.Join(remoteMetadata.URL, "objects", blob))
-			if err != nil {
-				return err
-			}
-			if err := writeContents(filepath.Join(objectsDir, blob), contents); err != nil {
-				return err
-			}
-			localBlobs[blob] = true
-		}
-
-		// add parent commits to traverse
-		for _, p := range curr.ParentUIDs {
-			if p != "" {
-				queue = append(queue, p)
-			}
-		}
+	if err := writeRemoteTrackingBranch(remoteName, remoteBranchName, remoteHeadCommitHash); err != nil {
+		return "", fmt.Errorf("fetchRemoteBranch: %w", err)
 	}
-	return nil
+	return remoteHeadCommitHash, nil
 }
 
-// pull
+// pull fetches the given branch from the remote repository and merges it into the current
+// branch, which is fast-forwarded when possible.
 func pull(remoteName string, remoteBranchName string) error {
-	if err := fetch(remoteName, remoteBranchName); err != nil {
+	unlock, err := lockRepo("pull")
+	if err != nil {
+		return fmt.Errorf("pull: %w", err)
+	}
+	defer unlock()
+	remoteHeadCommitHash, err := fetchRemoteBranch(remoteName, remoteBranchName)
+	if err != nil {
 		return fmt.Errorf("pull: %w", err)
 	}
-	if err := mergeBranch(remoteBranchName); err != nil {
+	if err := mergeCommit(remoteName+"/"+remoteBranchName, remoteHeadCommitHash, mergeOptions{}); err != nil {
 		return fmt.Errorf("pull: %w", err)
 	}
 	return nil
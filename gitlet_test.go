@@ -6,30 +6,33 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"testing"
+	"time"
 )
 
-const initialCommitHash = "f14a7dfac63092f78fb5d209312a84315dd9ef73"
+const initialCommitHash = "5a8ec0d8476b8b6865a7b799d21f1ed9508de6ee"
 
 func TestInit(t *testing.T) {
-	setupTempDir(t)
+	setupTestFS(t)
 	if err := newRepository(); err != nil {
 		t.Fatal(err)
 	}
 	// check dirs and files
 	for _, d := range []string{gitletDir, objectsDir, branchesDir, remotesDir, headFile, indexFile} {
-		if _, err := os.Stat(d); err != nil {
+		if _, err := repoFS.Stat(d); err != nil {
 			t.Fatal(err)
 		}
 	}
 	// check initial commit
 	expectedHash := initialCommitHash
-	if _, err := os.Stat(filepath.Join(objectsDir, expectedHash)); err != nil {
+	if _, err := repoFS.Stat(objectPath(expectedHash)); err != nil {
 		t.Fatal(err)
 	}
 	// check HEAD file
 	expectedHeadFile := filepath.Join(branchesDir, "main")
-	headBytes, err := os.ReadFile(headFile)
+	headBytes, err := readFile(headFile)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -38,7 +41,7 @@ func TestInit(t *testing.T) {
 		t.Fatalf("Incorrect head file contents, want %v, got %v\n", expectedHeadFile, actualHeadFile)
 	}
 	// check main branch
-	hashBytes, err := os.ReadFile(expectedHeadFile)
+	hashBytes, err := readFile(expectedHeadFile)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -51,7 +54,7 @@ func TestInit(t *testing.T) {
 func TestAddFile(t *testing.T) {
 	setupTestRepo(t)
 	testFile := "wug.txt"
-	if err := os.WriteFile(testFile, []byte("This is a wug"), 0644); err != nil {
+	if err := writeFile(testFile, []byte("This is a wug"), 0644); err != nil {
 		t.Fatal(err)
 	}
 	if err := stageFile(testFile); err != nil {
@@ -67,12 +70,12 @@ func TestAddFile(t *testing.T) {
 		t.Fatalf("Staged file not in index: %v\n", index)
 	}
 	// check objects for staged file blob
-	if _, err = os.Stat(filepath.Join(objectsDir, beforeMetadata.Hash)); err != nil {
+	if _, err = repoFS.Stat(objectPath(beforeMetadata.Hash)); err != nil {
 		t.Fatal("Staged file blob not found.")
 	}
 
 	// modify file and restage
-	f, err := os.OpenFile(testFile, os.O_WRONLY|os.O_APPEND, 0644)
+	f, err := repoFS.OpenFile(testFile, os.O_WRONLY|os.O_APPEND, 0644)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -88,7 +91,7 @@ func TestAddFile(t *testing.T) {
 	}
 
 	// after restaging, previously staged blob should not exist
-	if _, err := os.Stat(filepath.Join(objectsDir, beforeMetadata.Hash)); err == nil || !errors.Is(err, fs.ErrNotExist) {
+	if _, err := repoFS.Stat(objectPath(beforeMetadata.Hash)); err == nil || !errors.Is(err, fs.ErrNotExist) {
 		t.Fatal(err)
 	}
 
@@ -104,7 +107,7 @@ func TestAddFile(t *testing.T) {
 	if beforeMetadata.Hash == afterMetadata.Hash {
 		t.Fatal("Hashes are identical before and after staging changes.")
 	}
-	if _, err = os.Stat(filepath.Join(objectsDir, afterMetadata.Hash)); err != nil {
+	if _, err = repoFS.Stat(objectPath(afterMetadata.Hash)); err != nil {
 		t.Fatal("Restaged file blob not found.")
 	}
 
@@ -117,7 +120,7 @@ func TestAddFile(t *testing.T) {
 	}
 
 	// after staging, previously staged blob should not exist
-	if _, err := os.Stat(filepath.Join(objectsDir, afterMetadata.Hash)); err == nil || !errors.Is(err, fs.ErrNotExist) {
+	if _, err := repoFS.Stat(objectPath(afterMetadata.Hash)); err == nil || !errors.Is(err, fs.ErrNotExist) {
 		t.Fatal(err)
 	}
 
@@ -133,7 +136,7 @@ func TestAddFile(t *testing.T) {
 func TestNewCommit(t *testing.T) {
 	setupTestRepo(t)
 	testFile := "wug.txt"
-	if err := os.WriteFile(testFile, []byte("This is a wug"), 0644); err != nil {
+	if err := writeFile(testFile, []byte("This is a wug"), 0644); err != nil {
 		t.Fatal(err)
 	}
 
@@ -152,12 +155,12 @@ func TestNewCommit(t *testing.T) {
 	if err := newCommit("add wug file"); err != nil {
 		t.Fatal(err)
 	}
-	objects, err := getFilenames(objectsDir)
+	objects, err := getLooseObjectHashes()
 	if err != nil {
 		t.Fatal(err)
 	}
-	// expected blobs: initial commit, wug file, wug commit
-	if len(objects) != 3 {
+	// expected blobs: initial commit, wug file, wug tree, wug commit
+	if len(objects) != 4 {
 		t.Fatalf("Commit and/or file blobs not found. Found %v", objects)
 	}
 	// check index after commit
@@ -173,7 +176,7 @@ func TestNewCommit(t *testing.T) {
 func TestRemoveStaged(t *testing.T) {
 	setupTestRepo(t)
 	testFile := "wug.txt"
-	if err := os.WriteFile(testFile, []byte("This is a wug"), 0644); err != nil {
+	if err := writeFile(testFile, []byte("This is a wug"), 0644); err != nil {
 		t.Fatal(err)
 	}
 	if err := stageFile(testFile); err != nil {
@@ -198,13 +201,236 @@ func TestRemoveStaged(t *testing.T) {
 	}
 }
 
+func TestRemoveStagedSharedBlob(t *testing.T) {
+	setupTestRepo(t)
+	for _, file := range []string{"a.txt", "b.txt"} {
+		if err := writeFile(file, []byte("This is a wug"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := stageFiles([]string{"a.txt", "b.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	// the blob of a.txt is still staged for b.txt
+	if err := unstageFile("a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := newCommit("add b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	headCommitHash, err := getHeadCommitHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report, err := verifyHistory(headCommitHash); err != nil || len(report.Problems) != 0 {
+		t.Fatalf("History should verify after unstaging a file with the same contents: %+v, %v", report, err)
+	}
+	if err := repoFS.Remove("b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkoutHeadCommit("b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := readFile("b.txt"); err != nil || string(b) != "This is a wug" {
+		t.Fatalf("Incorrect contents checked out: %q, %v", b, err)
+	}
+}
+
 func TestRemoveTracked(t *testing.T) {}
 
-func TestLog(t *testing.T) {}
+func TestLog(t *testing.T) {
+	setupTestRepo(t)
+	first := commitInRepo(t, ".", "a.txt", "a")
+	second := commitInRepo(t, ".", "b.txt", "b")
+	commitHashes, err := getLogCommits(second, logOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{second, first, initialCommitHash}; !slices.Equal(commitHashes, want) {
+		t.Fatalf("Log does not list the history, want %v, got %v", want, commitHashes)
+	}
+	commitHashes, err = getLogCommits(second, logOptions{MaxCount: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{second, first}; !slices.Equal(commitHashes, want) {
+		t.Fatalf("Log does not stop at the maximum count, want %v, got %v", want, commitHashes)
+	}
+}
 
-func TestGlobalLog(t *testing.T) {}
+func TestLogFilters(t *testing.T) {
+	setupTestRepo(t)
+	if err := setConfig("user.name", "Wug"); err != nil {
+		t.Fatal(err)
+	}
+	wugHash := commitInRepo(t, ".", "a.txt", "a")
+	if err := setConfig("user.name", "Notwug"); err != nil {
+		t.Fatal(err)
+	}
+	notwugHash := commitInRepo(t, ".", "b.txt", "b")
+	notwugCommit, err := getCommit(notwugHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		opts     logOptions
+		expected []string
+	}{
+		{logOptions{Author: "Wug"}, []string{wugHash}},
+		{logOptions{Author: "Notwug"}, []string{notwugHash}},
+		{logOptions{Grep: regexp.MustCompile(`add [ab]\.txt`)}, []string{notwugHash, wugHash}},
+		{logOptions{Grep: regexp.MustCompile(`initial`), Author: "Wug"}, nil},
+		{logOptions{Since: time.Unix(notwugCommit.Timestamp+1, 0)}, nil},
+		{logOptions{Until: time.Unix(0, 0)}, []string{initialCommitHash}},
+		{logOptions{Paths: []string{"a.txt"}}, []string{wugHash}},
+		{logOptions{Paths: []string{"a.txt", "b.txt"}}, []string{notwugHash, wugHash}},
+		{logOptions{Paths: []string{"c.txt"}}, nil},
+	}
+	for _, test := range tests {
+		commitHashes, err := getLogCommits(notwugHash, test.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(commitHashes, test.expected) {
+			t.Errorf("getLogCommits(%+v) = %v, want %v", test.opts, commitHashes, test.expected)
+		}
+	}
+}
 
-func TestFind(t *testing.T) {}
+func TestLogOneline(t *testing.T) {
+	setupTestRepo(t)
+	if err := setConfig("user.name", "Wug"); err != nil {
+		t.Fatal(err)
+	}
+	first := commitInRepo(t, ".", "a.txt", "a")
+	if err := setConfig("user.name", "Notwug"); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeContents("b.txt", []string{"b"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stageFile("b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	// only the subject of a message with a body is printed
+	if err := newCommit("add b.txt\n\nThe body is not printed."); err != nil {
+		t.Fatal(err)
+	}
+	second, err := getHeadCommitHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		opts     logOptions
+		expected string
+	}{
+		{logOptions{}, second[:6] + " add b.txt\n" + first[:6] + " add a.txt\n" + initialCommitHash[:6] + " initial commit\n"},
+		{logOptions{MaxCount: 1}, second[:6] + " add b.txt\n"},
+		{logOptions{Author: "Wug"}, first[:6] + " add a.txt\n"},
+		{logOptions{Paths: []string{"a.txt", "b.txt"}, MaxCount: 1}, second[:6] + " add b.txt\n"},
+		{logOptions{Grep: regexp.MustCompile(`initial`)}, initialCommitHash[:6] + " initial commit\n"},
+		{logOptions{Grep: regexp.MustCompile(`body`)}, second[:6] + " add b.txt\n"},
+		{logOptions{Author: "nobody"}, ""},
+	}
+	for _, test := range tests {
+		test.opts.Oneline = true
+		out := captureLog(t)
+		if err := printBranchLog(test.opts); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != test.expected {
+			t.Errorf("printBranchLog(%+v) printed %q, want %q", test.opts, out.String(), test.expected)
+		}
+	}
+}
+
+func TestGlobalLog(t *testing.T) {
+	setupTestRepo(t)
+	commitInRepo(t, ".", "a.txt", "a")
+	commitInRepo(t, ".", "b.txt", "b")
+	commitHashes, commits, err := getAllCommits()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// file blobs are skipped
+	if len(commitHashes) != 3 || commitHashes[2] != initialCommitHash {
+		t.Fatalf("Global log does not list every commit, got %v", commitHashes)
+	}
+	for i := 1; i < len(commitHashes); i++ {
+		prev, curr := commits[commitHashes[i-1]], commits[commitHashes[i]]
+		if prev.Timestamp < curr.Timestamp || (prev.Timestamp == curr.Timestamp && commitHashes[i-1] > commitHashes[i]) {
+			t.Fatalf("Global log is not ordered newest first, got %v", commitHashes)
+		}
+	}
+}
+
+func TestFind(t *testing.T) {
+	setupTestRepo(t)
+	if err := setConfig("user.name", "Wug"); err != nil {
+		t.Fatal(err)
+	}
+	first := commitInRepo(t, ".", "a.txt", "a")
+	if err := setConfig("user.name", "Notwug"); err != nil {
+		t.Fatal(err)
+	}
+	second := commitInRepo(t, ".", "b.txt", "b")
+	commitHashes, commits, err := getAllCommits()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// both commits are newer than the initial commit, and are found newest first
+	newest := commitHashes[:2]
+	sinceEpoch, err := parseDate("2000-01-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		opts     logOptions
+		expected []string
+	}{
+		{logOptions{Grep: regexp.MustCompile(regexp.QuoteMeta("add a.txt"))}, []string{first}},
+		{logOptions{Grep: regexp.MustCompile(`^add [ab]\.txt$`)}, newest},
+		{logOptions{Grep: regexp.MustCompile(`add`), Author: "Notwug"}, []string{second}},
+		{logOptions{Author: "ug"}, newest},
+		{logOptions{Until: sinceEpoch}, []string{initialCommitHash}},
+		{logOptions{Since: sinceEpoch}, newest},
+		{logOptions{Since: sinceEpoch, Grep: regexp.MustCompile(`initial`)}, nil},
+	}
+	for _, test := range tests {
+		if test.expected == nil {
+			// finding nothing exits, so only the filters are checked
+			for _, hash := range commitHashes {
+				if test.opts.matches(commits[hash]) {
+					t.Errorf("Commit %v should not match %+v", hash, test.opts)
+				}
+			}
+			continue
+		}
+		out := captureLog(t)
+		if err := printMatchingCommits(test.opts, false); err != nil {
+			t.Fatal(err)
+		}
+		var expected string
+		for _, hash := range test.expected {
+			expected += "commit " + hash + "\n"
+		}
+		if out.String() != expected {
+			t.Errorf("printMatchingCommits(%+v) printed %q, want %q", test.opts, out.String(), expected)
+		}
+	}
+
+	out := captureLog(t)
+	if err := printMatchingCommits(logOptions{Author: "Notwug"}, true); err != nil {
+		t.Fatal(err)
+	}
+	c, err := getCommit(second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "===\n" + c.String(second) + "\n"; out.String() != expected {
+		t.Errorf("Long find printed %q, want %q", out.String(), expected)
+	}
+}
 
 func TestStatus(t *testing.T) {}
 
@@ -248,11 +474,44 @@ func TestRemoveBranch(t *testing.T) {
 		t.Fatal(err)
 	}
 	// check if branch was deleted
-	if _, err := os.Stat(filepath.Join(branchesDir, testBranch)); err == nil {
+	if _, err := repoFS.Stat(filepath.Join(branchesDir, testBranch)); err == nil {
 		t.Fatalf("Branch '%v' was not removed: %v", testBranch, err)
 	}
 }
 
+func TestMergedBranches(t *testing.T) {
+	setupTestRepo(t)
+	if err := addBranch("merged"); err != nil {
+		t.Fatal(err)
+	}
+	if err := createAndSwitchBranch("unmerged"); err != nil {
+		t.Fatal(err)
+	}
+	commitInRepo(t, ".", "wug.txt", "wug")
+	if err := checkoutBranch("main"); err != nil {
+		t.Fatal(err)
+	}
+	headCommitHash, err := getHeadCommitHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		merged   bool
+		expected []string
+	}{
+		{true, []string{"main", "merged"}},
+		{false, []string{"unmerged"}},
+	} {
+		branches, err := getMergedBranches(headCommitHash, test.merged)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(branches, test.expected) {
+			t.Errorf("getMergedBranches(%v) = %v, want %v", test.merged, branches, test.expected)
+		}
+	}
+}
+
 func TestReset(t *testing.T) {}
 
 func TestMerge(t *testing.T) {
@@ -318,7 +577,7 @@ func TestMerge(t *testing.T) {
 		t.Error(err)
 	}
 
-	if err := mergeBranch("target"); err != nil {
+	if err := mergeBranch("target", mergeOptions{}); err != nil {
 		t.Error(err)
 	}
 
@@ -356,3 +615,145 @@ func TestMerge(t *testing.T) {
 		t.Errorf("Incorrect merge commit message: %v", mergeCommit.Message)
 	}
 }
+
+// setupMergeBranches commits a.txt and b.txt, then changes a.txt differently on the current
+// branch and on a new target branch, which also adds c.txt.
+func setupMergeBranches(t *testing.T) {
+	t.Helper()
+	commitFiles := func(message string, files map[string]string) {
+		t.Helper()
+		for file, contents := range files {
+			if err := writeContents(file, []string{contents}); err != nil {
+				t.Fatal(err)
+			}
+			if err := stageFile(file); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := newCommit(message); err != nil {
+			t.Fatal(err)
+		}
+	}
+	commitFiles("commit split point", map[string]string{"a.txt": "A", "b.txt": "B"})
+	if err := addBranch("target"); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkoutBranch("target"); err != nil {
+		t.Fatal(err)
+	}
+	commitFiles("commit target branch", map[string]string{"a.txt": "T", "c.txt": "C"})
+	if err := checkoutBranch("main"); err != nil {
+		t.Fatal(err)
+	}
+	commitFiles("commit current branch", map[string]string{"a.txt": "M"})
+}
+
+func TestMergeStrategies(t *testing.T) {
+	for _, test := range []struct {
+		strategy string
+		expected string
+	}{
+		{mergeOurs, "M"},
+		{mergeTheirs, "T"},
+	} {
+		t.Run(test.strategy, func(t *testing.T) {
+			setupTestRepo(t)
+			setupMergeBranches(t)
+			if err := mergeBranch("target", mergeOptions{Strategy: test.strategy}); err != nil {
+				t.Fatal(err)
+			}
+			if contents, err := readContentsAsString("a.txt"); err != nil || contents != test.expected {
+				t.Errorf("Expected a.txt to be %q, got %q, %v", test.expected, contents, err)
+			}
+			if contents, err := readContentsAsString("c.txt"); err != nil || contents != "C" {
+				t.Errorf("Expected c.txt to be merged, got %q, %v", contents, err)
+			}
+			if state, err := readMergeState(); err != nil || state != nil {
+				t.Errorf("Expected no conflicts, got %v, %v", state, err)
+			}
+			headCommit, err := getHeadCommit()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if headCommit.ParentUIDs[1] == "" {
+				t.Errorf("Expected a merge commit, got %+v", headCommit)
+			}
+		})
+	}
+}
+
+func TestMergeNoFastForward(t *testing.T) {
+	setupTestRepo(t)
+	if err := addBranch("target"); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkoutBranch("target"); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeContents("wug.txt", []string{"wug"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stageFile("wug.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := newCommit("add wug"); err != nil {
+		t.Fatal(err)
+	}
+	targetCommitHash, err := getHeadCommitHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checkoutBranch("main"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mergeBranch("target", mergeOptions{NoFastForward: true}); err != nil {
+		t.Fatal(err)
+	}
+	headCommit, err := getHeadCommit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if headCommit.ParentUIDs != [2]string{initialCommitHash, targetCommitHash} {
+		t.Errorf("Expected a merge commit of main and target, got parents %v", headCommit.ParentUIDs)
+	}
+	if _, ok := headCommit.FileToBlob["wug.txt"]; !ok {
+		t.Errorf("Merge commit is missing wug.txt: %v", headCommit.FileToBlob)
+	}
+	if contents, err := readContentsAsString("wug.txt"); err != nil || contents != "wug" {
+		t.Errorf("Expected wug.txt to be checked out, got %q, %v", contents, err)
+	}
+}
+
+func TestMergeSquash(t *testing.T) {
+	setupTestRepo(t)
+	setupMergeBranches(t)
+	headCommitHash, err := getHeadCommitHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mergeBranch("target", mergeOptions{Strategy: mergeTheirs, Squash: true}); err != nil {
+		t.Fatal(err)
+	}
+	if hash, err := getHeadCommitHash(); err != nil || hash != headCommitHash {
+		t.Errorf("A squash merge should not commit, got head %v, %v", hash, err)
+	}
+	index, err := readIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(index) != 2 || index["a.txt"].Op != indexAdd || index["c.txt"].Op != indexAdd {
+		t.Errorf("Expected a.txt and c.txt to be staged, got %v", index)
+	}
+
+	if err := newCommit("squash target"); err != nil {
+		t.Fatal(err)
+	}
+	headCommit, err := getHeadCommit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if headCommit.ParentUIDs != [2]string{headCommitHash, ""} {
+		t.Errorf("Expected a regular commit, got parents %v", headCommit.ParentUIDs)
+	}
+}
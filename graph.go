@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// graphEdge is a line of the commit graph moving from one column to another between rows.
+type graphEdge struct {
+	From int
+	To   int
+}
+
+// getGraphCommits returns the commits reachable from a commit through either parent, in the
+// order the graph draws them: newest first, but never before one of its children, and the
+// commits by hash. At most maxCount commits are returned, unless maxCount is 0. History is
+// walked through the commit graph, so only the returned commits are read.
+func getGraphCommits(commitHash string, maxCount int) ([]string, map[string]commit, error) {
+	graph, err := readCommitGraph()
+	if err != nil {
+		return nil, nil, fmt.Errorf("getGraphCommits: %w", err)
+	}
+	entries := make(map[string]commitGraphEntry)
+	children := make(map[string]int)
+	queue := []string{commitHash}
+	for len(queue) > 0 {
+		hash := queue[0]
+		queue = queue[1:]
+		if _, seen := entries[hash]; seen {
+			continue
+		}
+		entry, err := graph.lookup(hash)
+		if err != nil {
+			return nil, nil, fmt.Errorf("getGraphCommits: %w", err)
+		}
+		entries[hash] = entry
+		for _, parent := range entry.Parents {
+			children[parent]++
+			queue = append(queue, parent)
+		}
+	}
+
+	var commitHashes []string
+	commits := make(map[string]commit)
+	ready := []string{commitHash}
+	for len(ready) > 0 && (maxCount <= 0 || len(commitHashes) < maxCount) {
+		next := 0
+		for i, hash := range ready {
+			entry, best := entries[hash], entries[ready[next]]
+			if entry.Timestamp > best.Timestamp || (entry.Timestamp == best.Timestamp && hash < ready[next]) {
+				next = i
+			}
+		}
+		hash := ready[next]
+		ready = slices.Delete(ready, next, next+1)
+		commitHashes = append(commitHashes, hash)
+		if commits[hash], err = getCommitMetadata(hash); err != nil {
+			return nil, nil, fmt.Errorf("getGraphCommits: %w", err)
+		}
+		for _, parent := range entries[hash].Parents {
+			if children[parent]--; children[parent] == 0 {
+				ready = append(ready, parent)
+			}
+		}
+	}
+	return commitHashes, commits, nil
+}
+
+// getParents returns the distinct parents of a commit.
+func getParents(c commit) []string {
+	var parents []string
+	for _, parent := range c.ParentUIDs {
+		if parent != "" && !slices.Contains(parents, parent) {
+			parents = append(parents, parent)
+		}
+	}
+	return parents
+}
+
+// drawGraphTransition returns the connector rows that move the graph's lines from their
+// columns to their new columns, one column per row. Returns no rows if no line moves.
+func drawGraphTransition(edges []graphEdge) []string {
+	width := 0
+	for _, e := range edges {
+		width = max(width, e.From+1, e.To+1)
+	}
+	var rows []string
+	positions := make([]int, len(edges))
+	for i, e := range edges {
+		positions[i] = e.From
+	}
+	for {
+		moving := false
+		for i, e := range edges {
+			moving = moving || positions[i] != e.To
+		}
+		if !moving {
+			return rows
+		}
+		row := []byte(strings.Repeat(" ", 2*width))
+		for i, e := range edges {
+			switch pos := positions[i]; {
+			case pos == e.To:
+				row[2*pos] = '|'
+			case pos > e.To:
+				row[2*pos-1] = '/'
+				positions[i]--
+			default:
+				row[2*pos+1] = '\\'
+				positions[i]++
+			}
+		}
+		rows = append(rows, strings.TrimRight(string(row), " "))
+	}
+}
+
+// drawGraph returns the lines of the commit graph for commits in graph order: a row for each
+// commit, with a column for every line of history still to be drawn and "*" marking the
+// commit's column, followed by the connector rows to its parents. Each commit's row ends
+// with its one-line summary, or is followed by its full log entry.
+func drawGraph(commitHashes []string, commits map[string]commit, oneline bool) []string {
+	var lines []string
+	columns := []string{commitHashes[0]}
+	for _, hash := range commitHashes {
+		c := commits[hash]
+		col := slices.Index(columns, hash)
+		if col < 0 {
+			col = len(columns)
+			columns = append(columns, hash)
+		}
+		parents := getParents(c)
+
+		// lay out the columns after the commit, then merge lines with the same commit
+		next := slices.Clone(columns)
+		if len(parents) == 0 {
+			next = slices.Delete(next, col, col+1)
+		} else {
+			next[col] = parents[0]
+			for _, parent := range parents[1:] {
+				if !slices.Contains(next, parent) {
+					next = slices.Insert(next, col+1, parent)
+				}
+			}
+		}
+		var deduped []string
+		for _, h := range next {
+			if !slices.Contains(deduped, h) {
+				deduped = append(deduped, h)
+			}
+		}
+		var edges []graphEdge
+		for i, h := range columns {
+			if i != col {
+				edges = append(edges, graphEdge{i, slices.Index(deduped, h)})
+				continue
+			}
+			for _, parent := range parents {
+				edges = append(edges, graphEdge{i, slices.Index(deduped, parent)})
+			}
+		}
+
+		marker, continuation := make([]string, len(columns)), make([]string, len(columns))
+		for i := range columns {
+			marker[i], continuation[i] = "|", "|"
+		}
+		marker[col] = "*"
+		if len(parents) == 0 {
+			continuation[col] = " "
+		}
+		text := []string{c.Oneline(hash)}
+		if !oneline {
+			text = strings.Split(c.String(hash), "\n")
+		}
+		for i, line := range text {
+			prefix := strings.Join(continuation, " ")
+			if i == 0 {
+				prefix = strings.Join(marker, " ")
+			}
+			lines = append(lines, strings.TrimRight(prefix+" "+line, " "))
+		}
+		lines = append(lines, drawGraphTransition(edges)...)
+		columns = deduped
+	}
+	return lines
+}
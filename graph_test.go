@@ -0,0 +1,60 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestDrawGraph(t *testing.T) {
+	// base <- main1 <- merge, base <- side1 <- merge
+	base, main1, side1, merge := strings.Repeat("a", 40), strings.Repeat("b", 40), strings.Repeat("c", 40), strings.Repeat("d", 40)
+	commits := map[string]commit{
+		base:  {Message: "base", Timestamp: 1},
+		main1: {Message: "main1", Timestamp: 2, ParentUIDs: [2]string{base}},
+		side1: {Message: "side1", Timestamp: 3, ParentUIDs: [2]string{base}},
+		merge: {Message: "merge", Timestamp: 4, ParentUIDs: [2]string{main1, side1}},
+	}
+	lines := drawGraph([]string{merge, side1, main1, base}, commits, true)
+	expected := []string{
+		"* dddddd merge",
+		`|\`,
+		"| * cccccc side1",
+		"* | bbbbbb main1",
+		"|/",
+		"* aaaaaa base",
+	}
+	if !slices.Equal(lines, expected) {
+		t.Fatalf("Graph does not match, want:\n%v\ngot:\n%v", strings.Join(expected, "\n"), strings.Join(lines, "\n"))
+	}
+}
+
+func TestGetGraphCommits(t *testing.T) {
+	setupTestRepo(t)
+	setupMergeBranches(t)
+	if err := mergeBranch("target", mergeOptions{Strategy: mergeOurs}); err != nil {
+		t.Fatal(err)
+	}
+	headCommitHash, err := getHeadCommitHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	commitHashes, commits, err := getGraphCommits(headCommitHash, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// split commit, main and target commits, merge commit, and initial commit
+	if len(commitHashes) != 5 || commitHashes[0] != headCommitHash || commitHashes[4] != initialCommitHash {
+		t.Fatalf("Graph does not list the merged history, got %v", commitHashes)
+	}
+	for i, hash := range commitHashes {
+		for _, parent := range getParents(commits[hash]) {
+			if slices.Index(commitHashes, parent) < i {
+				t.Fatalf("Commit %v is listed before its child %v.", parent, hash)
+			}
+		}
+	}
+	if commitHashes, _, err = getGraphCommits(headCommitHash, 2); err != nil || len(commitHashes) != 2 {
+		t.Fatalf("Graph does not stop at the maximum count, got %v, %v", commitHashes, err)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"regexp"
+)
+
+// grepMatch is a line of a tracked file matching a grep pattern.
+type grepMatch struct {
+	File   string
+	Line   int // Line number, starting at 1, or 0 for a binary file.
+	Text   string
+	Binary bool // The file is binary, so only the fact that it matches is reported.
+}
+
+// grepCommit searches the files tracked by a commit for lines matching a regular expression.
+// Matches are ordered by file, then by line. A binary file is reported once if it matches.
+func grepCommit(commitHash string, re *regexp.Regexp) ([]grepMatch, error) {
+	c, err := getCommit(commitHash)
+	if err != nil {
+		return nil, fmt.Errorf("grepCommit: %w", err)
+	}
+	var matches []grepMatch
+	for _, file := range sortedKeys(c.FileToBlob) {
+		_, contents, err := readBlob(c.FileToBlob[file])
+		if err != nil {
+			return nil, fmt.Errorf("grepCommit: %w", err)
+		}
+		if bytes.IndexByte(contents, 0) >= 0 {
+			if re.Match(contents) {
+				matches = append(matches, grepMatch{File: file, Binary: true})
+			}
+			continue
+		}
+		for i, line := range splitLines(contents) {
+			if re.MatchString(line) {
+				matches = append(matches, grepMatch{File: file, Line: i + 1, Text: line})
+			}
+		}
+	}
+	return matches, nil
+}
+
+// printGrep prints the lines of the files tracked by the commit named by a revision that
+// match a regular expression, as file:line:text.
+func printGrep(pattern string, rev string) error {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		exitf("Invalid pattern: %v.", err)
+	}
+	commitHash, err := resolveRevision(rev)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			exit("No commit with that id exists.")
+		}
+		return fmt.Errorf("printGrep: %w", err)
+	}
+	matches, err := grepCommit(commitHash, re)
+	if err != nil {
+		return fmt.Errorf("printGrep: %w", err)
+	}
+	for _, m := range matches {
+		if m.Binary {
+			log.Printf("Binary file %v matches\n", m.File)
+		} else {
+			log.Printf("%v:%v:%v\n", m.File, m.Line, m.Text)
+		}
+	}
+	return nil
+}
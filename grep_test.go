@@ -0,0 +1,56 @@
+package main
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestGrepCommit(t *testing.T) {
+	setupTestRepo(t)
+	if err := writeContents("a.txt", []string{"a wug\n", "not a match\n", "two wugs\n"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeContents("b.bin", [][]byte{{'w', 'u', 'g', 0}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{"a.txt", "b.bin"} {
+		if err := stageFile(file); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := newCommit("add files"); err != nil {
+		t.Fatal(err)
+	}
+	headCommitHash, err := getHeadCommitHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// working directory changes are not searched
+	if err := writeContents("a.txt", []string{"wug wug wug\n"}); err != nil {
+		t.Fatal(err)
+	}
+
+	matches, err := grepCommit(headCommitHash, regexp.MustCompile(`wugs?$`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []grepMatch{
+		{File: "a.txt", Line: 1, Text: "a wug"},
+		{File: "a.txt", Line: 3, Text: "two wugs"},
+	}
+	if !reflect.DeepEqual(matches, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, matches)
+	}
+	matches, err = grepCommit(headCommitHash, regexp.MustCompile(`wug`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 3 || !matches[2].Binary || matches[2].File != "b.bin" {
+		t.Errorf("Expected a binary match for b.bin, got %+v", matches)
+	}
+	matches, err = grepCommit(initialCommitHash, regexp.MustCompile(`wug`))
+	if err != nil || len(matches) != 0 {
+		t.Errorf("Expected no matches in the initial commit, got %+v, %v", matches, err)
+	}
+}
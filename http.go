@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Remote URLs starting with http:// or https:// name a repository hosted by gitlet serve-http.
+// The server advertises its branches at <url>/info/refs, sends the objects a fetch asks for
+// from <url>/upload-objects, and receives pushed objects at <url>/receive-objects. Media of
+// large files are downloaded from <url>/lfs/download and uploaded to <url>/lfs/upload.
+const defaultHTTPAddr string = ":8080"
+
+// Endpoints of a repository hosted by gitlet serve-http.
+const (
+	httpRefsPath     = "/info/refs"
+	httpUploadPath   = "/upload-objects"
+	httpReceivePath  = "/receive-objects"
+	httpMediaGetPath = "/lfs/download"
+	httpMediaPutPath = "/lfs/upload"
+)
+
+// httpEndpoints maps the operations sent as POST requests to their endpoints.
+var httpEndpoints = map[string]string{
+	fetchOp:      httpUploadPath,
+	pushOp:       httpReceivePath,
+	mediaFetchOp: httpMediaGetPath,
+	mediaPushOp:  httpMediaPutPath,
+}
+
+// isHTTPURL reports whether a remote URL names a repository hosted by gitlet serve-http.
+func isHTTPURL(url string) bool {
+	return strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://")
+}
+
+// requestHTTP sends a request to the repository hosted at an HTTP URL and returns the
+// response. Exits if the server cannot be reached.
+func requestHTTP(url string, req remoteRequest) (remoteResponse, error) {
+	url = strings.TrimSuffix(url, "/")
+	var httpResp *http.Response
+	var err error
+	switch req.Op {
+	case headsOp:
+		httpResp, err = http.Get(url + httpRefsPath)
+	case fetchOp, pushOp, mediaFetchOp, mediaPushOp:
+		endpoint := httpEndpoints[req.Op]
+		var b []byte
+		if b, err = serialize(req); err != nil {
+			return remoteResponse{}, fmt.Errorf("requestHTTP: %w", err)
+		}
+		httpResp, err = http.Post(url+endpoint, "application/json", bytes.NewReader(b))
+	default:
+		return remoteResponse{}, fmt.Errorf("requestHTTP: unknown operation '%v'", req.Op)
+	}
+	if err != nil {
+		exitf("Could not connect to remote at %v.", url)
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		return remoteResponse{}, fmt.Errorf("requestHTTP: %v", httpResp.Status)
+	}
+	b, err := io.ReadAll(io.LimitReader(httpResp.Body, int64(maxRemoteMessageSize)+1))
+	if err != nil {
+		return remoteResponse{}, fmt.Errorf("requestHTTP: %w", err)
+	}
+	if len(b) > int(maxRemoteMessageSize) {
+		return remoteResponse{}, fmt.Errorf("requestHTTP: response of more than %v bytes is too large", maxRemoteMessageSize)
+	}
+	resp, err := deserialize[remoteResponse](b)
+	if err != nil {
+		return remoteResponse{}, fmt.Errorf("requestHTTP: %w", err)
+	}
+	return resp, nil
+}
+
+// newHTTPHandler returns a handler hosting the repository in dir, which must be absolute.
+// Pushes are refused unless allowPush.
+func newHTTPHandler(dir string, allowPush bool) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+httpRefsPath, func(w http.ResponseWriter, r *http.Request) {
+		writeHTTPResponse(w, handleRemoteRequest(dir, allowPush, remoteRequest{Op: headsOp}))
+	})
+	for op, endpoint := range httpEndpoints {
+		mux.HandleFunc("POST "+endpoint, func(w http.ResponseWriter, r *http.Request) {
+			b, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(maxRemoteMessageSize)))
+			if err != nil {
+				http.Error(w, "request too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			req, err := deserialize[remoteRequest](b)
+			if err != nil {
+				http.Error(w, "malformed request", http.StatusBadRequest)
+				return
+			}
+			// the hosted repository is the only one, whatever the client asks for
+			req.Op, req.Repo = op, ""
+			writeHTTPResponse(w, handleRemoteRequest(dir, allowPush, req))
+		})
+	}
+	return mux
+}
+
+// writeHTTPResponse sends the answer to a request as JSON.
+func writeHTTPResponse(w http.ResponseWriter, resp remoteResponse) {
+	b, err := serialize(resp)
+	if err != nil {
+		http.Error(w, serverInternalError, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// serveHTTP hosts the repository in dir over HTTP to the connections accepted by a listener,
+// until the listener is closed. Pushes are refused unless allowPush.
+func serveHTTP(l net.Listener, dir string, allowPush bool) error {
+	dir, err := repoFS.Abs(dir)
+	if err != nil {
+		return fmt.Errorf("serveHTTP: %w", err)
+	}
+	if err := http.Serve(l, newHTTPHandler(dir, allowPush)); err != nil && !errors.Is(err, net.ErrClosed) {
+		return fmt.Errorf("serveHTTP: %w", err)
+	}
+	return nil
+}
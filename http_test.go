@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestHTTPPushFetch(t *testing.T) {
+	setupTestFS(t)
+	remoteDir := setupRemoteRepo(t)
+	absRemoteDir, err := repoFS.Abs(remoteDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(newHTTPHandler(absRemoteDir, true))
+	t.Cleanup(server.Close)
+	if err := repoFS.Mkdir("local", 0755); err != nil {
+		t.Fatal(err)
+	}
+	enterTestDir(t, "local")
+	if err := newRepository(); err != nil {
+		t.Fatal(err)
+	}
+	if err := addRemote("origin", server.URL+"/"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := pull("origin", "main"); err != nil {
+		t.Fatal(err)
+	}
+	if contents, err := readContentsAsString("wug.txt"); err != nil || contents != "This is a wug" {
+		t.Fatalf("Pull did not check out the fetched file: %q, %v", contents, err)
+	}
+	headHash := commitInRepo(t, ".", "notwug.txt", "This is not a wug")
+	if err := push("origin", "feature"); err != nil {
+		t.Fatal(err)
+	}
+	if hash, err := readContentsAsString(filepath.Join(remoteDir, branchesDir, "feature")); err != nil || hash != headHash {
+		t.Fatalf("Remote branch was not pushed: want %v, got %v, %v", headHash, hash, err)
+	}
+	if hash, err := readRemoteBranchHead("origin", "feature"); err != nil || hash != headHash {
+		t.Fatalf("Incorrect advertised branch head: want %v, got %v, %v", headHash, hash, err)
+	}
+}
+
+func TestHTTPClone(t *testing.T) {
+	setupTestFS(t)
+	remoteDir := setupRemoteRepo(t)
+	absRemoteDir, err := repoFS.Abs(remoteDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	featureHash := ""
+	if err := inRepository(remoteDir, func() error {
+		if err := setConfig("chunk.threshold", "1000"); err != nil {
+			return err
+		}
+		if err := addBranch("feature"); err != nil {
+			return err
+		}
+		if err := checkoutBranch("feature"); err != nil {
+			return err
+		}
+		featureHash = commitInRepo(t, ".", "notwug.txt", "This is not a wug")
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(newHTTPHandler(absRemoteDir, false))
+	t.Cleanup(server.Close)
+
+	if err := cloneRemote(server.URL+"/", "clone"); err != nil {
+		t.Fatal(err)
+	}
+	if contents, err := readContentsAsString(filepath.Join("clone", "notwug.txt")); err != nil || contents != "This is not a wug" {
+		t.Fatalf("Clone did not check out the remote head: %q, %v", contents, err)
+	}
+	enterTestDir(t, "clone")
+	if branch, err := getCurrentBranch(); err != nil || branch != "feature" {
+		t.Errorf("Clone should check out the remote's branch, got %q, %v", branch, err)
+	}
+	if hash, err := readContentsAsString(filepath.Join(remotesDir, "origin", "feature")); err != nil || hash != featureHash {
+		t.Errorf("Incorrect remote-tracking branch: want %v, got %v, %v", featureHash, hash, err)
+	}
+	if _, err := readContentsAsString(filepath.Join(branchesDir, "main")); err != nil {
+		t.Errorf("Clone should have every remote branch: %v", err)
+	}
+	if remotes, err := readRemoteIndex(); err != nil || remotes["origin"].URL != server.URL+"/" {
+		t.Errorf("Incorrect origin remote: %+v, %v", remotes["origin"], err)
+	}
+	if value, _, err := getConfig("chunk.threshold"); err != nil || value != "1000" {
+		t.Errorf("Clone should copy the remote's chunk threshold, got %q, %v", value, err)
+	}
+	if report, err := verifyHistory(featureHash); err != nil || len(report.Problems) > 0 {
+		t.Errorf("Clone is missing history: %+v, %v", report, err)
+	}
+}
@@ -1,14 +1,54 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"math"
 )
 
+// Operation staged for a file.
+type indexOp string
+
+const (
+	indexAdd       indexOp = "add"       // File contents are staged to be committed.
+	indexRemove    indexOp = "remove"    // File is staged to be removed from the next commit.
+	indexConflict  indexOp = "conflict"  // File contents with merge conflict markers are staged.
+	indexSubmodule indexOp = "submodule" // Submodule is staged to be pinned to the commit in Hash.
+)
+
+// Hash that marked files staged for removal before index entries recorded their operation.
+const legacyStagedForRemovalMarker string = "DELETED"
+
+// The index file is binary, with entries sorted by file name. Older repositories store it as
+// JSON, which is read transparently and replaced on the next index write.
+//
+//	index: magic | version | count | (op, flags, mod time, file size, hash length,
+//	       name length, hash, name)... | checksum
+const (
+	indexMagic           string = "GIDX"
+	indexVersion         uint32 = 2
+	indexHeaderSize      int    = 12
+	indexEntryHeaderSize int    = 1 + 1 + 8 + 8 + 1 + 2
+)
+
+// Flags of binary index entries.
+const indexFlagStagedOnly byte = 1 << 0
+
+// indexOpCodes numbers the operations in the binary index. Zero is never a valid code.
+var indexOpCodes = map[indexOp]byte{indexAdd: 1, indexRemove: 2, indexConflict: 3, indexSubmodule: 4}
+
 // Metadata for staged files.
 type indexMetadata struct {
-	Hash     string // Hash of the staged file blob.
-	ModTime  int64  // Timestamp of staging.
-	FileSize int64  // Size of file blob.
+	Op       indexOp // Operation staged for the file.
+	Hash     string  // Hash of the staged file blob or submodule commit, empty if staged for removal.
+	ModTime  int64   // Timestamp of staging.
+	FileSize int64   // Size of file blob.
+	// StagedOnly reports that staging wrote the blob, which no commit referred to then, so
+	// it is deleted if the file is unstaged or the index is discarded.
+	StagedOnly bool
 }
 
 // Map between filename and staging metadata.
@@ -16,29 +56,133 @@ type indexMap map[string]indexMetadata
 
 // Read the index file and return the index map object.
 func readIndex() (indexMap, error) {
-	indexData, err := readContents(indexFile)
+	indexData, err := readFile(indexFile)
 	if err != nil {
 		return nil, fmt.Errorf("readIndex: cannot read index file: %w", err)
 	}
+	if bytes.HasPrefix(indexData, []byte(indexMagic)) {
+		index, err := decodeIndex(indexData)
+		if err != nil {
+			return nil, fmt.Errorf("readIndex: %w", err)
+		}
+		return index, nil
+	}
 	index, err := deserialize[indexMap](indexData)
 	if err != nil {
 		return nil, fmt.Errorf("readIndex: %w", err)
 	}
+	// migrate entries written before operations were recorded,
+	// which are rewritten with their operation on the next index write
+	for file, metadata := range index {
+		if metadata.Op != "" {
+			continue
+		}
+		if metadata.Hash == legacyStagedForRemovalMarker {
+			metadata.Op, metadata.Hash = indexRemove, ""
+		} else {
+			metadata.Op = indexAdd
+		}
+		index[file] = metadata
+	}
 	return index, nil
 }
 
 // Write the index map object to the index file.
 func writeIndex(i indexMap) error {
-	indexData, err := serialize(i)
+	unlock, err := lockFile(indexFile, "update index")
 	if err != nil {
 		return fmt.Errorf("writeIndex: %w", err)
 	}
-	if err = writeContents(indexFile, [][]byte{indexData}); err != nil {
+	defer unlock()
+	indexData, err := encodeIndex(i)
+	if err != nil {
+		return fmt.Errorf("writeIndex: %w", err)
+	}
+	if err = writeFileAtomic(indexFile, indexData); err != nil {
 		return fmt.Errorf("writeIndex: %w", err)
 	}
 	return nil
 }
 
+// encodeIndex returns the binary index file contents of an index map.
+func encodeIndex(index indexMap) ([]byte, error) {
+	b := make([]byte, indexHeaderSize)
+	copy(b, indexMagic)
+	binary.BigEndian.PutUint32(b[4:], indexVersion)
+	binary.BigEndian.PutUint32(b[8:], uint32(len(index)))
+	for _, file := range sortedKeys(index) {
+		metadata := index[file]
+		op, ok := indexOpCodes[metadata.Op]
+		if !ok {
+			return nil, fmt.Errorf("encodeIndex: '%v' has unknown operation '%v'", file, metadata.Op)
+		}
+		if len(metadata.Hash) > math.MaxUint8 || len(file) > math.MaxUint16 {
+			return nil, fmt.Errorf("encodeIndex: entry for '%v' is too long", file)
+		}
+		var flags byte
+		if metadata.StagedOnly {
+			flags |= indexFlagStagedOnly
+		}
+		b = append(b, op, flags)
+		b = binary.BigEndian.AppendUint64(b, uint64(metadata.ModTime))
+		b = binary.BigEndian.AppendUint64(b, uint64(metadata.FileSize))
+		b = append(b, byte(len(metadata.Hash)))
+		b = binary.BigEndian.AppendUint16(b, uint16(len(file)))
+		b = append(b, metadata.Hash...)
+		b = append(b, file...)
+	}
+	sum := sha1.Sum(b)
+	return append(b, sum[:]...), nil
+}
+
+// decodeIndex returns the index map stored in binary index file contents.
+// Returns an error if the contents are truncated or do not match their checksum.
+func decodeIndex(b []byte) (indexMap, error) {
+	if len(b) < indexHeaderSize+hashSize {
+		return nil, errors.New("decodeIndex: index is truncated")
+	}
+	body, checksum := b[:len(b)-hashSize], b[len(b)-hashSize:]
+	if sum := sha1.Sum(body); !bytes.Equal(sum[:], checksum) {
+		return nil, errors.New("decodeIndex: index does not match its checksum")
+	}
+	version := binary.BigEndian.Uint32(body[4:])
+	if version != indexVersion {
+		return nil, fmt.Errorf("decodeIndex: unsupported index version %v", version)
+	}
+	count := int(binary.BigEndian.Uint32(body[8:]))
+	index := make(indexMap, count)
+	r := body[indexHeaderSize:]
+	for range count {
+		if len(r) < indexEntryHeaderSize {
+			return nil, errors.New("decodeIndex: index is truncated")
+		}
+		var metadata indexMetadata
+		for op, code := range indexOpCodes {
+			if code == r[0] {
+				metadata.Op = op
+			}
+		}
+		if metadata.Op == "" {
+			return nil, fmt.Errorf("decodeIndex: unknown operation code %v", r[0])
+		}
+		metadata.StagedOnly = r[1]&indexFlagStagedOnly != 0
+		metadata.ModTime = int64(binary.BigEndian.Uint64(r[2:]))
+		metadata.FileSize = int64(binary.BigEndian.Uint64(r[10:]))
+		hashLen, fileLen := int(r[18]), int(binary.BigEndian.Uint16(r[19:]))
+		r = r[indexEntryHeaderSize:]
+		if len(r) < hashLen+fileLen {
+			return nil, errors.New("decodeIndex: index is truncated")
+		}
+		metadata.Hash = string(r[:hashLen])
+		index[string(r[hashLen:hashLen+fileLen])] = metadata
+		r = r[hashLen+fileLen:]
+	}
+	if len(r) != 0 {
+		return nil, errors.New("decodeIndex: index has trailing data")
+	}
+	return index, nil
+}
+
 // Clear the index file.
 func newIndex() error {
 	if err := writeIndex(make(indexMap)); err != nil {
@@ -46,3 +190,105 @@ func newIndex() error {
 	}
 	return nil
 }
+
+// discardIndex clears the index without committing it, deleting the blobs that only it
+// referred to: those written by staging that neither the given commits nor an autosave
+// snapshot track.
+func discardIndex(keep ...commit) error {
+	unlock, err := lockFile(indexFile, "discard index")
+	if err != nil {
+		return fmt.Errorf("discardIndex: %w", err)
+	}
+	defer unlock()
+	index, err := readIndex()
+	if err != nil {
+		return fmt.Errorf("discardIndex: %w", err)
+	}
+	if err := newIndex(); err != nil {
+		return fmt.Errorf("discardIndex: %w", err)
+	}
+	var orphans []indexMetadata
+	for _, file := range sortedKeys(index) {
+		if metadata := index[file]; metadata.StagedOnly && metadata.Op != indexRemove && metadata.Op != indexSubmodule {
+			orphans = append(orphans, metadata)
+		}
+	}
+	if len(orphans) == 0 {
+		return nil
+	}
+	// snapshots may have been taken of the staged files since they were staged
+	autosaves, err := getAutosaves()
+	if err != nil {
+		return fmt.Errorf("discardIndex: %w", err)
+	}
+	for _, hash := range autosaves {
+		snapshot, err := getCommit(hash)
+		if err != nil {
+			return fmt.Errorf("discardIndex: %w", err)
+		}
+		keep = append(keep, snapshot)
+	}
+	// blobs that entries did not write were there before staging
+	referenced := make(map[string]bool)
+	for _, metadata := range index {
+		if !metadata.StagedOnly {
+			referenced[metadata.Hash] = true
+		}
+	}
+	for _, c := range keep {
+		for _, blobHash := range c.FileToBlob {
+			referenced[blobHash] = true
+		}
+	}
+	for _, metadata := range orphans {
+		if referenced[metadata.Hash] {
+			continue
+		}
+		referenced[metadata.Hash] = true
+		if err := removeStagedObject(metadata, nil); err != nil {
+			return fmt.Errorf("discardIndex: %w", err)
+		}
+	}
+	return nil
+}
+
+// removeStagedObject deletes the blob staged by an index entry, if staging wrote it and no
+// entry of index, the index without that entry, has the same blob. Entries staging identical
+// contents at once may all report writing the blob, so the other entries are checked here.
+func removeStagedObject(metadata indexMetadata, index indexMap) error {
+	if metadata.Op == indexRemove || metadata.Op == indexSubmodule || !metadata.StagedOnly {
+		return nil
+	}
+	for _, other := range index {
+		if other.Hash == metadata.Hash {
+			return nil
+		}
+	}
+	if err := removeObject(metadata.Hash); err != nil {
+		return fmt.Errorf("removeStagedObject: %w", err)
+	}
+	return nil
+}
+
+// markConflicted marks a staged file as containing merge conflict markers.
+func markConflicted(file string) error {
+	unlock, err := lockFile(indexFile, "merge")
+	if err != nil {
+		return fmt.Errorf("markConflicted: %w", err)
+	}
+	defer unlock()
+	index, err := readIndex()
+	if err != nil {
+		return fmt.Errorf("markConflicted: %w", err)
+	}
+	metadata, ok := index[file]
+	if !ok || metadata.Op == indexRemove {
+		return fmt.Errorf("markConflicted: file '%v' is not staged", file)
+	}
+	metadata.Op = indexConflict
+	index[file] = metadata
+	if err := writeIndex(index); err != nil {
+		return fmt.Errorf("markConflicted: %w", err)
+	}
+	return nil
+}
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
 	"reflect"
 	"testing"
 	"time"
@@ -9,8 +12,8 @@ import (
 func TestIndex(t *testing.T) {
 	setupTestRepo(t)
 	var expectedIndex indexMap = make(indexMap)
-	expectedIndex["foo"] = indexMetadata{"123", time.Now().UTC().Unix(), 123}
-	expectedIndex["bar"] = indexMetadata{"456", time.Now().UTC().Unix(), 456}
+	expectedIndex["foo"] = indexMetadata{indexAdd, "123", time.Now().UTC().Unix(), 123, false}
+	expectedIndex["bar"] = indexMetadata{indexAdd, "456", time.Now().UTC().Unix(), 456, true}
 
 	if err := writeIndex(expectedIndex); err != nil {
 		t.Fatal(err)
@@ -25,3 +28,142 @@ func TestIndex(t *testing.T) {
 		t.Fatalf("Index written and read incorrectly: want %v, got %v", expectedIndex, actualIndex)
 	}
 }
+
+func TestIndexMigration(t *testing.T) {
+	setupTestRepo(t)
+	legacyIndex := `{"foo":{"Hash":"DELETED","ModTime":1,"FileSize":0},"bar":{"Hash":"456","ModTime":1,"FileSize":456}}`
+	if err := writeContents(indexFile, []string{legacyIndex}); err != nil {
+		t.Fatal(err)
+	}
+	index, err := readIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedIndex := indexMap{
+		"foo": {indexRemove, "", 1, 0, false},
+		"bar": {indexAdd, "456", 1, 456, false},
+	}
+	if !reflect.DeepEqual(expectedIndex, index) {
+		t.Fatalf("Legacy index migrated incorrectly: want %v, got %v", expectedIndex, index)
+	}
+	// the next write replaces the legacy index with a binary one
+	if err := writeIndex(index); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := readFile(indexFile); err != nil || !bytes.HasPrefix(b, []byte(indexMagic)) {
+		t.Fatalf("Legacy index was not rewritten in the binary format: %q, %v", b, err)
+	}
+	if index, err := readIndex(); err != nil || !reflect.DeepEqual(expectedIndex, index) {
+		t.Fatalf("Rewritten index read incorrectly: want %v, got %v, %v", expectedIndex, index, err)
+	}
+}
+
+func TestBinaryIndex(t *testing.T) {
+	setupTestRepo(t)
+	expectedIndex := indexMap{
+		"wug.txt":        {indexAdd, initialCommitHash, 1, 13, true},
+		"notwug.txt":     {indexRemove, "", 2, 0, false},
+		"conflicted.txt": {indexConflict, initialCommitHash, 3, 42, true},
+		"sub":            {indexSubmodule, initialCommitHash, 4, 0, false},
+	}
+	if err := writeIndex(expectedIndex); err != nil {
+		t.Fatal(err)
+	}
+	b, err := readFile(indexFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(b, []byte(indexMagic)) {
+		t.Fatalf("Index should be written in the binary format: %q", b)
+	}
+	if index, err := readIndex(); err != nil || !reflect.DeepEqual(expectedIndex, index) {
+		t.Fatalf("Index written and read incorrectly: want %v, got %v, %v", expectedIndex, index, err)
+	}
+
+	for name, corrupt := range map[string][]byte{
+		"truncated": b[:len(b)-1],
+		"flipped":   append(bytes.Clone(b[:indexHeaderSize]), append([]byte{b[indexHeaderSize] ^ 1}, b[indexHeaderSize+1:]...)...),
+	} {
+		if err := writeFile(indexFile, corrupt, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := readIndex(); err == nil {
+			t.Errorf("Reading a %v index should fail", name)
+		}
+	}
+}
+
+func TestUnsupportedIndexVersion(t *testing.T) {
+	setupTestRepo(t)
+	index := indexMap{"wug.txt": {indexAdd, initialCommitHash, 1, 13, false}}
+	b, err := encodeIndex(index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// only the current binary version is read, with a valid checksum
+	body := b[:len(b)-hashSize]
+	binary.BigEndian.PutUint32(body[4:], indexVersion+1)
+	sum := sha1.Sum(body)
+	if err := writeFile(indexFile, append(body, sum[:]...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readIndex(); err == nil {
+		t.Fatal("Reading an index of an unsupported version should fail")
+	}
+}
+
+func TestDiscardIndex(t *testing.T) {
+	setupTestRepo(t)
+	commitInRepo(t, ".", "wug.txt", "This is a wug")
+	if err := addBranch("other"); err != nil {
+		t.Fatal(err)
+	}
+	for file, contents := range map[string]string{"new.txt": "This is a new wug", "notwug.txt": "This is a wug"} {
+		if err := writeContents(file, []string{contents}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := stageFiles([]string{"new.txt", "notwug.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	index, err := readIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !index["new.txt"].StagedOnly || index["notwug.txt"].StagedOnly {
+		t.Fatalf("Only blobs written by staging should be staged only: %+v", index)
+	}
+
+	if err := checkoutBranch("other"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := hasObject(index["new.txt"].Hash); err != nil || ok {
+		t.Fatalf("Blob only the discarded index referred to was kept: %v", err)
+	}
+	if ok, err := hasObject(index["notwug.txt"].Hash); err != nil || !ok {
+		t.Fatalf("Blob a commit tracks was deleted: %v", err)
+	}
+}
+
+func TestDiscardIndexKeepsAutosaves(t *testing.T) {
+	setupTestRepo(t)
+	if err := writeContents("wug.txt", []string{"This is a wug"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stageFile("wug.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := autosave(); err != nil {
+		t.Fatal(err)
+	}
+	index, err := readIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := resetFile("HEAD"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := hasObject(index["wug.txt"].Hash); err != nil || !ok {
+		t.Fatalf("Blob an autosave snapshot tracks was deleted: %v", err)
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"path/filepath"
+)
+
+// Operations that check out another commit, such as checkout, reset, and fast-forward merges,
+// record what they are about to change in the journal before touching the working directory,
+// and remove it once HEAD has moved. A journal left behind by an interrupted operation is
+// replayed by gitlet recover, which finishes the operation, or rolls it back with --abort.
+// Merges that combine two commits cannot know the commit they will create in advance, so an
+// interrupted merge can only be rolled back.
+var journalFile = filepath.Join(gitletDir, "JOURNAL")
+
+// journal records the state of the repository before and after an operation.
+type journal struct {
+	Operation    string // Name of the operation, such as "checkout".
+	OldHead      string // Contents of HEAD before the operation.
+	NewHead      string // Contents of HEAD after the operation.
+	Branch       string // Branch file moved by the operation, or empty if none is moved.
+	OldCommit    string // Commit checked out before the operation.
+	NewCommit    string // Commit checked out after the operation, or empty for a merge.
+	MergedCommit string // Commit being merged into OldCommit by a merge, or empty if none.
+	OldIndex     []byte // Index file before the operation.
+	OldConflicts []byte // Merge conflicts file before the operation, or nil if there was none.
+}
+
+// readJournal returns the journal of an interrupted operation, or nil if there is none.
+func readJournal() (*journal, error) {
+	b, err := readFile(journalFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("readJournal: %w", err)
+	}
+	j, err := deserialize[journal](b)
+	if err != nil {
+		return nil, fmt.Errorf("readJournal: %w", err)
+	}
+	return &j, nil
+}
+
+// beginJournal records that an operation is about to check out a commit and point HEAD at
+// newHead, moving a branch file to the commit if branch is not empty.
+// Exits if an earlier operation was interrupted and has not been recovered.
+func beginJournal(operation string, newHead string, branch string, newCommit string) error {
+	j := journal{Operation: operation, NewHead: newHead, Branch: branch, NewCommit: newCommit}
+	if err := writeJournal(j); err != nil {
+		return fmt.Errorf("beginJournal: %w", err)
+	}
+	return nil
+}
+
+// beginMergeJournal records that a merge is about to combine a commit with the current
+// commit, changing the working directory and staging area and then committing the result
+// to the current branch, or HEAD if it is detached.
+// Exits if an earlier operation was interrupted and has not been recovered.
+func beginMergeJournal(mergedCommit string) error {
+	head, err := readRef(headFile)
+	if err != nil {
+		return fmt.Errorf("beginMergeJournal: %w", err)
+	}
+	j := journal{Operation: "merge", NewHead: head, MergedCommit: mergedCommit}
+	if !isHash(head) {
+		j.Branch = head
+	}
+	if err := writeJournal(j); err != nil {
+		return fmt.Errorf("beginMergeJournal: %w", err)
+	}
+	return nil
+}
+
+// writeJournal completes a journal with the current state of the repository and writes it.
+// Exits if an earlier operation was interrupted and has not been recovered.
+func writeJournal(j journal) error {
+	if j, err := readJournal(); err != nil {
+		return fmt.Errorf("writeJournal: %w", err)
+	} else if j != nil {
+		exitf("An interrupted %v was not finished; run 'gitlet recover' first.", j.Operation)
+	}
+	var err error
+	if j.OldHead, err = readRef(headFile); err != nil {
+		return fmt.Errorf("writeJournal: %w", err)
+	}
+	if j.OldCommit, err = getHeadCommitHash(); err != nil {
+		return fmt.Errorf("writeJournal: %w", err)
+	}
+	if j.OldIndex, err = readFile(indexFile); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("writeJournal: %w", err)
+	}
+	if j.OldConflicts, err = readFile(mergeConflictsFile); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("writeJournal: %w", err)
+	}
+	b, err := serialize(j)
+	if err != nil {
+		return fmt.Errorf("writeJournal: %w", err)
+	}
+	if err := writeFileAtomic(journalFile, b); err != nil {
+		return fmt.Errorf("writeJournal: %w", err)
+	}
+	return nil
+}
+
+// beginHeadJournal records that an operation is about to check out a commit and move the
+// current branch to it, or HEAD if it is detached.
+func beginHeadJournal(operation string, newCommit string) error {
+	head, err := readRef(headFile)
+	if err != nil {
+		return fmt.Errorf("beginHeadJournal: %w", err)
+	}
+	if isHash(head) {
+		err = beginJournal(operation, newCommit, "", newCommit)
+	} else {
+		err = beginJournal(operation, head, head, newCommit)
+	}
+	if err != nil {
+		return fmt.Errorf("beginHeadJournal: %w", err)
+	}
+	return nil
+}
+
+// endJournal records that the journaled operation has finished.
+func endJournal() error {
+	if err := repoFS.Remove(journalFile); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("endJournal: %w", err)
+	}
+	return nil
+}
+
+// recoverOperation finishes the operation recorded in the journal, or rolls it back to the
+// state before it started if abort. Files tracked by the commit left behind are replaced by
+// those of the commit recovered to, and the refs are moved to where the journal expects them.
+func recoverOperation(abort bool) error {
+	unlock, err := lockRepo("recover")
+	if err != nil {
+		return fmt.Errorf("recoverOperation: %w", err)
+	}
+	defer unlock()
+	j, err := readJournal()
+	if err != nil {
+		return fmt.Errorf("recoverOperation: %w", err)
+	}
+	if j == nil {
+		log.Println("No interrupted operation to recover.")
+		return nil
+	}
+	if j.MergedCommit != "" && !abort {
+		exit("An interrupted merge cannot be finished; run 'gitlet recover --abort' and merge again.")
+	}
+	targetHash, otherHash, head := j.NewCommit, j.OldCommit, j.NewHead
+	if abort {
+		targetHash, otherHash, head = j.OldCommit, j.NewCommit, j.OldHead
+	}
+	if j.MergedCommit != "" {
+		// the merge wrote files from the merged commit over those of the current one
+		otherHash = j.MergedCommit
+	}
+	targetCommit, err := getCommit(targetHash)
+	if err != nil {
+		return fmt.Errorf("recoverOperation: %w", err)
+	}
+	otherCommit, err := getCommit(otherHash)
+	if err != nil {
+		return fmt.Errorf("recoverOperation: %w", err)
+	}
+
+	// the operation may have stopped partway, so every file either side tracks is restored,
+	// removing files first since either side's may be in place of the other's directories
+	var removed []string
+	for file := range otherCommit.FileToBlob {
+		if _, ok := targetCommit.FileToBlob[file]; !ok {
+			removed = append(removed, file)
+		}
+	}
+	if err := removeFiles(removed); err != nil {
+		return fmt.Errorf("recoverOperation: %w", err)
+	}
+	if err := materializeFiles(targetCommit.FileToBlob); err != nil {
+		return fmt.Errorf("recoverOperation: %w", err)
+	}
+	if err := updateSubmodules(targetCommit.Submodules); err != nil {
+		return fmt.Errorf("recoverOperation: %w", err)
+	}
+
+	message := "recover: finishing " + j.Operation
+	if abort {
+		message = "recover: rolling back " + j.Operation
+	}
+	currentHash, err := getHeadCommitHash()
+	if err != nil {
+		return fmt.Errorf("recoverOperation: %w", err)
+	}
+	if j.Branch != "" {
+		oldHash, err := readRef(j.Branch)
+		if err != nil {
+			return fmt.Errorf("recoverOperation: %w", err)
+		}
+		if err := writeRef(j.Branch, targetHash, message); err != nil {
+			return fmt.Errorf("recoverOperation: %w", err)
+		}
+		if oldHash != targetHash {
+			if err := logRefUpdate(filepath.Base(j.Branch), oldHash, targetHash, message); err != nil {
+				return fmt.Errorf("recoverOperation: %w", err)
+			}
+		}
+	}
+	if err := writeRef(headFile, head, message); err != nil {
+		return fmt.Errorf("recoverOperation: %w", err)
+	}
+	if currentHash != targetHash {
+		if err := logRefUpdate("HEAD", currentHash, targetHash, message); err != nil {
+			return fmt.Errorf("recoverOperation: %w", err)
+		}
+	}
+
+	if abort {
+		if err := restoreJournaledState(j); err != nil {
+			return fmt.Errorf("recoverOperation: %w", err)
+		}
+	} else {
+		if err := discardIndex(targetCommit, otherCommit); err != nil {
+			return fmt.Errorf("recoverOperation: %w", err)
+		}
+		if err := clearMergeState(); err != nil {
+			return fmt.Errorf("recoverOperation: %w", err)
+		}
+	}
+	if err := endJournal(); err != nil {
+		return fmt.Errorf("recoverOperation: %w", err)
+	}
+	if abort {
+		log.Printf("Rolled back the interrupted %v.\n", j.Operation)
+	} else {
+		log.Printf("Finished the interrupted %v.\n", j.Operation)
+	}
+	return nil
+}
+
+// restoreJournaledState restores the staging area and merge conflicts recorded in a journal
+// from before its operation.
+func restoreJournaledState(j *journal) error {
+	unlock, err := lockFile(indexFile, "recover")
+	if err != nil {
+		return fmt.Errorf("restoreJournaledState: %w", err)
+	}
+	defer unlock()
+	if j.OldIndex == nil {
+		err = newIndex()
+	} else {
+		err = writeFileAtomic(indexFile, j.OldIndex)
+	}
+	if err != nil {
+		return fmt.Errorf("restoreJournaledState: %w", err)
+	}
+	if j.OldConflicts == nil {
+		err = clearMergeState()
+	} else {
+		err = writeFileAtomic(mergeConflictsFile, j.OldConflicts)
+	}
+	if err != nil {
+		return fmt.Errorf("restoreJournaledState: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// setupJournalRepo creates a repository where main has one more commit than branch other,
+// adding notwug.txt. Returns the head commits of other and main.
+func setupJournalRepo(t *testing.T) (string, string) {
+	t.Helper()
+	setupTestRepo(t)
+	otherHash := commitInRepo(t, ".", "wug.txt", "This is a wug")
+	if err := addBranch("other"); err != nil {
+		t.Fatal(err)
+	}
+	mainHash := commitInRepo(t, ".", "notwug.txt", "This is not a wug")
+	return otherHash, mainHash
+}
+
+func TestRecoverCheckout(t *testing.T) {
+	otherHash, _ := setupJournalRepo(t)
+	otherFile := filepath.Join(branchesDir, "other")
+	// interrupted after deleting the files but before moving HEAD
+	if err := beginJournal("checkout", otherFile, "", otherHash); err != nil {
+		t.Fatal(err)
+	}
+	if err := repoFS.Remove("wug.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := recoverOperation(false); err != nil {
+		t.Fatal(err)
+	}
+	if head, err := readContentsAsString(headFile); err != nil || head != otherFile {
+		t.Fatalf("HEAD should point at the checked out branch: %v, %v", head, err)
+	}
+	if contents, err := readContentsAsString("wug.txt"); err != nil || contents != "This is a wug" {
+		t.Fatalf("Checked out file was not restored: %q, %v", contents, err)
+	}
+	if _, err := repoFS.Stat("notwug.txt"); !os.IsNotExist(err) {
+		t.Fatalf("File untracked by the checked out branch should be deleted: %v", err)
+	}
+	if j, err := readJournal(); err != nil || j != nil {
+		t.Fatalf("Journal should be removed after recovering: %+v, %v", j, err)
+	}
+}
+
+func TestRecoverAbortReset(t *testing.T) {
+	otherHash, mainHash := setupJournalRepo(t)
+	if err := writeContents("staged.txt", []string{"This is a staged wug"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stageFile("staged.txt"); err != nil {
+		t.Fatal(err)
+	}
+	// interrupted after checking out the files and clearing the staging area
+	if err := beginHeadJournal("reset", otherHash); err != nil {
+		t.Fatal(err)
+	}
+	if err := restrictedDelete("notwug.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := setHeadCommit(otherHash, "reset: moving to other"); err != nil {
+		t.Fatal(err)
+	}
+	if err := newIndex(); err != nil {
+		t.Fatal(err)
+	}
+	if err := recoverOperation(true); err != nil {
+		t.Fatal(err)
+	}
+	if hash, err := getHeadCommitHash(); err != nil || hash != mainHash {
+		t.Fatalf("Branch should be moved back: want %v, got %v, %v", mainHash, hash, err)
+	}
+	if branch, err := getCurrentBranch(); err != nil || branch != "main" {
+		t.Fatalf("HEAD should still point at main: %v, %v", branch, err)
+	}
+	if contents, err := readContentsAsString("notwug.txt"); err != nil || contents != "This is not a wug" {
+		t.Fatalf("Deleted file was not restored: %q, %v", contents, err)
+	}
+	if index, err := readIndex(); err != nil || index["staged.txt"].Op != indexAdd {
+		t.Fatalf("Staging area was not restored: %+v, %v", index, err)
+	}
+	if j, err := readJournal(); err != nil || j != nil {
+		t.Fatalf("Journal should be removed after rolling back: %+v, %v", j, err)
+	}
+}
+
+func TestCheckoutEndsJournal(t *testing.T) {
+	setupJournalRepo(t)
+	if err := checkoutBranch("other"); err != nil {
+		t.Fatal(err)
+	}
+	if j, err := readJournal(); err != nil || j != nil {
+		t.Fatalf("Journal should be removed after checking out: %+v, %v", j, err)
+	}
+}
+
+// setupDirectoryFileRepo creates a repository where main tracks src/a/x.go and branch other
+// tracks src/a as a file instead, with other checked out. Returns the head commits of other
+// and main.
+func setupDirectoryFileRepo(t *testing.T) (string, string) {
+	t.Helper()
+	setupTestRepo(t)
+	if err := repoFS.MkdirAll(filepath.Join("src", "a"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	mainHash := commitInRepo(t, ".", filepath.Join("src", "a", "x.go"), "package a")
+	if err := addBranch("other"); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkoutBranch("other"); err != nil {
+		t.Fatal(err)
+	}
+	if err := unstageFile(filepath.Join("src", "a", "x.go")); err != nil {
+		t.Fatal(err)
+	}
+	if err := repoFS.Remove(filepath.Join("src", "a")); err != nil {
+		t.Fatal(err)
+	}
+	otherHash := commitInRepo(t, ".", filepath.Join("src", "a"), "not a directory")
+	return otherHash, mainHash
+}
+
+func TestCheckoutDirectoryFile(t *testing.T) {
+	setupDirectoryFileRepo(t)
+	if err := checkoutBranch("main"); err != nil {
+		t.Fatal(err)
+	}
+	if contents, err := readContentsAsString(filepath.Join("src", "a", "x.go")); err != nil || contents != "package a" {
+		t.Fatalf("File should replace the other branch's file: %q, %v", contents, err)
+	}
+	if err := checkoutBranch("other"); err != nil {
+		t.Fatal(err)
+	}
+	if contents, err := readContentsAsString(filepath.Join("src", "a")); err != nil || contents != "not a directory" {
+		t.Fatalf("File should replace the main branch's directory: %q, %v", contents, err)
+	}
+	if j, err := readJournal(); err != nil || j != nil {
+		t.Fatalf("Journal should be removed after checking out: %+v, %v", j, err)
+	}
+}
+
+func TestRecoverDirectoryFile(t *testing.T) {
+	otherHash, mainHash := setupDirectoryFileRepo(t)
+	mainFile := filepath.Join(branchesDir, "main")
+	otherFile := filepath.Join(branchesDir, "other")
+	// interrupted before changing the working directory, then rolled back
+	if err := beginJournal("checkout", mainFile, "", mainHash); err != nil {
+		t.Fatal(err)
+	}
+	if err := recoverOperation(true); err != nil {
+		t.Fatal(err)
+	}
+	if contents, err := readContentsAsString(filepath.Join("src", "a")); err != nil || contents != "not a directory" {
+		t.Fatalf("Rolling back should keep the file: %q, %v", contents, err)
+	}
+
+	// interrupted before changing the working directory, then finished
+	if err := beginJournal("checkout", mainFile, "", mainHash); err != nil {
+		t.Fatal(err)
+	}
+	if err := recoverOperation(false); err != nil {
+		t.Fatal(err)
+	}
+	if contents, err := readContentsAsString(filepath.Join("src", "a", "x.go")); err != nil || contents != "package a" {
+		t.Fatalf("Finishing should replace the file with the directory: %q, %v", contents, err)
+	}
+
+	// interrupted after writing the files of other, then rolled back
+	if err := beginJournal("checkout", otherFile, "", otherHash); err != nil {
+		t.Fatal(err)
+	}
+	if err := repoFS.RemoveAll(filepath.Join("src", "a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeContents(filepath.Join("src", "a"), []string{"not a directory"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := recoverOperation(true); err != nil {
+		t.Fatal(err)
+	}
+	if contents, err := readContentsAsString(filepath.Join("src", "a", "x.go")); err != nil || contents != "package a" {
+		t.Fatalf("Rolling back should replace the file with the directory: %q, %v", contents, err)
+	}
+	if branch, err := getCurrentBranch(); err != nil || branch != "main" {
+		t.Fatalf("HEAD should point at main: %v, %v", branch, err)
+	}
+	if j, err := readJournal(); err != nil || j != nil {
+		t.Fatalf("Journal should be removed after recovering: %+v, %v", j, err)
+	}
+}
+
+// setupMergeJournalRepo creates a repository where branch other changes wug.txt and adds
+// other.txt after main added notwug.txt, so merging other creates a merge commit.
+// Returns the head commits of other and main.
+func setupMergeJournalRepo(t *testing.T) (string, string) {
+	t.Helper()
+	_, mainHash := setupJournalRepo(t)
+	if err := checkoutBranch("other"); err != nil {
+		t.Fatal(err)
+	}
+	commitInRepo(t, ".", "wug.txt", "This is another wug")
+	otherHash := commitInRepo(t, ".", "other.txt", "This is an other wug")
+	if err := checkoutBranch("main"); err != nil {
+		t.Fatal(err)
+	}
+	return otherHash, mainHash
+}
+
+// checkMergeRolledBack checks that the working directory, staging area, and refs are as
+// they were on main before merging other.
+func checkMergeRolledBack(t *testing.T, mainHash string) {
+	t.Helper()
+	if hash, err := getHeadCommitHash(); err != nil || hash != mainHash {
+		t.Fatalf("Branch should be moved back: want %v, got %v, %v", mainHash, hash, err)
+	}
+	if branch, err := getCurrentBranch(); err != nil || branch != "main" {
+		t.Fatalf("HEAD should still point at main: %v, %v", branch, err)
+	}
+	if contents, err := readContentsAsString("wug.txt"); err != nil || contents != "This is a wug" {
+		t.Fatalf("Merged file was not restored: %q, %v", contents, err)
+	}
+	if _, err := repoFS.Stat("other.txt"); !os.IsNotExist(err) {
+		t.Fatalf("File added by the merge should be deleted: %v", err)
+	}
+	if index, err := readIndex(); err != nil || len(index) != 0 {
+		t.Fatalf("Staging area was not restored: %+v, %v", index, err)
+	}
+	if j, err := readJournal(); err != nil || j != nil {
+		t.Fatalf("Journal should be removed after rolling back: %+v, %v", j, err)
+	}
+}
+
+func TestRecoverAbortMerge(t *testing.T) {
+	otherHash, mainHash := setupMergeJournalRepo(t)
+	// the merge fails to create its commit after merging the files
+	t.Setenv("USER", "")
+	if err := mergeBranch("other", mergeOptions{}); !errors.Is(err, errUnknownIdentity) {
+		t.Fatalf("Merge should fail without an identity, got %v", err)
+	}
+	j, err := readJournal()
+	if err != nil || j == nil {
+		t.Fatalf("Interrupted merge should leave a journal: %v", err)
+	}
+	if j.Operation != "merge" || j.MergedCommit != otherHash || j.OldCommit != mainHash {
+		t.Fatalf("Incorrect merge journal: %+v", j)
+	}
+	if contents, err := readContentsAsString("other.txt"); err != nil || contents != "This is an other wug" {
+		t.Fatalf("Merge should have written the merged files before failing: %q, %v", contents, err)
+	}
+	if err := recoverOperation(true); err != nil {
+		t.Fatal(err)
+	}
+	checkMergeRolledBack(t, mainHash)
+}
+
+func TestRecoverAbortCommittedMerge(t *testing.T) {
+	otherHash, mainHash := setupMergeJournalRepo(t)
+	// interrupted after committing the merge but before finishing the journal
+	if err := beginMergeJournal(otherHash); err != nil {
+		t.Fatal(err)
+	}
+	splitPoint, err := getCommit(mainHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if splitPoint, err = getCommit(splitPoint.ParentUIDs[0]); err != nil {
+		t.Fatal(err)
+	}
+	current, err := getHeadCommit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := getCommit(otherHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mergeCommitFiles(splitPoint, current, other, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := newMergeCommit("other", otherHash, "main", mainHash); err != nil {
+		t.Fatal(err)
+	}
+	if hash, err := getHeadCommitHash(); err != nil || hash == mainHash {
+		t.Fatalf("Merge commit should have moved main: %v, %v", hash, err)
+	}
+	if err := recoverOperation(true); err != nil {
+		t.Fatal(err)
+	}
+	checkMergeRolledBack(t, mainHash)
+}
+
+func TestMergeEndsJournal(t *testing.T) {
+	setupMergeJournalRepo(t)
+	if err := mergeBranch("other", mergeOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if j, err := readJournal(); err != nil || j != nil {
+		t.Fatalf("Journal should be removed after merging: %+v, %v", j, err)
+	}
+}
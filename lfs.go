@@ -0,0 +1,402 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Files of at least lfs.threshold bytes are committed as small lfs pointer blobs, while their
+// contents are kept in the media store, named by their SHA-256 hash. Media missing from the
+// store are downloaded from the remote named by lfs.remote when they are first read, so
+// fetching and cloning never copy media that are not checked out. Pointer blobs read as
+// "version <version>\noid sha256:<hash>\nsize <bytes>\n".
+const lfsPointerVersion string = "gitlet-lfs/1"
+
+// lfsPointer names the contents of a large file in the media store.
+type lfsPointer struct {
+	OID  string // Hex-encoded SHA-256 hash of the contents.
+	Size int64  // Size of the contents in bytes.
+}
+
+// String formats a pointer as the contents of its blob.
+func (p lfsPointer) String() string {
+	return fmt.Sprintf("version %v\noid sha256:%v\nsize %d\n", lfsPointerVersion, p.OID, p.Size)
+}
+
+// parseLFSPointer parses the contents of an lfs pointer blob.
+func parseLFSPointer(b []byte) (lfsPointer, error) {
+	var p lfsPointer
+	lines := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+	if len(lines) != 3 || lines[0] != "version "+lfsPointerVersion {
+		return p, fmt.Errorf("parseLFSPointer: malformed pointer %q", b)
+	}
+	oid, ok := strings.CutPrefix(lines[1], "oid sha256:")
+	if !ok || !isMediaOID(oid) {
+		return p, fmt.Errorf("parseLFSPointer: malformed oid %q", lines[1])
+	}
+	sizeField, ok := strings.CutPrefix(lines[2], "size ")
+	size, err := strconv.ParseInt(sizeField, 10, 64)
+	if !ok || err != nil || size < 0 {
+		return p, fmt.Errorf("parseLFSPointer: malformed size %q", lines[2])
+	}
+	return lfsPointer{oid, size}, nil
+}
+
+// isMediaOID reports whether s is a hex-encoded SHA-256 hash, which names contents in the
+// media store.
+func isMediaOID(s string) bool {
+	_, err := hex.DecodeString(s)
+	return err == nil && len(s) == 2*sha256.Size
+}
+
+// parseSize parses a size in bytes, with an optional k, m, or g suffix for KiB, MiB, or GiB.
+func parseSize(s string) (int64, error) {
+	unit := int64(1)
+	for i, suffix := range []string{"k", "m", "g"} {
+		if count, ok := strings.CutSuffix(strings.ToLower(s), suffix); ok {
+			s, unit = count, 1<<(10*(i+1))
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("parseSize: invalid size '%v'", s)
+	}
+	return n * unit, nil
+}
+
+// getLFSThreshold returns the size from which files are kept in the media store, or 0 if
+// lfs.threshold is not set and every file is stored as a file blob.
+func getLFSThreshold() (int64, error) {
+	value, ok, err := getConfig("lfs.threshold")
+	if err != nil {
+		return 0, fmt.Errorf("getLFSThreshold: %w", err)
+	}
+	if !ok || value == "" {
+		return 0, nil
+	}
+	threshold, err := parseSize(value)
+	if err != nil {
+		return 0, fmt.Errorf("getLFSThreshold: lfs.threshold: %w", err)
+	}
+	return threshold, nil
+}
+
+// storeFileContents returns the hash of the blob that stores the size bytes of file contents
+// read from r: an lfs pointer to the contents if they are at least lfs.threshold bytes, a
+// chunked blob listing their chunks if they are at least chunk.threshold bytes, or a file blob
+// of the contents otherwise. The contents are streamed, so files of any size are stored without
+// being read into memory. If write is true, the blob is also written, along with the contents
+// to the media store if it is an lfs pointer or to chunk objects if it is a chunked blob.
+// Returns whether the blob did not exist before.
+func storeFileContents(r io.Reader, size int64, write bool) (string, bool, error) {
+	threshold, err := getLFSThreshold()
+	if err != nil {
+		return "", false, fmt.Errorf("storeFileContents: %w", err)
+	}
+	var header string
+	var blobContents []byte
+	if threshold > 0 && size >= threshold {
+		pointer, err := streamMedia(r, size, write)
+		if err != nil {
+			return "", false, fmt.Errorf("storeFileContents: %w", err)
+		}
+		header, blobContents = "lfs", []byte(pointer.String())
+	} else if threshold, err = getChunkThreshold(); err != nil {
+		return "", false, fmt.Errorf("storeFileContents: %w", err)
+	} else if threshold > 0 && size >= threshold {
+		chunks, n, err := streamChunks(r, write)
+		if err != nil {
+			return "", false, fmt.Errorf("storeFileContents: %w", err)
+		}
+		if n != size {
+			return "", false, fmt.Errorf("storeFileContents: read %d bytes, want %d: %w", n, size, errFileChanged)
+		}
+		header, blobContents = "chunked", chunks
+	} else {
+		hash, created, err := streamBlob("file", r, size, write)
+		if err != nil {
+			return "", false, fmt.Errorf("storeFileContents: %w", err)
+		}
+		return hash, created, nil
+	}
+	if !write {
+		hash, err := getHash(blobPayload(header, blobContents))
+		if err != nil {
+			return "", false, fmt.Errorf("storeFileContents: %w", err)
+		}
+		return hash, false, nil
+	}
+	hash, created, err := createBlob(header, blobContents)
+	if err != nil {
+		return "", false, fmt.Errorf("storeFileContents: %w", err)
+	}
+	return hash, created, nil
+}
+
+// writeFileContentsBlob writes the blob that stores file contents held in memory, like
+// storeFileContents, and returns its hash.
+func writeFileContentsBlob(contents []byte) (string, error) {
+	hash, _, err := storeFileContents(bytes.NewReader(contents), int64(len(contents)), true)
+	if err != nil {
+		return "", fmt.Errorf("writeFileContentsBlob: %w", err)
+	}
+	return hash, nil
+}
+
+// mediaPath returns the path of the contents with a SHA-256 hash in the media store of the
+// repository whose .gitlet directory is repoGitletDir.
+func mediaPath(repoGitletDir string, oid string) string {
+	return filepath.Join(mediaDir(repoGitletDir), oid)
+}
+
+// mediaDir returns the media store directory of the repository whose .gitlet directory is
+// repoGitletDir.
+func mediaDir(repoGitletDir string) string {
+	return filepath.Join(repoGitletDir, "lfs", "objects")
+}
+
+// writeMedia adds file contents to the media store, unless it already has them.
+func writeMedia(contents []byte) error {
+	sum := sha256.Sum256(contents)
+	file := mediaPath(gitletDir, hex.EncodeToString(sum[:]))
+	if _, err := repoFS.Stat(file); err == nil {
+		return nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("writeMedia: %w", err)
+	}
+	if err := repoFS.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return fmt.Errorf("writeMedia: %w", err)
+	}
+	if err := writeFileAtomic(file, contents); err != nil {
+		return fmt.Errorf("writeMedia: %w", err)
+	}
+	return nil
+}
+
+// streamMedia returns the lfs pointer to the size bytes of file contents read from r, without
+// holding the contents in memory. If write is true, the contents are also added to the media
+// store through a temporary file, unless it already has them.
+func streamMedia(r io.Reader, size int64, write bool) (lfsPointer, error) {
+	h := sha256.New()
+	w := io.Writer(h)
+	var tmp file
+	if write {
+		dir := mediaDir(gitletDir)
+		if err := repoFS.MkdirAll(dir, 0755); err != nil {
+			return lfsPointer{}, fmt.Errorf("streamMedia: %w", err)
+		}
+		// the temporary file is hidden so it is never mistaken for media
+		f, err := repoFS.CreateTemp(dir, ".media.tmp*")
+		if err != nil {
+			return lfsPointer{}, fmt.Errorf("streamMedia: %w", err)
+		}
+		defer repoFS.Remove(f.Name())
+		defer f.Close()
+		w = io.MultiWriter(f, h)
+		tmp = f
+	}
+	n, err := io.Copy(w, r)
+	if err != nil {
+		return lfsPointer{}, fmt.Errorf("streamMedia: %w", err)
+	}
+	if n != size {
+		return lfsPointer{}, fmt.Errorf("streamMedia: read %d bytes, want %d: %w", n, size, errFileChanged)
+	}
+	pointer := lfsPointer{hex.EncodeToString(h.Sum(nil)), size}
+	if !write {
+		return pointer, nil
+	}
+	file := mediaPath(gitletDir, pointer.OID)
+	if _, err := repoFS.Stat(file); err == nil {
+		return pointer, nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return lfsPointer{}, fmt.Errorf("streamMedia: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return lfsPointer{}, fmt.Errorf("streamMedia: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return lfsPointer{}, fmt.Errorf("streamMedia: %w", err)
+	}
+	if err := repoFS.Chmod(tmp.Name(), 0644); err != nil {
+		return lfsPointer{}, fmt.Errorf("streamMedia: %w", err)
+	}
+	if err := repoFS.Rename(tmp.Name(), file); err != nil {
+		return lfsPointer{}, fmt.Errorf("streamMedia: %w", err)
+	}
+	if err := syncDir(filepath.Dir(file)); err != nil {
+		return lfsPointer{}, fmt.Errorf("streamMedia: %w", err)
+	}
+	return pointer, nil
+}
+
+// readMedia returns the contents an lfs pointer blob names.
+func readMedia(pointerContents []byte) ([]byte, error) {
+	pointer, err := parseLFSPointer(pointerContents)
+	if err != nil {
+		return nil, fmt.Errorf("readMedia: %w", err)
+	}
+	contents, err := readStoredMedia(pointer.OID)
+	if err != nil {
+		return nil, fmt.Errorf("readMedia: %w", err)
+	}
+	if int64(len(contents)) != pointer.Size {
+		return nil, fmt.Errorf("readMedia: media %v has %d bytes, pointer records %d", pointer.OID, len(contents), pointer.Size)
+	}
+	return contents, nil
+}
+
+// openMedia returns a reader of the media an lfs pointer blob refers to, streaming them from
+// the media store, or downloading them first if the store does not have them. The reader must
+// be closed.
+func openMedia(pointerContents []byte) (io.ReadCloser, error) {
+	pointer, err := parseLFSPointer(pointerContents)
+	if err != nil {
+		return nil, fmt.Errorf("openMedia: %w", err)
+	}
+	f, err := repoFS.Open(mediaPath(gitletDir, pointer.OID))
+	if errors.Is(err, fs.ErrNotExist) {
+		contents, err := readMedia(pointerContents)
+		if err != nil {
+			return nil, fmt.Errorf("openMedia: %w", err)
+		}
+		return io.NopCloser(bytes.NewReader(contents)), nil
+	} else if err != nil {
+		return nil, fmt.Errorf("openMedia: %w", err)
+	}
+	fileInfo, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("openMedia: %w", err)
+	}
+	if fileInfo.Size() != pointer.Size {
+		f.Close()
+		return nil, fmt.Errorf("openMedia: media %v has %d bytes, pointer records %d", pointer.OID, fileInfo.Size(), pointer.Size)
+	}
+	return f, nil
+}
+
+// readStoredMedia returns the contents with a SHA-256 hash from the media store, first
+// downloading them from the remote named by lfs.remote if the store does not have them.
+func readStoredMedia(oid string) ([]byte, error) {
+	contents, err := readFile(mediaPath(gitletDir, oid))
+	if err == nil {
+		return contents, nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("readStoredMedia: %w", err)
+	}
+	if contents, err = downloadMedia(oid); err != nil {
+		return nil, fmt.Errorf("readStoredMedia: %w", err)
+	}
+	if err := verifyMedia(oid, contents); err != nil {
+		return nil, fmt.Errorf("readStoredMedia: %w", err)
+	}
+	if err := writeMedia(contents); err != nil {
+		return nil, fmt.Errorf("readStoredMedia: %w", err)
+	}
+	return contents, nil
+}
+
+// verifyMedia returns an error if media contents do not match their SHA-256 hash.
+func verifyMedia(oid string, contents []byte) error {
+	if sum := sha256.Sum256(contents); hex.EncodeToString(sum[:]) != oid {
+		return fmt.Errorf("verifyMedia: media %v does not match its hash", oid)
+	}
+	return nil
+}
+
+// downloadMedia returns the contents with a SHA-256 hash from the media store of the remote
+// named by lfs.remote. Returns an error wrapping fs.ErrNotExist if the remote does not exist
+// or does not have them.
+func downloadMedia(oid string) ([]byte, error) {
+	remoteName, _, err := getConfig("lfs.remote")
+	if err != nil {
+		return nil, fmt.Errorf("downloadMedia: %w", err)
+	}
+	remotes, err := readRemoteIndex()
+	if err != nil {
+		return nil, fmt.Errorf("downloadMedia: %w", err)
+	}
+	remote, ok := remotes[remoteName]
+	if !ok {
+		return nil, fmt.Errorf("downloadMedia: media %v is not stored and there is no remote '%v': %w", oid, remoteName, fs.ErrNotExist)
+	}
+	if isServerURL(remote.URL) {
+		resp, err := requestServer(remote.URL, remoteRequest{Op: mediaFetchOp, Want: []string{oid}})
+		if err != nil {
+			return nil, fmt.Errorf("downloadMedia: %w", err)
+		}
+		contents, ok := resp.Media[oid]
+		if !ok {
+			return nil, fmt.Errorf("downloadMedia: remote '%v' does not have media %v: %w", remoteName, oid, fs.ErrNotExist)
+		}
+		return contents, nil
+	}
+	remoteGitletDir, err := findGitletDir(remote.URL)
+	if err != nil {
+		return nil, fmt.Errorf("downloadMedia: %w", err)
+	}
+	contents, err := readFile(mediaPath(remoteGitletDir, oid))
+	if err != nil {
+		return nil, fmt.Errorf("downloadMedia: %w", err)
+	}
+	return contents, nil
+}
+
+// getMediaOIDs returns the SHA-256 hashes of the media named by the lfs pointer blobs among
+// the given objects, sorted.
+func getMediaOIDs(hashes []string) ([]string, error) {
+	oids := make(map[string]bool)
+	for _, hash := range hashes {
+		obj, err := loadObject(hash)
+		if err != nil {
+			return nil, fmt.Errorf("getMediaOIDs: %w", err)
+		}
+		if obj.header != "lfs" {
+			continue
+		}
+		pointer, err := parseLFSPointer(obj.contents)
+		if err != nil {
+			return nil, fmt.Errorf("getMediaOIDs: %w", err)
+		}
+		oids[pointer.OID] = true
+	}
+	return sortedKeys(oids), nil
+}
+
+// collectMedia returns the media with the given SHA-256 hashes, by hash, downloading those
+// missing from the media store.
+func collectMedia(oids []string) (map[string][]byte, error) {
+	media := make(map[string][]byte, len(oids))
+	for _, oid := range oids {
+		contents, err := readStoredMedia(oid)
+		if err != nil {
+			return nil, fmt.Errorf("collectMedia: %w", err)
+		}
+		media[oid] = contents
+	}
+	return media, nil
+}
+
+// storeMedia adds media received from another repository to the media store.
+// Returns an error if some media do not match their hash.
+func storeMedia(media map[string][]byte) error {
+	for _, oid := range sortedKeys(media) {
+		if err := verifyMedia(oid, media[oid]); err != nil {
+			return fmt.Errorf("storeMedia: %w", err)
+		}
+		if err := writeMedia(media[oid]); err != nil {
+			return fmt.Errorf("storeMedia: %w", err)
+		}
+	}
+	return nil
+}
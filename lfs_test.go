@@ -0,0 +1,167 @@
+package main
+
+import (
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseLFSPointer(t *testing.T) {
+	pointer := lfsPointer{strings.Repeat("ab", 32), 1234}
+	if parsed, err := parseLFSPointer([]byte(pointer.String())); err != nil || parsed != pointer {
+		t.Fatalf("Incorrect parsed pointer: want %+v, got %+v, %v", pointer, parsed, err)
+	}
+	for _, s := range []string{
+		"",
+		"version gitlet-lfs/0\noid sha256:" + pointer.OID + "\nsize 1234\n",
+		"version gitlet-lfs/1\noid sha256:../../HEAD\nsize 1234\n",
+		"version gitlet-lfs/1\noid sha256:" + pointer.OID + "\nsize -1\n",
+	} {
+		if _, err := parseLFSPointer([]byte(s)); err == nil {
+			t.Errorf("Parsing %q should fail", s)
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	for s, expected := range map[string]int64{"0": 0, "100": 100, "2k": 2 << 10, "5M": 5 << 20, "1g": 1 << 30} {
+		if size, err := parseSize(s); err != nil || size != expected {
+			t.Errorf("Incorrect size of %q: want %v, got %v, %v", s, expected, size, err)
+		}
+	}
+	for _, s := range []string{"", "k", "-1", "1.5m", "1t"} {
+		if _, err := parseSize(s); err == nil {
+			t.Errorf("Parsing %q should fail", s)
+		}
+	}
+}
+
+// setupLFSRemoteRepo creates a repository in a new directory that stores files of at least
+// 10 bytes in its media store, with one large file committed. Returns the repository directory
+// and the hash of the large file's blob.
+func setupLFSRemoteRepo(t *testing.T) (string, string) {
+	t.Helper()
+	dir := testTempDir(t)
+	var blobHash string
+	if err := inRepository(dir, func() error {
+		if err := newRepository(); err != nil {
+			return err
+		}
+		if err := setConfig("lfs.threshold", "10"); err != nil {
+			return err
+		}
+		if err := writeContents("wug.txt", []string{"This is a large wug"}); err != nil {
+			return err
+		}
+		if err := stageFile("wug.txt"); err != nil {
+			return err
+		}
+		if err := newCommit("add large wug"); err != nil {
+			return err
+		}
+		headCommit, err := getHeadCommit()
+		blobHash = headCommit.FileToBlob["wug.txt"]
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return dir, blobHash
+}
+
+func TestLFSCommit(t *testing.T) {
+	setupTestFS(t)
+	dir, blobHash := setupLFSRemoteRepo(t)
+	enterTestDir(t, dir)
+	if header, err := parseBlobHeader(blobHash); err != nil || header != "lfs" {
+		t.Fatalf("Large file should be committed as an lfs pointer: %q, %v", header, err)
+	}
+	if header, contents, err := readBlob(blobHash); err != nil || header != "file" || string(contents) != "This is a large wug" {
+		t.Fatalf("Incorrect large file blob: %q, %q, %v", header, contents, err)
+	}
+	if err := verifyBlob(blobHash); err != nil {
+		t.Fatalf("Pointer blob should verify: %v", err)
+	}
+
+	if err := writeContents("small.txt", []string{"wug"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stageFile("small.txt"); err != nil {
+		t.Fatal(err)
+	}
+	index, err := readIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header, err := parseBlobHeader(index["small.txt"].Hash); err != nil || header != "file" {
+		t.Fatalf("Small file should be staged as a file blob: %q, %v", header, err)
+	}
+
+	if err := repoFS.Remove("wug.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkoutHeadCommit("wug.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if contents, err := readContentsAsString("wug.txt"); err != nil || contents != "This is a large wug" {
+		t.Fatalf("Large file was not checked out: %q, %v", contents, err)
+	}
+	if entries, err := getStatusEntries(); err != nil || len(entries) != 1 || entries[0].File != "small.txt" {
+		t.Fatalf("Checked out large file should be unmodified: %+v, %v", entries, err)
+	}
+}
+
+func TestLFSCloneDownloadsMedia(t *testing.T) {
+	setupTestFS(t)
+	remoteDir, _ := setupLFSRemoteRepo(t)
+	if err := cloneRemote(remoteDir, "clone"); err != nil {
+		t.Fatal(err)
+	}
+	if contents, err := readContentsAsString(filepath.Join("clone", "wug.txt")); err != nil || contents != "This is a large wug" {
+		t.Fatalf("Clone did not check out the large file: %q, %v", contents, err)
+	}
+	media, err := getFilenames(filepath.Join("clone", gitletDir, "lfs", "objects"))
+	if err != nil || len(media) != 1 {
+		t.Fatalf("Clone should store the downloaded media: %v, %v", media, err)
+	}
+	enterTestDir(t, "clone")
+	if entries, err := getStatusEntries(); err != nil || len(entries) != 0 {
+		t.Fatalf("Cloned large file should be unmodified: %+v, %v", entries, err)
+	}
+}
+
+func TestLFSPushHTTP(t *testing.T) {
+	setupTestFS(t)
+	remoteDir := setupRemoteRepo(t)
+	server := httptest.NewServer(newHTTPHandler(remoteDir, true))
+	t.Cleanup(server.Close)
+	if err := newRepository(); err != nil {
+		t.Fatal(err)
+	}
+	if err := addRemote("origin", server.URL); err != nil {
+		t.Fatal(err)
+	}
+	if err := pull("origin", "main"); err != nil {
+		t.Fatal(err)
+	}
+	if err := setConfig("lfs.threshold", "10"); err != nil {
+		t.Fatal(err)
+	}
+	commitInRepo(t, ".", "large.txt", "This is a large wug")
+	if err := push("origin", "main"); err != nil {
+		t.Fatal(err)
+	}
+	if err := inRepository(remoteDir, func() error {
+		headCommit, err := getHeadCommit()
+		if err != nil {
+			return err
+		}
+		_, contents, err := readBlob(headCommit.FileToBlob["large.txt"])
+		if err == nil && string(contents) != "This is a large wug" {
+			t.Errorf("Incorrect pushed large file: %q", contents)
+		}
+		return err
+	}); err != nil {
+		t.Fatalf("Pushed media are missing from the remote: %v", err)
+	}
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Locks held longer than this are considered abandoned even if their process still appears
+// to be running, since the PID may have been reused by an unrelated process.
+const staleRepoLockAge = time.Hour
+
+// Locks of processes that are no longer running are only broken once they are older than
+// this, in case the process runs somewhere its PID cannot be checked, such as another
+// container sharing the repository.
+const staleRepoLockMinAge = 10 * time.Second
+
+var repoLockFile = filepath.Join(gitletDir, "LOCK")
+
+// Files that are read, modified, and written back, such as the index and refs, are guarded by
+// a lock file named after them with this suffix, so concurrent processes cannot interleave
+// their updates.
+const lockFileSuffix string = ".lock"
+
+// repoLock is the contents of the repository lock file, and of the lock file of a file.
+type repoLock struct {
+	PID       int
+	Timestamp int64 // Unix time the lock was taken.
+	Operation string
+}
+
+// repoLockedError is returned when another process holds the repository lock or the lock of
+// a file.
+type repoLockedError struct {
+	File string // Path of the lock file.
+	Lock repoLock
+}
+
+func (e *repoLockedError) Error() string {
+	if e.Lock.PID <= 0 {
+		return fmt.Sprintf("%v is held by an unknown process since %v", e.File, time.Unix(e.Lock.Timestamp, 0).Format(time.DateTime))
+	}
+	return fmt.Sprintf(
+		"%v is held by %v (pid %v) since %v",
+		e.File, e.Lock.Operation, e.Lock.PID, time.Unix(e.Lock.Timestamp, 0).Format(time.DateTime),
+	)
+}
+
+// repoLockDepth counts the nested lockRepo calls of this process holding the lock, and
+// heldRepoLock is the absolute path of the lock file while it is held.
+var (
+	repoLockMu    sync.Mutex
+	repoLockDepth int
+	heldRepoLock  string
+)
+
+// lockRepo takes the repository-wide lock for a multi-step operation that mutates refs or
+// the working directory, and returns a function that releases it. Locking is re-entrant
+// within a process, so operations built from other locked operations can lock too.
+// A lock left behind by a process that is no longer running, or older than
+// staleRepoLockAge, is broken and taken over.
+// Returns a *repoLockedError if another process holds the lock.
+func lockRepo(operation string) (func(), error) {
+	repoLockMu.Lock()
+	defer repoLockMu.Unlock()
+	if repoLockDepth > 0 {
+		repoLockDepth++
+		return unlockRepo, nil
+	}
+	lock, err := repoFS.Abs(repoLockFile)
+	if err != nil {
+		return nil, fmt.Errorf("lockRepo: %w", err)
+	}
+	if err := createLockFile(lock, operation); err != nil {
+		return nil, fmt.Errorf("lockRepo: %w", err)
+	}
+	repoLockDepth = 1
+	heldRepoLock = lock
+	return unlockRepo, nil
+}
+
+// createLockFile creates a lock file recording this process and an operation, breaking a
+// stale lock file left behind by another process. The lock file is written under a fresh
+// temporary name and hard linked into place, which fails if the lock file exists, so a lock
+// file is never seen before its contents are written.
+// Returns a *repoLockedError if another process holds the lock.
+func createLockFile(file string, operation string) error {
+	b, err := serialize(repoLock{os.Getpid(), time.Now().Unix(), operation})
+	if err != nil {
+		return fmt.Errorf("createLockFile: %w", err)
+	}
+	for {
+		err := linkLockFile(file, b)
+		if err == nil {
+			return nil
+		} else if !errors.Is(err, fs.ErrExist) {
+			return fmt.Errorf("createLockFile: %w", err)
+		}
+
+		// stat before reading, so only the lock file that was read can be broken
+		fileInfo, err := repoFS.Stat(file)
+		if errors.Is(err, fs.ErrNotExist) {
+			// released between our attempt and reading it
+			continue
+		} else if err != nil {
+			return fmt.Errorf("createLockFile: %w", err)
+		}
+		held, err := readLockFile(file)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("createLockFile: %w", err)
+		}
+		if !isStaleRepoLock(held) {
+			return &repoLockedError{file, held}
+		}
+		if err := replaceStaleLockFile(file, fileInfo, b); errors.Is(err, errLockFileChanged) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("createLockFile: %w", err)
+		}
+		return nil
+	}
+}
+
+// errLockFileChanged is returned when a stale lock file was replaced or released before it
+// could be broken.
+var errLockFileChanged = errors.New("lock file changed")
+
+// replaceStaleLockFile breaks a stale lock file by renaming a new lock file with the given
+// contents over it, so the lock file is never missing for another process to take.
+// Processes breaking the same lock take turns through a second lock file, and the lock file
+// is only replaced if it is still the stale one, so a lock taken in the meantime is kept.
+// Returns errLockFileChanged if the lock file is no longer the stale one.
+func replaceStaleLockFile(file string, stale fs.FileInfo, b []byte) error {
+	breakLock := filepath.Join(filepath.Dir(file), "."+filepath.Base(file)+".break")
+	if err := linkLockFile(breakLock, b); errors.Is(err, fs.ErrExist) {
+		held, err := readLockFile(breakLock)
+		if errors.Is(err, fs.ErrNotExist) {
+			return errLockFileChanged
+		} else if err != nil {
+			return fmt.Errorf("replaceStaleLockFile: %w", err)
+		}
+		if time.Since(time.Unix(held.Timestamp, 0)) > staleRepoLockMinAge {
+			// left behind by a process that crashed while breaking the lock
+			repoFS.Remove(breakLock)
+			return errLockFileChanged
+		}
+		return &repoLockedError{breakLock, held}
+	} else if err != nil {
+		return fmt.Errorf("replaceStaleLockFile: %w", err)
+	}
+	defer repoFS.Remove(breakLock)
+
+	fileInfo, err := repoFS.Stat(file)
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && !repoFS.SameFile(fileInfo, stale)) {
+		return errLockFileChanged
+	} else if err != nil {
+		return fmt.Errorf("replaceStaleLockFile: %w", err)
+	}
+	f, err := createTempLockFile(file, b)
+	if err != nil {
+		return fmt.Errorf("replaceStaleLockFile: %w", err)
+	}
+	if err := repoFS.Rename(f, file); err != nil {
+		repoFS.Remove(f)
+		return fmt.Errorf("replaceStaleLockFile: %w", err)
+	}
+	return nil
+}
+
+// linkLockFile creates a lock file with the given contents by hard linking a temporary file
+// to it. Returns an error satisfying errors.Is(err, fs.ErrExist) if the lock file exists.
+func linkLockFile(file string, b []byte) error {
+	f, err := createTempLockFile(file, b)
+	if err != nil {
+		return fmt.Errorf("linkLockFile: %w", err)
+	}
+	defer repoFS.Remove(f)
+	if err := repoFS.Link(f, file); err != nil {
+		return fmt.Errorf("linkLockFile: %w", err)
+	}
+	return nil
+}
+
+// createTempLockFile writes lock file contents to a new hidden file next to a lock file and
+// returns its path.
+func createTempLockFile(file string, b []byte) (string, error) {
+	f, err := repoFS.CreateTemp(filepath.Dir(file), "."+filepath.Base(file)+".tmp*")
+	if err != nil {
+		return "", fmt.Errorf("createTempLockFile: %w", err)
+	}
+	_, err = f.Write(b)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = repoFS.Chmod(f.Name(), 0644)
+	}
+	if err != nil {
+		repoFS.Remove(f.Name())
+		return "", fmt.Errorf("createTempLockFile: %w", err)
+	}
+	return f.Name(), nil
+}
+
+// heldFileLocks counts the nested lockFile calls of this process holding the lock of each
+// file, by the absolute path of the lock file.
+var (
+	heldFileLocksMu sync.Mutex
+	heldFileLocks   = make(map[string]int)
+)
+
+// lockFile takes the lock of a file that is about to be read, modified, and written back,
+// such as the index or a ref, and returns a function that releases it. Like the repository
+// lock, it is re-entrant within a process and stale locks are broken.
+// Returns a *repoLockedError if another process holds the lock.
+func lockFile(file string, operation string) (func(), error) {
+	lock, err := repoFS.Abs(file + lockFileSuffix)
+	if err != nil {
+		return nil, fmt.Errorf("lockFile: %w", err)
+	}
+	heldFileLocksMu.Lock()
+	defer heldFileLocksMu.Unlock()
+	if heldFileLocks[lock] == 0 {
+		if err := createLockFile(lock, operation); err != nil {
+			return nil, fmt.Errorf("lockFile: %w", err)
+		}
+	}
+	heldFileLocks[lock]++
+	unlock := func() {
+		heldFileLocksMu.Lock()
+		defer heldFileLocksMu.Unlock()
+		if heldFileLocks[lock]--; heldFileLocks[lock] == 0 {
+			delete(heldFileLocks, lock)
+			repoFS.Remove(lock)
+		}
+	}
+	return unlock, nil
+}
+
+// writeRef points a ref, such as a branch, tag, or HEAD, at a commit or another ref while
+// holding the lock of its file. The ref is replaced atomically and durably, so a crash leaves
+// either its old or its new target, never a truncated file, and a returned write survives.
+func writeRef(file string, target string, operation string) error {
+	unlock, err := lockFile(file, operation)
+	if err != nil {
+		return fmt.Errorf("writeRef: %w", err)
+	}
+	defer unlock()
+	s := getStorage()
+	if err := s.writeRef(refName(file), target); err != nil {
+		return fmt.Errorf("writeRef: %w", err)
+	}
+	return nil
+}
+
+// unlockRepo releases one level of the repository lock, removing the lock file
+// once the outermost operation finishes.
+func unlockRepo() {
+	repoLockMu.Lock()
+	defer repoLockMu.Unlock()
+	if repoLockDepth == 0 {
+		return
+	}
+	repoLockDepth--
+	if repoLockDepth == 0 {
+		repoFS.Remove(heldRepoLock)
+	}
+}
+
+// releaseLocks removes the lock files this process holds, however deeply they were taken.
+// It is called before exiting, since exiting does not run the deferred unlocks of the
+// operations that took them.
+func releaseLocks() {
+	repoLockMu.Lock()
+	defer repoLockMu.Unlock()
+	if repoLockDepth > 0 {
+		repoLockDepth = 0
+		repoFS.Remove(heldRepoLock)
+	}
+	heldFileLocksMu.Lock()
+	defer heldFileLocksMu.Unlock()
+	for lock := range heldFileLocks {
+		delete(heldFileLocks, lock)
+		repoFS.Remove(lock)
+	}
+}
+
+// exit prints its arguments and exits with status 1, like log.Fatal, after releasing the
+// locks this process holds.
+func exit(v ...any) {
+	releaseLocks()
+	log.Fatal(v...)
+}
+
+// exitf formats and prints its arguments and exits with status 1, like log.Fatalf, after
+// releasing the locks this process holds.
+func exitf(format string, v ...any) {
+	releaseLocks()
+	log.Fatalf(format, v...)
+}
+
+// getRefNames returns the sorted names of the refs in a directory, not including those in
+// its subdirectories.
+func getRefNames(dir string) ([]string, error) {
+	files, err := listRefs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("getRefNames: %w", err)
+	}
+	var names []string
+	for _, file := range files {
+		if filepath.Dir(file) == filepath.Clean(dir) {
+			names = append(names, filepath.Base(file))
+		}
+	}
+	return names, nil
+}
+
+// readRepoLock reads the repository lock file.
+// A lock file that cannot be decoded is returned as a lock with no process.
+func readRepoLock() (repoLock, error) {
+	l, err := readLockFile(repoLockFile)
+	if err != nil {
+		return repoLock{}, fmt.Errorf("readRepoLock: %w", err)
+	}
+	return l, nil
+}
+
+// readLockFile reads a lock file.
+// A lock file that cannot be decoded, such as one written by an older version that was
+// interrupted while writing it, is returned as a lock with no process, taken when the file
+// was last modified.
+func readLockFile(file string) (repoLock, error) {
+	b, err := readFile(file)
+	if err != nil {
+		return repoLock{}, fmt.Errorf("readLockFile: %w", err)
+	}
+	var l repoLock
+	if err := json.Unmarshal(b, &l); err != nil || l.PID <= 0 {
+		fileInfo, err := repoFS.Stat(file)
+		if err != nil {
+			return repoLock{}, fmt.Errorf("readLockFile: %w", err)
+		}
+		return repoLock{Timestamp: fileInfo.ModTime().Unix()}, nil
+	}
+	return l, nil
+}
+
+// isStaleRepoLock reports whether a lock file not held by this process was abandoned: it is
+// older than staleRepoLockAge, or its process is no longer running and it is older than
+// staleRepoLockMinAge. A lock with no process is held until it is older than
+// staleRepoLockAge, since there is no process to check.
+func isStaleRepoLock(l repoLock) bool {
+	age := time.Since(time.Unix(l.Timestamp, 0))
+	if age > staleRepoLockAge {
+		return true
+	}
+	if l.PID <= 0 {
+		return false
+	}
+	if l.PID == os.Getpid() {
+		// left behind by an earlier process with the same PID, since this process tracks its own locks
+		return true
+	}
+	return age > staleRepoLockMinAge && !processExists(l.PID)
+}
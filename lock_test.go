@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLockRepo(t *testing.T) {
+	setupTestRepo(t)
+	writeLock := func(l repoLock) {
+		t.Helper()
+		b, err := serialize(l)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := writeFile(repoLockFile, b, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// locking is re-entrant and the lock file is removed by the outermost unlock
+	unlock, err := lockRepo("merge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	innerUnlock, err := lockRepo("checkout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	innerUnlock()
+	if l, err := readRepoLock(); err != nil || l.Operation != "merge" || l.PID != os.Getpid() {
+		t.Fatalf("Incorrect lock after inner unlock: %+v, %v", l, err)
+	}
+	unlock()
+	if _, err := repoFS.Stat(repoLockFile); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Lock file not removed after unlock: %v", err)
+	}
+
+	// a lock held by another running process is respected
+	writeLock(repoLock{os.Getppid(), time.Now().Unix(), "rebase"})
+	var lockedErr *repoLockedError
+	if _, err := lockRepo("merge"); !errors.As(err, &lockedErr) || lockedErr.Lock.Operation != "rebase" {
+		t.Fatalf("Lock held by another process was not respected: %v", err)
+	}
+
+	// recent locks of exited processes, and locks being written, are respected
+	writeLock(repoLock{1 << 30, time.Now().Unix(), "gc"})
+	if _, err := lockRepo("merge"); !errors.As(err, &lockedErr) || lockedErr.Lock.Operation != "gc" {
+		t.Fatalf("Recent lock of an exited process was not respected: %v", err)
+	}
+	if err := writeFile(repoLockFile, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lockRepo("merge"); !errors.As(err, &lockedErr) {
+		t.Fatalf("Empty lock file was not respected: %v", err)
+	}
+	old := time.Now().Add(-2 * staleRepoLockAge)
+	if err := repoFS.Chtimes(repoLockFile, old, old); err != nil {
+		t.Fatal(err)
+	}
+	unlock, err = lockRepo("merge")
+	if err != nil {
+		t.Fatalf("Old empty lock file was not broken: %v", err)
+	}
+	unlock()
+
+	// old locks and locks of exited processes are broken
+	for _, stale := range []repoLock{
+		{os.Getppid(), time.Now().Add(-2 * staleRepoLockAge).Unix(), "gc"},
+		{1 << 30, time.Now().Add(-time.Minute).Unix(), "gc"},
+	} {
+		writeLock(stale)
+		unlock, err := lockRepo("merge")
+		if err != nil {
+			t.Fatalf("Stale lock %+v was not broken: %v", stale, err)
+		}
+		if l, err := readRepoLock(); err != nil || l.Operation != "merge" {
+			t.Fatalf("Incorrect lock after breaking stale lock: %+v, %v", l, err)
+		}
+		unlock()
+	}
+
+	// locking another repository while holding the lock keeps track of both lock files
+	remoteDir := setupRemoteRepo(t)
+	if unlock, err = lockRepo("push"); err != nil {
+		t.Fatal(err)
+	}
+	if err := inRepository(remoteDir, func() error {
+		innerUnlock, err := lockRepo("push")
+		if err != nil {
+			return err
+		}
+		innerUnlock()
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	unlock()
+	if _, err := repoFS.Stat(repoLockFile); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Lock file should be removed after unlocking: %v", err)
+	}
+}
+
+func TestLockFile(t *testing.T) {
+	setupTestRepo(t)
+	lock := indexFile + lockFileSuffix
+
+	// locking is re-entrant and the lock file is removed by the outermost unlock
+	unlock, err := lockFile(indexFile, "add")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeIndex(make(indexMap)); err != nil {
+		t.Fatalf("Writing the index while holding its lock failed: %v", err)
+	}
+	if l, err := readLockFile(lock); err != nil || l.Operation != "add" || l.PID != os.Getpid() {
+		t.Fatalf("Incorrect index lock: %+v, %v", l, err)
+	}
+	unlock()
+	if _, err := repoFS.Stat(lock); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Lock file not removed after unlock: %v", err)
+	}
+
+	// an index locked by another running process cannot be updated
+	b, err := serialize(repoLock{os.Getppid(), time.Now().Unix(), "commit"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFile(lock, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeContents("wug.txt", []string{"This is a wug"}); err != nil {
+		t.Fatal(err)
+	}
+	var lockedErr *repoLockedError
+	if err := stageFile("wug.txt"); !errors.As(err, &lockedErr) || lockedErr.Lock.Operation != "commit" {
+		t.Fatalf("Index lock held by another process was not respected: %v", err)
+	}
+	if index, err := readIndex(); err != nil || len(index) != 0 {
+		t.Fatalf("Index was changed while locked: %v, %v", index, err)
+	}
+	if err := repoFS.Remove(lock); err != nil {
+		t.Fatal(err)
+	}
+
+	// lock files of refs being updated are not refs
+	unlock, err = lockFile(filepath.Join(branchesDir, "main"), "commit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlock()
+	if heads, err := getBranchHeads(); err != nil || len(heads) != 1 {
+		t.Fatalf("Incorrect branches while a branch is locked: %v, %v", heads, err)
+	}
+}
+
+func TestWriteRef(t *testing.T) {
+	setupTestRepo(t)
+	branchFile := filepath.Join(branchesDir, "main")
+	if err := writeRef(branchFile, "0123456789abcdef0123456789abcdef01234567", "commit"); err != nil {
+		t.Fatal(err)
+	}
+	if hash, err := readContentsAsString(branchFile); err != nil || hash != "0123456789abcdef0123456789abcdef01234567" {
+		t.Fatalf("Incorrect ref: %q, %v", hash, err)
+	}
+	// a temporary file left behind by a crash during an update is not a ref
+	if err := writeFile(filepath.Join(branchesDir, ".main.tmp123"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if names, err := getRefNames(branchesDir); err != nil || len(names) != 1 || names[0] != "main" {
+		t.Fatalf("Incorrect refs: %v, %v", names, err)
+	}
+}
+
+// TestConcurrentAddHelper stages a file when run as a subprocess by TestConcurrentAdd,
+// exiting with status 3 if the index is locked.
+func TestConcurrentAddHelper(t *testing.T) {
+	file := os.Getenv("GITLET_TEST_ADD")
+	if file == "" {
+		t.Skip("only run as a subprocess of TestConcurrentAdd")
+	}
+	var lockedErr *repoLockedError
+	if err := stageFile(file); errors.As(err, &lockedErr) {
+		os.Exit(3)
+	} else if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConcurrentAdd(t *testing.T) {
+	dir := setupDiskTestFS(t)
+	if err := newRepository(); err != nil {
+		t.Fatal(err)
+	}
+	cmds := make([]*exec.Cmd, 40)
+	outputs := make([]bytes.Buffer, len(cmds))
+	for i := range cmds {
+		file := fmt.Sprintf("wug%v.txt", i)
+		if err := writeContents(file, []string{"This is wug ", file}); err != nil {
+			t.Fatal(err)
+		}
+		cmds[i] = exec.Command(os.Args[0], "-test.run=^TestConcurrentAddHelper$")
+		cmds[i].Dir = dir
+		cmds[i].Env = append(os.Environ(), "GITLET_TEST_ADD="+file)
+		cmds[i].Stdout, cmds[i].Stderr = &outputs[i], &outputs[i]
+	}
+	for _, cmd := range cmds {
+		if err := cmd.Start(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var added []string
+	for i, cmd := range cmds {
+		err := cmd.Wait()
+		var exitErr *exec.ExitError
+		if err == nil {
+			added = append(added, fmt.Sprintf("wug%v.txt", i))
+		} else if !errors.As(err, &exitErr) || exitErr.ExitCode() != 3 {
+			t.Fatalf("Concurrent add failed: %v\n%s", err, outputs[i].Bytes())
+		}
+	}
+	if len(added) == 0 {
+		t.Fatal("Every concurrent add found the index locked")
+	}
+
+	// every add that succeeded is in the index
+	index, err := readIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range added {
+		if _, ok := index[file]; !ok {
+			t.Errorf("%v was added but is missing from the index", file)
+		}
+	}
+	if len(index) != len(added) {
+		t.Fatalf("Incorrect index after concurrent adds: want %v entries, got %v", len(added), len(index))
+	}
+}
+
+// TestExitHelper runs an operation that exits with a user error when run as a subprocess by
+// TestExitReleasesLocks.
+func TestExitHelper(t *testing.T) {
+	var err error
+	switch os.Getenv("GITLET_TEST_EXIT") {
+	case "":
+		t.Skip("only run as a subprocess of TestExitReleasesLocks")
+	case "rm-remote":
+		err = removeRemote("nosuch")
+	case "checkout":
+		err = checkoutBranch("nosuch")
+	case "add":
+		err = stageFile("nosuch.txt")
+	}
+	t.Fatalf("Operation returned instead of exiting: %v", err)
+}
+
+func TestExitReleasesLocks(t *testing.T) {
+	dir := setupDiskTestFS(t)
+	if err := newRepository(); err != nil {
+		t.Fatal(err)
+	}
+	commitInRepo(t, ".", "a.txt", "A")
+	tests := []struct {
+		operation string
+		message   string
+	}{
+		{"rm-remote", "A remote with that name does not exist."},
+		{"checkout", "No such branch exists."},
+		{"add", "File does not exist."},
+	}
+	// each operation runs twice, since an operation that leaves its lock behind blocks the next
+	for _, test := range append(tests, tests...) {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestExitHelper$")
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), "GITLET_TEST_EXIT="+test.operation)
+		output, err := cmd.CombinedOutput()
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) || exitErr.ExitCode() != 1 || !bytes.Contains(output, []byte(test.message)) {
+			t.Fatalf("Incorrect exit of %v: %v\n%s", test.operation, err, output)
+		}
+		if _, err := repoFS.Stat(repoLockFile); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("%v left the repository lock behind: %v", test.operation, err)
+		}
+		if _, err := repoFS.Stat(indexFile + lockFileSuffix); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("%v left the index lock behind: %v", test.operation, err)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// lineRange is an inclusive, 1-based range of lines in a file.
+type lineRange struct {
+	File       string
+	Start, End int
+}
+
+// lineRangeChange is a commit that changed a traced line range, with the hunk of the
+// change restricted to the range.
+type lineRangeChange struct {
+	Hash   string
+	Commit commit
+	File   string
+	Added  bool // The range was introduced along with the file.
+	Hunk   diffHunk
+}
+
+// parseLineRange parses a line range of the form <start>,<end>:<file>, where end may also
+// be given as +<count> lines from start.
+func parseLineRange(spec string) (lineRange, error) {
+	bounds, file, ok := strings.Cut(spec, ":")
+	if !ok || file == "" {
+		return lineRange{}, fmt.Errorf("parseLineRange: missing file in '%v'", spec)
+	}
+	startStr, endStr, ok := strings.Cut(bounds, ",")
+	if !ok {
+		return lineRange{}, fmt.Errorf("parseLineRange: missing end line in '%v'", spec)
+	}
+	start, err := strconv.Atoi(startStr)
+	if err != nil {
+		return lineRange{}, fmt.Errorf("parseLineRange: invalid start line in '%v'", spec)
+	}
+	var end int
+	if count, isCount := strings.CutPrefix(endStr, "+"); isCount {
+		n, err := strconv.Atoi(count)
+		if err != nil || n < 1 {
+			return lineRange{}, fmt.Errorf("parseLineRange: invalid line count in '%v'", spec)
+		}
+		end = start + n - 1
+	} else if end, err = strconv.Atoi(endStr); err != nil {
+		return lineRange{}, fmt.Errorf("parseLineRange: invalid end line in '%v'", spec)
+	}
+	if start < 1 || end < start {
+		return lineRange{}, fmt.Errorf("parseLineRange: invalid line range in '%v'", spec)
+	}
+	file, err = normalizePath(file)
+	if err != nil {
+		return lineRange{}, fmt.Errorf("parseLineRange: %w", err)
+	}
+	return lineRange{file, start, end}, nil
+}
+
+// getCommitFileLines returns the lines of a file tracked in a commit and whether the
+// commit tracks the file.
+func getCommitFileLines(c commit, file string) ([]string, bool, error) {
+	blobHash, ok := c.FileToBlob[file]
+	if !ok {
+		return nil, false, nil
+	}
+	_, contents, err := readBlob(blobHash)
+	if err != nil {
+		return nil, false, fmt.Errorf("getCommitFileLines: %w", err)
+	}
+	return splitLines(contents), true, nil
+}
+
+// rangeHunk restricts an edit script to the lines from start to end of the new side,
+// including lines deleted within the range, and reports whether the range changed.
+func rangeHunk(edits []diffLine, start int, end int) (diffHunk, bool) {
+	var hunk diffHunk
+	changed := false
+	oldSeen, newSeen := 0, 0
+	for _, e := range edits {
+		pos := newSeen + 1 // deleted lines sit before the next line of the new side
+		if e.Op != '-' {
+			pos = e.NewLine
+		}
+		if pos >= start && pos <= end {
+			if len(hunk.Lines) == 0 {
+				hunk.OldStart, hunk.NewStart = oldSeen+1, newSeen+1
+			}
+			hunk.Lines = append(hunk.Lines, e)
+			if e.Op != '+' {
+				hunk.OldCount++
+			}
+			if e.Op != '-' {
+				hunk.NewCount++
+			}
+			changed = changed || e.Op != ' '
+		}
+		if e.Op != '+' {
+			oldSeen++
+		}
+		if e.Op != '-' {
+			newSeen++
+		}
+	}
+	return hunk, changed
+}
+
+// lineRangeTrace follows a line range of a file back through the first-parent history of a
+// commit, one commit at a time. At each commit the range is mapped onto the parent's version
+// of the file, until the lines were introduced.
+type lineRangeTrace struct {
+	Range   lineRange                  // The range in the next commit to trace.
+	Done    bool                       // Every line of the range was introduced by a traced commit.
+	Changes map[string]lineRangeChange // Traced commits that changed the range, by hash.
+	lines   []string                   // Lines of the file in the next commit to trace.
+}
+
+// errLineRangeNotInCommit is returned by newLineRangeTrace when the commit does not track the
+// file of the range, or the file has fewer lines than the range.
+var errLineRangeNotInCommit = errors.New("line range is not in the commit")
+
+// newLineRangeTrace starts tracing a line range of a file from a commit.
+// Returns errLineRangeNotInCommit if the commit does not have the lines of the range.
+func newLineRangeTrace(c commit, r lineRange) (*lineRangeTrace, error) {
+	lines, ok, err := getCommitFileLines(c, r.File)
+	if err != nil {
+		return nil, fmt.Errorf("newLineRangeTrace: %w", err)
+	}
+	if !ok || r.End > len(lines) {
+		return nil, fmt.Errorf("newLineRangeTrace: %w", errLineRangeNotInCommit)
+	}
+	return &lineRangeTrace{Range: r, Changes: make(map[string]lineRangeChange), lines: lines}, nil
+}
+
+// step traces the range through a commit, which must be the commit the trace started from
+// or the first parent of the commit traced before it, and reports whether the commit changed
+// the range. Once the trace is done, no commit changes the range.
+func (tr *lineRangeTrace) step(commitHash string, c commit) (bool, error) {
+	if tr.Done {
+		return false, nil
+	}
+	var parent commit
+	var parentLines []string
+	inParent := false
+	if c.ParentUIDs[0] != "" {
+		var err error
+		if parent, err = getCommit(c.ParentUIDs[0]); err != nil {
+			return false, fmt.Errorf("step: %w", err)
+		}
+		if parentLines, inParent, err = getCommitFileLines(parent, tr.Range.File); err != nil {
+			return false, fmt.Errorf("step: %w", err)
+		}
+	}
+	hunk, changed := rangeHunk(diffLines(parentLines, tr.lines), tr.Range.Start, tr.Range.End)
+	if changed {
+		tr.Changes[commitHash] = lineRangeChange{commitHash, c, tr.Range.File, !inParent, hunk}
+	}
+	if !inParent || hunk.OldCount == 0 {
+		tr.Done = true // the lines of the range were all introduced by this commit
+	} else {
+		tr.Range.Start, tr.Range.End = hunk.OldStart, hunk.OldStart+hunk.OldCount-1
+		tr.lines = parentLines
+	}
+	return changed, nil
+}
+
+// traceLineRange follows a line range of a file back through the first-parent history of
+// a commit, returning the commits that changed the range, newest first.
+func traceLineRange(commitHash string, r lineRange) ([]lineRangeChange, error) {
+	c, err := getCommit(commitHash)
+	if err != nil {
+		return nil, fmt.Errorf("traceLineRange: %w", err)
+	}
+	tr, err := newLineRangeTrace(c, r)
+	if err != nil {
+		return nil, fmt.Errorf("traceLineRange: %w", err)
+	}
+	var changes []lineRangeChange
+	for !tr.Done {
+		changed, err := tr.step(commitHash, c)
+		if err != nil {
+			return nil, fmt.Errorf("traceLineRange: %w", err)
+		}
+		if changed {
+			changes = append(changes, tr.Changes[commitHash])
+		}
+		if commitHash = c.ParentUIDs[0]; !tr.Done {
+			if c, err = getCommit(commitHash); err != nil {
+				return nil, fmt.Errorf("traceLineRange: %w", err)
+			}
+		}
+	}
+	return changes, nil
+}
+
+// String returns the change as a diff of the traced file restricted to the range.
+func (change lineRangeChange) String() string {
+	from := "a/" + change.File
+	if change.Added {
+		from = "/dev/null"
+	}
+	return fmt.Sprintf("--- %v\n+++ b/%v\n%v", from, change.File, change.Hunk)
+}
+
+// traceHeadLineRange starts tracing a line range of the form <start>,<end>:<file> from the
+// head commit.
+func traceHeadLineRange(spec string) (*lineRangeTrace, error) {
+	r, err := parseLineRange(spec)
+	if err != nil {
+		exit("Incorrect line range, use <start>,<end>:<file>.")
+	}
+	headCommit, err := getHeadCommit()
+	if err != nil {
+		return nil, fmt.Errorf("traceHeadLineRange: %w", err)
+	}
+	tr, err := newLineRangeTrace(headCommit, r)
+	if errors.Is(err, errLineRangeNotInCommit) {
+		exit("File does not have those lines in the head commit.")
+	} else if err != nil {
+		return nil, fmt.Errorf("traceHeadLineRange: %w", err)
+	}
+	return tr, nil
+}
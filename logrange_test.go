@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParseLineRange(t *testing.T) {
+	tests := []struct {
+		spec     string
+		expected lineRange
+		valid    bool
+	}{
+		{"2,4:wug.txt", lineRange{"wug.txt", 2, 4}, true},
+		{"2,+3:wug.txt", lineRange{"wug.txt", 2, 4}, true},
+		{"2,4", lineRange{}, false},
+		{"4,2:wug.txt", lineRange{}, false},
+		{"0,2:wug.txt", lineRange{}, false},
+		{"2:wug.txt", lineRange{}, false},
+		{"1,2:../wug.txt", lineRange{}, false},
+	}
+	for _, test := range tests {
+		r, err := parseLineRange(test.spec)
+		if (err == nil) != test.valid || r != test.expected {
+			t.Errorf("parseLineRange(%q) = %v, %v", test.spec, r, err)
+		}
+	}
+}
+
+func TestTraceLineRange(t *testing.T) {
+	setupTestRepo(t)
+	commitContents := func(contents string) string {
+		t.Helper()
+		if err := writeContents("wug.txt", []string{contents}); err != nil {
+			t.Fatal(err)
+		}
+		if err := stageFile("wug.txt"); err != nil {
+			t.Fatal(err)
+		}
+		if err := newCommit("edit wug.txt"); err != nil {
+			t.Fatal(err)
+		}
+		hash, err := getHeadCommitHash()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hash
+	}
+	added := commitContents("a\nb\nc\n")
+	changed := commitContents("a\nB\nc\n")
+	commitContents("a\nB\nc\nd\n")               // outside the range
+	shifted := commitContents("z\na\nB\nc\nd\n") // moves the range down a line
+	head := commitContents("y\nz\na\nB\nc\nd\n")
+
+	changes, err := traceLineRange(head, lineRange{"wug.txt", 4, 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 2 || changes[0].Hash != changed || changes[1].Hash != added {
+		t.Fatalf("Incorrect commits changing line 4: want [%v %v], got %v", changed, added, changes)
+	}
+	if expected := "@@ -2 +2 @@\n-b\n+B\n"; changes[0].Hunk.String() != expected {
+		t.Errorf("Incorrect hunk: want %q, got %q", expected, changes[0].Hunk.String())
+	}
+	if !changes[1].Added || changes[0].Added {
+		t.Errorf("Only the first commit should add the range")
+	}
+
+	changes, err = traceLineRange(head, lineRange{"wug.txt", 2, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].Hash != shifted {
+		t.Fatalf("Incorrect commits changing line 2: want [%v], got %v", shifted, changes)
+	}
+
+	// log traces the range through the history its filters and limit apply to
+	headCommit, err := getCommit(head)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		opts     logOptions
+		expected []string
+	}{
+		{logOptions{}, []string{changed, added}},
+		{logOptions{MaxCount: 1}, []string{changed}},
+		{logOptions{Until: time.Unix(0, 0)}, nil},
+		{logOptions{Grep: regexp.MustCompile("wug")}, []string{changed, added}},
+	} {
+		tr, err := newLineRangeTrace(headCommit, lineRange{"wug.txt", 4, 4})
+		if err != nil {
+			t.Fatal(err)
+		}
+		test.opts.LineRange = tr
+		if hashes, err := getLogCommits(head, test.opts); err != nil || !slices.Equal(hashes, test.expected) {
+			t.Errorf("getLogCommits(%+v) = %v, %v, want %v", test.opts, hashes, err, test.expected)
+		}
+	}
+	if _, err := newLineRangeTrace(headCommit, lineRange{"wug.txt", 4, 7}); !errors.Is(err, errLineRangeNotInCommit) {
+		t.Errorf("Tracing lines past the end of the file should fail, got %v", err)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"path/filepath"
+)
+
+// indexedFile is a path the next commit would track and the hash of its file blob, or of the
+// commit it pins if it is a submodule.
+type indexedFile struct {
+	File string
+	Hash string
+}
+
+// getIndexedFiles returns the paths in the current scope that the next commit would track,
+// sorted: the files and submodules of the head commit, with staged changes applied.
+func getIndexedFiles() ([]indexedFile, error) {
+	headCommit, headFiles, err := getHeadScopeFiles()
+	if err != nil {
+		return nil, fmt.Errorf("getIndexedFiles: %w", err)
+	}
+	index, err := readIndex()
+	if err != nil {
+		return nil, fmt.Errorf("getIndexedFiles: %w", err)
+	}
+	files := make(map[string]string)
+	for file, hash := range headFiles {
+		files[file] = hash
+	}
+	for path, hash := range headCommit.Submodules {
+		files[path] = hash
+	}
+	for file, metadata := range index {
+		if metadata.Op == indexRemove {
+			delete(files, file)
+		} else {
+			files[file] = metadata.Hash
+		}
+	}
+	var indexed []indexedFile
+	for _, file := range sortedKeys(files) {
+		if inScope(file) {
+			indexed = append(indexed, indexedFile{file, files[file]})
+		}
+	}
+	return indexed, nil
+}
+
+// printIndexedFiles prints the paths the next commit would track, one per line, preceded by
+// their hashes if showHashes. With deletedOnly, only paths missing from the working directory
+// are printed.
+func printIndexedFiles(showHashes bool, deletedOnly bool) error {
+	indexed, err := getIndexedFiles()
+	if err != nil {
+		return fmt.Errorf("printIndexedFiles: %w", err)
+	}
+	for _, f := range indexed {
+		if deletedOnly {
+			if _, err := repoFS.Stat(filepath.FromSlash(f.File)); err == nil {
+				continue
+			} else if !errors.Is(err, fs.ErrNotExist) {
+				return fmt.Errorf("printIndexedFiles: %w", err)
+			}
+		}
+		if showHashes {
+			log.Printf("%v %v\n", f.Hash, f.File)
+		} else {
+			log.Println(f.File)
+		}
+	}
+	return nil
+}
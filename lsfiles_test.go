@@ -0,0 +1,39 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestGetIndexedFiles(t *testing.T) {
+	setupTestRepo(t)
+	commitInRepo(t, ".", "a.txt", "a")
+	commitInRepo(t, ".", "b.txt", "b")
+	if err := unstageFile("a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeContents("c.txt", []string{"c"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stageFile("c.txt"); err != nil {
+		t.Fatal(err)
+	}
+	index, err := readIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	headCommit, err := getHeadCommit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	indexed, err := getIndexedFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a.txt is staged for removal
+	expected := []indexedFile{{"b.txt", headCommit.FileToBlob["b.txt"]}, {"c.txt", index["c.txt"].Hash}}
+	if !slices.Equal(indexed, expected) {
+		t.Fatalf("Indexed files do not match, want %v, got %v", expected, indexed)
+	}
+}
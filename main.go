@@ -5,9 +5,14 @@ package main
 
 import (
 	"errors"
+	"flag"
+	"io/fs"
 	"log"
+	"net"
 	"os"
-	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
 )
 
 func main() {
@@ -17,82 +22,347 @@ func main() {
 		log.Fatal("Please enter a command.")
 	}
 
+	args, scopeDir, err := extractScopeOption(os.Args)
+	if err != nil {
+		log.Fatal("Incorrect operands.")
+	}
+	os.Args = args
+	if len(os.Args) == 1 {
+		log.Fatal("Please enter a command.")
+	}
+
 	command := os.Args[1]
-	if command != "init" {
+	if command != "init" && command != "bench" && command != "clone" && command != "daemon" {
 		checkGitletInit()
+		if err := upgradeRepository(); err != nil {
+			fatal(err)
+		}
+		if err := loadScope(scopeDir); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				log.Fatal("Scope directory does not exist.")
+			}
+			fatal(err)
+		}
 	}
 
 	switch command {
 	case "init":
 		validateArgs(os.Args, 1)
 		if err := newRepository(); err != nil {
-			log.Fatal(err)
+			fatal(err)
 		}
-		if cwd, err := os.Getwd(); err != nil {
+		if dir, err := repoFS.Abs(gitletDir); err != nil {
 			log.Println("Initialized new Gitlet repository.")
 		} else {
-			log.Printf("Initialized new Gitlet repository in %v\n", filepath.Join(cwd, gitletDir))
+			log.Printf("Initialized new Gitlet repository in %v\n", dir)
 		}
 	case "add":
-		validateArgs(os.Args, 2)
-		file := os.Args[2]
-		if err := stageFile(file); err != nil {
-			log.Fatal(err)
+		if len(os.Args) < 3 || (os.Args[2] == "-A" && len(os.Args) != 3) {
+			log.Fatal("Incorrect operands.")
+		}
+		if os.Args[2] == "-A" {
+			if err := stageAll(); err != nil {
+				fatal(err)
+			}
+			break
+		}
+		files, err := expandPaths(os.Args[2:])
+		if err != nil {
+			fatal(err)
+		}
+		if err := stageFiles(files); err != nil {
+			fatal(err)
 		}
 	case "commit":
-		validateArgs(os.Args, 2)
-		message := os.Args[2]
+		flags := flag.NewFlagSet("commit", flag.ExitOnError)
+		var paragraphs repeatedFlag
+		flags.Var(&paragraphs, "m", "use `message` as a paragraph of the commit message; may be repeated")
+		file := flags.String("F", "", "read the commit message from `file`, or from stdin if it is -")
+		flags.Parse(os.Args[2:])
+		if flags.NArg() > 1 || (flags.NArg() == 1 && (len(paragraphs) > 0 || *file != "")) ||
+			(len(paragraphs) > 0 && *file != "") {
+			log.Fatal("Incorrect operands.")
+		}
+		message := flags.Arg(0)
+		if flags.NArg() == 0 && len(paragraphs) == 0 && *file == "" {
+			if message, err = editMessage(); err != nil {
+				fatal(err)
+			}
+		} else if flags.NArg() == 0 {
+			if message, err = readMessage(paragraphs, *file, os.Stdin); errors.Is(err, fs.ErrNotExist) {
+				log.Fatal("Message file does not exist.")
+			} else if err != nil {
+				fatal(err)
+			}
+		}
 		if err := newCommit(message); err != nil {
-			log.Fatal(err)
+			fatal(err)
 		}
 	case "rm":
 		validateArgs(os.Args, 2)
 		file := os.Args[2]
 		if err := unstageFile(file); err != nil {
-			log.Fatal(err)
+			fatal(err)
+		}
+	case "mv":
+		validateArgs(os.Args, 3)
+		if err := moveFile(os.Args[2], os.Args[3]); err != nil {
+			fatal(err)
+		}
+	case "ls-files":
+		flags := flag.NewFlagSet("ls-files", flag.ExitOnError)
+		stage := flags.Bool("stage", false, "show the hash of each file's staged or tracked version")
+		deleted := flags.Bool("deleted", false, "only show files missing from the working directory")
+		flags.Parse(os.Args[2:])
+		if flags.NArg() != 0 {
+			log.Fatal("Incorrect operands.")
+		}
+		if err := printIndexedFiles(*stage, *deleted); err != nil {
+			fatal(err)
+		}
+	case "restore":
+		flags := flag.NewFlagSet("restore", flag.ExitOnError)
+		staged := flags.Bool("staged", false, "unstage the file without changing the working directory")
+		flags.Parse(os.Args[2:])
+		if flags.NArg() != 1 {
+			log.Fatal("Incorrect operands.")
+		}
+		var err error
+		if *staged {
+			err = restoreStaged(flags.Arg(0))
+		} else {
+			err = restoreFile(flags.Arg(0))
+		}
+		if err != nil {
+			fatal(err)
 		}
 	case "log":
-		validateArgs(os.Args, 1)
-		if err := printBranchLog(); err != nil {
-			log.Fatal(err)
+		flags := flag.NewFlagSet("log", flag.ExitOnError)
+		lines := flags.String("L", "", "trace the history of the line range `start,end:file`")
+		pickaxe := flags.String("S", "", "only show commits that added or removed the `string`")
+		maxCount := flags.Int("n", 0, "show at most `count` commits")
+		oneline := flags.Bool("oneline", false, "show each commit as its abbreviated hash and subject")
+		graph := flags.Bool("graph", false, "draw the history of both parents of merges as a graph")
+		author := flags.String("author", "", "only show commits whose author contains `name`")
+		since := flags.String("since", "", "only show commits made at or after `date`")
+		until := flags.String("until", "", "only show commits made at or before `date`")
+		grep := flags.String("grep", "", "only show commits whose message matches `pattern`")
+		follow := flags.Bool("follow", false, "continue the history of a single file across renames")
+		flags.Parse(os.Args[2:])
+		if (flags.NArg() != 0 && (*lines != "" || *pickaxe != "")) || (*lines != "" && *pickaxe != "") || *maxCount < 0 ||
+			(*follow && flags.NArg() != 1) {
+			log.Fatal("Incorrect operands.")
+		}
+		opts := logOptions{
+			MaxCount: *maxCount, Oneline: *oneline, Graph: *graph, Author: *author, Follow: *follow, Pickaxe: *pickaxe,
+		}
+		for _, file := range flags.Args() {
+			file, err := normalizePath(file)
+			if err != nil {
+				fatal(err)
+			}
+			opts.Paths = append(opts.Paths, file)
+		}
+		if *since != "" {
+			if opts.Since, err = parseDate(*since); err != nil {
+				log.Fatal("Incorrect date format, use YYYY-MM-DD [hh:mm[:ss]].")
+			}
+		}
+		if *until != "" {
+			if opts.Until, err = parseDate(*until); err != nil {
+				log.Fatal("Incorrect date format, use YYYY-MM-DD [hh:mm[:ss]].")
+			}
+		}
+		if *grep != "" {
+			if opts.Grep, err = regexp.Compile(*grep); err != nil {
+				log.Fatalf("Invalid pattern: %v.", err)
+			}
+		}
+		if *lines != "" {
+			if opts.LineRange, err = traceHeadLineRange(*lines); err != nil {
+				fatal(err)
+			}
+		}
+		if *graph && scope != "" {
+			log.Fatal("Cannot draw a graph within a scope.")
+		}
+		if *graph && opts.filtered() {
+			log.Fatal("Cannot draw a graph of filtered commits.")
+		}
+		if err := printBranchLog(opts); err != nil {
+			fatal(err)
 		}
 	case "global-log":
-		validateArgs(os.Args, 1)
-		if err := printAllCommits(); err != nil {
-			log.Fatal(err)
+		flags := flag.NewFlagSet("global-log", flag.ExitOnError)
+		reverse := flags.Bool("reverse", false, "show the oldest commits first")
+		flags.Parse(os.Args[2:])
+		if flags.NArg() != 0 {
+			log.Fatal("Incorrect operands.")
+		}
+		if err := printAllCommits(*reverse); err != nil {
+			fatal(err)
 		}
 	case "find":
-		validateArgs(os.Args, 2)
-		query := os.Args[2]
-		if err := printMatchingCommits(query); err != nil {
-			log.Fatal(err)
+		flags := flag.NewFlagSet("find", flag.ExitOnError)
+		isRegex := flags.Bool("regex", false, "match the message against a regular expression")
+		author := flags.String("author", "", "only find commits whose author contains `name`")
+		before := flags.String("before", "", "only find commits made at or before `date`")
+		after := flags.String("after", "", "only find commits made at or after `date`")
+		long := flags.Bool("long", false, "show full log entries instead of hashes")
+		flags.Parse(os.Args[2:])
+		// the message query may be left out when another filter is given
+		if flags.NArg() > 1 || (flags.NArg() == 0 && *author == "" && *before == "" && *after == "") {
+			log.Fatal("Incorrect operands.")
+		}
+		opts := logOptions{Author: *author}
+		if flags.NArg() == 1 {
+			query := flags.Arg(0)
+			if !*isRegex {
+				query = regexp.QuoteMeta(query)
+			}
+			if opts.Grep, err = regexp.Compile(query); err != nil {
+				log.Fatalf("Invalid pattern: %v.", err)
+			}
+		}
+		if *before != "" {
+			if opts.Until, err = parseDate(*before); err != nil {
+				log.Fatal("Incorrect date format, use YYYY-MM-DD [hh:mm[:ss]].")
+			}
+		}
+		if *after != "" {
+			if opts.Since, err = parseDate(*after); err != nil {
+				log.Fatal("Incorrect date format, use YYYY-MM-DD [hh:mm[:ss]].")
+			}
+		}
+		if err := printMatchingCommits(opts, *long); err != nil {
+			fatal(err)
 		}
 	case "status":
-		validateArgs(os.Args, 1)
-		if err := printStatus(); err != nil {
-			log.Fatal(err)
+		flags := flag.NewFlagSet("status", flag.ExitOnError)
+		porcelain := flags.Bool("porcelain", false, "print one line per changed path")
+		short := flags.Bool("short", false, "print the current branch and one line per changed path")
+		flags.BoolVar(short, "s", false, "shorthand for -short")
+		watch := flags.Bool("watch", false, "print the porcelain status again whenever it changes")
+		interval := flags.Duration("interval", 500*time.Millisecond, "time between checks for changes when watching")
+		flags.Parse(os.Args[2:])
+		if flags.NArg() != 0 || *interval <= 0 {
+			log.Fatal("Incorrect operands.")
+		}
+		if *watch {
+			if err := watchStatus(*interval, log.Writer(), nil); err != nil {
+				fatal(err)
+			}
+		} else if *porcelain {
+			entries, err := getStatusEntries()
+			if err != nil {
+				fatal(err)
+			}
+			log.Print(formatPorcelainStatus(entries))
+		} else if *short {
+			entries, err := getStatusEntries()
+			if err != nil {
+				fatal(err)
+			}
+			branch, err := getCurrentBranch()
+			if err != nil {
+				fatal(err)
+			}
+			log.Print(formatShortStatus(branch, entries))
+		} else if err := printStatus(); err != nil {
+			fatal(err)
 		}
 	case "checkout":
-		if (len(os.Args) == 4) && os.Args[2] == "--" {
+		if (len(os.Args) == 4 || len(os.Args) == 5) && os.Args[2] == "--as-of" {
+			rev := "HEAD"
+			if len(os.Args) == 5 {
+				rev = os.Args[4]
+			}
+			t, err := parseDate(os.Args[3])
+			if err != nil {
+				log.Fatal("Incorrect date format, use YYYY-MM-DD [hh:mm[:ss]].")
+			}
+			commitHash, err := resolveRevision(rev)
+			if errors.Is(err, fs.ErrNotExist) {
+				log.Fatal("No such branch exists.")
+			} else if err != nil {
+				fatal(err)
+			}
+			commitHash, err = commitAsOf(commitHash, t)
+			if errors.Is(err, fs.ErrNotExist) {
+				log.Fatal("No commit exists at or before that date.")
+			} else if err != nil {
+				fatal(err)
+			}
+			if err := checkoutDetached(commitHash); err != nil {
+				fatal(err)
+			}
+		} else if len(os.Args) == 4 && os.Args[2] == "-b" {
+			branchName := os.Args[3]
+			if err := createAndSwitchBranch(branchName); err != nil {
+				fatal(err)
+			}
+		} else if (len(os.Args) == 4) && os.Args[2] == "--" {
 			file := os.Args[3]
 			if err := checkoutHeadCommit(file); err != nil {
-				log.Fatal(err)
+				fatal(err)
 			}
 		} else if (len(os.Args) == 5) && os.Args[3] == "--" {
 			commitUID := os.Args[2]
 			file := os.Args[4]
 			if err := checkoutCommit(file, commitUID); err != nil {
-				log.Fatal(err)
+				fatal(err)
 			}
-		} else if len(os.Args) == 3 {
+		} else if len(os.Args) == 3 && os.Args[2] != "-b" {
 			branchName := os.Args[2]
 			if err := checkoutBranch(branchName); err != nil {
-				log.Fatal(err)
+				fatal(err)
 			}
 		} else {
 			log.Fatal("Incorrect operands.")
 		}
+	case "switch":
+		flags := flag.NewFlagSet("switch", flag.ExitOnError)
+		create := flags.Bool("c", false, "create the branch at the head commit before switching to it")
+		flags.Parse(os.Args[2:])
+		if flags.NArg() != 1 {
+			log.Fatal("Incorrect operands.")
+		}
+		var err error
+		if *create {
+			err = createAndSwitchBranch(flags.Arg(0))
+		} else {
+			err = switchBranch(flags.Arg(0))
+		}
+		if err != nil {
+			fatal(err)
+		}
 	case "branch":
+		if len(os.Args) > 2 && os.Args[2] == "--recover" {
+			if len(os.Args) != 4 && len(os.Args) != 5 {
+				log.Fatal("Incorrect operands.")
+			}
+			branchName, rev := os.Args[3], ""
+			if len(os.Args) == 5 {
+				rev = os.Args[4]
+			}
+			if err := recoverBranch(branchName, rev); err != nil {
+				log.Fatal("Could not recover branch: ", err)
+			}
+			break
+		}
+		if len(os.Args) > 2 && (os.Args[2] == "--merged" || os.Args[2] == "--no-merged") {
+			if len(os.Args) > 4 {
+				log.Fatal("Incorrect operands.")
+			}
+			rev := "HEAD"
+			if len(os.Args) == 4 {
+				rev = os.Args[3]
+			}
+			if err := printMergedBranches(rev, os.Args[2] == "--merged"); err != nil {
+				fatal(err)
+			}
+			break
+		}
 		validateArgs(os.Args, 2)
 		branchName := os.Args[2]
 		if err := addBranch(branchName); err != nil {
@@ -108,53 +378,738 @@ func main() {
 		validateArgs(os.Args, 2)
 		commitUID := os.Args[2]
 		if err := resetFile(commitUID); err != nil {
-			log.Fatal(err)
+			fatal(err)
+		}
+	case "recover":
+		flags := flag.NewFlagSet("recover", flag.ExitOnError)
+		abort := flags.Bool("abort", false, "roll back the interrupted operation instead of finishing it")
+		flags.Parse(os.Args[2:])
+		if flags.NArg() != 0 {
+			log.Fatal("Incorrect operands.")
+		}
+		if err := recoverOperation(*abort); err != nil {
+			fatal(err)
 		}
 	case "merge":
+		flags := flag.NewFlagSet("merge", flag.ExitOnError)
+		abort := flags.Bool("abort", false, "abandon the last merge and restore the commit before it")
+		ours := flags.Bool("ours", false, "resolve conflicting files with the current branch's version")
+		theirs := flags.Bool("theirs", false, "resolve conflicting files with the merged branch's version")
+		noFastForward := flags.Bool("no-ff", false, "create a merge commit even if the branch can be fast-forwarded")
+		squash := flags.Bool("squash", false, "stage the merged changes without creating a merge commit")
+		flags.Parse(os.Args[2:])
+		opts := mergeOptions{NoFastForward: *noFastForward, Squash: *squash}
+		if (*ours && *theirs) || (*squash && *noFastForward) {
+			log.Fatal("Incorrect operands.")
+		} else if *ours {
+			opts.Strategy = mergeOurs
+		} else if *theirs {
+			opts.Strategy = mergeTheirs
+		}
+		var err error
+		if *abort {
+			if flags.NArg() != 0 {
+				log.Fatal("Incorrect operands.")
+			}
+			err = abortMerge()
+		} else {
+			if flags.NArg() != 1 {
+				log.Fatal("Incorrect operands.")
+			}
+			err = mergeBranch(flags.Arg(0), opts)
+		}
+		if err != nil {
+			fatal(err)
+		}
+	case "revert":
 		validateArgs(os.Args, 2)
-		branchName := os.Args[2]
-		if err := mergeBranch(branchName); err != nil {
-			log.Fatal(err)
+		if err := revertCommit(os.Args[2]); err != nil {
+			fatal(err)
+		}
+	case "cherry-pick":
+		validateArgs(os.Args, 2)
+		if err := cherryPick(os.Args[2]); err != nil {
+			fatal(err)
+		}
+	case "merge-base":
+		flags := flag.NewFlagSet("merge-base", flag.ExitOnError)
+		checkAncestor := flags.Bool("is-ancestor", false, "exit with status 0 if the first commit is an ancestor of the second, or 1 if not")
+		flags.Parse(os.Args[2:])
+		if flags.NArg() != 2 {
+			log.Fatal("Incorrect operands.")
+		}
+		if !*checkAncestor {
+			if err := printMergeBase(flags.Arg(0), flags.Arg(1)); err != nil {
+				fatal(err)
+			}
+			break
+		}
+		ancestorHash, commitHash, err := resolveMergeBaseRevisions(flags.Arg(0), flags.Arg(1))
+		if err != nil {
+			fatal(err)
+		}
+		if ok, err := isAncestor(ancestorHash, commitHash); err != nil {
+			fatal(err)
+		} else if !ok {
+			os.Exit(1)
+		}
+	case "cherry":
+		flags := flag.NewFlagSet("cherry", flag.ExitOnError)
+		verbose := flags.Bool("v", false, "show the subject of each commit")
+		flags.Parse(os.Args[2:])
+		if flags.NArg() != 1 && flags.NArg() != 2 {
+			log.Fatal("Incorrect operands.")
+		}
+		head := "HEAD"
+		if flags.NArg() == 2 {
+			head = flags.Arg(1)
+		}
+		if err := printCherry(flags.Arg(0), head, *verbose); err != nil {
+			fatal(err)
+		}
+	case "rebase":
+		flags := flag.NewFlagSet("rebase", flag.ExitOnError)
+		interactive := flags.Bool("i", false, "edit the list of commits to replay")
+		flags.Parse(os.Args[2:])
+		if flags.NArg() != 1 {
+			log.Fatal("Incorrect operands.")
+		}
+		if err := rebaseBranch(flags.Arg(0), *interactive); err != nil {
+			fatal(err)
+		}
+	case "blame":
+		if len(os.Args) != 3 && len(os.Args) != 4 {
+			log.Fatal("Incorrect operands.")
+		}
+		rev, file := "HEAD", os.Args[len(os.Args)-1]
+		if len(os.Args) == 4 {
+			rev = os.Args[2]
+		}
+		if err := printBlame(rev, file); err != nil {
+			fatal(err)
+		}
+	case "grep":
+		if len(os.Args) != 3 && len(os.Args) != 4 {
+			log.Fatal("Incorrect operands.")
+		}
+		rev := "HEAD"
+		if len(os.Args) == 4 {
+			rev = os.Args[3]
+		}
+		if err := printGrep(os.Args[2], rev); err != nil {
+			fatal(err)
+		}
+	case "bisect":
+		if len(os.Args) < 3 {
+			log.Fatal("Incorrect operands.")
+		}
+		var err error
+		switch subcommand := os.Args[2]; subcommand {
+		case "start":
+			bad, good := "", []string(nil)
+			if len(os.Args) > 3 {
+				bad, good = os.Args[3], os.Args[4:]
+			}
+			err = startBisect(bad, good)
+		case "good", "bad":
+			if len(os.Args) > 4 {
+				log.Fatal("Incorrect operands.")
+			}
+			rev := "HEAD"
+			if len(os.Args) == 4 {
+				rev = os.Args[3]
+			}
+			err = markBisect(rev, subcommand == "good")
+		case "reset":
+			validateArgs(os.Args, 2)
+			err = resetBisect()
+		default:
+			log.Fatal("Incorrect operands.")
+		}
+		if err != nil {
+			fatal(err)
+		}
+	case "archive":
+		flags := flag.NewFlagSet("archive", flag.ExitOnError)
+		output := flags.String("o", "", "write the archive to `file` instead of standard output")
+		format := flags.String("format", "", "archive `format`: tar, tar.gz, or zip (default from -o, else tar)")
+		flags.Parse(os.Args[2:])
+		if flags.NArg() < 1 {
+			log.Fatal("Incorrect operands.")
+		}
+		// options may also follow the commit
+		rev := flags.Arg(0)
+		flags.Parse(flags.Args()[1:])
+		if flags.NArg() != 0 {
+			log.Fatal("Incorrect operands.")
+		}
+		if err := archiveCommit(rev, *output, *format); err != nil {
+			fatal(err)
+		}
+	case "clean":
+		flags := flag.NewFlagSet("clean", flag.ExitOnError)
+		dryRun := flags.Bool("n", false, "only list the files that would be removed")
+		force := flags.Bool("f", false, "remove the untracked files")
+		flags.Parse(os.Args[2:])
+		if flags.NArg() != 0 {
+			log.Fatal("Incorrect operands.")
+		}
+		if err := cleanUntracked(*dryRun, *force); err != nil {
+			fatal(err)
+		}
+	case "format-patch":
+		flags := flag.NewFlagSet("format-patch", flag.ExitOnError)
+		outputDir := flags.String("o", ".", "write the patch files to `dir`")
+		flags.Parse(os.Args[2:])
+		if flags.NArg() != 1 {
+			log.Fatal("Incorrect operands.")
+		}
+		if err := writePatches(flags.Arg(0), *outputDir); err != nil {
+			fatal(err)
+		}
+	case "apply":
+		flags := flag.NewFlagSet("apply", flag.ExitOnError)
+		stage := flags.Bool("index", false, "also stage the changed files")
+		flags.Parse(os.Args[2:])
+		if flags.NArg() != 1 {
+			log.Fatal("Incorrect operands.")
+		}
+		if err := applyPatch(flags.Arg(0), *stage); err != nil {
+			fatal(err)
+		}
+	case "bundle":
+		if len(os.Args) < 4 {
+			log.Fatal("Incorrect operands.")
+		}
+		var err error
+		switch subcommand := os.Args[2]; subcommand {
+		case "create":
+			err = createBundle(os.Args[3], os.Args[4:])
+		case "unbundle":
+			validateArgs(os.Args, 3)
+			err = unbundle(os.Args[3])
+		default:
+			log.Fatal("Incorrect operands.")
+		}
+		if err != nil {
+			fatal(err)
+		}
+	case "notes":
+		if len(os.Args) < 3 {
+			log.Fatal("Incorrect operands.")
+		}
+		flags := flag.NewFlagSet("notes "+os.Args[2], flag.ExitOnError)
+		message := flags.String("m", "", "use `message` as the note")
+		force := flags.Bool("f", false, "overwrite an existing note")
+		flags.Parse(os.Args[3:])
+		if flags.NArg() > 1 {
+			log.Fatal("Incorrect operands.")
+		}
+		rev := "HEAD"
+		if flags.NArg() == 1 {
+			rev = flags.Arg(0)
+		}
+		var err error
+		switch subcommand := os.Args[2]; {
+		case subcommand == "add" && *message != "":
+			err = addNote(rev, *message, *force)
+		case subcommand == "show" && *message == "" && !*force:
+			err = printNote(rev)
+		case subcommand == "remove" && *message == "" && !*force:
+			err = removeNote(rev)
+		default:
+			log.Fatal("Incorrect operands.")
+		}
+		if err != nil {
+			fatal(err)
+		}
+	case "shortlog":
+		flags := flag.NewFlagSet("shortlog", flag.ExitOnError)
+		byCount := flags.Bool("n", false, "sort authors by number of commits")
+		summary := flags.Bool("s", false, "only print the number of commits of each author")
+		flags.Parse(os.Args[2:])
+		if flags.NArg() != 0 {
+			log.Fatal("Incorrect operands.")
+		}
+		if err := printShortlog(*byCount, *summary); err != nil {
+			fatal(err)
+		}
+	case "clone":
+		if len(os.Args) != 3 && len(os.Args) != 4 {
+			log.Fatal("Incorrect operands.")
+		}
+		dir := ""
+		if len(os.Args) == 4 {
+			dir = os.Args[3]
+		}
+		if err := cloneRemote(os.Args[2], dir); err != nil {
+			fatal(err)
+		}
+	case "remote":
+		flags := flag.NewFlagSet("remote", flag.ExitOnError)
+		verbose := flags.Bool("v", false, "also print the URL of each remote")
+		flags.Parse(os.Args[2:])
+		if flags.NArg() != 0 {
+			log.Fatal("Incorrect operands.")
+		}
+		if err := printRemotes(*verbose); err != nil {
+			fatal(err)
 		}
 	case "add-remote":
 		validateArgs(os.Args, 3)
 		remoteName := os.Args[2]
 		remoteURL := os.Args[3]
 		if err := addRemote(remoteName, remoteURL); err != nil {
-			log.Fatal(err)
+			fatal(err)
 		}
 	case "rm-remote":
 		validateArgs(os.Args, 2)
 		remoteName := os.Args[2]
 		if err := removeRemote(remoteName); err != nil {
-			log.Fatal(err)
+			fatal(err)
 		}
 	case "push":
 		validateArgs(os.Args, 3)
 		remoteName := os.Args[2]
 		remoteBranchName := os.Args[3]
 		if err := push(remoteName, remoteBranchName); err != nil {
-			log.Fatal(err)
+			fatal(err)
 		}
 	case "fetch":
 		validateArgs(os.Args, 3)
 		remoteName := os.Args[2]
 		remoteBranchName := os.Args[3]
 		if err := fetch(remoteName, remoteBranchName); err != nil {
-			log.Fatal(err)
+			fatal(err)
 		}
 	case "pull":
 		validateArgs(os.Args, 3)
 		remoteName := os.Args[2]
 		remoteBranchName := os.Args[3]
 		if err := pull(remoteName, remoteBranchName); err != nil {
-			log.Fatal(err)
+			fatal(err)
+		}
+	case "daemon":
+		flags := flag.NewFlagSet("daemon", flag.ExitOnError)
+		addr := flags.String("listen", defaultDaemonAddr, "listen for connections on `address`")
+		basePath := flags.String("base-path", ".", "serve the repositories in `directory` and its subdirectories")
+		enablePush := flags.Bool("enable-push", false, "allow clients to push to the served repositories")
+		flags.Parse(os.Args[2:])
+		if flags.NArg() != 0 {
+			log.Fatal("Incorrect operands.")
+		}
+		l, err := net.Listen("tcp", *addr)
+		if err != nil {
+			log.Fatalf("Could not listen on %v.", *addr)
+		}
+		log.Printf("Serving %v on %v.\n", *basePath, l.Addr())
+		if err := serveDaemon(l, *basePath, *enablePush); err != nil {
+			fatal(err)
+		}
+	case "serve-http":
+		flags := flag.NewFlagSet("serve-http", flag.ExitOnError)
+		addr := flags.String("listen", defaultHTTPAddr, "listen for connections on `address`")
+		enablePush := flags.Bool("enable-push", false, "allow clients to push to the repository")
+		flags.Parse(os.Args[2:])
+		if flags.NArg() != 0 {
+			log.Fatal("Incorrect operands.")
+		}
+		l, err := net.Listen("tcp", *addr)
+		if err != nil {
+			log.Fatalf("Could not listen on %v.", *addr)
+		}
+		log.Printf("Serving repository on http://%v.\n", l.Addr())
+		if err := serveHTTP(l, ".", *enablePush); err != nil {
+			fatal(err)
+		}
+	case "repack":
+		if len(os.Args) == 3 && os.Args[2] == "--incremental" {
+			count, err := repackIncremental()
+			if err != nil {
+				fatal(err)
+			}
+			log.Printf("Packed %v loose objects.\n", count)
+			break
+		}
+		validateArgs(os.Args, 1)
+		count, err := repackAll()
+		if err != nil {
+			fatal(err)
+		}
+		log.Printf("Packed %v objects.\n", count)
+	case "bench":
+		opts := benchOptions{Seed: 1}
+		flags := flag.NewFlagSet("bench", flag.ExitOnError)
+		flags.IntVar(&opts.Files, "files", 1000, "number of files in the synthetic repository")
+		flags.IntVar(&opts.FileSize, "size", 1024, "size of each file in bytes")
+		flags.IntVar(&opts.Depth, "depth", 20, "number of commits of history")
+		flags.Parse(os.Args[2:])
+		if flags.NArg() != 0 {
+			log.Fatal("Incorrect operands.")
+		}
+		results, err := runBenchmark(opts)
+		if err != nil {
+			fatal(err)
+		}
+		if err := printBenchmark(opts, results); err != nil {
+			fatal(err)
+		}
+	case "verify":
+		validateArgs(os.Args, 2)
+		commitHash, err := resolveRevision(os.Args[2])
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				log.Fatal("No commit with that id exists.")
+			}
+			fatal(err)
+		}
+		report, err := verifyHistory(commitHash)
+		if err != nil {
+			fatal(err)
+		}
+		printVerifyReport(report)
+		if len(report.Problems) > 0 {
+			os.Exit(1)
+		}
+	case "doctor":
+		if len(os.Args) == 3 && os.Args[2] != "--fix" {
+			log.Fatal("Incorrect operands.")
+		} else if len(os.Args) > 3 {
+			log.Fatal("Incorrect operands.")
+		}
+		if err := runDoctor(len(os.Args) == 3); err != nil {
+			fatal(err)
+		}
+	case "config":
+		if len(os.Args) == 3 && os.Args[2] == "--list" {
+			config, err := readConfig()
+			if err != nil {
+				fatal(err)
+			}
+			for _, key := range sortedKeys(config) {
+				log.Printf("%v=%v\n", key, config[key])
+			}
+		} else if len(os.Args) == 4 && os.Args[2] == "--unset" {
+			if ok, err := unsetConfig(os.Args[3]); err != nil {
+				fatal(err)
+			} else if !ok {
+				log.Fatal("No value set for that key.")
+			}
+		} else if len(os.Args) == 3 {
+			value, ok, err := getConfig(os.Args[2])
+			if err != nil {
+				fatal(err)
+			} else if !ok {
+				log.Fatal("No value set for that key.")
+			}
+			log.Println(value)
+		} else if len(os.Args) == 4 {
+			if err := setConfig(os.Args[2], os.Args[3]); err != nil {
+				log.Fatal("Could not set value: ", err)
+			}
+		} else {
+			log.Fatal("Incorrect operands.")
+		}
+	case "reflog":
+		if len(os.Args) < 3 || os.Args[2] != "expire" {
+			if len(os.Args) > 3 {
+				log.Fatal("Incorrect operands.")
+			}
+			ref := "HEAD"
+			if len(os.Args) == 3 {
+				ref = os.Args[2]
+			}
+			if err := printReflog(ref); err != nil {
+				fatal(err)
+			}
+			break
+		}
+		flags := flag.NewFlagSet("reflog expire", flag.ExitOnError)
+		expire := flags.String("expire", "", "expire entries older than this age (default gc.reflogExpire)")
+		expireUnreachable := flags.String(
+			"expire-unreachable", "",
+			"expire entries unreachable from their ref older than this age (default gc.reflogExpireUnreachable)",
+		)
+		flags.Parse(os.Args[3:])
+		if flags.NArg() != 0 {
+			log.Fatal("Incorrect operands.")
+		}
+		removed, err := expireReflogs(*expire, *expireUnreachable)
+		if err != nil {
+			fatal(err)
+		}
+		log.Printf("Expired %v reflog entries.\n", removed)
+	case "gc", "prune":
+		// prune deletes every unreachable object unless told otherwise, while gc leaves
+		// those younger than gc.pruneExpire in case a command in progress still needs them
+		flags := flag.NewFlagSet(os.Args[1], flag.ExitOnError)
+		expire := flags.String("prune", "", "delete unreachable objects older than this age (default gc.pruneExpire)")
+		if os.Args[1] == "prune" {
+			expire = flags.String("expire", "now", "delete unreachable objects older than this age")
+		}
+		flags.Parse(os.Args[2:])
+		if flags.NArg() != 0 {
+			log.Fatal("Incorrect operands.")
+		}
+		gracePeriod, err := getExpiryConfig("gc.pruneExpire")
+		if *expire != "" {
+			if gracePeriod, err = parseExpiry(*expire); err != nil {
+				log.Fatalf("Invalid expiry '%v'.", *expire)
+			}
+		}
+		if err != nil {
+			fatal(err)
+		}
+		removed, reclaimed, err := collectGarbage(gracePeriod, time.Now())
+		if err != nil {
+			fatal(err)
+		}
+		log.Printf("Removed %v unreachable objects, reclaiming %v bytes.\n", removed, reclaimed)
+	case "autosave":
+		if len(os.Args) < 3 {
+			log.Fatal("Incorrect operands.")
+		}
+		switch subcommand := os.Args[2]; subcommand {
+		case "start", "run":
+			flags := flag.NewFlagSet("autosave "+subcommand, flag.ExitOnError)
+			interval := flags.Duration("interval", 10*time.Minute, "time between snapshots")
+			flags.Parse(os.Args[3:])
+			if flags.NArg() != 0 || *interval <= 0 {
+				log.Fatal("Incorrect operands.")
+			}
+			run := startAutosave
+			if subcommand == "run" {
+				run = runAutosave
+			}
+			if err := run(*interval); err != nil {
+				fatal(err)
+			}
+		case "stop":
+			validateArgs(os.Args, 2)
+			if err := stopAutosave(); err != nil {
+				fatal(err)
+			}
+		case "list":
+			validateArgs(os.Args, 2)
+			if err := printAutosaves(); err != nil {
+				fatal(err)
+			}
+		case "restore":
+			validateArgs(os.Args, 3)
+			if err := restoreAutosave(os.Args[3]); err != nil {
+				fatal(err)
+			}
+		default:
+			log.Fatal("Incorrect operands.")
+		}
+	case "conflicts":
+		if len(os.Args) > 3 || (len(os.Args) == 3 && os.Args[2] != "--json") {
+			log.Fatal("Incorrect operands.")
+		}
+		if err := printConflicts(len(os.Args) == 3); err != nil {
+			fatal(err)
+		}
+	case "stats":
+		flags := flag.NewFlagSet("stats", flag.ExitOnError)
+		largest := flags.Bool("largest", false, "list the largest file blobs in history")
+		n := flags.Int("n", 10, "number of blobs to list")
+		flags.Parse(os.Args[2:])
+		if flags.NArg() != 0 || !*largest || *n < 1 {
+			log.Fatal("Incorrect operands.")
+		}
+		if err := printLargestBlobs(*n); err != nil {
+			fatal(err)
+		}
+	case "submodule":
+		if len(os.Args) < 3 {
+			log.Fatal("Incorrect operands.")
+		}
+		switch os.Args[2] {
+		case "add":
+			validateArgs(os.Args, 4)
+			if err := addSubmodule(os.Args[3], os.Args[4]); err != nil {
+				fatal(err)
+			}
+		case "update":
+			validateArgs(os.Args, 2)
+			pinned, err := getPinnedSubmodules()
+			if err != nil {
+				fatal(err)
+			}
+			if err := updateSubmodules(pinned); err != nil {
+				fatal(err)
+			}
+		case "status":
+			validateArgs(os.Args, 2)
+			if err := printSubmoduleStatus(); err != nil {
+				fatal(err)
+			}
+		default:
+			log.Fatal("Incorrect operands.")
+		}
+	case "diff":
+		flags := flag.NewFlagSet("diff", flag.ExitOnError)
+		stat := flags.Bool("stat", false, "print the number of changed lines per file instead of hunks")
+		flags.Parse(os.Args[2:])
+		var diffs []fileDiff
+		switch flags.NArg() {
+		case 0:
+			if diffs, err = diffWorkingTree(); err != nil {
+				fatal(err)
+			}
+		case 2:
+			var commitHashes [2]string
+			for i, rev := range flags.Args() {
+				if commitHashes[i], err = resolveRevision(rev); err != nil {
+					if errors.Is(err, fs.ErrNotExist) {
+						log.Fatal("No commit with that id exists.")
+					}
+					fatal(err)
+				}
+			}
+			if diffs, err = diffCommits(commitHashes[0], commitHashes[1]); err != nil {
+				fatal(err)
+			}
+		default:
+			log.Fatal("Incorrect operands.")
+		}
+		if *stat {
+			log.Print(formatDiffStat(diffs))
+		} else {
+			printDiffs(diffs)
+		}
+	case "stash":
+		subcommand := "push"
+		if len(os.Args) > 2 && !strings.HasPrefix(os.Args[2], "-") {
+			subcommand = os.Args[2]
+		}
+		switch subcommand {
+		case "push":
+			flags := flag.NewFlagSet("stash", flag.ExitOnError)
+			message := flags.String("m", "", "description of the stashed changes")
+			args := os.Args[2:]
+			if len(args) > 0 && args[0] == "push" {
+				args = args[1:]
+			}
+			flags.Parse(args)
+			if flags.NArg() != 0 {
+				log.Fatal("Incorrect operands.")
+			}
+			if err := stashChanges(*message); err != nil {
+				fatal(err)
+			}
+		case "list":
+			validateArgs(os.Args, 2)
+			if err := printStashList(); err != nil {
+				fatal(err)
+			}
+		case "apply", "pop", "drop":
+			if len(os.Args) > 4 {
+				log.Fatal("Incorrect operands.")
+			}
+			n := 0
+			if len(os.Args) == 4 {
+				if n, err = parseStashRef(os.Args[3]); err != nil {
+					log.Fatal("Incorrect stash, use <n> or stash@{<n>}.")
+				}
+			}
+			run := map[string]func(int) error{"apply": applyStash, "pop": popStash, "drop": dropStash}[subcommand]
+			if err := run(n); err != nil {
+				fatal(err)
+			}
+		default:
+			log.Fatal("Incorrect operands.")
+		}
+	case "tag":
+		var annotate bool
+		var message string
+		var operands []string
+		for i := 2; i < len(os.Args); i++ {
+			switch os.Args[i] {
+			case "-a":
+				annotate = true
+			case "-m":
+				if i++; i == len(os.Args) {
+					log.Fatal("Incorrect operands.")
+				}
+				annotate, message = true, os.Args[i]
+			default:
+				operands = append(operands, os.Args[i])
+			}
+		}
+		switch {
+		case len(operands) == 0 && !annotate:
+			if err := printTags(); err != nil {
+				fatal(err)
+			}
+		case len(operands) == 1 || len(operands) == 2:
+			rev := "HEAD"
+			if len(operands) == 2 {
+				rev = operands[1]
+			}
+			if err := createTag(operands[0], rev, message, annotate); err != nil {
+				fatal(err)
+			}
+		default:
+			log.Fatal("Incorrect operands.")
+		}
+	case "show":
+		rev := "HEAD"
+		if len(os.Args) == 3 {
+			rev = os.Args[2]
+		} else if len(os.Args) != 2 {
+			log.Fatal("Incorrect operands.")
+		}
+		if err := showRevision(rev); err != nil {
+			fatal(err)
+		}
+	case "compare":
+		validateArgs(os.Args, 3)
+		if err := printComparison(os.Args[2], os.Args[3]); err != nil {
+			fatal(err)
 		}
 	default:
 		log.Fatal("No command with that name exists.")
 	}
 }
 
+// fatal prints an error and exits. Corrupt objects, invalid paths, and a locked repository
+// are reported on their own, since what matters is the offending object, path, or process
+// rather than the commands that ran into it.
+func fatal(err error) {
+	var corruptErr *corruptObjectError
+	if errors.As(err, &corruptErr) {
+		exitf("Object %v is corrupt: %v.", corruptErr.Hash, corruptErr.Reason)
+	}
+	var pathErr *pathspecError
+	if errors.As(err, &pathErr) {
+		exitf("Invalid path '%v': %v.", pathErr.Path, pathErr.Reason)
+	}
+	var lockedErr *repoLockedError
+	if errors.As(err, &lockedErr) {
+		exitf(
+			"Another gitlet process is running %v (pid %v); if it has exited, delete %v and try again.",
+			lockedErr.Lock.Operation, lockedErr.Lock.PID, lockedErr.File,
+		)
+	}
+	if errors.Is(err, errUnknownIdentity) {
+		exit("Please tell gitlet who you are: set user.name and user.email with gitlet config.")
+	}
+	exit(err)
+}
+
+// repeatedFlag collects the values of a flag that may be given more than once, in order.
+type repeatedFlag []string
+
+func (f *repeatedFlag) String() string {
+	return strings.Join(*f, ", ")
+}
+
+func (f *repeatedFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
 func validateArgs(args []string, expected int) {
 	if len(args)-1 != expected {
 		log.Fatal("Incorrect operands.")
@@ -162,7 +1117,7 @@ func validateArgs(args []string, expected int) {
 }
 
 func checkGitletInit() {
-	_, err := os.Stat(gitletDir)
+	_, err := repoFS.Stat(gitletDir)
 	if errors.Is(err, os.ErrNotExist) {
 		log.Fatal("Not in an initialized Gitlet directory.")
 	}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestMainHelper runs the command line in $GITLET_TEST_MAIN when run as a subprocess by
+// runMain.
+func TestMainHelper(t *testing.T) {
+	args := os.Getenv("GITLET_TEST_MAIN")
+	if args == "" {
+		t.Skip("only run as a subprocess of runMain")
+	}
+	os.Args = append([]string{"gitlet"}, strings.Fields(args)...)
+	main()
+	os.Exit(0)
+}
+
+// runMain runs gitlet with args in dir as a subprocess, and returns its output and exit code.
+func runMain(t *testing.T, dir string, args ...string) (string, int) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], "-test.run=^TestMainHelper$")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GITLET_TEST_MAIN="+strings.Join(args, " "))
+	output, err := cmd.CombinedOutput()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return string(output), exitErr.ExitCode()
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	return string(output), 0
+}
+
+func TestMainCheckoutCreateBranch(t *testing.T) {
+	dir := setupDiskTestFS(t)
+	if err := newRepository(); err != nil {
+		t.Fatal(err)
+	}
+	commitInRepo(t, ".", "wug.txt", "This is a wug\n")
+	tests := []struct {
+		args     []string
+		code     int
+		output   string
+		expected string
+	}{
+		{[]string{"checkout", "-b"}, 1, "Incorrect operands.", "main"},
+		{[]string{"checkout", "-b", "feature", "extra"}, 1, "Incorrect operands.", "main"},
+		{[]string{"checkout", "-b", "feature"}, 0, "Switched to a new branch 'feature'.", "feature"},
+		{[]string{"checkout", "main"}, 0, "Branch 'main' is now checked out.", "main"},
+		{[]string{"checkout", "-b", "feature"}, 1, "A branch with that name already exists.", "main"},
+		{[]string{"checkout", "feature"}, 0, "Branch 'feature' is now checked out.", "feature"},
+	}
+	for _, test := range tests {
+		output, code := runMain(t, dir, test.args...)
+		if code != test.code || strings.Count(output, test.output) != 1 {
+			t.Fatalf("gitlet %v = %v, %q, want %v, %q once", strings.Join(test.args, " "), code, output, test.code, test.output)
+		}
+		if branch, err := getCurrentBranch(); err != nil || branch != test.expected {
+			t.Fatalf("Incorrect branch after gitlet %v: want %v, got %v, %v", strings.Join(test.args, " "), test.expected, branch, err)
+		}
+	}
+}
+
+func TestMainNestedUntrackedFile(t *testing.T) {
+	dir := setupDiskTestFS(t)
+	if err := newRepository(); err != nil {
+		t.Fatal(err)
+	}
+	commitInRepo(t, ".", "a.txt", "This is a wug\n")
+	if err := addBranch("other"); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkoutBranch("other"); err != nil {
+		t.Fatal(err)
+	}
+	mkTestDir(t, "sub")
+	commitInRepo(t, ".", "sub/x.txt", "This is a tracked wug\n")
+	if err := checkoutBranch("main"); err != nil {
+		t.Fatal(err)
+	}
+	mkTestDir(t, "sub")
+	if err := writeContents("sub/x.txt", []string{"This is an untracked wug\n"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// checking out, merging, or resetting to the branch does not overwrite the untracked file
+	for _, args := range [][]string{{"checkout", "other"}, {"merge", "other"}, {"reset", "other"}} {
+		output, code := runMain(t, dir, args...)
+		if code != 1 || !strings.Contains(output, "There is an untracked file in the way; delete it, or add and commit it first.") {
+			t.Fatalf("gitlet %v = %v, %q, want the untracked file to be in the way", strings.Join(args, " "), code, output)
+		}
+		if b, err := readFile("sub/x.txt"); err != nil || string(b) != "This is an untracked wug\n" {
+			t.Fatalf("gitlet %v changed the untracked file: %q, %v", strings.Join(args, " "), b, err)
+		}
+		if branch, err := getCurrentBranch(); err != nil || branch != "main" {
+			t.Fatalf("gitlet %v changed the branch: %v, %v", strings.Join(args, " "), branch, err)
+		}
+	}
+}
+
+func TestMainBisectBadAncestorOfGood(t *testing.T) {
+	dir := setupDiskTestFS(t)
+	if err := newRepository(); err != nil {
+		t.Fatal(err)
+	}
+	var hashes []string
+	for _, contents := range []string{"1", "2", "3", "4"} {
+		commitInRepo(t, ".", "wug.txt", contents)
+		hash, err := getHeadCommitHash()
+		if err != nil {
+			t.Fatal(err)
+		}
+		hashes = append(hashes, hash)
+	}
+	if err := startBisect(hashes[2], []string{hashes[0]}); err != nil {
+		t.Fatal(err)
+	}
+	state, err := readBisectState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, err := getHeadCommitHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, args := range [][]string{
+		{"bisect", "good", hashes[3]},
+		{"bisect", "good", hashes[2]},
+		{"bisect", "bad", hashes[0]},
+	} {
+		output, code := runMain(t, dir, args...)
+		if code != 1 || strings.TrimSpace(output) != "The bad commit cannot be a good commit or an ancestor of one." {
+			t.Fatalf("gitlet %v = %v, %q", strings.Join(args, " "), code, output)
+		}
+		if got, err := readBisectState(); err != nil || !reflect.DeepEqual(got, state) {
+			t.Fatalf("gitlet %v should not change the bisect: want %+v, got %+v, %v", strings.Join(args, " "), state, got, err)
+		}
+		if got, err := getHeadCommitHash(); err != nil || got != head {
+			t.Fatalf("gitlet %v should not check out %v: %v", strings.Join(args, " "), got, err)
+		}
+	}
+	if output, code := runMain(t, dir, "bisect", "good", hashes[1]); code != 0 || !strings.Contains(output, hashes[2]+" is the first bad commit") {
+		t.Fatalf("gitlet bisect good = %v, %q", code, output)
+	}
+}
+
+func TestMainFind(t *testing.T) {
+	dir := setupDiskTestFS(t)
+	if err := newRepository(); err != nil {
+		t.Fatal(err)
+	}
+	if err := setConfig("user.name", "Wug"); err != nil {
+		t.Fatal(err)
+	}
+	hash := commitInRepo(t, ".", "wug.txt", "This is a wug\n")
+	tests := []struct {
+		args   []string
+		code   int
+		output string
+	}{
+		{[]string{"find", "--author", "Wug"}, 0, "commit " + hash + "\n"},
+		{[]string{"find", "--before", "2000-01-01"}, 0, "commit " + initialCommitHash + "\n"},
+		{[]string{"find", "--author", "Wug", "wug.txt"}, 0, "commit " + hash + "\n"},
+		{[]string{"find", "--author", "Notwug"}, 1, "Found no matching commit.\n"},
+		{[]string{"find", "--author", "Wug", "initial"}, 1, "Found no commit with that message.\n"},
+		{[]string{"find"}, 1, "Incorrect operands.\n"},
+		{[]string{"find", "--regex"}, 1, "Incorrect operands.\n"},
+	}
+	for _, test := range tests {
+		if output, code := runMain(t, dir, test.args...); code != test.code || output != test.output {
+			t.Errorf("gitlet %v = %v, %q, want %v, %q", strings.Join(test.args, " "), code, output, test.code, test.output)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// removeFiles deletes files from the working directory, along with the directories their
+// removal leaves empty. Files are removed before another commit is materialized, so its files
+// can be written where the directories were, and its directories where the files were.
+func removeFiles(files []string) error {
+	for _, file := range files {
+		if err := restrictedDelete(file); err != nil {
+			return fmt.Errorf("removeFiles: %w", err)
+		}
+		for dir := filepath.Dir(file); dir != "."; dir = filepath.Dir(dir) {
+			// fails once a directory still has other entries
+			if err := repoFS.Remove(dir); err != nil {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// getRemovedFiles returns the files that checking out the target commit removes from the
+// working directory: those at the root of the working directory and those tracked by the
+// head commit that the target commit does not track. Untracked files in directories are kept.
+func getRemovedFiles(wdFiles []string, headCommit commit, targetCommit commit) []string {
+	var removed []string
+	for _, file := range wdFiles {
+		_, isTracked := headCommit.FileToBlob[file]
+		if _, ok := targetCommit.FileToBlob[file]; !ok && (isTracked || !strings.Contains(file, "/")) {
+			removed = append(removed, file)
+		}
+	}
+	for file := range headCommit.FileToBlob {
+		if _, ok := targetCommit.FileToBlob[file]; !ok && !slices.Contains(wdFiles, file) {
+			removed = append(removed, file)
+		}
+	}
+	return removed
+}
+
+// Maximum number of files written concurrently when materializing a commit.
+const maxMaterializeWorkers int = 32
+
+// materializeFiles writes every file blob in the given file to blob mapping into the
+// working directory. Parent directories are created up front, then files are written
+// concurrently by a bounded pool of workers. Errors for individual files are collected
+// and returned together once every file has been attempted.
+// Nothing is written if any path would fall outside the working directory.
+func materializeFiles(fileToBlob map[string]string) error {
+	dirSet := make(map[string]bool)
+	for file := range fileToBlob {
+		if err := validateTrackedPath(file); err != nil {
+			return fmt.Errorf("materializeFiles: %w", err)
+		}
+		if dir := filepath.Dir(file); dir != "." {
+			dirSet[dir] = true
+		}
+	}
+	dirs := make([]string, 0, len(dirSet))
+	for dir := range dirSet {
+		dirs = append(dirs, dir)
+	}
+	slices.Sort(dirs)
+	for _, dir := range dirs {
+		if err := repoFS.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("materializeFiles: %w", err)
+		}
+	}
+
+	files := make(chan string)
+	var mu sync.Mutex
+	var errs []error
+	var wg sync.WaitGroup
+	workers := min(runtime.NumCPU()*4, maxMaterializeWorkers, len(fileToBlob))
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for file := range files {
+				if err := materializeBlob(fileToBlob[file], file); err != nil {
+					mu.Lock()
+					errs = append(errs, err)
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for file := range fileToBlob {
+		files <- file
+	}
+	close(files)
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("materializeFiles: %w", err)
+	}
+	return nil
+}
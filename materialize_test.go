@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestMaterializeFiles(t *testing.T) {
+	setupTestRepo(t)
+	fileToBlob := make(map[string]string)
+	for i := 0; i < 100; i++ {
+		file := filepath.Join(fmt.Sprintf("dir%d", i%7), fmt.Sprintf("file%d.txt", i))
+		contents := []byte(fmt.Sprintf("contents of file %d", i))
+		if _, err := writeBlob("file", contents); err != nil {
+			t.Fatal(err)
+		}
+		hash, err := getHash(blobPayload("file", contents))
+		if err != nil {
+			t.Fatal(err)
+		}
+		fileToBlob[file] = hash
+	}
+	if err := materializeFiles(fileToBlob); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		file := filepath.Join(fmt.Sprintf("dir%d", i%7), fmt.Sprintf("file%d.txt", i))
+		contents, err := readContentsAsString(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := fmt.Sprintf("contents of file %d", i); contents != expected {
+			t.Fatalf("Incorrect contents of %v: want '%v', got '%v'", file, expected, contents)
+		}
+	}
+
+	// every failing file is reported
+	missing := map[string]string{"a.txt": "0000", "b.txt": "1111"}
+	if err := materializeFiles(missing); err == nil {
+		t.Fatal("Materializing missing blobs succeeded, want error.")
+	}
+}
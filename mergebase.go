@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+)
+
+// resolveMergeBaseRevisions returns the commits named by two revisions, exiting if either
+// does not exist.
+func resolveMergeBaseRevisions(rev1 string, rev2 string) (string, string, error) {
+	hashes := make([]string, 2)
+	for i, rev := range []string{rev1, rev2} {
+		hash, err := resolveRevision(rev)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				exitf("No commit or branch named '%v' exists.", rev)
+			}
+			return "", "", fmt.Errorf("resolveMergeBaseRevisions: %w", err)
+		}
+		hashes[i] = hash
+	}
+	return hashes[0], hashes[1], nil
+}
+
+// printMergeBase prints the latest common ancestor of the commits named by two revisions,
+// the split point a merge between them would use.
+func printMergeBase(rev1 string, rev2 string) error {
+	commitHash1, commitHash2, err := resolveMergeBaseRevisions(rev1, rev2)
+	if err != nil {
+		return fmt.Errorf("printMergeBase: %w", err)
+	}
+	splitPointCommitHash, err := findSplitPoint(commitHash1, commitHash2)
+	if err != nil {
+		return fmt.Errorf("printMergeBase: %w", err)
+	}
+	log.Println(splitPointCommitHash)
+	return nil
+}
+
+// isAncestor reports whether the first commit is an ancestor of the second, or the same commit.
+// History is only walked down to the generation of the first commit, as no commit at or below
+// it can be its descendant.
+func isAncestor(ancestorHash string, commitHash string) (bool, error) {
+	graph, err := readCommitGraph()
+	if err != nil {
+		return false, fmt.Errorf("isAncestor: %w", err)
+	}
+	ancestor, err := graph.lookup(ancestorHash)
+	if err != nil {
+		return false, fmt.Errorf("isAncestor: %w", err)
+	}
+	visited := make(map[string]bool)
+	stack := []string{commitHash}
+	for len(stack) > 0 {
+		hash := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if hash == ancestorHash {
+			return true, nil
+		}
+		if visited[hash] {
+			continue
+		}
+		visited[hash] = true
+		entry, err := graph.lookup(hash)
+		if err != nil {
+			return false, fmt.Errorf("isAncestor: %w", err)
+		}
+		if entry.Generation != generationInfinity && entry.Generation <= ancestor.Generation {
+			continue
+		}
+		stack = append(stack, entry.Parents...)
+	}
+	return false, nil
+}
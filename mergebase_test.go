@@ -0,0 +1,25 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestIsAncestor(t *testing.T) {
+	setupTestRepo(t)
+	first := commitInRepo(t, ".", "a.txt", "a")
+	second := commitInRepo(t, ".", "b.txt", "b")
+	tests := []struct {
+		ancestor, commit string
+		expected         bool
+	}{
+		{first, second, true},
+		{initialCommitHash, second, true},
+		{second, second, true},
+		{second, first, false},
+	}
+	for _, test := range tests {
+		if ok, err := isAncestor(test.ancestor, test.commit); err != nil || ok != test.expected {
+			t.Errorf("isAncestor(%v, %v) = %v, %v, want %v", test.ancestor, test.commit, ok, err, test.expected)
+		}
+	}
+}
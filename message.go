@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// File the commit message is edited in when commit is run without a message.
+var commitMessageFile = filepath.Join(gitletDir, "COMMIT_EDITMSG")
+
+// readMessage returns a commit message made of paragraphs separated by blank lines, or, if
+// a file is given, read from the file, or from stdin if the file is "-". Blank lines and
+// spaces around the message are removed.
+func readMessage(paragraphs []string, file string, stdin io.Reader) (string, error) {
+	if file == "" {
+		return strings.TrimSpace(strings.Join(paragraphs, "\n\n")), nil
+	}
+	var b []byte
+	var err error
+	if file == "-" {
+		b, err = io.ReadAll(stdin)
+	} else {
+		b, err = readFile(file)
+	}
+	if err != nil {
+		return "", fmt.Errorf("readMessage: %w", err)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// commitMessageTemplate returns the text the commit message editor starts with: instructions
+// and a summary of the status of the repository, all in comments.
+func commitMessageTemplate(branch string, entries []statusEntry) string {
+	var staged, unstaged, untracked []string
+	for _, entry := range entries {
+		switch {
+		case entry.X == '?':
+			untracked = append(untracked, entry.File)
+		case entry.X == 'U':
+			unstaged = append(unstaged, "unmerged: "+entry.File)
+		default:
+			if entry.X != ' ' {
+				staged = append(staged, statusDescription(entry.X)+entry.File)
+			}
+			if entry.Y != ' ' {
+				unstaged = append(unstaged, statusDescription(entry.Y)+entry.File)
+			}
+		}
+	}
+	var b strings.Builder
+	b.WriteString("\n# Please enter the commit message for your changes. Lines starting\n" +
+		"# with '#' will be ignored, and an empty message aborts the commit.\n#\n")
+	if branch == "" {
+		b.WriteString("# HEAD detached\n")
+	} else {
+		fmt.Fprintf(&b, "# On branch %v\n", branch)
+	}
+	for _, section := range []struct {
+		title string
+		files []string
+	}{
+		{"Changes to be committed:", staged},
+		{"Changes not staged for commit:", unstaged},
+		{"Untracked files:", untracked},
+	} {
+		if len(section.files) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "#\n# %v\n", section.title)
+		for _, file := range section.files {
+			fmt.Fprintf(&b, "#\t%v\n", file)
+		}
+	}
+	return b.String()
+}
+
+// statusDescription describes a porcelain status code as a label for a file.
+func statusDescription(code byte) string {
+	switch code {
+	case 'A':
+		return "new file:   "
+	case 'D':
+		return "deleted:    "
+	default:
+		return "modified:   "
+	}
+}
+
+// editMessage opens the commit message file in the user's editor, filled in with the
+// commit message template, and returns the message without comments. Exits if nothing is
+// staged or the message is empty.
+func editMessage() (string, error) {
+	index, err := readIndex()
+	if err != nil {
+		return "", fmt.Errorf("editMessage: %w", err)
+	}
+	if len(index) == 0 {
+		exit("No changes added to commit.")
+	}
+	branch, err := getCurrentBranch()
+	if err != nil {
+		return "", fmt.Errorf("editMessage: %w", err)
+	}
+	entries, err := getStatusEntries()
+	if err != nil {
+		return "", fmt.Errorf("editMessage: %w", err)
+	}
+	if err := writeContents(commitMessageFile, []string{commitMessageTemplate(branch, entries)}); err != nil {
+		return "", fmt.Errorf("editMessage: %w", err)
+	}
+	if err := editFile(commitMessageFile); err != nil {
+		return "", fmt.Errorf("editMessage: %w", err)
+	}
+	edited, err := readContentsAsString(commitMessageFile)
+	if err != nil {
+		return "", fmt.Errorf("editMessage: %w", err)
+	}
+	message := strings.TrimSpace(stripComments(edited))
+	if message == "" {
+		exit("Aborting commit due to empty commit message.")
+	}
+	return message, nil
+}
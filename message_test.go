@@ -0,0 +1,59 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReadMessage(t *testing.T) {
+	setupTestFS(t)
+	if err := writeFile("msg.txt", []byte("\nsubject\n\nbody\n\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		paragraphs []string
+		file       string
+		expected   string
+	}{
+		{[]string{"subject"}, "", "subject"},
+		{[]string{"subject", "body"}, "", "subject\n\nbody"},
+		{nil, "msg.txt", "subject\n\nbody"},
+		{nil, "-", "from stdin"},
+	}
+	for _, test := range tests {
+		message, err := readMessage(test.paragraphs, test.file, strings.NewReader("from stdin\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if message != test.expected {
+			t.Errorf("readMessage(%q, %q) = %q, want %q", test.paragraphs, test.file, message, test.expected)
+		}
+	}
+}
+
+func TestEditMessage(t *testing.T) {
+	setupTestRepo(t)
+	if err := writeContents("wug.txt", []string{"wug"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stageFile("wug.txt"); err != nil {
+		t.Fatal(err)
+	}
+	// the editor replaces the empty first line and keeps the commented status
+	t.Setenv("VISUAL", "")
+	t.Setenv("EDITOR", "sed -i -e 1s/^$/wug/")
+	message, err := editMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message != "wug" {
+		t.Fatalf("Edited message does not match, want %q, got %q", "wug", message)
+	}
+	template, err := readContentsAsString(commitMessageFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(template, "# On branch main\n") || !strings.Contains(template, "#\tnew file:   wug.txt") {
+		t.Fatalf("Commit message template does not summarize the status: %q", template)
+	}
+}
@@ -0,0 +1,23 @@
+//go:build !unix
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// mmapFile reads the first size bytes of a file into memory on platforms without mmap.
+func mmapFile(f *os.File, size int) ([]byte, error) {
+	b := make([]byte, size)
+	if _, err := io.ReadFull(f, b); err != nil {
+		return nil, fmt.Errorf("mmapFile: %w", err)
+	}
+	return b, nil
+}
+
+// munmapFile releases memory returned by mmapFile.
+func munmapFile(b []byte) error {
+	return nil
+}
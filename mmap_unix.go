@@ -0,0 +1,32 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// mmapFile maps the first size bytes of a file into memory as read-only.
+func mmapFile(f *os.File, size int) ([]byte, error) {
+	if size == 0 {
+		return []byte{}, nil
+	}
+	b, err := syscall.Mmap(int(f.Fd()), 0, size, syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, fmt.Errorf("mmapFile: %w", err)
+	}
+	return b, nil
+}
+
+// munmapFile releases memory returned by mmapFile.
+func munmapFile(b []byte) error {
+	if len(b) == 0 {
+		return nil
+	}
+	if err := syscall.Munmap(b); err != nil {
+		return fmt.Errorf("munmapFile: %w", err)
+	}
+	return nil
+}
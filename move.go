@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+)
+
+// moveFile renames a tracked or staged file in the working directory, staging the removal
+// of the old path and the addition of the new path.
+func moveFile(src string, dst string) error {
+	src, err := normalizePath(src)
+	if err != nil {
+		return fmt.Errorf("moveFile: %w", err)
+	}
+	dst, err = normalizePath(dst)
+	if err != nil {
+		return fmt.Errorf("moveFile: %w", err)
+	}
+	unlock, err := lockRepo("mv")
+	if err != nil {
+		return fmt.Errorf("moveFile: %w", err)
+	}
+	defer unlock()
+
+	headCommit, err := getHeadCommit()
+	if err != nil {
+		return fmt.Errorf("moveFile: %w", err)
+	}
+	index, err := readIndex()
+	if err != nil {
+		return fmt.Errorf("moveFile: %w", err)
+	}
+	_, isTracked := headCommit.FileToBlob[src]
+	stagedMetadata, isStaged := index[src]
+	if !isTracked && (!isStaged || stagedMetadata.Op == indexRemove) {
+		exit("File is not tracked.")
+	}
+	if info, err := repoFS.Stat(src); errors.Is(err, fs.ErrNotExist) || (err == nil && info.IsDir()) {
+		exit("File does not exist.")
+	} else if err != nil {
+		return fmt.Errorf("moveFile: %w", err)
+	}
+	if _, err := repoFS.Lstat(dst); err == nil {
+		exit("Destination already exists.")
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("moveFile: %w", err)
+	}
+
+	if err := repoFS.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return fmt.Errorf("moveFile: %w", err)
+	}
+	if err := repoFS.Rename(src, dst); err != nil {
+		return fmt.Errorf("moveFile: %w", err)
+	}
+	// staging the missing source stages its removal, or drops a staged addition
+	if err := stageFile(src); err != nil {
+		return fmt.Errorf("moveFile: %w", err)
+	}
+	if err := stageFile(dst); err != nil {
+		return fmt.Errorf("moveFile: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestMoveFile(t *testing.T) {
+	setupTestRepo(t)
+	for _, file := range []string{"tracked.txt", "staged.txt"} {
+		if err := writeContents(file, []string{file}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := stageFile("tracked.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := newCommit("add tracked"); err != nil {
+		t.Fatal(err)
+	}
+	if err := stageFile("staged.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := moveFile("tracked.txt", "dir/moved.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := moveFile("staged.txt", "renamed.txt"); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{"tracked.txt", "staged.txt"} {
+		if _, err := repoFS.Stat(file); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("%v should be moved: %v", file, err)
+		}
+	}
+	if contents, err := readContentsAsString("dir/moved.txt"); err != nil || contents != "tracked.txt" {
+		t.Errorf("Incorrect moved file contents: %q, %v", contents, err)
+	}
+	index, err := readIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]indexOp{"tracked.txt": indexRemove, "dir/moved.txt": indexAdd, "renamed.txt": indexAdd}
+	if len(index) != len(expected) {
+		t.Errorf("Expected %v staged files, got %v", len(expected), index)
+	}
+	for file, op := range expected {
+		if index[file].Op != op {
+			t.Errorf("Expected %v to be staged for %v, got %+v", file, op, index[file])
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"path/filepath"
+	"strings"
+)
+
+// File mapping the hashes of commits to the hashes of the note blobs attached to them.
+// Notes can be changed without rewriting the commits they describe.
+var notesFile = filepath.Join(refsDir, "notes", "commits")
+
+// Map between commit hashes and note blob hashes.
+type notesMap map[string]string
+
+// readNotes returns the notes attached to commits, which are empty if no note was ever added.
+func readNotes() (notesMap, error) {
+	b, err := readContents(notesFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return make(notesMap), nil
+	} else if err != nil {
+		return nil, fmt.Errorf("readNotes: %w", err)
+	}
+	notes, err := deserialize[notesMap](b)
+	if err != nil {
+		return nil, fmt.Errorf("readNotes: %w", err)
+	}
+	return notes, nil
+}
+
+// writeNotes replaces the notes attached to commits.
+func writeNotes(notes notesMap) error {
+	b, err := serialize(notes)
+	if err != nil {
+		return fmt.Errorf("writeNotes: %w", err)
+	}
+	if err := repoFS.MkdirAll(filepath.Dir(notesFile), 0755); err != nil {
+		return fmt.Errorf("writeNotes: %w", err)
+	}
+	if err := writeFileAtomic(notesFile, b); err != nil {
+		return fmt.Errorf("writeNotes: %w", err)
+	}
+	return nil
+}
+
+// getNote returns the note attached to a commit, with ok false if it has none.
+func getNote(commitHash string) (note string, ok bool, err error) {
+	notes, err := readNotes()
+	if err != nil {
+		return "", false, fmt.Errorf("getNote: %w", err)
+	}
+	blobHash, ok := notes[commitHash]
+	if !ok {
+		return "", false, nil
+	}
+	_, contents, err := readBlob(blobHash)
+	if err != nil {
+		return "", false, fmt.Errorf("getNote: %w", err)
+	}
+	return string(contents), true, nil
+}
+
+// formatNote formats the note attached to a commit for a log entry, indented under a
+// "Notes:" line, or returns empty if the commit has no note.
+func formatNote(commitHash string) (string, error) {
+	note, ok, err := getNote(commitHash)
+	if err != nil {
+		return "", fmt.Errorf("formatNote: %w", err)
+	}
+	if !ok {
+		return "", nil
+	}
+	var b strings.Builder
+	b.WriteString("Notes:\n")
+	for _, line := range strings.Split(strings.TrimRight(note, "\n"), "\n") {
+		fmt.Fprintf(&b, "    %v\n", line)
+	}
+	return b.String(), nil
+}
+
+// resolveNoteTarget returns the hash of the commit named by a revision, exiting if there is none.
+func resolveNoteTarget(rev string) (string, error) {
+	commitHash, err := resolveRevision(rev)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			exitf("No commit or branch named '%v' exists.", rev)
+		}
+		return "", fmt.Errorf("resolveNoteTarget: %w", err)
+	}
+	return commitHash, nil
+}
+
+// addNote attaches a note to the commit named by a revision. A commit that already has
+// a note keeps it, unless force is set.
+func addNote(rev string, message string, force bool) error {
+	unlock, err := lockRepo("notes")
+	if err != nil {
+		return fmt.Errorf("addNote: %w", err)
+	}
+	defer unlock()
+	commitHash, err := resolveNoteTarget(rev)
+	if err != nil {
+		return fmt.Errorf("addNote: %w", err)
+	}
+	notes, err := readNotes()
+	if err != nil {
+		return fmt.Errorf("addNote: %w", err)
+	}
+	if _, ok := notes[commitHash]; ok && !force {
+		exitf("Commit %v already has a note; use -f to overwrite it.", commitHash[:6])
+	}
+	blobHash, err := writeBlob("note", []byte(message))
+	if err != nil {
+		return fmt.Errorf("addNote: %w", err)
+	}
+	notes[commitHash] = blobHash
+	if err := writeNotes(notes); err != nil {
+		return fmt.Errorf("addNote: %w", err)
+	}
+	return nil
+}
+
+// removeNote removes the note attached to the commit named by a revision.
+func removeNote(rev string) error {
+	unlock, err := lockRepo("notes")
+	if err != nil {
+		return fmt.Errorf("removeNote: %w", err)
+	}
+	defer unlock()
+	commitHash, err := resolveNoteTarget(rev)
+	if err != nil {
+		return fmt.Errorf("removeNote: %w", err)
+	}
+	notes, err := readNotes()
+	if err != nil {
+		return fmt.Errorf("removeNote: %w", err)
+	}
+	if _, ok := notes[commitHash]; !ok {
+		exitf("Commit %v has no note.", commitHash[:6])
+	}
+	delete(notes, commitHash)
+	if err := writeNotes(notes); err != nil {
+		return fmt.Errorf("removeNote: %w", err)
+	}
+	return nil
+}
+
+// printNote prints the note attached to the commit named by a revision.
+func printNote(rev string) error {
+	commitHash, err := resolveNoteTarget(rev)
+	if err != nil {
+		return fmt.Errorf("printNote: %w", err)
+	}
+	note, ok, err := getNote(commitHash)
+	if err != nil {
+		return fmt.Errorf("printNote: %w", err)
+	}
+	if !ok {
+		exitf("Commit %v has no note.", commitHash[:6])
+	}
+	log.Print(strings.TrimRight(note, "\n") + "\n")
+	return nil
+}
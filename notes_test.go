@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestNotes(t *testing.T) {
+	setupTestRepo(t)
+	if note, err := formatNote(initialCommitHash); err != nil || note != "" {
+		t.Fatalf("Commit without a note has one: %q, %v", note, err)
+	}
+	if err := addNote("HEAD", "reviewed\nlooks good", false); err != nil {
+		t.Fatal(err)
+	}
+	note, err := formatNote(initialCommitHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "Notes:\n    reviewed\n    looks good\n"; note != expected {
+		t.Fatalf("Note is not formatted, want %q, got %q", expected, note)
+	}
+
+	// overwriting the note does not change the commit
+	if err := addNote("HEAD", "rejected", true); err != nil {
+		t.Fatal(err)
+	}
+	if note, ok, err := getNote(initialCommitHash); err != nil || !ok || note != "rejected" {
+		t.Fatalf("Note was not overwritten: %q, %v, %v", note, ok, err)
+	}
+	if hash, err := getHeadCommitHash(); err != nil || hash != initialCommitHash {
+		t.Fatalf("Adding a note changed the head commit: %v, %v", hash, err)
+	}
+
+	if err := removeNote("HEAD"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := getNote(initialCommitHash); err != nil || ok {
+		t.Fatalf("Note was not removed: %v, %v", ok, err)
+	}
+}
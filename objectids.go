@@ -0,0 +1,184 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const hashLength int = 40
+
+// Loose objects are stored in 256 subdirectories of the objects directory named by the first
+// two characters of their hash, as objects/ab/cdef..., so no directory holds more than a small
+// fraction of them. Hash abbreviations are resolved by listing only the subdirectories the
+// abbreviation can fall in.
+const objectDirPrefixLength int = 2
+
+// isHash reports whether s is a full, lowercase hexadecimal object hash.
+func isHash(s string) bool {
+	return len(s) == hashLength && isHexPrefix(s)
+}
+
+// isHexPrefix reports whether s only contains lowercase hexadecimal characters.
+func isHexPrefix(s string) bool {
+	for _, r := range s {
+		if !strings.ContainsRune("0123456789abcdef", r) {
+			return false
+		}
+	}
+	return true
+}
+
+// objectPath returns the path of the loose object file of an object.
+func objectPath(hash string) string {
+	return looseObjectPath(objectsDir, hash)
+}
+
+// looseObjectPath returns the path of the loose object file of an object in the objects
+// directory dir.
+func looseObjectPath(dir string, hash string) string {
+	if !isHash(hash) {
+		return filepath.Join(dir, hash)
+	}
+	return filepath.Join(dir, hash[:objectDirPrefixLength], hash[objectDirPrefixLength:])
+}
+
+// makeObjectDir creates the subdirectory of the objects directory that holds the loose
+// object file of an object, if it does not exist, and returns the path of the file.
+func makeObjectDir(hash string) (string, error) {
+	file := objectPath(hash)
+	if err := repoFS.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return "", fmt.Errorf("makeObjectDir: %w", err)
+	}
+	return file, nil
+}
+
+// getObjectDirs returns the sorted names of the subdirectories of the objects directory dir
+// that hold loose objects whose hash starts with prefix.
+func getObjectDirs(dir string, prefix string) ([]string, error) {
+	entries, err := repoFS.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("getObjectDirs: %w", err)
+	}
+	var dirs []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() || len(name) != objectDirPrefixLength || !isHexPrefix(name) {
+			continue
+		}
+		if strings.HasPrefix(name, prefix) || strings.HasPrefix(prefix, name) {
+			dirs = append(dirs, name)
+		}
+	}
+	return dirs, nil
+}
+
+// findLooseObjects returns up to limit hashes of the loose objects in the objects directory
+// objDir starting with the given prefix, sorted.
+func findLooseObjects(objDir string, prefix string, limit int) ([]string, error) {
+	dirs, err := getObjectDirs(objDir, prefix)
+	if err != nil {
+		return nil, fmt.Errorf("findLooseObjects: %w", err)
+	}
+	var matched []string
+	for _, dir := range dirs {
+		files, err := getFilenames(filepath.Join(objDir, dir))
+		if err != nil {
+			return nil, fmt.Errorf("findLooseObjects: %w", err)
+		}
+		for _, file := range files {
+			hash := dir + file
+			if !isHash(hash) || !strings.HasPrefix(hash, prefix) {
+				continue
+			}
+			if len(matched) == limit {
+				return matched, nil
+			}
+			matched = append(matched, hash)
+		}
+	}
+	return matched, nil
+}
+
+// Version of the repository format, recorded as core.repositoryFormatVersion. Repositories
+// without a version predate sharded loose objects and keep them directly in the objects
+// directory.
+const repositoryFormatVersion int = 1
+
+// upgradeRepository brings a repository in an older format up to repositoryFormatVersion,
+// holding the repository lock, and records the new version so it is only upgraded once.
+// Does nothing for repositories already in the current format, or if there is no repository.
+func upgradeRepository() error {
+	if _, err := repoFS.Stat(gitletDir); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("upgradeRepository: %w", err)
+	}
+	if ok, err := isCurrentFormat(); err != nil {
+		return fmt.Errorf("upgradeRepository: %w", err)
+	} else if ok {
+		return nil
+	}
+	unlock, err := lockRepo("upgrade")
+	if err != nil {
+		return fmt.Errorf("upgradeRepository: %w", err)
+	}
+	defer unlock()
+	// another process may have upgraded the repository while this one took the lock
+	if ok, err := isCurrentFormat(); err != nil {
+		return fmt.Errorf("upgradeRepository: %w", err)
+	} else if ok {
+		return nil
+	}
+	if err := shardLooseObjects(); err != nil {
+		return fmt.Errorf("upgradeRepository: %w", err)
+	}
+	if err := setConfig("core.repositoryFormatVersion", strconv.Itoa(repositoryFormatVersion)); err != nil {
+		return fmt.Errorf("upgradeRepository: %w", err)
+	}
+	return nil
+}
+
+// isCurrentFormat reports whether the repository records repositoryFormatVersion or a later
+// version as its format.
+func isCurrentFormat() (bool, error) {
+	value, ok, err := getConfig("core.repositoryFormatVersion")
+	if err != nil {
+		return false, fmt.Errorf("isCurrentFormat: %w", err)
+	} else if !ok {
+		return false, nil
+	}
+	version, err := strconv.Atoi(value)
+	if err != nil {
+		return false, fmt.Errorf("isCurrentFormat: invalid repository format version '%v'", value)
+	}
+	return version >= repositoryFormatVersion, nil
+}
+
+// shardLooseObjects moves loose objects stored directly in the objects directory, as older
+// repositories store them, into their subdirectories. Does nothing if there is no objects
+// directory. Callers hold the repository lock.
+func shardLooseObjects() error {
+	files, err := getFilenames(objectsDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("shardLooseObjects: %w", err)
+	}
+	for _, file := range files {
+		if !isHash(file) {
+			continue
+		}
+		target, err := makeObjectDir(file)
+		if err != nil {
+			return fmt.Errorf("shardLooseObjects: %w", err)
+		}
+		if err := repoFS.Rename(filepath.Join(objectsDir, file), target); err != nil {
+			return fmt.Errorf("shardLooseObjects: %w", err)
+		}
+	}
+	return nil
+}
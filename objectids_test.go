@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestResolveHash(t *testing.T) {
+	setupTestRepo(t)
+	hash, err := resolveHash(initialCommitHash[:6])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash != initialCommitHash {
+		t.Fatalf("Incorrect resolved hash: want %v, got %v", initialCommitHash, hash)
+	}
+
+	// objects written after the index was built are still found
+	if err := writeContents("wug.txt", []string{"This is a wug"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stageFile("wug.txt"); err != nil {
+		t.Fatal(err)
+	}
+	index, err := readIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stagedHash := index["wug.txt"].Hash
+	if hash, err = resolveHash(stagedHash[:8]); err != nil {
+		t.Fatal(err)
+	} else if hash != stagedHash {
+		t.Fatalf("Incorrect resolved hash: want %v, got %v", stagedHash, hash)
+	}
+
+	if _, err := resolveHash("0000000"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("want fs.ErrNotExist for unknown prefix, got %v", err)
+	}
+	if _, err := resolveHash("zz"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("want fs.ErrNotExist for non-hex prefix, got %v", err)
+	}
+	if _, err := resolveHash(""); err == nil {
+		t.Fatal("Empty prefix resolved, want ambiguous hash prefix error.")
+	}
+}
+
+func TestUpgradeRepository(t *testing.T) {
+	setupTestRepo(t)
+	if ok, err := isCurrentFormat(); err != nil || !ok {
+		t.Fatalf("New repository should be in the current format: %v, %v", ok, err)
+	}
+	// older repositories record no format version and store loose objects directly in the
+	// objects directory
+	if _, err := unsetConfig("core.repositoryFormatVersion"); err != nil {
+		t.Fatal(err)
+	}
+	legacyFile := filepath.Join(objectsDir, initialCommitHash)
+	if err := repoFS.Rename(objectPath(initialCommitHash), legacyFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := upgradeRepository(); err != nil {
+		t.Fatal(err)
+	}
+	if version, _, err := getConfig("core.repositoryFormatVersion"); err != nil || version != strconv.Itoa(repositoryFormatVersion) {
+		t.Fatalf("Upgrade should record the format version: %q, %v", version, err)
+	}
+	if _, err := repoFS.Stat(repoLockFile); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Upgrade should release the repository lock: %v", err)
+	}
+	if _, err := repoFS.Stat(legacyFile); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Legacy object file was not moved: %v", err)
+	}
+	expected := filepath.Join(objectsDir, initialCommitHash[:2], initialCommitHash[2:])
+	if _, err := repoFS.Stat(expected); err != nil {
+		t.Fatalf("Object was not moved into its subdirectory: %v", err)
+	}
+	if hashes, err := getLooseObjectHashes(); err != nil || len(hashes) != 1 || hashes[0] != initialCommitHash {
+		t.Fatalf("Incorrect loose objects: %v, %v", hashes, err)
+	}
+	if _, err := getCommit(initialCommitHash[:6]); err != nil {
+		t.Fatal(err)
+	}
+
+	// upgraded repositories are not upgraded again
+	if err := repoFS.Rename(objectPath(initialCommitHash), legacyFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := upgradeRepository(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repoFS.Stat(legacyFile); err != nil {
+		t.Fatalf("Upgraded repository should not be upgraded again: %v", err)
+	}
+}
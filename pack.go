@@ -0,0 +1,678 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Pack files store many objects in one file. Each pack has an index of the objects it
+// contains, and the multi-pack index merges every pack index into a single sorted table
+// so an object can be found with one binary search no matter how many packs exist.
+//
+//	pack:             magic | version | count | payloads... | checksum
+//	pack index:       magic | version | count | (hash, offset, length)... | pack checksum
+//	multi-pack index: magic | version | pack count | count | pack checksums... |
+//	                  (hash, pack number, offset, length)... | checksum
+const (
+	packMagic                string = "GPAK"
+	packIndexMagic           string = "GPIX"
+	multiPackIndexMagic      string = "GMPX"
+	packVersion              uint32 = 1
+	packHeaderSize           int    = 12
+	multiPackIndexHeaderSize int    = 16
+	hashSize                 int    = sha1.Size
+	packIndexRecordSize      int    = hashSize + 8 + 8
+	multiPackIndexRecordSize int    = hashSize + 4 + 8 + 8
+)
+
+var (
+	packDir            string = filepath.Join(objectsDir, "pack")
+	multiPackIndexFile string = filepath.Join(packDir, "multi-pack-index")
+)
+
+// packEntry locates an object inside a pack file.
+type packEntry struct {
+	Hash   string // Hash of the object.
+	Pack   int    // Position of the pack in the multi-pack index.
+	Offset uint64 // Offset of the object payload in the pack file.
+	Length uint64 // Length of the object payload.
+}
+
+// packFilename returns the pack file name for a pack checksum.
+func packFilename(checksum string) string {
+	return "pack-" + checksum + ".pack"
+}
+
+// packIndexFilename returns the pack index file name for a pack checksum.
+func packIndexFilename(checksum string) string {
+	return "pack-" + checksum + ".idx"
+}
+
+// getLooseObjectHashes returns the sorted hashes of the loose objects, which are not
+// packed.
+func getLooseObjectHashes() ([]string, error) {
+	s := getStorage()
+	hashes, err := s.findObjects("", math.MaxInt)
+	if err != nil {
+		return nil, fmt.Errorf("getLooseObjectHashes: %w", err)
+	}
+	return hashes, nil
+}
+
+// getObjectHashes returns the sorted hashes of every loose and packed object.
+func getObjectHashes() ([]string, error) {
+	hashes, err := getLooseObjectHashes()
+	if err != nil {
+		return nil, fmt.Errorf("getObjectHashes: %w", err)
+	}
+	midx, err := openMultiPackIndex()
+	if err != nil {
+		return nil, fmt.Errorf("getObjectHashes: %w", err)
+	}
+	if midx != nil {
+		for i := 0; i < midx.count; i++ {
+			hashes = append(hashes, midx.entry(i).Hash)
+		}
+		slices.Sort(hashes)
+		hashes = slices.Compact(hashes)
+	}
+	return hashes, nil
+}
+
+// hasObject reports whether an object exists as a loose object or in a pack.
+func hasObject(hash string) (bool, error) {
+	s := getStorage()
+	if _, err := s.statObject(hash); err == nil {
+		return true, nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return false, fmt.Errorf("hasObject: %w", err)
+	}
+	midx, err := openMultiPackIndex()
+	if err != nil {
+		return false, fmt.Errorf("hasObject: %w", err)
+	}
+	if midx == nil {
+		return false, nil
+	}
+	_, ok := midx.find(hash)
+	return ok, nil
+}
+
+// readObjectPayload returns the stored bytes of an object, its header and contents,
+// from either a loose object or a pack.
+func readObjectPayload(hash string) ([]byte, error) {
+	r, err := openLooseObject(hash)
+	if err == nil {
+		defer r.Close()
+		payload, err := io.ReadAll(r)
+		if err != nil {
+			return nil, fmt.Errorf("readObjectPayload: %w", err)
+		}
+		return payload, nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("readObjectPayload: %w", err)
+	}
+	packed, packErr := readPackedObject(hash)
+	if packErr != nil {
+		return nil, fmt.Errorf("readObjectPayload: %w", packErr)
+	}
+	if packed == nil {
+		return nil, fmt.Errorf("readObjectPayload: %w", err)
+	}
+	return bytes.Clone(packed), nil
+}
+
+// readPackedObject returns the payload of a packed object, or nil if no pack contains it.
+// The payload refers to the memory-mapped pack file and must not be modified.
+func readPackedObject(hash string) ([]byte, error) {
+	midx, err := openMultiPackIndex()
+	if err != nil {
+		return nil, fmt.Errorf("readPackedObject: %w", err)
+	}
+	if midx == nil {
+		return nil, nil
+	}
+	e, ok := midx.find(hash)
+	if !ok {
+		return nil, nil
+	}
+	payload, err := midx.read(e)
+	if err != nil {
+		return nil, fmt.Errorf("readPackedObject: %w", err)
+	}
+	return payload, nil
+}
+
+// writePack writes the given loose or packed objects into a new pack file and pack index.
+// Returns the checksum that names the pack.
+func writePack(hashes []string) (string, error) {
+	if err := repoFS.MkdirAll(packDir, 0755); err != nil {
+		return "", fmt.Errorf("writePack: %w", err)
+	}
+	tmpPackFile := filepath.Join(packDir, fmt.Sprintf("tmp-%d.pack", os.Getpid()))
+	f, err := repoFS.OpenFile(tmpPackFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return "", fmt.Errorf("writePack: %w", err)
+	}
+	defer repoFS.Remove(tmpPackFile)
+	defer f.Close()
+
+	h := sha1.New()
+	w := io.MultiWriter(f, h)
+	header := make([]byte, packHeaderSize)
+	copy(header, packMagic)
+	binary.BigEndian.PutUint32(header[4:], packVersion)
+	binary.BigEndian.PutUint32(header[8:], uint32(len(hashes)))
+	if _, err := w.Write(header); err != nil {
+		return "", fmt.Errorf("writePack: %w", err)
+	}
+
+	entries := make([]packEntry, 0, len(hashes))
+	offset := uint64(packHeaderSize)
+	for _, hash := range hashes {
+		payload, err := readObjectPayload(hash)
+		if err != nil {
+			return "", fmt.Errorf("writePack: %w", err)
+		}
+		if _, err := w.Write(payload); err != nil {
+			return "", fmt.Errorf("writePack: %w", err)
+		}
+		entries = append(entries, packEntry{Hash: hash, Offset: offset, Length: uint64(len(payload))})
+		offset += uint64(len(payload))
+	}
+	sum := h.Sum(nil)
+	if _, err := f.Write(sum); err != nil {
+		return "", fmt.Errorf("writePack: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		return "", fmt.Errorf("writePack: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return "", fmt.Errorf("writePack: %w", err)
+	}
+
+	checksum := hex.EncodeToString(sum)
+	if err := writePackIndex(checksum, entries); err != nil {
+		return "", fmt.Errorf("writePack: %w", err)
+	}
+	if err := repoFS.Rename(tmpPackFile, filepath.Join(packDir, packFilename(checksum))); err != nil {
+		return "", fmt.Errorf("writePack: %w", err)
+	}
+	return checksum, nil
+}
+
+// writePackIndex writes the sorted index of the objects in a pack.
+func writePackIndex(checksum string, entries []packEntry) error {
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Hash < entries[j].Hash })
+	b := make([]byte, packHeaderSize, packHeaderSize+len(entries)*packIndexRecordSize+hashSize)
+	copy(b, packIndexMagic)
+	binary.BigEndian.PutUint32(b[4:], packVersion)
+	binary.BigEndian.PutUint32(b[8:], uint32(len(entries)))
+	for _, e := range entries {
+		raw, err := hex.DecodeString(e.Hash)
+		if err != nil {
+			return fmt.Errorf("writePackIndex: %w", err)
+		}
+		b = append(b, raw...)
+		b = binary.BigEndian.AppendUint64(b, e.Offset)
+		b = binary.BigEndian.AppendUint64(b, e.Length)
+	}
+	sum, err := hex.DecodeString(checksum)
+	if err != nil {
+		return fmt.Errorf("writePackIndex: %w", err)
+	}
+	b = append(b, sum...)
+	if err := writeFileAtomic(filepath.Join(packDir, packIndexFilename(checksum)), b); err != nil {
+		return fmt.Errorf("writePackIndex: %w", err)
+	}
+	return nil
+}
+
+// readPackIndex returns the entries of a pack index file.
+func readPackIndex(file string) ([]packEntry, error) {
+	b, err := readFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("readPackIndex: %w", err)
+	}
+	if len(b) < packHeaderSize+hashSize || string(b[:4]) != packIndexMagic {
+		return nil, fmt.Errorf("readPackIndex: '%v' is not a pack index", file)
+	}
+	count := int(binary.BigEndian.Uint32(b[8:]))
+	if len(b) != packHeaderSize+count*packIndexRecordSize+hashSize {
+		return nil, fmt.Errorf("readPackIndex: '%v' is truncated", file)
+	}
+	entries := make([]packEntry, count)
+	for i := range entries {
+		r := b[packHeaderSize+i*packIndexRecordSize:]
+		entries[i] = packEntry{
+			Hash:   hex.EncodeToString(r[:hashSize]),
+			Offset: binary.BigEndian.Uint64(r[hashSize:]),
+			Length: binary.BigEndian.Uint64(r[hashSize+8:]),
+		}
+	}
+	return entries, nil
+}
+
+// getPackChecksums returns the checksums of every pack that has an index, sorted.
+func getPackChecksums() ([]string, error) {
+	files, err := getFilenames(packDir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("getPackChecksums: %w", err)
+	}
+	var checksums []string
+	for _, file := range files {
+		if checksum, ok := strings.CutPrefix(file, "pack-"); ok && strings.HasSuffix(checksum, ".idx") {
+			checksums = append(checksums, strings.TrimSuffix(checksum, ".idx"))
+		}
+	}
+	return checksums, nil
+}
+
+// writeMultiPackIndex merges the indexes of every pack into the multi-pack index.
+// Objects stored in more than one pack are indexed from the first pack listed.
+func writeMultiPackIndex() error {
+	checksums, err := getPackChecksums()
+	if err != nil {
+		return fmt.Errorf("writeMultiPackIndex: %w", err)
+	}
+	var entries []packEntry
+	seen := make(map[string]bool)
+	for i, checksum := range checksums {
+		packEntries, err := readPackIndex(filepath.Join(packDir, packIndexFilename(checksum)))
+		if err != nil {
+			return fmt.Errorf("writeMultiPackIndex: %w", err)
+		}
+		for _, e := range packEntries {
+			if seen[e.Hash] {
+				continue
+			}
+			seen[e.Hash] = true
+			e.Pack = i
+			entries = append(entries, e)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Hash < entries[j].Hash })
+
+	b := make([]byte, multiPackIndexHeaderSize)
+	copy(b, multiPackIndexMagic)
+	binary.BigEndian.PutUint32(b[4:], packVersion)
+	binary.BigEndian.PutUint32(b[8:], uint32(len(checksums)))
+	binary.BigEndian.PutUint32(b[12:], uint32(len(entries)))
+	for _, checksum := range checksums {
+		raw, err := hex.DecodeString(checksum)
+		if err != nil {
+			return fmt.Errorf("writeMultiPackIndex: %w", err)
+		}
+		b = append(b, raw...)
+	}
+	for _, e := range entries {
+		raw, err := hex.DecodeString(e.Hash)
+		if err != nil {
+			return fmt.Errorf("writeMultiPackIndex: %w", err)
+		}
+		b = append(b, raw...)
+		b = binary.BigEndian.AppendUint32(b, uint32(e.Pack))
+		b = binary.BigEndian.AppendUint64(b, e.Offset)
+		b = binary.BigEndian.AppendUint64(b, e.Length)
+	}
+	sum := sha1.Sum(b)
+	b = append(b, sum[:]...)
+	if err := writeFileAtomic(multiPackIndexFile, b); err != nil {
+		return fmt.Errorf("writeMultiPackIndex: %w", err)
+	}
+	return nil
+}
+
+// multiPackIndex is a memory-mapped multi-pack index.
+type multiPackIndex struct {
+	mapping  []byte
+	packs    []string // Pack checksums, in pack number order.
+	count    int      // Number of indexed objects.
+	records  []byte   // Sorted fixed-width object records.
+	packMaps [][]byte // Memory-mapped pack files, mapped on first use.
+	mapped   bool     // Whether the index and pack files are mapped rather than read into memory.
+	modTime  time.Time
+	size     int64
+}
+
+var (
+	// loadedMultiPackIndex is the multi-pack index opened by this process, if any.
+	loadedMultiPackIndex *multiPackIndex
+	// packsMu guards loadedMultiPackIndex and its lazily mapped pack files.
+	packsMu sync.Mutex
+)
+
+// openMultiPackIndex returns the multi-pack index, or nil if the repository has no packs.
+// The index is mapped once per process and remapped if it is rewritten.
+func openMultiPackIndex() (*multiPackIndex, error) {
+	packsMu.Lock()
+	defer packsMu.Unlock()
+	fileInfo, err := repoFS.Stat(multiPackIndexFile)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("openMultiPackIndex: %w", err)
+	}
+	if m := loadedMultiPackIndex; m != nil && m.modTime.Equal(fileInfo.ModTime()) && m.size == fileInfo.Size() {
+		return m, nil
+	}
+	// earlier mappings are left in place since packed contents may still be in use
+	f, err := repoFS.Open(multiPackIndexFile)
+	if err != nil {
+		return nil, fmt.Errorf("openMultiPackIndex: %w", err)
+	}
+	defer f.Close()
+	mapping, mapped, err := mapFile(f, int(fileInfo.Size()))
+	if err != nil {
+		return nil, fmt.Errorf("openMultiPackIndex: %w", err)
+	}
+	unmap := func() {
+		if mapped {
+			munmapFile(mapping)
+		}
+	}
+	if len(mapping) < multiPackIndexHeaderSize+hashSize || string(mapping[:4]) != multiPackIndexMagic {
+		unmap()
+		return nil, errors.New("openMultiPackIndex: multi-pack index is corrupt")
+	}
+	packCount := int(binary.BigEndian.Uint32(mapping[8:]))
+	count := int(binary.BigEndian.Uint32(mapping[12:]))
+	recordsStart := multiPackIndexHeaderSize + packCount*hashSize
+	if len(mapping) != recordsStart+count*multiPackIndexRecordSize+hashSize {
+		unmap()
+		return nil, errors.New("openMultiPackIndex: multi-pack index is truncated")
+	}
+	m := &multiPackIndex{
+		mapping:  mapping,
+		count:    count,
+		records:  mapping[recordsStart : recordsStart+count*multiPackIndexRecordSize],
+		packMaps: make([][]byte, packCount),
+		mapped:   mapped,
+		modTime:  fileInfo.ModTime(),
+		size:     fileInfo.Size(),
+	}
+	for i := 0; i < packCount; i++ {
+		start := multiPackIndexHeaderSize + i*hashSize
+		m.packs = append(m.packs, hex.EncodeToString(mapping[start:start+hashSize]))
+	}
+	loadedMultiPackIndex = m
+	return m, f.Close()
+}
+
+// mapFile maps the first size bytes of a file into memory, or reads them if the file is not
+// kept on disk. Reports whether they were mapped, and so must be released with munmapFile.
+func mapFile(f file, size int) ([]byte, bool, error) {
+	if osFile, ok := f.(*os.File); ok {
+		b, err := mmapFile(osFile, size)
+		if err != nil {
+			return nil, false, fmt.Errorf("mapFile: %w", err)
+		}
+		return b, true, nil
+	}
+	b := make([]byte, size)
+	if _, err := io.ReadFull(f, b); err != nil {
+		return nil, false, fmt.Errorf("mapFile: %w", err)
+	}
+	return b, false, nil
+}
+
+// closePacks unmaps the multi-pack index and pack files opened by this process.
+// Contents previously read from packs must no longer be used.
+func closePacks() error {
+	packsMu.Lock()
+	defer packsMu.Unlock()
+	m := loadedMultiPackIndex
+	if m == nil {
+		return nil
+	}
+	loadedMultiPackIndex = nil
+	if !m.mapped {
+		return nil
+	}
+	errs := []error{munmapFile(m.mapping)}
+	for _, packMap := range m.packMaps {
+		if packMap != nil {
+			errs = append(errs, munmapFile(packMap))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// entry returns the i-th object record of the multi-pack index.
+func (m *multiPackIndex) entry(i int) packEntry {
+	r := m.records[i*multiPackIndexRecordSize:]
+	return packEntry{
+		Hash:   hex.EncodeToString(r[:hashSize]),
+		Pack:   int(binary.BigEndian.Uint32(r[hashSize:])),
+		Offset: binary.BigEndian.Uint64(r[hashSize+4:]),
+		Length: binary.BigEndian.Uint64(r[hashSize+12:]),
+	}
+}
+
+// search returns the position of the first record with a hash not less than the given hex prefix.
+func (m *multiPackIndex) search(prefix string) int {
+	return sort.Search(m.count, func(i int) bool {
+		r := m.records[i*multiPackIndexRecordSize : i*multiPackIndexRecordSize+hashSize]
+		return hex.EncodeToString(r) >= prefix
+	})
+}
+
+// find returns the pack entry of an object in O(log n).
+func (m *multiPackIndex) find(hash string) (packEntry, bool) {
+	if i := m.search(hash); i < m.count {
+		if e := m.entry(i); e.Hash == hash {
+			return e, true
+		}
+	}
+	return packEntry{}, false
+}
+
+// findPrefix returns up to limit packed object hashes starting with the given prefix.
+func (m *multiPackIndex) findPrefix(prefix string, limit int) []string {
+	var matched []string
+	for i := m.search(prefix); i < m.count && len(matched) < limit; i++ {
+		e := m.entry(i)
+		if !strings.HasPrefix(e.Hash, prefix) {
+			break
+		}
+		matched = append(matched, e.Hash)
+	}
+	return matched
+}
+
+// read returns the payload of a packed object from the memory-mapped pack file.
+func (m *multiPackIndex) read(e packEntry) ([]byte, error) {
+	packsMu.Lock()
+	defer packsMu.Unlock()
+	if m.packMaps[e.Pack] == nil {
+		f, err := repoFS.Open(filepath.Join(packDir, packFilename(m.packs[e.Pack])))
+		if err != nil {
+			return nil, fmt.Errorf("read: %w", err)
+		}
+		defer f.Close()
+		fileInfo, err := f.Stat()
+		if err != nil {
+			return nil, fmt.Errorf("read: %w", err)
+		}
+		packMap, _, err := mapFile(f, int(fileInfo.Size()))
+		if err != nil {
+			return nil, fmt.Errorf("read: %w", err)
+		}
+		m.packMaps[e.Pack] = packMap
+	}
+	packMap := m.packMaps[e.Pack]
+	if e.Offset+e.Length > uint64(len(packMap)) {
+		return nil, fmt.Errorf("read: object %v lies outside pack %v", e.Hash, m.packs[e.Pack])
+	}
+	return packMap[e.Offset : e.Offset+e.Length], nil
+}
+
+// repackIncremental moves every loose object that is not already packed into a new pack
+// and updates the multi-pack index. Existing packs are left untouched.
+// Returns the number of objects packed.
+func repackIncremental() (int, error) {
+	unlock, err := lockRepo("repack")
+	if err != nil {
+		return 0, fmt.Errorf("repackIncremental: %w", err)
+	}
+	defer unlock()
+	hashes, err := getLooseObjectHashes()
+	if err != nil {
+		return 0, fmt.Errorf("repackIncremental: %w", err)
+	}
+	midx, err := openMultiPackIndex()
+	if err != nil {
+		return 0, fmt.Errorf("repackIncremental: %w", err)
+	}
+	var unpacked, alreadyPacked []string
+	for _, hash := range hashes {
+		if midx != nil {
+			if _, ok := midx.find(hash); ok {
+				alreadyPacked = append(alreadyPacked, hash)
+				continue
+			}
+		}
+		unpacked = append(unpacked, hash)
+	}
+
+	if len(unpacked) > 0 {
+		if _, err := writePack(unpacked); err != nil {
+			return 0, fmt.Errorf("repackIncremental: %w", err)
+		}
+		if err := writeMultiPackIndex(); err != nil {
+			return 0, fmt.Errorf("repackIncremental: %w", err)
+		}
+	}
+	// loose copies are only removed once the multi-pack index refers to their packs
+	for _, hash := range append(unpacked, alreadyPacked...) {
+		if err := removeObject(hash); err != nil {
+			return 0, fmt.Errorf("repackIncremental: %w", err)
+		}
+	}
+	if err := removeEmptyObjectDirs(); err != nil {
+		return 0, fmt.Errorf("repackIncremental: %w", err)
+	}
+	return len(unpacked), nil
+}
+
+// repackAll consolidates every reachable object, loose or packed, into a single new pack
+// that replaces all existing packs. Unreachable packed objects are written back as loose
+// objects, so that gc can delete them once their grace period is over.
+// Returns the number of objects packed.
+func repackAll() (int, error) {
+	unlock, err := lockRepo("repack")
+	if err != nil {
+		return 0, fmt.Errorf("repackAll: %w", err)
+	}
+	defer unlock()
+	reachable, err := getReachableObjects()
+	if err != nil {
+		return 0, fmt.Errorf("repackAll: %w", err)
+	}
+	hashes, err := getObjectHashes()
+	if err != nil {
+		return 0, fmt.Errorf("repackAll: %w", err)
+	}
+	loose, err := getLooseObjectHashes()
+	if err != nil {
+		return 0, fmt.Errorf("repackAll: %w", err)
+	}
+	oldChecksums, err := getPackChecksums()
+	if err != nil {
+		return 0, fmt.Errorf("repackAll: %w", err)
+	}
+	var packed []string
+	for _, hash := range hashes {
+		if reachable[hash] {
+			packed = append(packed, hash)
+			continue
+		}
+		if _, isLoose := slices.BinarySearch(loose, hash); isLoose {
+			continue
+		}
+		payload, err := readObjectPayload(hash)
+		if err != nil {
+			return 0, fmt.Errorf("repackAll: %w", err)
+		}
+		if err := writeLooseObject(hash, [][]byte{payload}); err != nil {
+			return 0, fmt.Errorf("repackAll: %w", err)
+		}
+	}
+	checksum, err := writePack(packed)
+	if err != nil {
+		return 0, fmt.Errorf("repackAll: %w", err)
+	}
+	// old packs are dropped from the multi-pack index before their files are deleted
+	var oldPackFiles []string
+	for _, oldChecksum := range oldChecksums {
+		if oldChecksum == checksum {
+			continue
+		}
+		if err := repoFS.Remove(filepath.Join(packDir, packIndexFilename(oldChecksum))); err != nil {
+			return 0, fmt.Errorf("repackAll: %w", err)
+		}
+		oldPackFiles = append(oldPackFiles, filepath.Join(packDir, packFilename(oldChecksum)))
+	}
+	if err := writeMultiPackIndex(); err != nil {
+		return 0, fmt.Errorf("repackAll: %w", err)
+	}
+	for _, file := range oldPackFiles {
+		if err := repoFS.Remove(file); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return 0, fmt.Errorf("repackAll: %w", err)
+		}
+	}
+	for _, hash := range loose {
+		if reachable[hash] {
+			if err := removeObject(hash); err != nil {
+				return 0, fmt.Errorf("repackAll: %w", err)
+			}
+		}
+	}
+	if err := removeEmptyObjectDirs(); err != nil {
+		return 0, fmt.Errorf("repackAll: %w", err)
+	}
+	return len(packed), nil
+}
+
+// removeEmptyObjectDirs deletes the subdirectories of the objects directory that hold no
+// loose objects, as packing leaves them.
+func removeEmptyObjectDirs() error {
+	dirs, err := getObjectDirs(objectsDir, "")
+	if err != nil {
+		return fmt.Errorf("removeEmptyObjectDirs: %w", err)
+	}
+	for _, dir := range dirs {
+		dir = filepath.Join(objectsDir, dir)
+		entries, err := repoFS.ReadDir(dir)
+		if err != nil {
+			return fmt.Errorf("removeEmptyObjectDirs: %w", err)
+		}
+		if len(entries) != 0 {
+			continue
+		}
+		// an object written since the directory was read keeps it
+		if err := repoFS.Remove(dir); err != nil && !errors.Is(err, syscall.ENOTEMPTY) && !errors.Is(err, fs.ErrExist) {
+			return fmt.Errorf("removeEmptyObjectDirs: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestRepackIncremental(t *testing.T) {
+	setupTestRepo(t)
+	if err := writeContents("wug.txt", []string{"This is a wug"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stageFile("wug.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := newCommit("add wug file"); err != nil {
+		t.Fatal(err)
+	}
+
+	count, err := repackIncremental()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// initial commit, wug file, wug tree, wug commit
+	if count != 4 {
+		t.Fatalf("Incorrect number of packed objects: want 4, got %v", count)
+	}
+	loose, err := getLooseObjectHashes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loose) != 0 {
+		t.Fatalf("Loose objects remain after repacking: %v", loose)
+	}
+	if dirs, err := getObjectDirs(objectsDir, ""); err != nil || len(dirs) != 0 {
+		t.Fatalf("Emptied object directories remain after repacking: %v, %v", dirs, err)
+	}
+
+	// packed objects are still readable and resolvable
+	resetObjectCache()
+	headCommit, err := getHeadCommit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if headCommit.Message != "add wug file" {
+		t.Fatalf("Incorrect packed commit message: %v", headCommit.Message)
+	}
+	if hash, err := resolveHash(initialCommitHash[:6]); err != nil || hash != initialCommitHash {
+		t.Fatalf("Could not resolve packed object: %v, %v", hash, err)
+	}
+	if err := restrictedDelete("wug.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkoutHeadCommit("wug.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if contents, err := readContentsAsString("wug.txt"); err != nil || contents != "This is a wug" {
+		t.Fatalf("Incorrect checkout of packed file: %v, %v", contents, err)
+	}
+
+	// a second incremental repack only packs new objects into a new pack
+	if err := writeContents("wug.txt", []string{"This is a wug!"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stageFile("wug.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := newCommit("modify wug file"); err != nil {
+		t.Fatal(err)
+	}
+	if count, err = repackIncremental(); err != nil {
+		t.Fatal(err)
+	} else if count != 3 {
+		t.Fatalf("Incorrect number of packed objects: want 3, got %v", count)
+	}
+	checksums, err := getPackChecksums()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(checksums) != 2 {
+		t.Fatalf("Incorrect number of packs: want 2, got %v", len(checksums))
+	}
+	hashes, err := getObjectHashes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hashes) != 7 {
+		t.Fatalf("Incorrect number of objects across packs: want 7, got %v", len(hashes))
+	}
+	for _, hash := range hashes {
+		if ok, err := hasObject(hash); err != nil || !ok {
+			t.Fatalf("Packed object %v not found: %v", hash, err)
+		}
+	}
+
+	// nothing left to pack
+	if count, err = repackIncremental(); err != nil || count != 0 {
+		t.Fatalf("Repacking without loose objects: want 0, got %v, %v", count, err)
+	}
+	if _, err := repoFS.Stat(filepath.Join(packDir, packFilename(checksums[0]))); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRepackAll(t *testing.T) {
+	setupTestRepo(t)
+	commitInRepo(t, ".", "wug.txt", "This is a wug")
+	packedOrphan, err := writeBlob("file", []byte("This is a packed orphan"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repackIncremental(); err != nil {
+		t.Fatal(err)
+	}
+	commitInRepo(t, ".", "wug.txt", "This is a wug!")
+	looseOrphan, err := writeBlob("file", []byte("This is a loose orphan"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, err := getObjectHashes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// initial commit, two wug files, two wug trees, two wug commits
+	if count, err := repackAll(); err != nil || count != 7 {
+		t.Fatalf("Incorrect number of packed objects: want 7, got %v, %v", count, err)
+	}
+	checksums, err := getPackChecksums()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(checksums) != 1 {
+		t.Fatalf("Incorrect number of packs: want 1, got %v", checksums)
+	}
+	files, err := getFilenames(packDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"multi-pack-index", packIndexFilename(checksums[0]), packFilename(checksums[0])}; !slices.Equal(files, expected) {
+		t.Fatalf("Old packs remain after repacking: want %v, got %v", expected, files)
+	}
+	// unreachable objects are left loose for gc to delete
+	loose, err := getLooseObjectHashes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{looseOrphan, packedOrphan}
+	slices.Sort(expected)
+	if !slices.Equal(loose, expected) {
+		t.Fatalf("Incorrect loose objects after repacking: want %v, got %v", expected, loose)
+	}
+	var expectedDirs []string
+	for _, hash := range expected {
+		expectedDirs = append(expectedDirs, hash[:objectDirPrefixLength])
+	}
+	expectedDirs = slices.Compact(expectedDirs)
+	if dirs, err := getObjectDirs(objectsDir, ""); err != nil || !slices.Equal(dirs, expectedDirs) {
+		t.Fatalf("Only the directories of loose objects should remain: want %v, got %v, %v", expectedDirs, dirs, err)
+	}
+	resetObjectCache()
+	for _, hash := range before {
+		if ok, err := hasObject(hash); err != nil || !ok {
+			t.Fatalf("Object %v lost by repacking: %v", hash, err)
+		}
+	}
+	if headCommit, err := getHeadCommit(); err != nil || headCommit.Message != "add wug.txt" {
+		t.Fatalf("Incorrect repacked head commit: %v, %v", headCommit, err)
+	}
+}
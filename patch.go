@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Longest subject slug used in the names of patch files.
+const patchSlugLength int = 52
+
+// getPatchCommits returns the commits on the first-parent history of the end commit that are
+// not ancestors of the start commit, oldest first. Merge commits are skipped, since their
+// changes cannot be expressed as a single diff.
+func getPatchCommits(startHash string, endHash string) ([]string, error) {
+	excluded, err := getAncestors(startHash)
+	if err != nil {
+		return nil, fmt.Errorf("getPatchCommits: %w", err)
+	}
+	var commitHashes []string
+	for hash := endHash; hash != "" && !excluded[hash]; {
+		c, err := getCommit(hash)
+		if err != nil {
+			return nil, fmt.Errorf("getPatchCommits: %w", err)
+		}
+		if c.ParentUIDs[0] != "" && c.ParentUIDs[1] == "" {
+			commitHashes = append(commitHashes, hash)
+		}
+		hash = c.ParentUIDs[0]
+	}
+	slices.Reverse(commitHashes)
+	return commitHashes, nil
+}
+
+// formatPatch formats a commit as a patch: a mail-style header with its author, date, and
+// subject, the rest of its message, a summary of the changed files, and the unified diff
+// against its first parent. The patch is numbered n of total.
+func formatPatch(commitHash string, n int, total int) (string, error) {
+	c, err := getCommit(commitHash)
+	if err != nil {
+		return "", fmt.Errorf("formatPatch: %w", err)
+	}
+	diffs, err := diffCommits(c.ParentUIDs[0], commitHash)
+	if err != nil {
+		return "", fmt.Errorf("formatPatch: %w", err)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "From %v\n", commitHash)
+	if c.Author != "" {
+		fmt.Fprintf(&b, "From: %v\n", c.Author)
+	}
+	fmt.Fprintf(&b, "Date: %v\n", time.Unix(c.Timestamp, 0).UTC().Format(time.RFC1123Z))
+	subject, body, _ := strings.Cut(c.Message, "\n")
+	fmt.Fprintf(&b, "Subject: [PATCH %v/%v] %v\n\n", n, total, subject)
+	if body = strings.TrimSpace(body); body != "" {
+		fmt.Fprintf(&b, "%v\n\n", body)
+	}
+	fmt.Fprintf(&b, "---\n%v\n", formatDiffStat(diffs))
+	for _, d := range diffs {
+		b.WriteString(d.String())
+	}
+	return b.String(), nil
+}
+
+// patchFileName returns the name of the file for the nth patch: its number followed by a
+// slug of its subject.
+func patchFileName(n int, subject string) string {
+	var slug strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(subject) {
+		if ('a' <= r && r <= 'z') || ('0' <= r && r <= '9') {
+			if dash && slug.Len() > 0 {
+				slug.WriteByte('-')
+			}
+			slug.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+		if slug.Len() >= patchSlugLength {
+			break
+		}
+	}
+	return fmt.Sprintf("%04d-%v.patch", n, slug.String())
+}
+
+// writePatches writes a patch file for each commit in a range to the output directory, and
+// prints the names of the files. The range is either "<start>..<end>", or a single revision
+// meaning the commits from it to HEAD.
+func writePatches(revRange string, outputDir string) error {
+	startRev, endRev, ok := strings.Cut(revRange, "..")
+	if !ok || endRev == "" {
+		endRev = "HEAD"
+	}
+	commitHashes := make([]string, 2)
+	for i, rev := range []string{startRev, endRev} {
+		hash, err := resolveRevision(rev)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				exitf("No commit or branch named '%v' exists.", rev)
+			}
+			return fmt.Errorf("writePatches: %w", err)
+		}
+		commitHashes[i] = hash
+	}
+	patchCommits, err := getPatchCommits(commitHashes[0], commitHashes[1])
+	if err != nil {
+		return fmt.Errorf("writePatches: %w", err)
+	}
+	if err := repoFS.MkdirAll(outputDir, 0755); err != nil {
+		return fmt.Errorf("writePatches: %w", err)
+	}
+	for i, hash := range patchCommits {
+		patch, err := formatPatch(hash, i+1, len(patchCommits))
+		if err != nil {
+			return fmt.Errorf("writePatches: %w", err)
+		}
+		c, err := getCommit(hash)
+		if err != nil {
+			return fmt.Errorf("writePatches: %w", err)
+		}
+		file := filepath.Join(outputDir, patchFileName(i+1, c.Subject()))
+		if err := writeContents(file, []string{patch}); err != nil {
+			return fmt.Errorf("writePatches: %w", err)
+		}
+		log.Println(file)
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestWritePatches(t *testing.T) {
+	setupTestRepo(t)
+	var hashes []string
+	for _, version := range [][]string{
+		{"one\n", "two\n"},
+		{"one\n", "2\n"},
+	} {
+		if err := writeContents("wug.txt", version); err != nil {
+			t.Fatal(err)
+		}
+		if err := stageFile("wug.txt"); err != nil {
+			t.Fatal(err)
+		}
+		if err := newCommit("Update wug.txt\n\nWith a body."); err != nil {
+			t.Fatal(err)
+		}
+		hash, err := getHeadCommitHash()
+		if err != nil {
+			t.Fatal(err)
+		}
+		hashes = append(hashes, hash)
+	}
+	commitHashes, err := getPatchCommits(initialCommitHash, hashes[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(commitHashes, hashes) {
+		t.Errorf("Expected %v, got %v", hashes, commitHashes)
+	}
+
+	if err := writePatches(hashes[0], "patches"); err != nil {
+		t.Fatal(err)
+	}
+	files, err := repoFS.ReadDir("patches")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Name() != "0001-update-wug-txt.patch" {
+		t.Fatalf("Expected one patch file, got %v", files)
+	}
+	patch, err := readContentsAsString(filepath.Join("patches", files[0].Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"From " + hashes[1] + "\n",
+		"Subject: [PATCH 1/1] Update wug.txt\n\nWith a body.\n\n---\n",
+		"--- a/wug.txt\n+++ b/wug.txt\n@@ -1,2 +1,2 @@\n one\n-two\n+2",
+	} {
+		if !strings.Contains(patch, expected) {
+			t.Errorf("Patch does not contain %q:\n%v", expected, patch)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Suffix of a path that names every file under a directory, as in "src/...".
+const recursivePathSuffix string = "/..."
+
+// pathspecError is returned for a user-supplied path that cannot name a file in the repository.
+type pathspecError struct {
+	Path   string
+	Reason string
+}
+
+func (e *pathspecError) Error() string {
+	return fmt.Sprintf("invalid path '%v': %v", e.Path, e.Reason)
+}
+
+// normalizePath cleans a user-supplied path into the repository-relative, slash-separated
+// form used by the index and commits. Paths that are absolute, escape the repository root,
+// name the repository root itself, or lie under the .gitlet directory are rejected.
+func normalizePath(file string) (string, error) {
+	if file == "" {
+		return "", &pathspecError{file, "path is empty"}
+	}
+	if filepath.IsAbs(file) || filepath.VolumeName(file) != "" || strings.HasPrefix(filepath.ToSlash(file), "/") {
+		return "", &pathspecError{file, "path is absolute"}
+	}
+	cleaned := path.Clean(filepath.ToSlash(file))
+	if cleaned == "." {
+		return "", &pathspecError{file, "path is the repository root"}
+	}
+	if cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", &pathspecError{file, "path is outside the repository"}
+	}
+	if first, _, _ := strings.Cut(cleaned, "/"); first == gitletDir {
+		return "", &pathspecError{file, "path is inside the " + gitletDir + " directory"}
+	}
+	return cleaned, nil
+}
+
+// validateTrackedPath checks that a path read from a commit is already in normalized form,
+// so a commit from another repository cannot write outside the working directory.
+func validateTrackedPath(file string) error {
+	cleaned, err := normalizePath(file)
+	if err != nil {
+		return err
+	}
+	if cleaned != file {
+		return &pathspecError{file, "path is not in normalized form"}
+	}
+	return nil
+}
+
+// expandPaths expands user-supplied paths into the normalized files they name, sorted and
+// without duplicates. A path ending in "/..." names every file under the directory, and a
+// path with glob metacharacters names the files it matches, with "*" not matching "/".
+// Both also name tracked and staged files missing from the working directory, so that their
+// removal can be staged. Other paths name themselves, whether or not the file exists.
+// Returns a pathspecError if a pattern matches no files.
+func expandPaths(paths []string) ([]string, error) {
+	headCommit, err := getHeadCommit()
+	if err != nil {
+		return nil, fmt.Errorf("expandPaths: %w", err)
+	}
+	index, err := readIndex()
+	if err != nil {
+		return nil, fmt.Errorf("expandPaths: %w", err)
+	}
+	knownFiles := slices.Concat(sortedKeys(headCommit.FileToBlob), sortedKeys(index))
+
+	var files []string
+	for _, p := range paths {
+		dir, isRecursive := strings.CutSuffix(filepath.ToSlash(p), recursivePathSuffix)
+		if !isRecursive && !strings.ContainsAny(p, `*?[\`) {
+			file, err := normalizePath(p)
+			if err != nil {
+				return nil, fmt.Errorf("expandPaths: %w", err)
+			}
+			files = append(files, file)
+			continue
+		}
+
+		var matches []string
+		if isRecursive {
+			prefix := ""
+			if dir == "" || dir == "." {
+				dir = "."
+			} else if dir, err = normalizePath(dir); err != nil {
+				return nil, fmt.Errorf("expandPaths: %w", err)
+			} else {
+				prefix = dir + "/"
+			}
+			if info, err := repoFS.Stat(filepath.FromSlash(dir)); err == nil && info.IsDir() {
+				if matches, err = walkWorkingFiles(dir); err != nil {
+					return nil, fmt.Errorf("expandPaths: %w", err)
+				}
+			}
+			for _, file := range knownFiles {
+				if strings.HasPrefix(file, prefix) {
+					matches = append(matches, file)
+				}
+			}
+		} else {
+			pattern, err := normalizePath(p)
+			if err != nil {
+				return nil, fmt.Errorf("expandPaths: %w", err)
+			}
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, &pathspecError{p, "pattern is malformed"}
+			}
+			wdMatches, err := glob(filepath.FromSlash(pattern))
+			if err != nil {
+				return nil, fmt.Errorf("expandPaths: %w", err)
+			}
+			for _, match := range wdMatches {
+				if info, err := repoFS.Stat(match); err == nil && info.Mode().IsRegular() {
+					matches = append(matches, filepath.ToSlash(match))
+				}
+			}
+			for _, file := range knownFiles {
+				if ok, _ := path.Match(pattern, file); ok {
+					matches = append(matches, file)
+				}
+			}
+		}
+		if len(matches) == 0 {
+			return nil, &pathspecError{p, "pattern matches no files"}
+		}
+		files = append(files, matches...)
+	}
+	slices.Sort(files)
+	return slices.Compact(files), nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestNormalizePath(t *testing.T) {
+	for path, expected := range map[string]string{
+		"wug.txt":           "wug.txt",
+		"./wug.txt":         "wug.txt",
+		"dir//sub/../a.txt": "dir/a.txt",
+		"dir/.gitlet":       "dir/.gitlet",
+	} {
+		if actual, err := normalizePath(path); err != nil || actual != expected {
+			t.Fatalf("Incorrect normalized path for %v: want %v, got %v, %v", path, expected, actual, err)
+		}
+	}
+	for _, path := range []string{"", ".", "..", "../wug.txt", "dir/../../wug.txt", "/etc/passwd", ".gitlet", ".gitlet/HEAD", "./.gitlet/../.gitlet/INDEX"} {
+		var pathErr *pathspecError
+		if _, err := normalizePath(path); !errors.As(err, &pathErr) {
+			t.Fatalf("Invalid path %v was not rejected: %v", path, err)
+		}
+	}
+}
+
+func TestStageInvalidPath(t *testing.T) {
+	setupTestRepo(t)
+	var pathErr *pathspecError
+	if err := stageFile(".gitlet/HEAD"); !errors.As(err, &pathErr) {
+		t.Fatalf("Staging a file in the repository directory was not rejected: %v", err)
+	}
+	if err := materializeFiles(map[string]string{"../wug.txt": initialCommitHash}); !errors.As(err, &pathErr) {
+		t.Fatalf("Materializing a file outside the repository was not rejected: %v", err)
+	}
+}
+
+func TestExpandPaths(t *testing.T) {
+	setupTestRepo(t)
+	if err := repoFS.MkdirAll(filepath.Join("src", "x"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{"a.go", "b.go", "c.txt", "src/d.go", "src/x/e.go"} {
+		if err := writeContents(file, []string{file}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a tracked file deleted from the working directory still matches
+	commitInRepo(t, ".", "deleted.go", "deleted")
+	if err := restrictedDelete("deleted.go"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		paths    []string
+		expected []string
+	}{
+		{[]string{"c.txt", "missing.txt"}, []string{"c.txt", "missing.txt"}},
+		{[]string{"*.go"}, []string{"a.go", "b.go", "deleted.go"}},
+		{[]string{"*.go", "a.go", "src/*.go"}, []string{"a.go", "b.go", "deleted.go", "src/d.go"}},
+		{[]string{"src/..."}, []string{"src/d.go", "src/x/e.go"}},
+		{[]string{"./..."}, []string{"a.go", "b.go", "c.txt", "deleted.go", "src/d.go", "src/x/e.go"}},
+	}
+	for _, test := range tests {
+		files, err := expandPaths(test.paths)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(files, test.expected) {
+			t.Errorf("expandPaths(%v) = %v, want %v", test.paths, files, test.expected)
+		}
+	}
+	var pathErr *pathspecError
+	if _, err := expandPaths([]string{"*.md"}); !errors.As(err, &pathErr) {
+		t.Fatalf("Pattern matching no files is not rejected: %v", err)
+	}
+}
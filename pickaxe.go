@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// countInFile returns how many times a string occurs in a file tracked by a commit, or 0
+// if the commit does not track the file.
+func countInFile(fileToBlob map[string]string, file string, s string) (int, error) {
+	blobHash, ok := fileToBlob[file]
+	if !ok {
+		return 0, nil
+	}
+	_, contents, err := readBlob(blobHash)
+	if err != nil {
+		return 0, fmt.Errorf("countInFile: %w", err)
+	}
+	return bytes.Count(contents, []byte(s)), nil
+}
+
+// changesOccurrences reports whether a commit changed the number of occurrences of a string
+// in any file compared to its first parent, that is whether it added or removed the string.
+// For the initial commit, every file is compared as empty.
+func changesOccurrences(c commit, s string) (bool, error) {
+	var parent commit
+	if c.ParentUIDs[0] != "" {
+		var err error
+		if parent, err = getCommit(c.ParentUIDs[0]); err != nil {
+			return false, fmt.Errorf("changesOccurrences: %w", err)
+		}
+	}
+	changes, err := diffCommitFiles(parent, c)
+	if err != nil {
+		return false, fmt.Errorf("changesOccurrences: %w", err)
+	}
+	for _, change := range changes {
+		before, err := countInFile(parent.FileToBlob, change.File, s)
+		if err != nil {
+			return false, fmt.Errorf("changesOccurrences: %w", err)
+		}
+		after, err := countInFile(c.FileToBlob, change.File, s)
+		if err != nil {
+			return false, fmt.Errorf("changesOccurrences: %w", err)
+		}
+		if before != after {
+			return true, nil
+		}
+	}
+	return false, nil
+}
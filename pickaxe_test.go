@@ -0,0 +1,54 @@
+package main
+
+import (
+	"regexp"
+	"slices"
+	"testing"
+)
+
+func TestPickaxeLog(t *testing.T) {
+	setupTestRepo(t)
+	var hashes []string
+	for _, version := range [][]string{
+		{"one\n"},
+		{"one\n", "wug\n"}, // adds wug
+		{"two\n", "wug\n"}, // keeps one wug
+		{"wug wug\n"},      // adds a second wug
+		{"none\n"},         // removes both
+	} {
+		if err := writeContents("wug.txt", version); err != nil {
+			t.Fatal(err)
+		}
+		if err := stageFile("wug.txt"); err != nil {
+			t.Fatal(err)
+		}
+		if err := newCommit("update wug"); err != nil {
+			t.Fatal(err)
+		}
+		hash, err := getHeadCommitHash()
+		if err != nil {
+			t.Fatal(err)
+		}
+		hashes = append(hashes, hash)
+	}
+	matches, err := getLogCommits(hashes[4], logOptions{Pickaxe: "wug"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{hashes[4], hashes[3], hashes[1]}
+	if !slices.Equal(matches, expected) {
+		t.Errorf("Expected %v, got %v", expected, matches)
+	}
+	if matches, err := getLogCommits(hashes[4], logOptions{Pickaxe: "missing"}); err != nil || len(matches) != 0 {
+		t.Errorf("Expected no matches, got %v, %v", matches, err)
+	}
+	// the pickaxe is combined with the other filters and the limit
+	if matches, err := getLogCommits(hashes[4], logOptions{Pickaxe: "wug", MaxCount: 2}); err != nil ||
+		!slices.Equal(matches, expected[:2]) {
+		t.Errorf("Expected %v, got %v, %v", expected[:2], matches, err)
+	}
+	if matches, err := getLogCommits(hashes[4], logOptions{Pickaxe: "wug", Grep: regexp.MustCompile("other")}); err != nil ||
+		len(matches) != 0 {
+		t.Errorf("Expected no matches, got %v, %v", matches, err)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+// processExists reports whether a process with the given PID is running.
+// Without a portable way to probe other processes, every process is assumed to be running
+// and abandoned locks are only broken once they are older than staleRepoLockAge.
+func processExists(pid int) bool {
+	return true
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Commands of an interactive rebase todo list.
+const (
+	rebasePick   string = "pick"   // Replay the commit.
+	rebaseReword string = "reword" // Replay the commit and edit its message.
+	rebaseSquash string = "squash" // Meld the commit into the previous one and edit the combined message.
+	rebaseDrop   string = "drop"   // Skip the commit.
+)
+
+// Help appended to the todo list of an interactive rebase.
+const rebaseTodoHelp string = `
+# Commands:
+# p, pick <commit> = use commit
+# r, reword <commit> = use commit, but edit the commit message
+# s, squash <commit> = use commit, but meld into previous commit
+# d, drop <commit> = remove commit
+#
+# These lines can be re-ordered; they are executed from top to bottom.
+# If you remove a line here THAT COMMIT WILL BE LOST.
+# However, if you remove everything, the rebase will be aborted.
+`
+
+// rebaseStep is one instruction of a rebase: a command and the commit it applies to.
+type rebaseStep struct {
+	Command string
+	Hash    string
+}
+
+// mergeFileMaps applies the changes from base to theirs onto ours, where each maps a path
+// to the hash of its contents. Returns the merged map and the sorted paths changed
+// differently by ours and theirs, which keep their version in ours.
+func mergeFileMaps(base, ours, theirs map[string]string) (map[string]string, []string) {
+	merged := maps.Clone(ours)
+	if merged == nil {
+		merged = make(map[string]string)
+	}
+	var conflicts []string
+	for _, change := range diffFileToBlob(base, theirs) {
+		baseHash, inBase := base[change.File]
+		oursHash, inOurs := ours[change.File]
+		theirsHash, inTheirs := theirs[change.File]
+		switch {
+		case inOurs == inTheirs && oursHash == theirsHash:
+			// changed the same way on both sides
+		case inOurs == inBase && oursHash == baseHash:
+			if inTheirs {
+				merged[change.File] = theirsHash
+			} else {
+				delete(merged, change.File)
+			}
+		default:
+			conflicts = append(conflicts, change.File)
+		}
+	}
+	slices.Sort(conflicts)
+	return merged, conflicts
+}
+
+// getBranchCommits returns the first-parent history of a commit back to, but excluding,
+// the given ancestor, oldest first.
+func getBranchCommits(commitHash string, ancestorHash string) ([]string, error) {
+	var hashes []string
+	for commitHash != "" && commitHash != ancestorHash {
+		hashes = append(hashes, commitHash)
+		c, err := getCommit(commitHash)
+		if err != nil {
+			return nil, fmt.Errorf("getBranchCommits: %w", err)
+		}
+		commitHash = c.ParentUIDs[0]
+	}
+	slices.Reverse(hashes)
+	return hashes, nil
+}
+
+// replayCommit creates a commit applying the changes a commit made to its first parent onto
+// the onto commit, keeping its message, timestamp, and author. Returns the onto commit
+// unchanged if the changes are already there, and fails without writing anything on a conflict.
+func replayCommit(ontoHash string, commitHash string) (string, error) {
+	c, err := getCommit(commitHash)
+	if err != nil {
+		return "", fmt.Errorf("replayCommit: %w", err)
+	}
+	var parent commit
+	if c.ParentUIDs[0] != "" {
+		if parent, err = getCommit(c.ParentUIDs[0]); err != nil {
+			return "", fmt.Errorf("replayCommit: %w", err)
+		}
+	}
+	onto, err := getCommit(ontoHash)
+	if err != nil {
+		return "", fmt.Errorf("replayCommit: %w", err)
+	}
+	files, conflicts := mergeFileMaps(parent.FileToBlob, onto.FileToBlob, c.FileToBlob)
+	submodules, submoduleConflicts := mergeFileMaps(parent.Submodules, onto.Submodules, c.Submodules)
+	if conflicts = append(conflicts, submoduleConflicts...); len(conflicts) > 0 {
+		exitf("Could not apply %v; %v has conflicting changes.", c.Oneline(commitHash), conflicts[0])
+	}
+	if maps.Equal(files, onto.FileToBlob) && maps.Equal(submodules, onto.Submodules) {
+		return ontoHash, nil
+	}
+	committer, err := getIdentity(committerRole)
+	if err != nil {
+		return "", fmt.Errorf("replayCommit: %w", err)
+	}
+	replayed := commit{
+		Message:    c.Message,
+		Timestamp:  c.Timestamp,
+		FileToBlob: files,
+		ParentUIDs: [2]string{ontoHash, ""},
+		Author:     c.Author,
+		Committer:  committer,
+	}
+	if len(submodules) > 0 {
+		replayed.Submodules = submodules
+	}
+	replayedHash, err := writeCommitBlob(replayed)
+	if err != nil {
+		return "", fmt.Errorf("replayCommit: %w", err)
+	}
+	return replayedHash, nil
+}
+
+// rewriteCommit writes a copy of a commit with a different first parent and message.
+func rewriteCommit(commitHash string, parentHash string, message string) (string, error) {
+	c, err := getCommit(commitHash)
+	if err != nil {
+		return "", fmt.Errorf("rewriteCommit: %w", err)
+	}
+	c.ParentUIDs[0] = parentHash
+	c.Message = message
+	rewrittenHash, err := writeCommitBlob(c)
+	if err != nil {
+		return "", fmt.Errorf("rewriteCommit: %w", err)
+	}
+	return rewrittenHash, nil
+}
+
+// editCommitMessage opens a commit message in the user's editor and returns the edited message.
+func editCommitMessage(message string) (string, error) {
+	edited, err := editText("gitlet-commit-msg-*", message+"\n\n"+
+		"# Please enter the commit message for your changes. Lines starting\n"+
+		"# with '#' will be ignored, and an empty message aborts the rebase.\n")
+	if err != nil {
+		return "", fmt.Errorf("editCommitMessage: %w", err)
+	}
+	edited = strings.TrimSpace(edited)
+	if edited == "" {
+		exit("Aborting due to empty commit message.")
+	}
+	return edited, nil
+}
+
+// parseRebaseTodo parses an interactive rebase todo list. Blank lines and lines starting
+// with # are ignored.
+func parseRebaseTodo(todo string) ([]rebaseStep, error) {
+	var steps []rebaseStep
+	for _, line := range strings.Split(todo, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("parseRebaseTodo: missing commit in line '%v'", line)
+		}
+		var command string
+		switch fields[0] {
+		case "p", rebasePick:
+			command = rebasePick
+		case "r", rebaseReword:
+			command = rebaseReword
+		case "s", rebaseSquash:
+			command = rebaseSquash
+		case "d", rebaseDrop:
+			command = rebaseDrop
+		default:
+			return nil, fmt.Errorf("parseRebaseTodo: unknown command '%v'", fields[0])
+		}
+		hash, err := resolveRevision(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("parseRebaseTodo: %w", err)
+		}
+		steps = append(steps, rebaseStep{command, hash})
+	}
+	return steps, nil
+}
+
+// editRebaseTodo writes a todo list picking each commit to a temporary file, opens it in the
+// user's editor, and returns the edited steps.
+func editRebaseTodo(commits []string, ontoHash string) ([]rebaseStep, error) {
+	var todo strings.Builder
+	for _, commitHash := range commits {
+		c, err := getCommit(commitHash)
+		if err != nil {
+			return nil, fmt.Errorf("editRebaseTodo: %w", err)
+		}
+		fmt.Fprintf(&todo, "%v %v\n", rebasePick, c.Oneline(commitHash))
+	}
+	fmt.Fprintf(&todo, "\n# Rebase onto %v (%v commands)\n", ontoHash[:6], len(commits))
+	todo.WriteString(rebaseTodoHelp)
+	edited, err := editText("gitlet-rebase-todo-*", todo.String())
+	if err != nil {
+		return nil, fmt.Errorf("editRebaseTodo: %w", err)
+	}
+	steps, err := parseRebaseTodo(edited)
+	if err != nil {
+		return nil, fmt.Errorf("editRebaseTodo: %w", err)
+	}
+	return steps, nil
+}
+
+// runRebaseSteps replays the steps onto a commit, and returns the last commit created.
+func runRebaseSteps(ontoHash string, steps []rebaseStep) (string, error) {
+	newHeadCommitHash := ontoHash
+	for _, step := range steps {
+		if step.Command == rebaseDrop {
+			continue
+		}
+		if step.Command == rebaseSquash && newHeadCommitHash == ontoHash {
+			exit("Cannot squash without a previous commit.")
+		}
+		replayedHash, err := replayCommit(newHeadCommitHash, step.Hash)
+		if err != nil {
+			return "", fmt.Errorf("runRebaseSteps: %w", err)
+		}
+		switch step.Command {
+		case rebaseReword:
+			if replayedHash == newHeadCommitHash {
+				break
+			}
+			c, err := getCommit(replayedHash)
+			if err != nil {
+				return "", fmt.Errorf("runRebaseSteps: %w", err)
+			}
+			message, err := editCommitMessage(c.Message)
+			if err != nil {
+				return "", fmt.Errorf("runRebaseSteps: %w", err)
+			}
+			if replayedHash, err = rewriteCommit(replayedHash, newHeadCommitHash, message); err != nil {
+				return "", fmt.Errorf("runRebaseSteps: %w", err)
+			}
+		case rebaseSquash:
+			previous, err := getCommit(newHeadCommitHash)
+			if err != nil {
+				return "", fmt.Errorf("runRebaseSteps: %w", err)
+			}
+			squashed, err := getCommit(step.Hash)
+			if err != nil {
+				return "", fmt.Errorf("runRebaseSteps: %w", err)
+			}
+			message, err := editCommitMessage(previous.Message + "\n\n" + squashed.Message)
+			if err != nil {
+				return "", fmt.Errorf("runRebaseSteps: %w", err)
+			}
+			// the melded commit takes the place of the previous one
+			c, err := getCommit(replayedHash)
+			if err != nil {
+				return "", fmt.Errorf("runRebaseSteps: %w", err)
+			}
+			c.ParentUIDs = previous.ParentUIDs
+			c.Message = message
+			c.Timestamp = previous.Timestamp
+			if replayedHash, err = writeCommitBlob(c); err != nil {
+				return "", fmt.Errorf("runRebaseSteps: %w", err)
+			}
+		}
+		newHeadCommitHash = replayedHash
+	}
+	return newHeadCommitHash, nil
+}
+
+// rebaseBranch replays the commits of the current branch since its split point with the given
+// branch onto the head of that branch, and moves the current branch to the last replayed commit.
+// Commits whose changes are already on the given branch are dropped. The rebase stops without
+// changing the branch if a commit conflicts with the given branch.
+// An interactive rebase first opens the list of commits to replay in the user's editor, where
+// they can be reordered, dropped, reworded, or squashed into the previous commit.
+func rebaseBranch(branchName string, interactive bool) error {
+	unlock, err := lockRepo("rebase")
+	if err != nil {
+		return fmt.Errorf("rebaseBranch: %w", err)
+	}
+	defer unlock()
+	index, err := readIndex()
+	if err != nil {
+		return fmt.Errorf("rebaseBranch: %w", err)
+	}
+	if len(index) != 0 {
+		exit("You have uncommitted changes.")
+	}
+	targetCommitHash, err := readRef(filepath.Join(branchesDir, branchName))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			exit("A branch with that name does not exist.")
+		}
+		return fmt.Errorf("rebaseBranch: %w", err)
+	}
+	currentBranch, err := getCurrentBranch()
+	if err != nil {
+		return fmt.Errorf("rebaseBranch: %w", err)
+	}
+	if branchName == currentBranch {
+		exit("Cannot rebase a branch onto itself.")
+	}
+	headCommitHash, err := getHeadCommitHash()
+	if err != nil {
+		return fmt.Errorf("rebaseBranch: %w", err)
+	}
+	splitPointCommitHash, err := findSplitPoint(headCommitHash, targetCommitHash)
+	if err != nil {
+		return fmt.Errorf("rebaseBranch: %w", err)
+	}
+	if splitPointCommitHash == targetCommitHash && !interactive {
+		log.Println("Current branch is up to date.")
+		return nil
+	}
+
+	commits, err := getBranchCommits(headCommitHash, splitPointCommitHash)
+	if err != nil {
+		return fmt.Errorf("rebaseBranch: %w", err)
+	}
+	var steps []rebaseStep
+	if interactive {
+		if steps, err = editRebaseTodo(commits, targetCommitHash); err != nil {
+			return fmt.Errorf("rebaseBranch: %w", err)
+		}
+		if len(steps) == 0 {
+			log.Println("Nothing to do.")
+			return nil
+		}
+	} else {
+		for _, commitHash := range commits {
+			steps = append(steps, rebaseStep{rebasePick, commitHash})
+		}
+	}
+	newHeadCommitHash, err := runRebaseSteps(targetCommitHash, steps)
+	if err != nil {
+		return fmt.Errorf("rebaseBranch: %w", err)
+	}
+	if err := moveHead(newHeadCommitHash, "rebase: onto "+branchName); err != nil {
+		return fmt.Errorf("rebaseBranch: %w", err)
+	}
+	if currentBranch == "" {
+		currentBranch = "HEAD"
+	}
+	log.Printf("Successfully rebased and updated %v.\n", currentBranch)
+	return nil
+}
+
+// moveHead checks out a commit and points the current branch, or HEAD if detached, at it,
+// recording the move in the reflog with the given message.
+func moveHead(commitHash string, message string) error {
+	c, err := getCommit(commitHash)
+	if err != nil {
+		return fmt.Errorf("moveHead: %w", err)
+	}
+	if _, err := checkUntrackedFiles(c); err != nil {
+		return fmt.Errorf("moveHead: %w", err)
+	}
+	operation, _, _ := strings.Cut(message, " ")
+	operation = strings.TrimSuffix(operation, ":")
+	if err := beginHeadJournal(operation, commitHash); err != nil {
+		return fmt.Errorf("moveHead: %w", err)
+	}
+	if err := checkoutTree(c); err != nil {
+		return fmt.Errorf("moveHead: %w", err)
+	}
+	if err := setHeadCommit(commitHash, message); err != nil {
+		return fmt.Errorf("moveHead: %w", err)
+	}
+	if err := endJournal(); err != nil {
+		return fmt.Errorf("moveHead: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestMergeFileMaps(t *testing.T) {
+	base := map[string]string{"same": "1", "changed": "1", "removed": "1", "conflict": "1"}
+	ours := map[string]string{"same": "1", "changed": "1", "removed": "1", "conflict": "2", "ours": "1"}
+	theirs := map[string]string{"same": "1", "changed": "2", "conflict": "3", "added": "1"}
+	merged, conflicts := mergeFileMaps(base, ours, theirs)
+	want := map[string]string{"same": "1", "changed": "2", "conflict": "2", "ours": "1", "added": "1"}
+	if len(merged) != len(want) {
+		t.Errorf("mergeFileMaps() = %v, want %v", merged, want)
+	}
+	for file, hash := range want {
+		if merged[file] != hash {
+			t.Errorf("mergeFileMaps()[%v] = %v, want %v", file, merged[file], hash)
+		}
+	}
+	if !slices.Equal(conflicts, []string{"conflict"}) {
+		t.Errorf("mergeFileMaps() conflicts = %v, want [conflict]", conflicts)
+	}
+}
+
+func TestRebaseBranch(t *testing.T) {
+	setupTestRepo(t)
+	commitInRepo(t, ".", "wug.txt", "wug")
+	mainBranch, err := getCurrentBranch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := addBranch("other"); err != nil {
+		t.Fatal(err)
+	}
+	commitInRepo(t, ".", "main.txt", "main")
+	if err := checkoutBranch("other"); err != nil {
+		t.Fatal(err)
+	}
+	commitInRepo(t, ".", "wug.txt", "other wug")
+	commitInRepo(t, ".", "other.txt", "other")
+	oldHead, err := getHeadCommitHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := rebaseBranch(mainBranch, false); err != nil {
+		t.Fatal(err)
+	}
+	mainHash, err := readContentsAsString(filepath.Join(branchesDir, mainBranch))
+	if err != nil {
+		t.Fatal(err)
+	}
+	headHash, err := getHeadCommitHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if headHash == oldHead {
+		t.Fatal("Branch was not moved")
+	}
+	commits, err := getBranchCommits(headHash, mainHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(commits) != 2 {
+		t.Fatalf("Rebased branch has %v commits on %v, want 2", mainBranch, len(commits))
+	}
+	first, err := getCommit(commits[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.ParentUIDs[0] != mainHash || first.Message != "add wug.txt" {
+		t.Errorf("Incorrect first rebased commit: %+v", first)
+	}
+	for file, want := range map[string]string{"wug.txt": "other wug", "other.txt": "other", "main.txt": "main"} {
+		if contents, err := readContentsAsString(file); err != nil || contents != want {
+			t.Errorf("%v = %q, %v, want %q", file, contents, err, want)
+		}
+	}
+}
+
+func TestParseRebaseTodo(t *testing.T) {
+	setupTestRepo(t)
+	if err := writeContents("wug.txt", []string{"wug"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stageFile("wug.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := newCommit("add wug"); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := getHeadCommitHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	todo := "# comment\n\npick " + hash[:6] + " add wug\ns " + hash + "\nd " + hash[:6] + "\n"
+	steps, err := parseRebaseTodo(todo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []rebaseStep{{rebasePick, hash}, {rebaseSquash, hash}, {rebaseDrop, hash}}
+	if !slices.Equal(steps, want) {
+		t.Errorf("parseRebaseTodo() = %v, want %v", steps, want)
+	}
+	if _, err := parseRebaseTodo("edit " + hash); err == nil {
+		t.Error("parseRebaseTodo() should fail on an unknown command")
+	}
+}
+
+func TestRebaseBranchInteractive(t *testing.T) {
+	setupTestRepo(t)
+	mainBranch, err := getCurrentBranch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := addBranch("other"); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkoutBranch("other"); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{"a.txt", "b.txt", "c.txt"} {
+		if err := writeContents(file, []string{file}); err != nil {
+			t.Fatal(err)
+		}
+		if err := stageFile(file); err != nil {
+			t.Fatal(err)
+		}
+		if err := newCommit("add " + file); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// squash the second commit into the first, drop the third, and keep the message
+	t.Setenv("VISUAL", "")
+	t.Setenv("EDITOR", "sed -i -e 2s/^pick/squash/ -e 3s/^pick/drop/")
+	if err := rebaseBranch(mainBranch, true); err != nil {
+		t.Fatal(err)
+	}
+	head, err := getHeadCommit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head.ParentUIDs[0] != initialCommitHash {
+		t.Errorf("Squashed commit parent = %v, want the initial commit", head.ParentUIDs[0])
+	}
+	if head.Message != "add a.txt\n\nadd b.txt" {
+		t.Errorf("Incorrect squashed message: %q", head.Message)
+	}
+	if _, ok := head.FileToBlob["b.txt"]; !ok {
+		t.Error("Squashed commit should have the squashed file")
+	}
+	if _, ok := head.FileToBlob["c.txt"]; ok {
+		t.Error("Dropped commit's file should not be committed")
+	}
+}
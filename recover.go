@@ -0,0 +1,191 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// getBranchHeads returns the head commit hash of every local branch by branch name.
+func getBranchHeads() (map[string]string, error) {
+	branches, err := getRefNames(branchesDir)
+	if err != nil {
+		return nil, fmt.Errorf("getBranchHeads: %w", err)
+	}
+	heads := make(map[string]string, len(branches))
+	for _, branch := range branches {
+		hash, err := readRef(filepath.Join(branchesDir, branch))
+		if err != nil {
+			return nil, fmt.Errorf("getBranchHeads: %w", err)
+		}
+		heads[branch] = hash
+	}
+	return heads, nil
+}
+
+// findUnreachableTips returns the commits that are not reachable from any branch and
+// are not the parent of another such commit, newest first. These are the heads of
+// history that was dropped, such as by removing a branch.
+func findUnreachableTips() ([]string, error) {
+	heads, err := getBranchHeads()
+	if err != nil {
+		return nil, fmt.Errorf("findUnreachableTips: %w", err)
+	}
+	reachable := make(map[string]bool)
+	var queue []string
+	for _, hash := range heads {
+		queue = append(queue, hash)
+	}
+	// a detached HEAD keeps its commits alive too
+	headCommitHash, err := getHeadCommitHash()
+	if err != nil {
+		return nil, fmt.Errorf("findUnreachableTips: %w", err)
+	}
+	queue = append(queue, headCommitHash)
+	// autosave snapshots are kept alive by their hidden ref
+	if hash, err := readRef(autosaveRefFile); err == nil {
+		queue = append(queue, hash)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("findUnreachableTips: %w", err)
+	}
+	// and stashed changes by the stash
+	stash, err := readStash()
+	if err != nil {
+		return nil, fmt.Errorf("findUnreachableTips: %w", err)
+	}
+	for _, entry := range stash {
+		queue = append(queue, entry.Hash)
+	}
+	// and tagged commits by their tags
+	tags, err := getTags()
+	if err != nil {
+		return nil, fmt.Errorf("findUnreachableTips: %w", err)
+	}
+	for _, hash := range tags {
+		commitHash, err := peelTag(hash)
+		if err != nil {
+			return nil, fmt.Errorf("findUnreachableTips: %w", err)
+		}
+		queue = append(queue, commitHash)
+	}
+	for len(queue) > 0 {
+		hash := queue[0]
+		queue = queue[1:]
+		if reachable[hash] {
+			continue
+		}
+		reachable[hash] = true
+		c, err := getCommit(hash)
+		if err != nil {
+			return nil, fmt.Errorf("findUnreachableTips: %w", err)
+		}
+		for _, parentHash := range c.ParentUIDs {
+			if parentHash != "" {
+				queue = append(queue, parentHash)
+			}
+		}
+	}
+
+	hashes, err := getObjectHashes()
+	if err != nil {
+		return nil, fmt.Errorf("findUnreachableTips: %w", err)
+	}
+	unreachable := make(map[string]commit)
+	isParent := make(map[string]bool)
+	for _, hash := range hashes {
+		if reachable[hash] {
+			continue
+		}
+		header, err := parseBlobHeader(hash)
+		if err != nil {
+			return nil, fmt.Errorf("findUnreachableTips: %w", err)
+		}
+		if header != "commit" {
+			continue
+		}
+		c, err := getCommit(hash)
+		if err != nil {
+			return nil, fmt.Errorf("findUnreachableTips: %w", err)
+		}
+		unreachable[hash] = c
+		for _, parentHash := range c.ParentUIDs {
+			isParent[parentHash] = true
+		}
+	}
+	var tips []string
+	for hash := range unreachable {
+		if !isParent[hash] {
+			tips = append(tips, hash)
+		}
+	}
+	slices.Sort(tips)
+	slices.SortStableFunc(tips, func(a, b string) int {
+		return cmp.Compare(unreachable[b].Timestamp, unreachable[a].Timestamp)
+	})
+	return tips, nil
+}
+
+// recoverBranch recreates a removed branch. If rev is empty, the branch is pointed at its
+// last commit recorded in its reflog, or else at the only commit that is no longer reachable
+// from any branch; if there are several, they are listed so one can be chosen with rev.
+func recoverBranch(branchName string, rev string) error {
+	branchFile := filepath.Join(branchesDir, branchName)
+	if _, err := readRef(branchFile); err == nil {
+		exit("A branch with that name already exists.")
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("recoverBranch: %w", err)
+	}
+
+	var commitHash string
+	if rev != "" {
+		hash, err := resolveRevision(rev)
+		if errors.Is(err, fs.ErrNotExist) {
+			exit("No commit with that id exists.")
+		} else if err != nil {
+			return fmt.Errorf("recoverBranch: %w", err)
+		}
+		commitHash = hash
+	} else if hash, err := getReflogCommit(branchName, 0); err == nil {
+		commitHash = hash
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("recoverBranch: %w", err)
+	} else {
+		tips, err := findUnreachableTips()
+		if err != nil {
+			return fmt.Errorf("recoverBranch: %w", err)
+		}
+		switch len(tips) {
+		case 0:
+			exit("No unreachable commits found to recover the branch from.")
+		case 1:
+			commitHash = tips[0]
+		default:
+			log.Println("Found several unreachable commits:")
+			for _, hash := range tips {
+				c, err := getCommit(hash)
+				if err != nil {
+					return fmt.Errorf("recoverBranch: %w", err)
+				}
+				log.Printf(
+					"%v %v %v\n",
+					hash[:6], time.Unix(c.Timestamp, 0).Format(time.DateTime), c.Message,
+				)
+			}
+			exitf("Choose one with 'branch --recover %v <commit id>'.", branchName)
+		}
+	}
+
+	if err := writeRef(branchFile, commitHash, "branch --recover"); err != nil {
+		return fmt.Errorf("recoverBranch: %w", err)
+	}
+	if err := logRefUpdate(branchName, "", commitHash, "branch: Recovered"); err != nil {
+		return fmt.Errorf("recoverBranch: %w", err)
+	}
+	log.Printf("Branch '%v' was recovered on commit (%v).\n", branchName, commitHash[:6])
+	return nil
+}
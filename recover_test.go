@@ -0,0 +1,49 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestRecoverBranch(t *testing.T) {
+	setupTestRepo(t)
+	if err := addBranch("feature"); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkoutBranch("feature"); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeContents("wug.txt", []string{"This is a wug"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stageFile("wug.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := newCommit("add wug file"); err != nil {
+		t.Fatal(err)
+	}
+	featureHash, err := getHeadCommitHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checkoutBranch("main"); err != nil {
+		t.Fatal(err)
+	}
+
+	if tips, err := findUnreachableTips(); err != nil || len(tips) != 0 {
+		t.Fatalf("Incorrect unreachable tips before removing branch: %v, %v", tips, err)
+	}
+	if err := removeBranch("feature"); err != nil {
+		t.Fatal(err)
+	}
+	if tips, err := findUnreachableTips(); err != nil || len(tips) != 1 || tips[0] != featureHash {
+		t.Fatalf("Incorrect unreachable tips after removing branch: want [%v], got %v, %v", featureHash, tips, err)
+	}
+
+	if err := recoverBranch("feature", ""); err != nil {
+		t.Fatal(err)
+	}
+	if hash, err := readContentsAsString(filepath.Join(branchesDir, "feature")); err != nil || hash != featureHash {
+		t.Fatalf("Incorrect recovered branch head: want %v, got %v, %v", featureHash, hash, err)
+	}
+}
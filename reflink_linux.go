@@ -0,0 +1,36 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// FICLONERANGE ioctl request number from linux/fs.h.
+const ficloneRange uintptr = 0x4020940d
+
+// fileCloneRange mirrors struct file_clone_range from linux/fs.h.
+type fileCloneRange struct {
+	srcFd      int64
+	srcOffset  uint64
+	srcLength  uint64
+	destOffset uint64
+}
+
+// cloneFileRange shares length bytes of src starting at srcOffset with the start of dst
+// using a reflink, without copying any data. Fails if the filesystem does not support
+// reflinks, the files are on different filesystems, or the range is not block-aligned.
+func cloneFileRange(dst *os.File, src *os.File, srcOffset int64, length int64) error {
+	arg := fileCloneRange{
+		srcFd:     int64(src.Fd()),
+		srcOffset: uint64(srcOffset),
+		srcLength: uint64(length),
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, dst.Fd(), ficloneRange, uintptr(unsafe.Pointer(&arg)))
+	if errno != 0 {
+		return &os.SyscallError{Syscall: "ioctl FICLONERANGE", Err: errno}
+	}
+	return nil
+}
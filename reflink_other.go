@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// cloneFileRange is not supported on this platform; files are always copied.
+func cloneFileRange(dst *os.File, src *os.File, srcOffset int64, length int64) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var logsDir = filepath.Join(gitletDir, "logs")
+
+// Hash recorded as the old value of a ref that was created, or the new value of a ref that was deleted.
+var nullHash = strings.Repeat("0", hashLength)
+
+// reflogEntry records one update of a ref.
+type reflogEntry struct {
+	Old       string // Commit hash of the ref before the update.
+	New       string // Commit hash of the ref after the update.
+	Timestamp int64  // Unix time of the update.
+	Message   string // Description of the command that updated the ref.
+}
+
+// String formats an entry as a line of a reflog file: "<old> <new> <timestamp>\t<message>".
+func (e reflogEntry) String() string {
+	message := strings.ReplaceAll(e.Message, "\n", " ")
+	return fmt.Sprintf("%v %v %d\t%v", e.Old, e.New, e.Timestamp, message)
+}
+
+// parseReflogEntry parses a line of a reflog file.
+func parseReflogEntry(line string) (reflogEntry, error) {
+	fields, message, _ := strings.Cut(line, "\t")
+	parts := strings.Split(fields, " ")
+	if len(parts) != 3 || !isHash(parts[0]) || !isHash(parts[1]) {
+		return reflogEntry{}, fmt.Errorf("parseReflogEntry: malformed entry '%v'", line)
+	}
+	timestamp, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return reflogEntry{}, fmt.Errorf("parseReflogEntry: malformed timestamp in entry '%v'", line)
+	}
+	return reflogEntry{parts[0], parts[1], timestamp, message}, nil
+}
+
+// reflogPath returns the reflog file of a ref, which is either HEAD or a branch name.
+func reflogPath(ref string) string {
+	if ref == "HEAD" {
+		return filepath.Join(logsDir, "HEAD")
+	}
+	return filepath.Join(logsDir, "refs", "heads", ref)
+}
+
+// readReflog returns the entries of the reflog of a ref, oldest first.
+// Returns no entries if the ref has no reflog.
+func readReflog(ref string) ([]reflogEntry, error) {
+	b, err := readFile(reflogPath(ref))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("readReflog: %w", err)
+	}
+	var entries []reflogEntry
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		if scanner.Text() == "" {
+			continue
+		}
+		entry, err := parseReflogEntry(scanner.Text())
+		if err != nil {
+			return nil, fmt.Errorf("readReflog: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("readReflog: %w", err)
+	}
+	return entries, nil
+}
+
+// writeReflog replaces the reflog of a ref with the given entries.
+func writeReflog(ref string, entries []reflogEntry) error {
+	file := reflogPath(ref)
+	if err := repoFS.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return fmt.Errorf("writeReflog: %w", err)
+	}
+	var b bytes.Buffer
+	for _, entry := range entries {
+		b.WriteString(entry.String())
+		b.WriteByte('\n')
+	}
+	if err := writeFileAtomic(file, b.Bytes()); err != nil {
+		return fmt.Errorf("writeReflog: %w", err)
+	}
+	return nil
+}
+
+// appendReflog adds an entry to the end of the reflog of a ref, creating the reflog if needed.
+func appendReflog(ref string, entry reflogEntry) error {
+	file := reflogPath(ref)
+	if err := repoFS.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return fmt.Errorf("appendReflog: %w", err)
+	}
+	f, err := repoFS.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("appendReflog: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(entry.String() + "\n"); err != nil {
+		return fmt.Errorf("appendReflog: %w", err)
+	}
+	return f.Close()
+}
+
+// logRefUpdate records that a ref moved from one commit to another now. An empty hash
+// stands for a ref that did not exist before or no longer exists.
+func logRefUpdate(ref string, oldHash string, newHash string, message string) error {
+	if oldHash == "" {
+		oldHash = nullHash
+	}
+	if newHash == "" {
+		newHash = nullHash
+	}
+	if err := appendReflog(ref, reflogEntry{oldHash, newHash, time.Now().Unix(), message}); err != nil {
+		return fmt.Errorf("logRefUpdate: %w", err)
+	}
+	return nil
+}
+
+// logCheckout records in the reflog of HEAD that a checkout moved it from one commit to
+// another. Each side is described by its branch, or by its commit if the branch is empty.
+func logCheckout(fromHash string, fromBranch string, toHash string, toBranch string) error {
+	if fromBranch == "" {
+		fromBranch = fromHash
+	}
+	if toBranch == "" {
+		toBranch = toHash
+	}
+	message := fmt.Sprintf("checkout: moving from %v to %v", fromBranch, toBranch)
+	if err := logRefUpdate("HEAD", fromHash, toHash, message); err != nil {
+		return fmt.Errorf("logCheckout: %w", err)
+	}
+	return nil
+}
+
+// getReflogCommit returns the commit a ref pointed to n updates ago, where 0 is the latest.
+// Returns an error wrapping fs.ErrNotExist if the reflog has fewer entries.
+func getReflogCommit(ref string, n int) (string, error) {
+	entries, err := readReflog(ref)
+	if err != nil {
+		return "", fmt.Errorf("getReflogCommit: %w", err)
+	}
+	if n >= len(entries) || entries[len(entries)-1-n].New == nullHash {
+		return "", fmt.Errorf("getReflogCommit: %v has no entry %v: %w", ref, n, fs.ErrNotExist)
+	}
+	return entries[len(entries)-1-n].New, nil
+}
+
+// printReflog prints the reflog of a ref, newest first, naming each entry as <ref>@{n}.
+func printReflog(ref string) error {
+	entries, err := readReflog(ref)
+	if err != nil {
+		return fmt.Errorf("printReflog: %w", err)
+	}
+	for i := range entries {
+		entry := entries[len(entries)-1-i]
+		log.Printf("%v %v@{%v}: %v\n", entry.New[:6], ref, i, entry.Message)
+	}
+	return nil
+}
+
+// getReflogRefs returns the refs that have a reflog: HEAD first, then branches by name.
+func getReflogRefs() ([]string, error) {
+	var refs []string
+	if _, err := repoFS.Stat(reflogPath("HEAD")); err == nil {
+		refs = append(refs, "HEAD")
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("getReflogRefs: %w", err)
+	}
+	branches, err := getFilenames(filepath.Join(logsDir, "refs", "heads"))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("getReflogRefs: %w", err)
+	}
+	return append(refs, branches...), nil
+}
+
+// getAncestors returns the set of commits reachable from a commit, including itself.
+func getAncestors(commitHash string) (map[string]bool, error) {
+	graph, err := readCommitGraph()
+	if err != nil {
+		return nil, fmt.Errorf("getAncestors: %w", err)
+	}
+	ancestors := make(map[string]bool)
+	queue := []string{commitHash}
+	for len(queue) > 0 {
+		hash := queue[0]
+		queue = queue[1:]
+		if ancestors[hash] {
+			continue
+		}
+		ancestors[hash] = true
+		entry, err := graph.lookup(hash)
+		if err != nil {
+			return nil, fmt.Errorf("getAncestors: %w", err)
+		}
+		queue = append(queue, entry.Parents...)
+	}
+	return ancestors, nil
+}
+
+// expireReflog removes the entries of the reflog of a ref that are older than expire,
+// and the entries older than expireUnreachable whose commit is no longer reachable from
+// the ref. Returns the number of entries removed.
+func expireReflog(ref string, now time.Time, expire time.Duration, expireUnreachable time.Duration) (int, error) {
+	entries, err := readReflog(ref)
+	if err != nil {
+		return 0, fmt.Errorf("expireReflog: %w", err)
+	}
+	if len(entries) == 0 {
+		return 0, nil
+	}
+
+	// a deleted branch has nothing reachable from it
+	var tip string
+	if ref == "HEAD" {
+		tip, err = getHeadCommitHash()
+	} else {
+		tip, err = readRef(filepath.Join(branchesDir, ref))
+	}
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return 0, fmt.Errorf("expireReflog: %w", err)
+	}
+	reachable := make(map[string]bool)
+	if tip != "" {
+		if reachable, err = getAncestors(tip); err != nil {
+			return 0, fmt.Errorf("expireReflog: %w", err)
+		}
+	}
+
+	var kept []reflogEntry
+	for _, entry := range entries {
+		age := now.Sub(time.Unix(entry.Timestamp, 0))
+		if age > expire || (age > expireUnreachable && !reachable[entry.New]) {
+			continue
+		}
+		kept = append(kept, entry)
+	}
+	if len(kept) == len(entries) {
+		return 0, nil
+	}
+	if err := writeReflog(ref, kept); err != nil {
+		return 0, fmt.Errorf("expireReflog: %w", err)
+	}
+	return len(entries) - len(kept), nil
+}
+
+// expireReflogs expires the reflog of every ref. Ages that are not given are read from the
+// gc.reflogExpire and gc.reflogExpireUnreachable configuration.
+// Returns the number of entries removed.
+func expireReflogs(expire string, expireUnreachable string) (int, error) {
+	unlock, err := lockRepo("reflog expire")
+	if err != nil {
+		return 0, fmt.Errorf("expireReflogs: %w", err)
+	}
+	defer unlock()
+
+	var expireAge, expireUnreachableAge time.Duration
+	for _, opt := range []struct {
+		value string
+		key   string
+		age   *time.Duration
+	}{
+		{expire, "gc.reflogExpire", &expireAge},
+		{expireUnreachable, "gc.reflogExpireUnreachable", &expireUnreachableAge},
+	} {
+		if opt.value != "" {
+			*opt.age, err = parseExpiry(opt.value)
+		} else {
+			*opt.age, err = getExpiryConfig(opt.key)
+		}
+		if err != nil {
+			return 0, fmt.Errorf("expireReflogs: %w", err)
+		}
+	}
+
+	refs, err := getReflogRefs()
+	if err != nil {
+		return 0, fmt.Errorf("expireReflogs: %w", err)
+	}
+	now := time.Now()
+	removed := 0
+	for _, ref := range refs {
+		n, err := expireReflog(ref, now, expireAge, expireUnreachableAge)
+		if err != nil {
+			return 0, fmt.Errorf("expireReflogs: %w", err)
+		}
+		removed += n
+	}
+	return removed, nil
+}
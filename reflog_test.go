@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestExpireReflog(t *testing.T) {
+	setupTestRepo(t)
+	if err := writeContents("wug.txt", []string{"This is a wug"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stageFile("wug.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := newCommit("add wug file"); err != nil {
+		t.Fatal(err)
+	}
+	headHash, err := getHeadCommitHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	unreachableHash := "0123456789abcdef0123456789abcdef01234567"
+
+	now := time.Now()
+	day := int64(24 * 60 * 60)
+	entries := []reflogEntry{
+		{nullHash, initialCommitHash, now.Unix() - 100*day, "init"},           // too old
+		{initialCommitHash, unreachableHash, now.Unix() - 40*day, "commit"},   // unreachable and old
+		{unreachableHash, initialCommitHash, now.Unix() - 40*day, "reset"},    // reachable
+		{initialCommitHash, unreachableHash, now.Unix() - 10*day, "commit"},   // unreachable but recent
+		{unreachableHash, headHash, now.Unix() - day, "commit: add wug file"}, // reachable
+	}
+	if err := writeReflog("main", entries); err != nil {
+		t.Fatal(err)
+	}
+	if actual, err := readReflog("main"); err != nil || !reflect.DeepEqual(actual, entries) {
+		t.Fatalf("Reflog written and read incorrectly: want %v, got %v, %v", entries, actual, err)
+	}
+
+	removed, err := expireReflogs("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 2 {
+		t.Fatalf("Incorrect number of expired entries: want 2, got %v", removed)
+	}
+	expected := []reflogEntry{entries[2], entries[3], entries[4]}
+	if actual, err := readReflog("main"); err != nil || !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("Incorrect reflog after expiry: want %v, got %v, %v", expected, actual, err)
+	}
+
+	// explicit ages override the configuration
+	if removed, err := expireReflogs("never", "now"); err != nil || removed != 1 {
+		t.Fatalf("Incorrect expiry of unreachable entries: want 1, got %v, %v", removed, err)
+	}
+}
+
+func TestReflogRecordsHeadMoves(t *testing.T) {
+	setupTestRepo(t)
+	if err := writeContents("wug.txt", []string{"This is a wug"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stageFile("wug.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := newCommit("add wug file"); err != nil {
+		t.Fatal(err)
+	}
+	commitHash, err := getHeadCommitHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := addBranch("other"); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkoutBranch("other"); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkoutBranch("main"); err != nil {
+		t.Fatal(err)
+	}
+	if err := resetFile(initialCommitHash); err != nil {
+		t.Fatal(err)
+	}
+
+	var messages []string
+	entries, err := readReflog("HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		messages = append(messages, entry.Message)
+	}
+	expected := []string{
+		"commit (initial): initial commit",
+		"commit: add wug file",
+		"checkout: moving from main to other",
+		"checkout: moving from other to main",
+		"reset: moving to " + initialCommitHash,
+	}
+	if !reflect.DeepEqual(messages, expected) {
+		t.Fatalf("Incorrect HEAD reflog: want %q, got %q", expected, messages)
+	}
+	if entries, err := readReflog("main"); err != nil || len(entries) != 3 {
+		t.Fatalf("Branch reflog should record init, commit, and reset: %v, %v", entries, err)
+	}
+
+	// the commit dropped by the reset can be found through the reflog
+	if hash, err := resolveRevision("HEAD@{1}"); err != nil || hash != commitHash {
+		t.Errorf("resolveRevision(HEAD@{1}) = %v, %v, want %v", hash, err, commitHash)
+	}
+	if hash, err := resolveRevision("main@{1}"); err != nil || hash != commitHash {
+		t.Errorf("resolveRevision(main@{1}) = %v, %v, want %v", hash, err, commitHash)
+	}
+	if _, err := resolveRevision("main@{3}"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("resolveRevision(main@{3}) should not exist, got %v", err)
+	}
+}
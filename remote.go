@@ -1,6 +1,16 @@
 package main
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/url"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
 
 type remoteMetadata struct {
 	Name string
@@ -39,3 +49,476 @@ func newRemoteIndex() error {
 	}
 	return nil
 }
+
+// getRemoteURL returns the URL of a remote, which is either the path of a repository or the
+// URL of a served repository.
+func getRemoteURL(remoteName string) (string, error) {
+	remotes, err := readRemoteIndex()
+	if err != nil {
+		return "", fmt.Errorf("getRemoteURL: %w", err)
+	}
+	remote, ok := remotes[remoteName]
+	if !ok {
+		exit("A remote with that name does not exist.")
+	}
+	return remote.URL, nil
+}
+
+// getRemoteDir returns the absolute path of the working directory of a remote repository.
+func getRemoteDir(remoteName string) (string, error) {
+	url, err := getRemoteURL(remoteName)
+	if err != nil {
+		return "", fmt.Errorf("getRemoteDir: %w", err)
+	}
+	remoteGitletDir, err := findGitletDir(url)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			exit("Remote directory not found.")
+		}
+		return "", fmt.Errorf("getRemoteDir: %w", err)
+	}
+	return filepath.Dir(remoteGitletDir), nil
+}
+
+// writeRemoteTrackingBranch records the last known head commit of a branch in a remote
+// repository as the remote-tracking branch <remote>/<branch>.
+func writeRemoteTrackingBranch(remoteName string, branchName string, commitHash string) error {
+	file := filepath.Join(remotesDir, remoteName, branchName)
+	if err := repoFS.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return fmt.Errorf("writeRemoteTrackingBranch: %w", err)
+	}
+	if err := writeRef(file, commitHash, "fetch"); err != nil {
+		return fmt.Errorf("writeRemoteTrackingBranch: %w", err)
+	}
+	return nil
+}
+
+// readRemoteBranchHead returns the head commit of a branch in a remote repository.
+// Returns an error wrapping fs.ErrNotExist if the remote does not have the branch.
+func readRemoteBranchHead(remoteName string, branchName string) (string, error) {
+	url, err := getRemoteURL(remoteName)
+	if err != nil {
+		return "", fmt.Errorf("readRemoteBranchHead: %w", err)
+	}
+	if isServerURL(url) {
+		heads, err := getServerHeads(url)
+		if err != nil {
+			return "", fmt.Errorf("readRemoteBranchHead: %w", err)
+		}
+		hash, ok := heads[branchName]
+		if !ok {
+			return "", fmt.Errorf("readRemoteBranchHead: no branch '%v': %w", branchName, fs.ErrNotExist)
+		}
+		return hash, nil
+	}
+	remoteDir, err := getRemoteDir(remoteName)
+	if err != nil {
+		return "", fmt.Errorf("readRemoteBranchHead: %w", err)
+	}
+	var hash string
+	if err := inRepository(remoteDir, func() error {
+		hash, err = readRef(filepath.Join(branchesDir, branchName))
+		return err
+	}); err != nil {
+		return "", fmt.Errorf("readRemoteBranchHead: %w", err)
+	}
+	return hash, nil
+}
+
+// downloadObjects copies the commits reachable from a commit in a remote repository, and the
+// blobs they track, that the current repository does not have yet.
+func downloadObjects(remoteName string, commitHash string) error {
+	url, err := getRemoteURL(remoteName)
+	if err != nil {
+		return fmt.Errorf("downloadObjects: %w", err)
+	}
+	if isServerURL(url) {
+		if err := fetchFromServer(url, commitHash); err != nil {
+			return fmt.Errorf("downloadObjects: %w", err)
+		}
+		return nil
+	}
+	remoteDir, err := getRemoteDir(remoteName)
+	if err != nil {
+		return fmt.Errorf("downloadObjects: %w", err)
+	}
+	if _, err := transferObjects(remoteDir, ".", commitHash); err != nil {
+		return fmt.Errorf("downloadObjects: %w", err)
+	}
+	return nil
+}
+
+// uploadBranch copies the commits reachable from a new head commit, and the blobs they track,
+// that a remote repository does not have yet, then moves a branch of the remote from its old
+// head, empty if it does not exist, to the new head.
+func uploadBranch(remoteName string, branchName string, oldHash string, newHash string) error {
+	url, err := getRemoteURL(remoteName)
+	if err != nil {
+		return fmt.Errorf("uploadBranch: %w", err)
+	}
+	if isServerURL(url) {
+		if err := pushToServer(url, branchName, oldHash, newHash); err != nil {
+			return fmt.Errorf("uploadBranch: %w", err)
+		}
+		return nil
+	}
+	remoteDir, err := getRemoteDir(remoteName)
+	if err != nil {
+		return fmt.Errorf("uploadBranch: %w", err)
+	}
+	hashes, err := transferObjects(".", remoteDir, newHash)
+	if err != nil {
+		return fmt.Errorf("uploadBranch: %w", err)
+	}
+	oids, err := getMediaOIDs(hashes)
+	if err != nil {
+		return fmt.Errorf("uploadBranch: %w", err)
+	}
+	media, err := collectMedia(oids)
+	if err != nil {
+		return fmt.Errorf("uploadBranch: %w", err)
+	}
+	if err := inRepository(remoteDir, func() error {
+		if err := storeMedia(media); err != nil {
+			return err
+		}
+		unlock, err := lockRepo("push")
+		if err != nil {
+			return err
+		}
+		defer unlock()
+		if err := writeRef(filepath.Join(branchesDir, branchName), newHash, "push"); err != nil {
+			return err
+		}
+		return logRefUpdate(branchName, oldHash, newHash, "push")
+	}); err != nil {
+		return fmt.Errorf("uploadBranch: %w", err)
+	}
+	return nil
+}
+
+// collectObjects passes send the payload of every commit reachable from the given commits,
+// and of the trees and blobs they track and the chunks of chunked blobs, as it reads each
+// one. Commits in have are skipped along with their history, and objects in have are not
+// sent. Each object is sent once.
+func collectObjects(commitHashes []string, have map[string]bool, send func(hash string, payload []byte) error) error {
+	sent := make(map[string]bool)
+	addObject := func(hash string) error {
+		if have[hash] || sent[hash] {
+			return nil
+		}
+		payload, err := readObjectPayload(hash)
+		if err != nil {
+			return err
+		}
+		sent[hash] = true
+		return send(hash, payload)
+	}
+	queue := slices.Clone(commitHashes)
+	for len(queue) > 0 {
+		hash := queue[0]
+		queue = queue[1:]
+		if have[hash] || sent[hash] {
+			continue
+		}
+		if err := addObject(hash); err != nil {
+			return fmt.Errorf("collectObjects: %w", err)
+		}
+		c, err := getCommit(hash)
+		if err != nil {
+			return fmt.Errorf("collectObjects: %w", err)
+		}
+		if c.Tree != "" {
+			// a tree already sent or in have brings its subdirectories along
+			trees, err := getTreeObjects(c.Tree, func(treeHash string) bool {
+				return have[treeHash] || sent[treeHash]
+			})
+			if err != nil {
+				return fmt.Errorf("collectObjects: %w", err)
+			}
+			for _, treeHash := range trees {
+				if err := addObject(treeHash); err != nil {
+					return fmt.Errorf("collectObjects: %w", err)
+				}
+			}
+		}
+		for _, blobHash := range c.FileToBlob {
+			if have[blobHash] || sent[blobHash] {
+				continue
+			}
+			if err := addObject(blobHash); err != nil {
+				return fmt.Errorf("collectObjects: %w", err)
+			}
+			chunks, err := getBlobChunks(blobHash)
+			if err != nil {
+				return fmt.Errorf("collectObjects: %w", err)
+			}
+			for _, chunkHash := range chunks {
+				if err := addObject(chunkHash); err != nil {
+					return fmt.Errorf("collectObjects: %w", err)
+				}
+			}
+		}
+		for _, parentHash := range c.ParentUIDs {
+			if parentHash != "" {
+				queue = append(queue, parentHash)
+			}
+		}
+	}
+	return nil
+}
+
+// transferObjects copies the commits reachable from a commit, and the blobs they track,
+// from the repository in srcDir to the repository in dstDir. Commits the destination already
+// has are skipped along with their history. Returns the hashes of the objects copied, sorted.
+func transferObjects(srcDir string, dstDir string, commitHash string) ([]string, error) {
+	have := make(map[string]bool)
+	if err := inRepository(dstDir, func() error {
+		hashes, err := getObjectHashes()
+		for _, hash := range hashes {
+			have[hash] = true
+		}
+		return err
+	}); err != nil {
+		return nil, fmt.Errorf("transferObjects: %w", err)
+	}
+	dstAbs, err := repoFS.Abs(dstDir)
+	if err != nil {
+		return nil, fmt.Errorf("transferObjects: %w", err)
+	}
+
+	// each object is written to the destination as it is read from the source
+	dst := fileStorage{filepath.Join(dstAbs, gitletDir)}
+	var hashes []string
+	if err := inRepository(srcDir, func() error {
+		return collectObjects([]string{commitHash}, have, func(hash string, payload []byte) error {
+			w, err := dst.createObject()
+			if err != nil {
+				return err
+			}
+			defer w.discard()
+			if _, err := w.Write(payload); err != nil {
+				return err
+			}
+			hashes = append(hashes, hash)
+			return w.commit(hash)
+		})
+	}); err != nil {
+		return nil, fmt.Errorf("transferObjects: %w", err)
+	}
+	slices.Sort(hashes)
+	return hashes, nil
+}
+
+// findGitletDir returns the absolute path of the .gitlet directory of the repository at path,
+// which may be the repository's working directory or its .gitlet directory.
+// Returns an error wrapping fs.ErrNotExist if there is no repository at path.
+func findGitletDir(path string) (string, error) {
+	path, err := repoFS.Abs(path)
+	if err != nil {
+		return "", fmt.Errorf("findGitletDir: %w", err)
+	}
+	for _, dir := range []string{filepath.Join(path, gitletDir), path} {
+		if ok, err := isGitletDir(dir); err != nil {
+			return "", fmt.Errorf("findGitletDir: %w", err)
+		} else if ok {
+			return dir, nil
+		}
+	}
+	return "", fmt.Errorf("findGitletDir: no repository at '%v': %w", path, fs.ErrNotExist)
+}
+
+// isGitletDir reports whether dir is the .gitlet directory of a repository, which has a HEAD
+// file.
+func isGitletDir(dir string) (bool, error) {
+	info, err := repoFS.Stat(filepath.Join(dir, "HEAD"))
+	if err == nil && info.Mode().IsRegular() {
+		return true, nil
+	} else if err != nil && !errors.Is(err, fs.ErrNotExist) && !errors.Is(err, fs.ErrInvalid) {
+		return false, fmt.Errorf("isGitletDir: %w", err)
+	}
+	return false, nil
+}
+
+// cloneRemote copies the repository at source, a path or the URL of a served repository,
+// into a new directory, checks out the commit its HEAD names, and records the source as the
+// origin remote. An empty dir is replaced by the name of the source repository.
+func cloneRemote(source string, dir string) error {
+	remoteURL := source
+	if !isServerURL(source) {
+		remoteGitletDir, err := findGitletDir(source)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				exit("Remote directory not found.")
+			}
+			return fmt.Errorf("cloneRemote: %w", err)
+		}
+		remoteURL = remoteGitletDir
+	}
+	if dir == "" {
+		if isServerURL(source) {
+			if u, err := url.Parse(source); err == nil {
+				dir = path.Base(strings.TrimRight(u.Path, "/"))
+			}
+			if dir == "" || dir == "." || dir == "/" {
+				exit("Cannot name the destination directory after the URL; give a directory.")
+			}
+		} else {
+			dir = filepath.Base(filepath.Dir(remoteURL))
+		}
+	}
+	if entries, err := repoFS.ReadDir(dir); err == nil && len(entries) > 0 {
+		exit("Destination directory already exists and is not empty.")
+	} else if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("cloneRemote: %w", err)
+	}
+
+	var storageConfig configMap
+	if isServerURL(source) {
+		var err error
+		if storageConfig, err = cloneServer(source, dir); err != nil {
+			return fmt.Errorf("cloneRemote: %w", err)
+		}
+	} else {
+		if err := cloneRepository(remoteURL, dir); err != nil {
+			return fmt.Errorf("cloneRemote: %w", err)
+		}
+		if err := inRepository(filepath.Dir(remoteURL), func() error {
+			var err error
+			storageConfig, err = getCloneConfig()
+			return err
+		}); err != nil {
+			return fmt.Errorf("cloneRemote: %w", err)
+		}
+	}
+	if err := inRepository(dir, func() error {
+		remotes := remoteIndex{"origin": remoteMetadata{Name: "origin", URL: remoteURL}}
+		if err := writeRemoteIndex(remotes); err != nil {
+			return err
+		}
+		for _, key := range sortedKeys(storageConfig) {
+			if err := setConfig(key, storageConfig[key]); err != nil {
+				return err
+			}
+		}
+		// every cloned branch starts out matching its remote-tracking branch
+		branches, err := getRefNames(branchesDir)
+		if err != nil {
+			return err
+		}
+		for _, branch := range branches {
+			hash, err := readRef(filepath.Join(branchesDir, branch))
+			if err != nil {
+				return err
+			}
+			if err := writeRemoteTrackingBranch("origin", branch, hash); err != nil {
+				return err
+			}
+		}
+		headCommitHash, err := getHeadCommitHash()
+		if err != nil {
+			return err
+		}
+		headCommit, err := getCommit(headCommitHash)
+		if err != nil {
+			return err
+		}
+		if err := checkoutTree(headCommit); err != nil {
+			return err
+		}
+		message := "clone: from " + source
+		if err := logRefUpdate("HEAD", "", headCommitHash, message); err != nil {
+			return err
+		}
+		if branch, err := getCurrentBranch(); err != nil {
+			return err
+		} else if branch != "" {
+			return logRefUpdate(branch, "", headCommitHash, message)
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("cloneRemote: %w", err)
+	}
+	log.Printf("Cloned into '%v'.\n", dir)
+	return nil
+}
+
+// cloneConfigKeys are the settings a clone copies from its source. Large files must be stored
+// as the source stores them for checked out files to match the pointers and chunk lists the
+// source committed.
+var cloneConfigKeys = []string{"lfs.threshold", "chunk.threshold"}
+
+// getCloneConfig returns the settings of the current repository that a clone copies.
+func getCloneConfig() (configMap, error) {
+	config := make(configMap)
+	for _, key := range cloneConfigKeys {
+		value, ok, err := getConfig(key)
+		if err != nil {
+			return nil, fmt.Errorf("getCloneConfig: %w", err)
+		}
+		if ok {
+			config[key] = value
+		}
+	}
+	return config, nil
+}
+
+// cloneServer creates a repository in dir holding the branches of a served repository and
+// their history, with HEAD on the branch the served repository has checked out, or on its
+// first branch if its HEAD is detached. Returns the settings of the served repository that
+// a clone copies. Tags are not served, so the clone has none.
+func cloneServer(serverURL string, dir string) (configMap, error) {
+	resp, err := requestServer(serverURL, remoteRequest{Op: headsOp})
+	if err != nil {
+		return nil, fmt.Errorf("cloneServer: %w", err)
+	}
+	if len(resp.Heads) == 0 {
+		exit("Remote repository has no branches.")
+	}
+	head := resp.Head
+	if _, ok := resp.Heads[head]; !ok {
+		head = sortedKeys(resp.Heads)[0]
+	}
+	for _, refDir := range []string{objectsDir, branchesDir, tagsDir, remotesDir} {
+		if err := repoFS.MkdirAll(filepath.Join(dir, refDir), 0755); err != nil {
+			return nil, fmt.Errorf("cloneServer: %w", err)
+		}
+	}
+	if err := inRepository(dir, func() error {
+		for _, branch := range sortedKeys(resp.Heads) {
+			if !isBranchName(branch) {
+				return fmt.Errorf("remote sent invalid branch name '%v'", branch)
+			}
+			if err := fetchFromServer(serverURL, resp.Heads[branch]); err != nil {
+				return err
+			}
+			if err := writeRef(filepath.Join(branchesDir, branch), resp.Heads[branch], "clone"); err != nil {
+				return err
+			}
+		}
+		if err := writeRef(headFile, filepath.Join(branchesDir, head), "clone"); err != nil {
+			return err
+		}
+		return errors.Join(newIndex(), newRemoteIndex())
+	}); err != nil {
+		return nil, fmt.Errorf("cloneServer: %w", err)
+	}
+	return resp.Config, nil
+}
+
+// printRemotes prints the names of the configured remotes, each followed by its URL if
+// verbose is set.
+func printRemotes(verbose bool) error {
+	remotes, err := readRemoteIndex()
+	if err != nil {
+		return fmt.Errorf("printRemotes: %w", err)
+	}
+	for _, name := range sortedKeys(remotes) {
+		if verbose {
+			log.Printf("%v\t%v\n", name, remotes[name].URL)
+		} else {
+			log.Println(name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"testing"
+)
+
+// setupRemoteRepo creates a repository in a new directory with one committed file, leaving
+// the working directory unchanged. Returns the repository directory.
+func setupRemoteRepo(t *testing.T) string {
+	t.Helper()
+	dir := testTempDir(t)
+	if err := inRepository(dir, func() error {
+		if err := newRepository(); err != nil {
+			return err
+		}
+		if err := writeContents("wug.txt", []string{"This is a wug"}); err != nil {
+			return err
+		}
+		if err := stageFile("wug.txt"); err != nil {
+			return err
+		}
+		return newCommit("add wug")
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// commitInRepo commits a new file to the repository in dir and returns the commit hash.
+func commitInRepo(t *testing.T, dir string, file string, contents string) string {
+	t.Helper()
+	var hash string
+	if err := inRepository(dir, func() error {
+		if err := writeContents(file, []string{contents}); err != nil {
+			return err
+		}
+		if err := stageFile(file); err != nil {
+			return err
+		}
+		if err := newCommit("add " + file); err != nil {
+			return err
+		}
+		var err error
+		hash, err = getHeadCommitHash()
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
+
+func TestCloneRemote(t *testing.T) {
+	setupTestFS(t)
+	remoteDir := setupRemoteRepo(t)
+	if err := cloneRemote(remoteDir, "clone"); err != nil {
+		t.Fatal(err)
+	}
+	if contents, err := readContentsAsString(filepath.Join("clone", "wug.txt")); err != nil || contents != "This is a wug" {
+		t.Fatalf("Clone did not check out the remote head: %q, %v", contents, err)
+	}
+	enterTestDir(t, "clone")
+	branch, err := getCurrentBranch()
+	if err != nil || branch != "main" {
+		t.Errorf("Clone should check out the remote's branch, got %q, %v", branch, err)
+	}
+	remotes, err := readRemoteIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remotes["origin"].URL != filepath.Join(remoteDir, gitletDir) {
+		t.Errorf("Incorrect origin remote: %+v", remotes["origin"])
+	}
+	if index, err := readIndex(); err != nil || len(index) != 0 {
+		t.Errorf("Clone should have an empty index: %v, %v", index, err)
+	}
+}
+
+func TestPush(t *testing.T) {
+	setupTestFS(t)
+	remoteDir := setupRemoteRepo(t)
+	if err := cloneRemote(remoteDir, "clone"); err != nil {
+		t.Fatal(err)
+	}
+	enterTestDir(t, "clone")
+	if err := writeContents("notwug.txt", []string{"This is not a wug"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stageFile("notwug.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := newCommit("add notwug"); err != nil {
+		t.Fatal(err)
+	}
+	headCommit, err := getHeadCommit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	headCommitHash, err := getHeadCommitHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, branch := range []string{"main", "feature"} {
+		if err := push("origin", branch); err != nil {
+			t.Fatal(err)
+		}
+		hash, err := readContentsAsString(filepath.Join(remoteDir, branchesDir, branch))
+		if err != nil || hash != headCommitHash {
+			t.Errorf("Remote branch %v = %v, %v, want %v", branch, hash, err, headCommitHash)
+		}
+	}
+	for _, hash := range []string{headCommitHash, headCommit.FileToBlob["notwug.txt"]} {
+		if _, err := repoFS.Stat(filepath.Join(remoteDir, objectPath(hash))); err != nil {
+			t.Errorf("Object %v was not copied to the remote: %v", hash, err)
+		}
+	}
+}
+
+func TestPull(t *testing.T) {
+	setupTestFS(t)
+	remoteDir := setupRemoteRepo(t)
+	if err := cloneRemote(remoteDir, "clone"); err != nil {
+		t.Fatal(err)
+	}
+	enterTestDir(t, "clone")
+	// the local branch is behind, so it is fast-forwarded
+	remoteHeadCommitHash := commitInRepo(t, remoteDir, "notwug.txt", "This is not a wug")
+	if err := pull("origin", "main"); err != nil {
+		t.Fatal(err)
+	}
+	if headCommitHash, err := getHeadCommitHash(); err != nil || headCommitHash != remoteHeadCommitHash {
+		t.Fatalf("Pull should fast-forward to %v, got %v, %v", remoteHeadCommitHash, headCommitHash, err)
+	}
+	if contents, err := readContentsAsString("notwug.txt"); err != nil || contents != "This is not a wug" {
+		t.Errorf("Pull did not check out the fetched file: %q, %v", contents, err)
+	}
+
+	// both branches have diverged, so a merge commit is created
+	if err := writeContents("local.txt", []string{"local"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stageFile("local.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := newCommit("add local"); err != nil {
+		t.Fatal(err)
+	}
+	localHeadCommitHash, err := getHeadCommitHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	remoteHeadCommitHash = commitInRepo(t, remoteDir, "remote.txt", "remote")
+	if err := pull("origin", "main"); err != nil {
+		t.Fatal(err)
+	}
+	headCommit, err := getHeadCommit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if headCommit.ParentUIDs != [2]string{localHeadCommitHash, remoteHeadCommitHash} {
+		t.Errorf("Pull should create a merge commit, got parents %v", headCommit.ParentUIDs)
+	}
+	for _, file := range []string{"wug.txt", "notwug.txt", "local.txt", "remote.txt"} {
+		if _, ok := headCommit.FileToBlob[file]; !ok {
+			t.Errorf("Merge commit is missing %v", file)
+		}
+	}
+}
+
+func TestFetch(t *testing.T) {
+	setupTestFS(t)
+	remoteDir := setupRemoteRepo(t)
+	if err := cloneRemote(remoteDir, "clone"); err != nil {
+		t.Fatal(err)
+	}
+	enterTestDir(t, "clone")
+	headCommitHash, err := getHeadCommitHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash, err := resolveRevision("origin/main"); err != nil || hash != headCommitHash {
+		t.Fatalf("Clone should record origin/main at %v, got %v, %v", headCommitHash, hash, err)
+	}
+
+	remoteHeadCommitHash := commitInRepo(t, remoteDir, "notwug.txt", "This is not a wug")
+	if err := fetch("origin", "main"); err != nil {
+		t.Fatal(err)
+	}
+	if hash, err := resolveRevision("origin/main"); err != nil || hash != remoteHeadCommitHash {
+		t.Errorf("Fetch should move origin/main to %v, got %v, %v", remoteHeadCommitHash, hash, err)
+	}
+	if ok, err := hasObject(remoteHeadCommitHash); err != nil || !ok {
+		t.Errorf("Fetch did not copy commit %v: %v", remoteHeadCommitHash, err)
+	}
+	if hash, err := getHeadCommitHash(); err != nil || hash != headCommitHash {
+		t.Errorf("Fetch should not move the current branch, got %v, %v", hash, err)
+	}
+	if _, err := repoFS.Stat("notwug.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Fetch should not change the working directory: %v", err)
+	}
+}
+
+func TestRemoveRemote(t *testing.T) {
+	setupTestFS(t)
+	remoteDir := setupRemoteRepo(t)
+	if err := cloneRemote(remoteDir, "clone"); err != nil {
+		t.Fatal(err)
+	}
+	enterTestDir(t, "clone")
+	if err := removeRemote("origin"); err != nil {
+		t.Fatal(err)
+	}
+	remotes, err := readRemoteIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := remotes["origin"]; ok {
+		t.Errorf("The removed remote is still in the remote index: %v", remotes)
+	}
+	if _, err := repoFS.Stat(filepath.Join(remotesDir, "origin")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("The remote-tracking branches of the removed remote should be deleted: %v", err)
+	}
+}
+
+func TestPrintRemotes(t *testing.T) {
+	setupTestRepo(t)
+	tests := []struct {
+		verbose  bool
+		expected string
+	}{
+		{false, ""},
+		{true, ""},
+	}
+	for _, test := range tests {
+		out := captureLog(t)
+		if err := printRemotes(test.verbose); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != test.expected {
+			t.Errorf("printRemotes(%v) without remotes printed %q, want %q", test.verbose, out.String(), test.expected)
+		}
+	}
+
+	// remotes are printed sorted by name
+	if err := addRemote("upstream", "../upstream/.gitlet"); err != nil {
+		t.Fatal(err)
+	}
+	if err := addRemote("origin", "http://localhost:8080/wug"); err != nil {
+		t.Fatal(err)
+	}
+	upstreamURL := filepath.FromSlash("../upstream/.gitlet")
+	tests = []struct {
+		verbose  bool
+		expected string
+	}{
+		{false, "origin\nupstream\n"},
+		{true, "origin\thttp://localhost:8080/wug\nupstream\t" + upstreamURL + "\n"},
+	}
+	for _, test := range tests {
+		out := captureLog(t)
+		if err := printRemotes(test.verbose); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != test.expected {
+			t.Errorf("printRemotes(%v) printed %q, want %q", test.verbose, out.String(), test.expected)
+		}
+	}
+}
+
+func TestCollectObjects(t *testing.T) {
+	setupTestRepo(t)
+	first := commitInRepo(t, ".", "wug.txt", "This is a wug")
+	second := commitInRepo(t, ".", "notwug.txt", "This is not a wug")
+	// having the first commit skips it and its history, but not the objects the second tracks
+	sent := make(map[string]int)
+	if err := collectObjects([]string{second, first}, map[string]bool{first: true}, func(hash string, payload []byte) error {
+		sent[hash]++
+		if actual, err := getHash([][]byte{payload}); err != nil || actual != hash {
+			t.Errorf("Object %v sent with the payload of %v, %v", hash, actual, err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	c, err := getCommit(second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sent[second] != 1 || sent[c.FileToBlob["notwug.txt"]] != 1 || sent[first] != 0 || sent[initialCommitHash] != 0 {
+		t.Fatalf("Incorrect objects sent: %v", sent)
+	}
+	for hash, n := range sent {
+		if n != 1 {
+			t.Fatalf("Object %v sent %v times", hash, n)
+		}
+	}
+
+	// an error sending an object stops the collection
+	errSend := errors.New("cannot send")
+	calls := 0
+	if err := collectObjects([]string{second}, nil, func(string, []byte) error {
+		calls++
+		return errSend
+	}); !errors.Is(err, errSend) || calls != 1 {
+		t.Fatalf("Collection should stop at the first error: %v after %v objects", err, calls)
+	}
+}
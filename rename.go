@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Least similarity, as a percentage, for a deleted file and an added file to be detected
+// as a rename.
+const renameSimilarity int = 50
+
+// fileSimilarity returns how similar two file contents are, as the percentage of their lines
+// that are unchanged between them.
+func fileSimilarity(a []byte, b []byte) int {
+	aLines, bLines := splitLines(a), splitLines(b)
+	if len(aLines)+len(bLines) == 0 {
+		return 100
+	}
+	unchanged := 0
+	for _, line := range diffLines(aLines, bLines) {
+		if line.Op == ' ' {
+			unchanged++
+		}
+	}
+	return 200 * unchanged / (len(aLines) + len(bLines))
+}
+
+// findRename returns the file of the parent commit that a file added in the child commit
+// was renamed from, or empty if there is none. A file deleted in the child with the same
+// blob is preferred; otherwise the deleted file with the most similar contents is chosen,
+// if it is at least renameSimilarity percent similar.
+func findRename(parentFiles map[string]string, childFiles map[string]string, file string) (string, error) {
+	var deleted []string
+	for _, parentFile := range sortedKeys(parentFiles) {
+		if _, kept := childFiles[parentFile]; kept {
+			continue
+		}
+		if parentFiles[parentFile] == childFiles[file] {
+			return parentFile, nil
+		}
+		deleted = append(deleted, parentFile)
+	}
+	if len(deleted) == 0 {
+		return "", nil
+	}
+	_, contents, err := readBlob(childFiles[file])
+	if err != nil {
+		return "", fmt.Errorf("findRename: %w", err)
+	}
+	bestFile, bestSimilarity := "", renameSimilarity-1
+	for _, parentFile := range deleted {
+		_, parentContents, err := readBlob(parentFiles[parentFile])
+		if err != nil {
+			return "", fmt.Errorf("findRename: %w", err)
+		}
+		if similarity := fileSimilarity(parentContents, contents); similarity > bestSimilarity {
+			bestFile, bestSimilarity = parentFile, similarity
+		}
+	}
+	return bestFile, nil
+}
+
+// followRename returns the name a file had in the first parent of a commit: its old name if
+// the commit added it by renaming another file, or the same name otherwise.
+func followRename(c commit, file string) (string, error) {
+	if _, tracked := c.FileToBlob[file]; !tracked || c.ParentUIDs[0] == "" {
+		return file, nil
+	}
+	parent, err := getCommit(c.ParentUIDs[0])
+	if err != nil {
+		return "", fmt.Errorf("followRename: %w", err)
+	}
+	if _, tracked := parent.FileToBlob[file]; tracked {
+		return file, nil
+	}
+	oldFile, err := findRename(parent.FileToBlob, c.FileToBlob, file)
+	if err != nil {
+		return "", fmt.Errorf("followRename: %w", err)
+	}
+	if oldFile == "" {
+		return file, nil
+	}
+	return oldFile, nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestFileSimilarity(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected int
+	}{
+		{"", "", 100},
+		{"1\n2\n3\n4\n", "1\n2\n3\n4\n", 100},
+		{"1\n2\n3\n4\n", "1\n2\n3\n5\n", 75},
+		{"1\n2\n", "3\n4\n", 0},
+	}
+	for _, test := range tests {
+		if similarity := fileSimilarity([]byte(test.a), []byte(test.b)); similarity != test.expected {
+			t.Errorf("fileSimilarity(%q, %q) = %v, want %v", test.a, test.b, similarity, test.expected)
+		}
+	}
+}
+
+func TestLogFollow(t *testing.T) {
+	setupTestRepo(t)
+	first := commitInRepo(t, ".", "a.txt", "1\n2\n3\n4")
+	if err := moveFile("a.txt", "b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := newCommit("rename a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	renamed, err := getHeadCommitHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// rename and edit in the same commit
+	if err := unstageFile("b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFile("c.txt", []byte("1\n2\n3\n5\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := stageFile("c.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := newCommit("rename and edit b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	edited, err := getHeadCommitHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// an unrelated file added later is not followed
+	unrelated := commitInRepo(t, ".", "d.txt", "other")
+
+	commitHashes, err := getLogCommits(unrelated, logOptions{Paths: []string{"c.txt"}, Follow: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{edited, renamed, first}; !slices.Equal(commitHashes, expected) {
+		t.Fatalf("Log does not follow renames, want %v, got %v", expected, commitHashes)
+	}
+	commitHashes, err = getLogCommits(unrelated, logOptions{Paths: []string{"c.txt"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{edited}; !slices.Equal(commitHashes, expected) {
+		t.Fatalf("Log follows renames without --follow, want %v, got %v", expected, commitHashes)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+)
+
+// restoreFile overwrites a file in the working directory with its staged version, or with
+// its version in the head commit if it is not staged. The index is not changed.
+func restoreFile(file string) error {
+	file, err := normalizePath(file)
+	if err != nil {
+		return fmt.Errorf("restoreFile: %w", err)
+	}
+	unlock, err := lockRepo("restore")
+	if err != nil {
+		return fmt.Errorf("restoreFile: %w", err)
+	}
+	defer unlock()
+	index, err := readIndex()
+	if err != nil {
+		return fmt.Errorf("restoreFile: %w", err)
+	}
+	blobHash := ""
+	switch metadata, isStaged := index[file]; {
+	case isStaged && (metadata.Op == indexAdd || metadata.Op == indexConflict):
+		blobHash = metadata.Hash
+	case isStaged && metadata.Op == indexRemove:
+		exit("File is staged for removal; unstage it with restore --staged first.")
+	case isStaged:
+		exit("Cannot restore a submodule.")
+	default:
+		headCommit, err := getHeadCommit()
+		if err != nil {
+			return fmt.Errorf("restoreFile: %w", err)
+		}
+		var isTracked bool
+		if blobHash, isTracked = headCommit.FileToBlob[file]; !isTracked {
+			exit("File is not tracked.")
+		}
+	}
+	if err := materializeBlob(blobHash, file); err != nil {
+		return fmt.Errorf("restoreFile: %w", err)
+	}
+	return nil
+}
+
+// restoreStaged unstages a file, leaving the working directory unchanged. Unlike rm, a file
+// tracked in the head commit is not staged for removal. Does nothing if the file is not staged.
+func restoreStaged(file string) error {
+	file, err := normalizePath(file)
+	if err != nil {
+		return fmt.Errorf("restoreStaged: %w", err)
+	}
+	unlock, err := lockRepo("restore")
+	if err != nil {
+		return fmt.Errorf("restoreStaged: %w", err)
+	}
+	defer unlock()
+	unlockIndex, err := lockFile(indexFile, "restore")
+	if err != nil {
+		return fmt.Errorf("restoreStaged: %w", err)
+	}
+	defer unlockIndex()
+	index, err := readIndex()
+	if err != nil {
+		return fmt.Errorf("restoreStaged: %w", err)
+	}
+	metadata, isStaged := index[file]
+	if !isStaged {
+		return nil
+	}
+	delete(index, file)
+	if err := removeStagedObject(metadata, index); err != nil {
+		return fmt.Errorf("restoreStaged: %w", err)
+	}
+	if err := writeIndex(index); err != nil {
+		return fmt.Errorf("restoreStaged: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestRestoreFile(t *testing.T) {
+	setupTestRepo(t)
+	if err := writeContents("wug.txt", []string{"committed"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stageFile("wug.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := newCommit("add wug"); err != nil {
+		t.Fatal(err)
+	}
+
+	// restores the committed version when nothing is staged
+	if err := writeContents("wug.txt", []string{"modified"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := restoreFile("wug.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if contents, err := readContentsAsString("wug.txt"); err != nil || contents != "committed" {
+		t.Errorf("Expected the committed version, got %q, %v", contents, err)
+	}
+
+	// restores the staged version over later changes
+	if err := writeContents("wug.txt", []string{"staged"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stageFile("wug.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeContents("wug.txt", []string{"modified"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := restoreFile("wug.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if contents, err := readContentsAsString("wug.txt"); err != nil || contents != "staged" {
+		t.Errorf("Expected the staged version, got %q, %v", contents, err)
+	}
+}
+
+func TestRestoreStaged(t *testing.T) {
+	setupTestRepo(t)
+	if err := writeContents("wug.txt", []string{"committed"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stageFile("wug.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := newCommit("add wug"); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeContents("wug.txt", []string{"modified"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stageFile("wug.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := restoreStaged("wug.txt"); err != nil {
+		t.Fatal(err)
+	}
+	index, err := readIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(index) != 0 {
+		t.Errorf("Expected an empty index, got %v", index)
+	}
+	if contents, err := readContentsAsString("wug.txt"); err != nil || contents != "modified" {
+		t.Errorf("Unstaging should keep the working directory file, got %q, %v", contents, err)
+	}
+	// unstaging a file that is not staged does nothing
+	if err := restoreStaged("wug.txt"); err != nil {
+		t.Fatal(err)
+	}
+}
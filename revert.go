@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"strings"
+)
+
+// applyCommitDiff applies the changes from the base commit to the target commit onto the head
+// commit, and commits the result with the given message, recording action in the reflogs. An
+// empty hash stands for a commit with no files. Files changed differently by the head commit are committed with conflict
+// markers and recorded as merge conflicts.
+func applyCommitDiff(baseCommitHash string, targetCommitHash string, message string, action string) error {
+	index, err := readIndex()
+	if err != nil {
+		return fmt.Errorf("applyCommitDiff: %w", err)
+	}
+	if len(index) != 0 {
+		exit("You have uncommitted changes.")
+	}
+	var baseCommit, targetCommit commit
+	if baseCommitHash != "" {
+		if baseCommit, err = getCommit(baseCommitHash); err != nil {
+			return fmt.Errorf("applyCommitDiff: %w", err)
+		}
+	}
+	if targetCommitHash != "" {
+		if targetCommit, err = getCommit(targetCommitHash); err != nil {
+			return fmt.Errorf("applyCommitDiff: %w", err)
+		}
+	}
+	headCommitHash, err := getHeadCommitHash()
+	if err != nil {
+		return fmt.Errorf("applyCommitDiff: %w", err)
+	}
+	headCommit, err := getCommit(headCommitHash)
+	if err != nil {
+		return fmt.Errorf("applyCommitDiff: %w", err)
+	}
+
+	// check working directory for untracked files
+	wdFiles, err := walkWorkingFiles(".")
+	if err != nil {
+		return fmt.Errorf("applyCommitDiff: %w", err)
+	}
+	for _, file := range wdFiles {
+		_, isTracked := headCommit.FileToBlob[file]
+		hash, wouldBeOverwritten := targetCommit.FileToBlob[file]
+		if !isTracked && wouldBeOverwritten && hash != baseCommit.FileToBlob[file] {
+			exit("There is an untracked file in the way; delete it, or add and commit it first.")
+		}
+	}
+
+	conflicts, err := mergeCommitFiles(baseCommit, headCommit, targetCommit, mergeConflictMarkers)
+	if err != nil {
+		return fmt.Errorf("applyCommitDiff: %w", err)
+	}
+	if index, err = readIndex(); err != nil {
+		return fmt.Errorf("applyCommitDiff: %w", err)
+	}
+	if len(index) == 0 {
+		log.Println("No changes to commit.")
+		return nil
+	}
+	if err := newCommitAs(message, action); err != nil {
+		return fmt.Errorf("applyCommitDiff: %w", err)
+	}
+	if len(conflicts) > 0 {
+		// record the conflicts after the commit, which clears any earlier ones
+		if err := writeMergeState(mergeState{
+			baseCommitHash, headCommitHash, targetCommitHash, conflicts,
+		}); err != nil {
+			return fmt.Errorf("applyCommitDiff: %w", err)
+		}
+		log.Print("Encountered a merge conflict.")
+	}
+	return nil
+}
+
+// revertCommit creates a new commit undoing the changes a commit made to its first parent.
+func revertCommit(rev string) error {
+	unlock, err := lockRepo("revert")
+	if err != nil {
+		return fmt.Errorf("revertCommit: %w", err)
+	}
+	defer unlock()
+	commitHash, err := resolveRevision(rev)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			exit("No commit with that id exists.")
+		}
+		return fmt.Errorf("revertCommit: %w", err)
+	}
+	c, err := getCommit(commitHash)
+	if err != nil {
+		return fmt.Errorf("revertCommit: %w", err)
+	}
+	subject, _, _ := strings.Cut(c.Message, "\n")
+	message := fmt.Sprintf("Revert \"%v\"\n\nThis reverts commit %v.", subject, commitHash)
+	if err := applyCommitDiff(commitHash, c.ParentUIDs[0], message, "revert"); err != nil {
+		return fmt.Errorf("revertCommit: %w", err)
+	}
+	return nil
+}
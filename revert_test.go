@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRevertCommit(t *testing.T) {
+	setupTestRepo(t)
+	if err := writeContents("wug.txt", []string{"wug"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stageFile("wug.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := newCommit("add wug"); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeContents("wug.txt", []string{"changed"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stageFile("wug.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeContents("new.txt", []string{"new"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stageFile("new.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := newCommit("change wug"); err != nil {
+		t.Fatal(err)
+	}
+	changeHash, err := getHeadCommitHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := revertCommit(changeHash); err != nil {
+		t.Fatal(err)
+	}
+	headCommit, err := getHeadCommit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if headCommit.ParentUIDs[0] != changeHash {
+		t.Errorf("Revert commit parent = %v, want %v", headCommit.ParentUIDs[0], changeHash)
+	}
+	if !strings.HasPrefix(headCommit.Message, `Revert "change wug"`) {
+		t.Errorf("Incorrect revert message: %q", headCommit.Message)
+	}
+	entries, err := readReflog("HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message := entries[len(entries)-1].Message; message != `revert: Revert "change wug"` {
+		t.Errorf("Reflog message = %q, want %q", message, `revert: Revert "change wug"`)
+	}
+	if contents, err := readContentsAsString("wug.txt"); err != nil || contents != "wug" {
+		t.Errorf("Changed file was not reverted: %q, %v", contents, err)
+	}
+	if _, err := repoFS.Stat("new.txt"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Added file was not removed: %v", err)
+	}
+	if _, ok := headCommit.FileToBlob["new.txt"]; ok {
+		t.Error("Added file should not be tracked after the revert")
+	}
+}
+
+func TestRevertCommitConflict(t *testing.T) {
+	setupTestRepo(t)
+	for _, contents := range []string{"one", "two", "three"} {
+		if err := writeContents("wug.txt", []string{contents}); err != nil {
+			t.Fatal(err)
+		}
+		if err := stageFile("wug.txt"); err != nil {
+			t.Fatal(err)
+		}
+		if err := newCommit(contents); err != nil {
+			t.Fatal(err)
+		}
+	}
+	head, err := getHeadCommit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// reverting "two" conflicts with the later change to the same file
+	if err := revertCommit(head.ParentUIDs[0]); err != nil {
+		t.Fatal(err)
+	}
+	contents, err := readContentsAsString("wug.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(contents, conflictStartMarker) || !strings.Contains(contents, "three") || !strings.Contains(contents, "one") {
+		t.Errorf("Conflicted file is missing conflict markers: %q", contents)
+	}
+	state, err := readMergeState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state == nil || len(state.Conflicts) != 1 || state.Conflicts[0].File != "wug.txt" {
+		t.Errorf("Incorrect merge state: %v", state)
+	}
+}
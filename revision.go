@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Layouts accepted for dates in revisions, interpreted in local time unless they have a zone.
+var dateLayouts = []string{
+	time.RFC3339,
+	time.DateTime,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04",
+	time.DateOnly,
+}
+
+// resolveRevision returns the commit hash named by a revision: HEAD, a branch name,
+// a tag name, a remote-tracking branch such as origin/main, or a full or abbreviated commit hash, optionally followed by "@{<date>}" to name the
+// latest commit at or before that date on the first-parent history of the revision.
+// HEAD or a branch name followed by "@{<n>}" names the commit it pointed to n moves ago in its reflog.
+// An empty revision before "@{...}" means HEAD.
+// Returns an error wrapping fs.ErrNotExist if no commit matches the revision.
+func resolveRevision(rev string) (string, error) {
+	if base, spec, ok := strings.Cut(rev, "@{"); ok && strings.HasSuffix(spec, "}") {
+		if base == "" {
+			base = "HEAD"
+		}
+		spec = strings.TrimSuffix(spec, "}")
+		if n, err := strconv.Atoi(spec); err == nil && n >= 0 {
+			hash, err := getReflogCommit(base, n)
+			if err != nil {
+				return "", fmt.Errorf("resolveRevision: %w", err)
+			}
+			return hash, nil
+		}
+		t, err := parseDate(spec)
+		if err != nil {
+			return "", fmt.Errorf("resolveRevision: %w", err)
+		}
+		hash, err := resolveRevision(base)
+		if err != nil {
+			return "", fmt.Errorf("resolveRevision: %w", err)
+		}
+		hash, err = commitAsOf(hash, t)
+		if err != nil {
+			return "", fmt.Errorf("resolveRevision: %w", err)
+		}
+		return hash, nil
+	}
+
+	if rev == "HEAD" {
+		hash, err := getHeadCommitHash()
+		if err != nil {
+			return "", fmt.Errorf("resolveRevision: %w", err)
+		}
+		return hash, nil
+	}
+	if hash, err := readRef(filepath.Join(branchesDir, rev)); err == nil {
+		return hash, nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return "", fmt.Errorf("resolveRevision: %w", err)
+	}
+	if hash, err := readRef(filepath.Join(tagsDir, rev)); err == nil {
+		if hash, err = peelTag(hash); err != nil {
+			return "", fmt.Errorf("resolveRevision: %w", err)
+		}
+		return hash, nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return "", fmt.Errorf("resolveRevision: %w", err)
+	}
+	if strings.Contains(rev, "/") {
+		if hash, err := readRef(filepath.Join(remotesDir, rev)); err == nil {
+			return hash, nil
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return "", fmt.Errorf("resolveRevision: %w", err)
+		}
+	}
+	hash := rev
+	if len(hash) < hashLength {
+		resolved, err := resolveHash(hash)
+		if err != nil {
+			return "", fmt.Errorf("resolveRevision: %w", err)
+		}
+		hash = resolved
+	}
+	header, err := parseBlobHeader(hash)
+	if err != nil {
+		return "", fmt.Errorf("resolveRevision: %w", err)
+	}
+	if header != "commit" {
+		return "", fmt.Errorf("resolveRevision: '%v' is not a commit: %w", rev, fs.ErrNotExist)
+	}
+	return hash, nil
+}
+
+// parseDate parses a date such as "2024-06-01" or "2024-06-01 13:30:00".
+func parseDate(s string) (time.Time, error) {
+	for _, layout := range dateLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("parseDate: invalid date '%v', want YYYY-MM-DD [hh:mm[:ss]]", s)
+}
+
+// commitAsOf returns the latest commit at or before a time, following first parents from a commit.
+// Returns an error wrapping fs.ErrNotExist if every commit is later than the time.
+func commitAsOf(commitHash string, t time.Time) (string, error) {
+	for hash := commitHash; hash != ""; {
+		c, err := getCommit(hash)
+		if err != nil {
+			return "", fmt.Errorf("commitAsOf: %w", err)
+		}
+		if c.Timestamp <= t.Unix() {
+			return hash, nil
+		}
+		hash = c.ParentUIDs[0]
+	}
+	return "", fmt.Errorf("commitAsOf: no commit at or before %v: %w", t.Format(time.DateTime), fs.ErrNotExist)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestCheckoutAsOf(t *testing.T) {
+	setupTestRepo(t)
+	if err := writeContents("wug.txt", []string{"This is a wug"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stageFile("wug.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := newCommit("add wug file"); err != nil {
+		t.Fatal(err)
+	}
+	mainHash, err := getHeadCommitHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tomorrow := time.Now().Add(24 * time.Hour).Format(time.DateOnly)
+	if hash, err := resolveRevision("main@{" + tomorrow + "}"); err != nil || hash != mainHash {
+		t.Fatalf("Incorrect commit as of tomorrow: want %v, got %v, %v", mainHash, hash, err)
+	}
+	hash, err := resolveRevision("@{1970-01-02}")
+	if err != nil || hash != initialCommitHash {
+		t.Fatalf("Incorrect commit as of 1970: want %v, got %v, %v", initialCommitHash, hash, err)
+	}
+
+	// a detached HEAD moves with new commits while the branch stays put
+	if err := checkoutDetached(hash); err != nil {
+		t.Fatal(err)
+	}
+	if branch, err := getCurrentBranch(); err != nil || branch != "" {
+		t.Fatalf("HEAD is not detached: %v, %v", branch, err)
+	}
+	if _, err := repoFS.Stat("wug.txt"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("File from later commit remains after checkout: %v", err)
+	}
+	if err := writeContents("notwug.txt", []string{"This is not a wug"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stageFile("notwug.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := newCommit("add notwug file"); err != nil {
+		t.Fatal(err)
+	}
+	detachedHash, err := getHeadCommitHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c, err := getCommit(detachedHash); err != nil || c.ParentUIDs[0] != initialCommitHash {
+		t.Fatalf("Incorrect parent of detached commit: %v, %v", c.ParentUIDs, err)
+	}
+	if hash, err := resolveRevision("main"); err != nil || hash != mainHash {
+		t.Fatalf("Branch moved by detached commit: want %v, got %v, %v", mainHash, hash, err)
+	}
+
+	if err := checkoutBranch("main"); err != nil {
+		t.Fatal(err)
+	}
+	if contents, err := readContentsAsString("wug.txt"); err != nil || contents != "This is a wug" {
+		t.Fatalf("Incorrect file after returning to branch: %v, %v", contents, err)
+	}
+}
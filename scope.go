@@ -0,0 +1,224 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Configuration key of the default scope, used when no --scope option is given.
+const scopeConfigKey string = "core.scope"
+
+// Subtree of the repository that status, add -A, and log are restricted to, as a
+// normalized path. Empty when commands operate on the whole repository.
+var scope string
+
+// extractScopeOption removes a --scope <dir> or --scope=<dir> option given before the
+// command from the arguments, and returns the remaining arguments and the scope.
+func extractScopeOption(args []string) ([]string, string, error) {
+	if len(args) < 2 {
+		return args, "", nil
+	}
+	if dir, ok := strings.CutPrefix(args[1], "--scope="); ok {
+		return slices.Delete(slices.Clone(args), 1, 2), dir, nil
+	}
+	if args[1] == "--scope" {
+		if len(args) < 3 {
+			return nil, "", errors.New("extractScopeOption: --scope requires a directory")
+		}
+		return slices.Delete(slices.Clone(args), 1, 3), args[2], nil
+	}
+	return args, "", nil
+}
+
+// loadScope restricts commands to the given directory of the repository, or to the
+// directory configured by core.scope if dir is empty.
+func loadScope(dir string) error {
+	if dir == "" {
+		value, ok, err := getConfig(scopeConfigKey)
+		if err != nil {
+			return fmt.Errorf("loadScope: %w", err)
+		}
+		if !ok {
+			scope = ""
+			return nil
+		}
+		dir = value
+	}
+	dir, err := normalizePath(dir)
+	if err != nil {
+		return fmt.Errorf("loadScope: %w", err)
+	}
+	info, err := repoFS.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("loadScope: %w", err)
+	}
+	if !info.IsDir() {
+		return &pathspecError{dir, "scope is not a directory"}
+	}
+	scope = dir
+	return nil
+}
+
+// inScope reports whether a file is inside the current scope.
+func inScope(file string) bool {
+	return scope == "" || file == scope || strings.HasPrefix(file, scope+"/")
+}
+
+// getWorkingFiles returns the files in the working directory that commands consider, sorted.
+// Without a scope these are the files anywhere in the working directory. With a scope they
+// are the files anywhere under the scope directory, and no other directory is read.
+// Nested repositories, such as submodules, are skipped.
+func getWorkingFiles() ([]string, error) {
+	dir := scope
+	if dir == "" {
+		dir = "."
+	}
+	files, err := walkWorkingFiles(dir)
+	if err != nil {
+		return nil, fmt.Errorf("getWorkingFiles: %w", err)
+	}
+	return files, nil
+}
+
+// walkWorkingFiles returns the files anywhere under a directory of the working directory as
+// slash-separated paths from the repository root, sorted. Nested repositories, such as
+// submodules, are skipped.
+func walkWorkingFiles(dir string) ([]string, error) {
+	var files []string
+	err := walkDir(filepath.FromSlash(dir), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == gitletDir {
+				return fs.SkipDir
+			}
+			if _, err := repoFS.Stat(filepath.Join(path, gitletDir)); err == nil && path != "." {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.Type().IsRegular() {
+			files = append(files, filepath.ToSlash(path))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("walkWorkingFiles: %w", err)
+	}
+	slices.Sort(files)
+	return files, nil
+}
+
+// getScopeFiles returns the file to blob mapping of the files of a commit within the current
+// scope. With a scope, only the trees on the path to the scope directory and the trees under
+// it are read, so the files elsewhere in the repository are never listed. Commits written
+// before trees list every file, which are filtered instead.
+func getScopeFiles(c commit) (map[string]string, error) {
+	if scope == "" || c.Tree == "" {
+		files, err := getCommitFiles(c)
+		if err != nil {
+			return nil, fmt.Errorf("getScopeFiles: %w", err)
+		}
+		if scope != "" {
+			files = maps.Clone(files)
+			maps.DeleteFunc(files, func(file string, _ string) bool { return !inScope(file) })
+		}
+		return files, nil
+	}
+	treeHash, err := getSubtreeHash(c.Tree, scope)
+	if err != nil {
+		return nil, fmt.Errorf("getScopeFiles: %w", err)
+	}
+	files := make(map[string]string)
+	if treeHash == "" {
+		return files, nil
+	}
+	if err := addTreeFiles(files, treeHash, scope+"/"); err != nil {
+		return nil, fmt.Errorf("getScopeFiles: %w", err)
+	}
+	return files, nil
+}
+
+// getHeadScopeFiles returns the head commit, without reading its tree, and its files within
+// the current scope.
+func getHeadScopeFiles() (commit, map[string]string, error) {
+	headCommitHash, err := getHeadCommitHash()
+	if err != nil {
+		return commit{}, nil, fmt.Errorf("getHeadScopeFiles: %w", err)
+	}
+	headCommit, err := getCommitMetadata(headCommitHash)
+	if err != nil {
+		return commit{}, nil, fmt.Errorf("getHeadScopeFiles: %w", err)
+	}
+	files, err := getScopeFiles(headCommit)
+	if err != nil {
+		return commit{}, nil, fmt.Errorf("getHeadScopeFiles: %w", err)
+	}
+	return headCommit, files, nil
+}
+
+// commitTouchesScope reports whether a commit added, removed, or modified a file in the
+// current scope compared to its first parent. Commits recording their files as trees are
+// compared by the trees of the scope directory, without listing their files.
+func commitTouchesScope(c commit) (bool, error) {
+	var parent commit
+	if c.ParentUIDs[0] != "" {
+		var err error
+		if parent, err = getCommitMetadata(c.ParentUIDs[0]); err != nil {
+			return false, fmt.Errorf("commitTouchesScope: %w", err)
+		}
+	}
+	// a parent without a tree or files is the initial commit
+	if c.Tree != "" && (parent.Tree != "" || len(parent.FileToBlob) == 0) {
+		treeHash, err := getSubtreeHash(c.Tree, scope)
+		if err != nil {
+			return false, fmt.Errorf("commitTouchesScope: %w", err)
+		}
+		var parentTreeHash string
+		if parent.Tree != "" {
+			if parentTreeHash, err = getSubtreeHash(parent.Tree, scope); err != nil {
+				return false, fmt.Errorf("commitTouchesScope: %w", err)
+			}
+		}
+		return treeHash != parentTreeHash, nil
+	}
+	changes, err := diffCommitFiles(parent, c)
+	if err != nil {
+		return false, fmt.Errorf("commitTouchesScope: %w", err)
+	}
+	for _, change := range changes {
+		if inScope(change.File) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// stageAll stages every change in the current scope: untracked and modified files are
+// staged, and deleted files are staged for removal.
+func stageAll() error {
+	entries, err := getStatusEntries()
+	if err != nil {
+		return fmt.Errorf("stageAll: %w", err)
+	}
+	var files []string
+	for _, entry := range entries {
+		if entry.Y == ' ' || entry.Y == 'U' {
+			continue
+		}
+		files = append(files, entry.File)
+	}
+	if len(files) == 0 {
+		return nil
+	}
+	if err := stageFiles(files); err != nil {
+		return fmt.Errorf("stageAll: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestExtractScopeOption(t *testing.T) {
+	tests := []struct {
+		args          []string
+		expectedArgs  []string
+		expectedScope string
+	}{
+		{[]string{"gitlet", "status"}, []string{"gitlet", "status"}, ""},
+		{[]string{"gitlet", "--scope", "sub", "status"}, []string{"gitlet", "status"}, "sub"},
+		{[]string{"gitlet", "--scope=sub", "log"}, []string{"gitlet", "log"}, "sub"},
+	}
+	for _, test := range tests {
+		args, dir, err := extractScopeOption(test.args)
+		if err != nil || !slices.Equal(args, test.expectedArgs) || dir != test.expectedScope {
+			t.Errorf("extractScopeOption(%v) = %v, %q, %v", test.args, args, dir, err)
+		}
+	}
+	if _, _, err := extractScopeOption([]string{"gitlet", "--scope"}); err == nil {
+		t.Error("Missing scope directory should be an error")
+	}
+}
+
+func TestScope(t *testing.T) {
+	setupTestRepo(t)
+	t.Cleanup(func() { scope = "" })
+	for _, dir := range []string{"sub/deep", "other"} {
+		if err := repoFS.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, file := range []string{"a.txt", "sub/b.txt", "sub/deep/c.txt", "other/d.txt", "subway.txt"} {
+		if err := writeContents(file, []string{file}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := setConfig(scopeConfigKey, "sub"); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadScope(""); err != nil {
+		t.Fatal(err)
+	}
+	if scope != "sub" {
+		t.Fatalf("Incorrect scope from config: want 'sub', got %q", scope)
+	}
+
+	files, err := getWorkingFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"sub/b.txt", "sub/deep/c.txt"}; !slices.Equal(files, expected) {
+		t.Fatalf("Incorrect working files: want %v, got %v", expected, files)
+	}
+
+	if err := stageAll(); err != nil {
+		t.Fatal(err)
+	}
+	index, err := readIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if staged := sortedKeys(index); !slices.Equal(staged, files) {
+		t.Fatalf("Incorrect staged files: want %v, got %v", files, staged)
+	}
+	if err := newCommit("add sub"); err != nil {
+		t.Fatal(err)
+	}
+	headCommit, err := getHeadCommit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if touches, err := commitTouchesScope(headCommit); err != nil || !touches {
+		t.Fatalf("Commit adding scoped files should touch the scope: %v, %v", touches, err)
+	}
+
+	if err := repoFS.Remove("sub/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := getStatusEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []statusEntry{{' ', 'D', "sub/b.txt"}}; !reflect.DeepEqual(entries, expected) {
+		t.Fatalf("Incorrect scoped status: want %v, got %v", expected, entries)
+	}
+
+	// files outside the scope are committed without touching it
+	scope = ""
+	if err := stageFile("a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := newCommit("add a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadScope("sub"); err != nil {
+		t.Fatal(err)
+	}
+	if headCommit, err = getHeadCommit(); err != nil {
+		t.Fatal(err)
+	}
+	if touches, err := commitTouchesScope(headCommit); err != nil || touches {
+		t.Fatalf("Commit adding unscoped files should not touch the scope: %v, %v", touches, err)
+	}
+
+	// the trees of directories outside the scope are never read
+	scope = ""
+	if err := stageFile("other/d.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := newCommit("add other"); err != nil {
+		t.Fatal(err)
+	}
+	if headCommit, err = getHeadCommit(); err != nil {
+		t.Fatal(err)
+	}
+	treeEntries, err := readTreeEntries(headCommit.Tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range treeEntries {
+		if entry.Name == "other" {
+			if err := repoFS.Remove(objectPath(entry.Hash)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	resetObjectCache()
+	if err := loadScope("sub"); err != nil {
+		t.Fatal(err)
+	}
+	if entries, err := getStatusEntries(); err != nil || len(entries) != 1 {
+		t.Fatalf("Incorrect scoped status without trees outside the scope: %v, %v", entries, err)
+	}
+	headHash, err := getHeadCommitHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hashes, err := getLogCommits(headHash, logOptions{}); err != nil || len(hashes) != 1 {
+		t.Fatalf("Incorrect scoped log without trees outside the scope: %v, %v", hashes, err)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"log"
+	"slices"
+)
+
+// Author shown by shortlog for commits that do not record one.
+const unknownAuthor string = "(unknown)"
+
+// shortlogGroup is the commits of one author.
+type shortlogGroup struct {
+	Author   string
+	Subjects []string // Subjects of the author's commits, oldest first.
+}
+
+// getShortlog groups the commits on the first-parent history of a commit by author.
+// Groups are sorted by author, or by number of commits, most first, if byCount is set.
+func getShortlog(commitHash string, byCount bool) ([]shortlogGroup, error) {
+	subjects := make(map[string][]string)
+	for commitHash != "" {
+		c, err := getCommit(commitHash)
+		if err != nil {
+			return nil, fmt.Errorf("getShortlog: %w", err)
+		}
+		author := c.Author
+		if author == "" {
+			author = unknownAuthor
+		}
+		subjects[author] = append(subjects[author], c.Subject())
+		commitHash = c.ParentUIDs[0]
+	}
+	groups := make([]shortlogGroup, 0, len(subjects))
+	for _, author := range sortedKeys(subjects) {
+		slices.Reverse(subjects[author])
+		groups = append(groups, shortlogGroup{author, subjects[author]})
+	}
+	if byCount {
+		slices.SortStableFunc(groups, func(a, b shortlogGroup) int {
+			return cmp.Compare(len(b.Subjects), len(a.Subjects))
+		})
+	}
+	return groups, nil
+}
+
+// printShortlog prints the commits from HEAD grouped by author, with the number of commits
+// of each author. With summary, only the counts are printed.
+func printShortlog(byCount bool, summary bool) error {
+	headCommitHash, err := getHeadCommitHash()
+	if err != nil {
+		return fmt.Errorf("printShortlog: %w", err)
+	}
+	groups, err := getShortlog(headCommitHash, byCount)
+	if err != nil {
+		return fmt.Errorf("printShortlog: %w", err)
+	}
+	for _, group := range groups {
+		if summary {
+			log.Printf("%6d\t%v\n", len(group.Subjects), group.Author)
+			continue
+		}
+		log.Printf("%v (%v):\n", group.Author, len(group.Subjects))
+		for _, subject := range group.Subjects {
+			log.Printf("      %v\n", subject)
+		}
+		log.Println()
+	}
+	return nil
+}
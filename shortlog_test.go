@@ -0,0 +1,48 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGetShortlog(t *testing.T) {
+	setupTestRepo(t)
+	for i, author := range []string{"Wug", "Notwug", "Wug"} {
+		if err := setConfig("user.name", author); err != nil {
+			t.Fatal(err)
+		}
+		if err := writeContents("wug.txt", []string{author, string(rune('0' + i))}); err != nil {
+			t.Fatal(err)
+		}
+		if err := stageFile("wug.txt"); err != nil {
+			t.Fatal(err)
+		}
+		if err := newCommit("commit " + string(rune('0'+i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	headCommitHash, err := getHeadCommitHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	groups, err := getShortlog(headCommitHash, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []shortlogGroup{
+		{unknownAuthor, []string{"initial commit"}},
+		{"Notwug", []string{"commit 1"}},
+		{"Wug", []string{"commit 0", "commit 2"}},
+	}
+	if !reflect.DeepEqual(groups, expected) {
+		t.Errorf("Expected %v, got %v", expected, groups)
+	}
+	groups, err = getShortlog(headCommitHash, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if groups[0].Author != "Wug" {
+		t.Errorf("Expected Wug first when sorted by count, got %v", groups)
+	}
+}
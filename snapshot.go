@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"time"
+)
+
+// snapshotWorkingTree writes a blob for every file in the working directory and returns a
+// commit recording them, without touching the index, HEAD, or any branch. The commit's
+// parents are the head commit and the given parent, which may be empty. The commit itself
+// is not written, so callers can discard snapshots that record nothing new.
+func snapshotWorkingTree(message string, parentHash string) (commit, error) {
+	headCommitHash, err := getHeadCommitHash()
+	if err != nil {
+		return commit{}, fmt.Errorf("snapshotWorkingTree: %w", err)
+	}
+	files, err := walkWorkingFiles(".")
+	if err != nil {
+		return commit{}, fmt.Errorf("snapshotWorkingTree: %w", err)
+	}
+
+	c := commit{
+		Message:    message,
+		Timestamp:  time.Now().UTC().Unix(),
+		FileToBlob: make(map[string]string, len(files)),
+		ParentUIDs: [2]string{headCommitHash, parentHash},
+	}
+	for _, file := range files {
+		hash, err := writeFileBlob(file)
+		if errors.Is(err, fs.ErrNotExist) {
+			// removed while the snapshot was being taken
+			continue
+		} else if err != nil {
+			return commit{}, fmt.Errorf("snapshotWorkingTree: %w", err)
+		}
+		c.FileToBlob[file] = hash
+	}
+	return c, nil
+}
+
+// sameSnapshotFiles reports whether two snapshots record the same file contents.
+func sameSnapshotFiles(a commit, b commit) bool {
+	return maps.Equal(a.FileToBlob, b.FileToBlob)
+}
+
+// restoreSnapshot writes every file recorded by a snapshot into the working directory,
+// overwriting existing files. Other files, the index, and HEAD are left untouched.
+func restoreSnapshot(snapshotHash string) error {
+	snapshot, err := getCommit(snapshotHash)
+	if err != nil {
+		return fmt.Errorf("restoreSnapshot: %w", err)
+	}
+	if err := materializeFiles(snapshot.FileToBlob); err != nil {
+		return fmt.Errorf("restoreSnapshot: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+// Maximum number of files hashed and written concurrently when staging.
+const maxStageWorkers int = 32
+
+// workingBlob is the blob written for a file in the working directory.
+type workingBlob struct {
+	Hash    string
+	Size    int64 // Size of the working file in bytes.
+	Created bool  // Blob did not exist before.
+}
+
+// writeWorkingBlobs reads files from the working directory and writes the blobs that store
+// them, along with their media if they are large, by file. Files are hashed and written
+// concurrently by a bounded pool of workers. Errors for individual files are collected and
+// returned together once every file has been attempted.
+func writeWorkingBlobs(files []string) (map[string]workingBlob, error) {
+	blobs := make(map[string]workingBlob, len(files))
+	if len(files) == 0 {
+		return blobs, nil
+	}
+	queue := make(chan string)
+	var mu sync.Mutex
+	var errs []error
+	var wg sync.WaitGroup
+	workers := min(runtime.NumCPU()*2, maxStageWorkers, len(files))
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for file := range queue {
+				blob, err := writeWorkingBlob(file)
+				mu.Lock()
+				if err != nil {
+					errs = append(errs, err)
+				} else {
+					blobs[file] = blob
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, file := range files {
+		queue <- file
+	}
+	close(queue)
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return nil, fmt.Errorf("writeWorkingBlobs: %w", err)
+	}
+	return blobs, nil
+}
+
+// writeWorkingBlob writes the blob that stores a file in the working directory, streaming the
+// file so it is never read into memory whole.
+func writeWorkingBlob(file string) (workingBlob, error) {
+	f, err := repoFS.Open(file)
+	if err != nil {
+		return workingBlob{}, fmt.Errorf("writeWorkingBlob: cannot read file '%v': %w", file, err)
+	}
+	defer f.Close()
+	fileInfo, err := f.Stat()
+	if err != nil {
+		return workingBlob{}, fmt.Errorf("writeWorkingBlob: cannot read file '%v': %w", file, err)
+	}
+	hash, created, err := storeFileContents(f, fileInfo.Size(), true)
+	if err != nil {
+		return workingBlob{}, fmt.Errorf("writeWorkingBlob: could not write blob of '%v': %w", file, err)
+	}
+	return workingBlob{hash, fileInfo.Size(), created}, nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestStageFiles(t *testing.T) {
+	setupTestRepo(t)
+	var files []string
+	for i := range 100 {
+		file := fmt.Sprintf("wug%03d.txt", i)
+		if err := writeContents(file, []string{fmt.Sprintf("This is wug %v\n", i)}); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, file)
+	}
+	if err := stageFiles(files); err != nil {
+		t.Fatal(err)
+	}
+	index, err := readIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(index) != len(files) {
+		t.Fatalf("Incorrect number of staged files: want %v, got %v", len(files), len(index))
+	}
+	for i, file := range files {
+		metadata := index[file]
+		if metadata.Op != indexAdd {
+			t.Fatalf("Incorrect index entry for %v: %+v", file, metadata)
+		}
+		expected := fmt.Sprintf("This is wug %v\n", i)
+		if _, contents, err := readBlob(metadata.Hash); err != nil || string(contents) != expected {
+			t.Fatalf("Incorrect staged blob of %v: want %q, got %q, %v", file, expected, contents, err)
+		}
+	}
+}
+
+func TestStageFilesKeepsSharedBlob(t *testing.T) {
+	setupTestRepo(t)
+	for _, file := range []string{"wug.txt", "notwug.txt"} {
+		if err := writeContents(file, []string{"This is a wug"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := stageFiles([]string{"wug.txt", "notwug.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	index, err := readIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sharedHash := index["notwug.txt"].Hash
+	// re-staging one file must not delete the blob the other file still stages
+	if err := writeContents("wug.txt", []string{"This is a changed wug"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stageFile("wug.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := hasObject(sharedHash); err != nil || !ok {
+		t.Fatalf("Blob staged for notwug.txt was deleted: %v", err)
+	}
+}
+
+func TestStoreFileContents(t *testing.T) {
+	setupTestRepo(t)
+	for _, test := range []struct {
+		name, key, threshold, header string
+		contents                     []byte
+	}{
+		{"small", "", "", "file", []byte("This is a wug\n")},
+		{"large", "", "", "file", randomContents(3 << 20)},
+		{"chunked", "chunk.threshold", "1m", "chunked", randomContents(3 << 20)},
+		{"media", "lfs.threshold", "1m", "lfs", randomContents(3 << 20)},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if test.key != "" {
+				if err := setConfig(test.key, test.threshold); err != nil {
+					t.Fatal(err)
+				}
+				t.Cleanup(func() { setConfig(test.key, "") })
+			}
+			if err := writeContents("wug.bin", [][]byte{test.contents}); err != nil {
+				t.Fatal(err)
+			}
+			hash, err := hashWorkingFile("wug.bin")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok, err := hasObject(hash); err != nil || ok {
+				t.Fatalf("Hashing a file should not write its blob: %v", err)
+			}
+			blob, err := writeWorkingBlob("wug.bin")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if blob.Hash != hash || blob.Size != int64(len(test.contents)) || !blob.Created {
+				t.Fatalf("Incorrect staged blob: want hash %v of %v bytes, got %+v", hash, len(test.contents), blob)
+			}
+			if test.header == "file" {
+				if want, err := getHash(blobPayload("file", test.contents)); err != nil || hash != want {
+					t.Fatalf("Streamed hash differs from the blob hash: want %v, got %v, %v", want, hash, err)
+				}
+			}
+			if header, err := parseBlobHeader(hash); err != nil || header != test.header {
+				t.Fatalf("Incorrect blob type: want %q, got %q, %v", test.header, header, err)
+			}
+			if err := verifyBlob(hash); err != nil {
+				t.Fatalf("Streamed blob should verify: %v", err)
+			}
+			if _, contents, err := readBlob(hash); err != nil || !bytes.Equal(contents, test.contents) {
+				t.Fatalf("Streamed blob does not read as the file contents: %v", err)
+			}
+		})
+	}
+}
+
+func TestStageLargeFileRoundTrip(t *testing.T) {
+	setupTestRepo(t)
+	contents := randomContents(5 << 20)
+	if err := writeContents("wug.bin", [][]byte{contents}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stageFile("wug.bin"); err != nil {
+		t.Fatal(err)
+	}
+	if err := newCommit("add large wug"); err != nil {
+		t.Fatal(err)
+	}
+	if err := repoFS.Remove("wug.bin"); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkoutHeadCommit("wug.bin"); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := readFile("wug.bin"); err != nil || !bytes.Equal(b, contents) {
+		t.Fatalf("Checked out file differs from the committed file: %v", err)
+	}
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Stack of stashed changes, newest first.
+var stashFile = filepath.Join(refsDir, "stash")
+
+// stashEntry is a set of uncommitted changes set aside by stash.
+type stashEntry struct {
+	Hash      string // Commit recording the working directory. Its second parent records the index.
+	Message   string
+	Timestamp int64
+}
+
+// readStash returns the stashed changes, newest first.
+func readStash() ([]stashEntry, error) {
+	b, err := readContents(stashFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("readStash: %w", err)
+	}
+	entries, err := deserialize[[]stashEntry](b)
+	if err != nil {
+		return nil, fmt.Errorf("readStash: %w", err)
+	}
+	return entries, nil
+}
+
+// writeStash records the stashed changes, newest first.
+func writeStash(entries []stashEntry) error {
+	b, err := serialize(entries)
+	if err != nil {
+		return fmt.Errorf("writeStash: %w", err)
+	}
+	if err := writeFileAtomic(stashFile, b); err != nil {
+		return fmt.Errorf("writeStash: %w", err)
+	}
+	return nil
+}
+
+// stashChanges saves the staged changes and the working directory versions of every tracked
+// or staged file onto the stash, then returns the index and those files to the head commit.
+// Untracked files are left alone. An empty message is replaced by a description of HEAD.
+func stashChanges(message string) error {
+	unlock, err := lockRepo("stash")
+	if err != nil {
+		return fmt.Errorf("stashChanges: %w", err)
+	}
+	defer unlock()
+	headCommitHash, err := getHeadCommitHash()
+	if err != nil {
+		return fmt.Errorf("stashChanges: %w", err)
+	}
+	headCommit, err := getCommit(headCommitHash)
+	if err != nil {
+		return fmt.Errorf("stashChanges: %w", err)
+	}
+	unlockIndex, err := lockFile(indexFile, "stash")
+	if err != nil {
+		return fmt.Errorf("stashChanges: %w", err)
+	}
+	defer unlockIndex()
+	index, err := readIndex()
+	if err != nil {
+		return fmt.Errorf("stashChanges: %w", err)
+	}
+	if message == "" {
+		branch, err := getCurrentBranch()
+		if err != nil {
+			return fmt.Errorf("stashChanges: %w", err)
+		}
+		if branch == "" {
+			branch = "(no branch)"
+		}
+		message = fmt.Sprintf("WIP on %v: %v", branch, headCommit.Oneline(headCommitHash))
+	}
+
+	// record the index as the head commit with the staged changes applied
+	indexCommit := commit{
+		Message:    "index on " + message,
+		Timestamp:  time.Now().UTC().Unix(),
+		FileToBlob: maps.Clone(headCommit.FileToBlob),
+		ParentUIDs: [2]string{headCommitHash, ""},
+	}
+	for file, metadata := range index {
+		switch metadata.Op {
+		case indexRemove:
+			delete(indexCommit.FileToBlob, file)
+		case indexSubmodule:
+			pinSubmodule(&indexCommit, file, metadata.Hash)
+		default:
+			indexCommit.FileToBlob[file] = metadata.Hash
+		}
+	}
+
+	// record the working directory versions of the tracked and staged files
+	workCommit := commit{
+		Message:    message,
+		Timestamp:  indexCommit.Timestamp,
+		FileToBlob: make(map[string]string),
+	}
+	for _, file := range sortedKeys(indexCommit.FileToBlob) {
+		hash, err := writeFileBlob(file)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("stashChanges: %w", err)
+		}
+		workCommit.FileToBlob[file] = hash
+	}
+	if len(index) == 0 && maps.Equal(workCommit.FileToBlob, headCommit.FileToBlob) {
+		log.Println("No local changes to save.")
+		return nil
+	}
+
+	indexCommitHash, err := writeCommitBlob(indexCommit)
+	if err != nil {
+		return fmt.Errorf("stashChanges: %w", err)
+	}
+	workCommit.ParentUIDs = [2]string{headCommitHash, indexCommitHash}
+	workCommitHash, err := writeCommitBlob(workCommit)
+	if err != nil {
+		return fmt.Errorf("stashChanges: %w", err)
+	}
+	entries, err := readStash()
+	if err != nil {
+		return fmt.Errorf("stashChanges: %w", err)
+	}
+	entries = append([]stashEntry{{workCommitHash, message, workCommit.Timestamp}}, entries...)
+	if err := writeStash(entries); err != nil {
+		return fmt.Errorf("stashChanges: %w", err)
+	}
+
+	// return the stashed files to their versions in the head commit
+	changed := make(map[string]string)
+	for file, hash := range headCommit.FileToBlob {
+		if workCommit.FileToBlob[file] != hash {
+			changed[file] = hash
+		}
+	}
+	if err := materializeFiles(changed); err != nil {
+		return fmt.Errorf("stashChanges: %w", err)
+	}
+	for file := range workCommit.FileToBlob {
+		if _, isTracked := headCommit.FileToBlob[file]; !isTracked {
+			if err := restrictedDelete(file); err != nil {
+				return fmt.Errorf("stashChanges: %w", err)
+			}
+		}
+	}
+	if err := newIndex(); err != nil {
+		return fmt.Errorf("stashChanges: %w", err)
+	}
+	if err := clearMergeState(); err != nil {
+		return fmt.Errorf("stashChanges: %w", err)
+	}
+	log.Printf("Saved working directory and index state: %v\n", message)
+	return nil
+}
+
+// parseStashRef parses a stash entry position given as n or stash@{n}.
+func parseStashRef(ref string) (int, error) {
+	if inner, ok := strings.CutPrefix(ref, "stash@{"); ok {
+		ref, ok = strings.CutSuffix(inner, "}")
+		if !ok {
+			return 0, fmt.Errorf("parseStashRef: invalid stash '%v'", ref)
+		}
+	}
+	n, err := strconv.Atoi(ref)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("parseStashRef: invalid stash '%v'", ref)
+	}
+	return n, nil
+}
+
+// getStashEntry returns the stash entry at position n, where 0 is the newest.
+func getStashEntry(n int) (stashEntry, error) {
+	entries, err := readStash()
+	if err != nil {
+		return stashEntry{}, fmt.Errorf("getStashEntry: %w", err)
+	}
+	if n >= len(entries) {
+		exit("No stash entry with that index exists.")
+	}
+	return entries[n], nil
+}
+
+// printStashList prints every stash entry, newest first.
+func printStashList() error {
+	entries, err := readStash()
+	if err != nil {
+		return fmt.Errorf("printStashList: %w", err)
+	}
+	for i, entry := range entries {
+		log.Printf(
+			"stash@{%v}: %v (%v)\n",
+			i, entry.Message, time.Unix(entry.Timestamp, 0).Local().Format(time.DateTime),
+		)
+	}
+	return nil
+}
+
+// applyStash restores the changes of stash entry n. Every file the stash changed is written
+// with its stashed version, or deleted, and its stashed index state is staged again.
+// Other files are left alone. The entry stays on the stash.
+// Fails without changing anything if a file the stash changed has uncommitted changes or is
+// untracked in the working directory.
+func applyStash(n int) error {
+	unlock, err := lockRepo("stash apply")
+	if err != nil {
+		return fmt.Errorf("applyStash: %w", err)
+	}
+	defer unlock()
+	entry, err := getStashEntry(n)
+	if err != nil {
+		return fmt.Errorf("applyStash: %w", err)
+	}
+	workCommit, err := getCommit(entry.Hash)
+	if err != nil {
+		return fmt.Errorf("applyStash: %w", err)
+	}
+	baseCommit, err := getCommit(workCommit.ParentUIDs[0])
+	if err != nil {
+		return fmt.Errorf("applyStash: %w", err)
+	}
+	indexCommit, err := getCommit(workCommit.ParentUIDs[1])
+	if err != nil {
+		return fmt.Errorf("applyStash: %w", err)
+	}
+	headCommit, err := getHeadCommit()
+	if err != nil {
+		return fmt.Errorf("applyStash: %w", err)
+	}
+	unlockIndex, err := lockFile(indexFile, "stash apply")
+	if err != nil {
+		return fmt.Errorf("applyStash: %w", err)
+	}
+	defer unlockIndex()
+	index, err := readIndex()
+	if err != nil {
+		return fmt.Errorf("applyStash: %w", err)
+	}
+
+	files := make(map[string]bool)
+	for _, c := range []commit{baseCommit, indexCommit, workCommit} {
+		for file := range c.FileToBlob {
+			files[file] = true
+		}
+	}
+	// check every file the stash changed before writing any
+	var workChanged, indexChanged []string
+	for _, file := range sortedKeys(files) {
+		stashedHash, inWork := workCommit.FileToBlob[file]
+		if stashedHash != baseCommit.FileToBlob[file] {
+			workChanged = append(workChanged, file)
+		}
+		if indexCommit.FileToBlob[file] != baseCommit.FileToBlob[file] {
+			indexChanged = append(indexChanged, file)
+		}
+		if stashedHash == baseCommit.FileToBlob[file] && indexCommit.FileToBlob[file] == baseCommit.FileToBlob[file] {
+			continue
+		}
+		wdHash, err := hashWorkingFile(file)
+		inWD := err == nil
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("applyStash: %w", err)
+		}
+		if inWD && wdHash == stashedHash && inWork {
+			continue
+		}
+		trackedHash, isTracked := headCommit.FileToBlob[file]
+		if _, isStaged := index[file]; isStaged || (isTracked && (!inWD || wdHash != trackedHash)) {
+			exit("Your local changes would be overwritten by the stash; commit or stash them first.")
+		}
+		if !isTracked && inWD {
+			exit("There is an untracked file in the way; delete it, or add and commit it first.")
+		}
+	}
+
+	restored := make(map[string]string)
+	for _, file := range workChanged {
+		if hash, ok := workCommit.FileToBlob[file]; ok {
+			restored[file] = hash
+		} else if err := restrictedDelete(file); err != nil {
+			return fmt.Errorf("applyStash: %w", err)
+		}
+	}
+	if err := materializeFiles(restored); err != nil {
+		return fmt.Errorf("applyStash: %w", err)
+	}
+	for _, file := range indexChanged {
+		hash, ok := indexCommit.FileToBlob[file]
+		trackedHash, isTracked := headCommit.FileToBlob[file]
+		switch {
+		case !ok && isTracked:
+			index[file] = indexMetadata{indexRemove, "", time.Now().Unix(), 0, false}
+		case ok && (!isTracked || hash != trackedHash):
+			_, contents, err := readBlob(hash)
+			if err != nil {
+				return fmt.Errorf("applyStash: %w", err)
+			}
+			index[file] = indexMetadata{indexAdd, hash, time.Now().Unix(), int64(len(contents)), false}
+		default:
+			delete(index, file)
+		}
+	}
+	for path, hash := range indexCommit.Submodules {
+		if headCommit.Submodules[path] != hash {
+			index[path] = indexMetadata{indexSubmodule, hash, time.Now().Unix(), 0, false}
+		}
+	}
+	if err := writeIndex(index); err != nil {
+		return fmt.Errorf("applyStash: %w", err)
+	}
+	log.Printf("Applied stash@{%v}: %v\n", n, entry.Message)
+	return nil
+}
+
+// dropStash removes stash entry n from the stash.
+func dropStash(n int) error {
+	unlock, err := lockRepo("stash drop")
+	if err != nil {
+		return fmt.Errorf("dropStash: %w", err)
+	}
+	defer unlock()
+	entries, err := readStash()
+	if err != nil {
+		return fmt.Errorf("dropStash: %w", err)
+	}
+	if n >= len(entries) {
+		exit("No stash entry with that index exists.")
+	}
+	dropped := entries[n]
+	if err := writeStash(slices.Delete(entries, n, n+1)); err != nil {
+		return fmt.Errorf("dropStash: %w", err)
+	}
+	log.Printf("Dropped stash@{%v} (%v).\n", n, dropped.Hash[:6])
+	return nil
+}
+
+// popStash applies stash entry n and then removes it from the stash.
+func popStash(n int) error {
+	unlock, err := lockRepo("stash pop")
+	if err != nil {
+		return fmt.Errorf("popStash: %w", err)
+	}
+	defer unlock()
+	if err := applyStash(n); err != nil {
+		return fmt.Errorf("popStash: %w", err)
+	}
+	if err := dropStash(n); err != nil {
+		return fmt.Errorf("popStash: %w", err)
+	}
+	return nil
+}
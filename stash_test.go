@@ -0,0 +1,177 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestStashChanges(t *testing.T) {
+	setupTestRepo(t)
+	for _, file := range []string{"wug.txt", "gone.txt"} {
+		if err := writeContents(file, []string{file}); err != nil {
+			t.Fatal(err)
+		}
+		if err := stageFile(file); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := newCommit("add files"); err != nil {
+		t.Fatal(err)
+	}
+	headCommit, err := getHeadCommit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := stashChanges(""); err != nil {
+		t.Fatal(err)
+	}
+	if entries, err := readStash(); err != nil || len(entries) != 0 {
+		t.Fatalf("Nothing should be stashed without changes: %v, %v", entries, err)
+	}
+
+	if err := writeContents("wug.txt", []string{"changed"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeContents("new.txt", []string{"new"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stageFile("new.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := unstageFile("gone.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeContents("untracked.txt", []string{"untracked"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stashChanges("my changes"); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := readStash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Message != "my changes" {
+		t.Fatalf("Incorrect stash entries: %v", entries)
+	}
+	workCommit, err := getCommit(entries[0].Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexCommit, err := getCommit(workCommit.ParentUIDs[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := indexCommit.FileToBlob["gone.txt"]; ok {
+		t.Error("Stashed index should not have the file staged for removal")
+	}
+	if _, ok := indexCommit.FileToBlob["new.txt"]; !ok {
+		t.Error("Stashed index should have the staged file")
+	}
+	if workCommit.FileToBlob["wug.txt"] == headCommit.FileToBlob["wug.txt"] {
+		t.Error("Stashed working directory should have the modified file")
+	}
+
+	// the working directory and index are back to the head commit
+	if contents, err := readContentsAsString("wug.txt"); err != nil || contents != "wug.txt" {
+		t.Errorf("Modified file was not restored: %q, %v", contents, err)
+	}
+	if _, err := repoFS.Stat("gone.txt"); err != nil {
+		t.Errorf("Removed file was not restored: %v", err)
+	}
+	if _, err := repoFS.Stat("new.txt"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Staged new file should be removed: %v", err)
+	}
+	if _, err := repoFS.Stat("untracked.txt"); err != nil {
+		t.Errorf("Untracked file should be left alone: %v", err)
+	}
+	if index, err := readIndex(); err != nil || len(index) != 0 {
+		t.Errorf("Index should be cleared: %v, %v", index, err)
+	}
+}
+
+func TestStashApplyPopDrop(t *testing.T) {
+	setupTestRepo(t)
+	if err := writeContents("wug.txt", []string{"wug"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stageFile("wug.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := newCommit("add wug"); err != nil {
+		t.Fatal(err)
+	}
+	stash := func(file string, contents string, stage bool) {
+		t.Helper()
+		if err := writeContents(file, []string{contents}); err != nil {
+			t.Fatal(err)
+		}
+		if stage {
+			if err := stageFile(file); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := stashChanges("stash " + contents); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stash("wug.txt", "first", false)
+	stash("new.txt", "second", true)
+
+	if err := applyStash(1); err != nil {
+		t.Fatal(err)
+	}
+	if contents, err := readContentsAsString("wug.txt"); err != nil || contents != "first" {
+		t.Fatalf("Stashed file was not applied: %q, %v", contents, err)
+	}
+	if index, err := readIndex(); err != nil || len(index) != 0 {
+		t.Fatalf("Unstaged changes should not be staged: %v, %v", index, err)
+	}
+	if entries, err := readStash(); err != nil || len(entries) != 2 {
+		t.Fatalf("Applying should keep the stash entry: %v, %v", entries, err)
+	}
+
+	if err := popStash(0); err != nil {
+		t.Fatal(err)
+	}
+	if contents, err := readContentsAsString("new.txt"); err != nil || contents != "second" {
+		t.Fatalf("Stashed file was not popped: %q, %v", contents, err)
+	}
+	index, err := readIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata, ok := index["new.txt"]; !ok || metadata.Op != indexAdd {
+		t.Fatalf("Staged file should be staged again: %v", index)
+	}
+	entries, err := readStash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Message != "stash first" {
+		t.Fatalf("Popping should remove the stash entry: %v", entries)
+	}
+
+	if err := dropStash(0); err != nil {
+		t.Fatal(err)
+	}
+	if entries, err := readStash(); err != nil || len(entries) != 0 {
+		t.Fatalf("Dropping should remove the stash entry: %v, %v", entries, err)
+	}
+}
+
+func TestParseStashRef(t *testing.T) {
+	for ref, expected := range map[string]int{"0": 0, "2": 2, "stash@{3}": 3} {
+		if n, err := parseStashRef(ref); err != nil || n != expected {
+			t.Errorf("parseStashRef(%q) = %v, %v", ref, n, err)
+		}
+	}
+	for _, ref := range []string{"-1", "x", "stash@{1", "stash@{}"} {
+		if _, err := parseStashRef(ref); err == nil {
+			t.Errorf("parseStashRef(%q) should fail", ref)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"path/filepath"
+	"time"
+)
+
+// The stat cache records the hash status last computed for each working file, along with the
+// size and modification time the file had, so status only reads the files whose stat info
+// changed since. Hashes depend on lfs.threshold and chunk.threshold, so the cache is dropped
+// if either changes. A file modified within statCacheRacyWindow of being hashed is not
+// recorded, since a later write in the same timestamp tick would leave its stat info as is.
+// Like the index, the file is binary, with entries sorted by file name.
+//
+//	stat cache: magic | version | lfs threshold | chunk threshold | count | (size, mod time,
+//	            hash length, name length, hash, name)... | checksum
+const (
+	statCacheMagic           string        = "GSTC"
+	statCacheVersion         uint32        = 1
+	statCacheHeaderSize      int           = 4 + 4 + 8 + 8 + 4
+	statCacheEntryHeaderSize int           = 8 + 8 + 1 + 2
+	statCacheRacyWindow      time.Duration = 2 * time.Second
+)
+
+var statCacheFile string = filepath.Join(gitletDir, "STAT_CACHE")
+
+// statCacheEntry is the hash a working file had with the given stat info.
+type statCacheEntry struct {
+	Size    int64
+	ModTime int64 // Modification time in nanoseconds since the Unix epoch.
+	Hash    string
+}
+
+// statCache looks up and records the hashes of working files.
+type statCache struct {
+	entries        map[string]statCacheEntry
+	lfsThreshold   int64
+	chunkThreshold int64
+	started        time.Time // When the cache was read, before any file was hashed.
+	changed        bool
+}
+
+// readStatCache returns the stat cache. An unreadable cache, or one written with other
+// thresholds, is replaced by an empty one.
+func readStatCache() (*statCache, error) {
+	lfsThreshold, err := getLFSThreshold()
+	if err != nil {
+		return nil, fmt.Errorf("readStatCache: %w", err)
+	}
+	chunkThreshold, err := getChunkThreshold()
+	if err != nil {
+		return nil, fmt.Errorf("readStatCache: %w", err)
+	}
+	sc := &statCache{
+		entries:        make(map[string]statCacheEntry),
+		lfsThreshold:   lfsThreshold,
+		chunkThreshold: chunkThreshold,
+		started:        time.Now(),
+	}
+	b, err := readFile(statCacheFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return sc, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("readStatCache: %w", err)
+	}
+	entries, err := decodeStatCache(b, lfsThreshold, chunkThreshold)
+	if err != nil {
+		sc.changed = true
+		return sc, nil
+	}
+	sc.entries = entries
+	return sc, nil
+}
+
+// hash returns the hash a file in the working directory would have as a file blob, reading
+// the file only if its stat info does not match the cache.
+func (sc *statCache) hash(file string) (string, error) {
+	fileInfo, err := repoFS.Stat(file)
+	if err != nil {
+		if _, ok := sc.entries[file]; ok {
+			delete(sc.entries, file)
+			sc.changed = true
+		}
+		return "", fmt.Errorf("hash: %w", err)
+	}
+	entry, ok := sc.entries[file]
+	if ok && entry.Size == fileInfo.Size() && entry.ModTime == fileInfo.ModTime().UnixNano() {
+		return entry.Hash, nil
+	}
+	hash, err := hashWorkingFile(file)
+	if err != nil {
+		return "", fmt.Errorf("hash: %w", err)
+	}
+	if fileInfo.ModTime().Before(sc.started.Add(-statCacheRacyWindow)) {
+		sc.entries[file] = statCacheEntry{fileInfo.Size(), fileInfo.ModTime().UnixNano(), hash}
+		sc.changed = true
+	} else if ok {
+		delete(sc.entries, file)
+		sc.changed = true
+	}
+	return hash, nil
+}
+
+// save writes the stat cache if it changed. The cache is left as is if another process is
+// writing it.
+func (sc *statCache) save() error {
+	if !sc.changed {
+		return nil
+	}
+	unlock, err := lockFile(statCacheFile, "update stat cache")
+	var lockedErr *repoLockedError
+	if errors.As(err, &lockedErr) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("save: %w", err)
+	}
+	defer unlock()
+	b, err := encodeStatCache(sc.entries, sc.lfsThreshold, sc.chunkThreshold)
+	if err != nil {
+		return fmt.Errorf("save: %w", err)
+	}
+	if err := writeFileAtomic(statCacheFile, b); err != nil {
+		return fmt.Errorf("save: %w", err)
+	}
+	sc.changed = false
+	return nil
+}
+
+// encodeStatCache returns the stat cache file contents of stat cache entries.
+func encodeStatCache(entries map[string]statCacheEntry, lfsThreshold int64, chunkThreshold int64) ([]byte, error) {
+	b := make([]byte, statCacheHeaderSize)
+	copy(b, statCacheMagic)
+	binary.BigEndian.PutUint32(b[4:], statCacheVersion)
+	binary.BigEndian.PutUint64(b[8:], uint64(lfsThreshold))
+	binary.BigEndian.PutUint64(b[16:], uint64(chunkThreshold))
+	binary.BigEndian.PutUint32(b[24:], uint32(len(entries)))
+	for _, file := range sortedKeys(entries) {
+		entry := entries[file]
+		if len(entry.Hash) > math.MaxUint8 || len(file) > math.MaxUint16 {
+			return nil, fmt.Errorf("encodeStatCache: entry for '%v' is too long", file)
+		}
+		b = binary.BigEndian.AppendUint64(b, uint64(entry.Size))
+		b = binary.BigEndian.AppendUint64(b, uint64(entry.ModTime))
+		b = append(b, byte(len(entry.Hash)))
+		b = binary.BigEndian.AppendUint16(b, uint16(len(file)))
+		b = append(b, entry.Hash...)
+		b = append(b, file...)
+	}
+	sum := sha1.Sum(b)
+	return append(b, sum[:]...), nil
+}
+
+// decodeStatCache returns the entries stored in stat cache file contents. Returns an error
+// if the contents are truncated, do not match their checksum, or were written with other
+// thresholds.
+func decodeStatCache(b []byte, lfsThreshold int64, chunkThreshold int64) (map[string]statCacheEntry, error) {
+	if len(b) < statCacheHeaderSize+hashSize || string(b[:4]) != statCacheMagic {
+		return nil, errors.New("decodeStatCache: stat cache is corrupt")
+	}
+	body, checksum := b[:len(b)-hashSize], b[len(b)-hashSize:]
+	if sum := sha1.Sum(body); !bytes.Equal(sum[:], checksum) {
+		return nil, errors.New("decodeStatCache: stat cache does not match its checksum")
+	}
+	if version := binary.BigEndian.Uint32(body[4:]); version != statCacheVersion {
+		return nil, fmt.Errorf("decodeStatCache: unsupported stat cache version %v", version)
+	}
+	if int64(binary.BigEndian.Uint64(body[8:])) != lfsThreshold || int64(binary.BigEndian.Uint64(body[16:])) != chunkThreshold {
+		return nil, errors.New("decodeStatCache: stat cache was written with other thresholds")
+	}
+	count := int(binary.BigEndian.Uint32(body[24:]))
+	entries := make(map[string]statCacheEntry, count)
+	r := body[statCacheHeaderSize:]
+	for range count {
+		if len(r) < statCacheEntryHeaderSize {
+			return nil, errors.New("decodeStatCache: stat cache is truncated")
+		}
+		entry := statCacheEntry{
+			Size:    int64(binary.BigEndian.Uint64(r)),
+			ModTime: int64(binary.BigEndian.Uint64(r[8:])),
+		}
+		hashLen, fileLen := int(r[16]), int(binary.BigEndian.Uint16(r[17:]))
+		r = r[statCacheEntryHeaderSize:]
+		if len(r) < hashLen+fileLen {
+			return nil, errors.New("decodeStatCache: stat cache is truncated")
+		}
+		entry.Hash = string(r[:hashLen])
+		entries[string(r[hashLen:hashLen+fileLen])] = entry
+		r = r[hashLen+fileLen:]
+	}
+	if len(r) != 0 {
+		return nil, errors.New("decodeStatCache: stat cache has trailing data")
+	}
+	return entries, nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStatCache(t *testing.T) {
+	setupTestRepo(t)
+	commitInRepo(t, ".", "wug.txt", "This is a wug")
+	past := time.Now().Add(-time.Hour)
+	if err := repoFS.Chtimes("wug.txt", past, past); err != nil {
+		t.Fatal(err)
+	}
+	if entries, err := getStatusEntries(); err != nil || len(entries) != 0 {
+		t.Fatalf("Committed file should be unmodified: %+v, %v", entries, err)
+	}
+	sc, err := readStatCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := sc.entries["wug.txt"]; !ok {
+		t.Fatalf("Status should record the hash of a file not modified recently: %+v", sc.entries)
+	}
+
+	// a file whose stat info is unchanged is not read again
+	if err := writeContents("wug.txt", []string{"This is a bug"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repoFS.Chtimes("wug.txt", past, past); err != nil {
+		t.Fatal(err)
+	}
+	if entries, err := getStatusEntries(); err != nil || len(entries) != 0 {
+		t.Fatalf("File with unchanged stat info should not be hashed again: %+v, %v", entries, err)
+	}
+	if err := repoFS.Chtimes("wug.txt", past.Add(time.Second), past.Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if entries, err := getStatusEntries(); err != nil || len(entries) != 1 || entries[0].String() != " M wug.txt" {
+		t.Fatalf("File with changed stat info should be hashed again: %+v, %v", entries, err)
+	}
+}
+
+func TestStatCacheSkipsRacyFiles(t *testing.T) {
+	setupTestRepo(t)
+	commitInRepo(t, ".", "wug.txt", "This is a wug")
+	if entries, err := getStatusEntries(); err != nil || len(entries) != 0 {
+		t.Fatalf("Committed file should be unmodified: %+v, %v", entries, err)
+	}
+	sc, err := readStatCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := sc.entries["wug.txt"]; ok {
+		t.Fatal("Status should not record the hash of a file modified just before it")
+	}
+}
+
+func TestStatCacheThresholds(t *testing.T) {
+	setupTestRepo(t)
+	entries := map[string]statCacheEntry{"wug.txt": {13, 1, initialCommitHash}}
+	b, err := encodeStatCache(entries, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFile(statCacheFile, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if sc, err := readStatCache(); err != nil || len(sc.entries) != 1 {
+		t.Fatalf("Stat cache read incorrectly: %+v, %v", sc, err)
+	}
+	if err := setConfig("chunk.threshold", "1m"); err != nil {
+		t.Fatal(err)
+	}
+	if sc, err := readStatCache(); err != nil || len(sc.entries) != 0 {
+		t.Fatalf("Stat cache written with other thresholds should be dropped: %+v, %v", sc, err)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"text/tabwriter"
+)
+
+// blobStat is a file blob in the history of the repository.
+type blobStat struct {
+	Hash   string
+	Size   int
+	Paths  []string // Files the blob was committed as, sorted.
+	Commit string   // Oldest commit that tracks the blob.
+}
+
+// getHistoryCommits returns the commits reachable from any branch or HEAD, with every commit
+// after its parents.
+func getHistoryCommits() ([]string, map[string]commit, error) {
+	heads, err := getBranchHeads()
+	if err != nil {
+		return nil, nil, fmt.Errorf("getHistoryCommits: %w", err)
+	}
+	headCommitHash, err := getHeadCommitHash()
+	if err != nil {
+		return nil, nil, fmt.Errorf("getHistoryCommits: %w", err)
+	}
+	roots := []string{headCommitHash}
+	for _, branch := range sortedKeys(heads) {
+		roots = append(roots, heads[branch])
+	}
+	commits := make(map[string]commit)
+	var hashes []string
+	// depth-first from each root, emitting a commit once its parents have been emitted
+	type frame struct {
+		hash     string
+		expanded bool
+	}
+	for _, root := range roots {
+		stack := []frame{{root, false}}
+		for len(stack) > 0 {
+			top := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if top.expanded {
+				hashes = append(hashes, top.hash)
+				continue
+			}
+			if _, ok := commits[top.hash]; ok {
+				continue
+			}
+			c, err := getCommit(top.hash)
+			if err != nil {
+				return nil, nil, fmt.Errorf("getHistoryCommits: %w", err)
+			}
+			commits[top.hash] = c
+			stack = append(stack, frame{top.hash, true})
+			for i := len(c.ParentUIDs) - 1; i >= 0; i-- {
+				if parentHash := c.ParentUIDs[i]; parentHash != "" {
+					stack = append(stack, frame{parentHash, false})
+				}
+			}
+		}
+	}
+	return hashes, commits, nil
+}
+
+// findLargestBlobs returns the n largest file blobs in history, largest first, with the
+// files they were committed as and the commit that introduced them.
+func findLargestBlobs(n int) ([]blobStat, error) {
+	hashes, commits, err := getHistoryCommits()
+	if err != nil {
+		return nil, fmt.Errorf("findLargestBlobs: %w", err)
+	}
+	stats := make(map[string]*blobStat)
+	for _, commitHash := range hashes {
+		for file, blobHash := range commits[commitHash].FileToBlob {
+			stat, ok := stats[blobHash]
+			if !ok {
+				_, contents, err := readBlob(blobHash)
+				if err != nil {
+					return nil, fmt.Errorf("findLargestBlobs: %w", err)
+				}
+				stat = &blobStat{Hash: blobHash, Size: len(contents), Commit: commitHash}
+				stats[blobHash] = stat
+			}
+			if !slices.Contains(stat.Paths, file) {
+				stat.Paths = append(stat.Paths, file)
+			}
+		}
+	}
+	largest := make([]blobStat, 0, len(stats))
+	for _, stat := range stats {
+		slices.Sort(stat.Paths)
+		largest = append(largest, *stat)
+	}
+	slices.SortFunc(largest, func(a, b blobStat) int {
+		if c := cmp.Compare(b.Size, a.Size); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Hash, b.Hash)
+	})
+	return largest[:min(n, len(largest))], nil
+}
+
+// formatSize formats a number of bytes with a binary unit.
+func formatSize(size int) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%v B", size)
+	}
+	value, exp := float64(size)/unit, 0
+	for value >= unit && exp < 3 {
+		value /= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", value, "KMGT"[exp])
+}
+
+// printLargestBlobs prints the n largest file blobs in history.
+func printLargestBlobs(n int) error {
+	largest, err := findLargestBlobs(n)
+	if err != nil {
+		return fmt.Errorf("printLargestBlobs: %w", err)
+	}
+	w := tabwriter.NewWriter(log.Writer(), 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "size\tblob\tcommit\tpaths")
+	for _, stat := range largest {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", formatSize(stat.Size), stat.Hash[:6], stat.Commit[:6], strings.Join(stat.Paths, ", "))
+	}
+	return w.Flush()
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestFindLargestBlobs(t *testing.T) {
+	setupTestRepo(t)
+	commitFiles := func(files map[string]string) string {
+		t.Helper()
+		for file, contents := range files {
+			if err := writeContents(file, []string{contents}); err != nil {
+				t.Fatal(err)
+			}
+			if err := stageFile(file); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := newCommit("add files"); err != nil {
+			t.Fatal(err)
+		}
+		hash, err := getHeadCommitHash()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hash
+	}
+	big := strings.Repeat("x", 100)
+	first := commitFiles(map[string]string{"big.txt": big, "small.txt": "s"})
+	commitFiles(map[string]string{"copy.txt": big, "medium.txt": strings.Repeat("m", 50)})
+
+	largest, err := findLargestBlobs(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(largest) != 2 {
+		t.Fatalf("Incorrect number of blobs: want 2, got %v", len(largest))
+	}
+	if largest[0].Size != 100 || largest[0].Commit != first || !slices.Equal(largest[0].Paths, []string{"big.txt", "copy.txt"}) {
+		t.Errorf("Incorrect largest blob: %+v", largest[0])
+	}
+	if largest[1].Size != 50 || !slices.Equal(largest[1].Paths, []string{"medium.txt"}) {
+		t.Errorf("Incorrect second largest blob: %+v", largest[1])
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	tests := map[int]string{0: "0 B", 1023: "1023 B", 1536: "1.5 KiB", 3 << 20: "3.0 MiB"}
+	for size, expected := range tests {
+		if got := formatSize(size); got != expected {
+			t.Errorf("formatSize(%v): want %q, got %q", size, expected, got)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"slices"
+	"strings"
+	"time"
+)
+
+// statusEntry is the porcelain status of a path: a two-letter code of its staged state
+// (X, index against the head commit) and unstaged state (Y, working directory against
+// the index or head commit), as in "M " for a staged modification or "??" for an
+// untracked file.
+type statusEntry struct {
+	X, Y byte
+	File string
+}
+
+// String formats an entry as a porcelain status line.
+func (e statusEntry) String() string {
+	return fmt.Sprintf("%c%c %v", e.X, e.Y, e.File)
+}
+
+// hashWorkingFile returns the hash a file in the working directory would have as a file blob,
+// which is an lfs pointer for files of at least lfs.threshold bytes.
+func hashWorkingFile(file string) (string, error) {
+	f, err := repoFS.Open(file)
+	if err != nil {
+		return "", fmt.Errorf("hashWorkingFile: %w", err)
+	}
+	defer f.Close()
+	fileInfo, err := f.Stat()
+	if err != nil {
+		return "", fmt.Errorf("hashWorkingFile: %w", err)
+	}
+	hash, _, err := storeFileContents(f, fileInfo.Size(), false)
+	if err != nil {
+		return "", fmt.Errorf("hashWorkingFile: %w", err)
+	}
+	return hash, nil
+}
+
+// hashLegacyWorkingFile returns the hash the blob of a file in the working directory had
+// before blob headers recorded the size of the contents.
+func hashLegacyWorkingFile(file string) (string, error) {
+	f, err := repoFS.Open(file)
+	if err != nil {
+		return "", fmt.Errorf("hashLegacyWorkingFile: %w", err)
+	}
+	defer f.Close()
+	h := sha1.New()
+	io.WriteString(h, "file")
+	h.Write([]byte{blobHeaderDelim})
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("hashLegacyWorkingFile: %w", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// workingFileMatches reports whether a file in the working directory, whose blob hash is
+// wdHash, has the contents of the blob with hash blobHash. A blob written before headers
+// recorded sizes has another hash for the same contents, so the legacy hash of the file is
+// compared as well.
+func workingFileMatches(file string, wdHash string, blobHash string) (bool, error) {
+	if wdHash == blobHash {
+		return true, nil
+	}
+	legacyHash, err := hashLegacyWorkingFile(file)
+	if err != nil {
+		return false, fmt.Errorf("workingFileMatches: %w", err)
+	}
+	return legacyHash == blobHash, nil
+}
+
+// getStatusEntries returns the porcelain status of every path that differs between the head
+// commit, the index, and the working directory within the current scope, sorted by path. Codes are:
+//
+//	A  staged new file          D  staged removal        M  staged modification
+//	 M modified, not staged      D deleted, not staged   UU unresolved merge conflict
+//	?? untracked
+func getStatusEntries() ([]statusEntry, error) {
+	headCommit, headFiles, err := getHeadScopeFiles()
+	if err != nil {
+		return nil, fmt.Errorf("getStatusEntries: %w", err)
+	}
+	index, err := readIndex()
+	if err != nil {
+		return nil, fmt.Errorf("getStatusEntries: %w", err)
+	}
+	wdFiles, err := getWorkingFiles()
+	if err != nil {
+		return nil, fmt.Errorf("getStatusEntries: %w", err)
+	}
+	sc, err := readStatCache()
+	if err != nil {
+		return nil, fmt.Errorf("getStatusEntries: %w", err)
+	}
+
+	files := slices.Concat(sortedKeys(headFiles), sortedKeys(index), wdFiles)
+	slices.Sort(files)
+	files = slices.Compact(files)
+
+	var entries []statusEntry
+	for _, file := range files {
+		if !inScope(file) {
+			continue
+		}
+		trackedHash, isTracked := headFiles[file]
+		metadata, isStaged := index[file]
+		entry := statusEntry{' ', ' ', file}
+		if isStaged && metadata.Op == indexSubmodule {
+			entry.X = 'A'
+			if _, ok := headCommit.Submodules[file]; ok {
+				entry.X = 'M'
+			}
+			entries = append(entries, entry)
+			continue
+		}
+
+		// staged state and the version the working file is compared against
+		expectedHash, expected := trackedHash, isTracked
+		if isStaged {
+			switch metadata.Op {
+			case indexRemove:
+				entry.X, expected = 'D', false
+			case indexConflict:
+				entry.X, entry.Y = 'U', 'U'
+			default:
+				entry.X = 'A'
+				if isTracked {
+					entry.X = 'M'
+				}
+			}
+			if metadata.Op != indexRemove {
+				expectedHash, expected = metadata.Hash, true
+			}
+		}
+
+		switch {
+		case entry.X == 'U':
+		case !isTracked && !isStaged:
+			entry.X, entry.Y = '?', '?'
+		case expected:
+			wdHash, err := sc.hash(file)
+			if errors.Is(err, fs.ErrNotExist) {
+				entry.Y = 'D'
+			} else if err != nil {
+				return nil, fmt.Errorf("getStatusEntries: %w", err)
+			} else if matches, err := workingFileMatches(file, wdHash, expectedHash); err != nil {
+				return nil, fmt.Errorf("getStatusEntries: %w", err)
+			} else if !matches {
+				entry.Y = 'M'
+			}
+		}
+		if entry.X != ' ' || entry.Y != ' ' {
+			entries = append(entries, entry)
+		}
+	}
+	if err := sc.save(); err != nil {
+		return nil, fmt.Errorf("getStatusEntries: %w", err)
+	}
+	return entries, nil
+}
+
+// formatPorcelainStatus formats status entries as porcelain status lines.
+func formatPorcelainStatus(entries []statusEntry) string {
+	var b strings.Builder
+	for _, entry := range entries {
+		b.WriteString(entry.String())
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// formatShortStatus formats status entries for people reading them: a "## <branch>" line
+// naming the current branch, or "## HEAD (no branch)" if HEAD is detached, followed by the
+// porcelain status lines. Unlike the porcelain format, this format may change.
+func formatShortStatus(branch string, entries []statusEntry) string {
+	if branch == "" {
+		branch = "HEAD (no branch)"
+	}
+	return fmt.Sprintf("## %v\n%v", branch, formatPorcelainStatus(entries))
+}
+
+// watchStatus prints the porcelain status, then prints it again each time it changes until
+// stop is closed. Each status is followed by an empty line so subscribers can tell updates apart.
+func watchStatus(interval time.Duration, w io.Writer, stop <-chan struct{}) error {
+	watcher, err := newFileWatcher(interval)
+	if err != nil {
+		return fmt.Errorf("watchStatus: %w", err)
+	}
+	var last string
+	printed := false
+	for {
+		entries, err := getStatusEntries()
+		if err != nil {
+			return fmt.Errorf("watchStatus: %w", err)
+		}
+		// changes to watched files that leave the status unchanged are not reported
+		if status := formatPorcelainStatus(entries); !printed || status != last {
+			if _, err := fmt.Fprintln(w, status); err != nil {
+				return fmt.Errorf("watchStatus: %w", err)
+			}
+			last, printed = status, true
+		}
+		changed, err := watcher.wait(stop)
+		if err != nil {
+			return fmt.Errorf("watchStatus: %w", err)
+		}
+		if !changed {
+			return nil
+		}
+	}
+}
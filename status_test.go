@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStatusEntries(t *testing.T) {
+	setupTestRepo(t)
+	for file, contents := range map[string]string{"a.txt": "A", "b.txt": "B", "c.txt": "C"} {
+		if err := writeContents(file, []string{contents}); err != nil {
+			t.Fatal(err)
+		}
+		if err := stageFile(file); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := newCommit("add files"); err != nil {
+		t.Fatal(err)
+	}
+
+	// staged modification, staged removal, unstaged deletion, staged new file
+	// with unstaged modification, and untracked file
+	if err := writeContents("a.txt", []string{"!A"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stageFile("a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := unstageFile("b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := restrictedDelete("c.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeContents("d.txt", []string{"D"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stageFile("d.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeContents("d.txt", []string{"!D"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeContents("e.txt", []string{"E"}); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := getStatusEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "M  a.txt\nD  b.txt\n D c.txt\nAM d.txt\n?? e.txt\n"
+	if actual := formatPorcelainStatus(entries); actual != expected {
+		t.Fatalf("Incorrect porcelain status: want %q, got %q", expected, actual)
+	}
+}
+
+func TestShortStatus(t *testing.T) {
+	entries := []statusEntry{{'M', ' ', "a.txt"}, {'?', '?', "b.txt"}}
+	tests := []struct {
+		branch   string
+		expected string
+	}{
+		{"main", "## main\nM  a.txt\n?? b.txt\n"},
+		{"", "## HEAD (no branch)\nM  a.txt\n?? b.txt\n"},
+	}
+	for _, test := range tests {
+		if actual := formatShortStatus(test.branch, entries); actual != test.expected {
+			t.Errorf("formatShortStatus(%q) = %q, want %q", test.branch, actual, test.expected)
+		}
+	}
+}
+
+// syncBuffer is a buffer safe for concurrent writes and reads.
+type syncBuffer struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.String()
+}
+
+func TestWatchStatus(t *testing.T) {
+	setupTestRepo(t)
+	var out syncBuffer
+	stop := make(chan struct{})
+	done := make(chan error)
+	go func() { done <- watchStatus(10*time.Millisecond, &out, stop) }()
+
+	waitFor := func(expected string) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+			if strings.Contains(out.String(), expected) {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("Status update %q not printed, got %q", expected, out.String())
+	}
+	waitFor("\n")
+	if err := writeContents("wug.txt", []string{"This is a wug"}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("?? wug.txt\n\n")
+	close(stop)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	// an empty status for the clean repository, then the untracked file
+	if expected := "\n?? wug.txt\n\n"; out.String() != expected {
+		t.Fatalf("Incorrect status updates: want %q, got %q", expected, out.String())
+	}
+}
+
+func TestStatusNestedFiles(t *testing.T) {
+	setupTestRepo(t)
+	mkTestDir(t, "sub")
+	commitInRepo(t, ".", "sub/tracked.txt", "This is a wug")
+	if err := writeContents("sub/tracked.txt", []string{"This is not a wug"}); err != nil {
+		t.Fatal(err)
+	}
+	mkTestDir(t, filepath.Join("sub", "deeper"))
+	if err := writeContents("sub/deeper/untracked.txt", []string{"This is a wug"}); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := getStatusEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actual []string
+	for _, entry := range entries {
+		actual = append(actual, entry.String())
+	}
+	if expected := []string{"?? sub/deeper/untracked.txt", " M sub/tracked.txt"}; !slices.Equal(actual, expected) {
+		t.Fatalf("Incorrect status of nested files: want %q, got %q", expected, actual)
+	}
+}
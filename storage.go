@@ -0,0 +1,352 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// objectInfo describes a stored object.
+type objectInfo struct {
+	Size    int64     // Size of the payload in bytes.
+	ModTime time.Time // When the object was written.
+}
+
+// getStorage returns the loose objects and refs of the current repository.
+func getStorage() fileStorage {
+	return fileStorage{gitletDir}
+}
+
+// openLooseObject returns a reader of the payload of a loose object.
+// Returns an error wrapping fs.ErrNotExist if the object is packed or missing.
+func openLooseObject(hash string) (io.ReadCloser, error) {
+	s := getStorage()
+	r, err := s.openObject(hash)
+	if err != nil {
+		return nil, fmt.Errorf("openLooseObject: %w", err)
+	}
+	return r, nil
+}
+
+// writeLooseObject stores the payload of an object, given as strings and byte slices, as a
+// loose object unless it already exists.
+func writeLooseObject[T any](hash string, payload []T) error {
+	s := getStorage()
+	w, err := s.createObject()
+	if err != nil {
+		return fmt.Errorf("writeLooseObject: %w", err)
+	}
+	defer w.discard()
+	for _, part := range payload {
+		switch t := any(part).(type) {
+		case string:
+			_, err = io.WriteString(w, t)
+		case []byte:
+			_, err = w.Write(t)
+		default:
+			err = fmt.Errorf("%v is not a string or byte slice", t)
+		}
+		if err != nil {
+			return fmt.Errorf("writeLooseObject: %w", err)
+		}
+	}
+	if err := w.commit(hash); err != nil {
+		return fmt.Errorf("writeLooseObject: %w", err)
+	}
+	return nil
+}
+
+// refName returns the slash-separated name of a ref in the .gitlet directory, given its path.
+func refName(file string) string {
+	return strings.TrimPrefix(filepath.ToSlash(file), filepath.ToSlash(gitletDir)+"/")
+}
+
+// readRef returns the target of a ref given its path, such as headFile or a file in
+// branchesDir. Returns an error wrapping fs.ErrNotExist if there is no such ref.
+func readRef(file string) (string, error) {
+	s := getStorage()
+	target, err := s.readRef(refName(file))
+	if err != nil {
+		return "", fmt.Errorf("readRef: %w", err)
+	}
+	return target, nil
+}
+
+// hasRef reports whether a ref exists given its path.
+func hasRef(file string) (bool, error) {
+	if _, err := readRef(file); errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("hasRef: %w", err)
+	}
+	return true, nil
+}
+
+// removeRef deletes a ref given its path. Does nothing if there is no such ref.
+func removeRef(file string) error {
+	s := getStorage()
+	if err := s.removeRef(refName(file)); err != nil {
+		return fmt.Errorf("removeRef: %w", err)
+	}
+	return nil
+}
+
+// listRefs returns the sorted paths of the refs under a directory, such as remotesDir,
+// including those in its subdirectories.
+func listRefs(dir string) ([]string, error) {
+	s := getStorage()
+	names, err := s.listRefs(refName(dir))
+	if err != nil {
+		return nil, fmt.Errorf("listRefs: %w", err)
+	}
+	files := make([]string, len(names))
+	for i, name := range names {
+		files[i] = filepath.Join(gitletDir, filepath.FromSlash(name))
+	}
+	return files, nil
+}
+
+// copyStorage copies the loose objects dst does not have, and the branches, tags, and HEAD,
+// from src.
+func copyStorage(dst fileStorage, src fileStorage) error {
+	if err := copyObjects(dst, src); err != nil {
+		return fmt.Errorf("copyStorage: %w", err)
+	}
+	var names []string
+	for _, dir := range []string{"refs/heads", "refs/tags"} {
+		refs, err := src.listRefs(dir)
+		if err != nil {
+			return fmt.Errorf("copyStorage: %w", err)
+		}
+		names = append(names, refs...)
+	}
+	for _, name := range append(names, "HEAD") {
+		target, err := src.readRef(name)
+		if err != nil {
+			return fmt.Errorf("copyStorage: %w", err)
+		}
+		if err := dst.writeRef(name, target); err != nil {
+			return fmt.Errorf("copyStorage: %w", err)
+		}
+	}
+	return nil
+}
+
+// copyObjects copies the loose objects dst does not have from src.
+func copyObjects(dst fileStorage, src fileStorage) error {
+	hashes, err := src.findObjects("", math.MaxInt)
+	if err != nil {
+		return fmt.Errorf("copyObjects: %w", err)
+	}
+	for _, hash := range hashes {
+		if _, err := dst.statObject(hash); err == nil {
+			continue
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("copyObjects: %w", err)
+		}
+		if err := copyObject(dst, src, hash); err != nil {
+			return fmt.Errorf("copyObjects: %w", err)
+		}
+	}
+	return nil
+}
+
+// copyObject copies a loose object from src to dst.
+func copyObject(dst fileStorage, src fileStorage, hash string) error {
+	r, err := src.openObject(hash)
+	if err != nil {
+		return fmt.Errorf("copyObject: %w", err)
+	}
+	defer r.Close()
+	w, err := dst.createObject()
+	if err != nil {
+		return fmt.Errorf("copyObject: %w", err)
+	}
+	defer w.discard()
+	if _, err := io.Copy(w, r); err != nil {
+		return fmt.Errorf("copyObject: %w", err)
+	}
+	if err := w.commit(hash); err != nil {
+		return fmt.Errorf("copyObject: %w", err)
+	}
+	return r.Close()
+}
+
+// fileStorage keeps the loose objects and refs of a repository: each loose object in a file of
+// the objects directory, and each ref in a file of the .gitlet directory. Objects are stored as
+// their payload, the bytes their hash is computed over. Refs are named by their slash-separated
+// path in the .gitlet directory, such as "HEAD" or "refs/heads/main", and hold a commit hash or
+// the path of another ref.
+type fileStorage struct {
+	dir string // The .gitlet directory of the repository.
+}
+
+func (s fileStorage) objectsDir() string {
+	return filepath.Join(s.dir, filepath.Base(objectsDir))
+}
+
+// openObject returns a reader of the payload of an object. Returns an error wrapping
+// fs.ErrNotExist if there is no such loose object. The reader must be closed.
+func (s fileStorage) openObject(hash string) (io.ReadCloser, error) {
+	f, err := repoFS.Open(looseObjectPath(s.objectsDir(), hash))
+	if err != nil {
+		return nil, fmt.Errorf("openObject: %w", err)
+	}
+	return f, nil
+}
+
+// statObject returns the size and write time of an object, or an error wrapping
+// fs.ErrNotExist if there is no such loose object.
+func (s fileStorage) statObject(hash string) (objectInfo, error) {
+	fileInfo, err := repoFS.Stat(looseObjectPath(s.objectsDir(), hash))
+	if err != nil {
+		return objectInfo{}, fmt.Errorf("statObject: %w", err)
+	}
+	return objectInfo{fileInfo.Size(), fileInfo.ModTime()}, nil
+}
+
+// createObject returns a writer of the payload of a new object, whose hash is only known
+// once the payload is written.
+func (s fileStorage) createObject() (*fileObjectWriter, error) {
+	// the temporary file is hidden so it is never mistaken for an object
+	f, err := repoFS.CreateTemp(s.objectsDir(), ".object.tmp*")
+	if err != nil {
+		return nil, fmt.Errorf("createObject: %w", err)
+	}
+	return &fileObjectWriter{f, s.objectsDir(), false}, nil
+}
+
+// removeObject deletes a loose object. Does nothing if there is no such object.
+func (s fileStorage) removeObject(hash string) error {
+	file := looseObjectPath(s.objectsDir(), hash)
+	if err := repoFS.Remove(file); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("removeObject: %w", err)
+	}
+	return nil
+}
+
+// findObjects returns up to limit hashes of loose objects starting with prefix, sorted.
+func (s fileStorage) findObjects(prefix string, limit int) ([]string, error) {
+	hashes, err := findLooseObjects(s.objectsDir(), prefix, limit)
+	if err != nil {
+		return nil, fmt.Errorf("findObjects: %w", err)
+	}
+	return hashes, nil
+}
+
+// readRef returns the target of a ref, or an error wrapping fs.ErrNotExist if there is no
+// such ref.
+func (s fileStorage) readRef(name string) (string, error) {
+	target, err := readContentsAsString(filepath.Join(s.dir, filepath.FromSlash(name)))
+	if err != nil {
+		return "", fmt.Errorf("readRef: %w", err)
+	}
+	return target, nil
+}
+
+// writeRef creates or replaces a ref. Callers hold the lock of the ref.
+func (s fileStorage) writeRef(name string, target string) error {
+	if err := writeFileAtomic(filepath.Join(s.dir, filepath.FromSlash(name)), []byte(target)); err != nil {
+		return fmt.Errorf("writeRef: %w", err)
+	}
+	return nil
+}
+
+// removeRef deletes a ref. Does nothing if there is no such ref.
+func (s fileStorage) removeRef(name string) error {
+	if err := repoFS.Remove(filepath.Join(s.dir, filepath.FromSlash(name))); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("removeRef: %w", err)
+	}
+	return nil
+}
+
+// listRefs returns the sorted names of the refs under the directory dir, such as
+// "refs/heads", including those in its subdirectories.
+func (s fileStorage) listRefs(dir string) ([]string, error) {
+	root := filepath.Join(s.dir, filepath.FromSlash(dir))
+	var names []string
+	err := walkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && path == root {
+			return fs.SkipAll
+		} else if err != nil {
+			return err
+		}
+		// lock files and the hidden temporary files of refs being updated are not refs
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".") || strings.HasSuffix(d.Name(), lockFileSuffix) {
+			return nil
+		}
+		rel, err := filepath.Rel(s.dir, path)
+		if err != nil {
+			return err
+		}
+		names = append(names, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listRefs: %w", err)
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+// fileObjectWriter writes a new loose object to a temporary file that is renamed to the object
+// file once its hash is known.
+type fileObjectWriter struct {
+	file
+	objectsDir string
+	committed  bool
+}
+
+// commit stores the written payload as the object with the given hash, unless it already
+// exists.
+func (w *fileObjectWriter) commit(hash string) error {
+	file := looseObjectPath(w.objectsDir, hash)
+	if _, err := repoFS.Stat(file); err == nil {
+		return nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("commit: %w", err)
+	}
+	if err := w.Sync(); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+	if err := repoFS.Chmod(w.Name(), 0644); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+	if err := repoFS.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+	err := repoFS.Rename(w.Name(), file)
+	if errors.Is(err, fs.ErrNotExist) {
+		// a repack may remove the directory once it has no objects left, before the rename
+		if err := repoFS.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			return fmt.Errorf("commit: %w", err)
+		}
+		err = repoFS.Rename(w.Name(), file)
+	}
+	if err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+	w.committed = true
+	// the rename is only durable once the directory entry pointing at the new file is
+	if err := syncDir(filepath.Dir(file)); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+	return nil
+}
+
+// discard drops the written payload unless it was committed.
+func (w *fileObjectWriter) discard() {
+	w.Close()
+	if !w.committed {
+		repoFS.Remove(w.Name())
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestFileStorage(t *testing.T) {
+	setupTestRepo(t)
+	s := getStorage()
+
+	payload := blobPayload("file", []byte("This is a wug\n"))
+	hash, err := getHash(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeLooseObject(hash, payload); err != nil {
+		t.Fatal(err)
+	}
+	var want bytes.Buffer
+	for _, part := range payload {
+		switch p := part.(type) {
+		case string:
+			want.WriteString(p)
+		case []byte:
+			want.Write(p)
+		}
+	}
+	if info, err := s.statObject(hash); err != nil || info.Size != int64(want.Len()) {
+		t.Fatalf("Incorrect object size: want %v, got %+v, %v", want.Len(), info, err)
+	}
+	r, err := s.openObject(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(r)
+	r.Close()
+	if err != nil || !bytes.Equal(got, want.Bytes()) {
+		t.Fatalf("Incorrect object payload: want %q, got %q, %v", want.Bytes(), got, err)
+	}
+	if hashes, err := s.findObjects(hash[:4], 10); err != nil || !slices.Contains(hashes, hash) {
+		t.Fatalf("Object not found by its prefix: %v, %v", hashes, err)
+	}
+	if err := s.removeObject(hash); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.statObject(hash); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Removed object should not exist: %v", err)
+	}
+	if _, err := s.openObject(hash); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Removed object should not open: %v", err)
+	}
+
+	headCommitHash, err := getHeadCommitHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.writeRef("refs/tags/v1", headCommitHash); err != nil {
+		t.Fatal(err)
+	}
+	if target, err := s.readRef("refs/tags/v1"); err != nil || target != headCommitHash {
+		t.Fatalf("Incorrect ref target: want %v, got %v, %v", headCommitHash, target, err)
+	}
+	for dir, want := range map[string][]string{
+		"refs/heads": {"refs/heads/main"},
+		"refs/tags":  {"refs/tags/v1"},
+		"refs":       {"refs/heads/main", "refs/tags/v1"},
+	} {
+		if names, err := s.listRefs(dir); err != nil || !slices.Equal(names, want) {
+			t.Fatalf("Incorrect refs under %v: want %v, got %v, %v", dir, want, names, err)
+		}
+	}
+	if err := s.removeRef("refs/tags/v1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.readRef("refs/tags/v1"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Removed ref should not exist: %v", err)
+	}
+}
+
+func TestStorageHistory(t *testing.T) {
+	// the history is kept both in a memory filesystem and on disk
+	for _, disk := range []bool{false, true} {
+		subtest := "memory"
+		if disk {
+			subtest = "disk"
+		}
+		t.Run(subtest, func(t *testing.T) {
+			if disk {
+				setupDiskTestFS(t)
+			} else {
+				setupTestFS(t)
+			}
+			mkTestDir(t, "repo")
+			if err := inRepository("repo", func() error {
+				return newRepository()
+			}); err != nil {
+				t.Fatal(err)
+			}
+			commitInRepo(t, "repo", "wug.txt", "This is a wug\n")
+			if err := inRepository("repo", func() error {
+				if err := addBranch("other"); err != nil {
+					return err
+				}
+				return createTag("v1", "HEAD", "", false)
+			}); err != nil {
+				t.Fatal(err)
+			}
+			commitInRepo(t, "repo", "notwug.txt", "This is not a wug\n")
+			if err := inRepository("repo", func() error {
+				return checkoutBranch("other")
+			}); err != nil {
+				t.Fatal(err)
+			}
+			commitInRepo(t, "repo", "other.txt", "This is another wug\n")
+			var mergeHash string
+			if err := inRepository("repo", func() error {
+				if err := checkoutBranch("main"); err != nil {
+					return err
+				}
+				if err := mergeBranch("other", mergeOptions{}); err != nil {
+					return err
+				}
+				if _, _, err := collectGarbage(0, time.Now().Add(time.Hour)); err != nil {
+					return err
+				}
+				var err error
+				mergeHash, err = getHeadCommitHash()
+				return err
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := cloneRemote("repo", "clone"); err != nil {
+				t.Fatal(err)
+			}
+			enterTestDir(t, "clone")
+			headCommit, err := getHeadCommit()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(headCommit.FileToBlob) != 3 || headCommit.ParentUIDs[1] == "" {
+				t.Fatalf("Clone should check out the merge commit: %+v", headCommit)
+			}
+			if report, err := verifyHistory(mergeHash); err != nil || len(report.Problems) != 0 || report.Commits != 5 {
+				t.Fatalf("Cloned history should verify: %+v, %v", report, err)
+			}
+			if tags, err := getTags(); err != nil || len(tags) != 1 {
+				t.Fatalf("Clone should have the tag: %v, %v", tags, err)
+			}
+			for _, file := range []string{"wug.txt", "notwug.txt", "other.txt"} {
+				if _, err := repoFS.Stat(file); err != nil {
+					t.Fatalf("Clone should check out %v: %v", file, err)
+				}
+			}
+		})
+	}
+}
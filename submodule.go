@@ -0,0 +1,410 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"path/filepath"
+	"time"
+)
+
+// Tracked file in the working directory recording the repository each submodule is cloned from.
+const submodulesFile string = ".gitletmodules"
+
+// Map between submodule paths and the .gitlet directories of the repositories they are cloned from.
+type submoduleMap map[string]string
+
+// submoduleStatus is the state of a submodule in the working directory: '-' if it is not
+// cloned, '+' if it has a different commit checked out than the one pinned, or ' ' otherwise.
+type submoduleStatus struct {
+	State byte
+	Hash  string // Commit checked out in the submodule, or the pinned commit if not cloned.
+	Path  string
+}
+
+func (s submoduleStatus) String() string {
+	return fmt.Sprintf("%c%v %v", s.State, s.Hash, s.Path)
+}
+
+// readSubmodules reads the submodules recorded in the working directory.
+// Returns an empty map if there are none.
+func readSubmodules() (submoduleMap, error) {
+	b, err := readFile(submodulesFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return make(submoduleMap), nil
+	} else if err != nil {
+		return nil, fmt.Errorf("readSubmodules: %w", err)
+	}
+	submodules, err := deserialize[submoduleMap](b)
+	if err != nil {
+		return nil, fmt.Errorf("readSubmodules: %w", err)
+	}
+	return submodules, nil
+}
+
+// writeSubmodules records submodules in the working directory.
+func writeSubmodules(submodules submoduleMap) error {
+	b, err := json.MarshalIndent(submodules, "", "  ")
+	if err != nil {
+		return fmt.Errorf("writeSubmodules: %w", err)
+	}
+	if err := writeFileAtomic(submodulesFile, append(b, '\n')); err != nil {
+		return fmt.Errorf("writeSubmodules: %w", err)
+	}
+	return nil
+}
+
+// inRepository runs fn with the working directory changed to another repository, such as a
+// submodule. Repository state held by this process is set aside while fn runs.
+func inRepository(dir string, fn func() error) error {
+	wdFS := repoFS
+	dirFS, err := repoFS.Sub(dir)
+	if err != nil {
+		return fmt.Errorf("inRepository: %w", err)
+	}
+	repoFS = dirFS
+	packsMu.Lock()
+	midx := loadedMultiPackIndex
+	loadedMultiPackIndex = nil
+	packsMu.Unlock()
+	repoLockMu.Lock()
+	lockDepth, lock := repoLockDepth, heldRepoLock
+	repoLockDepth, heldRepoLock = 0, ""
+	repoLockMu.Unlock()
+
+	fnErr := upgradeRepository()
+	if fnErr == nil {
+		fnErr = fn()
+	}
+
+	packsMu.Lock()
+	loadedMultiPackIndex = midx
+	packsMu.Unlock()
+	repoLockMu.Lock()
+	repoLockDepth, heldRepoLock = lockDepth, lock
+	repoLockMu.Unlock()
+	repoFS = wdFS
+	return fnErr
+}
+
+// copyMissingFiles copies the files under the src directory that are missing from the dst directory.
+func copyMissingFiles(src string, dst string) error {
+	return walkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if d.IsDir() {
+			return repoFS.MkdirAll(target, 0755)
+		}
+		if _, err := repoFS.Stat(target); err == nil {
+			return nil
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		b, err := readFile(path)
+		if err != nil {
+			return err
+		}
+		return writeFileAtomic(target, b)
+	})
+}
+
+// cloneRepository copies the objects, branches, tags, and HEAD of the repository at
+// remoteGitletDir into a new repository in dir, with nothing checked out.
+func cloneRepository(remoteGitletDir string, dir string) error {
+	for _, refDir := range []string{objectsDir, branchesDir, tagsDir, remotesDir} {
+		if err := repoFS.MkdirAll(filepath.Join(dir, refDir), 0755); err != nil {
+			return fmt.Errorf("cloneRepository: %w", err)
+		}
+	}
+	if err := copyRepositoryStorage(remoteGitletDir, dir, copyStorage); err != nil {
+		return fmt.Errorf("cloneRepository: %w", err)
+	}
+	if err := inRepository(dir, func() error {
+		return errors.Join(newIndex(), newRemoteIndex())
+	}); err != nil {
+		return fmt.Errorf("cloneRepository: %w", err)
+	}
+	return nil
+}
+
+// copyRepositoryStorage copies the packs of the repository at remoteGitletDir that the
+// repository in dir does not have, and then what copy copies between their loose objects and refs.
+func copyRepositoryStorage(remoteGitletDir string, dir string, copy func(dst, src fileStorage) error) error {
+	remotePackDir := filepath.Join(remoteGitletDir, filepath.Base(objectsDir), filepath.Base(packDir))
+	if _, err := repoFS.Stat(remotePackDir); err == nil {
+		if err := copyMissingFiles(remotePackDir, filepath.Join(dir, packDir)); err != nil {
+			return fmt.Errorf("copyRepositoryStorage: %w", err)
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("copyRepositoryStorage: %w", err)
+	}
+	src := fileStorage{remoteGitletDir}
+	if err := inRepository(dir, func() error {
+		return copy(getStorage(), src)
+	}); err != nil {
+		return fmt.Errorf("copyRepositoryStorage: %w", err)
+	}
+	return nil
+}
+
+// checkoutSubmodule checks out the files of a commit in the submodule at path.
+// If detach is set, the submodule's HEAD is pointed directly at the commit.
+func checkoutSubmodule(path string, commitHash string, detach bool) error {
+	if err := inRepository(path, func() error {
+		unlock, err := lockRepo("submodule update")
+		if err != nil {
+			return err
+		}
+		defer unlock()
+		targetCommit, err := getCommit(commitHash)
+		if err != nil {
+			return err
+		}
+		if err := checkoutTree(targetCommit); err != nil {
+			return err
+		}
+		if detach {
+			return writeRef(headFile, commitHash, "submodule update")
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("checkoutSubmodule: %w", err)
+	}
+	return nil
+}
+
+// getSubmoduleHead returns the commit checked out in the submodule at path.
+func getSubmoduleHead(path string) (string, error) {
+	var headCommitHash string
+	if err := inRepository(path, func() (err error) {
+		headCommitHash, err = getHeadCommitHash()
+		return err
+	}); err != nil {
+		return "", fmt.Errorf("getSubmoduleHead: %w", err)
+	}
+	return headCommitHash, nil
+}
+
+// isCloned reports whether the submodule at path has been cloned.
+func isCloned(path string) (bool, error) {
+	if _, err := repoFS.Stat(filepath.Join(path, gitletDir)); err == nil {
+		return true, nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return false, fmt.Errorf("isCloned: %w", err)
+	}
+	return false, nil
+}
+
+// addSubmodule clones the repository at remoteGitletDir into path, records it in the
+// submodules file, and stages both the submodules file and the submodule pinned to the
+// commit it has checked out.
+func addSubmodule(remoteGitletDir string, path string) error {
+	path, err := normalizePath(path)
+	if err != nil {
+		return fmt.Errorf("addSubmodule: %w", err)
+	}
+	if ok, err := isGitletDir(remoteGitletDir); err != nil || !ok {
+		exit("Remote directory not found.")
+	}
+	submodules, err := readSubmodules()
+	if err != nil {
+		return fmt.Errorf("addSubmodule: %w", err)
+	}
+	if _, ok := submodules[path]; ok {
+		exit("A submodule already exists at that path.")
+	}
+	if _, err := repoFS.Stat(path); err == nil {
+		exit("A file already exists at that path.")
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("addSubmodule: %w", err)
+	}
+
+	if err := cloneRepository(remoteGitletDir, path); err != nil {
+		return fmt.Errorf("addSubmodule: %w", err)
+	}
+	headCommitHash, err := getSubmoduleHead(path)
+	if err != nil {
+		return fmt.Errorf("addSubmodule: %w", err)
+	}
+	if err := checkoutSubmodule(path, headCommitHash, false); err != nil {
+		return fmt.Errorf("addSubmodule: %w", err)
+	}
+
+	submodules[path] = filepath.ToSlash(remoteGitletDir)
+	if err := writeSubmodules(submodules); err != nil {
+		return fmt.Errorf("addSubmodule: %w", err)
+	}
+	if err := stageFile(submodulesFile); err != nil {
+		return fmt.Errorf("addSubmodule: %w", err)
+	}
+	if err := stageSubmodule(path); err != nil {
+		return fmt.Errorf("addSubmodule: %w", err)
+	}
+	return nil
+}
+
+// stageSubmodule stages the submodule at path to be pinned to the commit it has checked out.
+func stageSubmodule(path string) error {
+	headCommit, err := getHeadCommit()
+	if err != nil {
+		return fmt.Errorf("stageSubmodule: %w", err)
+	}
+	unlockIndex, err := lockFile(indexFile, "add")
+	if err != nil {
+		return fmt.Errorf("stageSubmodule: %w", err)
+	}
+	defer unlockIndex()
+	index, err := readIndex()
+	if err != nil {
+		return fmt.Errorf("stageSubmodule: %w", err)
+	}
+	submoduleHead, err := getSubmoduleHead(path)
+	if err != nil {
+		return fmt.Errorf("stageSubmodule: %w", err)
+	}
+	stagedMetadata, isStaged := index[path]
+	if isStaged && stagedMetadata.Hash == submoduleHead {
+		log.Printf("File '%v' is already staged.\n", path)
+		return nil
+	}
+	if pinnedHash, ok := headCommit.Submodules[path]; ok && pinnedHash == submoduleHead {
+		if isStaged {
+			delete(index, path)
+			if err := writeIndex(index); err != nil {
+				return fmt.Errorf("stageSubmodule: %w", err)
+			}
+		}
+		log.Printf("No changes detected. Skipping staging...\n")
+		return nil
+	}
+	index[path] = indexMetadata{indexSubmodule, submoduleHead, time.Now().Unix(), 0, false}
+	if err := writeIndex(index); err != nil {
+		return fmt.Errorf("stageSubmodule: %w", err)
+	}
+	return nil
+}
+
+// pinSubmodule records in a commit that the submodule at path is pinned to a commit.
+func pinSubmodule(c *commit, path string, commitHash string) {
+	if c.Submodules == nil {
+		c.Submodules = make(map[string]string)
+	}
+	c.Submodules[path] = commitHash
+}
+
+// getPinnedSubmodules returns the commits submodules are pinned to by the head commit,
+// overridden by the staged submodules.
+func getPinnedSubmodules() (map[string]string, error) {
+	headCommit, err := getHeadCommit()
+	if err != nil {
+		return nil, fmt.Errorf("getPinnedSubmodules: %w", err)
+	}
+	index, err := readIndex()
+	if err != nil {
+		return nil, fmt.Errorf("getPinnedSubmodules: %w", err)
+	}
+	pinned := make(map[string]string, len(headCommit.Submodules))
+	for path, hash := range headCommit.Submodules {
+		pinned[path] = hash
+	}
+	for path, metadata := range index {
+		if metadata.Op == indexSubmodule {
+			pinned[path] = metadata.Hash
+		}
+	}
+	return pinned, nil
+}
+
+// updateSubmodules clones each pinned submodule that is not yet cloned, fetches the objects
+// of those that are, and checks out the pinned commit in any that have another commit
+// checked out.
+func updateSubmodules(pinned map[string]string) error {
+	if len(pinned) == 0 {
+		return nil
+	}
+	submodules, err := readSubmodules()
+	if err != nil {
+		return fmt.Errorf("updateSubmodules: %w", err)
+	}
+	for _, path := range sortedKeys(pinned) {
+		remoteGitletDir, ok := submodules[path]
+		if !ok {
+			return fmt.Errorf("updateSubmodules: no repository recorded for submodule '%v'", path)
+		}
+		remoteGitletDir, err = repoFS.Abs(filepath.FromSlash(remoteGitletDir))
+		if err != nil {
+			return fmt.Errorf("updateSubmodules: %w", err)
+		}
+		cloned, err := isCloned(path)
+		if err != nil {
+			return fmt.Errorf("updateSubmodules: %w", err)
+		}
+		if !cloned {
+			if err := cloneRepository(remoteGitletDir, path); err != nil {
+				return fmt.Errorf("updateSubmodules: %w", err)
+			}
+		} else if err := copyRepositoryStorage(remoteGitletDir, path, copyObjects); err != nil {
+			return fmt.Errorf("updateSubmodules: %w", err)
+		}
+		submoduleHead, err := getSubmoduleHead(path)
+		if err != nil {
+			return fmt.Errorf("updateSubmodules: %w", err)
+		}
+		if cloned && submoduleHead == pinned[path] {
+			continue
+		}
+		if err := checkoutSubmodule(path, pinned[path], true); err != nil {
+			return fmt.Errorf("updateSubmodules: %w", err)
+		}
+	}
+	return nil
+}
+
+// getSubmoduleStatuses returns the state of each pinned submodule, sorted by path.
+func getSubmoduleStatuses() ([]submoduleStatus, error) {
+	pinned, err := getPinnedSubmodules()
+	if err != nil {
+		return nil, fmt.Errorf("getSubmoduleStatuses: %w", err)
+	}
+	var statuses []submoduleStatus
+	for _, path := range sortedKeys(pinned) {
+		cloned, err := isCloned(path)
+		if err != nil {
+			return nil, fmt.Errorf("getSubmoduleStatuses: %w", err)
+		}
+		if !cloned {
+			statuses = append(statuses, submoduleStatus{'-', pinned[path], path})
+			continue
+		}
+		submoduleHead, err := getSubmoduleHead(path)
+		if err != nil {
+			return nil, fmt.Errorf("getSubmoduleStatuses: %w", err)
+		}
+		state := byte(' ')
+		if submoduleHead != pinned[path] {
+			state = '+'
+		}
+		statuses = append(statuses, submoduleStatus{state, submoduleHead, path})
+	}
+	return statuses, nil
+}
+
+// printSubmoduleStatus prints the state of each pinned submodule.
+func printSubmoduleStatus() error {
+	statuses, err := getSubmoduleStatuses()
+	if err != nil {
+		return fmt.Errorf("printSubmoduleStatus: %w", err)
+	}
+	for _, status := range statuses {
+		log.Println(status)
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSubmodules(t *testing.T) {
+	setupTestRepo(t)
+	srcDir := testTempDir(t)
+	commitInSource := func(contents string) string {
+		t.Helper()
+		var hash string
+		if err := inRepository(srcDir, func() error {
+			if err := writeContents("a.txt", []string{contents}); err != nil {
+				return err
+			}
+			if err := stageFile("a.txt"); err != nil {
+				return err
+			}
+			if err := newCommit("write " + contents); err != nil {
+				return err
+			}
+			var err error
+			hash, err = getHeadCommitHash()
+			return err
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return hash
+	}
+	expectStatuses := func(expected []submoduleStatus) {
+		t.Helper()
+		statuses, err := getSubmoduleStatuses()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(statuses, expected) {
+			t.Fatalf("Incorrect submodule status: want %v, got %v", expected, statuses)
+		}
+	}
+	expectContents := func(expected string) {
+		t.Helper()
+		contents, err := readContentsAsString(filepath.Join("lib", "a.txt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if contents != expected {
+			t.Fatalf("Incorrect submodule contents: want %q, got %q", expected, contents)
+		}
+	}
+
+	if err := inRepository(srcDir, newRepository); err != nil {
+		t.Fatal(err)
+	}
+	v1 := commitInSource("one")
+	if err := addSubmodule(filepath.Join(srcDir, gitletDir), "lib"); err != nil {
+		t.Fatal(err)
+	}
+	expectContents("one")
+	entries, err := getStatusEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []statusEntry{{'A', ' ', submodulesFile}, {'A', ' ', "lib"}}; !reflect.DeepEqual(entries, expected) {
+		t.Fatalf("Incorrect status entries: want %v, got %v", expected, entries)
+	}
+	if err := newCommit("add lib"); err != nil {
+		t.Fatal(err)
+	}
+	added, err := getHeadCommitHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	headCommit, err := getHeadCommit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if headCommit.Submodules["lib"] != v1 {
+		t.Fatalf("Incorrect pinned commit: want %v, got %v", v1, headCommit.Submodules["lib"])
+	}
+	expectStatuses([]submoduleStatus{{' ', v1, "lib"}})
+
+	// move the submodule to a new commit and pin it
+	v2 := commitInSource("two")
+	if err := updateSubmodules(map[string]string{"lib": v2}); err != nil {
+		t.Fatal(err)
+	}
+	expectContents("two")
+	expectStatuses([]submoduleStatus{{'+', v2, "lib"}})
+	if err := stageFile("lib"); err != nil {
+		t.Fatal(err)
+	}
+	if err := newCommit("bump lib"); err != nil {
+		t.Fatal(err)
+	}
+	expectStatuses([]submoduleStatus{{' ', v2, "lib"}})
+
+	// resetting checks out the submodule commit pinned by the target commit
+	if err := resetFile(added); err != nil {
+		t.Fatal(err)
+	}
+	expectContents("one")
+	expectStatuses([]submoduleStatus{{' ', v1, "lib"}})
+
+	if err := repoFS.RemoveAll("lib"); err != nil {
+		t.Fatal(err)
+	}
+	expectStatuses([]submoduleStatus{{'-', v1, "lib"}})
+	if err := updateSubmodules(map[string]string{"lib": v1}); err != nil {
+		t.Fatal(err)
+	}
+	expectContents("one")
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"path/filepath"
+)
+
+// switchBranch checks out an existing branch. Unlike checkout, it never detaches HEAD at
+// another kind of revision and never restores files.
+func switchBranch(branchName string) error {
+	unlock, err := lockRepo("switch")
+	if err != nil {
+		return fmt.Errorf("switchBranch: %w", err)
+	}
+	defer unlock()
+	if _, err := readRef(filepath.Join(branchesDir, branchName)); errors.Is(err, fs.ErrNotExist) {
+		exit("No such branch exists.")
+	} else if err != nil {
+		return fmt.Errorf("switchBranch: %w", err)
+	}
+	if err := checkoutBranch(branchName); err != nil {
+		return fmt.Errorf("switchBranch: %w", err)
+	}
+	return nil
+}
+
+// createAndSwitchBranch creates a branch at the head commit and checks it out. The working
+// directory and the staging area are kept as they are, since the head commit does not change.
+func createAndSwitchBranch(branchName string) error {
+	unlock, err := lockRepo("switch")
+	if err != nil {
+		return fmt.Errorf("createAndSwitchBranch: %w", err)
+	}
+	defer unlock()
+	currentBranch, err := getCurrentBranch()
+	if err != nil {
+		return fmt.Errorf("createAndSwitchBranch: %w", err)
+	}
+	headCommitHash, err := getHeadCommitHash()
+	if err != nil {
+		return fmt.Errorf("createAndSwitchBranch: %w", err)
+	}
+	if err := addBranch(branchName); err != nil {
+		return fmt.Errorf("createAndSwitchBranch: %w", err)
+	}
+	if err := writeRef(headFile, filepath.Join(branchesDir, branchName), "switch"); err != nil {
+		return fmt.Errorf("createAndSwitchBranch: %w", err)
+	}
+	if err := logCheckout(headCommitHash, currentBranch, headCommitHash, branchName); err != nil {
+		return fmt.Errorf("createAndSwitchBranch: %w", err)
+	}
+	log.Printf("Switched to a new branch '%v'.\n", branchName)
+	return nil
+}
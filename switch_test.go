@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestCreateAndSwitchBranch(t *testing.T) {
+	setupTestRepo(t)
+	if err := writeContents("wug.txt", []string{"wug"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stageFile("wug.txt"); err != nil {
+		t.Fatal(err)
+	}
+	headCommitHash, err := getHeadCommitHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := createAndSwitchBranch("feature"); err != nil {
+		t.Fatal(err)
+	}
+	if branch, err := getCurrentBranch(); err != nil || branch != "feature" {
+		t.Errorf("Expected feature to be checked out, got %q, %v", branch, err)
+	}
+	if hash, err := getHeadCommitHash(); err != nil || hash != headCommitHash {
+		t.Errorf("The head commit should not change, got %v, %v", hash, err)
+	}
+	if index, err := readIndex(); err != nil || len(index) != 1 {
+		t.Errorf("Staged changes should be kept, got %v, %v", index, err)
+	}
+
+	if err := newCommit("add wug"); err != nil {
+		t.Fatal(err)
+	}
+	if err := switchBranch("main"); err != nil {
+		t.Fatal(err)
+	}
+	if branch, err := getCurrentBranch(); err != nil || branch != "main" {
+		t.Errorf("Expected main to be checked out, got %q, %v", branch, err)
+	}
+	if hash, err := getHeadCommitHash(); err != nil || hash != headCommitHash {
+		t.Errorf("Expected the head of main, got %v, %v", hash, err)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+// syncDir flushes a directory to disk, so that files renamed into it survive a crash.
+// Directories cannot be opened for syncing on other systems, which persist renames with the
+// file system metadata instead, so there is nothing to do.
+func syncDir(dir string) error {
+	return nil
+}
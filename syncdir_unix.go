@@ -0,0 +1,20 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+)
+
+// syncDir flushes a directory to disk, so that files renamed into it survive a crash.
+func syncDir(dir string) error {
+	d, err := repoFS.Open(dir)
+	if err != nil {
+		return fmt.Errorf("syncDir: %w", err)
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("syncDir: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var tagsDir = filepath.Join(refsDir, "tags")
+
+// tag is an annotated tag object, naming a commit with a message and who tagged it.
+type tag struct {
+	Object    string // Hash of the tagged commit.
+	Name      string // Name of the tag when it was created.
+	Tagger    string // Who created the tag, as "Name <email>".
+	Message   string // User supplied tag message.
+	Timestamp int64  // When the tag was created in UNIX time in UTC.
+}
+
+func (t *tag) String() string {
+	return fmt.Sprintf(
+		"tag %v\n"+
+			"Tagger: %v\n"+
+			"Date: %v\n"+
+			"%v\n",
+		t.Name,
+		t.Tagger,
+		time.Unix(t.Timestamp, 0).Local().Format("Mon Jan 02 15:04:05 2006 -0700"),
+		t.Message,
+	)
+}
+
+// writeTagBlob writes a tag object and returns its hash.
+func writeTagBlob(t tag) (string, error) {
+	b, err := serialize(t)
+	if err != nil {
+		return "", err
+	}
+	return writeBlob("tag", b)
+}
+
+// getTag returns the tag object with the given hash.
+// Returns an error if the object is not a tag.
+func getTag(hash string) (tag, error) {
+	header, contents, err := readBlob(hash)
+	if err != nil {
+		return tag{}, fmt.Errorf("getTag: %w", err)
+	}
+	if header != "tag" {
+		return tag{}, fmt.Errorf("getTag: incorrect blob header, want 'tag', got '%v'", header)
+	}
+	t, err := deserialize[tag](contents)
+	if err != nil {
+		return tag{}, fmt.Errorf("getTag: %w", err)
+	}
+	return t, nil
+}
+
+// Roles of the identities recorded in commits. A role names the environment variables that
+// override the configured identity, such as GITLET_AUTHOR_NAME and GITLET_AUTHOR_EMAIL.
+const (
+	authorRole    string = "AUTHOR"    // Who wrote the changes.
+	committerRole string = "COMMITTER" // Who recorded the commit, or created the tag.
+)
+
+// errUnknownIdentity is returned by getIdentity when no name is configured for a role.
+var errUnknownIdentity = errors.New("no user.name is configured")
+
+// getIdentity returns the identity recorded in new commits and tags for a role, as
+// "name <email>": the name and email from the GITLET_<role>_NAME and GITLET_<role>_EMAIL
+// environment variables if set, or else the configured user.name and user.email, falling
+// back to the name of the user running gitlet. The email is left out if none is known.
+// Returns errUnknownIdentity if no name is known.
+func getIdentity(role string) (string, error) {
+	name := os.Getenv("GITLET_" + role + "_NAME")
+	if name == "" {
+		configName, ok, err := getConfig("user.name")
+		if err != nil {
+			return "", fmt.Errorf("getIdentity: %w", err)
+		}
+		if name = configName; !ok {
+			name = os.Getenv("USER")
+		}
+	}
+	if name == "" {
+		return "", fmt.Errorf("getIdentity: %w", errUnknownIdentity)
+	}
+	email := os.Getenv("GITLET_" + role + "_EMAIL")
+	if email == "" {
+		configEmail, ok, err := getConfig("user.email")
+		if err != nil {
+			return "", fmt.Errorf("getIdentity: %w", err)
+		}
+		if !ok {
+			return name, nil
+		}
+		email = configEmail
+	}
+	return fmt.Sprintf("%v <%v>", name, email), nil
+}
+
+// getTags returns the object each tag points to by tag name: a tag object for annotated
+// tags, or a commit for lightweight tags.
+func getTags() (map[string]string, error) {
+	tags := make(map[string]string)
+	names, err := getRefNames(tagsDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return tags, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("getTags: %w", err)
+	}
+	for _, name := range names {
+		hash, err := readRef(filepath.Join(tagsDir, name))
+		if err != nil {
+			return nil, fmt.Errorf("getTags: %w", err)
+		}
+		tags[name] = hash
+	}
+	return tags, nil
+}
+
+// peelTag returns the commit an object names: the tagged commit of a tag object,
+// or the object itself otherwise.
+func peelTag(hash string) (string, error) {
+	header, err := parseBlobHeader(hash)
+	if err != nil {
+		return "", fmt.Errorf("peelTag: %w", err)
+	}
+	if header != "tag" {
+		return hash, nil
+	}
+	t, err := getTag(hash)
+	if err != nil {
+		return "", fmt.Errorf("peelTag: %w", err)
+	}
+	return t.Object, nil
+}
+
+// createTag tags the commit named by a revision. Annotated tags point to a new tag object
+// recording the message, tagger, and time; lightweight tags point to the commit itself.
+func createTag(name string, rev string, message string, annotate bool) error {
+	if name == "" || strings.HasPrefix(name, ".") || strings.HasSuffix(name, lockFileSuffix) ||
+		strings.ContainsAny(name, "/\\ \t\n@{}") {
+		exit("Invalid tag name.")
+	}
+	tagFile := filepath.Join(tagsDir, name)
+	if err := repoFS.MkdirAll(tagsDir, 0755); err != nil {
+		return fmt.Errorf("createTag: %w", err)
+	}
+	unlock, err := lockFile(tagFile, "tag")
+	if err != nil {
+		return fmt.Errorf("createTag: %w", err)
+	}
+	defer unlock()
+	if _, err := readRef(tagFile); err == nil {
+		exit("A tag with that name already exists.")
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("createTag: %w", err)
+	}
+	commitHash, err := resolveRevision(rev)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			exit("No commit with that id exists.")
+		}
+		return fmt.Errorf("createTag: %w", err)
+	}
+
+	target := commitHash
+	if annotate {
+		if message == "" {
+			exit("Please enter a tag message.")
+		}
+		tagger, err := getIdentity(committerRole)
+		if err != nil {
+			return fmt.Errorf("createTag: %w", err)
+		}
+		t := tag{commitHash, name, tagger, message, time.Now().UTC().Unix()}
+		if target, err = writeTagBlob(t); err != nil {
+			return fmt.Errorf("createTag: %w", err)
+		}
+	}
+	if err := writeRef(tagFile, target, "tag"); err != nil {
+		return fmt.Errorf("createTag: %w", err)
+	}
+	return nil
+}
+
+// printTags prints the name of every tag.
+func printTags() error {
+	tags, err := getTags()
+	if err != nil {
+		return fmt.Errorf("printTags: %w", err)
+	}
+	for _, name := range sortedKeys(tags) {
+		log.Println(name)
+	}
+	return nil
+}
+
+// showRevision prints the commit named by a revision and its changes from its first parent,
+// preceded by the annotation if the revision is an annotated tag.
+func showRevision(rev string) error {
+	if tags, err := getTags(); err != nil {
+		return fmt.Errorf("showRevision: %w", err)
+	} else if hash, ok := tags[rev]; ok {
+		header, err := parseBlobHeader(hash)
+		if err != nil {
+			return fmt.Errorf("showRevision: %w", err)
+		}
+		if header == "tag" {
+			t, err := getTag(hash)
+			if err != nil {
+				return fmt.Errorf("showRevision: %w", err)
+			}
+			log.Println(t.String())
+		}
+	}
+	commitHash, err := resolveRevision(rev)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			exit("No commit with that id exists.")
+		}
+		return fmt.Errorf("showRevision: %w", err)
+	}
+	c, err := getCommit(commitHash)
+	if err != nil {
+		return fmt.Errorf("showRevision: %w", err)
+	}
+	note, err := formatNote(commitHash)
+	if err != nil {
+		return fmt.Errorf("showRevision: %w", err)
+	}
+	log.Println(c.String(commitHash) + note)
+	if c.ParentUIDs[0] != "" {
+		diffs, err := diffCommits(c.ParentUIDs[0], commitHash)
+		if err != nil {
+			return fmt.Errorf("showRevision: %w", err)
+		}
+		printDiffs(diffs)
+	}
+	return nil
+}
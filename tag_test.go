@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestCreateTag(t *testing.T) {
+	setupTestRepo(t)
+	if err := setConfig("user.name", "Wug"); err != nil {
+		t.Fatal(err)
+	}
+	if err := setConfig("user.email", "wug@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := createTag("v0", "HEAD", "", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeContents("wug.txt", []string{"wug"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stageFile("wug.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := newCommit("add wug"); err != nil {
+		t.Fatal(err)
+	}
+	headCommitHash, err := getHeadCommitHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := createTag("v1", "HEAD", "first release", true); err != nil {
+		t.Fatal(err)
+	}
+
+	tags, err := getTags()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tags["v0"] != initialCommitHash {
+		t.Errorf("Lightweight tag should point to the commit: want %v, got %v", initialCommitHash, tags["v0"])
+	}
+	annotation, err := getTag(tags["v1"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if annotation.Object != headCommitHash || annotation.Name != "v1" ||
+		annotation.Tagger != "Wug <wug@example.com>" || annotation.Message != "first release" {
+		t.Errorf("Incorrect annotated tag: %+v", annotation)
+	}
+
+	for rev, expected := range map[string]string{"v0": initialCommitHash, "v1": headCommitHash} {
+		hash, err := resolveRevision(rev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hash != expected {
+			t.Errorf("resolveRevision(%q): want %v, got %v", rev, expected, hash)
+		}
+	}
+
+	// tag objects are neither commits nor orphaned file blobs
+	problems, err := checkOrphanedBlobs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 0 {
+		t.Errorf("Tag object should not be reported as orphaned: %v", problems[0].Description)
+	}
+}
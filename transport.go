@@ -0,0 +1,361 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Repositories can be served to remotes by gitlet daemon over TCP, or by gitlet serve-http.
+// Both transports carry the same requests and responses, encoded as JSON, and are answered
+// by handleRemoteRequest.
+const (
+	maxRemoteMessageSize uint32 = 1 << 30
+	serverInternalError  string = "internal error"
+)
+
+// Operations a remote request can ask for.
+const (
+	headsOp      = "heads"       // Return the head commit of every branch.
+	fetchOp      = "fetch"       // Return the objects reachable from Want that are not in Have.
+	pushOp       = "push"        // Store Objects and move Branch from Old to New.
+	mediaFetchOp = "media-fetch" // Return the stored media among the SHA-256 hashes in Want.
+	mediaPushOp  = "media-push"  // Add Media to the media store.
+)
+
+// remoteRequest is a request sent to a served repository.
+type remoteRequest struct {
+	Op      string
+	Repo    string            // Path of the repository under the served directory.
+	Want    []string          `json:",omitempty"` // Commits to fetch.
+	Have    []string          `json:",omitempty"` // Objects the client already has.
+	Branch  string            `json:",omitempty"` // Branch to push.
+	Old     string            `json:",omitempty"` // Head of the branch the push expects, empty if it must not exist.
+	New     string            `json:",omitempty"` // Head of the branch after the push.
+	Objects map[string][]byte `json:",omitempty"` // Pushed object payloads by hash.
+	Media   map[string][]byte `json:",omitempty"` // Pushed media by SHA-256 hash.
+}
+
+// remoteResponse is the answer of a served repository to a request.
+type remoteResponse struct {
+	Error   string            `json:",omitempty"` // Why the request was refused, empty if it succeeded.
+	Heads   map[string]string `json:",omitempty"` // Branch heads by branch name.
+	Head    string            `json:",omitempty"` // Branch HEAD is on, empty if detached.
+	Config  map[string]string `json:",omitempty"` // Settings a clone copies, by key.
+	Objects map[string][]byte `json:",omitempty"` // Fetched object payloads by hash.
+	Media   map[string][]byte `json:",omitempty"` // Fetched media by SHA-256 hash.
+}
+
+// storeObjects writes the given object payloads that the current repository does not have.
+// Returns an error if a payload does not match its hash.
+func storeObjects(payloads map[string][]byte) error {
+	for _, hash := range sortedKeys(payloads) {
+		if actual, err := getHash([][]byte{payloads[hash]}); err != nil {
+			return fmt.Errorf("storeObjects: %w", err)
+		} else if actual != hash {
+			return fmt.Errorf("storeObjects: %w", &corruptObjectError{hash, "received contents do not match the hash"})
+		}
+		if ok, err := hasObject(hash); err != nil {
+			return fmt.Errorf("storeObjects: %w", err)
+		} else if ok {
+			continue
+		}
+		if err := writeLooseObject(hash, [][]byte{payloads[hash]}); err != nil {
+			return fmt.Errorf("storeObjects: %w", err)
+		}
+	}
+	return nil
+}
+
+// collectObjectPayloads returns the payloads collectObjects sends, by hash, as a request or
+// response carries them.
+func collectObjectPayloads(commitHashes []string, have map[string]bool) (map[string][]byte, error) {
+	payloads := make(map[string][]byte)
+	if err := collectObjects(commitHashes, have, func(hash string, payload []byte) error {
+		payloads[hash] = payload
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("collectObjectPayloads: %w", err)
+	}
+	return payloads, nil
+}
+
+// isServerURL reports whether a remote URL names a served repository rather than a path.
+func isServerURL(url string) bool {
+	return isDaemonURL(url) || isHTTPURL(url)
+}
+
+// requestServer sends a request to the served repository named by a URL and returns the
+// response. Exits if the server refuses the request.
+func requestServer(url string, req remoteRequest) (remoteResponse, error) {
+	var resp remoteResponse
+	var err error
+	if isDaemonURL(url) {
+		resp, err = requestDaemon(url, req)
+	} else {
+		resp, err = requestHTTP(url, req)
+	}
+	if err != nil {
+		return remoteResponse{}, fmt.Errorf("requestServer: %w", err)
+	}
+	if resp.Error != "" {
+		exitf("Remote refused the request: %v.", resp.Error)
+	}
+	return resp, nil
+}
+
+// getServerHeads returns the head commit of every branch of a served repository, by branch
+// name.
+func getServerHeads(url string) (map[string]string, error) {
+	resp, err := requestServer(url, remoteRequest{Op: headsOp})
+	if err != nil {
+		return nil, fmt.Errorf("getServerHeads: %w", err)
+	}
+	return resp.Heads, nil
+}
+
+// fetchFromServer copies the objects reachable from a commit in a served repository that the
+// current repository does not have yet.
+func fetchFromServer(url string, commitHash string) error {
+	have, err := getObjectHashes()
+	if err != nil {
+		return fmt.Errorf("fetchFromServer: %w", err)
+	}
+	resp, err := requestServer(url, remoteRequest{Op: fetchOp, Want: []string{commitHash}, Have: have})
+	if err != nil {
+		return fmt.Errorf("fetchFromServer: %w", err)
+	}
+	if err := storeObjects(resp.Objects); err != nil {
+		return fmt.Errorf("fetchFromServer: %w", err)
+	}
+	return nil
+}
+
+// pushToServer sends the objects reachable from a new head commit to a served repository,
+// and moves a branch of the repository from its old head, empty if it does not exist, to the
+// new head. Objects reachable from the branches of the served repository are not sent.
+func pushToServer(url string, branchName string, oldHash string, newHash string) error {
+	heads, err := getServerHeads(url)
+	if err != nil {
+		return fmt.Errorf("pushToServer: %w", err)
+	}
+	have := make(map[string]bool)
+	for _, hash := range heads {
+		if ok, err := hasObject(hash); err != nil {
+			return fmt.Errorf("pushToServer: %w", err)
+		} else if ok {
+			have[hash] = true
+		}
+	}
+	payloads, err := collectObjectPayloads([]string{newHash}, have)
+	if err != nil {
+		return fmt.Errorf("pushToServer: %w", err)
+	}
+	// media are sent first so the branch never names a large file the server does not have
+	oids, err := getMediaOIDs(sortedKeys(payloads))
+	if err != nil {
+		return fmt.Errorf("pushToServer: %w", err)
+	}
+	if len(oids) > 0 {
+		media, err := collectMedia(oids)
+		if err != nil {
+			return fmt.Errorf("pushToServer: %w", err)
+		}
+		if _, err := requestServer(url, remoteRequest{Op: mediaPushOp, Media: media}); err != nil {
+			return fmt.Errorf("pushToServer: %w", err)
+		}
+	}
+	req := remoteRequest{Op: pushOp, Branch: branchName, Old: oldHash, New: newHash, Objects: payloads}
+	if _, err := requestServer(url, req); err != nil {
+		return fmt.Errorf("pushToServer: %w", err)
+	}
+	return nil
+}
+
+// serverMu serializes the requests handled by a server, since each one changes the
+// working directory of the process to the repository it reads or updates.
+var serverMu sync.Mutex
+
+// handleRemoteRequest answers a request for a repository in baseDir. Failures other than
+// refusing the request are logged, and reported to the client without details.
+func handleRemoteRequest(baseDir string, allowPush bool, req remoteRequest) remoteResponse {
+	serverMu.Lock()
+	defer serverMu.Unlock()
+	// the path is cleaned as if absolute so it cannot name a directory outside baseDir
+	dir := filepath.Join(baseDir, filepath.FromSlash(path.Clean("/"+req.Repo)))
+	repoGitletDir, err := findGitletDir(dir)
+	if err != nil {
+		return remoteResponse{Error: fmt.Sprintf("no repository at '%v'", req.Repo)}
+	}
+	var resp remoteResponse
+	if err := inRepository(filepath.Dir(repoGitletDir), func() error {
+		var err error
+		switch req.Op {
+		case headsOp:
+			resp, err = answerHeads()
+		case fetchOp:
+			resp, err = answerFetch(req)
+		case pushOp, mediaPushOp:
+			if !allowPush {
+				resp.Error = "pushing is disabled"
+			} else if req.Op == pushOp {
+				resp, err = answerPush(req)
+			} else {
+				resp, err = answerMediaPush(req)
+			}
+		case mediaFetchOp:
+			resp, err = answerMediaFetch(req)
+		default:
+			resp.Error = fmt.Sprintf("unknown operation '%v'", req.Op)
+		}
+		return err
+	}); err != nil {
+		log.Printf("%v %v: %v\n", req.Op, req.Repo, err)
+		return remoteResponse{Error: serverInternalError}
+	}
+	return resp
+}
+
+// hasCommit reports whether a string sent by a client is the hash of a commit in the current
+// repository.
+func hasCommit(hash string) (bool, error) {
+	if !isHash(hash) {
+		return false, nil
+	}
+	if ok, err := hasObject(hash); err != nil || !ok {
+		return false, err
+	}
+	header, err := parseBlobHeader(hash)
+	if err != nil {
+		return false, fmt.Errorf("hasCommit: %w", err)
+	}
+	return header == "commit", nil
+}
+
+// answerHeads returns the branch heads of the current repository, the branch HEAD is on, and
+// the settings a clone copies.
+func answerHeads() (remoteResponse, error) {
+	var resp remoteResponse
+	var err error
+	if resp.Heads, err = getBranchHeads(); err != nil {
+		return remoteResponse{}, fmt.Errorf("answerHeads: %w", err)
+	}
+	if resp.Head, err = getCurrentBranch(); err != nil {
+		return remoteResponse{}, fmt.Errorf("answerHeads: %w", err)
+	}
+	if resp.Config, err = getCloneConfig(); err != nil {
+		return remoteResponse{}, fmt.Errorf("answerHeads: %w", err)
+	}
+	return resp, nil
+}
+
+// isBranchName reports whether a name sent by a client or server can name a branch, which
+// is stored as a file directly in the branches directory.
+func isBranchName(name string) bool {
+	return name != "" && name == filepath.Base(name) && !strings.HasPrefix(name, ".")
+}
+
+// answerFetch returns the objects of the current repository reachable from the wanted
+// commits that the client does not have.
+func answerFetch(req remoteRequest) (remoteResponse, error) {
+	for _, hash := range req.Want {
+		if ok, err := hasCommit(hash); err != nil {
+			return remoteResponse{}, fmt.Errorf("answerFetch: %w", err)
+		} else if !ok {
+			return remoteResponse{Error: fmt.Sprintf("no commit %v", hash)}, nil
+		}
+	}
+	have := make(map[string]bool, len(req.Have))
+	for _, hash := range req.Have {
+		have[hash] = true
+	}
+	payloads, err := collectObjectPayloads(req.Want, have)
+	if err != nil {
+		return remoteResponse{}, fmt.Errorf("answerFetch: %w", err)
+	}
+	return remoteResponse{Objects: payloads}, nil
+}
+
+// answerPush stores the pushed objects in the current repository and fast-forwards the
+// pushed branch, if it still points to the commit the client expects.
+func answerPush(req remoteRequest) (remoteResponse, error) {
+	if !isBranchName(req.Branch) {
+		return remoteResponse{Error: fmt.Sprintf("invalid branch name '%v'", req.Branch)}, nil
+	}
+	unlock, err := lockRepo("push")
+	if err != nil {
+		return remoteResponse{}, fmt.Errorf("answerPush: %w", err)
+	}
+	defer unlock()
+	heads, err := getBranchHeads()
+	if err != nil {
+		return remoteResponse{}, fmt.Errorf("answerPush: %w", err)
+	}
+	if heads[req.Branch] != req.Old {
+		return remoteResponse{Error: fmt.Sprintf("branch '%v' has changed since it was read", req.Branch)}, nil
+	}
+	var corrupt *corruptObjectError
+	if err := storeObjects(req.Objects); errors.As(err, &corrupt) {
+		return remoteResponse{Error: corrupt.Error()}, nil
+	} else if err != nil {
+		return remoteResponse{}, fmt.Errorf("answerPush: %w", err)
+	}
+	if ok, err := hasCommit(req.New); err != nil {
+		return remoteResponse{}, fmt.Errorf("answerPush: %w", err)
+	} else if !ok {
+		return remoteResponse{Error: fmt.Sprintf("no commit %v", req.New)}, nil
+	}
+	if req.Old != "" {
+		ancestors, err := getAncestors(req.New)
+		if err != nil {
+			return remoteResponse{}, fmt.Errorf("answerPush: %w", err)
+		}
+		if !ancestors[req.Old] {
+			return remoteResponse{Error: "not a fast-forward"}, nil
+		}
+	}
+	if err := writeRef(filepath.Join(branchesDir, req.Branch), req.New, "push"); err != nil {
+		return remoteResponse{}, fmt.Errorf("answerPush: %w", err)
+	}
+	if err := logRefUpdate(req.Branch, req.Old, req.New, "push"); err != nil {
+		return remoteResponse{}, fmt.Errorf("answerPush: %w", err)
+	}
+	return remoteResponse{}, nil
+}
+
+// answerMediaFetch returns the media of the current repository's media store among those the
+// client wants.
+func answerMediaFetch(req remoteRequest) (remoteResponse, error) {
+	media := make(map[string][]byte)
+	for _, oid := range req.Want {
+		if !isMediaOID(oid) {
+			return remoteResponse{Error: fmt.Sprintf("invalid media hash '%v'", oid)}, nil
+		}
+		contents, err := readFile(mediaPath(gitletDir, oid))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return remoteResponse{}, fmt.Errorf("answerMediaFetch: %w", err)
+		}
+		media[oid] = contents
+	}
+	return remoteResponse{Media: media}, nil
+}
+
+// answerMediaPush adds the pushed media to the current repository's media store.
+func answerMediaPush(req remoteRequest) (remoteResponse, error) {
+	for _, oid := range sortedKeys(req.Media) {
+		if verifyMedia(oid, req.Media[oid]) != nil {
+			return remoteResponse{Error: fmt.Sprintf("media %v does not match its hash", oid)}, nil
+		}
+	}
+	if err := storeMedia(req.Media); err != nil {
+		return remoteResponse{}, fmt.Errorf("answerMediaPush: %w", err)
+	}
+	return remoteResponse{}, nil
+}
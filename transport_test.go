@@ -0,0 +1,47 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestHandleRemoteRequest(t *testing.T) {
+	setupTestFS(t)
+	remoteDir := setupRemoteRepo(t)
+	baseDir := filepath.Dir(remoteDir)
+	repo := filepath.Base(remoteDir)
+	var headHash string
+	if err := inRepository(remoteDir, func() error {
+		var err error
+		headHash, err = getHeadCommitHash()
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		allowPush bool
+		req       remoteRequest
+	}{
+		{"outside base", true, remoteRequest{Op: headsOp, Repo: "../.."}},
+		{"unknown operation", true, remoteRequest{Op: "delete", Repo: repo}},
+		{"push disabled", false, remoteRequest{Op: pushOp, Repo: repo, Branch: "main", Old: headHash, New: headHash}},
+		{"invalid branch", true, remoteRequest{Op: pushOp, Repo: repo, Branch: "../HEAD", New: headHash}},
+		{"stale branch", true, remoteRequest{Op: pushOp, Repo: repo, Branch: "main", New: headHash}},
+		{"missing commit", true, remoteRequest{Op: fetchOp, Repo: repo, Want: []string{"../../HEAD"}}},
+		{"corrupt object", true, remoteRequest{
+			Op: pushOp, Repo: repo, Branch: "feature", New: headHash,
+			Objects: map[string][]byte{initialCommitHash: []byte("not a commit")},
+		}},
+	}
+	for _, test := range tests {
+		if resp := handleRemoteRequest(baseDir, test.allowPush, test.req); resp.Error == "" {
+			t.Errorf("Request should be refused: %v", test.name)
+		}
+	}
+	resp := handleRemoteRequest(baseDir, false, remoteRequest{Op: headsOp, Repo: repo})
+	if resp.Error != "" || len(resp.Heads) != 1 || resp.Heads["main"] != headHash {
+		t.Fatalf("Incorrect heads: %v", resp)
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Commits record their files as the tree object of the root directory, which lists the files
+// and subdirectories in it, each subdirectory by a tree object of its own. A directory that
+// did not change keeps its hash, so commits share the trees of unchanged directories and
+// diffs skip them. Tree objects list one "<type> <hash> <name>" entry per file or
+// subdirectory, sorted by name, where type is "blob" for a file or "tree" for a subdirectory.
+// Each entry ends with a NUL byte, which unlike a newline cannot appear in a name. Commits
+// written before trees list every file in FileToBlob instead.
+
+// treeEntry is a file or subdirectory listed by a tree object.
+type treeEntry struct {
+	Type string // "blob" for a file or "tree" for a subdirectory.
+	Hash string
+	Name string
+}
+
+// writeTree writes the tree objects of the directories holding the files in a file to blob
+// mapping, unless they already exist, and returns the hash of the root tree.
+func writeTree(fileToBlob map[string]string) (string, error) {
+	var entries []treeEntry
+	subdirs := make(map[string]map[string]string)
+	for file, blobHash := range fileToBlob {
+		dir, rest, ok := strings.Cut(file, "/")
+		if !ok {
+			entries = append(entries, treeEntry{"blob", blobHash, file})
+			continue
+		}
+		if subdirs[dir] == nil {
+			subdirs[dir] = make(map[string]string)
+		}
+		subdirs[dir][rest] = blobHash
+	}
+	for _, dir := range sortedKeys(subdirs) {
+		hash, err := writeTree(subdirs[dir])
+		if err != nil {
+			return "", fmt.Errorf("writeTree: %w", err)
+		}
+		entries = append(entries, treeEntry{"tree", hash, dir})
+	}
+	slices.SortFunc(entries, func(a, b treeEntry) int { return cmp.Compare(a.Name, b.Name) })
+	var b strings.Builder
+	for _, entry := range entries {
+		fmt.Fprintf(&b, "%v %v %v\x00", entry.Type, entry.Hash, entry.Name)
+	}
+	hash, err := writeBlob("tree", []byte(b.String()))
+	if err != nil {
+		return "", fmt.Errorf("writeTree: %w", err)
+	}
+	return hash, nil
+}
+
+// parseTree parses the contents of a tree object.
+func parseTree(b []byte) ([]treeEntry, error) {
+	var entries []treeEntry
+	for _, line := range strings.SplitAfter(string(b), "\x00") {
+		if line == "" {
+			continue
+		}
+		fields := strings.SplitN(strings.TrimSuffix(line, "\x00"), " ", 3)
+		if len(fields) != 3 || !strings.HasSuffix(line, "\x00") || (fields[0] != "blob" && fields[0] != "tree") ||
+			!isHash(fields[1]) || fields[2] == "" || strings.Contains(fields[2], "/") {
+			return nil, fmt.Errorf("parseTree: malformed entry %q", line)
+		}
+		entries = append(entries, treeEntry{fields[0], fields[1], fields[2]})
+	}
+	return entries, nil
+}
+
+// readTreeEntries returns the entries of a tree object.
+func readTreeEntries(hash string) ([]treeEntry, error) {
+	obj, err := loadObject(hash)
+	if err != nil {
+		return nil, fmt.Errorf("readTreeEntries: %w", err)
+	}
+	if obj.header != "tree" {
+		return nil, &corruptObjectError{hash, fmt.Sprintf("want 'tree' object, got '%v'", obj.header)}
+	}
+	entries, err := parseTree(obj.contents)
+	if err != nil {
+		return nil, &corruptObjectError{hash, err.Error()}
+	}
+	return entries, nil
+}
+
+// readTree returns the file to blob mapping of the files under a tree.
+func readTree(hash string) (map[string]string, error) {
+	fileToBlob := make(map[string]string)
+	if err := addTreeFiles(fileToBlob, hash, ""); err != nil {
+		return nil, fmt.Errorf("readTree: %w", err)
+	}
+	return fileToBlob, nil
+}
+
+// addTreeFiles adds the files under a tree to a file to blob mapping, with their paths
+// prefixed by the path of the tree.
+func addTreeFiles(fileToBlob map[string]string, hash string, prefix string) error {
+	entries, err := readTreeEntries(hash)
+	if err != nil {
+		return fmt.Errorf("addTreeFiles: %w", err)
+	}
+	for _, entry := range entries {
+		if entry.Type == "blob" {
+			fileToBlob[prefix+entry.Name] = entry.Hash
+		} else if err := addTreeFiles(fileToBlob, entry.Hash, prefix+entry.Name+"/"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getSubtreeHash returns the hash of the tree of a directory under a tree, given as a
+// slash-separated path, reading only the trees on the path to it. Returns an empty hash if
+// there is no such directory.
+func getSubtreeHash(hash string, dir string) (string, error) {
+	for _, name := range strings.Split(dir, "/") {
+		entries, err := readTreeEntries(hash)
+		if err != nil {
+			return "", fmt.Errorf("getSubtreeHash: %w", err)
+		}
+		i := slices.IndexFunc(entries, func(entry treeEntry) bool { return entry.Name == name })
+		if i < 0 || entries[i].Type != "tree" {
+			return "", nil
+		}
+		hash = entries[i].Hash
+	}
+	return hash, nil
+}
+
+// getCommitFiles returns the file to blob mapping of a commit, reading its tree if the
+// commit was loaded by getCommitMetadata.
+func getCommitFiles(c commit) (map[string]string, error) {
+	if c.Tree == "" || c.FileToBlob != nil {
+		return c.FileToBlob, nil
+	}
+	fileToBlob, err := readTree(c.Tree)
+	if err != nil {
+		return nil, fmt.Errorf("getCommitFiles: %w", err)
+	}
+	return fileToBlob, nil
+}
+
+// getTreeObjects returns the hashes of a tree and the trees of its subdirectories. Trees for
+// which skip reports true are left out along with their subdirectories.
+func getTreeObjects(hash string, skip func(hash string) bool) ([]string, error) {
+	var hashes []string
+	queue := []string{hash}
+	seen := make(map[string]bool)
+	for len(queue) > 0 {
+		hash := queue[0]
+		queue = queue[1:]
+		if seen[hash] || skip(hash) {
+			continue
+		}
+		seen[hash] = true
+		hashes = append(hashes, hash)
+		entries, err := readTreeEntries(hash)
+		if err != nil {
+			return nil, fmt.Errorf("getTreeObjects: %w", err)
+		}
+		for _, entry := range entries {
+			if entry.Type == "tree" {
+				queue = append(queue, entry.Hash)
+			}
+		}
+	}
+	return hashes, nil
+}
+
+// diffCommitFiles returns the files added, deleted, or modified going from one commit to
+// another, sorted by file. Directories whose trees are the same in both are skipped.
+func diffCommitFiles(from commit, to commit) ([]fileChange, error) {
+	if from.Tree == "" || to.Tree == "" {
+		fromFiles, err := getCommitFiles(from)
+		if err != nil {
+			return nil, fmt.Errorf("diffCommitFiles: %w", err)
+		}
+		toFiles, err := getCommitFiles(to)
+		if err != nil {
+			return nil, fmt.Errorf("diffCommitFiles: %w", err)
+		}
+		return diffFileToBlob(fromFiles, toFiles), nil
+	}
+	var changes []fileChange
+	if err := diffTrees(&changes, from.Tree, to.Tree, ""); err != nil {
+		return nil, fmt.Errorf("diffCommitFiles: %w", err)
+	}
+	slices.SortFunc(changes, func(a, b fileChange) int { return cmp.Compare(a.File, b.File) })
+	return changes, nil
+}
+
+// diffTrees adds the files that differ between two trees to changes, with their paths
+// prefixed by the path of the trees.
+func diffTrees(changes *[]fileChange, fromHash string, toHash string, prefix string) error {
+	if fromHash == toHash {
+		return nil
+	}
+	fromEntries, err := readTreeEntries(fromHash)
+	if err != nil {
+		return fmt.Errorf("diffTrees: %w", err)
+	}
+	toEntries, err := readTreeEntries(toHash)
+	if err != nil {
+		return fmt.Errorf("diffTrees: %w", err)
+	}
+	// every file under an entry on only one side was added or deleted
+	addAll := func(status byte, entry treeEntry) error {
+		if entry.Type == "blob" {
+			*changes = append(*changes, fileChange{status, prefix + entry.Name})
+			return nil
+		}
+		files, err := readTree(entry.Hash)
+		if err != nil {
+			return err
+		}
+		for file := range files {
+			*changes = append(*changes, fileChange{status, prefix + entry.Name + "/" + file})
+		}
+		return nil
+	}
+	toByName := make(map[string]treeEntry, len(toEntries))
+	for _, entry := range toEntries {
+		toByName[entry.Name] = entry
+	}
+	for _, fromEntry := range fromEntries {
+		toEntry, ok := toByName[fromEntry.Name]
+		delete(toByName, fromEntry.Name)
+		switch {
+		case !ok:
+			err = addAll('D', fromEntry)
+		case fromEntry == toEntry:
+		case fromEntry.Type == "tree" && toEntry.Type == "tree":
+			err = diffTrees(changes, fromEntry.Hash, toEntry.Hash, prefix+fromEntry.Name+"/")
+		case fromEntry.Type == "blob" && toEntry.Type == "blob":
+			*changes = append(*changes, fileChange{'M', prefix + fromEntry.Name})
+		default:
+			// a file replaced by a directory, or the other way around
+			if err = addAll('D', fromEntry); err == nil {
+				err = addAll('A', toEntry)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("diffTrees: %w", err)
+		}
+	}
+	for _, toEntry := range toByName {
+		if err := addAll('A', toEntry); err != nil {
+			return fmt.Errorf("diffTrees: %w", err)
+		}
+	}
+	return nil
+}
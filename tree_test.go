@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestWriteReadTree(t *testing.T) {
+	setupTestRepo(t)
+	fileToBlob := map[string]string{
+		"wug.txt":           strings.Repeat("1", 40),
+		"dir/wug.txt":       strings.Repeat("2", 40),
+		"dir/sub/wug.txt":   strings.Repeat("3", 40),
+		"other/name with":   strings.Repeat("4", 40),
+		"other/new\nline":   strings.Repeat("5", 40),
+		"dir/sub/notwug.go": strings.Repeat("6", 40),
+	}
+	hash, err := writeTree(fileToBlob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read, err := readTree(hash); err != nil || !maps.Equal(read, fileToBlob) {
+		t.Fatalf("Incorrect tree read back: want %v, got %v, %v", fileToBlob, read, err)
+	}
+	trees, err := getTreeObjects(hash, func(string) bool { return false })
+	if err != nil || len(trees) != 4 {
+		t.Fatalf("Incorrect trees: want root, dir, dir/sub, and other, got %v, %v", trees, err)
+	}
+
+	// changing one file rewrites only the trees of the directories holding it
+	fileToBlob["dir/wug.txt"] = strings.Repeat("7", 40)
+	newHash, err := writeTree(fileToBlob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newTrees, err := getTreeObjects(newHash, func(hash string) bool { return slices.Contains(trees, hash) })
+	if err != nil || len(newTrees) != 2 {
+		t.Fatalf("Incorrect new trees: want root and dir, got %v, %v", newTrees, err)
+	}
+}
+
+func TestCommitTree(t *testing.T) {
+	setupTestRepo(t)
+	commitInRepo(t, ".", "wug.txt", "This is a wug")
+	headCommit, err := getHeadCommit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if headCommit.Tree == "" || headCommit.FileToBlob["wug.txt"] == "" {
+		t.Fatalf("Commit should record its files as a tree: %+v", headCommit)
+	}
+	hash, err := getHeadCommitHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, err := loadObject(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored, err := deserialize[commit](obj.contents); err != nil || stored.FileToBlob != nil {
+		t.Fatalf("Commit object should not list its files: %s", obj.contents)
+	}
+}
+
+func TestCommitMetadata(t *testing.T) {
+	setupTestRepo(t)
+	commitInRepo(t, ".", "wug.txt", "This is a wug")
+	headHash := commitInRepo(t, ".", "notwug.txt", "This is not a wug")
+	headCommit, err := getCommit(headHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// walking history does not read trees
+	if err := repoFS.Remove(objectPath(headCommit.Tree)); err != nil {
+		t.Fatal(err)
+	}
+	resetObjectCache()
+	if c, err := getCommitMetadata(headHash); err != nil || c.Message != "add notwug.txt" || c.FileToBlob != nil {
+		t.Fatalf("Incorrect commit metadata: %+v, %v", c, err)
+	}
+	if hashes, err := getLogCommits(headHash, logOptions{}); err != nil || len(hashes) != 3 {
+		t.Fatalf("Log should not read trees: %v, %v", hashes, err)
+	}
+	if _, err := getCommit(headHash); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Reading the files of a commit without its tree should fail: %v", err)
+	}
+}
+
+func TestDiffCommitFiles(t *testing.T) {
+	setupTestRepo(t)
+	from := commit{FileToBlob: map[string]string{
+		"wug.txt":         strings.Repeat("1", 40),
+		"same/wug.txt":    strings.Repeat("2", 40),
+		"changed/wug.txt": strings.Repeat("3", 40),
+		"gone/wug.txt":    strings.Repeat("4", 40),
+		"becomes":         strings.Repeat("5", 40),
+	}}
+	to := commit{FileToBlob: map[string]string{
+		"wug.txt":         strings.Repeat("1", 40),
+		"same/wug.txt":    strings.Repeat("2", 40),
+		"changed/wug.txt": strings.Repeat("6", 40),
+		"new/wug.txt":     strings.Repeat("7", 40),
+		"becomes/dir.txt": strings.Repeat("8", 40),
+	}}
+	expected := diffFileToBlob(from.FileToBlob, to.FileToBlob)
+	for _, c := range []*commit{&from, &to} {
+		var err error
+		if c.Tree, err = writeTree(c.FileToBlob); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if changes, err := diffCommitFiles(from, to); err != nil || !slices.Equal(changes, expected) {
+		t.Fatalf("Incorrect tree diff: want %v, got %v, %v", expected, changes, err)
+	}
+}
@@ -9,10 +9,10 @@ import (
 	"fmt"
 	"io"
 	"io/fs"
-	"log"
 	"os"
 	"path/filepath"
 	"slices"
+	"syscall"
 )
 
 // getHash generates a 40-character SHA1 hash given an array of bytes and strings.
@@ -40,22 +40,18 @@ func getHash[T any](arr []T) (string, error) {
 // restrictedDelete removes a file if the working directory contains a .gitlet directory.
 // This function is used to safely delete user files within a Gitlet repository and
 // should be called from the root directory of the Gitlet repository.
-// Does nothing if file does not exist.
+// Does nothing if file does not exist, including when one of its parents is a file.
 func restrictedDelete(file string) error {
-	wd, err := os.Getwd()
-	if err != nil {
-		return fmt.Errorf("restrictedDelete: %w", err)
-	}
-	_, err = os.Stat(filepath.Join(wd, gitletDir))
+	_, err := repoFS.Stat(gitletDir)
 	if err != nil {
 		if errors.Is(err, fs.ErrNotExist) {
-			log.Fatalf("Not in an initialized Gitlet repository.")
+			exitf("Not in an initialized Gitlet repository.")
 		}
 		return fmt.Errorf("restrictedDelete: %w", err)
 	}
-	fileInfo, err := os.Stat(file)
+	fileInfo, err := repoFS.Stat(file)
 	if err != nil {
-		if errors.Is(err, fs.ErrNotExist) {
+		if errors.Is(err, fs.ErrNotExist) || errors.Is(err, syscall.ENOTDIR) {
 			return nil
 		}
 		return fmt.Errorf("restrictedDelete: %w", err)
@@ -63,7 +59,7 @@ func restrictedDelete(file string) error {
 	if fileInfo.IsDir() {
 		return fmt.Errorf("restrictedDelete: cannot delete directory '%v'", file)
 	}
-	if err := os.Remove(file); err != nil {
+	if err := repoFS.Remove(file); err != nil {
 		return fmt.Errorf("restrictedDelete: %w", err)
 	}
 	return nil
@@ -71,7 +67,7 @@ func restrictedDelete(file string) error {
 
 // readContents returns the contents of a file as bytes.
 func readContents(file string) ([]byte, error) {
-	fileBytes, err := os.ReadFile(file)
+	fileBytes, err := readFile(file)
 	if err != nil {
 		return nil, fmt.Errorf("readContents: %w", err)
 	}
@@ -91,14 +87,14 @@ func readContentsAsString(file string) (string, error) {
 // If the file does not exist, it is created. If the file does exist, it is overwritten.
 // Returns an error if the file is a directory.
 func writeContents[T any](file string, arr []T) error {
-	fileInfo, err := os.Stat(file)
+	fileInfo, err := repoFS.Stat(file)
 	if (err != nil) && !errors.Is(err, fs.ErrNotExist) {
 		return fmt.Errorf("writeContents: %w", err)
 	}
 	if (err == nil) && fileInfo.IsDir() {
 		return fmt.Errorf("writeContents: cannot overwrite directory '%v'", file)
 	}
-	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	f, err := repoFS.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
 	if err != nil {
 		return fmt.Errorf("writeContents: cannot open file '%v': %w", file, err)
 	}
@@ -120,9 +116,29 @@ func writeContents[T any](file string, arr []T) error {
 	return f.Close()
 }
 
+// writeStream writes everything read from r to a file, creating or truncating it.
+func writeStream(file string, r io.Reader) error {
+	fileInfo, err := repoFS.Stat(file)
+	if (err != nil) && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("writeStream: %w", err)
+	}
+	if (err == nil) && fileInfo.IsDir() {
+		return fmt.Errorf("writeStream: cannot overwrite directory '%v'", file)
+	}
+	f, err := repoFS.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("writeStream: cannot open file '%v': %w", file, err)
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, r); err != nil {
+		return fmt.Errorf("writeStream: cannot write file '%v': %w", file, err)
+	}
+	return f.Close()
+}
+
 // getFilenames returns a sorted list of filenames in the directory.
 func getFilenames(dir string) ([]string, error) {
-	files, err := os.ReadDir(dir)
+	files, err := repoFS.ReadDir(dir)
 	if err != nil {
 		return nil, fmt.Errorf("getFilenames: %w", err)
 	}
@@ -136,6 +152,16 @@ func getFilenames(dir string) ([]string, error) {
 	return filenames, nil
 }
 
+// sortedKeys returns the keys of a map in sorted order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
 // serialize encodes an object as bytes.
 func serialize[T any](obj T) ([]byte, error) {
 	b, err := json.Marshal(obj)
@@ -153,3 +179,36 @@ func deserialize[T any](b []byte) (T, error) {
 	}
 	return obj, nil
 }
+
+// writeFileAtomic replaces the contents of a file by writing a temporary file in the same
+// directory, syncing it to disk, renaming it over the original, and syncing the directory.
+// Readers see either the old or the new contents, never a partially written file.
+func writeFileAtomic(file string, b []byte) error {
+	// the temporary file is hidden so it is never mistaken for a ref or object
+	f, err := repoFS.CreateTemp(filepath.Dir(file), "."+filepath.Base(file)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("writeFileAtomic: %w", err)
+	}
+	defer repoFS.Remove(f.Name())
+	defer f.Close()
+	if _, err := f.Write(b); err != nil {
+		return fmt.Errorf("writeFileAtomic: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("writeFileAtomic: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("writeFileAtomic: %w", err)
+	}
+	if err := repoFS.Chmod(f.Name(), 0644); err != nil {
+		return fmt.Errorf("writeFileAtomic: %w", err)
+	}
+	if err := repoFS.Rename(f.Name(), file); err != nil {
+		return fmt.Errorf("writeFileAtomic: %w", err)
+	}
+	// the rename is only durable once the directory entry pointing at the new file is
+	if err := syncDir(filepath.Dir(file)); err != nil {
+		return fmt.Errorf("writeFileAtomic: %w", err)
+	}
+	return nil
+}
@@ -3,7 +3,7 @@ package main
 import (
 	"bytes"
 	"errors"
-	"os"
+	"log"
 	"path/filepath"
 	"slices"
 	"testing"
@@ -11,37 +11,107 @@ import (
 
 func mkTestDir(t *testing.T, dir string) {
 	t.Helper()
-	err1 := os.Mkdir(dir, 0755)
-	err2 := os.Chmod(dir, 0755)
+	err1 := repoFS.Mkdir(dir, 0755)
+	err2 := repoFS.Chmod(dir, 0755)
 	if err := errors.Join(err1, err2); err != nil {
 		t.FailNow()
 	}
 }
 
-func setupTempDir(t *testing.T) {
+// setupTestFS gives the test an empty working directory in a new memory filesystem, so the
+// test never touches the disk. The previous filesystem is restored when the test ends.
+func setupTestFS(t *testing.T) {
 	t.Helper()
-	if err := os.Chdir(t.TempDir()); err != nil {
-		t.FailNow()
+	resetTestState(t)
+	wdFS := repoFS
+	repoFS = newMemoryFileSystem()
+	t.Cleanup(func() {
+		resetTestState(t)
+		repoFS = wdFS
+	})
+	enterTestDir(t, testTempDir(t))
+}
+
+// setupDiskTestFS gives the test an empty working directory on disk, for tests that run gitlet
+// in subprocesses or exercise the filesystem of the operating system, and returns its path.
+// The previous filesystem is restored when the test ends.
+func setupDiskTestFS(t *testing.T) string {
+	t.Helper()
+	resetTestState(t)
+	wdFS := repoFS
+	dir := t.TempDir()
+	repoFS = osFileSystem{dir}
+	t.Cleanup(func() {
+		resetTestState(t)
+		repoFS = wdFS
+	})
+	return dir
+}
+
+// resetTestState forgets the cached objects and packs of the repository of
+// the previous test.
+func resetTestState(t *testing.T) {
+	t.Helper()
+	// commits record the name of the user running the tests unless a test configures one
+	t.Setenv("USER", "wug")
+	resetObjectCache()
+	if err := closePacks(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// enterTestDir makes dir the working directory of repoFS, like changing into it would.
+func enterTestDir(t *testing.T, dir string) {
+	t.Helper()
+	dirFS, err := repoFS.Sub(dir)
+	if err != nil {
+		t.Fatal(err)
 	}
+	repoFS = dirFS
+}
+
+// captureLog returns a buffer the log package writes to, without timestamps like the command
+// line, until the test ends.
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var b bytes.Buffer
+	writer, flags := log.Writer(), log.Flags()
+	log.SetOutput(&b)
+	log.SetFlags(0)
+	t.Cleanup(func() {
+		log.SetOutput(writer)
+		log.SetFlags(flags)
+	})
+	return &b
+}
+
+// testTempDir returns a new temporary directory of repoFS.
+func testTempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := repoFS.MkdirTemp("", "gitlet-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
 }
 
 func setupTestRepo(t *testing.T) {
 	t.Helper()
-	setupTempDir(t)
+	setupTestFS(t)
 	if err := newRepository(); err != nil {
-		t.FailNow()
+		t.Fatal(err)
 	}
 }
 
 func TestGetFilenames(t *testing.T) {
 	setupTestRepo(t)
-	wd, err := os.Getwd()
+	wd, err := repoFS.Abs(".")
 	if err != nil {
 		t.Error(err)
 	}
 	expected := []string{"bar.js", "foo.go", "wug.txt"}
 	for _, testFile := range expected {
-		if _, err := os.Create(filepath.Join(wd, testFile)); err != nil {
+		if err := writeFile(filepath.Join(wd, testFile), nil, 0644); err != nil {
 			t.Error(err)
 		}
 	}
@@ -93,23 +163,19 @@ func TestRestrictedDeleteFile(t *testing.T) {
 	mkTestDir(t, "foo")
 	mkTestDir(t, filepath.Join("foo", "bar"))
 	testFile := filepath.Join("foo", "bar", "baz.go")
-	f, err := os.Create(testFile)
-	if err != nil {
+	if err := writeFile(testFile, nil, 0644); err != nil {
 		t.Fatalf("Could not create test file: %v", err)
 	}
-	if err := f.Close(); err != nil {
-		t.Fatal(err)
-	}
 	if err := restrictedDelete(testFile); err != nil {
 		t.Fatalf("restrictedDelete('%v') did not occur as expected", testFile)
 	}
 }
 
 func TestReadContentsToBytes(t *testing.T) {
-	setupTempDir(t)
+	setupTestFS(t)
 	testFile := "foo.txt"
 	expected := []byte("Hello, world!")
-	os.WriteFile(testFile, expected, 0644)
+	writeFile(testFile, expected, 0644)
 	actual, err := readContents(testFile)
 	if err != nil {
 		t.Fatalf("Could not read test file: %v", err)
@@ -120,10 +186,10 @@ func TestReadContentsToBytes(t *testing.T) {
 }
 
 func TestReadContentsToString(t *testing.T) {
-	setupTempDir(t)
+	setupTestFS(t)
 	testFile := "foo.txt"
 	expected := []byte("Hello, world!")
-	os.WriteFile(testFile, expected, 0644)
+	writeFile(testFile, expected, 0644)
 	actual, err := readContentsAsString(testFile)
 	if err != nil {
 		t.Fatalf("Could not read test file: %v", err)
@@ -134,14 +200,14 @@ func TestReadContentsToString(t *testing.T) {
 }
 
 func TestWriteContents(t *testing.T) {
-	setupTempDir(t)
+	setupTestFS(t)
 	testFile := "foo.txt"
 	expected := []byte("Hello, world!")
 	err := writeContents[[]byte](testFile, [][]byte{expected})
 	if err != nil {
 		t.Fatalf("Could not write to test file: %v", err)
 	}
-	actual, err := os.ReadFile(testFile)
+	actual, err := readFile(testFile)
 	if err != nil {
 		t.Fatalf("Could not read test file: %v", err)
 	}
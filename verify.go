@@ -0,0 +1,181 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+)
+
+// verifyReport summarizes the objects checked by verifyHistory.
+type verifyReport struct {
+	Commit   string   // Hash of the commit verification started from.
+	Commits  int      // Number of commits verified.
+	Blobs    int      // Number of distinct file blobs verified.
+	Problems []string // Description of every missing or corrupt object.
+	Digest   string   // SHA1 over the sorted hashes of every verified object.
+}
+
+// verifyHistory walks the commit DAG from a commit, recomputing the hash of every commit
+// and every file blob they reference from the stored bytes. Objects that are missing,
+// corrupt, or of the wrong type are recorded as problems rather than stopping the walk.
+func verifyHistory(commitHash string) (verifyReport, error) {
+	report := verifyReport{Commit: commitHash}
+	verified := make(map[string]bool)
+	visited := make(map[string]bool)
+	queue := []string{commitHash}
+	for len(queue) > 0 {
+		hash := queue[0]
+		queue = queue[1:]
+		if visited[hash] {
+			continue
+		}
+		visited[hash] = true
+
+		c, err := verifyCommit(hash)
+		if err != nil {
+			problemHash, role := hash, "commit"
+			var treeErr *treeReadError
+			if errors.As(err, &treeErr) {
+				problemHash, role, err = treeErr.Tree, fmt.Sprintf("tree of commit %v", hash), treeErr.Err
+			}
+			problem, err := describeObjectProblem(problemHash, role, err)
+			if err != nil {
+				return report, fmt.Errorf("verifyHistory: %w", err)
+			}
+			report.Problems = append(report.Problems, problem)
+			continue
+		}
+		report.Commits++
+		verified[hash] = true
+
+		for _, file := range sortedKeys(c.FileToBlob) {
+			blobHash := c.FileToBlob[file]
+			if verified[blobHash] || visited[blobHash] {
+				continue
+			}
+			visited[blobHash] = true
+			if err := verifyBlob(blobHash); err != nil {
+				problem, err := describeObjectProblem(blobHash, fmt.Sprintf("file '%v' of commit %v", file, hash), err)
+				if err != nil {
+					return report, fmt.Errorf("verifyHistory: %w", err)
+				}
+				report.Problems = append(report.Problems, problem)
+				continue
+			}
+			report.Blobs++
+			verified[blobHash] = true
+		}
+
+		for _, parentHash := range c.ParentUIDs {
+			if parentHash != "" {
+				queue = append(queue, parentHash)
+			}
+		}
+	}
+
+	h := sha1.New()
+	for _, hash := range sortedKeys(verified) {
+		io.WriteString(h, hash)
+	}
+	report.Digest = hex.EncodeToString(h.Sum(nil))
+	return report, nil
+}
+
+// treeReadError reports a commit whose tree could not be read.
+type treeReadError struct {
+	Tree string // Hash of the commit's root tree.
+	Err  error
+}
+
+func (e *treeReadError) Error() string {
+	return fmt.Sprintf("cannot read tree %v: %v", e.Tree, e.Err)
+}
+
+func (e *treeReadError) Unwrap() error {
+	return e.Err
+}
+
+// verifyCommit checks that a commit exists, hashes correctly, and decodes as a commit, and
+// that its tree can be read. A tree that cannot be read is reported as a *treeReadError.
+func verifyCommit(hash string) (commit, error) {
+	obj, err := loadObject(hash)
+	if err != nil {
+		return commit{}, fmt.Errorf("verifyCommit: %w", err)
+	}
+	if obj.header != "commit" {
+		return commit{}, &corruptObjectError{hash, fmt.Sprintf("want 'commit' object, got '%v'", obj.header)}
+	}
+	c, err := deserialize[commit](obj.contents)
+	if err != nil {
+		return commit{}, &corruptObjectError{hash, fmt.Sprintf("cannot decode commit: %v", err)}
+	}
+	if c.Tree != "" {
+		if c.FileToBlob, err = readTree(c.Tree); err != nil {
+			return commit{}, &treeReadError{c.Tree, err}
+		}
+	}
+	return c, nil
+}
+
+// verifyBlob checks that a file blob exists, hashes correctly, and is a file blob, a
+// well-formed lfs pointer blob, or a chunked blob whose chunks are intact. The media a
+// pointer names are not checked.
+func verifyBlob(hash string) error {
+	obj, err := openObject(hash)
+	if err != nil {
+		return fmt.Errorf("verifyBlob: %w", err)
+	}
+	defer obj.Close()
+	switch obj.header {
+	case "file":
+	case "lfs":
+		if _, err := parseLFSPointer(obj.contents); err != nil {
+			return &corruptObjectError{hash, fmt.Sprintf("malformed lfs pointer: %v", err)}
+		}
+	case "chunked":
+		if _, err := parseChunkedBlob(obj.contents); err != nil {
+			return &corruptObjectError{hash, fmt.Sprintf("malformed chunk list: %v", err)}
+		}
+		if _, err := readChunks(obj.contents); err != nil {
+			return fmt.Errorf("verifyBlob: %w", err)
+		}
+	default:
+		return &corruptObjectError{hash, fmt.Sprintf("want 'file' object, got '%v'", obj.header)}
+	}
+	return nil
+}
+
+// describeObjectProblem describes why an object failed verification.
+// Errors other than missing or corrupt objects are returned instead.
+func describeObjectProblem(hash string, role string, err error) (string, error) {
+	var corruptErr *corruptObjectError
+	if errors.As(err, &corruptErr) {
+		return fmt.Sprintf("corrupt %v %v: %v", role, hash, corruptErr.Reason), nil
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Sprintf("missing %v %v", role, hash), nil
+	}
+	return "", err
+}
+
+// printVerifyReport prints every problem found by verifyHistory followed by a summary
+// that can be compared against other copies of the repository.
+func printVerifyReport(report verifyReport) {
+	for _, problem := range report.Problems {
+		log.Println(problem)
+	}
+	log.Printf(
+		"Verified %v commits and %v blobs reachable from %v.\n",
+		report.Commits, report.Blobs, report.Commit,
+	)
+	log.Printf("Digest: %v\n", report.Digest)
+	if len(report.Problems) > 0 {
+		log.Printf("Found %v problems.\n", len(report.Problems))
+	} else {
+		log.Println("No problems found.")
+	}
+}
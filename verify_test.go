@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestVerifyHistory(t *testing.T) {
+	setupTestRepo(t)
+	for _, file := range []string{"wug.txt", "notwug.txt"} {
+		if err := writeContents(file, []string{"This is " + file}); err != nil {
+			t.Fatal(err)
+		}
+		if err := stageFile(file); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := newCommit("add files"); err != nil {
+		t.Fatal(err)
+	}
+	headHash, err := resolveRevision("HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash, err := resolveRevision("main"); err != nil || hash != headHash {
+		t.Fatalf("Incorrect branch revision: want %v, got %v, %v", headHash, hash, err)
+	}
+	if hash, err := resolveRevision(headHash[:8]); err != nil || hash != headHash {
+		t.Fatalf("Incorrect abbreviated revision: want %v, got %v, %v", headHash, hash, err)
+	}
+
+	report, err := verifyHistory(headHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Commits != 2 || report.Blobs != 2 || len(report.Problems) != 0 {
+		t.Fatalf("Incorrect report for clean history: %+v", report)
+	}
+
+	// a corrupt blob and a missing blob are both reported
+	headCommit, err := getCommit(headHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	corruptFile := objectPath(headCommit.FileToBlob["wug.txt"])
+	payload, err := readFile(corruptFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload[len(payload)-1] ^= 0xff
+	if err := repoFS.Chmod(corruptFile, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFile(corruptFile, payload, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := repoFS.Remove(objectPath(headCommit.FileToBlob["notwug.txt"])); err != nil {
+		t.Fatal(err)
+	}
+	resetObjectCache()
+
+	damaged, err := verifyHistory(headHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if damaged.Commits != 2 || damaged.Blobs != 0 || len(damaged.Problems) != 2 {
+		t.Fatalf("Incorrect report for damaged history: %+v", damaged)
+	}
+	problems := strings.Join(damaged.Problems, "\n")
+	if !strings.Contains(problems, "corrupt file 'wug.txt'") || !strings.Contains(problems, "missing file 'notwug.txt'") {
+		t.Fatalf("Incorrect problems for damaged history: %v", damaged.Problems)
+	}
+	if damaged.Digest == report.Digest {
+		t.Fatal("Digest did not change after objects were damaged.")
+	}
+}
+
+func TestVerifyHistoryMissingTree(t *testing.T) {
+	setupTestRepo(t)
+	headHash := commitInRepo(t, ".", "wug.txt", "This is a wug")
+	headCommit, err := getCommit(headHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repoFS.Remove(objectPath(headCommit.Tree)); err != nil {
+		t.Fatal(err)
+	}
+	resetObjectCache()
+
+	report, err := verifyHistory(headHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := fmt.Sprintf("missing tree of commit %v %v", headHash, headCommit.Tree)
+	if len(report.Problems) != 1 || report.Problems[0] != expected {
+		t.Fatalf("Incorrect problems for missing tree: want %v, got %v", expected, report.Problems)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"time"
+)
+
+// fileStamp is the state of a watched file that changes whenever its contents do.
+type fileStamp struct {
+	Size    int64
+	ModTime time.Time
+	Exists  bool
+}
+
+// fileWatcher detects changes to the working directory and repository state by polling,
+// which works on every platform and filesystem, including network mounts.
+type fileWatcher struct {
+	interval time.Duration
+	stamps   map[string]fileStamp
+}
+
+// newFileWatcher creates a watcher polling at the given interval, recording the current state
+// so that only later changes are reported.
+func newFileWatcher(interval time.Duration) (*fileWatcher, error) {
+	stamps, err := watchStamps()
+	if err != nil {
+		return nil, fmt.Errorf("newFileWatcher: %w", err)
+	}
+	return &fileWatcher{interval, stamps}, nil
+}
+
+// watchStamps records the state of every file in the working directory within the current
+// scope, the index, HEAD, and the current branch.
+func watchStamps() (map[string]fileStamp, error) {
+	files, err := getWorkingFiles()
+	if err != nil {
+		return nil, fmt.Errorf("watchStamps: %w", err)
+	}
+	files = append(files, indexFile, headFile)
+	if head, err := readRef(headFile); err == nil && !isHash(head) {
+		files = append(files, head)
+	}
+	stamps := make(map[string]fileStamp, len(files))
+	for _, file := range files {
+		info, err := repoFS.Stat(file)
+		if errors.Is(err, fs.ErrNotExist) {
+			stamps[file] = fileStamp{}
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("watchStamps: %w", err)
+		}
+		stamps[file] = fileStamp{info.Size(), info.ModTime(), true}
+	}
+	return stamps, nil
+}
+
+// wait blocks until a watched file changes, or until stop is closed.
+// Reports whether a change was seen.
+func (w *fileWatcher) wait(stop <-chan struct{}) (bool, error) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return false, nil
+		case <-ticker.C:
+		}
+		stamps, err := watchStamps()
+		if err != nil {
+			return false, fmt.Errorf("wait: %w", err)
+		}
+		if !maps.Equal(stamps, w.stamps) {
+			w.stamps = stamps
+			return true, nil
+		}
+	}
+}